@@ -10,6 +10,7 @@ import (
 
 	_ "github.com/TangSengDaoDao/TangSengDaoDaoServer/internal"
 	"github.com/TangSengDaoDao/TangSengDaoDaoServer/modules/base/event"
+	"github.com/TangSengDaoDao/TangSengDaoDaoServer/modules/user"
 	"github.com/TangSengDaoDao/TangSengDaoDaoServerLib/config"
 	"github.com/TangSengDaoDao/TangSengDaoDaoServerLib/module"
 	"github.com/TangSengDaoDao/TangSengDaoDaoServerLib/pkg/log"
@@ -89,6 +90,7 @@ func runAPI(ctx *config.Context) {
 		}
 		gin.Logger()(c)
 	})
+	s.GetRoute().Use(user.SessionTouchMiddleware(ctx)) // 更新登录会话的最后活跃时间，需要放在 api.Route(s.GetRoute())的前面
 	// 模块安装
 	err := module.Setup(ctx)
 	if err != nil {
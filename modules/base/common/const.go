@@ -14,9 +14,32 @@ const (
 	CodeTypeCheckMobile
 	// DestroyAccount 注销账号
 	CodeTypeDestroyAccount
+	// CodeTypeBindEmail 绑定邮箱
+	CodeTypeBindEmail
 )
 
+// Purpose 验证码用途（用于邮件模版）
+func (c CodeType) Purpose() string {
+	switch c {
+	case CodeTypeRegister:
+		return "注册"
+	case CodeTypePayPWD:
+		return "设置支付密码"
+	case CodeTypeForgetLoginPWD:
+		return "重置登录密码"
+	case CodeTypeCheckMobile:
+		return "登录验证"
+	case CodeTypeDestroyAccount:
+		return "注销账号"
+	case CodeTypeBindEmail:
+		return "绑定邮箱"
+	}
+	return "身份验证"
+}
+
 const (
 	// CacheKeySMSCode 短信验证码的缓存key
 	CacheKeySMSCode string = "smscode:"
+	// CacheKeyEmailCode 邮件验证码的缓存key
+	CacheKeyEmailCode string = "emailcode:"
 )
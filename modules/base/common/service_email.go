@@ -0,0 +1,287 @@
+package common
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	htmltemplate "html/template"
+	"mime"
+	"net"
+	"net/mail"
+	"net/smtp"
+	"strconv"
+	"strings"
+	texttemplate "text/template"
+	"time"
+
+	"github.com/TangSengDaoDao/TangSengDaoDaoServerLib/config"
+	"github.com/TangSengDaoDao/TangSengDaoDaoServerLib/pkg/log"
+	"go.uber.org/zap"
+)
+
+const (
+	// EmailSMTPTLSAuto 服务器支持时使用STARTTLS
+	EmailSMTPTLSAuto = 0
+	// EmailSMTPTLSImplicit SSL/TLS直连（一般为465端口）
+	EmailSMTPTLSImplicit = 1
+	// EmailSMTPTLSStartTLS 必须使用STARTTLS（一般为587端口）
+	EmailSMTPTLSStartTLS = 2
+)
+
+const (
+	// DefaultEmailCodeSubject 默认的验证码邮件标题模版
+	DefaultEmailCodeSubject = "{{.Purpose}}验证码"
+	// DefaultEmailCodeTemplate 默认的验证码邮件正文模版
+	DefaultEmailCodeTemplate = `<p>你正在进行{{.Purpose}}操作，验证码为：<b>{{.Code}}</b></p><p>验证码{{.ExpireMinutes}}分钟内有效。如非本人操作，请忽略本邮件。</p>`
+)
+
+const smtpTimeout = time.Second * 30
+
+// ErrEmailServiceOff 邮件服务未开启
+var ErrEmailServiceOff = errors.New("邮件服务未开启！")
+
+// EmailConfig 邮件服务配置
+type EmailConfig struct {
+	On           bool
+	SMTPHost     string
+	SMTPPort     int // 端口 0.按加密方式使用默认端口
+	SMTPUsername string
+	SMTPPassword string
+	SMTPTLS      int    // 加密方式 0.自动 1.SSL/TLS 2.STARTTLS
+	From         string // 发件人邮箱
+	FromName     string // 发件人名称
+	CodeSubject  string // 验证码邮件标题模版，为空时使用默认模版
+	CodeTemplate string // 验证码邮件正文模版（html），为空时使用默认模版
+}
+
+// Enabled 是否可以发送邮件
+func (e *EmailConfig) Enabled() bool {
+	return e != nil && e.On && strings.TrimSpace(e.SMTPHost) != "" && strings.TrimSpace(e.From) != ""
+}
+
+// IEmailConfigProvider 邮件配置提供者
+type IEmailConfigProvider interface {
+	GetEmailConfig() (*EmailConfig, error)
+}
+
+// IEmailProvider 邮件发送
+type IEmailProvider interface {
+	SendEmail(ctx context.Context, to string, subject string, htmlBody string) error
+}
+
+// IEmailService 邮件验证码服务
+type IEmailService interface {
+	// 发送验证码
+	SendVerifyCode(ctx context.Context, email string, codeType CodeType) error
+	// 验证验证码(销毁缓存)
+	Verify(ctx context.Context, email, code string, codeType CodeType) error
+}
+
+// EmailService 邮件验证码服务（发送频率限制和失败锁定与短信一致）
+type EmailService struct {
+	ctx *config.Context
+	log.Log
+	codeStore      *verifyCodeStore
+	configProvider IEmailConfigProvider
+}
+
+// NewEmailService 创建邮件验证码服务
+func NewEmailService(ctx *config.Context, configProvider IEmailConfigProvider) *EmailService {
+	return &EmailService{
+		ctx:            ctx,
+		Log:            log.NewTLog("EmailService"),
+		codeStore:      newVerifyCodeStore(ctx, "email", CacheKeyEmailCode),
+		configProvider: configProvider,
+	}
+}
+
+// SendVerifyCode 发送验证码
+func (e *EmailService) SendVerifyCode(ctx context.Context, email string, codeType CodeType) error {
+	cfg, err := e.configProvider.GetEmailConfig()
+	if err != nil {
+		return err
+	}
+	if !cfg.Enabled() {
+		return ErrEmailServiceOff
+	}
+	err = e.codeStore.checkRateLimit(email)
+	if err != nil {
+		return err
+	}
+	verifyCode, err := e.codeStore.create(email, codeType)
+	if err != nil {
+		e.Error("生成验证码失败", zap.Error(err))
+		return err
+	}
+	subject, body, err := RenderVerifyCodeEmail(cfg.CodeSubject, cfg.CodeTemplate, &VerifyCodeEmailData{
+		Code:          verifyCode,
+		Purpose:       codeType.Purpose(),
+		ExpireMinutes: int(verifyCodeExpire / time.Minute),
+	})
+	if err != nil {
+		e.Error("渲染验证码邮件失败", zap.Error(err))
+		return err
+	}
+	return NewSMTPProvider(cfg).SendEmail(ctx, email, subject, body)
+}
+
+// Verify 验证验证码
+func (e *EmailService) Verify(ctx context.Context, email, code string, codeType CodeType) error {
+	span, _ := e.ctx.Tracer().StartSpanFromContext(ctx, "emailService.Verify")
+	defer span.Finish()
+
+	return e.codeStore.verify(email, code, codeType)
+}
+
+// VerifyCodeEmailData 验证码邮件模版可以使用的变量
+type VerifyCodeEmailData struct {
+	Code          string // 验证码
+	Purpose       string // 用途 如注册、重置登录密码
+	ExpireMinutes int    // 有效分钟数
+}
+
+// RenderVerifyCodeEmail 渲染验证码邮件的标题和正文，模版为空时使用默认模版
+func RenderVerifyCodeEmail(subjectTpl string, bodyTpl string, data *VerifyCodeEmailData) (string, string, error) {
+	if strings.TrimSpace(subjectTpl) == "" {
+		subjectTpl = DefaultEmailCodeSubject
+	}
+	if strings.TrimSpace(bodyTpl) == "" {
+		bodyTpl = DefaultEmailCodeTemplate
+	}
+	st, err := texttemplate.New("subject").Parse(subjectTpl)
+	if err != nil {
+		return "", "", fmt.Errorf("邮件标题模版有误：%w", err)
+	}
+	bt, err := htmltemplate.New("body").Parse(bodyTpl)
+	if err != nil {
+		return "", "", fmt.Errorf("邮件正文模版有误：%w", err)
+	}
+	var subject, body bytes.Buffer
+	if err = st.Execute(&subject, data); err != nil {
+		return "", "", fmt.Errorf("邮件标题模版有误：%w", err)
+	}
+	if err = bt.Execute(&body, data); err != nil {
+		return "", "", fmt.Errorf("邮件正文模版有误：%w", err)
+	}
+	// 标题不能换行
+	return strings.Join(strings.Fields(subject.String()), " "), body.String(), nil
+}
+
+// SMTPProvider 通过SMTP发送邮件
+type SMTPProvider struct {
+	cfg *EmailConfig
+}
+
+// NewSMTPProvider 创建SMTP邮件发送
+func NewSMTPProvider(cfg *EmailConfig) *SMTPProvider {
+	return &SMTPProvider{
+		cfg: cfg,
+	}
+}
+
+// SendEmail 发送html邮件
+func (s *SMTPProvider) SendEmail(ctx context.Context, to string, subject string, htmlBody string) error {
+	msg, err := buildEmailMessage(s.cfg.From, s.cfg.FromName, to, subject, htmlBody, time.Now())
+	if err != nil {
+		return err
+	}
+	host := strings.TrimSpace(s.cfg.SMTPHost)
+	port := s.cfg.SMTPPort
+	if port == 0 {
+		switch s.cfg.SMTPTLS {
+		case EmailSMTPTLSImplicit:
+			port = 465
+		case EmailSMTPTLSStartTLS:
+			port = 587
+		default:
+			port = 25
+		}
+	}
+	addr := net.JoinHostPort(host, strconv.Itoa(port))
+	tlsConfig := &tls.Config{ServerName: host}
+
+	dialer := &net.Dialer{Timeout: time.Second * 10}
+	var conn net.Conn
+	if s.cfg.SMTPTLS == EmailSMTPTLSImplicit {
+		conn, err = tls.DialWithDialer(dialer, "tcp", addr, tlsConfig)
+	} else {
+		conn, err = dialer.DialContext(ctx, "tcp", addr)
+	}
+	if err != nil {
+		return err
+	}
+	conn.SetDeadline(time.Now().Add(smtpTimeout))
+	client, err := smtp.NewClient(conn, host)
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	defer client.Close()
+
+	if s.cfg.SMTPTLS != EmailSMTPTLSImplicit {
+		if ok, _ := client.Extension("STARTTLS"); ok {
+			if err = client.StartTLS(tlsConfig); err != nil {
+				return err
+			}
+		} else if s.cfg.SMTPTLS == EmailSMTPTLSStartTLS {
+			return errors.New("邮件服务器不支持STARTTLS")
+		}
+	}
+	if s.cfg.SMTPUsername != "" {
+		// PlainAuth只允许在加密连接（或本机）上发送密码
+		if err = client.Auth(smtp.PlainAuth("", s.cfg.SMTPUsername, s.cfg.SMTPPassword, host)); err != nil {
+			return err
+		}
+	}
+	if err = client.Mail(s.cfg.From); err != nil {
+		return err
+	}
+	if err = client.Rcpt(to); err != nil {
+		return err
+	}
+	w, err := client.Data()
+	if err != nil {
+		return err
+	}
+	if _, err = w.Write(msg); err != nil {
+		return err
+	}
+	if err = w.Close(); err != nil {
+		return err
+	}
+	return client.Quit()
+}
+
+// 组装MIME邮件（正文为base64编码的html）
+func buildEmailMessage(from, fromName, to, subject, htmlBody string, date time.Time) ([]byte, error) {
+	for _, v := range []string{from, fromName, to, subject} {
+		if strings.ContainsAny(v, "\r\n") {
+			return nil, errors.New("邮件头不能包含换行符")
+		}
+	}
+	domain := "localhost"
+	if i := strings.LastIndex(from, "@"); i >= 0 {
+		domain = from[i+1:]
+	}
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "From: %s\r\n", (&mail.Address{Name: fromName, Address: from}).String())
+	fmt.Fprintf(&buf, "To: %s\r\n", (&mail.Address{Address: to}).String())
+	fmt.Fprintf(&buf, "Subject: %s\r\n", mime.BEncoding.Encode("UTF-8", subject))
+	fmt.Fprintf(&buf, "Date: %s\r\n", date.Format(time.RFC1123Z))
+	fmt.Fprintf(&buf, "Message-ID: <%d@%s>\r\n", date.UnixNano(), domain)
+	buf.WriteString("MIME-Version: 1.0\r\n")
+	buf.WriteString("Content-Type: text/html; charset=UTF-8\r\n")
+	buf.WriteString("Content-Transfer-Encoding: base64\r\n\r\n")
+	encoded := base64.StdEncoding.EncodeToString([]byte(htmlBody))
+	for len(encoded) > 76 {
+		buf.WriteString(encoded[:76])
+		buf.WriteString("\r\n")
+		encoded = encoded[76:]
+	}
+	buf.WriteString(encoded)
+	buf.WriteString("\r\n")
+	return buf.Bytes(), nil
+}
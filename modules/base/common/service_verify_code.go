@@ -0,0 +1,132 @@
+package common
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"math/big"
+	"strconv"
+	"time"
+
+	"github.com/TangSengDaoDao/TangSengDaoDaoServerLib/config"
+)
+
+// 验证码有效期
+const verifyCodeExpire = time.Minute * 5
+
+var errVerifyCodeInvalid = errors.New("验证码无效！")
+
+// verifyCodeStore 验证码的缓存、发送频率限制和失败锁定（短信和邮件共用）
+type verifyCodeStore struct {
+	ctx            *config.Context
+	keyPrefix      string // 频率限制和失败计数的缓存key前缀 如sms、email
+	cacheKeyPrefix string // 验证码的缓存key前缀
+}
+
+func newVerifyCodeStore(ctx *config.Context, keyPrefix string, cacheKeyPrefix string) *verifyCodeStore {
+	return &verifyCodeStore{
+		ctx:            ctx,
+		keyPrefix:      keyPrefix,
+		cacheKeyPrefix: cacheKeyPrefix,
+	}
+}
+
+// 检查发送频率限制（同一个目标1分钟内只能发送一次）
+func (v *verifyCodeStore) checkRateLimit(target string) error {
+	exists, err := v.ctx.GetRedisConn().GetString(v.rateLimitKey(target))
+	if err != nil {
+		return err
+	}
+	if exists != "" {
+		return errors.New("发送过于频繁，请1分钟后再试")
+	}
+	return nil
+}
+
+// 生成验证码并缓存，同时设置发送频率限制
+func (v *verifyCodeStore) create(target string, codeType CodeType) (string, error) {
+	// 使用 crypto/rand 生成安全的验证码
+	verifyCode, err := generateSecureVerifyCode(4)
+	if err != nil {
+		return "", errors.New("系统错误，请稍后重试")
+	}
+	err = v.ctx.GetRedisConn().SetAndExpire(v.cacheKey(target, codeType), verifyCode, verifyCodeExpire)
+	if err != nil {
+		return "", err
+	}
+	// 设置发送频率限制
+	err = v.ctx.GetRedisConn().SetAndExpire(v.rateLimitKey(target), "1", time.Minute)
+	if err != nil {
+		return "", err
+	}
+	return verifyCode, nil
+}
+
+// 校验验证码，成功后销毁缓存；连续失败3次锁定10分钟
+func (v *verifyCodeStore) verify(target string, code string, codeType CodeType) error {
+	// 检查是否被锁定
+	lockKey := fmt.Sprintf("%s_verify_lock:%s", v.keyPrefix, target)
+	locked, err := v.ctx.GetRedisConn().GetString(lockKey)
+	if err != nil {
+		return err
+	}
+	if locked != "" {
+		return errors.New("验证失败次数过多，请10分钟后再试")
+	}
+
+	cacheKey := v.cacheKey(target, codeType)
+	sysCode, err := v.ctx.GetRedisConn().GetString(cacheKey)
+	if err != nil {
+		return err
+	}
+	failCountKey := fmt.Sprintf("%s_verify_fail:%s", v.keyPrefix, target)
+	if sysCode != "" && subtle.ConstantTimeCompare([]byte(sysCode), []byte(code)) == 1 {
+		v.ctx.GetRedisConn().Del(cacheKey)
+		// 验证成功，清除失败计数
+		v.ctx.GetRedisConn().Del(failCountKey)
+		v.ctx.GetRedisConn().Del(lockKey)
+		return nil
+	}
+
+	// 验证失败，增加失败计数
+	failCountStr, _ := v.ctx.GetRedisConn().GetString(failCountKey)
+	failCount := 0
+	if failCountStr != "" {
+		if count, err := strconv.Atoi(failCountStr); err == nil {
+			failCount = count
+		}
+	}
+	failCount++
+
+	if failCount >= 3 {
+		// 锁定10分钟
+		v.ctx.GetRedisConn().SetAndExpire(lockKey, "1", time.Minute*10)
+		return errors.New("验证失败次数过多，已锁定10分钟")
+	}
+	// 设置失败计数，10分钟过期
+	v.ctx.GetRedisConn().SetAndExpire(failCountKey, fmt.Sprintf("%d", failCount), time.Minute*10)
+	return errVerifyCodeInvalid
+}
+
+func (v *verifyCodeStore) rateLimitKey(target string) string {
+	return fmt.Sprintf("%s_rate_limit:%s", v.keyPrefix, target)
+}
+
+func (v *verifyCodeStore) cacheKey(target string, codeType CodeType) string {
+	return fmt.Sprintf("%s%d@%s", v.cacheKeyPrefix, codeType, target)
+}
+
+// generateSecureVerifyCode 生成密码学安全的验证码
+func generateSecureVerifyCode(length int) (string, error) {
+	const digits = "0123456789"
+	result := make([]byte, length)
+	for i := 0; i < length; i++ {
+		num, err := rand.Int(rand.Reader, big.NewInt(int64(len(digits))))
+		if err != nil {
+			return "", err
+		}
+		result[i] = digits[num.Int64()]
+	}
+	return string(result), nil
+}
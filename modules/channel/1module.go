@@ -3,7 +3,9 @@ package channel
 import (
 	"embed"
 
+	chservice "github.com/TangSengDaoDao/TangSengDaoDaoServer/modules/channel/service"
 	"github.com/TangSengDaoDao/TangSengDaoDaoServerLib/config"
+	"github.com/TangSengDaoDao/TangSengDaoDaoServerLib/model"
 	"github.com/TangSengDaoDao/TangSengDaoDaoServerLib/pkg/register"
 )
 
@@ -15,14 +17,43 @@ var swaggerContent string
 
 func init() {
 	register.AddModule(func(ctx interface{}) register.Module {
+		api := New(ctx.(*config.Context))
 		return register.Module{
 			Name: "channel",
 			SetupAPI: func() register.APIRouter {
-				return New(ctx.(*config.Context))
+				return api
 			},
 			SQLDir:  register.NewSQLFS(sqlFS),
 			Swagger: swaggerContent,
 			Service: NewService(ctx.(*config.Context)),
+			IMDatasource: register.IMDatasource{
+				HasData: func(channelID string, channelType uint8) register.IMDatasourceType {
+					if channelType == chservice.ChannelTypeThread {
+						return register.IMDatasourceTypeChannelInfo | register.IMDatasourceTypeSubscribers | register.IMDatasourceTypeBlacklist | register.IMDatasourceTypeWhitelist
+					}
+					return register.IMDatasourceTypeNone
+				},
+				ChannelInfo: func(channelID string, channelType uint8) (map[string]interface{}, error) {
+					return api.threadChannelInfo(channelID)
+				},
+				Subscribers: func(channelID string, channelType uint8) ([]string, error) {
+					return api.threadSubscribers(channelID)
+				},
+				Blacklist: func(channelID string, channelType uint8) ([]string, error) {
+					return api.threadBlacklist(channelID)
+				},
+				Whitelist: func(channelID string, channelType uint8) ([]string, error) {
+					return api.threadWhitelist(channelID)
+				},
+			},
+			BussDataSource: register.BussDataSource{
+				ChannelGet: func(channelID string, channelType uint8, loginUID string) (*model.ChannelResp, error) {
+					if channelType != chservice.ChannelTypeThread {
+						return nil, register.ErrDatasourceNotProcess
+					}
+					return api.threadChannelGet(channelID, loginUID)
+				},
+			},
 		}
 	})
 }
@@ -24,6 +24,7 @@ type Channel struct {
 	userService      user.IService
 	groupService     group.IService
 	channelSettingDB *channelSettingDB
+	threadDB         *threadDB
 }
 
 func New(ctx *config.Context) *Channel {
@@ -33,6 +34,7 @@ func New(ctx *config.Context) *Channel {
 		userService:      user.NewService(ctx),
 		groupService:     group.NewService(ctx),
 		channelSettingDB: newChannelSettingDB(ctx),
+		threadDB:         newThreadDB(ctx),
 	}
 }
 
@@ -45,6 +47,13 @@ func (ch *Channel) Route(r *wkhttp.WKHttp) {
 		auth.POST("/channels/:channel_id/:channel_type/message/autodelete", ch.setAutoDeleteForMessage) // 设置消息定时删除时间
 		auth.POST("/channels/:channel_id/:channel_type/message/clear", ch.clearChannelMessages)         // 清空频道消息
 	}
+	threads := r.Group("/v1/threads", ch.ctx.AuthMiddleware(r))
+	{
+		threads.POST("", ch.threadCreate)            // 从群消息创建话题
+		threads.GET("/sync", ch.threadSync)          // 同步频道下的话题
+		threads.PUT("/:channel_id", ch.threadUpdate) // 修改话题
+	}
+	ch.ctx.AddMessagesListener(ch.listenerThreadMessages) // 监听话题消息
 }
 
 func (ch *Channel) clearChannelMessages(c *wkhttp.Context) {
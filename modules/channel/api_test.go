@@ -0,0 +1,142 @@
+package channel
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/TangSengDaoDao/TangSengDaoDaoServer/modules/group"
+	"github.com/TangSengDaoDao/TangSengDaoDaoServerLib/common"
+	"github.com/TangSengDaoDao/TangSengDaoDaoServerLib/config"
+	"github.com/TangSengDaoDao/TangSengDaoDaoServerLib/pkg/util"
+	"github.com/TangSengDaoDao/TangSengDaoDaoServerLib/testutil"
+	"github.com/stretchr/testify/assert"
+)
+
+// 准备一个群和群下的话题
+func prepareThread(t *testing.T, ctx *config.Context, ch *Channel) *threadModel {
+	groupDB := group.NewDB(ctx)
+	err := groupDB.Insert(&group.Model{
+		GroupNo: "g1",
+		Name:    "群1",
+		Creator: "10001",
+		Status:  group.GroupStatusNormal,
+		Version: 1,
+	})
+	assert.NoError(t, err)
+	members := []*group.MemberModel{
+		{GroupNo: "g1", UID: "10001", Role: group.MemberRoleCreator, Status: int(common.GroupMemberStatusNormal)},
+		{GroupNo: "g1", UID: testutil.UID, Role: group.MemberRoleCommon, Status: int(common.GroupMemberStatusNormal)},
+		{GroupNo: "g1", UID: "10002", Role: group.MemberRoleCommon, Status: int(common.GroupMemberStatusBlacklist)},
+		{GroupNo: "g1", UID: "10003", Role: group.MemberRoleCommon, Status: int(common.GroupMemberStatusNormal), ForbiddenExpirTime: time.Now().Add(time.Hour).Unix()},
+		{GroupNo: "g1", UID: "10004", Role: group.MemberRoleCommon, Status: int(common.GroupMemberStatusNormal), ForbiddenExpirTime: time.Now().Add(-time.Hour).Unix()},
+	}
+	for _, member := range members {
+		err = groupDB.InsertMember(member)
+		assert.NoError(t, err)
+	}
+
+	threadM := &threadModel{
+		ChannelID:         getThreadChannelID("g1", "100"),
+		ParentChannelID:   "g1",
+		ParentChannelType: common.ChannelTypeGroup.Uint8(),
+		RootMessageID:     "100",
+		RootMessageSeq:    1,
+		Creator:           "10001",
+		Name:              "话题1",
+		Version:           1,
+	}
+	tx, err := ctx.DB().Begin()
+	assert.NoError(t, err)
+	err = ch.threadDB.insertTx(threadM, tx)
+	assert.NoError(t, err)
+	err = tx.Commit()
+	assert.NoError(t, err)
+	return threadM
+}
+
+func TestThreadDatasource(t *testing.T) {
+	_, ctx := testutil.NewTestServer()
+	ch := New(ctx)
+	err := testutil.CleanAllTables(ctx)
+	assert.NoError(t, err)
+	threadM := prepareThread(t, ctx, ch)
+
+	subscribers, err := ch.threadSubscribers(threadM.ChannelID)
+	assert.NoError(t, err)
+	assert.ElementsMatch(t, []string{"10001", testutil.UID, "10002", "10003", "10004"}, subscribers)
+
+	// 被拉黑和禁言中的成员不能在话题内发言，禁言已过期的成员可以
+	blacklist, err := ch.threadBlacklist(threadM.ChannelID)
+	assert.NoError(t, err)
+	assert.ElementsMatch(t, []string{"10002", "10003"}, blacklist)
+
+	// 不存在的话题
+	subscribers, err = ch.threadSubscribers("g1____999")
+	assert.NoError(t, err)
+	assert.Equal(t, 0, len(subscribers))
+}
+
+func TestGetThreadChannelIDs(t *testing.T) {
+	_, ctx := testutil.NewTestServer()
+	ch := New(ctx)
+	err := testutil.CleanAllTables(ctx)
+	assert.NoError(t, err)
+	threadM := prepareThread(t, ctx, ch)
+
+	channelIDs, err := NewService(ctx).GetThreadChannelIDs("g1", common.ChannelTypeGroup.Uint8())
+	assert.NoError(t, err)
+	assert.Equal(t, []string{threadM.ChannelID}, channelIDs)
+
+	channelIDs, err = NewService(ctx).GetThreadChannelIDs("g2", common.ChannelTypeGroup.Uint8())
+	assert.NoError(t, err)
+	assert.Equal(t, 0, len(channelIDs))
+}
+
+func TestThreadSync(t *testing.T) {
+	s, ctx := testutil.NewTestServer()
+	ch := New(ctx)
+	ch.Route(s.GetRoute())
+	err := testutil.CleanAllTables(ctx)
+	assert.NoError(t, err)
+	threadM := prepareThread(t, ctx, ch)
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/v1/threads/sync?channel_id=g1&channel_type=2&version=0", nil)
+	req.Header.Set("token", testutil.Token)
+	s.GetRoute().ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), threadM.ChannelID)
+
+	// 非群成员不能同步
+	w = httptest.NewRecorder()
+	req, _ = http.NewRequest("GET", "/v1/threads/sync?channel_id=g2&channel_type=2&version=0", nil)
+	req.Header.Set("token", testutil.Token)
+	s.GetRoute().ServeHTTP(w, req)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+}
+
+func TestThreadUpdate(t *testing.T) {
+	s, ctx := testutil.NewTestServer()
+	ch := New(ctx)
+	ch.Route(s.GetRoute())
+	err := testutil.CleanAllTables(ctx)
+	assert.NoError(t, err)
+	threadM := prepareThread(t, ctx, ch)
+
+	// 普通成员不能修改别人创建的话题
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("PUT", "/v1/threads/"+threadM.ChannelID, bytes.NewReader([]byte(util.ToJson(map[string]interface{}{
+		"name": "新话题",
+	}))))
+	req.Header.Set("token", testutil.Token)
+	s.GetRoute().ServeHTTP(w, req)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Contains(t, w.Body.String(), "只有话题创建者或群管理员才能修改话题")
+
+	threadM, err = ch.threadDB.queryWithChannelID(threadM.ChannelID)
+	assert.NoError(t, err)
+	assert.Equal(t, "话题1", threadM.Name)
+}
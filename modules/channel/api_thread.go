@@ -0,0 +1,493 @@
+package channel
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	chservice "github.com/TangSengDaoDao/TangSengDaoDaoServer/modules/channel/service"
+	"github.com/TangSengDaoDao/TangSengDaoDaoServer/modules/group"
+	"github.com/TangSengDaoDao/TangSengDaoDaoServerLib/common"
+	"github.com/TangSengDaoDao/TangSengDaoDaoServerLib/config"
+	"github.com/TangSengDaoDao/TangSengDaoDaoServerLib/model"
+	"github.com/TangSengDaoDao/TangSengDaoDaoServerLib/pkg/util"
+	"github.com/TangSengDaoDao/TangSengDaoDaoServerLib/pkg/wkhttp"
+	"go.uber.org/zap"
+)
+
+const (
+	// CMDSyncThread 同步话题（发往父频道）
+	CMDSyncThread = "syncThread"
+
+	threadChannelIDSeparator = "____" // 话题频道ID的分隔符 格式：父频道ID____根消息ID
+	threadNameMaxLen         = 100    // 话题名称最大长度
+	threadDefaultNameLen     = 20     // 默认话题名称截取根消息内容的长度
+	threadSyncDefaultLimit   = 100    // 话题同步默认数量
+	threadSyncMaxLimit       = 500    // 话题同步最大数量
+)
+
+// 从群消息创建话题（同一条消息只能创建一个话题，重复创建返回已有话题）
+func (ch *Channel) threadCreate(c *wkhttp.Context) {
+	loginUID := c.GetLoginUID()
+	var req struct {
+		ChannelID   string `json:"channel_id"`   // 父频道ID
+		ChannelType uint8  `json:"channel_type"` // 父频道类型
+		MessageID   string `json:"message_id"`   // 根消息ID
+		Name        string `json:"name"`         // 话题名称
+	}
+	if err := c.BindJSON(&req); err != nil {
+		ch.Error(common.ErrData.Error(), zap.Error(err))
+		c.ResponseError(common.ErrData)
+		return
+	}
+	if req.ChannelID == "" {
+		c.ResponseError(errors.New("频道ID不能为空"))
+		return
+	}
+	if req.ChannelType != common.ChannelTypeGroup.Uint8() {
+		c.ResponseError(errors.New("只能在群聊中创建话题"))
+		return
+	}
+	if req.MessageID == "" {
+		c.ResponseError(errors.New("消息ID不能为空"))
+		return
+	}
+	messageID, err := strconv.ParseInt(req.MessageID, 10, 64)
+	if err != nil {
+		c.ResponseError(errors.New("消息ID不合法"))
+		return
+	}
+	req.Name = strings.TrimSpace(req.Name)
+	if len([]rune(req.Name)) > threadNameMaxLen {
+		c.ResponseError(fmt.Errorf("话题名称不能超过%d个字", threadNameMaxLen))
+		return
+	}
+	if err := ch.checkThreadGroupMember(req.ChannelID, loginUID); err != nil {
+		c.ResponseError(err)
+		return
+	}
+
+	threadM, err := ch.threadDB.queryWithRootMessageID(req.ChannelID, req.ChannelType, req.MessageID)
+	if err != nil {
+		ch.Error("查询话题失败！", zap.Error(err))
+		c.ResponseError(errors.New("查询话题失败！"))
+		return
+	}
+	if threadM != nil {
+		c.Response(newThreadResp(threadM))
+		return
+	}
+
+	syncMsg, err := ch.ctx.IMSearchMessages(&config.MsgSearchReq{
+		ChannelID:   req.ChannelID,
+		ChannelType: req.ChannelType,
+		LoginUID:    loginUID,
+		MessageIds:  []int64{messageID},
+	})
+	if err != nil {
+		ch.Error("查询消息错误", zap.Error(err))
+		c.ResponseError(errors.New("查询消息错误"))
+		return
+	}
+	if syncMsg == nil || len(syncMsg.Messages) == 0 {
+		c.ResponseError(errors.New("该消息不存在或已删除"))
+		return
+	}
+	rootMessage := syncMsg.Messages[0]
+	if req.Name == "" {
+		req.Name = ch.defaultThreadName(rootMessage)
+	}
+
+	threadM = &threadModel{
+		ChannelID:         getThreadChannelID(req.ChannelID, req.MessageID),
+		ParentChannelID:   req.ChannelID,
+		ParentChannelType: req.ChannelType,
+		RootMessageID:     req.MessageID,
+		RootMessageSeq:    rootMessage.MessageSeq,
+		Creator:           loginUID,
+		Name:              req.Name,
+		Version:           time.Now().UnixMilli(),
+	}
+	tx, err := ch.ctx.DB().Begin()
+	if err != nil {
+		ch.Error("开启事务失败！", zap.Error(err))
+		c.ResponseError(errors.New("开启事务失败！"))
+		return
+	}
+	defer func() {
+		if err := recover(); err != nil {
+			tx.RollbackUnlessCommitted()
+			panic(err)
+		}
+	}()
+	err = ch.threadDB.insertTx(threadM, tx)
+	if err != nil {
+		tx.Rollback()
+		ch.Error("创建话题失败！", zap.Error(err))
+		c.ResponseError(errors.New("创建话题失败！"))
+		return
+	}
+	err = ch.channelSettingDB.insertOrUpdateParentChannelTx(threadM.ChannelID, chservice.ChannelTypeThread, threadM.ParentChannelID, threadM.ParentChannelType, tx)
+	if err != nil {
+		tx.Rollback()
+		ch.Error("设置话题父频道失败！", zap.Error(err))
+		c.ResponseError(errors.New("设置话题父频道失败！"))
+		return
+	}
+	for _, uid := range util.RemoveRepeatedElement([]string{loginUID, rootMessage.FromUID}) {
+		if uid == "" {
+			continue
+		}
+		err = ch.threadDB.insertParticipantTx(threadM.ChannelID, uid, tx)
+		if err != nil {
+			tx.Rollback()
+			ch.Error("添加话题参与者失败！", zap.Error(err))
+			c.ResponseError(errors.New("添加话题参与者失败！"))
+			return
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		tx.Rollback()
+		ch.Error("提交事务失败！", zap.Error(err))
+		c.ResponseError(errors.New("提交事务失败！"))
+		return
+	}
+	ch.sendSyncThreadCMD(threadM, loginUID)
+	c.Response(newThreadResp(threadM))
+}
+
+// 同步频道下的话题
+func (ch *Channel) threadSync(c *wkhttp.Context) {
+	loginUID := c.GetLoginUID()
+	channelID := c.Query("channel_id")
+	channelTypeI64, _ := strconv.ParseInt(c.Query("channel_type"), 10, 64)
+	channelType := uint8(channelTypeI64)
+	version, _ := strconv.ParseInt(c.Query("version"), 10, 64)
+	limit, _ := strconv.ParseUint(c.Query("limit"), 10, 64)
+	if channelID == "" {
+		c.ResponseError(errors.New("频道ID不能为空"))
+		return
+	}
+	if channelType != common.ChannelTypeGroup.Uint8() {
+		c.ResponseError(errors.New("频道类型不支持话题"))
+		return
+	}
+	if limit == 0 {
+		limit = threadSyncDefaultLimit
+	}
+	if limit > threadSyncMaxLimit {
+		limit = threadSyncMaxLimit
+	}
+	exist, err := ch.groupService.ExistMember(channelID, loginUID)
+	if err != nil {
+		ch.Error("查询是否是群成员失败！", zap.Error(err))
+		c.ResponseError(errors.New("查询是否是群成员失败！"))
+		return
+	}
+	if !exist {
+		c.ResponseError(errors.New("不是群成员"))
+		return
+	}
+	threadModels, err := ch.threadDB.sync(channelID, channelType, version, limit)
+	if err != nil {
+		ch.Error("同步话题失败！", zap.Error(err))
+		c.ResponseError(errors.New("同步话题失败！"))
+		return
+	}
+	resps := make([]*threadResp, 0, len(threadModels))
+	for _, threadM := range threadModels {
+		resps = append(resps, newThreadResp(threadM))
+	}
+	c.Response(resps)
+}
+
+// 修改话题名称
+func (ch *Channel) threadUpdate(c *wkhttp.Context) {
+	loginUID := c.GetLoginUID()
+	threadChannelID := c.Param("channel_id")
+	var req struct {
+		Name string `json:"name"`
+	}
+	if err := c.BindJSON(&req); err != nil {
+		ch.Error(common.ErrData.Error(), zap.Error(err))
+		c.ResponseError(common.ErrData)
+		return
+	}
+	req.Name = strings.TrimSpace(req.Name)
+	if req.Name == "" {
+		c.ResponseError(errors.New("话题名称不能为空"))
+		return
+	}
+	if len([]rune(req.Name)) > threadNameMaxLen {
+		c.ResponseError(fmt.Errorf("话题名称不能超过%d个字", threadNameMaxLen))
+		return
+	}
+	threadM, err := ch.threadDB.queryWithChannelID(threadChannelID)
+	if err != nil {
+		ch.Error("查询话题失败！", zap.Error(err))
+		c.ResponseError(errors.New("查询话题失败！"))
+		return
+	}
+	if threadM == nil {
+		c.ResponseError(errors.New("话题不存在"))
+		return
+	}
+	if threadM.Creator != loginUID {
+		isCreatorOrManager, err := ch.groupService.IsCreatorOrManager(threadM.ParentChannelID, loginUID)
+		if err != nil {
+			ch.Error("查询群的创建者或管理员错误", zap.Error(err))
+			c.ResponseError(errors.New("查询群的创建者或管理员错误"))
+			return
+		}
+		if !isCreatorOrManager {
+			c.ResponseError(errors.New("只有话题创建者或群管理员才能修改话题"))
+			return
+		}
+	}
+	threadM.Name = req.Name
+	threadM.Version = time.Now().UnixMilli()
+	err = ch.threadDB.updateName(threadM.ChannelID, threadM.Name, threadM.Version)
+	if err != nil {
+		ch.Error("修改话题名称失败！", zap.Error(err))
+		c.ResponseError(errors.New("修改话题名称失败！"))
+		return
+	}
+	ch.sendSyncThreadCMD(threadM, loginUID)
+	c.ResponseOK()
+}
+
+// 监听话题频道的消息，更新回复数和最后回复信息
+func (ch *Channel) listenerThreadMessages(messages []*config.MessageResp) {
+	systemUID := ch.ctx.GetConfig().Account.SystemUID
+	for _, message := range messages {
+		if message.ChannelType != chservice.ChannelTypeThread || message.FromUID == systemUID {
+			continue
+		}
+		threadM, err := ch.threadDB.queryWithChannelID(message.ChannelID)
+		if err != nil {
+			ch.Error("查询话题失败！", zap.Error(err), zap.String("channelID", message.ChannelID))
+			continue
+		}
+		if threadM == nil {
+			continue
+		}
+		threadM.LastMessageID = strconv.FormatInt(message.MessageID, 10)
+		threadM.LastMessageSeq = message.MessageSeq
+		threadM.LastReplyUID = message.FromUID
+		threadM.LastReplyAt = int64(message.Timestamp)
+		threadM.Version = time.Now().UnixMilli()
+		err = ch.threadDB.updateReply(threadM)
+		if err != nil {
+			ch.Error("更新话题回复信息失败！", zap.Error(err), zap.String("channelID", message.ChannelID))
+			continue
+		}
+		err = ch.threadDB.insertParticipant(threadM.ChannelID, message.FromUID)
+		if err != nil {
+			ch.Error("添加话题参与者失败！", zap.Error(err), zap.String("channelID", message.ChannelID))
+		}
+		ch.sendSyncThreadCMD(threadM, message.FromUID)
+	}
+}
+
+// 校验用户是否可以在群内操作话题
+func (ch *Channel) checkThreadGroupMember(groupNo string, uid string) error {
+	groupInfo, err := ch.groupService.GetGroupWithGroupNo(groupNo)
+	if err != nil {
+		ch.Error("查询群信息失败！", zap.Error(err))
+		return errors.New("查询群信息失败！")
+	}
+	if groupInfo == nil || groupInfo.Status != group.GroupStatusNormal {
+		return errors.New("群不存在或已删除")
+	}
+	exist, err := ch.groupService.ExistMember(groupNo, uid)
+	if err != nil {
+		ch.Error("查询是否是群成员失败！", zap.Error(err))
+		return errors.New("查询是否是群成员失败！")
+	}
+	if !exist {
+		return errors.New("不是群成员")
+	}
+	return nil
+}
+
+func (ch *Channel) defaultThreadName(rootMessage *config.MessageResp) string {
+	payloadMap, err := rootMessage.GetPayloadMap()
+	if err != nil || payloadMap == nil {
+		return "话题"
+	}
+	content, _ := payloadMap["content"].(string)
+	contentRunes := []rune(strings.TrimSpace(content))
+	if len(contentRunes) == 0 {
+		return "话题"
+	}
+	if len(contentRunes) > threadDefaultNameLen {
+		return string(contentRunes[:threadDefaultNameLen])
+	}
+	return string(contentRunes)
+}
+
+func (ch *Channel) sendSyncThreadCMD(threadM *threadModel, fromUID string) {
+	err := ch.ctx.SendCMD(config.MsgCMDReq{
+		NoPersist:   true,
+		ChannelID:   threadM.ParentChannelID,
+		ChannelType: threadM.ParentChannelType,
+		FromUID:     fromUID,
+		CMD:         CMDSyncThread,
+		Param: map[string]interface{}{
+			"channel_id":   threadM.ParentChannelID,
+			"channel_type": threadM.ParentChannelType,
+		},
+	})
+	if err != nil {
+		ch.Warn("发送同步话题命令失败！", zap.Error(err))
+	}
+}
+
+// 话题的频道信息
+func (ch *Channel) threadChannelGet(channelID string, loginUID string) (*model.ChannelResp, error) {
+	threadM, err := ch.threadDB.queryWithChannelID(channelID)
+	if err != nil {
+		return nil, err
+	}
+	if threadM == nil {
+		return nil, nil
+	}
+	exist, err := ch.groupService.ExistMember(threadM.ParentChannelID, loginUID)
+	if err != nil {
+		return nil, err
+	}
+	if !exist {
+		return nil, nil
+	}
+	resp := &model.ChannelResp{}
+	resp.Channel.ChannelID = threadM.ChannelID
+	resp.Channel.ChannelType = chservice.ChannelTypeThread
+	resp.Name = threadM.Name
+	resp.Logo = fmt.Sprintf("groups/%s/avatar", threadM.ParentChannelID)
+	resp.Status = 1
+	resp.Extra = map[string]interface{}{
+		"root_message_id":  threadM.RootMessageID,
+		"root_message_seq": threadM.RootMessageSeq,
+		"creator":          threadM.Creator,
+		"reply_count":      threadM.ReplyCount,
+	}
+	return resp, nil
+}
+
+// 话题的订阅者为父频道（群）的成员
+func (ch *Channel) threadSubscribers(channelID string) ([]string, error) {
+	threadM, err := ch.threadDB.queryWithChannelID(channelID)
+	if err != nil {
+		return nil, err
+	}
+	if threadM == nil {
+		return make([]string, 0), nil
+	}
+	members, err := ch.groupService.GetMembers(threadM.ParentChannelID)
+	if err != nil {
+		return nil, err
+	}
+	subscribers := make([]string, 0, len(members))
+	for _, member := range members {
+		subscribers = append(subscribers, member.UID)
+	}
+	return subscribers, nil
+}
+
+// 话题的频道信息跟随父频道（群）的状态
+func (ch *Channel) threadChannelInfo(channelID string) (map[string]interface{}, error) {
+	channelInfoMap := map[string]interface{}{}
+	threadM, err := ch.threadDB.queryWithChannelID(channelID)
+	if err != nil {
+		return nil, err
+	}
+	if threadM == nil {
+		channelInfoMap["disband"] = 1
+		return channelInfoMap, nil
+	}
+	groupInfo, err := ch.groupService.GetGroupWithGroupNo(threadM.ParentChannelID)
+	if err != nil {
+		return nil, err
+	}
+	if groupInfo == nil || groupInfo.Status == group.GroupStatusDisband {
+		channelInfoMap["disband"] = 1
+	} else if groupInfo.Status == group.GroupStatusDisabled {
+		channelInfoMap["ban"] = 1
+	}
+	return channelInfoMap, nil
+}
+
+// 话题的黑名单为父频道（群）内不能发言的成员（拉黑、禁言中、慢速模式冷却中）
+func (ch *Channel) threadBlacklist(channelID string) ([]string, error) {
+	threadM, err := ch.threadDB.queryWithChannelID(channelID)
+	if err != nil {
+		return nil, err
+	}
+	if threadM == nil {
+		return make([]string, 0), nil
+	}
+	return ch.groupService.GetTalkBlacklistUIDs(threadM.ParentChannelID)
+}
+
+// 父频道（群）全员禁言时只有管理员可以在话题内发言
+func (ch *Channel) threadWhitelist(channelID string) ([]string, error) {
+	threadM, err := ch.threadDB.queryWithChannelID(channelID)
+	if err != nil {
+		return nil, err
+	}
+	if threadM == nil {
+		return make([]string, 0), nil
+	}
+	groupInfo, err := ch.groupService.GetGroupWithGroupNo(threadM.ParentChannelID)
+	if err != nil {
+		return nil, err
+	}
+	if groupInfo != nil && groupInfo.Forbidden == 1 {
+		return ch.groupService.GetMemberUIDsOfManager(threadM.ParentChannelID)
+	}
+	return make([]string, 0), nil
+}
+
+func getThreadChannelID(parentChannelID string, rootMessageID string) string {
+	return fmt.Sprintf("%s%s%s", parentChannelID, threadChannelIDSeparator, rootMessageID)
+}
+
+type threadResp struct {
+	ChannelID         string `json:"channel_id"`          // 话题频道ID
+	ChannelType       uint8  `json:"channel_type"`        // 话题频道类型
+	ParentChannelID   string `json:"parent_channel_id"`   // 父频道ID
+	ParentChannelType uint8  `json:"parent_channel_type"` // 父频道类型
+	RootMessageID     string `json:"root_message_id"`     // 根消息ID
+	RootMessageSeq    uint32 `json:"root_message_seq"`    // 根消息序号
+	Creator           string `json:"creator"`             // 创建者
+	Name              string `json:"name"`                // 话题名称
+	ReplyCount        int    `json:"reply_count"`         // 回复数量
+	LastMessageID     string `json:"last_message_id"`     // 最后一条回复的消息ID
+	LastMessageSeq    uint32 `json:"last_message_seq"`    // 最后一条回复的消息序号
+	LastReplyUID      string `json:"last_reply_uid"`      // 最后回复者
+	LastReplyAt       int64  `json:"last_reply_at"`       // 最后回复时间
+	IsDeleted         int    `json:"is_deleted"`          // 是否已删除
+	Version           int64  `json:"version"`             // 同步版本号
+}
+
+func newThreadResp(m *threadModel) *threadResp {
+	return &threadResp{
+		ChannelID:         m.ChannelID,
+		ChannelType:       chservice.ChannelTypeThread,
+		ParentChannelID:   m.ParentChannelID,
+		ParentChannelType: m.ParentChannelType,
+		RootMessageID:     m.RootMessageID,
+		RootMessageSeq:    m.RootMessageSeq,
+		Creator:           m.Creator,
+		Name:              m.Name,
+		ReplyCount:        m.ReplyCount,
+		LastMessageID:     m.LastMessageID,
+		LastMessageSeq:    m.LastMessageSeq,
+		LastReplyUID:      m.LastReplyUID,
+		LastReplyAt:       m.LastReplyAt,
+		IsDeleted:         m.IsDeleted,
+		Version:           m.Version,
+	}
+}
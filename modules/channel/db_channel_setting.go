@@ -40,6 +40,12 @@ func (c *channelSettingDB) insertOrAddOffsetMessageSeq(channelID string, channel
 	return err
 }
 
+// 设置频道的父频道（话题等子频道）
+func (c *channelSettingDB) insertOrUpdateParentChannelTx(channelID string, channelType uint8, parentChannelID string, parentChannelType uint8, tx *dbr.Tx) error {
+	_, err := tx.InsertBySql("insert into channel_setting (channel_id, channel_type, parent_channel_id, parent_channel_type) values (?, ?, ?, ?) ON DUPLICATE KEY UPDATE parent_channel_id=VALUES(parent_channel_id),parent_channel_type=VALUES(parent_channel_type)", channelID, channelType, parentChannelID, parentChannelType).Exec()
+	return err
+}
+
 type channelSettingModel struct {
 	ChannelID         string
 	ChannelType       uint8
@@ -0,0 +1,100 @@
+package channel
+
+import (
+	"github.com/TangSengDaoDao/TangSengDaoDaoServerLib/config"
+	"github.com/TangSengDaoDao/TangSengDaoDaoServerLib/pkg/db"
+	"github.com/TangSengDaoDao/TangSengDaoDaoServerLib/pkg/util"
+	"github.com/gocraft/dbr/v2"
+)
+
+type threadDB struct {
+	session *dbr.Session
+	ctx     *config.Context
+}
+
+func newThreadDB(ctx *config.Context) *threadDB {
+	return &threadDB{
+		session: ctx.DB(),
+		ctx:     ctx,
+	}
+}
+
+func (t *threadDB) insertTx(m *threadModel, tx *dbr.Tx) error {
+	_, err := tx.InsertInto("thread").Columns(util.AttrToUnderscore(m)...).Record(m).Exec()
+	return err
+}
+
+func (t *threadDB) queryWithChannelID(channelID string) (*threadModel, error) {
+	var m *threadModel
+	_, err := t.session.Select("*").From("thread").Where("channel_id=? and is_deleted=0", channelID).Load(&m)
+	return m, err
+}
+
+func (t *threadDB) queryWithRootMessageID(parentChannelID string, parentChannelType uint8, rootMessageID string) (*threadModel, error) {
+	var m *threadModel
+	_, err := t.session.Select("*").From("thread").Where("parent_channel_id=? and parent_channel_type=? and root_message_id=?", parentChannelID, parentChannelType, rootMessageID).Load(&m)
+	return m, err
+}
+
+// 同步父频道下大于指定版本的话题
+func (t *threadDB) sync(parentChannelID string, parentChannelType uint8, version int64, limit uint64) ([]*threadModel, error) {
+	var models []*threadModel
+	_, err := t.session.Select("*").From("thread").Where("parent_channel_id=? and parent_channel_type=? and version>?", parentChannelID, parentChannelType, version).OrderAsc("version").Limit(limit).Load(&models)
+	return models, err
+}
+
+// 查询父频道下所有未删除话题的频道ID
+func (t *threadDB) queryChannelIDsWithParent(parentChannelID string, parentChannelType uint8) ([]string, error) {
+	var channelIDs []string
+	_, err := t.session.Select("channel_id").From("thread").Where("parent_channel_id=? and parent_channel_type=? and is_deleted=0", parentChannelID, parentChannelType).Load(&channelIDs)
+	return channelIDs, err
+}
+
+// 新增一条回复
+func (t *threadDB) updateReply(m *threadModel) error {
+	_, err := t.session.UpdateBySql("update thread set reply_count=reply_count+1,last_message_id=?,last_message_seq=?,last_reply_uid=?,last_reply_at=?,version=?,updated_at=NOW() where channel_id=?", m.LastMessageID, m.LastMessageSeq, m.LastReplyUID, m.LastReplyAt, m.Version, m.ChannelID).Exec()
+	return err
+}
+
+func (t *threadDB) updateName(channelID string, name string, version int64) error {
+	_, err := t.session.Update("thread").SetMap(map[string]interface{}{
+		"name":       name,
+		"version":    version,
+		"updated_at": dbr.Now,
+	}).Where("channel_id=?", channelID).Exec()
+	return err
+}
+
+func (t *threadDB) insertParticipant(channelID string, uid string) error {
+	_, err := t.session.InsertBySql("insert ignore into thread_participant (channel_id,uid) values (?,?)", channelID, uid).Exec()
+	return err
+}
+
+func (t *threadDB) insertParticipantTx(channelID string, uid string, tx *dbr.Tx) error {
+	_, err := tx.InsertBySql("insert ignore into thread_participant (channel_id,uid) values (?,?)", channelID, uid).Exec()
+	return err
+}
+
+func (t *threadDB) queryParticipantUIDs(channelID string) ([]string, error) {
+	var uids []string
+	_, err := t.session.Select("uid").From("thread_participant").Where("channel_id=?", channelID).Load(&uids)
+	return uids, err
+}
+
+type threadModel struct {
+	ChannelID         string
+	ParentChannelID   string
+	ParentChannelType uint8
+	RootMessageID     string
+	RootMessageSeq    uint32
+	Creator           string
+	Name              string
+	ReplyCount        int
+	LastMessageID     string
+	LastMessageSeq    uint32
+	LastReplyUID      string
+	LastReplyAt       int64
+	IsDeleted         int
+	Version           int64
+	db.BaseModel
+}
@@ -8,12 +8,14 @@ import (
 type service struct {
 	ctx              *config.Context
 	channelSettingDB *channelSettingDB
+	threadDB         *threadDB
 }
 
 func NewService(ctx *config.Context) chservice.IService {
 	return &service{
 		ctx:              ctx,
 		channelSettingDB: newChannelSettingDB(ctx),
+		threadDB:         newThreadDB(ctx),
 	}
 }
 
@@ -38,6 +40,34 @@ func (s *service) CreateOrUpdateMsgAutoDelete(channelID string, channelType uint
 	return s.channelSettingDB.insertOrAddMsgAutoDelete(channelID, channelType, msgAutoDelete)
 }
 
+func (s *service) GetThread(channelID string) (*chservice.ThreadResp, error) {
+	threadM, err := s.threadDB.queryWithChannelID(channelID)
+	if err != nil {
+		return nil, err
+	}
+	if threadM == nil {
+		return nil, nil
+	}
+	return &chservice.ThreadResp{
+		ChannelID:         threadM.ChannelID,
+		ParentChannelID:   threadM.ParentChannelID,
+		ParentChannelType: threadM.ParentChannelType,
+		RootMessageID:     threadM.RootMessageID,
+		RootMessageSeq:    threadM.RootMessageSeq,
+		Creator:           threadM.Creator,
+		Name:              threadM.Name,
+		ReplyCount:        threadM.ReplyCount,
+	}, nil
+}
+
+func (s *service) GetThreadParticipantUIDs(channelID string) ([]string, error) {
+	return s.threadDB.queryParticipantUIDs(channelID)
+}
+
+func (s *service) GetThreadChannelIDs(parentChannelID string, parentChannelType uint8) ([]string, error) {
+	return s.threadDB.queryChannelIDsWithParent(parentChannelID, parentChannelType)
+}
+
 func newChannelSettingResp(m *channelSettingModel) *chservice.ChannelSettingResp {
 
 	return &chservice.ChannelSettingResp{
@@ -1,10 +1,21 @@
 package service
 
+// ChannelTypeThread 话题频道（群消息下的子频道）
+// WuKongIM内置的频道类型占用了较小的值（如5为社区话题频道，有其专属的路由和存储逻辑），
+// 这里使用远离内置范围的自定义类型，由IM按普通订阅频道处理，订阅者由业务侧维护
+const ChannelTypeThread uint8 = 100
+
 type IService interface {
 	// 获取频道设置集合
 	GetChannelSettings(channelIDs []string) ([]*ChannelSettingResp, error)
 	// 创建或更新频道消息自动删除时间
 	CreateOrUpdateMsgAutoDelete(channelID string, channelType uint8, msgAutoDelete int64) error
+	// 获取话题（不存在返回nil）
+	GetThread(channelID string) (*ThreadResp, error)
+	// 获取话题参与者
+	GetThreadParticipantUIDs(channelID string) ([]string, error)
+	// 获取父频道下所有话题的频道ID（父频道的订阅者和黑白名单变化时需要同步到话题频道）
+	GetThreadChannelIDs(parentChannelID string, parentChannelType uint8) ([]string, error)
 }
 
 type ChannelSettingResp struct {
@@ -14,3 +25,14 @@ type ChannelSettingResp struct {
 	ParentChannelType uint8
 	OffsetMessageSeq  uint32
 }
+
+type ThreadResp struct {
+	ChannelID         string
+	ParentChannelID   string
+	ParentChannelType uint8
+	RootMessageID     string
+	RootMessageSeq    uint32
+	Creator           string
+	Name              string
+	ReplyCount        int
+}
@@ -0,0 +1,37 @@
+-- +migrate Up
+
+-- 话题（从群消息创建的子频道）
+create table `thread`(
+    id                      bigint                 not null primary key AUTO_INCREMENT,
+    channel_id              VARCHAR(80)            not null default '' COMMENT '话题频道ID',
+    parent_channel_id       VARCHAR(40)            not null default '' COMMENT '父频道ID（群编号）',
+    parent_channel_type     smallint               not null default 0  COMMENT '父频道类型',
+    root_message_id         VARCHAR(20)            not null default '' COMMENT '话题根消息ID',
+    root_message_seq        bigint                 not null default 0  COMMENT '话题根消息序号',
+    creator                 VARCHAR(40)            not null default '' COMMENT '创建者uid',
+    name                    VARCHAR(100)           not null default '' COMMENT '话题名称',
+    reply_count             integer                not null default 0  COMMENT '回复数量',
+    last_message_id         VARCHAR(20)            not null default '' COMMENT '最后一条回复的消息ID',
+    last_message_seq        bigint                 not null default 0  COMMENT '最后一条回复的消息序号',
+    last_reply_uid          VARCHAR(40)            not null default '' COMMENT '最后回复者uid',
+    last_reply_at           integer                not null default 0  COMMENT '最后回复时间（10位时间戳）',
+    is_deleted              smallint               not null default 0  COMMENT '是否已删除',
+    `version`               bigint                 not null default 0  COMMENT '同步版本号',
+    created_at              timeStamp              not null DEFAULT CURRENT_TIMESTAMP,      -- 创建时间
+    updated_at              timeStamp              not null DEFAULT CURRENT_TIMESTAMP       -- 更新时间
+);
+
+CREATE UNIQUE INDEX thread_channel_uidx on `thread` (channel_id);
+CREATE UNIQUE INDEX thread_root_message_uidx on `thread` (parent_channel_id,parent_channel_type,root_message_id);
+CREATE INDEX thread_parent_version_idx on `thread` (parent_channel_id,parent_channel_type,`version`);
+
+-- 话题参与者（创建者、根消息发送者和回复过的成员）
+create table `thread_participant`(
+    id                      bigint                 not null primary key AUTO_INCREMENT,
+    channel_id              VARCHAR(80)            not null default '' COMMENT '话题频道ID',
+    uid                     VARCHAR(40)            not null default '' COMMENT '参与者uid',
+    created_at              timeStamp              not null DEFAULT CURRENT_TIMESTAMP,      -- 创建时间
+    updated_at              timeStamp              not null DEFAULT CURRENT_TIMESTAMP       -- 更新时间
+);
+
+CREATE UNIQUE INDEX thread_participant_uidx on `thread_participant` (channel_id,uid);
@@ -0,0 +1,4 @@
+-- +migrate Up
+
+-- 话题频道类型由5（与WuKongIM的社区话题频道冲突）改为100
+UPDATE `channel_setting` SET channel_type=100 WHERE channel_type=5 AND channel_id IN (SELECT channel_id FROM `thread`);
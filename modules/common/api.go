@@ -334,6 +334,7 @@ func (cn *Common) appConfig(c *wkhttp.Context) {
 		InviteSystemAccountJoinGroupOn: appConfigM.InviteSystemAccountJoinGroupOn,
 		RegisterUserMustCompleteInfoOn: appConfigM.RegisterUserMustCompleteInfoOn,
 		CanModifyApiUrl:                appConfigM.CanModifyApiUrl,
+		MessageEditSecond:              appConfigM.MessageEditSecond,
 	})
 }
 
@@ -494,6 +495,7 @@ type appConfigResp struct {
 	InviteSystemAccountJoinGroupOn int    `json:"invite_system_account_join_group_on"` // 开启系统账号加入群聊
 	RegisterUserMustCompleteInfoOn int    `json:"register_user_must_complete_info_on"` // 注册用户必须填写完整信息
 	CanModifyApiUrl                int    `json:"can_modify_api_url"`                  // 允许修改api地址
+	MessageEditSecond              int    `json:"message_edit_second"`                 // 消息可编辑时长（单位秒） 0.不限制
 }
 
 type appVersionReq struct {
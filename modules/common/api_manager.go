@@ -38,6 +38,10 @@ func (m *Manager) Route(r *wkhttp.WKHttp) {
 		auth.PUT("/common/appmodule", m.updateAppModule)         // 修改app模块
 		auth.POST("/common/appmodule", m.addAppModule)           // 新增app模块
 		auth.DELETE("/common/:sid/appmodule", m.deleteAppModule) // 删除app模块
+		// #################### 邮件服务 ####################
+		auth.GET("/common/emailconfig", m.emailConfig)           // 获取邮件服务配置
+		auth.PUT("/common/emailconfig", m.updateEmailConfig)     // 修改邮件服务配置
+		auth.POST("/common/emailconfig/test", m.emailConfigTest) // 发送测试邮件
 	}
 }
 func (m *Manager) deleteAppModule(c *wkhttp.Context) {
@@ -204,6 +208,11 @@ func (m *Manager) updateConfig(c *wkhttp.Context) {
 		RegisterUserMustCompleteInfoOn int    `json:"register_user_must_complete_info_on"` // 注册用户必须填写完整信息
 		ChannelPinnedMessageMaxCount   int    `json:"channel_pinned_message_max_count"`    // 频道置顶消息最大数量
 		CanModifyApiUrl                int    `json:"can_modify_api_url"`                  // 是否可以修改api地址
+		MessageSearchLocalOn           int    `json:"message_search_local_on"`             // 是否使用内置消息搜索索引
+		MessageEditSecond              int    `json:"message_edit_second"`                 // 消息可编辑时长（单位秒） 0.不限制
+		ManagerTOTPOn                  int    `json:"manager_totp_on"`                     // 后台管理账号是否必须开启两步验证
+		WebauthnRPID                   string `json:"webauthn_rp_id"`                      // 通行密钥的依赖方ID（域名）
+		WebauthnOrigins                string `json:"webauthn_origins"`                    // 通行密钥允许的来源，多个用逗号分隔
 	}
 	var req reqVO
 	if err := c.BindJSON(&req); err != nil {
@@ -227,6 +236,11 @@ func (m *Manager) updateConfig(c *wkhttp.Context) {
 	configMap["register_user_must_complete_info_on"] = req.RegisterUserMustCompleteInfoOn
 	configMap["channel_pinned_message_max_count"] = req.ChannelPinnedMessageMaxCount
 	configMap["can_modify_api_url"] = req.CanModifyApiUrl
+	configMap["message_search_local_on"] = req.MessageSearchLocalOn
+	configMap["message_edit_second"] = req.MessageEditSecond
+	configMap["manager_totp_on"] = req.ManagerTOTPOn
+	configMap["webauthn_rp_id"] = strings.TrimSpace(req.WebauthnRPID)
+	configMap["webauthn_origins"] = strings.TrimSpace(req.WebauthnOrigins)
 	err = m.appconfigDB.updateWithMap(configMap, appConfigM.Id)
 	if err != nil {
 		m.Error("修改app配置信息错误", zap.Error(err))
@@ -257,6 +271,11 @@ func (m *Manager) appconfig(c *wkhttp.Context) {
 	var registerUserMustCompleteInfoOn = 0
 	var channelPinnedMessageMaxCount = 10
 	var canModifyApiUrl = 0
+	var messageSearchLocalOn = 0
+	var messageEditSecond = 0
+	var managerTOTPOn = 0
+	var webauthnRPID = ""
+	var webauthnOrigins = ""
 	if appconfig != nil {
 		revokeSecond = appconfig.RevokeSecond
 		welcomeMessage = appconfig.WelcomeMessage
@@ -268,6 +287,11 @@ func (m *Manager) appconfig(c *wkhttp.Context) {
 		registerUserMustCompleteInfoOn = appconfig.RegisterUserMustCompleteInfoOn
 		channelPinnedMessageMaxCount = appconfig.ChannelPinnedMessageMaxCount
 		canModifyApiUrl = appconfig.CanModifyApiUrl
+		messageSearchLocalOn = appconfig.MessageSearchLocalOn
+		messageEditSecond = appconfig.MessageEditSecond
+		managerTOTPOn = appconfig.ManagerTOTPOn
+		webauthnRPID = appconfig.WebauthnRpID
+		webauthnOrigins = appconfig.WebauthnOrigins
 	}
 	if revokeSecond == 0 {
 		revokeSecond = 120
@@ -286,6 +310,11 @@ func (m *Manager) appconfig(c *wkhttp.Context) {
 		RegisterUserMustCompleteInfoOn: registerUserMustCompleteInfoOn,
 		ChannelPinnedMessageMaxCount:   channelPinnedMessageMaxCount,
 		CanModifyApiUrl:                canModifyApiUrl,
+		MessageSearchLocalOn:           messageSearchLocalOn,
+		MessageEditSecond:              messageEditSecond,
+		ManagerTOTPOn:                  managerTOTPOn,
+		WebauthnRPID:                   webauthnRPID,
+		WebauthnOrigins:                webauthnOrigins,
 	})
 }
 
@@ -300,6 +329,11 @@ type managerAppConfigResp struct {
 	RegisterUserMustCompleteInfoOn int    `json:"register_user_must_complete_info_on"` // 注册用户必须填写完整信息
 	ChannelPinnedMessageMaxCount   int    `json:"channel_pinned_message_max_count"`    // 频道置顶消息最大数量
 	CanModifyApiUrl                int    `json:"can_modify_api_url"`                  // 是否可以修改api地址
+	MessageSearchLocalOn           int    `json:"message_search_local_on"`             // 是否使用内置消息搜索索引
+	MessageEditSecond              int    `json:"message_edit_second"`                 // 消息可编辑时长（单位秒） 0.不限制
+	ManagerTOTPOn                  int    `json:"manager_totp_on"`                     // 后台管理账号是否必须开启两步验证
+	WebauthnRPID                   string `json:"webauthn_rp_id"`                      // 通行密钥的依赖方ID（域名）
+	WebauthnOrigins                string `json:"webauthn_origins"`                    // 通行密钥允许的来源，多个用逗号分隔
 }
 
 type managerAppModule struct {
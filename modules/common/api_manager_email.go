@@ -0,0 +1,205 @@
+package common
+
+import (
+	"context"
+	"errors"
+	"net/mail"
+	"strings"
+	"time"
+
+	commonapi "github.com/TangSengDaoDao/TangSengDaoDaoServer/modules/base/common"
+	"github.com/TangSengDaoDao/TangSengDaoDaoServerLib/pkg/wkhttp"
+	"go.uber.org/zap"
+)
+
+type managerEmailConfigResp struct {
+	EmailOn           int    `json:"email_on"`           // 是否开启邮件验证码
+	SMTPHost          string `json:"smtp_host"`          // SMTP服务器地址
+	SMTPPort          int    `json:"smtp_port"`          // SMTP端口 0.按加密方式使用默认端口
+	SMTPUsername      string `json:"smtp_username"`      // SMTP用户名
+	SMTPPasswordSet   int    `json:"smtp_password_set"`  // 是否已设置SMTP密码（密码不返回）
+	SMTPSecure        int    `json:"smtp_secure"`        // 加密方式 0.自动 1.SSL/TLS 2.STARTTLS
+	From              string `json:"from"`               // 发件人邮箱
+	FromName          string `json:"from_name"`          // 发件人名称
+	CodeSubject       string `json:"code_subject"`       // 验证码邮件标题模版
+	CodeTemplate      string `json:"code_template"`      // 验证码邮件正文模版
+	DefaultSubject    string `json:"default_subject"`    // 默认的标题模版
+	DefaultTemplate   string `json:"default_template"`   // 默认的正文模版
+	TemplateVariables string `json:"template_variables"` // 模版可以使用的变量
+}
+
+// 获取邮件服务配置
+func (m *Manager) emailConfig(c *wkhttp.Context) {
+	err := c.CheckLoginRole()
+	if err != nil {
+		c.ResponseError(err)
+		return
+	}
+	appconfig, err := m.appconfigDB.query()
+	if err != nil {
+		m.Error("查询应用配置失败！", zap.Error(err))
+		c.ResponseError(errors.New("查询应用配置失败！"))
+		return
+	}
+	resp := &managerEmailConfigResp{
+		DefaultSubject:    commonapi.DefaultEmailCodeSubject,
+		DefaultTemplate:   commonapi.DefaultEmailCodeTemplate,
+		TemplateVariables: "{{.Code}} 验证码，{{.Purpose}} 用途，{{.ExpireMinutes}} 有效分钟数",
+	}
+	if appconfig != nil {
+		resp.EmailOn = appconfig.EmailOn
+		resp.SMTPHost = appconfig.EmailSMTPHost
+		resp.SMTPPort = appconfig.EmailSMTPPort
+		resp.SMTPUsername = appconfig.EmailSMTPUsername
+		resp.SMTPSecure = appconfig.EmailSMTPSecure
+		resp.From = appconfig.EmailFrom
+		resp.FromName = appconfig.EmailFromName
+		resp.CodeSubject = appconfig.EmailCodeSubject
+		resp.CodeTemplate = appconfig.EmailCodeTemplate
+		if appconfig.EmailSMTPPassword != "" {
+			resp.SMTPPasswordSet = 1
+		}
+	}
+	c.Response(resp)
+}
+
+// 修改邮件服务配置
+func (m *Manager) updateEmailConfig(c *wkhttp.Context) {
+	err := c.CheckLoginRoleIsSuperAdmin()
+	if err != nil {
+		c.ResponseError(err)
+		return
+	}
+	var req struct {
+		EmailOn      int    `json:"email_on"`
+		SMTPHost     string `json:"smtp_host"`
+		SMTPPort     int    `json:"smtp_port"`
+		SMTPUsername string `json:"smtp_username"`
+		SMTPPassword string `json:"smtp_password"` // 为空时不修改
+		SMTPSecure   int    `json:"smtp_secure"`
+		From         string `json:"from"`
+		FromName     string `json:"from_name"`
+		CodeSubject  string `json:"code_subject"`
+		CodeTemplate string `json:"code_template"`
+	}
+	if err := c.BindJSON(&req); err != nil {
+		c.ResponseError(errors.New("请求数据格式有误！"))
+		return
+	}
+	req.SMTPHost = strings.TrimSpace(req.SMTPHost)
+	req.From = strings.TrimSpace(req.From)
+	if req.SMTPPort < 0 || req.SMTPPort > 65535 {
+		c.ResponseError(errors.New("SMTP端口有误！"))
+		return
+	}
+	if req.SMTPSecure < commonapi.EmailSMTPTLSAuto || req.SMTPSecure > commonapi.EmailSMTPTLSStartTLS {
+		c.ResponseError(errors.New("加密方式有误！"))
+		return
+	}
+	if req.EmailOn == 1 {
+		if req.SMTPHost == "" {
+			c.ResponseError(errors.New("SMTP服务器地址不能为空！"))
+			return
+		}
+		if req.From == "" {
+			c.ResponseError(errors.New("发件人邮箱不能为空！"))
+			return
+		}
+	}
+	if req.From != "" {
+		if addr, err := mail.ParseAddress(req.From); err != nil || addr.Address != req.From {
+			c.ResponseError(errors.New("发件人邮箱格式有误！"))
+			return
+		}
+	}
+	if strings.ContainsAny(req.FromName, "\r\n") {
+		c.ResponseError(errors.New("发件人名称不能包含换行！"))
+		return
+	}
+	_, _, err = commonapi.RenderVerifyCodeEmail(req.CodeSubject, req.CodeTemplate, &commonapi.VerifyCodeEmailData{Code: "0000", Purpose: "注册", ExpireMinutes: 5})
+	if err != nil {
+		c.ResponseError(err)
+		return
+	}
+	appConfigM, err := m.appconfigDB.query()
+	if err != nil {
+		m.Error("查询应用配置失败！", zap.Error(err))
+		c.ResponseError(errors.New("查询应用配置失败！"))
+		return
+	}
+	if appConfigM == nil {
+		c.ResponseError(errors.New("应用配置不存在！"))
+		return
+	}
+	configMap := map[string]interface{}{}
+	configMap["email_on"] = req.EmailOn
+	configMap["email_smtp_host"] = req.SMTPHost
+	configMap["email_smtp_port"] = req.SMTPPort
+	configMap["email_smtp_username"] = strings.TrimSpace(req.SMTPUsername)
+	configMap["email_smtp_secure"] = req.SMTPSecure
+	configMap["email_from"] = req.From
+	configMap["email_from_name"] = strings.TrimSpace(req.FromName)
+	configMap["email_code_subject"] = strings.TrimSpace(req.CodeSubject)
+	configMap["email_code_template"] = strings.TrimSpace(req.CodeTemplate)
+	if req.SMTPPassword != "" {
+		configMap["email_smtp_password"] = req.SMTPPassword
+	}
+	err = m.appconfigDB.updateWithMap(configMap, appConfigM.Id)
+	if err != nil {
+		m.Error("修改邮件服务配置错误", zap.Error(err))
+		c.ResponseError(errors.New("修改邮件服务配置错误"))
+		return
+	}
+	c.ResponseOK()
+}
+
+// 用已保存的配置发送一封测试邮件
+func (m *Manager) emailConfigTest(c *wkhttp.Context) {
+	err := c.CheckLoginRoleIsSuperAdmin()
+	if err != nil {
+		c.ResponseError(err)
+		return
+	}
+	var req struct {
+		Email string `json:"email"`
+	}
+	if err := c.BindJSON(&req); err != nil {
+		c.ResponseError(errors.New("请求数据格式有误！"))
+		return
+	}
+	req.Email = strings.TrimSpace(req.Email)
+	if addr, err := mail.ParseAddress(req.Email); err != nil || addr.Address != req.Email {
+		c.ResponseError(errors.New("邮箱格式有误！"))
+		return
+	}
+	appConfigM, err := m.appconfigDB.query()
+	if err != nil {
+		m.Error("查询应用配置失败！", zap.Error(err))
+		c.ResponseError(errors.New("查询应用配置失败！"))
+		return
+	}
+	if appConfigM == nil {
+		c.ResponseError(errors.New("应用配置不存在！"))
+		return
+	}
+	cfg := newEmailConfig(appConfigM)
+	cfg.On = true // 未开启时也允许测试
+	if !cfg.Enabled() {
+		c.ResponseError(errors.New("请先设置SMTP服务器地址和发件人邮箱！"))
+		return
+	}
+	subject, body, err := commonapi.RenderVerifyCodeEmail(cfg.CodeSubject, cfg.CodeTemplate, &commonapi.VerifyCodeEmailData{Code: "0000", Purpose: "测试", ExpireMinutes: 5})
+	if err != nil {
+		c.ResponseError(err)
+		return
+	}
+	ctx, cancel := context.WithTimeout(c.Request.Context(), time.Second*30)
+	defer cancel()
+	err = commonapi.NewSMTPProvider(cfg).SendEmail(ctx, req.Email, subject, body)
+	if err != nil {
+		m.Warn("发送测试邮件失败！", zap.Error(err))
+		c.ResponseError(errors.New("发送测试邮件失败：" + err.Error()))
+		return
+	}
+	c.ResponseOK()
+}
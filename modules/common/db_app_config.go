@@ -51,5 +51,20 @@ type appConfigModel struct {
 	RegisterUserMustCompleteInfoOn int    // 注册用户是否必须完善个人信息
 	ChannelPinnedMessageMaxCount   int    // 频道置顶消息最大数量
 	CanModifyApiUrl                int    // 是否可以修改API地址
+	MessageSearchLocalOn           int    // 是否使用内置消息搜索索引
+	MessageEditSecond              int    // 消息可编辑时长（单位秒） 0.不限制
+	ManagerTOTPOn                  int    // 后台管理账号是否必须开启两步验证
+	WebauthnRpID                   string // 通行密钥的依赖方ID（域名），为空时使用网页登录地址的域名（字段名对应webauthn_rp_id列）
+	WebauthnOrigins                string // 通行密钥允许的来源，多个用逗号分隔
+	EmailOn                        int    // 是否开启邮件验证码
+	EmailSMTPHost                  string // SMTP服务器地址
+	EmailSMTPPort                  int    // SMTP端口 0.按加密方式使用默认端口
+	EmailSMTPUsername              string // SMTP用户名
+	EmailSMTPPassword              string // SMTP密码
+	EmailSMTPSecure                int    // SMTP加密方式 0.自动 1.SSL/TLS 2.STARTTLS
+	EmailFrom                      string // 发件人邮箱
+	EmailFromName                  string // 发件人名称
+	EmailCodeSubject               string // 验证码邮件标题模版
+	EmailCodeTemplate              string // 验证码邮件正文模版
 	ldb.BaseModel
 }
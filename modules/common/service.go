@@ -7,6 +7,7 @@ import (
 	"sync"
 	"time"
 
+	commonapi "github.com/TangSengDaoDao/TangSengDaoDaoServer/modules/base/common"
 	"github.com/TangSengDaoDao/TangSengDaoDaoServerLib/config"
 	"go.uber.org/zap"
 )
@@ -16,6 +17,8 @@ var onceSerce sync.Once
 // IService IService
 type IService interface {
 	GetAppConfig() (*AppConfigResp, error)
+	// 获取邮件服务配置
+	GetEmailConfig() (*commonapi.EmailConfig, error)
 	// 获取短编号
 	GetShortno() (string, error)
 	SetShortnoUsed(shortno string, business string) error
@@ -67,9 +70,41 @@ func (s *service) GetAppConfig() (*AppConfigResp, error) {
 		InviteSystemAccountJoinGroupOn: appConfigM.InviteSystemAccountJoinGroupOn,
 		RegisterUserMustCompleteInfoOn: appConfigM.RegisterUserMustCompleteInfoOn,
 		ChannelPinnedMessageMaxCount:   appConfigM.ChannelPinnedMessageMaxCount,
+		MessageSearchLocalOn:           appConfigM.MessageSearchLocalOn,
+		MessageEditSecond:              appConfigM.MessageEditSecond,
+		ManagerTOTPOn:                  appConfigM.ManagerTOTPOn,
+		WebauthnRPID:                   appConfigM.WebauthnRpID,
+		WebauthnOrigins:                appConfigM.WebauthnOrigins,
 	}, nil
 }
 
+// GetEmailConfig 获取邮件服务配置
+func (s *service) GetEmailConfig() (*commonapi.EmailConfig, error) {
+	appConfigM, err := s.appConfigDB.query()
+	if err != nil {
+		return nil, err
+	}
+	if appConfigM == nil {
+		return &commonapi.EmailConfig{}, nil
+	}
+	return newEmailConfig(appConfigM), nil
+}
+
+func newEmailConfig(m *appConfigModel) *commonapi.EmailConfig {
+	return &commonapi.EmailConfig{
+		On:           m.EmailOn == 1,
+		SMTPHost:     m.EmailSMTPHost,
+		SMTPPort:     m.EmailSMTPPort,
+		SMTPUsername: m.EmailSMTPUsername,
+		SMTPPassword: m.EmailSMTPPassword,
+		SMTPTLS:      m.EmailSMTPSecure,
+		From:         m.EmailFrom,
+		FromName:     m.EmailFromName,
+		CodeSubject:  m.EmailCodeSubject,
+		CodeTemplate: m.EmailCodeTemplate,
+	}
+}
+
 func (s *service) GetShortno() (string, error) {
 
 	s.shortnoLock.Lock() // 这里需要加锁 要不然多线程下会出现shortNo重复的问题
@@ -140,4 +175,9 @@ type AppConfigResp struct {
 	InviteSystemAccountJoinGroupOn int    // 是否允许邀请系统账号进入群聊
 	RegisterUserMustCompleteInfoOn int    // 是否要求注册用户必须填写完整信息
 	ChannelPinnedMessageMaxCount   int    // 频道置顶消息最大数量
+	MessageSearchLocalOn           int    // 是否使用内置消息搜索索引
+	MessageEditSecond              int    // 消息可编辑时长（单位秒） 0.不限制
+	ManagerTOTPOn                  int    // 后台管理账号是否必须开启两步验证
+	WebauthnRPID                   string // 通行密钥的依赖方ID（域名）
+	WebauthnOrigins                string // 通行密钥允许的来源，多个用逗号分隔
 }
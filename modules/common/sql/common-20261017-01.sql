@@ -0,0 +1,3 @@
+-- +migrate Up
+
+ALTER TABLE `app_config` ADD COLUMN message_search_local_on smallint not null DEFAULT 0 COMMENT '是否使用内置消息搜索索引';
@@ -0,0 +1,3 @@
+-- +migrate Up
+
+ALTER TABLE `app_config` ADD COLUMN message_edit_second integer not null DEFAULT 0 COMMENT '消息可编辑时长（单位秒） 0.不限制';
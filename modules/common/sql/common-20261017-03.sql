@@ -0,0 +1,3 @@
+-- +migrate Up
+
+ALTER TABLE `app_config` ADD COLUMN manager_totp_on smallint not null DEFAULT 0 COMMENT '后台管理账号是否必须开启两步验证';
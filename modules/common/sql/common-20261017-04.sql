@@ -0,0 +1,4 @@
+-- +migrate Up
+
+ALTER TABLE `app_config` ADD COLUMN webauthn_rp_id VARCHAR(100) not null DEFAULT '' COMMENT '通行密钥的依赖方ID（域名）';
+ALTER TABLE `app_config` ADD COLUMN webauthn_origins VARCHAR(1000) not null DEFAULT '' COMMENT '通行密钥允许的来源，多个用逗号分隔';
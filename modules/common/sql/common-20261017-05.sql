@@ -0,0 +1,12 @@
+-- +migrate Up
+
+ALTER TABLE `app_config` ADD COLUMN email_on smallint not null DEFAULT 0 COMMENT '是否开启邮件验证码';
+ALTER TABLE `app_config` ADD COLUMN email_smtp_host VARCHAR(100) not null DEFAULT '' COMMENT 'SMTP服务器地址';
+ALTER TABLE `app_config` ADD COLUMN email_smtp_port int not null DEFAULT 0 COMMENT 'SMTP端口 0.按加密方式使用默认端口';
+ALTER TABLE `app_config` ADD COLUMN email_smtp_username VARCHAR(100) not null DEFAULT '' COMMENT 'SMTP用户名';
+ALTER TABLE `app_config` ADD COLUMN email_smtp_password VARCHAR(255) not null DEFAULT '' COMMENT 'SMTP密码';
+ALTER TABLE `app_config` ADD COLUMN email_smtp_secure smallint not null DEFAULT 0 COMMENT 'SMTP加密方式 0.自动 1.SSL/TLS 2.STARTTLS';
+ALTER TABLE `app_config` ADD COLUMN email_from VARCHAR(100) not null DEFAULT '' COMMENT '发件人邮箱';
+ALTER TABLE `app_config` ADD COLUMN email_from_name VARCHAR(100) not null DEFAULT '' COMMENT '发件人名称';
+ALTER TABLE `app_config` ADD COLUMN email_code_subject VARCHAR(255) not null DEFAULT '' COMMENT '验证码邮件标题模版';
+ALTER TABLE `app_config` ADD COLUMN email_code_template VARCHAR(2000) not null DEFAULT '' COMMENT '验证码邮件正文模版';
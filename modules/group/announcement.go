@@ -0,0 +1,453 @@
+package group
+
+import (
+	"errors"
+	"html"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/TangSengDaoDao/TangSengDaoDaoServer/pkg/markdown"
+	"github.com/TangSengDaoDao/TangSengDaoDaoServerLib/common"
+	"github.com/TangSengDaoDao/TangSengDaoDaoServerLib/config"
+	"github.com/TangSengDaoDao/TangSengDaoDaoServerLib/pkg/util"
+	"github.com/TangSengDaoDao/TangSengDaoDaoServerLib/pkg/wkhttp"
+	"go.uber.org/zap"
+)
+
+const (
+	announcementTitleMaxLen   = 100  // 公告标题的最大长度
+	announcementContentMaxLen = 5000 // 公告内容的最大长度
+)
+
+// 发布群公告（群主和管理员）
+func (g *Group) announcementAdd(c *wkhttp.Context) {
+	loginUID := c.GetLoginUID()
+	groupNo := c.Param("group_no")
+	var req announcementReq
+	if err := c.BindJSON(&req); err != nil {
+		c.ResponseError(errors.New("数据格式有误！"))
+		return
+	}
+	if err := req.check(); err != nil {
+		c.ResponseError(err)
+		return
+	}
+	if err := g.checkAnnouncementManager(groupNo, loginUID); err != nil {
+		c.ResponseError(err)
+		return
+	}
+	now := time.Now().UnixMilli()
+	m := &announcementModel{
+		AnnouncementNo: util.GenerUUID(),
+		GroupNo:        groupNo,
+		Title:          req.Title,
+		Content:        req.Content,
+		NeedConfirm:    req.NeedConfirm,
+		Creator:        loginUID,
+		Editor:         loginUID,
+		Version:        g.ctx.GenSeq(common.GroupSeqKey),
+	}
+	if req.Pinned == 1 {
+		m.Pinned = 1
+		m.PinnedAt = now
+	}
+	err := g.db.insertAnnouncement(m)
+	if err != nil {
+		g.Error("添加群公告失败！", zap.Error(err))
+		c.ResponseError(errors.New("添加群公告失败！"))
+		return
+	}
+	g.sendSyncAnnouncementCMD(groupNo, loginUID)
+	c.Response(map[string]interface{}{
+		"announcement_no": m.AnnouncementNo,
+	})
+}
+
+// 编辑群公告（群主和管理员）
+func (g *Group) announcementUpdate(c *wkhttp.Context) {
+	loginUID := c.GetLoginUID()
+	groupNo := c.Param("group_no")
+	var req announcementReq
+	if err := c.BindJSON(&req); err != nil {
+		c.ResponseError(errors.New("数据格式有误！"))
+		return
+	}
+	if err := req.check(); err != nil {
+		c.ResponseError(err)
+		return
+	}
+	m, err := g.getManagedAnnouncement(groupNo, c.Param("announcement_no"), loginUID)
+	if err != nil {
+		c.ResponseError(err)
+		return
+	}
+	now := time.Now().UnixMilli()
+	m.Title = req.Title
+	m.Content = req.Content
+	m.NeedConfirm = req.NeedConfirm
+	if req.Pinned == 1 && m.Pinned == 0 {
+		m.PinnedAt = now
+	}
+	if req.Pinned == 0 {
+		m.PinnedAt = 0
+	}
+	m.Pinned = req.Pinned
+	m.Editor = loginUID
+	m.Version = g.ctx.GenSeq(common.GroupSeqKey)
+	err = g.db.updateAnnouncement(m)
+	if err != nil {
+		g.Error("修改群公告失败！", zap.Error(err))
+		c.ResponseError(errors.New("修改群公告失败！"))
+		return
+	}
+	g.sendSyncAnnouncementCMD(groupNo, loginUID)
+	c.ResponseOK()
+}
+
+// 置顶或取消置顶群公告（群主和管理员）
+func (g *Group) announcementPinned(c *wkhttp.Context) {
+	loginUID := c.GetLoginUID()
+	groupNo := c.Param("group_no")
+	on, _ := strconv.Atoi(c.Param("on"))
+	if on != 0 && on != 1 {
+		c.ResponseError(errors.New("参数有误！"))
+		return
+	}
+	m, err := g.getManagedAnnouncement(groupNo, c.Param("announcement_no"), loginUID)
+	if err != nil {
+		c.ResponseError(err)
+		return
+	}
+	if m.Pinned == on {
+		c.ResponseOK()
+		return
+	}
+	now := time.Now().UnixMilli()
+	m.Pinned = on
+	m.PinnedAt = 0
+	if on == 1 {
+		m.PinnedAt = now
+	}
+	m.Version = g.ctx.GenSeq(common.GroupSeqKey)
+	err = g.db.updateAnnouncement(m)
+	if err != nil {
+		g.Error("修改群公告置顶状态失败！", zap.Error(err))
+		c.ResponseError(errors.New("修改群公告置顶状态失败！"))
+		return
+	}
+	g.sendSyncAnnouncementCMD(groupNo, loginUID)
+	c.ResponseOK()
+}
+
+// 删除群公告（群主和管理员）
+func (g *Group) announcementDelete(c *wkhttp.Context) {
+	loginUID := c.GetLoginUID()
+	groupNo := c.Param("group_no")
+	m, err := g.getManagedAnnouncement(groupNo, c.Param("announcement_no"), loginUID)
+	if err != nil {
+		c.ResponseError(err)
+		return
+	}
+	// 只标记删除，客户端通过同步得知公告已删除
+	m.IsDeleted = 1
+	m.Pinned = 0
+	m.PinnedAt = 0
+	m.Editor = loginUID
+	m.Version = g.ctx.GenSeq(common.GroupSeqKey)
+	err = g.db.updateAnnouncement(m)
+	if err != nil {
+		g.Error("删除群公告失败！", zap.Error(err))
+		c.ResponseError(errors.New("删除群公告失败！"))
+		return
+	}
+	g.sendSyncAnnouncementCMD(groupNo, loginUID)
+	c.ResponseOK()
+}
+
+// 同步群公告（群成员）
+func (g *Group) announcementSync(c *wkhttp.Context) {
+	loginUID := c.GetLoginUID()
+	groupNo := c.Param("group_no")
+	limit, _ := strconv.ParseUint(c.Query("limit"), 10, 64)
+	if limit <= 0 {
+		limit = 100
+	}
+	version, _ := strconv.ParseInt(c.Query("version"), 10, 64)
+	isMember, err := g.db.ExistMember(loginUID, groupNo)
+	if err != nil {
+		g.Error("查询是否在群内失败！", zap.Error(err))
+		c.ResponseError(errors.New("查询是否在群内失败！"))
+		return
+	}
+	if !isMember {
+		c.ResponseError(errors.New("不在群内，不能查看群公告！"))
+		return
+	}
+	models, err := g.db.syncAnnouncements(groupNo, version, limit)
+	if err != nil {
+		g.Error("同步群公告失败！", zap.Error(err))
+		c.ResponseError(errors.New("同步群公告失败！"))
+		return
+	}
+	resps := make([]*announcementResp, 0, len(models))
+	if len(models) == 0 {
+		c.Response(resps)
+		return
+	}
+	announcementNos := make([]string, 0, len(models))
+	for _, m := range models {
+		announcementNos = append(announcementNos, m.AnnouncementNo)
+	}
+	confirmedNos, err := g.db.queryConfirmedAnnouncementNos(announcementNos, loginUID)
+	if err != nil {
+		g.Error("查询已确认的群公告失败！", zap.Error(err))
+		c.ResponseError(errors.New("查询已确认的群公告失败！"))
+		return
+	}
+	confirmCountMap, err := g.db.queryAnnouncementConfirmCounts(announcementNos)
+	if err != nil {
+		g.Error("查询群公告确认人数失败！", zap.Error(err))
+		c.ResponseError(errors.New("查询群公告确认人数失败！"))
+		return
+	}
+	confirmedMap := make(map[string]bool, len(confirmedNos))
+	for _, confirmedNo := range confirmedNos {
+		confirmedMap[confirmedNo] = true
+	}
+	for _, m := range models {
+		resp := newAnnouncementResp(m)
+		if confirmedMap[m.AnnouncementNo] {
+			resp.Confirmed = 1
+		}
+		resp.ConfirmCount = confirmCountMap[m.AnnouncementNo]
+		resps = append(resps, resp)
+	}
+	c.Response(resps)
+}
+
+// 确认已读群公告（群成员）
+func (g *Group) announcementConfirm(c *wkhttp.Context) {
+	loginUID := c.GetLoginUID()
+	groupNo := c.Param("group_no")
+	isMember, err := g.db.ExistMember(loginUID, groupNo)
+	if err != nil {
+		g.Error("查询是否在群内失败！", zap.Error(err))
+		c.ResponseError(errors.New("查询是否在群内失败！"))
+		return
+	}
+	if !isMember {
+		c.ResponseError(errors.New("不在群内，不能确认群公告！"))
+		return
+	}
+	m, err := g.getAnnouncement(groupNo, c.Param("announcement_no"))
+	if err != nil {
+		c.ResponseError(err)
+		return
+	}
+	if m.NeedConfirm != 1 {
+		c.ResponseError(errors.New("该公告不需要确认！"))
+		return
+	}
+	confirmed, err := g.db.existAnnouncementConfirm(m.AnnouncementNo, loginUID)
+	if err != nil {
+		g.Error("查询是否已确认群公告失败！", zap.Error(err))
+		c.ResponseError(errors.New("查询是否已确认群公告失败！"))
+		return
+	}
+	if confirmed {
+		c.ResponseOK()
+		return
+	}
+	err = g.db.insertAnnouncementConfirm(&announcementConfirmModel{
+		AnnouncementNo: m.AnnouncementNo,
+		GroupNo:        groupNo,
+		UID:            loginUID,
+	})
+	if err != nil {
+		g.Error("添加群公告确认记录失败！", zap.Error(err))
+		c.ResponseError(errors.New("添加群公告确认记录失败！"))
+		return
+	}
+	c.ResponseOK()
+}
+
+// 群公告的确认情况（群主和管理员）confirmed=1查询已确认的成员，confirmed=0查询未确认的成员
+func (g *Group) announcementConfirms(c *wkhttp.Context) {
+	loginUID := c.GetLoginUID()
+	groupNo := c.Param("group_no")
+	confirmed := c.Query("confirmed") != "0"
+	pageIndex, pageSize := c.GetPage()
+	m, err := g.getManagedAnnouncement(groupNo, c.Param("announcement_no"), loginUID)
+	if err != nil {
+		c.ResponseError(err)
+		return
+	}
+	var (
+		models []*announcementConfirmMemberModel
+		count  int64
+	)
+	if confirmed {
+		models, err = g.db.queryAnnouncementConfirmedMembers(groupNo, m.AnnouncementNo, uint64(pageIndex), uint64(pageSize))
+		if err == nil {
+			count, err = g.db.queryAnnouncementConfirmedMemberCount(groupNo, m.AnnouncementNo)
+		}
+	} else {
+		models, err = g.db.queryAnnouncementUnconfirmedMembers(groupNo, m.AnnouncementNo, uint64(pageIndex), uint64(pageSize))
+		if err == nil {
+			count, err = g.db.queryAnnouncementUnconfirmedMemberCount(groupNo, m.AnnouncementNo)
+		}
+	}
+	if err != nil {
+		g.Error("查询群公告确认情况失败！", zap.Error(err))
+		c.ResponseError(errors.New("查询群公告确认情况失败！"))
+		return
+	}
+	list := make([]*announcementConfirmResp, 0, len(models))
+	for _, model := range models {
+		resp := &announcementConfirmResp{
+			UID:  model.UID,
+			Name: model.Name,
+		}
+		if confirmed {
+			resp.ConfirmedAt = model.ConfirmedAt.String()
+		}
+		list = append(list, resp)
+	}
+	c.Response(map[string]interface{}{
+		"count": count,
+		"list":  list,
+	})
+}
+
+// 只有群主和管理员可以管理群公告
+func (g *Group) checkAnnouncementManager(groupNo string, uid string) error {
+	_, err := g.getGroupInfo(groupNo)
+	if err != nil {
+		return err
+	}
+	canManage, err := g.permission.hasPermission(groupNo, uid, PermissionAnnouncement)
+	if err != nil {
+		g.Error("查询群内权限失败！", zap.Error(err))
+		return errors.New("查询群内权限失败！")
+	}
+	if !canManage {
+		return errors.New("没有管理群公告的权限！")
+	}
+	return nil
+}
+
+// 获取未删除的群公告
+func (g *Group) getAnnouncement(groupNo, announcementNo string) (*announcementModel, error) {
+	m, err := g.db.queryAnnouncementWithAnnouncementNo(announcementNo)
+	if err != nil {
+		g.Error("查询群公告失败！", zap.Error(err))
+		return nil, errors.New("查询群公告失败！")
+	}
+	if m == nil || m.GroupNo != groupNo || m.IsDeleted == 1 {
+		return nil, errors.New("群公告不存在！")
+	}
+	return m, nil
+}
+
+// 获取群公告并校验管理权限
+func (g *Group) getManagedAnnouncement(groupNo, announcementNo, loginUID string) (*announcementModel, error) {
+	if err := g.checkAnnouncementManager(groupNo, loginUID); err != nil {
+		return nil, err
+	}
+	return g.getAnnouncement(groupNo, announcementNo)
+}
+
+// 通知群成员同步群公告
+func (g *Group) sendSyncAnnouncementCMD(groupNo string, fromUID string) {
+	err := g.ctx.SendCMD(config.MsgCMDReq{
+		NoPersist:   true,
+		ChannelID:   groupNo,
+		ChannelType: common.ChannelTypeGroup.Uint8(),
+		FromUID:     fromUID,
+		CMD:         CMDSyncGroupAnnouncement,
+		Param: map[string]interface{}{
+			"group_no": groupNo,
+		},
+	})
+	if err != nil {
+		g.Warn("发送同步群公告命令失败！", zap.Error(err))
+	}
+}
+
+type announcementReq struct {
+	Title       string `json:"title"`        // 标题
+	Content     string `json:"content"`      // 内容（markdown）
+	Pinned      int    `json:"pinned"`       // 是否置顶 0.否 1.是
+	NeedConfirm int    `json:"need_confirm"` // 是否需要成员确认 0.否 1.是
+}
+
+func (r *announcementReq) check() error {
+	r.Title = strings.TrimSpace(r.Title)
+	r.Content = strings.TrimSpace(r.Content)
+	if r.Content == "" {
+		return errors.New("公告内容不能为空！")
+	}
+	if utf8.RuneCountInString(r.Title) > announcementTitleMaxLen {
+		return errors.New("公告标题不能超过" + strconv.Itoa(announcementTitleMaxLen) + "个字！")
+	}
+	if utf8.RuneCountInString(r.Content) > announcementContentMaxLen {
+		return errors.New("公告内容不能超过" + strconv.Itoa(announcementContentMaxLen) + "个字！")
+	}
+	if r.Pinned != 0 && r.Pinned != 1 {
+		return errors.New("是否置顶的值有误！")
+	}
+	if r.NeedConfirm != 0 && r.NeedConfirm != 1 {
+		return errors.New("是否需要确认的值有误！")
+	}
+	return nil
+}
+
+type announcementResp struct {
+	AnnouncementNo string `json:"announcement_no"`
+	GroupNo        string `json:"group_no"`
+	Title          string `json:"title"`         // 标题
+	Content        string `json:"content"`       // 内容（markdown原文，用于编辑）
+	ContentHTML    string `json:"content_html"`  // 渲染后的内容
+	Pinned         int    `json:"pinned"`        // 是否置顶
+	PinnedAt       int64  `json:"pinned_at"`     // 置顶时间（13位时间戳）
+	NeedConfirm    int    `json:"need_confirm"`  // 是否需要成员确认
+	Confirmed      int    `json:"confirmed"`     // 当前用户是否已确认
+	ConfirmCount   int    `json:"confirm_count"` // 已确认人数
+	Creator        string `json:"creator"`       // 发布者uid
+	Editor         string `json:"editor"`        // 最后编辑者uid
+	Version        int64  `json:"version"`       // 数据版本
+	IsDeleted      int    `json:"is_deleted"`    // 是否已删除
+	CreatedAt      string `json:"created_at"`
+	UpdatedAt      string `json:"updated_at"`
+}
+
+func newAnnouncementResp(m *announcementModel) *announcementResp {
+	resp := &announcementResp{
+		AnnouncementNo: m.AnnouncementNo,
+		GroupNo:        m.GroupNo,
+		Pinned:         m.Pinned,
+		PinnedAt:       m.PinnedAt,
+		NeedConfirm:    m.NeedConfirm,
+		Creator:        m.Creator,
+		Editor:         m.Editor,
+		Version:        m.Version,
+		IsDeleted:      m.IsDeleted,
+		CreatedAt:      m.CreatedAt.String(),
+		UpdatedAt:      m.UpdatedAt.String(),
+	}
+	if m.IsDeleted == 0 {
+		resp.Title = m.Title
+		resp.Content = m.Content
+		// 先转义再渲染，避免公告内容中的html被执行
+		resp.ContentHTML = markdown.ToHtml(html.EscapeString(m.Content))
+	}
+	return resp
+}
+
+type announcementConfirmResp struct {
+	UID         string `json:"uid"`
+	Name        string `json:"name"`
+	ConfirmedAt string `json:"confirmed_at,omitempty"` // 确认时间
+}
@@ -0,0 +1,136 @@
+package group
+
+import (
+	"github.com/TangSengDaoDao/TangSengDaoDaoServerLib/pkg/db"
+	"github.com/TangSengDaoDao/TangSengDaoDaoServerLib/pkg/util"
+)
+
+// 添加群公告
+func (d *DB) insertAnnouncement(m *announcementModel) error {
+	_, err := d.session.InsertInto("group_announcement").Columns(util.AttrToUnderscore(m)...).Record(m).Exec()
+	return err
+}
+
+// 查询群公告
+func (d *DB) queryAnnouncementWithAnnouncementNo(announcementNo string) (*announcementModel, error) {
+	var m *announcementModel
+	_, err := d.session.Select("*").From("group_announcement").Where("announcement_no=?", announcementNo).Load(&m)
+	return m, err
+}
+
+// 更新群公告
+func (d *DB) updateAnnouncement(m *announcementModel) error {
+	_, err := d.session.Update("group_announcement").SetMap(map[string]interface{}{
+		"title":        m.Title,
+		"content":      m.Content,
+		"pinned":       m.Pinned,
+		"pinned_at":    m.PinnedAt,
+		"need_confirm": m.NeedConfirm,
+		"editor":       m.Editor,
+		"version":      m.Version,
+		"is_deleted":   m.IsDeleted,
+	}).Where("announcement_no=?", m.AnnouncementNo).Exec()
+	return err
+}
+
+// 同步群公告（包含已删除的公告）
+func (d *DB) syncAnnouncements(groupNo string, version int64, limit uint64) ([]*announcementModel, error) {
+	var models []*announcementModel
+	_, err := d.session.Select("*").From("group_announcement").Where("group_no=? and version>?", groupNo, version).OrderAsc("version").Limit(limit).Load(&models)
+	return models, err
+}
+
+// 查询用户已确认的公告编号
+func (d *DB) queryConfirmedAnnouncementNos(announcementNos []string, uid string) ([]string, error) {
+	var results []string
+	_, err := d.session.Select("announcement_no").From("group_announcement_confirm").Where("announcement_no in ? and uid=?", announcementNos, uid).Load(&results)
+	return results, err
+}
+
+// 查询多个公告的确认人数
+func (d *DB) queryAnnouncementConfirmCounts(announcementNos []string) (map[string]int, error) {
+	var results []*struct {
+		AnnouncementNo string
+		Count          int
+	}
+	_, err := d.session.Select("announcement_no,count(*) count").From("group_announcement_confirm").Where("announcement_no in ?", announcementNos).GroupBy("announcement_no").Load(&results)
+	if err != nil {
+		return nil, err
+	}
+	countMap := make(map[string]int, len(results))
+	for _, result := range results {
+		countMap[result.AnnouncementNo] = result.Count
+	}
+	return countMap, nil
+}
+
+// 是否已确认公告
+func (d *DB) existAnnouncementConfirm(announcementNo, uid string) (bool, error) {
+	var count int
+	_, err := d.session.Select("count(*)").From("group_announcement_confirm").Where("announcement_no=? and uid=?", announcementNo, uid).Load(&count)
+	return count > 0, err
+}
+
+// 添加公告确认记录
+func (d *DB) insertAnnouncementConfirm(m *announcementConfirmModel) error {
+	_, err := d.session.InsertInto("group_announcement_confirm").Columns(util.AttrToUnderscore(m)...).Record(m).Exec()
+	return err
+}
+
+// 分页查询已确认公告的成员
+func (d *DB) queryAnnouncementConfirmedMembers(groupNo, announcementNo string, pageIndex, pageSize uint64) ([]*announcementConfirmMemberModel, error) {
+	var models []*announcementConfirmMemberModel
+	_, err := d.session.Select("group_member.uid,IFNULL(user.name,'') name,group_announcement_confirm.created_at confirmed_at").From("group_announcement_confirm").Join("group_member", "group_announcement_confirm.group_no=group_member.group_no and group_announcement_confirm.uid=group_member.uid and group_member.is_deleted=0").LeftJoin("user", "group_member.uid=user.uid").Where("group_announcement_confirm.group_no=? and group_announcement_confirm.announcement_no=?", groupNo, announcementNo).OrderDesc("group_announcement_confirm.id").Offset((pageIndex - 1) * pageSize).Limit(pageSize).Load(&models)
+	return models, err
+}
+
+// 查询已确认公告的成员数量
+func (d *DB) queryAnnouncementConfirmedMemberCount(groupNo, announcementNo string) (int64, error) {
+	var count int64
+	_, err := d.session.Select("count(*)").From("group_announcement_confirm").Join("group_member", "group_announcement_confirm.group_no=group_member.group_no and group_announcement_confirm.uid=group_member.uid and group_member.is_deleted=0").Where("group_announcement_confirm.group_no=? and group_announcement_confirm.announcement_no=?", groupNo, announcementNo).Load(&count)
+	return count, err
+}
+
+// 分页查询未确认公告的成员
+func (d *DB) queryAnnouncementUnconfirmedMembers(groupNo, announcementNo string, pageIndex, pageSize uint64) ([]*announcementConfirmMemberModel, error) {
+	var models []*announcementConfirmMemberModel
+	_, err := d.session.Select("group_member.uid,IFNULL(user.name,'') name").From("group_member").LeftJoin("user", "group_member.uid=user.uid").Where("group_member.group_no=? and group_member.is_deleted=0 and group_member.uid not in (select uid from group_announcement_confirm where announcement_no=?)", groupNo, announcementNo).OrderAsc("group_member.created_at").Offset((pageIndex - 1) * pageSize).Limit(pageSize).Load(&models)
+	return models, err
+}
+
+// 查询未确认公告的成员数量
+func (d *DB) queryAnnouncementUnconfirmedMemberCount(groupNo, announcementNo string) (int64, error) {
+	var count int64
+	_, err := d.session.Select("count(*)").From("group_member").Where("group_no=? and is_deleted=0 and uid not in (select uid from group_announcement_confirm where announcement_no=?)", groupNo, announcementNo).Load(&count)
+	return count, err
+}
+
+// 群公告
+type announcementModel struct {
+	AnnouncementNo string // 公告编号
+	GroupNo        string // 群编号
+	Title          string // 标题
+	Content        string // 内容（markdown）
+	Pinned         int    // 是否置顶
+	PinnedAt       int64  // 置顶时间（13位时间戳）
+	NeedConfirm    int    // 是否需要成员确认
+	Creator        string // 发布者uid
+	Editor         string // 最后编辑者uid
+	Version        int64  // 数据版本
+	IsDeleted      int    // 是否已删除
+	db.BaseModel
+}
+
+// 群公告确认记录
+type announcementConfirmModel struct {
+	AnnouncementNo string // 公告编号
+	GroupNo        string // 群编号
+	UID            string // 确认者uid
+	db.BaseModel
+}
+
+type announcementConfirmMemberModel struct {
+	UID         string  // 成员uid
+	Name        string  // 成员名称
+	ConfirmedAt db.Time // 确认时间
+}
@@ -36,9 +36,11 @@ type Group struct {
 	db            *DB
 	settingDB     *settingDB
 	userDB        *user.DB
+	userService   user.IService
 	groupService  IService
 	fileService   file.IService
 	commonService common2.IService
+	permission    *permissionService
 }
 
 // New New
@@ -49,10 +51,12 @@ func New(ctx *config.Context) *Group {
 		Log:           log.NewTLog("Group"),
 		db:            NewDB(ctx),
 		userDB:        user.NewDB(ctx),
+		userService:   user.NewService(ctx),
 		settingDB:     newSettingDB(ctx),
 		groupService:  NewService(ctx),
 		fileService:   file.NewService(ctx),
 		commonService: common2.NewService(ctx),
+		permission:    newPermissionService(ctx),
 	}
 	g.ctx.AddEventListener(event.GroupDisband, g.handleGroupDisbandEvent)
 	g.ctx.AddEventListener(event.EventUserRegister, g.handleRegisterUserEvent)
@@ -61,6 +65,11 @@ func New(ctx *config.Context) *Group {
 	g.ctx.AddEventListener(event.OrgOrDeptEmployeeUpdate, g.handleOrgOrDeptEmployeeUpdate)
 	g.ctx.AddEventListener(event.OrgEmployeeExit, g.handleOrgEmployeeExit)
 	source.SetGroupMemberProvider(g)
+	user.AddDataExportSection(&user.DataExportSection{
+		Name:   "groups",
+		Title:  "加入的群",
+		Export: g.exportUserGroups,
+	})
 	return g
 }
 
@@ -71,6 +80,9 @@ func (g *Group) Route(r *wkhttp.WKHttp) {
 		group.POST("/create", g.groupCreate)
 		group.GET("/my", g.list)                            //我保存的群
 		group.GET("/forbidden_times", g.forbiddenTimesList) // 获取禁言时常列表
+		// #################### 入群申请 ####################
+		group.GET("/search", g.joinApplyGroupSearch) // 搜索可以申请加入的群
+		group.GET("/join_applies", g.joinApplyMy)    // 我的入群申请
 	}
 	groups := r.Group("/v1/groups", g.ctx.AuthMiddleware(r))
 	{
@@ -95,6 +107,35 @@ func (g *Group) Route(r *wkhttp.WKHttp) {
 		groups.POST("/:group_no/forbidden_with_member", g.forbiddenWithGroupMember)        // 禁言或解禁某个群成员
 		groups.POST("/:group_no/avatar", g.avatarUpload)                                   // 上传群头像
 		groups.DELETE("/:group_no/disband", g.disband)                                     // 解散群
+		// #################### 入群申请 ####################
+		groups.GET("/:group_no/join_questions", g.joinQuestionsGet)                  // 获取入群问题
+		groups.PUT("/:group_no/join_questions", g.joinQuestionsSet)                  // 设置入群问题
+		groups.POST("/:group_no/join_apply", g.joinApplyAdd)                         // 申请加入群
+		groups.GET("/:group_no/join_applies", g.joinApplyList)                       // 入群申请列表
+		groups.POST("/:group_no/join_applies/:apply_no/approve", g.joinApplyApprove) // 通过入群申请
+		groups.POST("/:group_no/join_applies/:apply_no/reject", g.joinApplyReject)   // 拒绝入群申请
+		// #################### 邀请链接 ####################
+		groups.POST("/:group_no/invite_links", g.inviteLinkAdd)                     // 创建邀请链接
+		groups.GET("/:group_no/invite_links", g.inviteLinkList)                     // 邀请链接列表
+		groups.DELETE("/:group_no/invite_links/:link_no", g.inviteLinkRevoke)       // 撤销邀请链接
+		groups.GET("/:group_no/invite_links/:link_no/members", g.inviteLinkMembers) // 通过邀请链接入群的成员
+		// #################### 群公告 ####################
+		groups.POST("/:group_no/announcements", g.announcementAdd)                                // 发布群公告
+		groups.PUT("/:group_no/announcements/:announcement_no", g.announcementUpdate)             // 编辑群公告
+		groups.DELETE("/:group_no/announcements/:announcement_no", g.announcementDelete)          // 删除群公告
+		groups.POST("/:group_no/announcements/:announcement_no/pinned/:on", g.announcementPinned) // 置顶或取消置顶群公告
+		groups.POST("/:group_no/announcements/:announcement_no/confirm", g.announcementConfirm)   // 确认群公告
+		groups.GET("/:group_no/announcements/:announcement_no/confirms", g.announcementConfirms)  // 群公告的确认情况
+		groups.GET("/:group_no/announcementsync", g.announcementSync)                             // 同步群公告
+		// #################### 慢速模式 ####################
+		groups.GET("/:group_no/slowmode", g.slowModeGet) // 获取慢速模式状态
+		// #################### 自定义角色 ####################
+		groups.POST("/:group_no/roles", g.roleAdd)                  // 添加自定义角色
+		groups.GET("/:group_no/roles", g.roleList)                  // 自定义角色列表
+		groups.PUT("/:group_no/roles/:role_no", g.roleUpdate)       // 修改自定义角色
+		groups.DELETE("/:group_no/roles/:role_no", g.roleDelete)    // 删除自定义角色
+		groups.PUT("/:group_no/members/:uid/role", g.memberRoleSet) // 设置成员的自定义角色
+		groups.GET("/:group_no/permissions", g.permissionsGet)      // 获取我在群内的权限
 	}
 	openGroups := r.Group("/v1/groups")
 	{ // 获取群头像
@@ -109,6 +150,7 @@ func (g *Group) Route(r *wkhttp.WKHttp) {
 		openGroup.POST("invite/sure", g.groupMemberInviteSure)         // 确认邀请
 	}
 	go g.CheckForbiddenLoop()
+	go g.CheckSlowModeLoop()
 }
 
 // 解散群
@@ -779,15 +821,15 @@ func (g *Group) groupUpdate(c *wkhttp.Context) {
 		c.ResponseError(err)
 		return
 	}
-	// 查询是否是管理者
-	isManager, err := g.db.QueryIsGroupManagerOrCreator(groupNo, loginUID)
+	// 查询是否有修改群信息的权限
+	canEdit, err := g.permission.hasPermission(groupNo, loginUID, PermissionEditGroup)
 	if err != nil {
-		g.Error("查询是否是群管理者失败！", zap.Error(err))
-		c.ResponseError(errors.New("查询是否是群管理者失败！"))
+		g.Error("查询群内权限失败！", zap.Error(err))
+		c.ResponseError(errors.New("查询群内权限失败！"))
 		return
 	}
-	if !isManager {
-		c.ResponseError(errors.New("只有群管理者才能修改！"))
+	if !canEdit {
+		c.ResponseError(errors.New("没有修改群信息的权限！"))
 		return
 	}
 
@@ -900,16 +942,16 @@ func (g *Group) memberAdd(c *wkhttp.Context) {
 		}
 	}
 	/**
-	判断群是否开启了邀请模式 如果开启了 再判断邀请的人是否是群主或管理员 如果不是则不允许直接添加群成员
+	判断群是否开启了邀请模式 如果开启了 再判断邀请的人是否有邀请权限 如果没有则不允许直接添加群成员
 	**/
 	if group.Invite == 1 {
-		creatorOrManager, err := g.db.QueryIsGroupManagerOrCreator(groupNo, operator)
+		canInvite, err := g.permission.hasPermission(groupNo, operator, PermissionInvite)
 		if err != nil {
-			g.Error("查询是否是创建者和管理者失败！", zap.Error(err))
-			c.ResponseError(errors.New("查询是否是创建者和管理者失败！"))
+			g.Error("查询群内权限失败！", zap.Error(err))
+			c.ResponseError(errors.New("查询群内权限失败！"))
 			return
 		}
-		if !creatorOrManager {
+		if !canInvite {
 			c.ResponseError(errors.New("群开启了邀请模式，不能添加群成员！"))
 			return
 		}
@@ -1176,6 +1218,7 @@ func (g *Group) addMembersTx(members []string, groupNo string, operator, operato
 		g.Error("调用IM的订阅接口失败！", zap.Error(err))
 		return nil, errors.New("调用IM的订阅接口失败！")
 	}
+	syncThreadSubscribers(g.ctx, groupNo, realMembers, true)
 
 	return func() {
 		// 提交事件
@@ -1404,14 +1447,14 @@ func (g *Group) groupForbidden(c *wkhttp.Context) {
 	loginName := c.MustGet("name").(string)
 	groupNo := c.Param("group_no")
 	on := c.Param("on")
-	isCreatorOrManager, err := g.db.QueryIsGroupManagerOrCreator(groupNo, loginUID)
+	canForbidden, err := g.permission.hasPermission(groupNo, loginUID, PermissionForbidden)
 	if err != nil {
-		g.Error("查询是否是创建者失败！", zap.Error(err))
-		c.ResponseError(errors.New("查询是否是创建者失败！"))
+		g.Error("查询群内权限失败！", zap.Error(err))
+		c.ResponseError(errors.New("查询群内权限失败！"))
 		return
 	}
-	if !isCreatorOrManager {
-		c.ResponseError(errors.New("只有创建者或管理员才能禁言！"))
+	if !canForbidden {
+		c.ResponseError(errors.New("没有禁言的权限！"))
 		return
 	}
 	groupModel, err := g.getGroupInfo(groupNo)
@@ -1514,6 +1557,7 @@ func (g *Group) resetIMWhitelist(whitelist []string, groupNo string) error {
 		g.Error("设置白名单失败！", zap.Error(err))
 		return err
 	}
+	syncThreadWhitelist(g.ctx, groupNo, whitelist)
 	return nil
 
 }
@@ -1569,10 +1613,6 @@ func (g *Group) groupScanJoin(c *wkhttp.Context) {
 		c.ResponseError(err)
 		return
 	}
-	if group.Invite == 1 {
-		c.ResponseError(errors.New("群开启了邀请模式，不能直接加入群聊"))
-		return
-	}
 	authInfo, err := g.ctx.GetRedisConn().GetString(fmt.Sprintf("%s%s", common.AuthCodeCachePrefix, authCode))
 	if err != nil {
 		g.Error("获取认证信息数据失败！", zap.Error(err))
@@ -1610,6 +1650,28 @@ func (g *Group) groupScanJoin(c *wkhttp.Context) {
 		c.ResponseError(errors.New("没有二维码扫码信息！"))
 		return
 	}
+	// 通过邀请链接入群时，链接由群主或管理员创建，不受邀请模式限制
+	linkNo, _ := authMap["link_no"].(string)
+	var link *InviteLinkModel
+	if linkNo != "" {
+		link, err = g.db.QueryInviteLinkWithLinkNo(linkNo)
+		if err != nil {
+			g.Error("查询邀请链接失败！", zap.Error(err))
+			c.ResponseError(errors.New("查询邀请链接失败！"))
+			return
+		}
+		if link == nil || link.GroupNo != groupNo {
+			c.ResponseError(errors.New("邀请链接不存在！"))
+			return
+		}
+		if err := link.Check(); err != nil {
+			c.ResponseError(err)
+			return
+		}
+	} else if group.Invite == 1 {
+		c.ResponseError(errors.New("群开启了邀请模式，不能直接加入群聊"))
+		return
+	}
 	existMember, err := g.db.ExistMember(scaner, groupNo)
 	if err != nil {
 		g.Error("查询是否存在群内时失败！", zap.Error(err))
@@ -1642,6 +1704,18 @@ func (g *Group) groupScanJoin(c *wkhttp.Context) {
 		c.ResponseError(errors.New("扫码者信息不存在！"))
 		return
 	}
+	if link != nil && link.NeedApprove == 1 {
+		applyNo, err := g.addInviteLinkJoinApply(group, link, scanerInfo.UID, scanerInfo.Name)
+		if err != nil {
+			c.ResponseError(err)
+			return
+		}
+		c.Response(gin.H{
+			"need_approve": 1,
+			"apply_no":     applyNo,
+		})
+		return
+	}
 
 	memberCount, err := g.db.QueryMemberCount(groupNo)
 	if err != nil {
@@ -1653,13 +1727,14 @@ func (g *Group) groupScanJoin(c *wkhttp.Context) {
 	version := g.ctx.GenSeq(common.GroupMemberSeqKey)
 
 	memberModel := &MemberModel{
-		GroupNo:   groupNo,
-		UID:       scaner,
-		Role:      MemberRoleCommon,
-		Version:   version,
-		Status:    int(common.GroupMemberStatusNormal),
-		InviteUID: generator,
-		Vercode:   fmt.Sprintf("%s@%d", util.GenerUUID(), common.GroupMember),
+		GroupNo:      groupNo,
+		UID:          scaner,
+		Role:         MemberRoleCommon,
+		Version:      version,
+		Status:       int(common.GroupMemberStatusNormal),
+		InviteUID:    generator,
+		InviteLinkNo: linkNo,
+		Vercode:      fmt.Sprintf("%s@%d", util.GenerUUID(), common.GroupMember),
 	}
 
 	tx, err := g.db.session.Begin()
@@ -1746,6 +1821,21 @@ func (g *Group) groupScanJoin(c *wkhttp.Context) {
 		c.ResponseError(errors.New("添加群成员失败！"))
 		return
 	}
+	if linkNo != "" {
+		// 并发入群时以数据库的条件更新为准，避免超出最大使用次数
+		rowsAffected, err := g.db.useInviteLinkTx(linkNo, tx)
+		if err != nil {
+			tx.Rollback()
+			g.Error("更新邀请链接使用次数失败！", zap.Error(err))
+			c.ResponseError(errors.New("更新邀请链接使用次数失败！"))
+			return
+		}
+		if rowsAffected == 0 {
+			tx.Rollback()
+			c.ResponseError(errors.New("邀请链接已失效！"))
+			return
+		}
+	}
 	// 调用IM的添加订阅者
 	err = g.ctx.IMAddSubscriber(&config.SubscriberAddReq{
 		ChannelID:   groupNo,
@@ -1758,6 +1848,7 @@ func (g *Group) groupScanJoin(c *wkhttp.Context) {
 		c.ResponseError(errors.New("调用IM的订阅接口失败！"))
 		return
 	}
+	syncThreadSubscribers(g.ctx, groupNo, []string{scaner}, true)
 
 	if err := tx.Commit(); err != nil {
 		tx.Rollback()
@@ -1924,6 +2015,7 @@ func (g *Group) transferGrouper(c *wkhttp.Context) {
 			g.Error("新群主添加白名单失败！", zap.Error(err))
 			return
 		}
+		syncThreadBlacklist(g.ctx, groupNo, toUIDs, false)
 	}
 
 	c.ResponseOK()
@@ -1946,16 +2038,17 @@ func (g *Group) memberUpdate(c *wkhttp.Context) {
 		c.ResponseError(err)
 		return
 	}
-	isManager, err := g.db.QueryIsGroupManagerOrCreator(groupNo, loginUID)
-	if err != nil {
-		g.Error("查询是否是群管理者失败！", zap.Error(err))
-		c.ResponseError(errors.New("查询是否是群管理者失败！"))
-		return
-	}
-	if !isManager && loginUID != memberUID {
-		g.Error("只有管理员才能修改其他人的成员信息！")
-		c.ResponseError(errors.New("只有管理员才能修改其他人的成员信息！"))
-		return
+	if loginUID != memberUID {
+		canEdit, err := g.permission.hasPermission(groupNo, loginUID, PermissionEditGroup)
+		if err != nil {
+			g.Error("查询群内权限失败！", zap.Error(err))
+			c.ResponseError(errors.New("查询群内权限失败！"))
+			return
+		}
+		if !canEdit {
+			c.ResponseError(errors.New("没有修改其他人的成员信息的权限！"))
+			return
+		}
 	}
 	memberModel, err := g.db.QueryMemberWithUID(memberUID, groupNo)
 	if err != nil {
@@ -2035,8 +2128,14 @@ func (g *Group) memberRemove(c *wkhttp.Context) {
 			c.ResponseError(errors.New("操作者不再此群"))
 			return
 		}
-		if loginMember.Role != int(common.GroupMemberRoleCreater) && loginMember.Role != int(common.GroupMemberRoleManager) {
-			c.ResponseError(errors.New("普通成员无法删除群成员"))
+		canRemove, err := g.permission.memberHasPermission(loginMember, PermissionRemoveMember)
+		if err != nil {
+			g.Error("查询群内权限失败！", zap.Error(err))
+			c.ResponseError(errors.New("查询群内权限失败！"))
+			return
+		}
+		if !canRemove {
+			c.ResponseError(errors.New("没有删除群成员的权限"))
 			return
 		}
 	}
@@ -2060,15 +2159,13 @@ func (g *Group) memberRemove(c *wkhttp.Context) {
 	if loginMember != nil {
 		// 验证权限
 		for _, member := range deleteMembers {
-			if loginMember.Role == int(common.GroupMemberRoleManager) {
-				if member.Role == int(common.GroupMemberRoleManager) {
-					c.ResponseError(errors.New("管理员不能删除管理员"))
-					return
-				}
-				if member.Role == int(common.GroupMemberRoleCreater) {
-					c.ResponseError(errors.New("管理员不能删除群主"))
-					return
-				}
+			if member.Role == MemberRoleCreator {
+				c.ResponseError(errors.New("不能删除群主"))
+				return
+			}
+			if !g.permission.outrank(loginMember, member) {
+				c.ResponseError(errors.New("不能删除身份不低于自己的成员"))
+				return
 			}
 		}
 	}
@@ -2214,6 +2311,7 @@ func (g *Group) memberRemove(c *wkhttp.Context) {
 		c.ResponseError(errors.New("调用IM的移除订阅者接口失败！"))
 		return
 	}
+	syncThreadSubscribers(g.ctx, groupNo, req.Members, false)
 
 	//给被踢的成员发送被踢消息
 	err = g.ctx.SendGroupMemberBeRemove(groupMemberRemoveReq)
@@ -2354,6 +2452,7 @@ func (g *Group) groupExit(c *wkhttp.Context) {
 		c.ResponseError(errors.New("移除订阅者失败！"))
 		return
 	}
+	syncThreadSubscribers(g.ctx, groupNo, []string{loginUID}, false)
 	loginMember, err := g.db.QueryMemberWithUID(loginUID, groupNo)
 	if err != nil {
 		g.Error("查询是否存在群成员失败！", zap.Error(err))
@@ -2531,15 +2630,15 @@ func (g *Group) blacklist(c *wkhttp.Context) {
 		c.ResponseError(errors.New("群不存在"))
 		return
 	}
-	// 查询是否是管理者
-	isManager, err := g.db.QueryIsGroupManagerOrCreator(groupNo, loginUID)
+	// 拉黑成员需要移除成员的权限
+	canRemove, err := g.permission.hasPermission(groupNo, loginUID, PermissionRemoveMember)
 	if err != nil {
-		g.Error("查询是否是群管理者失败！", zap.Error(err))
-		c.ResponseError(errors.New("查询是否是群管理者失败！"))
+		g.Error("查询群内权限失败！", zap.Error(err))
+		c.ResponseError(errors.New("查询群内权限失败！"))
 		return
 	}
-	if !isManager {
-		c.ResponseError(errors.New("只有群管理者才能修改！"))
+	if !canRemove {
+		c.ResponseError(errors.New("没有拉黑群成员的权限！"))
 		return
 	}
 	status := 0
@@ -2580,8 +2679,14 @@ func (g *Group) blacklist(c *wkhttp.Context) {
 				removeUIDs = append(removeUIDs, member.UID)
 			}
 		}
+		removeUIDs, err = g.withoutSlowModeCooldown(groupNo, removeUIDs)
+		if err != nil {
+			g.Error("查询慢速模式冷却失败！", zap.Error(err))
+			c.ResponseError(errors.New("查询慢速模式冷却失败！"))
+			return
+		}
 		if len(removeUIDs) > 0 {
-			err = g.setGroupBlacklist(groupNo, req.Uids, false)
+			err = g.setGroupBlacklist(groupNo, removeUIDs, false)
 			if err != nil {
 				g.Error("移除IM黑名单错误", zap.Error(err))
 				c.ResponseError(errors.New("移除IM黑名单错误"))
@@ -2714,7 +2819,13 @@ func (g *Group) forbiddenWithGroupMember(c *wkhttp.Context) {
 		c.ResponseError(errors.New("该成员不在群内"))
 		return
 	}
-	if loginGroupMember.Role == MemberRoleCommon || member.Role == MemberRoleCreator || loginGroupMember.Role == member.Role {
+	canForbidden, err := g.permission.memberHasPermission(loginGroupMember, PermissionForbidden)
+	if err != nil {
+		g.Error("查询群内权限失败！", zap.Error(err))
+		c.ResponseError(errors.New("查询群内权限失败！"))
+		return
+	}
+	if !canForbidden || !g.permission.outrank(loginGroupMember, member) {
 		c.ResponseError(errors.New("操作用户权限不够"))
 		return
 	}
@@ -2762,10 +2873,20 @@ func (g *Group) forbiddenWithGroupMember(c *wkhttp.Context) {
 	// 加入talk黑名单
 	uids := make([]string, 0)
 	uids = append(uids, req.MemberUID)
-	err = g.setGroupBlacklist(groupNo, uids, req.Action == 1)
-	if err != nil {
-		c.ResponseError(errors.New("设置IM黑名单错误"))
-		return
+	if req.Action != 1 { // 解除禁言时，还在慢速模式冷却中的成员留在黑名单中
+		uids, err = g.withoutSlowModeCooldown(groupNo, uids)
+		if err != nil {
+			g.Error("查询慢速模式冷却失败！", zap.Error(err))
+			c.ResponseError(errors.New("查询慢速模式冷却失败！"))
+			return
+		}
+	}
+	if len(uids) > 0 {
+		err = g.setGroupBlacklist(groupNo, uids, req.Action == 1)
+		if err != nil {
+			c.ResponseError(errors.New("设置IM黑名单错误"))
+			return
+		}
 	}
 	err = g.ctx.SendCMD(config.MsgCMDReq{
 		ChannelID:   groupNo,
@@ -2810,11 +2931,19 @@ func (g *Group) CheckForbiddenLoop() {
 			uids := make([]string, 0)
 			uids = append(uids, model.UID)
 			if model.Status != int(common.GroupMemberStatusBlacklist) {
-				err = g.setGroupBlacklist(model.GroupNo, uids, false)
+				// 还在慢速模式冷却中的成员留在黑名单中，冷却结束后由CheckSlowModeLoop移出
+				uids, err = g.withoutSlowModeCooldown(model.GroupNo, uids)
 				if err != nil {
-					g.Warn("更新禁言成员新消息错误", zap.Error(err))
+					g.Warn("查询慢速模式冷却失败！", zap.Error(err))
 					continue
 				}
+				if len(uids) > 0 {
+					err = g.setGroupBlacklist(model.GroupNo, uids, false)
+					if err != nil {
+						g.Warn("更新禁言成员新消息错误", zap.Error(err))
+						continue
+					}
+				}
 			}
 			err = g.ctx.SendCMD(config.MsgCMDReq{
 				ChannelID:   model.GroupNo,
@@ -2854,6 +2983,7 @@ func (g *Group) setGroupBlacklist(groupNo string, uids []string, isAdd bool) err
 		g.Error("设置群黑名单错误", zap.Error(err))
 		return err
 	}
+	syncThreadBlacklist(g.ctx, groupNo, uids, isAdd)
 	return nil
 }
 
@@ -2909,6 +3039,8 @@ type memberDetailResp struct {
 	Status             int    `json:"status"`               //成员状态0:正常，2:黑名单
 	Vercode            string `json:"vercode"`              // 验证码
 	InviteUID          string `json:"invite_uid"`           // 邀请人
+	InviteLinkNo       string `json:"invite_link_no"`       // 入群邀请链接编号
+	RoleNo             string `json:"role_no"`              // 自定义角色编号
 	Robot              int    `json:"robot"`                // 机器人
 	ForbiddenExpirTime int64  `json:"forbidden_expir_time"` // 禁言时长
 	CreatedAt          string `json:"created_at"`
@@ -2928,6 +3060,8 @@ func (r memberDetailResp) from(model *MemberDetailModel) memberDetailResp {
 		Status:    model.Status,
 		// Vercode:            model.Vercode,
 		InviteUID:          model.InviteUID,
+		InviteLinkNo:       model.InviteLinkNo,
+		RoleNo:             model.RoleNo,
 		Robot:              model.Robot,
 		ForbiddenExpirTime: model.ForbiddenExpirTime,
 		CreatedAt:          model.CreatedAt.String(),
@@ -0,0 +1,30 @@
+package group
+
+import (
+	"encoding/json"
+	"io"
+)
+
+// 个人数据导出：用户加入的群
+func (g *Group) exportUserGroups(uid string, w io.Writer) (int64, error) {
+	members, err := g.db.queryMembersWithUID(uid)
+	if err != nil {
+		return 0, err
+	}
+	list := make([]map[string]interface{}, 0, len(members))
+	for _, member := range members {
+		list = append(list, map[string]interface{}{
+			"group_no":       member.GroupNo,
+			"group_name":     member.GroupName,
+			"remark":         member.Remark,
+			"role":           member.Role,
+			"status":         member.Status,
+			"invite_uid":     member.InviteUID,
+			"invite_link_no": member.InviteLinkNo,
+			"joined_at":      member.CreatedAt.String(),
+		})
+	}
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return int64(len(list)), encoder.Encode(list)
+}
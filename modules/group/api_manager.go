@@ -337,6 +337,7 @@ func (m *Manager) forbidden(c *wkhttp.Context) {
 		c.ResponseError(errors.New(err.Error()))
 		return
 	}
+	syncThreadWhitelist(m.ctx, groupModel.GroupNo, whitelistUIDs)
 
 	tx, err := m.ctx.DB().Begin()
 	if err != nil {
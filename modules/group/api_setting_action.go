@@ -59,21 +59,13 @@ type groupUpdateContext struct {
 	g          *Group
 }
 
-func (g *groupUpdateContext) isManager() (bool, error) {
-	isManager, err := g.g.db.QueryIsGroupManagerOrCreator(g.groupModel.GroupNo, g.loginUID)
-	if err != nil {
-		g.g.Error("查询是否是群管理者失败！", zap.Error(err))
-		return false, err
-	}
-	return isManager, nil
-}
-
-func (g *groupUpdateContext) checkPermissions() error {
-	isManager, err := g.isManager()
+func (g *groupUpdateContext) checkPermission(permission Permission) error {
+	has, err := g.g.permission.hasPermission(g.groupModel.GroupNo, g.loginUID, permission)
 	if err != nil {
+		g.g.Error("查询群内权限失败！", zap.Error(err))
 		return err
 	}
-	if !isManager {
+	if !has {
 		return errors.New("没有权限！")
 	}
 	return nil
@@ -185,7 +177,7 @@ var settingActionMap = map[string]groupSettingActionFnc{
 
 var groupUpdateActionMap = map[string]groupUpdateActionFnc{
 	common.GroupAttrKeyForbidden: func(ctx *groupUpdateContext, value interface{}) error { // 群内禁言
-		if err := ctx.checkPermissions(); err != nil {
+		if err := ctx.checkPermission(PermissionForbidden); err != nil {
 			return err
 		}
 		ctx.groupModel.Forbidden = int(value.(float64))
@@ -216,13 +208,14 @@ var groupUpdateActionMap = map[string]groupUpdateActionFnc{
 			ctx.g.Error("设置禁言失败！", zap.Error(err))
 			return errors.New("设置禁言失败！")
 		}
+		syncThreadWhitelist(ctx.g.ctx, groupNo, whitelistUIDs)
 
 		ctx.commmitGroupUpdateEvent(common.GroupAttrKeyForbidden, fmt.Sprintf("%d", ctx.groupModel.Forbidden))
 
 		return nil
 	},
 	common.GroupAttrKeyForbiddenAddFriend: func(ctx *groupUpdateContext, value interface{}) error { // 群内禁止加好友
-		if err := ctx.checkPermissions(); err != nil {
+		if err := ctx.checkPermission(PermissionEditGroup); err != nil {
 			return err
 		}
 		ctx.groupModel.ForbiddenAddFriend = int(value.(float64))
@@ -237,7 +230,7 @@ var groupUpdateActionMap = map[string]groupUpdateActionFnc{
 		return err
 	},
 	common.GroupAttrKeyInvite: func(ctx *groupUpdateContext, value interface{}) error { // 邀请开关
-		if err := ctx.checkPermissions(); err != nil {
+		if err := ctx.checkPermission(PermissionEditGroup); err != nil {
 			return err
 		}
 		ctx.groupModel.Invite = int(value.(float64))
@@ -250,7 +243,7 @@ var groupUpdateActionMap = map[string]groupUpdateActionFnc{
 		return ctx.commmitGroupUpdateEvent(common.GroupAttrKeyInvite, fmt.Sprintf("%d", ctx.groupModel.Invite))
 	},
 	common.GroupAllowViewHistoryMsg: func(ctx *groupUpdateContext, value interface{}) error {
-		if err := ctx.checkPermissions(); err != nil {
+		if err := ctx.checkPermission(PermissionEditGroup); err != nil {
 			return err
 		}
 		ctx.groupModel.AllowViewHistoryMsg = int(value.(float64))
@@ -264,7 +257,7 @@ var groupUpdateActionMap = map[string]groupUpdateActionFnc{
 		return ctx.g.ctx.SendChannelUpdateToGroup(groupNo)
 	},
 	common.GroupAllowMemberPinnedMessage: func(ctx *groupUpdateContext, value interface{}) error {
-		if err := ctx.checkPermissions(); err != nil {
+		if err := ctx.checkPermission(PermissionEditGroup); err != nil {
 			return err
 		}
 		ctx.groupModel.AllowMemberPinnedMessage = int(value.(float64))
@@ -276,4 +269,30 @@ var groupUpdateActionMap = map[string]groupUpdateActionFnc{
 		// 通知群内成员更新频道
 		return ctx.g.ctx.SendChannelUpdateToGroup(groupNo)
 	},
+	GroupAttrKeyJoinApply: func(ctx *groupUpdateContext, value interface{}) error { // 允许搜索并申请入群
+		if err := ctx.checkPermission(PermissionEditGroup); err != nil {
+			return err
+		}
+		ctx.groupModel.JoinApply = int(value.(float64))
+		err := ctx.updateGroup()
+		if err != nil {
+			return err
+		}
+		return ctx.g.ctx.SendChannelUpdateToGroup(ctx.groupModel.GroupNo)
+	},
+	GroupAttrKeySlowMode: func(ctx *groupUpdateContext, value interface{}) error { // 慢速模式
+		if err := ctx.checkPermission(PermissionEditGroup); err != nil {
+			return err
+		}
+		slowMode := int(value.(float64))
+		if slowMode < 0 || slowMode > slowModeMaxSecond {
+			return fmt.Errorf("慢速模式的间隔需要在0到%d秒之间！", slowModeMaxSecond)
+		}
+		ctx.groupModel.SlowMode = slowMode
+		err := ctx.updateGroup()
+		if err != nil {
+			return err
+		}
+		return ctx.g.ctx.SendChannelUpdateToGroup(ctx.groupModel.GroupNo)
+	},
 }
@@ -319,3 +319,380 @@ func TestList(t *testing.T) {
 	assert.Equal(t, true, strings.Contains(w.Body.String(), `"name":`))
 
 }
+
+func TestJoinApplyAdd(t *testing.T) {
+	s, ctx := testutil.NewTestServer()
+	f := New(ctx)
+	f.Route(s.GetRoute())
+
+	// 先清空旧数据
+	err := testutil.CleanAllTables(ctx)
+	assert.NoError(t, err)
+
+	err = f.db.Insert(&Model{
+		GroupNo:   "1",
+		Name:      "test",
+		Creator:   "10001",
+		Version:   1,
+		Status:    1,
+		JoinApply: 1,
+	})
+	assert.NoError(t, err)
+	err = f.db.InsertMember(&MemberModel{
+		GroupNo: "1",
+		UID:     "10001",
+		Role:    MemberRoleCreator,
+		Status:  1,
+	})
+	assert.NoError(t, err)
+	tx, _ := ctx.DB().Begin()
+	err = f.db.insertJoinQuestionTx(&joinQuestionModel{
+		GroupNo:  "1",
+		Question: "你从哪里知道本群的？",
+	}, tx)
+	assert.NoError(t, err)
+	assert.NoError(t, tx.Commit())
+	questions, err := f.db.queryJoinQuestions("1")
+	assert.NoError(t, err)
+	assert.Equal(t, 1, len(questions))
+
+	// 未回答入群问题
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("POST", "/v1/groups/1/join_apply", bytes.NewReader([]byte(util.ToJson(map[string]interface{}{
+		"remark": "你好",
+	}))))
+	req.Header.Set("token", testutil.Token)
+	s.GetRoute().ServeHTTP(w, req)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+
+	w = httptest.NewRecorder()
+	req, _ = http.NewRequest("POST", "/v1/groups/1/join_apply", bytes.NewReader([]byte(util.ToJson(map[string]interface{}{
+		"remark": "你好",
+		"answers": []map[string]interface{}{
+			{"question_id": questions[0].Id, "answer": "朋友推荐"},
+		},
+	}))))
+	req.Header.Set("token", testutil.Token)
+	s.GetRoute().ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	apply, err := f.db.queryWaitJoinApply("1", testutil.UID)
+	assert.NoError(t, err)
+	assert.NotNil(t, apply)
+	assert.Contains(t, apply.Answers, "朋友推荐")
+
+	// 待审核时不能重复申请
+	w = httptest.NewRecorder()
+	req, _ = http.NewRequest("POST", "/v1/groups/1/join_apply", bytes.NewReader([]byte(util.ToJson(map[string]interface{}{
+		"answers": []map[string]interface{}{
+			{"question_id": questions[0].Id, "answer": "朋友推荐"},
+		},
+	}))))
+	req.Header.Set("token", testutil.Token)
+	s.GetRoute().ServeHTTP(w, req)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+}
+
+func TestInviteLinkAdd(t *testing.T) {
+	s, ctx := testutil.NewTestServer()
+	f := New(ctx)
+	f.Route(s.GetRoute())
+
+	// 先清空旧数据
+	err := testutil.CleanAllTables(ctx)
+	assert.NoError(t, err)
+
+	err = f.db.Insert(&Model{
+		GroupNo: "1",
+		Name:    "test",
+		Creator: testutil.UID,
+		Version: 1,
+		Status:  1,
+	})
+	assert.NoError(t, err)
+	err = f.db.InsertMember(&MemberModel{
+		GroupNo: "1",
+		UID:     testutil.UID,
+		Role:    MemberRoleCreator,
+		Status:  1,
+	})
+	assert.NoError(t, err)
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("POST", "/v1/groups/1/invite_links", bytes.NewReader([]byte(util.ToJson(map[string]interface{}{
+		"name":      "活动推广",
+		"expire_at": time.Now().Add(time.Hour).Unix(),
+		"max_uses":  1,
+	}))))
+	req.Header.Set("token", testutil.Token)
+	s.GetRoute().ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	links, err := f.db.queryInviteLinksWithGroupNo("1")
+	assert.NoError(t, err)
+	assert.Equal(t, 1, len(links))
+	assert.NoError(t, links[0].Check())
+
+	// 最多只能使用一次
+	tx, _ := ctx.DB().Begin()
+	rowsAffected, err := f.db.useInviteLinkTx(links[0].LinkNo, tx)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(1), rowsAffected)
+	rowsAffected, err = f.db.useInviteLinkTx(links[0].LinkNo, tx)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(0), rowsAffected)
+	assert.NoError(t, tx.Commit())
+
+	link, err := f.db.QueryInviteLinkWithLinkNo(links[0].LinkNo)
+	assert.NoError(t, err)
+	assert.Error(t, link.Check())
+}
+
+func TestJoinApplyApproveWithRevokedLink(t *testing.T) {
+	s, ctx := testutil.NewTestServer()
+	f := New(ctx)
+	f.Route(s.GetRoute())
+
+	// 先清空旧数据
+	err := testutil.CleanAllTables(ctx)
+	assert.NoError(t, err)
+
+	err = f.db.Insert(&Model{
+		GroupNo: "1",
+		Name:    "test",
+		Creator: testutil.UID,
+		Version: 1,
+		Status:  1,
+	})
+	assert.NoError(t, err)
+	err = f.db.InsertMember(&MemberModel{
+		GroupNo: "1",
+		UID:     testutil.UID,
+		Role:    MemberRoleCreator,
+		Status:  1,
+	})
+	assert.NoError(t, err)
+	err = f.db.insertInviteLink(&InviteLinkModel{
+		LinkNo:      "link1",
+		GroupNo:     "1",
+		Creator:     testutil.UID,
+		NeedApprove: 1,
+		Status:      InviteLinkStatusNormal,
+	})
+	assert.NoError(t, err)
+	err = f.db.insertJoinApply(&joinApplyModel{
+		ApplyNo:      "apply1",
+		GroupNo:      "1",
+		UID:          "10002",
+		Status:       JoinApplyStatusWait,
+		InviteLinkNo: "link1",
+	})
+	assert.NoError(t, err)
+
+	// 申请期间链接被撤销，不能再通过
+	err = f.db.revokeInviteLink("link1")
+	assert.NoError(t, err)
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("POST", "/v1/groups/1/join_applies/apply1/approve", nil)
+	req.Header.Set("token", testutil.Token)
+	s.GetRoute().ServeHTTP(w, req)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+
+	apply, err := f.db.queryJoinApplyWithApplyNo("apply1")
+	assert.NoError(t, err)
+	assert.Equal(t, JoinApplyStatusWait, apply.Status)
+	exist, err := f.db.ExistMember("10002", "1")
+	assert.NoError(t, err)
+	assert.False(t, exist)
+}
+
+func TestAnnouncementAdd(t *testing.T) {
+	s, ctx := testutil.NewTestServer()
+	f := New(ctx)
+	f.Route(s.GetRoute())
+
+	// 先清空旧数据
+	err := testutil.CleanAllTables(ctx)
+	assert.NoError(t, err)
+
+	err = f.db.Insert(&Model{
+		GroupNo: "1",
+		Name:    "test",
+		Creator: testutil.UID,
+		Version: 1,
+		Status:  1,
+	})
+	assert.NoError(t, err)
+	err = f.db.InsertMember(&MemberModel{
+		GroupNo: "1",
+		UID:     testutil.UID,
+		Role:    MemberRoleCreator,
+		Status:  1,
+	})
+	assert.NoError(t, err)
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("POST", "/v1/groups/1/announcements", bytes.NewReader([]byte(util.ToJson(map[string]interface{}{
+		"title":        "群规",
+		"content":      "**禁止**发广告",
+		"need_confirm": 1,
+	}))))
+	req.Header.Set("token", testutil.Token)
+	s.GetRoute().ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	announcements, err := f.db.syncAnnouncements("1", 0, 100)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, len(announcements))
+	assert.Contains(t, newAnnouncementResp(announcements[0]).ContentHTML, "<strong>禁止</strong>")
+
+	w = httptest.NewRecorder()
+	req, _ = http.NewRequest("POST", "/v1/groups/1/announcements/"+announcements[0].AnnouncementNo+"/confirm", nil)
+	req.Header.Set("token", testutil.Token)
+	s.GetRoute().ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	w = httptest.NewRecorder()
+	req, _ = http.NewRequest("GET", "/v1/groups/1/announcementsync?version=0", nil)
+	req.Header.Set("token", testutil.Token)
+	s.GetRoute().ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, true, strings.Contains(w.Body.String(), `"confirmed":1`))
+}
+
+func TestSlowModeGet(t *testing.T) {
+	s, ctx := testutil.NewTestServer()
+	f := New(ctx)
+	f.Route(s.GetRoute())
+
+	// 先清空旧数据
+	err := testutil.CleanAllTables(ctx)
+	assert.NoError(t, err)
+
+	err = f.db.Insert(&Model{
+		GroupNo:  "1",
+		Name:     "test",
+		Creator:  "10001",
+		Version:  1,
+		Status:   1,
+		SlowMode: 30,
+	})
+	assert.NoError(t, err)
+	err = f.db.InsertMember(&MemberModel{
+		GroupNo: "1",
+		UID:     testutil.UID,
+		Role:    MemberRoleCommon,
+		Status:  1,
+	})
+	assert.NoError(t, err)
+
+	sentAt := time.Now().Unix()
+	allowed, err := f.groupService.StartSlowModeCooldown("1", testutil.UID, "1001", sentAt)
+	assert.NoError(t, err)
+	assert.True(t, allowed)
+	// webhook重复推送开始冷却的消息不视为违反慢速模式
+	allowed, err = f.groupService.StartSlowModeCooldown("1", testutil.UID, "1001", sentAt)
+	assert.NoError(t, err)
+	assert.True(t, allowed)
+	// 黑名单生效前连续发送的消息视为违反慢速模式
+	allowed, err = f.groupService.StartSlowModeCooldown("1", testutil.UID, "1002", sentAt+1)
+	assert.NoError(t, err)
+	assert.False(t, allowed)
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/v1/groups/1/slowmode", nil)
+	req.Header.Set("token", testutil.Token)
+	s.GetRoute().ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, true, strings.Contains(w.Body.String(), `"slow_mode":30`))
+	assert.Equal(t, true, strings.Contains(w.Body.String(), `"exempt":0`))
+	assert.Equal(t, false, strings.Contains(w.Body.String(), `"wait":0`))
+
+	// 角色拥有不受慢速模式限制权限的成员不进入冷却
+	err = f.db.insertRole(&roleModel{
+		RoleNo:      "role1",
+		GroupNo:     "1",
+		Name:        "role1",
+		Permissions: int(PermissionIgnoreSlowMode),
+		Creator:     "10001",
+	})
+	assert.NoError(t, err)
+	err = f.db.InsertMember(&MemberModel{
+		GroupNo: "1",
+		UID:     "10002",
+		Role:    MemberRoleCommon,
+		RoleNo:  "role1",
+		Status:  1,
+	})
+	assert.NoError(t, err)
+	allowed, err = f.groupService.StartSlowModeCooldown("1", "10002", "1003", sentAt)
+	assert.NoError(t, err)
+	assert.True(t, allowed)
+	allowed, err = f.groupService.StartSlowModeCooldown("1", "10002", "1004", sentAt+1)
+	assert.NoError(t, err)
+	assert.True(t, allowed)
+}
+
+func TestMemberRoleSet(t *testing.T) {
+	s, ctx := testutil.NewTestServer()
+	f := New(ctx)
+	f.Route(s.GetRoute())
+
+	// 先清空旧数据
+	err := testutil.CleanAllTables(ctx)
+	assert.NoError(t, err)
+
+	err = f.db.Insert(&Model{
+		GroupNo: "1",
+		Name:    "test",
+		Creator: testutil.UID,
+		Version: 1,
+		Status:  1,
+	})
+	assert.NoError(t, err)
+	err = f.db.InsertMember(&MemberModel{
+		GroupNo: "1",
+		UID:     testutil.UID,
+		Role:    MemberRoleCreator,
+		Status:  1,
+	})
+	assert.NoError(t, err)
+	err = f.db.InsertMember(&MemberModel{
+		GroupNo: "1",
+		UID:     "10001",
+		Role:    MemberRoleCommon,
+		Status:  1,
+	})
+	assert.NoError(t, err)
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("POST", "/v1/groups/1/roles", bytes.NewReader([]byte(util.ToJson(map[string]interface{}{
+		"name":        "版主",
+		"permissions": int(PermissionForbidden | PermissionPinMessage),
+	}))))
+	req.Header.Set("token", testutil.Token)
+	s.GetRoute().ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	roles, err := f.db.queryRolesWithGroupNo("1")
+	assert.NoError(t, err)
+	assert.Equal(t, 1, len(roles))
+
+	w = httptest.NewRecorder()
+	req, _ = http.NewRequest("PUT", "/v1/groups/1/members/10001/role", bytes.NewReader([]byte(util.ToJson(map[string]interface{}{
+		"role_no": roles[0].RoleNo,
+	}))))
+	req.Header.Set("token", testutil.Token)
+	s.GetRoute().ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	canForbidden, err := f.permission.hasPermission("1", "10001", PermissionForbidden)
+	assert.NoError(t, err)
+	assert.Equal(t, true, canForbidden)
+	canRemove, err := f.permission.hasPermission("1", "10001", PermissionRemoveMember)
+	assert.NoError(t, err)
+	assert.Equal(t, false, canRemove)
+	canOperateCreator, err := f.permission.canOperateMember("1", "10001", testutil.UID, PermissionForbidden)
+	assert.NoError(t, err)
+	assert.Equal(t, false, canOperateCreator)
+}
@@ -27,6 +27,74 @@ const (
 	InviteStatusOK = 1
 )
 
+// 入群申请状态
+const (
+	// JoinApplyStatusWait 待审核
+	JoinApplyStatusWait = 0
+	// JoinApplyStatusApproved 已通过
+	JoinApplyStatusApproved = 1
+	// JoinApplyStatusRejected 已拒绝
+	JoinApplyStatusRejected = 2
+)
+
+// 邀请链接状态
+const (
+	// InviteLinkStatusRevoked 已撤销
+	InviteLinkStatusRevoked = 0
+	// InviteLinkStatusNormal 正常
+	InviteLinkStatusNormal = 1
+)
+
+// Permission 群内权限（按位组合，群主和管理员拥有全部权限，普通成员的权限来自其自定义角色）
+type Permission int
+
+const (
+	// PermissionInvite 邀请成员（包括管理邀请链接、审核入群申请）
+	PermissionInvite Permission = 1 << iota
+	// PermissionRemoveMember 移除成员（包括拉黑成员）
+	PermissionRemoveMember
+	// PermissionForbidden 禁言
+	PermissionForbidden
+	// PermissionPinMessage 置顶消息
+	PermissionPinMessage
+	// PermissionEditGroup 修改群信息和群设置
+	PermissionEditGroup
+	// PermissionAnnouncement 管理群公告
+	PermissionAnnouncement
+	// PermissionRevokeMessage 撤回或删除其他成员的消息
+	PermissionRevokeMessage
+	// PermissionClosePoll 结束其他成员发起的投票
+	PermissionClosePoll
+	// PermissionViewMessageDetail 查看其他成员消息的编辑历史和回执
+	PermissionViewMessageDetail
+	// PermissionIgnoreSlowMode 不受慢速模式限制
+	PermissionIgnoreSlowMode
+
+	// PermissionAll 全部权限
+	PermissionAll = PermissionInvite | PermissionRemoveMember | PermissionForbidden | PermissionPinMessage | PermissionEditGroup | PermissionAnnouncement | PermissionRevokeMessage | PermissionClosePoll | PermissionViewMessageDetail | PermissionIgnoreSlowMode
+)
+
+// InviteLinkCodePrefix 邀请链接二维码的code前缀 格式：group_invite_xxxx
+const InviteLinkCodePrefix = "group_invite_"
+
+const (
+	// GroupAttrKeyJoinApply 是否允许用户搜索并申请入群
+	GroupAttrKeyJoinApply = "join_apply"
+	// GroupAttrKeySlowMode 慢速模式（普通成员两次发言的最小间隔秒数，0表示关闭）
+	GroupAttrKeySlowMode = "slow_mode"
+)
+
+const (
+	// CMDGroupJoinApply 有新的入群申请（发给群主和管理员）
+	CMDGroupJoinApply = "groupJoinApply"
+	// CMDGroupJoinApplyResult 入群申请已审核（发给申请者）
+	CMDGroupJoinApplyResult = "groupJoinApplyResult"
+	// CMDSyncGroupAnnouncement 群公告有变化，需要同步（发到群频道）
+	CMDSyncGroupAnnouncement = "syncGroupAnnouncement"
+	// CMDGroupRoleUpdate 群自定义角色有变化（发到群频道）
+	CMDGroupRoleUpdate = "groupRoleUpdate"
+)
+
 // 群类型
 type GroupType int
 
@@ -99,6 +99,13 @@ func (d *DB) queryMemberWithVercodes(vercodes []string) ([]*MemberGroupDetailMod
 	return memberModels, err
 }
 
+// 查询用户加入的群（包含群名称）
+func (d *DB) queryMembersWithUID(uid string) ([]*MemberGroupDetailModel, error) {
+	var memberModels []*MemberGroupDetailModel
+	_, err := d.session.Select("group_member.*,IFNULL(`group`.name,'') group_name").From("group_member").LeftJoin("group", "`group`.group_no=group_member.group_no").Where("group_member.uid=? and group_member.is_deleted=0", uid).OrderAsc("group_member.id").Load(&memberModels)
+	return memberModels, err
+}
+
 // QueryIsGroupManagerOrCreator 是否是群管理者或创建者
 func (d *DB) QueryIsGroupManagerOrCreator(groupNo string, uid string) (bool, error) {
 	var count int64
@@ -143,7 +150,7 @@ func (d *DB) UpdateMembersToManager(groupNo string, members []string, version in
 	if len(members) <= 0 {
 		return nil
 	}
-	_, err := d.session.Update("group_member").Set("role", MemberRoleManager).Set("version", version).Where("group_no=? and uid in ? and is_deleted=0", groupNo, members).Exec()
+	_, err := d.session.Update("group_member").Set("role", MemberRoleManager).Set("role_no", "").Set("version", version).Where("group_no=? and uid in ? and is_deleted=0", groupNo, members).Exec()
 	return err
 }
 
@@ -191,12 +198,14 @@ func (d *DB) UpdateMemberTx(member *MemberModel, tx *dbr.Tx) error {
 // recoverMemberTx 恢复成员信息
 func (d *DB) recoverMemberTx(member *MemberModel, tx *dbr.Tx) error {
 	_, err := tx.Update("group_member").SetMap(map[string]interface{}{
-		"remark":     member.Remark,
-		"role":       member.Role,
-		"version":    member.Version,
-		"is_deleted": 0,
-		"invite_uid": member.InviteUID,
-		"created_at": dbr.Expr("Now()"),
+		"remark":         member.Remark,
+		"role":           member.Role,
+		"version":        member.Version,
+		"is_deleted":     0,
+		"invite_uid":     member.InviteUID,
+		"invite_link_no": member.InviteLinkNo,
+		"role_no":        member.RoleNo,
+		"created_at":     dbr.Expr("Now()"),
 	}).Where("group_no=? and uid=?", member.GroupNo, member.UID).Exec()
 	return err
 }
@@ -270,6 +279,8 @@ func (d *DB) Update(model *Model) error {
 		"forbidden_add_friend":        model.ForbiddenAddFriend,
 		"allow_view_history_msg":      model.AllowViewHistoryMsg,
 		"allow_member_pinned_message": model.AllowMemberPinnedMessage,
+		"join_apply":                  model.JoinApply,
+		"slow_mode":                   model.SlowMode,
 	}).Where("id=?", model.Id).Exec()
 	return err
 }
@@ -344,7 +355,7 @@ func (d *DB) QueryMemberWithUIDAndGroupNos(uid string, groupNos []string) ([]*Me
 func (d *DB) SyncMembers(groupNo string, version int64, limit uint64) ([]*MemberDetailModel, error) {
 
 	var details []*MemberDetailModel
-	builder := d.session.Select("group_member.id,group_member.vercode,group_member.uid,group_member.status,group_member.group_no,group_member.remark,group_member.role,IFNULL(user.name,'') name,IFNULL(user.username,'') username,group_member.is_deleted,group_member.robot,group_member.version,group_member.invite_uid,group_member.forbidden_expir_time,group_member.created_at,group_member.updated_at").From("group_member").LeftJoin("user", "group_member.uid=user.uid").Where("group_member.group_no=?", groupNo).OrderDir("group_member.version", true)
+	builder := d.session.Select("group_member.id,group_member.vercode,group_member.uid,group_member.status,group_member.group_no,group_member.remark,group_member.role,IFNULL(user.name,'') name,IFNULL(user.username,'') username,group_member.is_deleted,group_member.robot,group_member.version,group_member.invite_uid,group_member.invite_link_no,group_member.role_no,group_member.forbidden_expir_time,group_member.created_at,group_member.updated_at").From("group_member").LeftJoin("user", "group_member.uid=user.uid").Where("group_member.group_no=?", groupNo).OrderDir("group_member.version", true)
 	var err error
 	if version <= 0 {
 		_, err = builder.Limit(limit).Load(&details)
@@ -360,9 +371,9 @@ func (d *DB) queryMembersWithKeyword(groupNo string, loginUID string, keyword st
 	var details []*MemberDetailModel
 	var builder *dbr.SelectStmt
 	if keyword != "" {
-		builder = d.session.Select("group_member.id,group_member.vercode,group_member.uid,group_member.status,group_member.group_no,group_member.remark,group_member.role,IFNULL(user.name,'') name,IFNULL(user.username,'') username,group_member.is_deleted,group_member.robot,group_member.version,group_member.invite_uid,group_member.forbidden_expir_time,group_member.created_at,group_member.updated_at").From("group_member").LeftJoin("user", "group_member.uid=user.uid").LeftJoin("user_setting", fmt.Sprintf("user_setting.uid='%s' and user_setting.to_uid=group_member.uid", loginUID)).Where("group_member.group_no=? and group_member.is_deleted=0 and group_member.status=1 and (group_member.remark like ? or user.name like ? or user_setting.remark like ?)", groupNo, "%"+keyword+"%", "%"+keyword+"%", "%"+keyword+"%").OrderAsc("group_member.created_at")
+		builder = d.session.Select("group_member.id,group_member.vercode,group_member.uid,group_member.status,group_member.group_no,group_member.remark,group_member.role,IFNULL(user.name,'') name,IFNULL(user.username,'') username,group_member.is_deleted,group_member.robot,group_member.version,group_member.invite_uid,group_member.invite_link_no,group_member.role_no,group_member.forbidden_expir_time,group_member.created_at,group_member.updated_at").From("group_member").LeftJoin("user", "group_member.uid=user.uid").LeftJoin("user_setting", fmt.Sprintf("user_setting.uid='%s' and user_setting.to_uid=group_member.uid", loginUID)).Where("group_member.group_no=? and group_member.is_deleted=0 and group_member.status=1 and (group_member.remark like ? or user.name like ? or user_setting.remark like ?)", groupNo, "%"+keyword+"%", "%"+keyword+"%", "%"+keyword+"%").OrderAsc("group_member.created_at")
 	} else {
-		builder = d.session.Select("group_member.id,group_member.vercode,group_member.uid,group_member.status,group_member.group_no,group_member.remark,group_member.role,IFNULL(user.name,'') name,IFNULL(user.username,'') username,group_member.is_deleted,group_member.robot,group_member.version,group_member.invite_uid,group_member.forbidden_expir_time,group_member.created_at,group_member.updated_at").From("group_member").LeftJoin("user", "group_member.uid=user.uid").Where("group_member.group_no=? and group_member.is_deleted=0 and group_member.status=1", groupNo).OrderDesc(fmt.Sprintf("group_member.role=%d", MemberRoleCreator)).OrderDesc(fmt.Sprintf("group_member.role=%d", MemberRoleManager)).OrderAsc("group_member.created_at")
+		builder = d.session.Select("group_member.id,group_member.vercode,group_member.uid,group_member.status,group_member.group_no,group_member.remark,group_member.role,IFNULL(user.name,'') name,IFNULL(user.username,'') username,group_member.is_deleted,group_member.robot,group_member.version,group_member.invite_uid,group_member.invite_link_no,group_member.role_no,group_member.forbidden_expir_time,group_member.created_at,group_member.updated_at").From("group_member").LeftJoin("user", "group_member.uid=user.uid").Where("group_member.group_no=? and group_member.is_deleted=0 and group_member.status=1", groupNo).OrderDesc(fmt.Sprintf("group_member.role=%d", MemberRoleCreator)).OrderDesc(fmt.Sprintf("group_member.role=%d", MemberRoleManager)).OrderAsc("group_member.created_at")
 	}
 	var err error
 	_, err = builder.Offset((page - 1) * limit).Limit(limit).Load(&details)
@@ -384,7 +395,7 @@ func (d *DB) queryMembersWithGroupNo(groupNo string) ([]*MemberDetailModel, erro
 
 func (d *DB) queryMemberWithGroupNoAndUID(groupNo, uid string) (*MemberDetailModel, error) {
 	var detail *MemberDetailModel
-	_, err := d.session.Select("group_member.id,group_member.vercode,group_member.uid,group_member.status,group_member.group_no,group_member.remark,group_member.role,group_member.invite_uid,IFNULL(user.name,'') name,group_member.is_deleted,group_member.version,group_member.forbidden_expir_time,group_member.created_at,group_member.updated_at").From("group_member").LeftJoin("user", "group_member.uid=user.uid").Where("group_member.group_no=? and group_member.uid=? and group_member.is_deleted=0", groupNo, uid).Load(&detail)
+	_, err := d.session.Select("group_member.id,group_member.vercode,group_member.uid,group_member.status,group_member.group_no,group_member.remark,group_member.role,group_member.invite_uid,group_member.invite_link_no,group_member.role_no,IFNULL(user.name,'') name,group_member.is_deleted,group_member.version,group_member.forbidden_expir_time,group_member.created_at,group_member.updated_at").From("group_member").LeftJoin("user", "group_member.uid=user.uid").Where("group_member.group_no=? and group_member.uid=? and group_member.is_deleted=0", groupNo, uid).Load(&detail)
 	return detail, err
 }
 func (d *DB) queryBlacklistMemberUIDsWithGroupNo(groupNo string) ([]string, error) {
@@ -393,6 +404,13 @@ func (d *DB) queryBlacklistMemberUIDsWithGroupNo(groupNo string) ([]string, erro
 	return uids, err
 }
 
+// 查询被拉黑或禁言中的成员uid
+func (d *DB) queryBlacklistOrForbiddenMemberUIDs(groupNo string, now int64) ([]string, error) {
+	var uids []string
+	_, err := d.session.Select("uid").From("group_member").Where("group_no=? and is_deleted=0 and (status=? or forbidden_expir_time>?)", groupNo, common.GroupMemberStatusBlacklist, now).Load(&uids)
+	return uids, err
+}
+
 // 查询在线成员数量
 func (d *DB) queryMemberOnlineCount(groupNo string) (int64, error) {
 	var count int64
@@ -517,6 +535,8 @@ type Model struct {
 	ForbiddenAddFriend       int    //群内禁止加好友
 	AllowViewHistoryMsg      int    // 是否允许新成员查看历史消息
 	AllowMemberPinnedMessage int    // 是否允许群成员置顶消息
+	JoinApply                int    // 是否允许用户搜索并申请入群 0.否 1.是
+	SlowMode                 int    // 慢速模式，普通成员两次发言的最小间隔（秒），0表示关闭
 	Category                 string // 群分类
 	db.BaseModel
 }
@@ -532,6 +552,8 @@ type MemberModel struct {
 	Vercode            string //验证码
 	IsDeleted          int    // 是否删除
 	InviteUID          string // 邀请者
+	InviteLinkNo       string // 入群邀请链接编号
+	RoleNo             string // 自定义角色编号
 	Robot              int    // 机器人
 	ForbiddenExpirTime int64  // 禁言时长
 	db.BaseModel
@@ -547,6 +569,8 @@ type MemberDetailModel struct {
 	Version            int64
 	Vercode            string //验证码
 	InviteUID          string // 邀请人
+	InviteLinkNo       string // 入群邀请链接编号
+	RoleNo             string // 自定义角色编号
 	IsDeleted          int    // 是否删除
 	Status             int    // 1.正常 2.黑名单
 	Username           string
@@ -508,6 +508,7 @@ func (g *Group) handleOrgOrDeptEmployeeUpdate(data []byte, commit config.EventCo
 			commit(err)
 			return
 		}
+		syncThreadSubscribers(g.ctx, m.GroupNo, uids, true)
 		content := fmt.Sprintf("欢迎%s 加入 %s，新成员入群可查看所有历史消息", strings.Join(params, ","), groupName)
 		err = g.ctx.SendMessage(&config.MsgSendReq{
 			Header: config.MsgHeader{
@@ -555,6 +556,7 @@ func (g *Group) handleOrgOrDeptEmployeeUpdate(data []byte, commit config.EventCo
 				commit(err)
 				return
 			}
+			syncThreadSubscribers(g.ctx, m.GroupNo, members, false)
 			// 发送群成员更新命令
 			err = g.ctx.SendCMD(config.MsgCMDReq{
 				ChannelID:   m.GroupNo,
@@ -711,6 +713,7 @@ func (g *Group) handleOrgEmployeeExit(data []byte, commit config.EventCommit) {
 			commit(err)
 			return
 		}
+		syncThreadSubscribers(g.ctx, groupNo, members, false)
 		// 发送群成员更新命令
 		err = g.ctx.SendCMD(config.MsgCMDReq{
 			ChannelID:   groupNo,
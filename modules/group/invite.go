@@ -133,14 +133,14 @@ func (g *Group) getToGroupMemberConfirmInviteDetailH5(c *wkhttp.Context) {
 		return
 	}
 
-	managerOrCreator, err := g.db.QueryIsGroupManagerOrCreator(groupNo, loginUID)
+	canInvite, err := g.permission.hasPermission(groupNo, loginUID, PermissionInvite)
 	if err != nil {
-		g.Error("查询是否管理者或创建者失败！")
-		c.ResponseError(errors.New("查询是否管理者或创建者失败！"))
+		g.Error("查询群内权限失败！", zap.Error(err))
+		c.ResponseError(errors.New("查询群内权限失败！"))
 		return
 	}
-	if !managerOrCreator {
-		c.ResponseError(errors.New("你不是群主或管理员！"))
+	if !canInvite {
+		c.ResponseError(errors.New("你没有邀请成员的权限！"))
 		return
 	}
 	authCode := util.GenerUUID()
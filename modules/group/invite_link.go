@@ -0,0 +1,273 @@
+package group
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/TangSengDaoDao/TangSengDaoDaoServerLib/pkg/util"
+	"github.com/TangSengDaoDao/TangSengDaoDaoServerLib/pkg/wkhttp"
+	"go.uber.org/zap"
+)
+
+const (
+	inviteLinkNameMaxLen  = 50     // 邀请链接名称的最大长度
+	inviteLinkMaxCount    = 100    // 每个群最多的邀请链接数量
+	inviteLinkMaxUsesMax  = 100000 // 最大使用次数的上限
+	inviteLinkMembersSize = 500    // 链接成员列表返回的最大数量
+)
+
+// 创建邀请链接（群主和管理员）
+func (g *Group) inviteLinkAdd(c *wkhttp.Context) {
+	loginUID := c.GetLoginUID()
+	groupNo := c.Param("group_no")
+	var req struct {
+		Name        string `json:"name"`         // 链接名称
+		ExpireAt    int64  `json:"expire_at"`    // 过期时间（10位时间戳，0表示永不过期）
+		MaxUses     int    `json:"max_uses"`     // 最大使用次数（0表示不限制）
+		NeedApprove int    `json:"need_approve"` // 是否需要审核 0.否 1.是
+	}
+	if err := c.BindJSON(&req); err != nil {
+		c.ResponseError(errors.New("数据格式有误！"))
+		return
+	}
+	req.Name = strings.TrimSpace(req.Name)
+	if utf8.RuneCountInString(req.Name) > inviteLinkNameMaxLen {
+		c.ResponseError(errors.New("链接名称不能超过" + strconv.Itoa(inviteLinkNameMaxLen) + "个字！"))
+		return
+	}
+	if req.ExpireAt < 0 || (req.ExpireAt > 0 && req.ExpireAt <= time.Now().Unix()) {
+		c.ResponseError(errors.New("过期时间有误！"))
+		return
+	}
+	if req.MaxUses < 0 || req.MaxUses > inviteLinkMaxUsesMax {
+		c.ResponseError(errors.New("最大使用次数有误！"))
+		return
+	}
+	if req.NeedApprove != 0 && req.NeedApprove != 1 {
+		c.ResponseError(errors.New("是否需要审核的值有误！"))
+		return
+	}
+	_, err := g.getGroupInfo(groupNo)
+	if err != nil {
+		c.ResponseError(err)
+		return
+	}
+	if err := g.checkInviteLinkManager(groupNo, loginUID); err != nil {
+		c.ResponseError(err)
+		return
+	}
+	links, err := g.db.queryInviteLinksWithGroupNo(groupNo)
+	if err != nil {
+		g.Error("查询邀请链接失败！", zap.Error(err))
+		c.ResponseError(errors.New("查询邀请链接失败！"))
+		return
+	}
+	if len(links) >= inviteLinkMaxCount {
+		c.ResponseError(errors.New("每个群最多创建" + strconv.Itoa(inviteLinkMaxCount) + "个邀请链接！"))
+		return
+	}
+	link := &InviteLinkModel{
+		LinkNo:      util.GenerUUID(),
+		GroupNo:     groupNo,
+		Name:        req.Name,
+		Creator:     loginUID,
+		ExpireAt:    req.ExpireAt,
+		MaxUses:     req.MaxUses,
+		NeedApprove: req.NeedApprove,
+		Status:      InviteLinkStatusNormal,
+	}
+	err = g.db.insertInviteLink(link)
+	if err != nil {
+		g.Error("添加邀请链接失败！", zap.Error(err))
+		c.ResponseError(errors.New("添加邀请链接失败！"))
+		return
+	}
+	link, err = g.db.QueryInviteLinkWithLinkNo(link.LinkNo)
+	if err != nil {
+		g.Error("查询邀请链接失败！", zap.Error(err))
+		c.ResponseError(errors.New("查询邀请链接失败！"))
+		return
+	}
+	c.Response(g.newInviteLinkResp(link))
+}
+
+// 群的邀请链接列表（群主和管理员）
+func (g *Group) inviteLinkList(c *wkhttp.Context) {
+	groupNo := c.Param("group_no")
+	_, err := g.getGroupInfo(groupNo)
+	if err != nil {
+		c.ResponseError(err)
+		return
+	}
+	if err := g.checkInviteLinkManager(groupNo, c.GetLoginUID()); err != nil {
+		c.ResponseError(err)
+		return
+	}
+	links, err := g.db.queryInviteLinksWithGroupNo(groupNo)
+	if err != nil {
+		g.Error("查询邀请链接失败！", zap.Error(err))
+		c.ResponseError(errors.New("查询邀请链接失败！"))
+		return
+	}
+	resps := make([]*inviteLinkResp, 0, len(links))
+	for _, link := range links {
+		resps = append(resps, g.newInviteLinkResp(link))
+	}
+	c.Response(resps)
+}
+
+// 撤销邀请链接（群主和管理员）
+func (g *Group) inviteLinkRevoke(c *wkhttp.Context) {
+	groupNo := c.Param("group_no")
+	link, err := g.getInviteLink(groupNo, c.Param("link_no"), c.GetLoginUID())
+	if err != nil {
+		c.ResponseError(err)
+		return
+	}
+	if link.Status == InviteLinkStatusRevoked {
+		c.ResponseOK()
+		return
+	}
+	err = g.db.revokeInviteLink(link.LinkNo)
+	if err != nil {
+		g.Error("撤销邀请链接失败！", zap.Error(err))
+		c.ResponseError(errors.New("撤销邀请链接失败！"))
+		return
+	}
+	c.ResponseOK()
+}
+
+// 通过邀请链接入群的成员（群主和管理员）
+func (g *Group) inviteLinkMembers(c *wkhttp.Context) {
+	groupNo := c.Param("group_no")
+	link, err := g.getInviteLink(groupNo, c.Param("link_no"), c.GetLoginUID())
+	if err != nil {
+		c.ResponseError(err)
+		return
+	}
+	members, err := g.db.queryMembersWithInviteLinkNo(groupNo, link.LinkNo, inviteLinkMembersSize)
+	if err != nil {
+		g.Error("查询通过邀请链接入群的成员失败！", zap.Error(err))
+		c.ResponseError(errors.New("查询通过邀请链接入群的成员失败！"))
+		return
+	}
+	resps := make([]*inviteLinkMemberResp, 0, len(members))
+	for _, member := range members {
+		resps = append(resps, &inviteLinkMemberResp{
+			UID:       member.UID,
+			Name:      member.Name,
+			Role:      member.Role,
+			InviteUID: member.InviteUID,
+			JoinedAt:  member.CreatedAt.String(),
+		})
+	}
+	c.Response(resps)
+}
+
+// 通过需要审核的邀请链接入群时，转为入群申请等待群主或管理员审核
+func (g *Group) addInviteLinkJoinApply(group *Model, link *InviteLinkModel, uid, name string) (string, error) {
+	waitApply, err := g.db.queryWaitJoinApply(group.GroupNo, uid)
+	if err != nil {
+		g.Error("查询待审核的入群申请失败！", zap.Error(err))
+		return "", errors.New("查询待审核的入群申请失败！")
+	}
+	if waitApply != nil {
+		return "", errors.New("已提交过申请，请等待审核！")
+	}
+	applyNo := util.GenerUUID()
+	err = g.db.insertJoinApply(&joinApplyModel{
+		ApplyNo:      applyNo,
+		GroupNo:      group.GroupNo,
+		UID:          uid,
+		Answers:      util.ToJson([]*joinAnswer{}),
+		Status:       JoinApplyStatusWait,
+		InviteLinkNo: link.LinkNo,
+	})
+	if err != nil {
+		g.Error("添加入群申请失败！", zap.Error(err))
+		return "", errors.New("添加入群申请失败！")
+	}
+	g.notifyJoinApplyAdd(applyNo, group.GroupNo, group.Name, uid, name)
+	return applyNo, nil
+}
+
+// 拥有邀请权限的成员（群主、管理员或自定义角色）可以管理邀请链接
+func (g *Group) checkInviteLinkManager(groupNo string, uid string) error {
+	canInvite, err := g.permission.hasPermission(groupNo, uid, PermissionInvite)
+	if err != nil {
+		g.Error("查询群内权限失败！", zap.Error(err))
+		return errors.New("查询群内权限失败！")
+	}
+	if !canInvite {
+		return errors.New("没有管理邀请链接的权限！")
+	}
+	return nil
+}
+
+// 获取邀请链接并校验管理权限
+func (g *Group) getInviteLink(groupNo, linkNo, loginUID string) (*InviteLinkModel, error) {
+	_, err := g.getGroupInfo(groupNo)
+	if err != nil {
+		return nil, err
+	}
+	if err := g.checkInviteLinkManager(groupNo, loginUID); err != nil {
+		return nil, err
+	}
+	link, err := g.db.QueryInviteLinkWithLinkNo(linkNo)
+	if err != nil {
+		g.Error("查询邀请链接失败！", zap.Error(err))
+		return nil, errors.New("查询邀请链接失败！")
+	}
+	if link == nil || link.GroupNo != groupNo {
+		return nil, errors.New("邀请链接不存在！")
+	}
+	return link, nil
+}
+
+type inviteLinkResp struct {
+	LinkNo      string `json:"link_no"`
+	GroupNo     string `json:"group_no"`
+	Name        string `json:"name"`         // 链接名称
+	Creator     string `json:"creator"`      // 创建者uid
+	QRCode      string `json:"qrcode"`       // 链接地址（用于生成二维码）
+	ExpireAt    int64  `json:"expire_at"`    // 过期时间（10位时间戳，0表示永不过期）
+	MaxUses     int    `json:"max_uses"`     // 最大使用次数（0表示不限制）
+	UseCount    int    `json:"use_count"`    // 已通过此链接入群的人数
+	NeedApprove int    `json:"need_approve"` // 是否需要审核 0.否 1.是
+	Status      int    `json:"status"`       // 状态 1.正常 0.已撤销
+	Available   int    `json:"available"`    // 当前是否可用（未撤销、未过期、次数未用完）
+	CreatedAt   string `json:"created_at"`
+}
+
+func (g *Group) newInviteLinkResp(m *InviteLinkModel) *inviteLinkResp {
+	available := 0
+	if m.Check() == nil {
+		available = 1
+	}
+	return &inviteLinkResp{
+		LinkNo:      m.LinkNo,
+		GroupNo:     m.GroupNo,
+		Name:        m.Name,
+		Creator:     m.Creator,
+		QRCode:      fmt.Sprintf("%s/%s", g.ctx.GetConfig().External.BaseURL, strings.ReplaceAll(g.ctx.GetConfig().QRCodeInfoURL, ":code", InviteLinkCodePrefix+m.LinkNo)),
+		ExpireAt:    m.ExpireAt,
+		MaxUses:     m.MaxUses,
+		UseCount:    m.UseCount,
+		NeedApprove: m.NeedApprove,
+		Status:      m.Status,
+		Available:   available,
+		CreatedAt:   m.CreatedAt.String(),
+	}
+}
+
+type inviteLinkMemberResp struct {
+	UID       string `json:"uid"`
+	Name      string `json:"name"`
+	Role      int    `json:"role"`
+	InviteUID string `json:"invite_uid"` // 邀请人（链接创建者或审核者）
+	JoinedAt  string `json:"joined_at"`  // 入群时间
+}
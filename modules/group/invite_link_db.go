@@ -0,0 +1,86 @@
+package group
+
+import (
+	"errors"
+	"time"
+
+	"github.com/TangSengDaoDao/TangSengDaoDaoServerLib/pkg/db"
+	"github.com/TangSengDaoDao/TangSengDaoDaoServerLib/pkg/util"
+	"github.com/gocraft/dbr/v2"
+)
+
+// 添加邀请链接
+func (d *DB) insertInviteLink(m *InviteLinkModel) error {
+	_, err := d.session.InsertInto("group_invite_link").Columns(util.AttrToUnderscore(m)...).Record(m).Exec()
+	return err
+}
+
+// QueryInviteLinkWithLinkNo 查询邀请链接
+func (d *DB) QueryInviteLinkWithLinkNo(linkNo string) (*InviteLinkModel, error) {
+	var m *InviteLinkModel
+	_, err := d.session.Select("*").From("group_invite_link").Where("link_no=?", linkNo).Load(&m)
+	return m, err
+}
+
+// 查询群的邀请链接
+func (d *DB) queryInviteLinksWithGroupNo(groupNo string) ([]*InviteLinkModel, error) {
+	var models []*InviteLinkModel
+	_, err := d.session.Select("*").From("group_invite_link").Where("group_no=?", groupNo).OrderDesc("id").Load(&models)
+	return models, err
+}
+
+// 撤销邀请链接
+func (d *DB) revokeInviteLink(linkNo string) error {
+	_, err := d.session.Update("group_invite_link").Set("status", InviteLinkStatusRevoked).Where("link_no=?", linkNo).Exec()
+	return err
+}
+
+// 占用一次邀请链接的使用次数（链接已撤销、已过期或次数已用完时不会修改）
+func (d *DB) useInviteLinkTx(linkNo string, tx *dbr.Tx) (int64, error) {
+	result, err := tx.Update("group_invite_link").Set("use_count", dbr.Expr("use_count+1")).Where("link_no=? and status=? and (expire_at=0 or expire_at>?) and (max_uses=0 or use_count<max_uses)", linkNo, InviteLinkStatusNormal, time.Now().Unix()).Exec()
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+// 更新成员的入群邀请链接
+func (d *DB) updateMemberInviteLinkTx(groupNo, uid, linkNo string, tx *dbr.Tx) error {
+	_, err := tx.Update("group_member").Set("invite_link_no", linkNo).Where("group_no=? and uid=?", groupNo, uid).Exec()
+	return err
+}
+
+// 查询通过邀请链接入群的成员
+func (d *DB) queryMembersWithInviteLinkNo(groupNo, linkNo string, limit uint64) ([]*MemberDetailModel, error) {
+	var models []*MemberDetailModel
+	_, err := d.session.Select("group_member.uid,group_member.group_no,group_member.role,group_member.invite_uid,group_member.invite_link_no,IFNULL(user.name,'') name,group_member.created_at,group_member.updated_at").From("group_member").LeftJoin("user", "group_member.uid=user.uid").Where("group_member.group_no=? and group_member.invite_link_no=? and group_member.is_deleted=0", groupNo, linkNo).OrderDesc("group_member.created_at").Limit(limit).Load(&models)
+	return models, err
+}
+
+// InviteLinkModel 群邀请链接
+type InviteLinkModel struct {
+	LinkNo      string // 链接编号
+	GroupNo     string // 群编号
+	Name        string // 链接名称
+	Creator     string // 创建者uid
+	ExpireAt    int64  // 过期时间（10位时间戳，0表示永不过期）
+	MaxUses     int    // 最大使用次数（0表示不限制）
+	UseCount    int    // 已通过此链接入群的人数
+	NeedApprove int    // 是否需要审核 0.否 1.是
+	Status      int    // 状态 1.正常 0.已撤销
+	db.BaseModel
+}
+
+// Check 检查邀请链接是否还能使用
+func (m *InviteLinkModel) Check() error {
+	if m.Status != InviteLinkStatusNormal {
+		return errors.New("邀请链接已被撤销！")
+	}
+	if m.ExpireAt > 0 && m.ExpireAt <= time.Now().Unix() {
+		return errors.New("邀请链接已过期！")
+	}
+	if m.MaxUses > 0 && m.UseCount >= m.MaxUses {
+		return errors.New("邀请链接使用次数已达上限！")
+	}
+	return nil
+}
@@ -0,0 +1,713 @@
+package group
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/TangSengDaoDao/TangSengDaoDaoServer/modules/user"
+	"github.com/TangSengDaoDao/TangSengDaoDaoServerLib/config"
+	"github.com/TangSengDaoDao/TangSengDaoDaoServerLib/pkg/util"
+	"github.com/TangSengDaoDao/TangSengDaoDaoServerLib/pkg/wkhttp"
+	"go.uber.org/zap"
+)
+
+const (
+	joinQuestionMaxCount = 5   // 入群问题最多数量
+	joinTextMaxLen       = 200 // 问题、回答、留言和拒绝原因的最大长度
+	joinSearchLimit      = 20  // 搜索群返回的最大数量
+)
+
+// 搜索可以申请加入的群
+func (g *Group) joinApplyGroupSearch(c *wkhttp.Context) {
+	loginUID := c.GetLoginUID()
+	keyword := strings.TrimSpace(c.Query("keyword"))
+	if keyword == "" {
+		c.ResponseError(errors.New("搜索关键字不能为空！"))
+		return
+	}
+	groups, err := g.db.queryJoinApplyGroupsWithKeyword(keyword, joinSearchLimit)
+	if err != nil {
+		g.Error("搜索群失败！", zap.Error(err))
+		c.ResponseError(errors.New("搜索群失败！"))
+		return
+	}
+	resps := make([]*joinApplyGroupResp, 0, len(groups))
+	if len(groups) == 0 {
+		c.Response(resps)
+		return
+	}
+	groupNos := make([]string, 0, len(groups))
+	for _, group := range groups {
+		groupNos = append(groupNos, group.GroupNo)
+	}
+	questionCountMap, err := g.db.queryJoinQuestionCounts(groupNos)
+	if err != nil {
+		g.Error("查询入群问题数量失败！", zap.Error(err))
+		c.ResponseError(errors.New("查询入群问题数量失败！"))
+		return
+	}
+	memberGroupNos, err := g.db.existMembers(groupNos, loginUID)
+	if err != nil {
+		g.Error("查询是否在群内失败！", zap.Error(err))
+		c.ResponseError(errors.New("查询是否在群内失败！"))
+		return
+	}
+	applyingGroupNos, err := g.db.queryWaitJoinApplyGroupNos(groupNos, loginUID)
+	if err != nil {
+		g.Error("查询待审核的入群申请失败！", zap.Error(err))
+		c.ResponseError(errors.New("查询待审核的入群申请失败！"))
+		return
+	}
+	memberMap := make(map[string]bool, len(memberGroupNos))
+	for _, memberGroupNo := range memberGroupNos {
+		memberMap[memberGroupNo] = true
+	}
+	applyingMap := make(map[string]bool, len(applyingGroupNos))
+	for _, applyingGroupNo := range applyingGroupNos {
+		applyingMap[applyingGroupNo] = true
+	}
+	for _, group := range groups {
+		memberCount, err := g.db.QueryMemberCount(group.GroupNo)
+		if err != nil {
+			g.Error("查询成员数量失败！", zap.Error(err))
+			c.ResponseError(errors.New("查询成员数量失败！"))
+			return
+		}
+		resp := &joinApplyGroupResp{
+			GroupNo:       group.GroupNo,
+			Name:          group.Name,
+			MemberCount:   memberCount,
+			QuestionCount: questionCountMap[group.GroupNo],
+		}
+		if memberMap[group.GroupNo] {
+			resp.IsMember = 1
+		}
+		if applyingMap[group.GroupNo] {
+			resp.Applying = 1
+		}
+		resps = append(resps, resp)
+	}
+	c.Response(resps)
+}
+
+// 获取入群问题
+func (g *Group) joinQuestionsGet(c *wkhttp.Context) {
+	groupNo := c.Param("group_no")
+	group, err := g.getGroupInfo(groupNo)
+	if err != nil {
+		c.ResponseError(err)
+		return
+	}
+	if group.JoinApply != 1 {
+		isMember, err := g.db.ExistMember(c.GetLoginUID(), groupNo)
+		if err != nil {
+			g.Error("查询是否在群内失败！", zap.Error(err))
+			c.ResponseError(errors.New("查询是否在群内失败！"))
+			return
+		}
+		if !isMember {
+			c.ResponseError(errors.New("该群不允许申请加入"))
+			return
+		}
+	}
+	questions, err := g.db.queryJoinQuestions(groupNo)
+	if err != nil {
+		g.Error("查询入群问题失败！", zap.Error(err))
+		c.ResponseError(errors.New("查询入群问题失败！"))
+		return
+	}
+	resps := make([]*joinQuestionResp, 0, len(questions))
+	for _, question := range questions {
+		resps = append(resps, &joinQuestionResp{
+			ID:       question.Id,
+			Question: question.Question,
+		})
+	}
+	c.Response(resps)
+}
+
+// 设置入群问题（只有群主可以设置，传空数组表示清空问题）
+func (g *Group) joinQuestionsSet(c *wkhttp.Context) {
+	loginUID := c.GetLoginUID()
+	groupNo := c.Param("group_no")
+	var req struct {
+		Questions []string `json:"questions"`
+	}
+	if err := c.BindJSON(&req); err != nil {
+		c.ResponseError(errors.New("数据格式有误！"))
+		return
+	}
+	if len(req.Questions) > joinQuestionMaxCount {
+		c.ResponseError(errors.New("入群问题最多" + strconv.Itoa(joinQuestionMaxCount) + "个！"))
+		return
+	}
+	questions := make([]string, 0, len(req.Questions))
+	for _, question := range req.Questions {
+		question = strings.TrimSpace(question)
+		if question == "" {
+			c.ResponseError(errors.New("入群问题不能为空！"))
+			return
+		}
+		if utf8.RuneCountInString(question) > joinTextMaxLen {
+			c.ResponseError(errors.New("入群问题不能超过" + strconv.Itoa(joinTextMaxLen) + "个字！"))
+			return
+		}
+		questions = append(questions, question)
+	}
+	_, err := g.getGroupInfo(groupNo)
+	if err != nil {
+		c.ResponseError(err)
+		return
+	}
+	isCreator, err := g.db.QueryIsGroupCreator(groupNo, loginUID)
+	if err != nil {
+		g.Error("查询是否是群主失败！", zap.Error(err))
+		c.ResponseError(errors.New("查询是否是群主失败！"))
+		return
+	}
+	if !isCreator {
+		c.ResponseError(errors.New("只有群主才能设置入群问题！"))
+		return
+	}
+	tx, err := g.ctx.DB().Begin()
+	if err != nil {
+		g.Error("开启事务失败！", zap.Error(err))
+		c.ResponseError(errors.New("开启事务失败！"))
+		return
+	}
+	defer func() {
+		if err := recover(); err != nil {
+			tx.RollbackUnlessCommitted()
+			panic(err)
+		}
+	}()
+	err = g.db.deleteJoinQuestionsTx(groupNo, tx)
+	if err != nil {
+		tx.Rollback()
+		g.Error("删除入群问题失败！", zap.Error(err))
+		c.ResponseError(errors.New("删除入群问题失败！"))
+		return
+	}
+	for i, question := range questions {
+		err = g.db.insertJoinQuestionTx(&joinQuestionModel{
+			GroupNo:  groupNo,
+			Question: question,
+			SortNum:  i,
+		}, tx)
+		if err != nil {
+			tx.Rollback()
+			g.Error("添加入群问题失败！", zap.Error(err))
+			c.ResponseError(errors.New("添加入群问题失败！"))
+			return
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		tx.Rollback()
+		g.Error("提交事务失败！", zap.Error(err))
+		c.ResponseError(errors.New("提交事务失败！"))
+		return
+	}
+	c.ResponseOK()
+}
+
+// 申请加入群
+func (g *Group) joinApplyAdd(c *wkhttp.Context) {
+	loginUID := c.GetLoginUID()
+	loginName := c.GetLoginName()
+	groupNo := c.Param("group_no")
+	var req joinApplyReq
+	if err := c.BindJSON(&req); err != nil {
+		c.ResponseError(errors.New("数据格式有误！"))
+		return
+	}
+	req.Remark = strings.TrimSpace(req.Remark)
+	if utf8.RuneCountInString(req.Remark) > joinTextMaxLen {
+		c.ResponseError(errors.New("申请留言不能超过" + strconv.Itoa(joinTextMaxLen) + "个字！"))
+		return
+	}
+	group, err := g.getGroupInfo(groupNo)
+	if err != nil {
+		c.ResponseError(err)
+		return
+	}
+	if group.JoinApply != 1 {
+		c.ResponseError(errors.New("该群不允许申请加入"))
+		return
+	}
+	isMember, err := g.db.ExistMember(loginUID, groupNo)
+	if err != nil {
+		g.Error("查询是否在群内失败！", zap.Error(err))
+		c.ResponseError(errors.New("查询是否在群内失败！"))
+		return
+	}
+	if isMember {
+		c.ResponseError(errors.New("已经在群内，不能再申请！"))
+		return
+	}
+	waitApply, err := g.db.queryWaitJoinApply(groupNo, loginUID)
+	if err != nil {
+		g.Error("查询待审核的入群申请失败！", zap.Error(err))
+		c.ResponseError(errors.New("查询待审核的入群申请失败！"))
+		return
+	}
+	if waitApply != nil {
+		c.ResponseError(errors.New("已提交过申请，请等待审核！"))
+		return
+	}
+
+	// 每个入群问题都需要回答，保存时连同问题一起保存，避免群主修改问题后对不上
+	questions, err := g.db.queryJoinQuestions(groupNo)
+	if err != nil {
+		g.Error("查询入群问题失败！", zap.Error(err))
+		c.ResponseError(errors.New("查询入群问题失败！"))
+		return
+	}
+	answerMap := make(map[int64]string, len(req.Answers))
+	for _, answer := range req.Answers {
+		answerMap[answer.QuestionID] = strings.TrimSpace(answer.Answer)
+	}
+	answers := make([]*joinAnswer, 0, len(questions))
+	for _, question := range questions {
+		answer := answerMap[question.Id]
+		if answer == "" {
+			c.ResponseError(errors.New("请回答入群问题！"))
+			return
+		}
+		if utf8.RuneCountInString(answer) > joinTextMaxLen {
+			c.ResponseError(errors.New("回答不能超过" + strconv.Itoa(joinTextMaxLen) + "个字！"))
+			return
+		}
+		answers = append(answers, &joinAnswer{
+			Question: question.Question,
+			Answer:   answer,
+		})
+	}
+	applyNo := util.GenerUUID()
+	err = g.db.insertJoinApply(&joinApplyModel{
+		ApplyNo: applyNo,
+		GroupNo: groupNo,
+		UID:     loginUID,
+		Remark:  req.Remark,
+		Answers: util.ToJson(answers),
+		Status:  JoinApplyStatusWait,
+	})
+	if err != nil {
+		g.Error("添加入群申请失败！", zap.Error(err))
+		c.ResponseError(errors.New("添加入群申请失败！"))
+		return
+	}
+
+	g.notifyJoinApplyAdd(applyNo, groupNo, group.Name, loginUID, loginName)
+	c.Response(map[string]interface{}{
+		"apply_no": applyNo,
+	})
+}
+
+// 群的入群申请列表（群主和管理员）
+func (g *Group) joinApplyList(c *wkhttp.Context) {
+	groupNo := c.Param("group_no")
+	status := -1
+	if strings.TrimSpace(c.Query("status")) != "" {
+		statusI64, err := strconv.ParseInt(c.Query("status"), 10, 64)
+		if err != nil {
+			c.ResponseError(errors.New("状态有误！"))
+			return
+		}
+		status = int(statusI64)
+	}
+	pageIndex, pageSize := c.GetPage()
+	_, err := g.getGroupInfo(groupNo)
+	if err != nil {
+		c.ResponseError(err)
+		return
+	}
+	err = g.checkJoinApplyManager(groupNo, c.GetLoginUID())
+	if err != nil {
+		c.ResponseError(err)
+		return
+	}
+	models, err := g.db.queryJoinAppliesWithGroupNo(groupNo, status, uint64(pageIndex), uint64(pageSize))
+	if err != nil {
+		g.Error("查询入群申请失败！", zap.Error(err))
+		c.ResponseError(errors.New("查询入群申请失败！"))
+		return
+	}
+	count, err := g.db.queryJoinApplyCountWithGroupNo(groupNo, status)
+	if err != nil {
+		g.Error("查询入群申请数量失败！", zap.Error(err))
+		c.ResponseError(errors.New("查询入群申请数量失败！"))
+		return
+	}
+	list := make([]*joinApplyResp, 0, len(models))
+	for _, model := range models {
+		resp := newJoinApplyResp(&model.joinApplyModel)
+		resp.Name = model.Name
+		list = append(list, resp)
+	}
+	c.Response(map[string]interface{}{
+		"count": count,
+		"list":  list,
+	})
+}
+
+// 我的入群申请
+func (g *Group) joinApplyMy(c *wkhttp.Context) {
+	pageIndex, pageSize := c.GetPage()
+	models, err := g.db.queryJoinAppliesWithUID(c.GetLoginUID(), uint64(pageIndex), uint64(pageSize))
+	if err != nil {
+		g.Error("查询我的入群申请失败！", zap.Error(err))
+		c.ResponseError(errors.New("查询我的入群申请失败！"))
+		return
+	}
+	list := make([]*joinApplyResp, 0, len(models))
+	for _, model := range models {
+		resp := newJoinApplyResp(&model.joinApplyModel)
+		resp.GroupName = model.GroupName
+		list = append(list, resp)
+	}
+	c.Response(list)
+}
+
+// 通过入群申请
+func (g *Group) joinApplyApprove(c *wkhttp.Context) {
+	loginUID := c.GetLoginUID()
+	loginName := c.GetLoginName()
+	groupNo := c.Param("group_no")
+	apply, err := g.getWaitJoinApply(groupNo, c.Param("apply_no"), loginUID)
+	if err != nil {
+		c.ResponseError(err)
+		return
+	}
+	isMember, err := g.db.ExistMember(apply.UID, groupNo)
+	if err != nil {
+		g.Error("查询是否在群内失败！", zap.Error(err))
+		c.ResponseError(errors.New("查询是否在群内失败！"))
+		return
+	}
+	// 通过邀请链接申请的，审核时链接需要仍然有效（申请期间链接可能被撤销、过期或次数已用完）
+	if !isMember && apply.InviteLinkNo != "" {
+		link, err := g.db.QueryInviteLinkWithLinkNo(apply.InviteLinkNo)
+		if err != nil {
+			g.Error("查询邀请链接失败！", zap.Error(err))
+			c.ResponseError(errors.New("查询邀请链接失败！"))
+			return
+		}
+		if link == nil {
+			c.ResponseError(errors.New("邀请链接已失效！"))
+			return
+		}
+		if err := link.Check(); err != nil {
+			c.ResponseError(err)
+			return
+		}
+	}
+	tx, err := g.ctx.DB().Begin()
+	if err != nil {
+		g.Error("开启事务失败！", zap.Error(err))
+		c.ResponseError(errors.New("开启事务失败！"))
+		return
+	}
+	defer func() {
+		if err := recover(); err != nil {
+			tx.RollbackUnlessCommitted()
+			panic(err)
+		}
+	}()
+	rowsAffected, err := g.db.updateJoinApplyStatusTx(apply.ApplyNo, JoinApplyStatusApproved, loginUID, "", tx)
+	if err != nil {
+		tx.Rollback()
+		g.Error("修改入群申请状态失败！", zap.Error(err))
+		c.ResponseError(errors.New("修改入群申请状态失败！"))
+		return
+	}
+	if rowsAffected == 0 {
+		tx.Rollback()
+		c.ResponseError(errors.New("该申请已被处理！"))
+		return
+	}
+	// 申请期间已经通过其他方式入群的不再重复添加
+	var commitCallback func()
+	if !isMember {
+		commitCallback, err = g.addMembersTx([]string{apply.UID}, groupNo, loginUID, loginName, tx)
+		if err != nil {
+			tx.Rollback()
+			g.Error("添加成员失败！", zap.Error(err))
+			c.ResponseError(errors.New("添加成员失败！"))
+			return
+		}
+		// 通过邀请链接申请的，记录入群链接并计入链接的使用次数
+		if apply.InviteLinkNo != "" {
+			err = g.db.updateMemberInviteLinkTx(groupNo, apply.UID, apply.InviteLinkNo, tx)
+			if err != nil {
+				tx.Rollback()
+				g.Error("更新成员的入群邀请链接失败！", zap.Error(err))
+				c.ResponseError(errors.New("更新成员的入群邀请链接失败！"))
+				return
+			}
+			// 并发审核时以数据库的条件更新为准，避免超出最大使用次数
+			rowsAffected, err := g.db.useInviteLinkTx(apply.InviteLinkNo, tx)
+			if err != nil {
+				tx.Rollback()
+				g.Error("更新邀请链接使用次数失败！", zap.Error(err))
+				c.ResponseError(errors.New("更新邀请链接使用次数失败！"))
+				return
+			}
+			if rowsAffected == 0 {
+				tx.Rollback()
+				c.ResponseError(errors.New("邀请链接已失效！"))
+				return
+			}
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		tx.Rollback()
+		g.Error("提交事务失败！", zap.Error(err))
+		c.ResponseError(errors.New("提交事务失败！"))
+		return
+	}
+	if commitCallback != nil {
+		commitCallback()
+	}
+	g.notifyJoinApplyHandled(apply, JoinApplyStatusApproved, loginUID, loginName, "")
+	c.ResponseOK()
+}
+
+// 拒绝入群申请
+func (g *Group) joinApplyReject(c *wkhttp.Context) {
+	loginUID := c.GetLoginUID()
+	groupNo := c.Param("group_no")
+	var req struct {
+		Reason string `json:"reason"` // 拒绝原因
+	}
+	if err := c.BindJSON(&req); err != nil {
+		c.ResponseError(errors.New("数据格式有误！"))
+		return
+	}
+	req.Reason = strings.TrimSpace(req.Reason)
+	if utf8.RuneCountInString(req.Reason) > joinTextMaxLen {
+		c.ResponseError(errors.New("拒绝原因不能超过" + strconv.Itoa(joinTextMaxLen) + "个字！"))
+		return
+	}
+	apply, err := g.getWaitJoinApply(groupNo, c.Param("apply_no"), loginUID)
+	if err != nil {
+		c.ResponseError(err)
+		return
+	}
+	tx, err := g.ctx.DB().Begin()
+	if err != nil {
+		g.Error("开启事务失败！", zap.Error(err))
+		c.ResponseError(errors.New("开启事务失败！"))
+		return
+	}
+	defer func() {
+		if err := recover(); err != nil {
+			tx.RollbackUnlessCommitted()
+			panic(err)
+		}
+	}()
+	rowsAffected, err := g.db.updateJoinApplyStatusTx(apply.ApplyNo, JoinApplyStatusRejected, loginUID, req.Reason, tx)
+	if err != nil {
+		tx.Rollback()
+		g.Error("修改入群申请状态失败！", zap.Error(err))
+		c.ResponseError(errors.New("修改入群申请状态失败！"))
+		return
+	}
+	if rowsAffected == 0 {
+		tx.Rollback()
+		c.ResponseError(errors.New("该申请已被处理！"))
+		return
+	}
+	if err := tx.Commit(); err != nil {
+		tx.Rollback()
+		g.Error("提交事务失败！", zap.Error(err))
+		c.ResponseError(errors.New("提交事务失败！"))
+		return
+	}
+	g.notifyJoinApplyHandled(apply, JoinApplyStatusRejected, loginUID, c.GetLoginName(), req.Reason)
+	c.ResponseOK()
+}
+
+// 拥有邀请权限的成员（群主、管理员或自定义角色）可以审核入群申请
+func (g *Group) checkJoinApplyManager(groupNo string, uid string) error {
+	canInvite, err := g.permission.hasPermission(groupNo, uid, PermissionInvite)
+	if err != nil {
+		g.Error("查询群内权限失败！", zap.Error(err))
+		return errors.New("查询群内权限失败！")
+	}
+	if !canInvite {
+		return errors.New("没有审核入群申请的权限！")
+	}
+	return nil
+}
+
+// 获取待审核的入群申请并校验审核权限
+func (g *Group) getWaitJoinApply(groupNo, applyNo, loginUID string) (*joinApplyModel, error) {
+	_, err := g.getGroupInfo(groupNo)
+	if err != nil {
+		return nil, err
+	}
+	if err := g.checkJoinApplyManager(groupNo, loginUID); err != nil {
+		return nil, err
+	}
+	apply, err := g.db.queryJoinApplyWithApplyNo(applyNo)
+	if err != nil {
+		g.Error("查询入群申请失败！", zap.Error(err))
+		return nil, errors.New("查询入群申请失败！")
+	}
+	if apply == nil || apply.GroupNo != groupNo {
+		return nil, errors.New("入群申请不存在！")
+	}
+	if apply.Status != JoinApplyStatusWait {
+		return nil, errors.New("该申请已被处理！")
+	}
+	return apply, nil
+}
+
+// 通知群主和管理员有新的入群申请
+func (g *Group) notifyJoinApplyAdd(applyNo, groupNo, groupName, applyUID, applyName string) {
+	managerUIDs, err := g.db.QueryGroupManagerOrCreatorUIDS(groupNo)
+	if err != nil {
+		g.Warn("查询群主和管理员失败！", zap.Error(err))
+		return
+	}
+	if len(managerUIDs) == 0 {
+		return
+	}
+	err = g.userService.AddRedDot(managerUIDs, user.UserRedDotCategoryGroupJoinApply)
+	if err != nil {
+		g.Warn("增加入群申请红点失败！", zap.Error(err))
+	}
+	err = g.ctx.SendCMD(config.MsgCMDReq{
+		CMD:         CMDGroupJoinApply,
+		Subscribers: managerUIDs,
+		Param: map[string]interface{}{
+			"apply_no":   applyNo,
+			"group_no":   groupNo,
+			"group_name": groupName,
+			"apply_uid":  applyUID,
+			"apply_name": applyName,
+			"status":     JoinApplyStatusWait,
+		},
+	})
+	if err != nil {
+		g.Warn("发送入群申请命令失败！", zap.Error(err))
+	}
+}
+
+// 通知申请者审核结果，同时通知其他管理员刷新申请列表
+func (g *Group) notifyJoinApplyHandled(apply *joinApplyModel, status int, handler, handlerName, reason string) {
+	groupName := ""
+	group, err := g.db.QueryWithGroupNo(apply.GroupNo)
+	if err != nil {
+		g.Warn("查询群信息失败！", zap.Error(err))
+	} else if group != nil {
+		groupName = group.Name
+	}
+	err = g.userService.AddRedDot([]string{apply.UID}, user.UserRedDotCategoryGroupJoinApplyResult)
+	if err != nil {
+		g.Warn("增加入群申请结果红点失败！", zap.Error(err))
+	}
+	err = g.ctx.SendCMD(config.MsgCMDReq{
+		CMD:         CMDGroupJoinApplyResult,
+		Subscribers: []string{apply.UID},
+		Param: map[string]interface{}{
+			"apply_no":   apply.ApplyNo,
+			"group_no":   apply.GroupNo,
+			"group_name": groupName,
+			"status":     status,
+			"reason":     reason,
+		},
+	})
+	if err != nil {
+		g.Warn("发送入群申请结果命令失败！", zap.Error(err))
+	}
+	managerUIDs, err := g.db.QueryGroupManagerOrCreatorUIDS(apply.GroupNo)
+	if err != nil {
+		g.Warn("查询群主和管理员失败！", zap.Error(err))
+		return
+	}
+	if len(managerUIDs) == 0 {
+		return
+	}
+	err = g.ctx.SendCMD(config.MsgCMDReq{
+		CMD:         CMDGroupJoinApply,
+		Subscribers: managerUIDs,
+		Param: map[string]interface{}{
+			"apply_no":     apply.ApplyNo,
+			"group_no":     apply.GroupNo,
+			"group_name":   groupName,
+			"apply_uid":    apply.UID,
+			"status":       status,
+			"handler":      handler,
+			"handler_name": handlerName,
+		},
+	})
+	if err != nil {
+		g.Warn("发送入群申请命令失败！", zap.Error(err))
+	}
+}
+
+type joinApplyReq struct {
+	Remark  string `json:"remark"` // 申请留言
+	Answers []struct {
+		QuestionID int64  `json:"question_id"` // 问题id
+		Answer     string `json:"answer"`      // 回答
+	} `json:"answers"`
+}
+
+type joinAnswer struct {
+	Question string `json:"question"`
+	Answer   string `json:"answer"`
+}
+
+type joinQuestionResp struct {
+	ID       int64  `json:"id"`
+	Question string `json:"question"`
+}
+
+type joinApplyGroupResp struct {
+	GroupNo       string `json:"group_no"`
+	Name          string `json:"name"`
+	MemberCount   int64  `json:"member_count"`   // 成员数量
+	QuestionCount int    `json:"question_count"` // 入群问题数量
+	IsMember      int    `json:"is_member"`      // 是否已在群内
+	Applying      int    `json:"applying"`       // 是否有待审核的申请
+}
+
+type joinApplyResp struct {
+	ApplyNo      string        `json:"apply_no"`
+	GroupNo      string        `json:"group_no"`
+	GroupName    string        `json:"group_name,omitempty"`
+	UID          string        `json:"uid"`
+	Name         string        `json:"name,omitempty"`
+	Remark       string        `json:"remark"`
+	Answers      []*joinAnswer `json:"answers"`
+	Status       int           `json:"status"` // 状态 0.待审核 1.已通过 2.已拒绝
+	Handler      string        `json:"handler"`
+	Reason       string        `json:"reason"`
+	InviteLinkNo string        `json:"invite_link_no"` // 通过邀请链接申请时的链接编号
+	CreatedAt    string        `json:"created_at"`
+	UpdatedAt    string        `json:"updated_at"`
+}
+
+func newJoinApplyResp(m *joinApplyModel) *joinApplyResp {
+	answers := make([]*joinAnswer, 0)
+	if m.Answers != "" {
+		_ = util.ReadJsonByByte([]byte(m.Answers), &answers)
+	}
+	return &joinApplyResp{
+		ApplyNo:      m.ApplyNo,
+		GroupNo:      m.GroupNo,
+		UID:          m.UID,
+		Remark:       m.Remark,
+		Answers:      answers,
+		Status:       m.Status,
+		Handler:      m.Handler,
+		Reason:       m.Reason,
+		InviteLinkNo: m.InviteLinkNo,
+		CreatedAt:    m.CreatedAt.String(),
+		UpdatedAt:    m.UpdatedAt.String(),
+	}
+}
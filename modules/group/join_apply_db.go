@@ -0,0 +1,151 @@
+package group
+
+import (
+	"github.com/TangSengDaoDao/TangSengDaoDaoServerLib/pkg/db"
+	"github.com/TangSengDaoDao/TangSengDaoDaoServerLib/pkg/util"
+	"github.com/gocraft/dbr/v2"
+)
+
+// 查询可以申请加入的群
+func (d *DB) queryJoinApplyGroupsWithKeyword(keyword string, limit uint64) ([]*Model, error) {
+	var models []*Model
+	_, err := d.session.Select("*").From("group").Where("join_apply=1 and status=? and (group_no=? or name like ?)", GroupStatusNormal, keyword, "%"+keyword+"%").OrderDesc("id").Limit(limit).Load(&models)
+	return models, err
+}
+
+// 查询群的入群问题
+func (d *DB) queryJoinQuestions(groupNo string) ([]*joinQuestionModel, error) {
+	var models []*joinQuestionModel
+	_, err := d.session.Select("*").From("group_join_question").Where("group_no=?", groupNo).OrderAsc("sort_num").Load(&models)
+	return models, err
+}
+
+// 查询多个群的入群问题数量
+func (d *DB) queryJoinQuestionCounts(groupNos []string) (map[string]int, error) {
+	var results []*struct {
+		GroupNo string
+		Count   int
+	}
+	_, err := d.session.Select("group_no,count(*) count").From("group_join_question").Where("group_no in ?", groupNos).GroupBy("group_no").Load(&results)
+	if err != nil {
+		return nil, err
+	}
+	countMap := make(map[string]int, len(results))
+	for _, result := range results {
+		countMap[result.GroupNo] = result.Count
+	}
+	return countMap, nil
+}
+
+// 删除群的入群问题
+func (d *DB) deleteJoinQuestionsTx(groupNo string, tx *dbr.Tx) error {
+	_, err := tx.DeleteFrom("group_join_question").Where("group_no=?", groupNo).Exec()
+	return err
+}
+
+// 添加入群问题
+func (d *DB) insertJoinQuestionTx(m *joinQuestionModel, tx *dbr.Tx) error {
+	_, err := tx.InsertInto("group_join_question").Columns(util.AttrToUnderscore(m)...).Record(m).Exec()
+	return err
+}
+
+// 添加入群申请
+func (d *DB) insertJoinApply(m *joinApplyModel) error {
+	_, err := d.session.InsertInto("group_join_apply").Columns(util.AttrToUnderscore(m)...).Record(m).Exec()
+	return err
+}
+
+// 查询入群申请
+func (d *DB) queryJoinApplyWithApplyNo(applyNo string) (*joinApplyModel, error) {
+	var m *joinApplyModel
+	_, err := d.session.Select("*").From("group_join_apply").Where("apply_no=?", applyNo).Load(&m)
+	return m, err
+}
+
+// 查询用户在某个群待审核的申请
+func (d *DB) queryWaitJoinApply(groupNo, uid string) (*joinApplyModel, error) {
+	var m *joinApplyModel
+	_, err := d.session.Select("*").From("group_join_apply").Where("group_no=? and uid=? and status=?", groupNo, uid, JoinApplyStatusWait).Load(&m)
+	return m, err
+}
+
+// 查询用户在多个群待审核的申请
+func (d *DB) queryWaitJoinApplyGroupNos(groupNos []string, uid string) ([]string, error) {
+	var results []string
+	_, err := d.session.Select("group_no").From("group_join_apply").Where("group_no in ? and uid=? and status=?", groupNos, uid, JoinApplyStatusWait).Load(&results)
+	return results, err
+}
+
+// 分页查询群的入群申请（status小于0时查询全部）
+func (d *DB) queryJoinAppliesWithGroupNo(groupNo string, status int, pageIndex, pageSize uint64) ([]*joinApplyDetailModel, error) {
+	var models []*joinApplyDetailModel
+	builder := d.session.Select("group_join_apply.*,IFNULL(user.name,'') name").From("group_join_apply").LeftJoin("user", "group_join_apply.uid=user.uid").Where("group_join_apply.group_no=?", groupNo)
+	if status >= 0 {
+		builder = builder.Where("group_join_apply.status=?", status)
+	}
+	_, err := builder.OrderDesc("group_join_apply.id").Offset((pageIndex - 1) * pageSize).Limit(pageSize).Load(&models)
+	return models, err
+}
+
+// 查询群的入群申请数量（status小于0时查询全部）
+func (d *DB) queryJoinApplyCountWithGroupNo(groupNo string, status int) (int64, error) {
+	var count int64
+	builder := d.session.Select("count(*)").From("group_join_apply").Where("group_no=?", groupNo)
+	if status >= 0 {
+		builder = builder.Where("status=?", status)
+	}
+	_, err := builder.Load(&count)
+	return count, err
+}
+
+// 查询用户的入群申请
+func (d *DB) queryJoinAppliesWithUID(uid string, pageIndex, pageSize uint64) ([]*joinApplyGroupModel, error) {
+	var models []*joinApplyGroupModel
+	_, err := d.session.Select("group_join_apply.*,IFNULL(`group`.name,'') group_name").From("group_join_apply").LeftJoin("group", "group_join_apply.group_no=`group`.group_no").Where("group_join_apply.uid=?", uid).OrderDesc("group_join_apply.id").Offset((pageIndex - 1) * pageSize).Limit(pageSize).Load(&models)
+	return models, err
+}
+
+// 审核入群申请（只有待审核的申请会被修改）
+func (d *DB) updateJoinApplyStatusTx(applyNo string, status int, handler, reason string, tx *dbr.Tx) (int64, error) {
+	result, err := tx.Update("group_join_apply").SetMap(map[string]interface{}{
+		"status":  status,
+		"handler": handler,
+		"reason":  reason,
+	}).Where("apply_no=? and status=?", applyNo, JoinApplyStatusWait).Exec()
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+// 入群问题
+type joinQuestionModel struct {
+	GroupNo  string // 群编号
+	Question string // 问题
+	SortNum  int    // 排序号
+	db.BaseModel
+}
+
+// 入群申请
+type joinApplyModel struct {
+	ApplyNo      string // 申请编号
+	GroupNo      string // 群编号
+	UID          string // 申请者uid
+	Remark       string // 申请留言
+	Answers      string // 入群问题的回答（json）
+	Status       int    // 状态 0.待审核 1.已通过 2.已拒绝
+	Handler      string // 审核者uid
+	Reason       string // 拒绝原因
+	InviteLinkNo string // 通过邀请链接申请时的链接编号
+	db.BaseModel
+}
+
+type joinApplyDetailModel struct {
+	joinApplyModel
+	Name string // 申请者名称
+}
+
+type joinApplyGroupModel struct {
+	joinApplyModel
+	GroupName string // 群名称
+}
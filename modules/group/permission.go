@@ -0,0 +1,125 @@
+package group
+
+import (
+	"github.com/TangSengDaoDao/TangSengDaoDaoServerLib/config"
+)
+
+// 成员身份等级（操作其他成员时，操作者的等级需要高于被操作者）
+const (
+	memberRankCommon   = 0 // 普通成员
+	memberRankRole     = 1 // 拥有自定义角色的普通成员
+	memberRankManager  = 2 // 管理员
+	memberRankCreator  = 3 // 群主
+	memberRankNotExist = -1
+)
+
+// permissionService 群权限服务，group和message模块中需要群内权限的操作都通过此服务判断
+type permissionService struct {
+	db *DB
+}
+
+func newPermissionService(ctx *config.Context) *permissionService {
+	return &permissionService{
+		db: NewDB(ctx),
+	}
+}
+
+// 获取成员在群内的权限（不在群内返回0）
+func (p *permissionService) getPermissions(groupNo, uid string) (Permission, error) {
+	member, err := p.db.QueryMemberWithUID(uid, groupNo)
+	if err != nil {
+		return 0, err
+	}
+	return p.memberPermissions(member)
+}
+
+// 获取成员的权限，群主和管理员拥有全部权限，普通成员的权限来自其自定义角色
+func (p *permissionService) memberPermissions(member *MemberModel) (Permission, error) {
+	if member == nil || member.IsDeleted == 1 {
+		return 0, nil
+	}
+	if member.Role == MemberRoleCreator || member.Role == MemberRoleManager {
+		return PermissionAll, nil
+	}
+	if member.RoleNo == "" {
+		return 0, nil
+	}
+	role, err := p.db.queryRoleWithRoleNo(member.RoleNo)
+	if err != nil {
+		return 0, err
+	}
+	if role == nil || role.GroupNo != member.GroupNo {
+		return 0, nil
+	}
+	return Permission(role.Permissions) & PermissionAll, nil
+}
+
+// 成员在群内是否拥有指定权限
+func (p *permissionService) hasPermission(groupNo, uid string, permission Permission) (bool, error) {
+	permissions, err := p.getPermissions(groupNo, uid)
+	if err != nil {
+		return false, err
+	}
+	return permissions&permission == permission, nil
+}
+
+// 成员是否拥有指定权限
+func (p *permissionService) memberHasPermission(member *MemberModel, permission Permission) (bool, error) {
+	permissions, err := p.memberPermissions(member)
+	if err != nil {
+		return false, err
+	}
+	return permissions&permission == permission, nil
+}
+
+// 操作者是否可以对成员执行需要指定权限的操作（需拥有权限且身份等级高于对方，对方不在群内时视为普通成员）
+func (p *permissionService) canOperateMember(groupNo, operatorUID, memberUID string, permission Permission) (bool, error) {
+	operator, err := p.db.QueryMemberWithUID(operatorUID, groupNo)
+	if err != nil {
+		return false, err
+	}
+	has, err := p.memberHasPermission(operator, permission)
+	if err != nil || !has {
+		return false, err
+	}
+	member, err := p.db.QueryMemberWithUID(memberUID, groupNo)
+	if err != nil {
+		return false, err
+	}
+	return p.outrank(operator, member), nil
+}
+
+// 成员是否是群主或管理员（IM的全员禁言白名单按此身份设置，与全员禁言相关的判断使用此方法保持一致）
+func (p *permissionService) isCreatorOrManager(groupNo, uid string) (bool, error) {
+	member, err := p.db.QueryMemberWithUID(uid, groupNo)
+	if err != nil {
+		return false, err
+	}
+	return memberRank(member) >= memberRankManager, nil
+}
+
+// 操作者的身份等级是否高于成员
+func (p *permissionService) outrank(operator *MemberModel, member *MemberModel) bool {
+	operatorRank := memberRank(operator)
+	targetRank := memberRank(member)
+	if targetRank == memberRankNotExist {
+		targetRank = memberRankCommon
+	}
+	return operatorRank > targetRank
+}
+
+func memberRank(member *MemberModel) int {
+	if member == nil || member.IsDeleted == 1 {
+		return memberRankNotExist
+	}
+	switch member.Role {
+	case MemberRoleCreator:
+		return memberRankCreator
+	case MemberRoleManager:
+		return memberRankManager
+	}
+	if member.RoleNo != "" {
+		return memberRankRole
+	}
+	return memberRankCommon
+}
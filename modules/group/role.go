@@ -0,0 +1,374 @@
+package group
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/TangSengDaoDao/TangSengDaoDaoServerLib/common"
+	"github.com/TangSengDaoDao/TangSengDaoDaoServerLib/config"
+	"github.com/TangSengDaoDao/TangSengDaoDaoServerLib/pkg/util"
+	"github.com/TangSengDaoDao/TangSengDaoDaoServerLib/pkg/wkhttp"
+	"go.uber.org/zap"
+)
+
+const (
+	roleNameMaxLen = 20 // 角色名称的最大长度
+	roleMaxCount   = 20 // 每个群最多的自定义角色数量
+)
+
+// 添加自定义角色（群主）
+func (g *Group) roleAdd(c *wkhttp.Context) {
+	loginUID := c.GetLoginUID()
+	groupNo := c.Param("group_no")
+	var req roleReq
+	if err := c.BindJSON(&req); err != nil {
+		c.ResponseError(errors.New("数据格式有误！"))
+		return
+	}
+	if err := req.check(); err != nil {
+		c.ResponseError(err)
+		return
+	}
+	if err := g.checkRoleCreator(groupNo, loginUID); err != nil {
+		c.ResponseError(err)
+		return
+	}
+	roles, err := g.db.queryRolesWithGroupNo(groupNo)
+	if err != nil {
+		g.Error("查询自定义角色失败！", zap.Error(err))
+		c.ResponseError(errors.New("查询自定义角色失败！"))
+		return
+	}
+	if len(roles) >= roleMaxCount {
+		c.ResponseError(errors.New("每个群最多创建" + strconv.Itoa(roleMaxCount) + "个角色！"))
+		return
+	}
+	role := &roleModel{
+		RoleNo:      util.GenerUUID(),
+		GroupNo:     groupNo,
+		Name:        req.Name,
+		Permissions: req.Permissions,
+		Creator:     loginUID,
+	}
+	err = g.db.insertRole(role)
+	if err != nil {
+		g.Error("添加自定义角色失败！", zap.Error(err))
+		c.ResponseError(errors.New("添加自定义角色失败！"))
+		return
+	}
+	g.sendRoleUpdateCMD(groupNo, loginUID)
+	c.Response(&roleResp{
+		RoleNo:      role.RoleNo,
+		GroupNo:     role.GroupNo,
+		Name:        role.Name,
+		Permissions: role.Permissions,
+	})
+}
+
+// 群的自定义角色列表（群成员）
+func (g *Group) roleList(c *wkhttp.Context) {
+	loginUID := c.GetLoginUID()
+	groupNo := c.Param("group_no")
+	_, err := g.getGroupInfo(groupNo)
+	if err != nil {
+		c.ResponseError(err)
+		return
+	}
+	isMember, err := g.db.ExistMember(loginUID, groupNo)
+	if err != nil {
+		g.Error("查询是否是群成员失败！", zap.Error(err))
+		c.ResponseError(errors.New("查询是否是群成员失败！"))
+		return
+	}
+	if !isMember {
+		c.ResponseError(errors.New("不在群内！"))
+		return
+	}
+	roles, err := g.db.queryRolesWithGroupNo(groupNo)
+	if err != nil {
+		g.Error("查询自定义角色失败！", zap.Error(err))
+		c.ResponseError(errors.New("查询自定义角色失败！"))
+		return
+	}
+	memberCountMap, err := g.db.queryRoleMemberCounts(groupNo)
+	if err != nil {
+		g.Error("查询角色成员数量失败！", zap.Error(err))
+		c.ResponseError(errors.New("查询角色成员数量失败！"))
+		return
+	}
+	resps := make([]*roleResp, 0, len(roles))
+	for _, role := range roles {
+		resps = append(resps, &roleResp{
+			RoleNo:      role.RoleNo,
+			GroupNo:     role.GroupNo,
+			Name:        role.Name,
+			Permissions: role.Permissions,
+			MemberCount: memberCountMap[role.RoleNo],
+			CreatedAt:   role.CreatedAt.String(),
+		})
+	}
+	c.Response(resps)
+}
+
+// 修改自定义角色（群主）
+func (g *Group) roleUpdate(c *wkhttp.Context) {
+	loginUID := c.GetLoginUID()
+	groupNo := c.Param("group_no")
+	var req roleReq
+	if err := c.BindJSON(&req); err != nil {
+		c.ResponseError(errors.New("数据格式有误！"))
+		return
+	}
+	if err := req.check(); err != nil {
+		c.ResponseError(err)
+		return
+	}
+	role, err := g.getRole(groupNo, c.Param("role_no"), loginUID)
+	if err != nil {
+		c.ResponseError(err)
+		return
+	}
+	role.Name = req.Name
+	role.Permissions = req.Permissions
+	err = g.db.updateRole(role)
+	if err != nil {
+		g.Error("修改自定义角色失败！", zap.Error(err))
+		c.ResponseError(errors.New("修改自定义角色失败！"))
+		return
+	}
+	g.sendRoleUpdateCMD(groupNo, loginUID)
+	c.ResponseOK()
+}
+
+// 删除自定义角色，拥有此角色的成员恢复为普通成员（群主）
+func (g *Group) roleDelete(c *wkhttp.Context) {
+	loginUID := c.GetLoginUID()
+	groupNo := c.Param("group_no")
+	role, err := g.getRole(groupNo, c.Param("role_no"), loginUID)
+	if err != nil {
+		c.ResponseError(err)
+		return
+	}
+	memberUIDs, err := g.db.queryMemberUIDsWithRoleNo(groupNo, role.RoleNo)
+	if err != nil {
+		g.Error("查询角色成员失败！", zap.Error(err))
+		c.ResponseError(errors.New("查询角色成员失败！"))
+		return
+	}
+	tx, err := g.ctx.DB().Begin()
+	if err != nil {
+		g.Error("开启事务失败！", zap.Error(err))
+		c.ResponseError(errors.New("开启事务失败！"))
+		return
+	}
+	defer func() {
+		if err := recover(); err != nil {
+			tx.RollbackUnlessCommitted()
+			panic(err)
+		}
+	}()
+	err = g.db.deleteRoleTx(role.RoleNo, tx)
+	if err != nil {
+		tx.Rollback()
+		g.Error("删除自定义角色失败！", zap.Error(err))
+		c.ResponseError(errors.New("删除自定义角色失败！"))
+		return
+	}
+	err = g.db.clearMembersRoleNoTx(groupNo, role.RoleNo, g.ctx.GenSeq(common.GroupMemberSeqKey), tx)
+	if err != nil {
+		tx.Rollback()
+		g.Error("清除成员的自定义角色失败！", zap.Error(err))
+		c.ResponseError(errors.New("清除成员的自定义角色失败！"))
+		return
+	}
+	if err := tx.Commit(); err != nil {
+		tx.RollbackUnlessCommitted()
+		g.Error("提交事务失败！", zap.Error(err))
+		c.ResponseError(errors.New("提交事务失败！"))
+		return
+	}
+	for _, memberUID := range memberUIDs {
+		g.sendMemberUpdateCMD(groupNo, memberUID)
+	}
+	g.sendRoleUpdateCMD(groupNo, loginUID)
+	c.ResponseOK()
+}
+
+// 设置成员的自定义角色，role_no为空表示取消角色（群主，仅普通成员可设置）
+func (g *Group) memberRoleSet(c *wkhttp.Context) {
+	loginUID := c.GetLoginUID()
+	groupNo := c.Param("group_no")
+	memberUID := c.Param("uid")
+	var req struct {
+		RoleNo string `json:"role_no"` // 角色编号
+	}
+	if err := c.BindJSON(&req); err != nil {
+		c.ResponseError(errors.New("数据格式有误！"))
+		return
+	}
+	if err := g.checkRoleCreator(groupNo, loginUID); err != nil {
+		c.ResponseError(err)
+		return
+	}
+	member, err := g.db.QueryMemberWithUID(memberUID, groupNo)
+	if err != nil {
+		g.Error("查询成员信息失败！", zap.Error(err))
+		c.ResponseError(errors.New("查询成员信息失败！"))
+		return
+	}
+	if member == nil {
+		c.ResponseError(errors.New("该成员不在群内！"))
+		return
+	}
+	if member.Role != MemberRoleCommon {
+		c.ResponseError(errors.New("只能给普通成员设置角色！"))
+		return
+	}
+	if req.RoleNo != "" {
+		role, err := g.db.queryRoleWithRoleNo(req.RoleNo)
+		if err != nil {
+			g.Error("查询自定义角色失败！", zap.Error(err))
+			c.ResponseError(errors.New("查询自定义角色失败！"))
+			return
+		}
+		if role == nil || role.GroupNo != groupNo {
+			c.ResponseError(errors.New("角色不存在！"))
+			return
+		}
+	}
+	if member.RoleNo == req.RoleNo {
+		c.ResponseOK()
+		return
+	}
+	err = g.db.updateMemberRoleNo(groupNo, memberUID, req.RoleNo, g.ctx.GenSeq(common.GroupMemberSeqKey))
+	if err != nil {
+		g.Error("设置成员的自定义角色失败！", zap.Error(err))
+		c.ResponseError(errors.New("设置成员的自定义角色失败！"))
+		return
+	}
+	g.sendMemberUpdateCMD(groupNo, memberUID)
+	c.ResponseOK()
+}
+
+// 获取登录用户在群内的权限
+func (g *Group) permissionsGet(c *wkhttp.Context) {
+	loginUID := c.GetLoginUID()
+	groupNo := c.Param("group_no")
+	member, err := g.db.QueryMemberWithUID(loginUID, groupNo)
+	if err != nil {
+		g.Error("查询群成员失败！", zap.Error(err))
+		c.ResponseError(errors.New("查询群成员失败！"))
+		return
+	}
+	if member == nil {
+		c.ResponseError(errors.New("不在群内！"))
+		return
+	}
+	permissions, err := g.permission.memberPermissions(member)
+	if err != nil {
+		g.Error("查询群内权限失败！", zap.Error(err))
+		c.ResponseError(errors.New("查询群内权限失败！"))
+		return
+	}
+	c.Response(map[string]interface{}{
+		"role":        member.Role,
+		"role_no":     member.RoleNo,
+		"permissions": permissions,
+	})
+}
+
+// 只有群主可以管理自定义角色
+func (g *Group) checkRoleCreator(groupNo string, uid string) error {
+	_, err := g.getGroupInfo(groupNo)
+	if err != nil {
+		return err
+	}
+	isCreator, err := g.db.QueryIsGroupCreator(groupNo, uid)
+	if err != nil {
+		g.Error("查询群创建者失败！", zap.Error(err))
+		return errors.New("查询群创建者失败！")
+	}
+	if !isCreator {
+		return errors.New("只有群主才能管理角色！")
+	}
+	return nil
+}
+
+// 获取自定义角色并校验管理权限
+func (g *Group) getRole(groupNo, roleNo, loginUID string) (*roleModel, error) {
+	if err := g.checkRoleCreator(groupNo, loginUID); err != nil {
+		return nil, err
+	}
+	role, err := g.db.queryRoleWithRoleNo(roleNo)
+	if err != nil {
+		g.Error("查询自定义角色失败！", zap.Error(err))
+		return nil, errors.New("查询自定义角色失败！")
+	}
+	if role == nil || role.GroupNo != groupNo {
+		return nil, errors.New("角色不存在！")
+	}
+	return role, nil
+}
+
+// 通知群成员角色有变化（客户端重新获取角色列表和自己的权限）
+func (g *Group) sendRoleUpdateCMD(groupNo string, fromUID string) {
+	err := g.ctx.SendCMD(config.MsgCMDReq{
+		NoPersist:   true,
+		ChannelID:   groupNo,
+		ChannelType: common.ChannelTypeGroup.Uint8(),
+		FromUID:     fromUID,
+		CMD:         CMDGroupRoleUpdate,
+		Param: map[string]interface{}{
+			"group_no": groupNo,
+		},
+	})
+	if err != nil {
+		g.Warn("发送群角色更新命令失败！", zap.Error(err))
+	}
+}
+
+// 通知群成员信息有变化
+func (g *Group) sendMemberUpdateCMD(groupNo string, uid string) {
+	err := g.ctx.SendCMD(config.MsgCMDReq{
+		ChannelID:   groupNo,
+		ChannelType: common.ChannelTypeGroup.Uint8(),
+		CMD:         common.CMDGroupMemberUpdate,
+		Param: map[string]interface{}{
+			"group_no": groupNo,
+			"uid":      uid,
+		},
+	})
+	if err != nil {
+		g.Warn("发送群成员更新命令失败！", zap.Error(err))
+	}
+}
+
+type roleReq struct {
+	Name        string `json:"name"`        // 角色名称
+	Permissions int    `json:"permissions"` // 权限（按位组合）
+}
+
+func (r *roleReq) check() error {
+	r.Name = strings.TrimSpace(r.Name)
+	if r.Name == "" {
+		return errors.New("角色名称不能为空！")
+	}
+	if utf8.RuneCountInString(r.Name) > roleNameMaxLen {
+		return errors.New("角色名称不能超过" + strconv.Itoa(roleNameMaxLen) + "个字！")
+	}
+	if r.Permissions <= 0 || Permission(r.Permissions)&^PermissionAll != 0 {
+		return errors.New("角色权限有误！")
+	}
+	return nil
+}
+
+type roleResp struct {
+	RoleNo      string `json:"role_no"`
+	GroupNo     string `json:"group_no"`
+	Name        string `json:"name"`         // 角色名称
+	Permissions int    `json:"permissions"`  // 权限（按位组合）
+	MemberCount int    `json:"member_count"` // 拥有此角色的成员数量
+	CreatedAt   string `json:"created_at"`
+}
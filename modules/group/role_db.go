@@ -0,0 +1,88 @@
+package group
+
+import (
+	"github.com/TangSengDaoDao/TangSengDaoDaoServerLib/pkg/db"
+	"github.com/TangSengDaoDao/TangSengDaoDaoServerLib/pkg/util"
+	"github.com/gocraft/dbr/v2"
+)
+
+// 添加自定义角色
+func (d *DB) insertRole(m *roleModel) error {
+	_, err := d.session.InsertInto("group_role").Columns(util.AttrToUnderscore(m)...).Record(m).Exec()
+	return err
+}
+
+// 查询自定义角色
+func (d *DB) queryRoleWithRoleNo(roleNo string) (*roleModel, error) {
+	var m *roleModel
+	_, err := d.session.Select("*").From("group_role").Where("role_no=?", roleNo).Load(&m)
+	return m, err
+}
+
+// 查询群的自定义角色
+func (d *DB) queryRolesWithGroupNo(groupNo string) ([]*roleModel, error) {
+	var models []*roleModel
+	_, err := d.session.Select("*").From("group_role").Where("group_no=?", groupNo).OrderAsc("id").Load(&models)
+	return models, err
+}
+
+// 更新自定义角色
+func (d *DB) updateRole(m *roleModel) error {
+	_, err := d.session.Update("group_role").SetMap(map[string]interface{}{
+		"name":        m.Name,
+		"permissions": m.Permissions,
+	}).Where("role_no=?", m.RoleNo).Exec()
+	return err
+}
+
+// 删除自定义角色
+func (d *DB) deleteRoleTx(roleNo string, tx *dbr.Tx) error {
+	_, err := tx.DeleteFrom("group_role").Where("role_no=?", roleNo).Exec()
+	return err
+}
+
+// 查询拥有指定角色的成员uid
+func (d *DB) queryMemberUIDsWithRoleNo(groupNo, roleNo string) ([]string, error) {
+	var uids []string
+	_, err := d.session.Select("uid").From("group_member").Where("group_no=? and role_no=? and is_deleted=0", groupNo, roleNo).Load(&uids)
+	return uids, err
+}
+
+// 查询群内各角色的成员数量
+func (d *DB) queryRoleMemberCounts(groupNo string) (map[string]int, error) {
+	var results []*struct {
+		RoleNo string
+		Count  int
+	}
+	_, err := d.session.Select("role_no,count(*) count").From("group_member").Where("group_no=? and role_no<>'' and is_deleted=0", groupNo).GroupBy("role_no").Load(&results)
+	if err != nil {
+		return nil, err
+	}
+	countMap := make(map[string]int, len(results))
+	for _, result := range results {
+		countMap[result.RoleNo] = result.Count
+	}
+	return countMap, nil
+}
+
+// 清除成员的自定义角色
+func (d *DB) clearMembersRoleNoTx(groupNo, roleNo string, version int64, tx *dbr.Tx) error {
+	_, err := tx.Update("group_member").Set("role_no", "").Set("version", version).Where("group_no=? and role_no=?", groupNo, roleNo).Exec()
+	return err
+}
+
+// 设置成员的自定义角色
+func (d *DB) updateMemberRoleNo(groupNo, uid, roleNo string, version int64) error {
+	_, err := d.session.Update("group_member").Set("role_no", roleNo).Set("version", version).Where("group_no=? and uid=? and is_deleted=0", groupNo, uid).Exec()
+	return err
+}
+
+// 群自定义角色
+type roleModel struct {
+	RoleNo      string // 角色编号
+	GroupNo     string // 群编号
+	Name        string // 角色名称
+	Permissions int    // 权限（按位组合）
+	Creator     string // 创建者uid
+	db.BaseModel
+}
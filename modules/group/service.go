@@ -42,9 +42,11 @@ type IService interface {
 	GetMember(groupNo, uid string) (*MemberResp, error)
 	// 获取黑名单成员uid集合
 	GetBlacklistMemberUIDs(groupNo string) ([]string, error)
+	// 查询群内不能发言的成员uid（被拉黑、禁言中和慢速模式冷却中的成员，话题频道加载黑名单时使用）
+	GetTalkBlacklistUIDs(groupNo string) ([]string, error)
 	// 查询管理员成员uid列表（包括创建者）
 	GetMemberUIDsOfManager(groupNo string) ([]string, error)
-	// 是否是创建者或管理者
+	// 是否是创建者或管理者（按成员身份判断，与全员禁言白名单一致；群内操作的权限判断使用HasPermission）
 	IsCreatorOrManager(groupNo string, uid string) (bool, error)
 	// 获取成员总数量和在线数量
 	// 第一个返回参数为成员总数量
@@ -66,6 +68,18 @@ type IService interface {
 	GetMembersWithUIDAndGroupIds(uid string, groupNos []string) ([]*MemberResp, error)
 	// 查询一批群的管理员及群主
 	GetManagersWithGroupNos(groupNos []string) ([]*MemberResp, error)
+
+	// -------------------- 群权限 --------------------
+	// 获取成员在群内的权限（群主和管理员拥有全部权限，普通成员的权限来自其自定义角色）
+	GetPermissions(groupNo string, uid string) (Permission, error)
+	// 成员在群内是否拥有指定权限
+	HasPermission(groupNo string, uid string, permission Permission) (bool, error)
+	// 操作者是否可以对成员执行需要指定权限的操作（需拥有权限且身份高于对方）
+	CanOperateMember(groupNo string, operatorUID string, memberUID string, permission Permission) (bool, error)
+
+	// -------------------- 慢速模式 --------------------
+	// 成员在群内发送了消息，如果群开启了慢速模式则开始冷却 消息是在冷却期间发送的返回false（同一条消息重复调用返回相同结果）
+	StartSlowModeCooldown(groupNo string, uid string, messageID string, sentAt int64) (bool, error)
 }
 
 // Service Service
@@ -74,17 +88,19 @@ type Service struct {
 	db        *DB
 	managerDB *managerDB
 	log.Log
-	settingDB *settingDB
+	settingDB  *settingDB
+	permission *permissionService
 }
 
 // NewService NewService
 func NewService(ctx *config.Context) IService {
 	return &Service{
-		ctx:       ctx,
-		db:        NewDB(ctx),
-		managerDB: newManagerDB(ctx.DB()),
-		Log:       log.NewTLog("groupService"),
-		settingDB: newSettingDB(ctx),
+		ctx:        ctx,
+		db:         NewDB(ctx),
+		managerDB:  newManagerDB(ctx.DB()),
+		Log:        log.NewTLog("groupService"),
+		settingDB:  newSettingDB(ctx),
+		permission: newPermissionService(ctx),
 	}
 }
 
@@ -311,12 +327,36 @@ func (s *Service) GetBlacklistMemberUIDs(groupNo string) ([]string, error) {
 	return uids, nil
 }
 
+func (s *Service) GetTalkBlacklistUIDs(groupNo string) ([]string, error) {
+	uids, err := s.db.queryBlacklistOrForbiddenMemberUIDs(groupNo, time.Now().Unix())
+	if err != nil {
+		return nil, err
+	}
+	cooldownUIDs, err := s.slowModeCooldownUIDs(groupNo)
+	if err != nil {
+		return nil, err
+	}
+	return util.RemoveRepeatedElement(append(uids, cooldownUIDs...)), nil
+}
+
 func (s *Service) GetMemberUIDsOfManager(groupNo string) ([]string, error) {
 	return s.db.QueryGroupManagerOrCreatorUIDS(groupNo)
 }
 
 func (s *Service) IsCreatorOrManager(groupNo string, uid string) (bool, error) {
-	return s.db.QueryIsGroupManagerOrCreator(groupNo, uid)
+	return s.permission.isCreatorOrManager(groupNo, uid)
+}
+
+func (s *Service) GetPermissions(groupNo string, uid string) (Permission, error) {
+	return s.permission.getPermissions(groupNo, uid)
+}
+
+func (s *Service) HasPermission(groupNo string, uid string, permission Permission) (bool, error) {
+	return s.permission.hasPermission(groupNo, uid, permission)
+}
+
+func (s *Service) CanOperateMember(groupNo string, operatorUID string, memberUID string, permission Permission) (bool, error) {
+	return s.permission.canOperateMember(groupNo, operatorUID, memberUID, permission)
 }
 
 func (s *Service) GetMemberTotalAndOnlineCount(groupNo string) (int, int, error) {
@@ -407,6 +447,7 @@ type AddMemberReq struct {
 type InfoResp struct {
 	GroupNo             string    `json:"group_no"`               // 群编号
 	GroupType           GroupType `json:"group_type"`             // 群类型
+	Category            string    `json:"category"`               // 群分类
 	Name                string    `json:"name"`                   // 群名称
 	Notice              string    `json:"notice"`                 // 群公告
 	Creator             string    `json:"creator"`                // 创建者uid
@@ -424,6 +465,7 @@ func toInfoResp(m *Model) *InfoResp {
 	return &InfoResp{
 		GroupNo:             m.GroupNo,
 		GroupType:           GroupType(m.GroupType),
+		Category:            m.Category,
 		Name:                m.Name,
 		Notice:              m.Notice,
 		Creator:             m.Creator,
@@ -444,6 +486,7 @@ type MemberResp struct {
 	Name               string // 群成员名称
 	Remark             string // 成员备注
 	Role               int    // 成员角色
+	RoleNo             string // 自定义角色编号
 	Version            int64
 	Vercode            string //验证码
 	InviteUID          string // 邀请人uid
@@ -460,6 +503,7 @@ func newMemberResp(m *MemberDetailModel) *MemberResp {
 		Name:               m.Name,
 		Remark:             m.Remark,
 		Role:               m.Role,
+		RoleNo:             m.RoleNo,
 		Version:            m.Version,
 		Vercode:            m.Vercode,
 		InviteUID:          m.InviteUID,
@@ -534,6 +578,8 @@ type GroupResp struct {
 	Role                     int       `json:"role"`                        // 我在群聊里的角色
 	ForbiddenExpirTime       int64     `json:"forbidden_expir_time"`        // 我在此群的禁言过期时间
 	AllowMemberPinnedMessage int       `json:"allow_member_pinned_message"` //是否允许群成员置顶消息
+	JoinApply                int       `json:"join_apply"`                  // 是否允许用户搜索并申请入群
+	SlowMode                 int       `json:"slow_mode"`                   // 慢速模式，普通成员两次发言的最小间隔（秒），0表示关闭
 	CreatedAt                string    `json:"created_at"`
 	UpdatedAt                string    `json:"updated_at"`
 	Version                  int64     `json:"version"` // 群数据版本
@@ -565,6 +611,8 @@ func (g *GroupResp) from(model *DetailModel) *GroupResp {
 		Status:                   model.Status,
 		AllowViewHistoryMsg:      model.AllowViewHistoryMsg,
 		AllowMemberPinnedMessage: model.AllowMemberPinnedMessage,
+		JoinApply:                model.JoinApply,
+		SlowMode:                 model.SlowMode,
 		CreatedAt:                model.CreatedAt.String(),
 		UpdatedAt:                model.UpdatedAt.String(),
 	}
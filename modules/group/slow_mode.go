@@ -0,0 +1,278 @@
+package group
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/TangSengDaoDao/TangSengDaoDaoServerLib/common"
+	"github.com/TangSengDaoDao/TangSengDaoDaoServerLib/config"
+	"github.com/TangSengDaoDao/TangSengDaoDaoServerLib/pkg/wkhttp"
+	"github.com/go-redis/redis"
+	"go.uber.org/zap"
+)
+
+// 慢速模式：普通成员在群内发送消息后进入冷却，冷却期间将成员加入IM的频道黑名单，由IM拒绝其发送消息，
+// 冷却结束后由CheckSlowModeLoop将成员移出黑名单。拥有不受慢速模式限制权限的成员（群主和管理员拥有全部权限）和机器人不受限制。
+// IM没有发送前的回调，黑名单生效前连续发送的消息在webhook收到时判定为违反慢速模式并由系统撤回。
+
+const (
+	slowModeMaxSecond        = 3600                    // 慢速模式最大间隔（秒）
+	slowModeCooldownPrefix   = "groupSlowMode:"        // 成员冷却结束时间和开始冷却的消息 groupSlowMode:{group_no}@{uid}
+	slowModeCooldownQueueKey = "groupSlowModeCooldown" // 待结束的冷却（有序集合，score为冷却结束时间）
+	slowModeLockPrefix       = "groupSlowModeLock:"    // 修改成员冷却的互斥锁 groupSlowModeLock:{group_no}@{uid}
+	slowModeLockExpire       = time.Second * 5         // 互斥锁过期时间
+	slowModeLockWait         = time.Millisecond * 50   // 等待互斥锁的间隔
+	slowModeLockRetry        = 100                     // 等待互斥锁的最大次数
+)
+
+// StartSlowModeCooldown 成员在群内发送了消息，如果群开启了慢速模式则开始冷却
+// sentAt为消息的发送时间（10位时间戳），消息是在冷却期间发送的（IM黑名单生效前连续发送）返回false，此时不重新开始冷却
+// 冷却记录了开始冷却的消息，webhook重复推送同一条消息时仍然返回true
+func (s *Service) StartSlowModeCooldown(groupNo string, uid string, messageID string, sentAt int64) (bool, error) {
+	group, err := s.db.QueryWithGroupNo(groupNo)
+	if err != nil {
+		return false, err
+	}
+	if group == nil || group.SlowMode <= 0 {
+		return true, nil
+	}
+	member, err := s.db.QueryMemberWithUID(uid, groupNo)
+	if err != nil {
+		return false, err
+	}
+	if member == nil {
+		return true, nil
+	}
+	exempt, err := slowModeExempt(s.permission, member)
+	if err != nil {
+		return false, err
+	}
+	if exempt {
+		return true, nil
+	}
+	cooldownMember := fmt.Sprintf("%s@%s", groupNo, uid)
+	// 多实例同时处理同一成员的消息时，判断和开始冷却需要互斥
+	unlock, err := s.lockSlowModeCooldown(cooldownMember)
+	if err != nil {
+		return false, err
+	}
+	defer unlock()
+	cooldownValue, err := s.ctx.GetRedisConn().GetString(slowModeCooldownPrefix + cooldownMember)
+	if err != nil {
+		return false, err
+	}
+	if cooldownValue != "" {
+		cooldownExpireAt, cooldownMessageID := parseSlowModeCooldown(cooldownValue)
+		if cooldownMessageID == messageID {
+			return true, nil
+		}
+		if sentAt < cooldownExpireAt {
+			return false, nil
+		}
+	}
+	expireAt := sentAt + int64(group.SlowMode)
+	ttl := expireAt - time.Now().Unix()
+	if ttl <= 0 { // 消息通知延迟，冷却已经结束
+		return true, nil
+	}
+	err = s.ctx.GetRedisConn().SetAndExpire(slowModeCooldownPrefix+cooldownMember, fmt.Sprintf("%d@%s", expireAt, messageID), time.Duration(ttl)*time.Second)
+	if err != nil {
+		return false, err
+	}
+	err = s.ctx.GetRedisConn().ZAdd(slowModeCooldownQueueKey, float64(expireAt), cooldownMember)
+	if err != nil {
+		return false, err
+	}
+	err = s.ctx.IMBlacklistAdd(config.ChannelBlacklistReq{
+		ChannelReq: config.ChannelReq{
+			ChannelID:   groupNo,
+			ChannelType: common.ChannelTypeGroup.Uint8(),
+		},
+		UIDs: []string{uid},
+	})
+	if err != nil {
+		return false, err
+	}
+	syncThreadBlacklist(s.ctx, groupNo, []string{uid}, true)
+	return true, nil
+}
+
+// 查询群内慢速模式冷却中的成员uid
+func (s *Service) slowModeCooldownUIDs(groupNo string) ([]string, error) {
+	cooldownMembers, err := s.ctx.GetRedisConn().ZRangeByScore(slowModeCooldownQueueKey, redis.ZRangeBy{
+		Min: strconv.FormatInt(time.Now().Unix(), 10),
+		Max: "+inf",
+	})
+	if err != nil {
+		return nil, err
+	}
+	uids := make([]string, 0)
+	for _, cooldownMember := range cooldownMembers {
+		if strings.HasPrefix(cooldownMember, groupNo+"@") {
+			uids = append(uids, strings.TrimPrefix(cooldownMember, groupNo+"@"))
+		}
+	}
+	return uids, nil
+}
+
+// 获取成员冷却的互斥锁，返回解锁函数（redis连接没有提供SETNX和EVAL，通过INCR的返回值判断是否抢到锁）
+func (s *Service) lockSlowModeCooldown(cooldownMember string) (func(), error) {
+	key := slowModeLockPrefix + cooldownMember
+	for i := 0; i < slowModeLockRetry; i++ {
+		count, err := s.ctx.GetRedisConn().Incr(key)
+		if err != nil {
+			return nil, err
+		}
+		// 每次都刷新过期时间，持有者异常退出时锁也会过期
+		if err = s.ctx.GetRedisConn().Expire(key, slowModeLockExpire); err != nil {
+			return nil, err
+		}
+		if count == 1 {
+			return func() {
+				_ = s.ctx.GetRedisConn().Del(key)
+			}, nil
+		}
+		time.Sleep(slowModeLockWait)
+	}
+	return nil, errors.New("获取慢速模式冷却锁超时！")
+}
+
+// 解析成员的冷却记录 {expire_at}@{message_id}
+func parseSlowModeCooldown(value string) (int64, string) {
+	expireAtAndMessageID := strings.SplitN(value, "@", 2)
+	expireAt, _ := strconv.ParseInt(expireAtAndMessageID[0], 10, 64)
+	messageID := ""
+	if len(expireAtAndMessageID) == 2 {
+		messageID = expireAtAndMessageID[1]
+	}
+	return expireAt, messageID
+}
+
+// 成员是否不受慢速模式限制（机器人或拥有不受慢速模式限制权限的成员）
+func slowModeExempt(permission *permissionService, member *MemberModel) (bool, error) {
+	if member.Robot == 1 {
+		return true, nil
+	}
+	return permission.memberHasPermission(member, PermissionIgnoreSlowMode)
+}
+
+// 获取慢速模式状态（客户端用于显示还需要等待多久才能发言）
+func (g *Group) slowModeGet(c *wkhttp.Context) {
+	loginUID := c.GetLoginUID()
+	groupNo := c.Param("group_no")
+	group, err := g.getGroupInfo(groupNo)
+	if err != nil {
+		c.ResponseError(err)
+		return
+	}
+	member, err := g.db.QueryMemberWithUID(loginUID, groupNo)
+	if err != nil {
+		g.Error("查询群成员失败！", zap.Error(err))
+		c.ResponseError(errors.New("查询群成员失败！"))
+		return
+	}
+	if member == nil {
+		c.ResponseError(errors.New("不在群内！"))
+		return
+	}
+	isExempt, err := slowModeExempt(g.permission, member)
+	if err != nil {
+		g.Error("查询群内权限失败！", zap.Error(err))
+		c.ResponseError(errors.New("查询群内权限失败！"))
+		return
+	}
+	exempt := 0
+	var wait int64 = 0
+	if isExempt {
+		exempt = 1
+	} else if group.SlowMode > 0 {
+		cooldownValue, err := g.ctx.GetRedisConn().GetString(fmt.Sprintf("%s%s@%s", slowModeCooldownPrefix, groupNo, loginUID))
+		if err != nil {
+			g.Error("获取慢速模式冷却时间失败！", zap.Error(err))
+			c.ResponseError(errors.New("获取慢速模式冷却时间失败！"))
+			return
+		}
+		if cooldownValue != "" {
+			expireAt, _ := parseSlowModeCooldown(cooldownValue)
+			if expireAt > time.Now().Unix() {
+				wait = expireAt - time.Now().Unix()
+			}
+		}
+	}
+	c.Response(map[string]interface{}{
+		"slow_mode": group.SlowMode, // 慢速模式间隔（秒），0表示关闭
+		"exempt":    exempt,         // 是否不受慢速模式限制
+		"wait":      wait,           // 还需要等待的秒数
+	})
+}
+
+// CheckSlowModeLoop 慢速模式冷却结束后将成员移出IM黑名单
+func (g *Group) CheckSlowModeLoop() {
+	var limit int64 = 100
+	var errSleep = time.Second * 1
+	var noDataSleep = time.Second * 1
+	for {
+		cooldownMembers, err := g.ctx.GetRedisConn().ZRangeByScore(slowModeCooldownQueueKey, redis.ZRangeBy{
+			Min:   "-inf",
+			Max:   strconv.FormatInt(time.Now().Unix(), 10),
+			Count: limit,
+		})
+		if err != nil {
+			g.Warn("查询慢速模式冷却结束的成员失败！", zap.Error(err))
+			time.Sleep(errSleep)
+			continue
+		}
+		if len(cooldownMembers) <= 0 {
+			time.Sleep(noDataSleep)
+			continue
+		}
+		for _, cooldownMember := range cooldownMembers {
+			err = g.releaseSlowModeCooldown(cooldownMember)
+			if err != nil {
+				g.Warn("结束慢速模式冷却失败！", zap.Error(err), zap.String("member", cooldownMember))
+				time.Sleep(errSleep)
+				break
+			}
+			err = g.ctx.GetRedisConn().ZRem(slowModeCooldownQueueKey, cooldownMember)
+			if err != nil {
+				g.Warn("移除慢速模式冷却记录失败！", zap.Error(err), zap.String("member", cooldownMember))
+			}
+		}
+	}
+}
+
+// 过滤掉还在慢速模式冷却中的成员（解除禁言或移出黑名单时，冷却中的成员需要留在IM黑名单中）
+func (g *Group) withoutSlowModeCooldown(groupNo string, uids []string) ([]string, error) {
+	result := make([]string, 0, len(uids))
+	for _, uid := range uids {
+		expireAtStr, err := g.ctx.GetRedisConn().GetString(fmt.Sprintf("%s%s@%s", slowModeCooldownPrefix, groupNo, uid))
+		if err != nil {
+			return nil, err
+		}
+		if expireAtStr != "" {
+			continue
+		}
+		result = append(result, uid)
+	}
+	return result, nil
+}
+
+// 结束成员的冷却（成员同时被拉黑或禁言时保留在IM黑名单中）
+func (g *Group) releaseSlowModeCooldown(cooldownMember string) error {
+	groupNoAndUID := strings.SplitN(cooldownMember, "@", 2)
+	if len(groupNoAndUID) != 2 {
+		return nil
+	}
+	groupNo, uid := groupNoAndUID[0], groupNoAndUID[1]
+	member, err := g.db.QueryMemberWithUID(uid, groupNo)
+	if err != nil {
+		return err
+	}
+	if member != nil && (member.Status == int(common.GroupMemberStatusBlacklist) || member.ForbiddenExpirTime > time.Now().Unix()) {
+		return nil
+	}
+	return g.setGroupBlacklist(groupNo, []string{uid}, false)
+}
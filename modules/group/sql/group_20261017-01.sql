@@ -0,0 +1,34 @@
+-- +migrate Up
+
+ALTER TABLE `group` ADD COLUMN join_apply smallint not null DEFAULT 0 COMMENT '是否允许用户搜索并申请入群 0.否 1.是';
+
+-- 入群问题
+create table `group_join_question`
+(
+  id         integer      not null primary key AUTO_INCREMENT,
+  group_no   VARCHAR(40)  not null default '',            -- 群编号
+  question   VARCHAR(200) not null default '',            -- 问题
+  sort_num   integer      not null default 0,             -- 排序号
+  created_at timeStamp    not null DEFAULT CURRENT_TIMESTAMP,
+  updated_at timeStamp    not null DEFAULT CURRENT_TIMESTAMP
+);
+CREATE INDEX group_join_question_group_no_idx on `group_join_question` (group_no);
+
+-- 入群申请
+create table `group_join_apply`
+(
+  id         integer       not null primary key AUTO_INCREMENT,
+  apply_no   VARCHAR(40)   not null default '',           -- 申请编号
+  group_no   VARCHAR(40)   not null default '',           -- 群编号
+  uid        VARCHAR(40)   not null default '',           -- 申请者uid
+  remark     VARCHAR(200)  not null default '',           -- 申请留言
+  answers    VARCHAR(4000) not null default '',           -- 入群问题的回答（json，包含申请时的问题）
+  status     smallint      not null default 0,            -- 状态 0.待审核 1.已通过 2.已拒绝
+  handler    VARCHAR(40)   not null default '',           -- 审核者uid
+  reason     VARCHAR(200)  not null default '',           -- 拒绝原因
+  created_at timeStamp     not null DEFAULT CURRENT_TIMESTAMP,
+  updated_at timeStamp     not null DEFAULT CURRENT_TIMESTAMP
+);
+CREATE UNIQUE INDEX group_join_apply_apply_no_idx on `group_join_apply` (apply_no);
+CREATE INDEX group_join_apply_group_no_status_idx on `group_join_apply` (group_no, status);
+CREATE INDEX group_join_apply_uid_idx on `group_join_apply` (uid);
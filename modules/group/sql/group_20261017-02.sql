@@ -0,0 +1,25 @@
+-- +migrate Up
+
+ALTER TABLE `group_member` ADD COLUMN invite_link_no VARCHAR(40) not null DEFAULT '' COMMENT '通过哪个邀请链接入群';
+CREATE INDEX group_member_invite_link_no_idx on `group_member` (invite_link_no);
+
+ALTER TABLE `group_join_apply` ADD COLUMN invite_link_no VARCHAR(40) not null DEFAULT '' COMMENT '通过哪个邀请链接申请';
+
+-- 群邀请链接
+create table `group_invite_link`
+(
+  id           integer      not null primary key AUTO_INCREMENT,
+  link_no      VARCHAR(40)  not null default '',            -- 链接编号
+  group_no     VARCHAR(40)  not null default '',            -- 群编号
+  name         VARCHAR(100) not null default '',            -- 链接名称
+  creator      VARCHAR(40)  not null default '',            -- 创建者uid
+  expire_at    BIGINT       not null default 0,             -- 过期时间（10位时间戳，0表示永不过期）
+  max_uses     integer      not null default 0,             -- 最大使用次数（0表示不限制）
+  use_count    integer      not null default 0,             -- 已通过此链接入群的人数
+  need_approve smallint     not null default 0,             -- 通过此链接入群是否需要审核 0.否 1.是
+  status       smallint     not null default 1,             -- 状态 1.正常 0.已撤销
+  created_at   timeStamp    not null DEFAULT CURRENT_TIMESTAMP,
+  updated_at   timeStamp    not null DEFAULT CURRENT_TIMESTAMP
+);
+CREATE UNIQUE INDEX group_invite_link_link_no_idx on `group_invite_link` (link_no);
+CREATE INDEX group_invite_link_group_no_idx on `group_invite_link` (group_no);
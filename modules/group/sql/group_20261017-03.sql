@@ -0,0 +1,34 @@
+-- +migrate Up
+
+-- 群公告
+create table `group_announcement`
+(
+  id              integer      not null primary key AUTO_INCREMENT,
+  announcement_no VARCHAR(40)  not null default '',       -- 公告编号
+  group_no        VARCHAR(40)  not null default '',       -- 群编号
+  title           VARCHAR(100) not null default '',       -- 标题
+  content         TEXT,                                   -- 内容（markdown）
+  pinned          smallint     not null default 0,        -- 是否置顶 0.否 1.是
+  pinned_at       BIGINT       not null default 0,        -- 置顶时间（13位时间戳）
+  need_confirm    smallint     not null default 0,        -- 是否需要成员确认 0.否 1.是
+  creator         VARCHAR(40)  not null default '',       -- 发布者uid
+  editor          VARCHAR(40)  not null default '',       -- 最后编辑者uid
+  version         BIGINT       not null default 0,        -- 数据版本
+  is_deleted      smallint     not null default 0,        -- 是否已删除
+  created_at      timeStamp    not null DEFAULT CURRENT_TIMESTAMP,
+  updated_at      timeStamp    not null DEFAULT CURRENT_TIMESTAMP
+);
+CREATE UNIQUE INDEX group_announcement_announcement_no_idx on `group_announcement` (announcement_no);
+CREATE INDEX group_announcement_group_no_version_idx on `group_announcement` (group_no, version);
+
+-- 群公告确认记录
+create table `group_announcement_confirm`
+(
+  id              integer     not null primary key AUTO_INCREMENT,
+  announcement_no VARCHAR(40) not null default '',        -- 公告编号
+  group_no        VARCHAR(40) not null default '',        -- 群编号
+  uid             VARCHAR(40) not null default '',        -- 确认者uid
+  created_at      timeStamp   not null DEFAULT CURRENT_TIMESTAMP,
+  updated_at      timeStamp   not null DEFAULT CURRENT_TIMESTAMP
+);
+CREATE UNIQUE INDEX group_announcement_confirm_uid_idx on `group_announcement_confirm` (announcement_no, uid);
@@ -0,0 +1,3 @@
+-- +migrate Up
+
+ALTER TABLE `group` ADD COLUMN slow_mode integer not null DEFAULT 0 COMMENT '慢速模式，普通成员两次发言的最小间隔（秒），0表示关闭';
@@ -0,0 +1,19 @@
+-- +migrate Up
+
+ALTER TABLE `group_member` ADD COLUMN role_no VARCHAR(40) not null DEFAULT '' COMMENT '自定义角色编号（仅普通成员）';
+CREATE INDEX group_member_role_no_idx on `group_member` (role_no);
+
+-- 群自定义角色
+create table `group_role`
+(
+  id          integer      not null primary key AUTO_INCREMENT,
+  role_no     VARCHAR(40)  not null default '',            -- 角色编号
+  group_no    VARCHAR(40)  not null default '',            -- 群编号
+  name        VARCHAR(40)  not null default '',            -- 角色名称
+  permissions integer      not null default 0,             -- 权限（按位组合）
+  creator     VARCHAR(40)  not null default '',            -- 创建者uid
+  created_at  timeStamp    not null DEFAULT CURRENT_TIMESTAMP,
+  updated_at  timeStamp    not null DEFAULT CURRENT_TIMESTAMP
+);
+CREATE UNIQUE INDEX group_role_role_no_idx on `group_role` (role_no);
+CREATE INDEX group_role_group_no_idx on `group_role` (group_no);
@@ -0,0 +1,85 @@
+package group
+
+import (
+	chservice "github.com/TangSengDaoDao/TangSengDaoDaoServer/modules/channel/service"
+	"github.com/TangSengDaoDao/TangSengDaoDaoServerLib/common"
+	"github.com/TangSengDaoDao/TangSengDaoDaoServerLib/config"
+	"github.com/TangSengDaoDao/TangSengDaoDaoServerLib/pkg/log"
+	"github.com/TangSengDaoDao/TangSengDaoDaoServerLib/pkg/register"
+	"go.uber.org/zap"
+)
+
+// 话题频道（群消息下的子频道，由channel模块维护）的订阅者和黑白名单跟随群。
+// IM只在加载话题频道时从数据源读取一次，之后群频道的订阅者和黑白名单变化需要同步到群下所有的话题频道。
+
+// 同步新增或移除的订阅者到群下所有话题
+func syncThreadSubscribers(ctx *config.Context, groupNo string, uids []string, isAdd bool) {
+	if len(uids) == 0 {
+		return
+	}
+	forEachGroupThread(groupNo, func(channelReq config.ChannelReq) error {
+		if isAdd {
+			return ctx.IMAddSubscriber(&config.SubscriberAddReq{
+				ChannelID:   channelReq.ChannelID,
+				ChannelType: channelReq.ChannelType,
+				Subscribers: uids,
+			})
+		}
+		return ctx.IMRemoveSubscriber(&config.SubscriberRemoveReq{
+			ChannelID:   channelReq.ChannelID,
+			ChannelType: channelReq.ChannelType,
+			Subscribers: uids,
+		})
+	})
+}
+
+// 同步黑名单（禁言、拉黑、慢速模式冷却）到群下所有话题
+func syncThreadBlacklist(ctx *config.Context, groupNo string, uids []string, isAdd bool) {
+	if len(uids) == 0 {
+		return
+	}
+	forEachGroupThread(groupNo, func(channelReq config.ChannelReq) error {
+		if isAdd {
+			return ctx.IMBlacklistAdd(config.ChannelBlacklistReq{
+				ChannelReq: channelReq,
+				UIDs:       uids,
+			})
+		}
+		return ctx.IMBlacklistRemove(config.ChannelBlacklistReq{
+			ChannelReq: channelReq,
+			UIDs:       uids,
+		})
+	})
+}
+
+// 同步白名单（全员禁言）到群下所有话题
+func syncThreadWhitelist(ctx *config.Context, groupNo string, uids []string) {
+	forEachGroupThread(groupNo, func(channelReq config.ChannelReq) error {
+		return ctx.IMWhitelistSet(config.ChannelWhitelistReq{
+			ChannelReq: channelReq,
+			UIDs:       uids,
+		})
+	})
+}
+
+// 对群下所有话题频道执行操作（单个话题失败只记录日志，话题频道重新加载时会从数据源读取最新的数据）
+func forEachGroupThread(groupNo string, fn func(channelReq config.ChannelReq) error) {
+	channelServiceObj := register.GetService(ChannelServiceName)
+	if channelServiceObj == nil {
+		return
+	}
+	channelIDs, err := channelServiceObj.(chservice.IService).GetThreadChannelIDs(groupNo, common.ChannelTypeGroup.Uint8())
+	if err != nil {
+		log.Warn("查询群下的话题失败！", zap.Error(err), zap.String("groupNo", groupNo))
+		return
+	}
+	for _, channelID := range channelIDs {
+		err = fn(config.ChannelReq{
+			ChannelID:   channelID,
+			ChannelType: chservice.ChannelTypeThread,
+		})
+		if err != nil {
+			log.Warn("同步话题频道失败！", zap.Error(err), zap.String("channelID", channelID))
+		}
+	}
+}
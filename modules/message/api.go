@@ -16,6 +16,8 @@ import (
 	commonapi "github.com/TangSengDaoDao/TangSengDaoDaoServer/modules/common"
 	"github.com/TangSengDaoDao/TangSengDaoDaoServer/modules/file"
 	"github.com/TangSengDaoDao/TangSengDaoDaoServer/modules/group"
+	"github.com/TangSengDaoDao/TangSengDaoDaoServer/modules/message/msgsearch"
+	"github.com/TangSengDaoDao/TangSengDaoDaoServer/modules/message/prohibit"
 	"github.com/TangSengDaoDao/TangSengDaoDaoServer/modules/user"
 	"github.com/TangSengDaoDao/TangSengDaoDaoServerLib/common"
 	"github.com/TangSengDaoDao/TangSengDaoDaoServerLib/config"
@@ -45,6 +47,13 @@ type Message struct {
 	messageUserExtraDB  *messageUserExtraDB
 	remindersDB         *remindersDB
 	pinnedDB            *pinnedDB
+	scheduledMessageDB  *scheduledMessageDB
+	editRevisionDB      *messageEditRevisionDB
+	pollDB              *pollDB
+	forwardDB           *forwardDB
+	exporter            *exporter
+	prohibitService     prohibit.IService
+	messageSearch       *msgsearch.Service
 	userService         user.IService
 	groupService        group.IService
 	commonService       commonapi.IService
@@ -72,6 +81,13 @@ func New(ctx *config.Context) *Message {
 		deviceOffsetDB:      newDeviceOffsetDB(ctx.DB()),
 		remindersDB:         newRemindersDB(ctx),
 		pinnedDB:            newPinnedDB(ctx),
+		scheduledMessageDB:  newScheduledMessageDB(ctx),
+		editRevisionDB:      newMessageEditRevisionDB(ctx),
+		pollDB:              newPollDB(ctx),
+		forwardDB:           newForwardDB(ctx),
+		exporter:            newExporter(ctx),
+		prohibitService:     prohibit.NewService(ctx),
+		messageSearch:       msgsearch.NewService(ctx),
 		userService:         user.NewService(ctx),
 		commonService:       commonapi.NewService(ctx),
 		fileService:         file.NewService(ctx),
@@ -79,6 +95,11 @@ func New(ctx *config.Context) *Message {
 	}
 	m.ctx.AddEventListener(event.GroupMemberAdd, m.handleGroupMemberAddEvent)
 	m.ctx.AddEventListener(event.GroupMemberScanJoin, m.handleGroupMemberScanJoinEvent)
+	user.AddDataExportSection(&user.DataExportSection{
+		Name:   "messages",
+		Title:  "发送的消息",
+		Export: m.exporter.exportUserMessages,
+	})
 	return m
 }
 
@@ -107,11 +128,36 @@ func (m *Message) Route(r *wkhttp.WKHttp) {
 		message.POST("/pinned", m.pinnedMessage)                  // 置顶消息
 		message.POST("/pinned/sync", m.syncPinnedMessage)         // 同步置顶消息
 		message.POST("/pinned/clear", m.clearPinnedMessage)       // 删除所有置顶消息
+		message.POST("/scheduled", m.scheduledAdd)                // 添加定时消息
+		message.GET("/scheduled", m.scheduledList)                // 频道内待发送的定时消息
+		message.PUT("/scheduled/:id", m.scheduledUpdate)          // 编辑定时消息
+		message.DELETE("/scheduled/:id", m.scheduledCancel)       // 取消定时消息
+		message.POST("/export", m.exportAdd)                      // 导出会话记录
+		message.GET("/exports", m.exportList)                     // 导出记录
+		message.GET("/exports/:export_no", m.exportGet)           // 导出详情（进度）
+		message.GET("/exports/:export_no/download", m.exportFile) // 下载导出的归档文件
 	}
 	messages := r.Group("/v1/messages", m.ctx.AuthMiddleware(r))
 	{
 		// messages.PUT("/:message_id/voicereaded", m.voiceReaded)
 		messages.GET("/:message_id/receipt", m.messageReceiptList) // 消息回执列表
+		messages.GET("/:message_id/revisions", m.messageRevisions) // 消息编辑历史
+		messages.GET("/:message_id/read_status", m.receiptSummary) // 群消息已读未读成员
+		messages.POST("/:message_id/nudge", m.receiptNudge)        // 催读未读成员
+	}
+	// 投票
+	polls := r.Group("/v1/polls", m.ctx.AuthMiddleware(r))
+	{
+		polls.POST("", m.pollCreate)               // 发起投票
+		polls.GET("/:poll_no", m.pollGet)          // 投票详情
+		polls.POST("/:poll_no/vote", m.pollVote)   // 投票
+		polls.POST("/:poll_no/close", m.pollClose) // 结束投票
+	}
+	// 合并转发
+	forwards := r.Group("/v1/forwards", m.ctx.AuthMiddleware(r))
+	{
+		forwards.POST("", m.forwardCreate)        // 合并转发
+		forwards.GET("/:bundle_no", m.forwardGet) // 合并转发详情（聊天记录）
 	}
 	// 回应
 	reactions := r.Group("/v1/reactions", m.ctx.AuthMiddleware(r))
@@ -128,6 +174,10 @@ func (m *Message) Route(r *wkhttp.WKHttp) {
 	}
 	m.ctx.AddMessagesListener(m.listenerMessages) // 监听消息
 	m.syncMessageReadedCount()
+	m.startScheduledMessageWorker() // 定时消息投递
+	m.messageSearch.Start()         // 内置消息搜索索引
+	m.exporter.start()              // 会话记录后台导出
+	m.startPollDeadlineWorker()     // 结束到期的投票
 }
 
 func (m *Message) sendMsg(c *wkhttp.Context) {
@@ -212,7 +262,12 @@ func (m *Message) sendMsg(c *wkhttp.Context) {
 			return
 		}
 	}
-	err = m.sendMessage(req.ReceiveChannelID, req.ReceiveChannelType, uid, req.Payload)
+	payload, err := m.prohibitService.FilterPayload(req.Payload)
+	if err != nil {
+		c.ResponseError(err)
+		return
+	}
+	err = m.sendMessage(req.ReceiveChannelID, req.ReceiveChannelType, uid, payload)
 	if err != nil {
 		c.ResponseError(err)
 		return
@@ -262,6 +317,35 @@ func (m *Message) messageEdit(c *wkhttp.Context) {
 		c.ResponseError(errors.New("频道ID不能为空！"))
 		return
 	}
+	loginUID := c.GetLoginUID()
+	fakeChannelID := req.ChannelID
+	if req.ChannelType == common.ChannelTypePerson.Uint8() {
+		fakeChannelID = common.GetFakeChannelIDWith(loginUID, req.ChannelID)
+	}
+	message, err := m.db.queryMessageWithMessageID(fakeChannelID, req.ChannelType, req.MessageID)
+	if err != nil {
+		m.Error("查询消息失败！", zap.Error(err))
+		c.ResponseError(errors.New("查询消息失败！"))
+		return
+	}
+	if message == nil {
+		c.ResponseError(errors.New("消息不存在！"))
+		return
+	}
+	if message.FromUID != loginUID {
+		c.ResponseError(errors.New("只能编辑自己发送的消息！"))
+		return
+	}
+	appConfig, err := m.commonService.GetAppConfig()
+	if err != nil {
+		m.Error("查询配置错误", zap.Error(err))
+		c.ResponseError(errors.New("查询配置错误"))
+		return
+	}
+	if appConfig != nil && appConfig.MessageEditSecond > 0 && time.Now().Unix()-message.Timestamp > int64(appConfig.MessageEditSecond) {
+		c.ResponseError(errors.New("消息已超过可编辑时长！"))
+		return
+	}
 	contentEdit := dbr.NewNullString(req.ContentEdit).String
 	contentMD5 := util.MD5(contentEdit)
 
@@ -289,11 +373,8 @@ func (m *Message) messageEdit(c *wkhttp.Context) {
 			panic(err)
 		}
 	}()
-	fakeChannelID := req.ChannelID
-	if req.ChannelType == common.ChannelTypePerson.Uint8() {
-		fakeChannelID = common.GetFakeChannelIDWith(c.GetLoginUID(), req.ChannelID)
-	}
 
+	editedAt := int(time.Now().Unix())
 	version := m.genMessageExtraSeq(fakeChannelID)
 	err = m.messageExtraDB.insertOrUpdateContentEditTx(&messageExtraModel{
 		MessageID:       req.MessageID,
@@ -302,14 +383,40 @@ func (m *Message) messageEdit(c *wkhttp.Context) {
 		ChannelType:     req.ChannelType,
 		ContentEdit:     dbr.NewNullString(req.ContentEdit),
 		ContentEditHash: contentMD5,
-		EditedAt:        int(time.Now().Unix()),
+		EditedAt:        editedAt,
 		Version:         version,
 	}, tx)
 	if err != nil {
+		tx.Rollback()
 		m.Error("添加或修改编辑内容失败！", zap.Error(err))
 		c.ResponseError(errors.New("添加或修改编辑内容失败！"))
 		return
 	}
+	// 保存编辑历史
+	revision, err := m.editRevisionDB.queryMaxRevisionForUpdateTx(req.MessageID, tx)
+	if err != nil {
+		tx.Rollback()
+		m.Error("查询消息修订号失败！", zap.Error(err))
+		c.ResponseError(errors.New("查询消息修订号失败！"))
+		return
+	}
+	err = m.editRevisionDB.insertTx(&messageEditRevisionModel{
+		MessageID:       req.MessageID,
+		MessageSeq:      req.MessageSeq,
+		ChannelID:       fakeChannelID,
+		ChannelType:     req.ChannelType,
+		Revision:        revision + 1,
+		ContentEdit:     dbr.NewNullString(req.ContentEdit),
+		ContentEditHash: contentMD5,
+		EditorUID:       loginUID,
+		EditedAt:        editedAt,
+	}, tx)
+	if err != nil {
+		tx.Rollback()
+		m.Error("添加消息编辑历史失败！", zap.Error(err))
+		c.ResponseError(errors.New("添加消息编辑历史失败！"))
+		return
+	}
 	msgIds := make([]string, 0)
 	msgIds = append(msgIds, req.MessageID)
 	// 发布编辑事件
@@ -338,6 +445,10 @@ func (m *Message) messageEdit(c *wkhttp.Context) {
 	if eventID > 0 {
 		m.ctx.EventCommit(eventID)
 	}
+	// 内置搜索按编辑后的正文重新索引
+	if err = m.messageSearch.ReindexEdited(req.MessageID, contentEdit); err != nil {
+		m.Warn("重新索引编辑的消息失败！", zap.Error(err), zap.String("messageID", req.MessageID))
+	}
 
 	err = m.ctx.SendCMD(config.MsgCMDReq{
 		NoPersist:   true,
@@ -721,6 +832,10 @@ func (m *Message) search(c *wkhttp.Context) {
 	}
 	uid := c.MustGet("uid").(string)
 	req.UID = uid
+	if m.messageSearch.LocalOn() {
+		m.searchWithLocalIndex(c, req.UID, req.ChannelID, req.ChannelType, req.ContentType, req.Keyword)
+		return
+	}
 	fmt.Println("req->", req)
 	resp, err := network.Post(fmt.Sprintf("%s/message/search", m.ctx.GetConfig().WuKongIM.APIURL), []byte(util.ToJson(req)), nil)
 	if err != nil {
@@ -744,6 +859,35 @@ func (m *Message) search(c *wkhttp.Context) {
 	c.JSON(http.StatusOK, results)
 }
 
+// 通过内置索引搜索消息
+func (m *Message) searchWithLocalIndex(c *wkhttp.Context, uid string, channelID string, channelType uint8, contentType int, keyword string) {
+	searchReq := &msgsearch.SearchReq{
+		UID:         uid,
+		Keyword:     keyword,
+		ChannelID:   channelID,
+		ChannelType: channelType,
+	}
+	if contentType != 0 {
+		searchReq.ContentTypes = []int{contentType}
+	}
+	searchResp, err := m.messageSearch.Search(searchReq)
+	if err != nil {
+		m.Error("搜索消息失败！", zap.Error(err))
+		c.ResponseError(errors.New("搜索消息失败！"))
+		return
+	}
+	results := make([]*MsgSyncResp, 0, len(searchResp.Messages))
+	for _, message := range searchResp.Messages {
+		msgResp := &MsgSyncResp{}
+		msgResp.from(message, uid, nil, nil, nil, 0)
+		if msgResp.IsDeleted == 1 {
+			continue
+		}
+		results = append(results, msgResp)
+	}
+	c.JSON(http.StatusOK, results)
+}
+
 // 语音消息设置为已读
 func (m *Message) voiceReaded(c *wkhttp.Context) {
 	var req *voiceReadedReq
@@ -1073,14 +1217,14 @@ func (m *Message) mutualDelete(c *wkhttp.Context) {
 	}
 	isCanDelete := true
 	if req.ChannelType == common.ChannelTypeGroup.Uint8() {
-		isManager, err := m.groupService.IsCreatorOrManager(req.ChannelID, loginUID)
-		if err != nil {
-			m.Error("查询登录用户群内权限错误", zap.Error(err))
-			c.ResponseError(errors.New("查询登录用户群内权限错误"))
-			return
-		}
-		if resp.Messages[0].FromUID != loginUID && !isManager {
-			isCanDelete = false
+		if resp.Messages[0].FromUID != loginUID {
+			canRevoke, err := m.groupService.HasPermission(req.ChannelID, loginUID, group.PermissionRevokeMessage)
+			if err != nil {
+				m.Error("查询登录用户群内权限错误", zap.Error(err))
+				c.ResponseError(errors.New("查询登录用户群内权限错误"))
+				return
+			}
+			isCanDelete = canRevoke
 		}
 	}
 	if !isCanDelete {
@@ -1334,28 +1478,8 @@ func (m *Message) hasRevokePermission(messageM *messageModel, loginUID string) (
 	if messageM.FromUID == loginUID { // 自己发的消息允许被撤回
 		return true, nil
 	}
-	if messageM.ChannelType == common.ChannelTypeGroup.Uint8() { // 管理者或创建者可以撤回其他成员的消息
-		loginMember, err := m.groupService.GetMember(messageM.ChannelID, loginUID)
-		if err != nil {
-			return false, err
-		}
-		if loginMember == nil {
-			return false, nil
-		}
-		fromMember, err := m.groupService.GetMember(messageM.ChannelID, messageM.FromUID)
-		if err != nil {
-			return false, err
-		}
-		if fromMember == nil && loginMember.Role != int(common.GroupMemberRoleNormal) {
-			return true, nil
-		}
-		if fromMember.Role == int(common.GroupMemberRoleCreater) || loginMember.Role == int(common.GroupMemberRoleNormal) {
-			return false, nil
-		}
-		if loginMember.Role == int(common.GroupMemberRoleCreater) || (loginMember.Role == int(common.GroupMemberRoleManager) && fromMember.Role == int(common.GroupMemberRoleNormal)) {
-			return true, nil
-		}
-
+	if messageM.ChannelType == common.ChannelTypeGroup.Uint8() { // 拥有撤回权限且身份高于发送者的成员可以撤回其消息
+		return m.groupService.CanOperateMember(messageM.ChannelID, loginUID, messageM.FromUID, group.PermissionRevokeMessage)
 	}
 
 	return false, nil
@@ -2084,6 +2208,7 @@ type messageExtraResp struct {
 	IsPinned        int                    `json:"is_pinned,omitempty"`         // 是否置顶
 	ContentEdit     map[string]interface{} `json:"content_edit,omitempty"`      // 编辑后的正文
 	EditedAt        int                    `json:"edited_at,omitempty"`         // 编辑时间 例如 12:23
+	Poll            map[string]interface{} `json:"poll,omitempty"`              // 投票结果
 	ExtraVersion    int64                  `json:"extra_version"`               // 数据版本
 }
 
@@ -2099,6 +2224,14 @@ func newMessageExtraResp(m *messageExtraDetailModel) *messageExtraResp {
 		}
 	}
 
+	var pollMap map[string]interface{}
+	if m.PollResult.String != "" {
+		err := util.ReadJsonByByte([]byte(m.PollResult.String), &pollMap)
+		if err != nil {
+			log.Warn("投票结果不是json格式！", zap.Error(err), zap.String("pollResult", m.PollResult.String))
+		}
+	}
+
 	var readedAt int64 = 0
 	if m.ReadedAt.Valid {
 		readedAt = m.ReadedAt.Time.Unix()
@@ -2116,6 +2249,7 @@ func newMessageExtraResp(m *messageExtraDetailModel) *messageExtraResp {
 		EditedAt:        m.EditedAt,
 		IsMutualDeleted: m.IsDeleted,
 		IsPinned:        m.IsPinned,
+		Poll:            pollMap,
 		ExtraVersion:    m.Version,
 	}
 }
@@ -0,0 +1,79 @@
+package message
+
+import (
+	"encoding/json"
+	"io"
+	"strconv"
+
+	"github.com/TangSengDaoDao/TangSengDaoDaoServerLib/common"
+)
+
+// 个人数据导出中的消息（附带所在频道）
+type dataExportMessage struct {
+	ChannelID   string `json:"channel_id"`
+	ChannelType uint8  `json:"channel_type"`
+	*exportMessage
+}
+
+// 个人数据导出：用户自己发送的消息
+// 与用户导出会话记录一致，不包含已删除的消息，撤回的消息不保留内容
+func (e *exporter) exportUserMessages(uid string, w io.Writer) (int64, error) {
+	if _, err := w.Write([]byte("[")); err != nil {
+		return 0, err
+	}
+	model := &exportModel{UID: uid}
+	userNames := map[string]string{}
+	var written int64
+	for _, table := range e.exportDB.getMessageTables() {
+		var lastID int64
+		for {
+			messages, err := e.exportDB.queryMessagesWithFromUID(table, uid, lastID, exportBatchSize)
+			if err != nil {
+				return 0, err
+			}
+			if len(messages) == 0 {
+				break
+			}
+			lastID = messages[len(messages)-1].Id
+			messageMap := make(map[string]*messageModel, len(messages))
+			for _, message := range messages {
+				messageMap[strconv.FormatInt(message.MessageID, 10)] = message
+			}
+			exportMessages, err := e.buildMessages(model, messages, userNames)
+			if err != nil {
+				return 0, err
+			}
+			for _, exportMsg := range exportMessages {
+				message := messageMap[exportMsg.MessageID]
+				channelID := message.ChannelID
+				if message.ChannelType == common.ChannelTypePerson.Uint8() {
+					channelID = fakeChannelPeerUID(message.ChannelID, uid)
+				}
+				data, err := json.Marshal(&dataExportMessage{
+					ChannelID:     channelID,
+					ChannelType:   message.ChannelType,
+					exportMessage: exportMsg,
+				})
+				if err != nil {
+					return 0, err
+				}
+				if written > 0 {
+					if _, err = w.Write([]byte(",")); err != nil {
+						return 0, err
+					}
+				}
+				if _, err = w.Write(data); err != nil {
+					return 0, err
+				}
+				written++
+			}
+			if len(messages) < exportBatchSize {
+				break
+			}
+		}
+	}
+	if _, err := w.Write([]byte("]")); err != nil {
+		return 0, err
+	}
+	return written, nil
+}
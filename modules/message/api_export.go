@@ -0,0 +1,902 @@
+package message
+
+import (
+	"archive/zip"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html"
+	"html/template"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/TangSengDaoDao/TangSengDaoDaoServer/modules/channel"
+	chservice "github.com/TangSengDaoDao/TangSengDaoDaoServer/modules/channel/service"
+	"github.com/TangSengDaoDao/TangSengDaoDaoServer/modules/file"
+	"github.com/TangSengDaoDao/TangSengDaoDaoServer/modules/user"
+	"github.com/TangSengDaoDao/TangSengDaoDaoServer/pkg/exporttask"
+	"github.com/TangSengDaoDao/TangSengDaoDaoServer/pkg/markdown"
+	"github.com/TangSengDaoDao/TangSengDaoDaoServerLib/common"
+	"github.com/TangSengDaoDao/TangSengDaoDaoServerLib/config"
+	"github.com/TangSengDaoDao/TangSengDaoDaoServerLib/pkg/log"
+	"github.com/TangSengDaoDao/TangSengDaoDaoServerLib/pkg/util"
+	"github.com/TangSengDaoDao/TangSengDaoDaoServerLib/pkg/wkhttp"
+	"go.uber.org/zap"
+)
+
+const (
+	exportStatusWait    = exporttask.StatusWait    // 等待导出
+	exportStatusRunning = exporttask.StatusRunning // 导出中
+	exportStatusDone    = exporttask.StatusDone    // 已完成
+	exportStatusFail    = exporttask.StatusFail    // 导出失败
+)
+
+const (
+	exportSyncMaxCount      = 2000             // 消息数量不超过此值时直接导出，否则转为后台任务
+	exportBatchSize         = 500              // 每批读取的消息数量
+	exportMaxUnfinished     = 3                // 每个用户同时进行中的导出任务上限
+	exportListLimit         = 50               // 导出记录列表的最大数量
+	exportScanInterval      = time.Second * 5  // 扫描待导出任务的间隔
+	exportScanLimit         = 10               // 每次扫描的最大任务数量
+	exportRunningStaleAfter = time.Minute * 10 // 导出中状态超过此时间未更新进度视为中断，重新导出
+)
+
+var exportWorkerOnce sync.Once
+
+type exportReq struct {
+	ChannelID   string `json:"channel_id"`
+	ChannelType uint8  `json:"channel_type"`
+}
+
+type exportResp struct {
+	ExportNo    string `json:"export_no"`
+	ChannelID   string `json:"channel_id"`
+	ChannelType uint8  `json:"channel_type"`
+	Status      int    `json:"status"`    // 状态 0.等待导出 1.导出中 2.已完成 3.导出失败
+	Total       int64  `json:"total"`     // 需要导出的消息数量
+	Processed   int64  `json:"processed"` // 已处理的消息数量
+	Progress    int    `json:"progress"`  // 导出进度 0-100
+	FileSize    int64  `json:"file_size,omitempty"`
+	FailReason  string `json:"fail_reason,omitempty"`
+	FinishedAt  int64  `json:"finished_at,omitempty"`
+	CreatedAt   string `json:"created_at"`
+}
+
+// channelID为个人频道时返回登录用户视角的频道ID（对方uid）
+func newExportResp(m *exportModel, loginUID string) *exportResp {
+	channelID := m.ChannelID
+	if m.ChannelType == common.ChannelTypePerson.Uint8() && loginUID != "" {
+		channelID = fakeChannelPeerUID(m.ChannelID, loginUID)
+	}
+	progress := 0
+	if m.Status == exportStatusDone {
+		progress = 100
+	} else if m.Total > 0 {
+		progress = int(m.Processed * 100 / m.Total)
+		if progress > 99 {
+			progress = 99
+		}
+	}
+	return &exportResp{
+		ExportNo:    m.ExportNo,
+		ChannelID:   channelID,
+		ChannelType: m.ChannelType,
+		Status:      m.Status,
+		Total:       m.Total,
+		Processed:   m.Processed,
+		Progress:    progress,
+		FileSize:    m.FileSize,
+		FailReason:  m.FailReason,
+		FinishedAt:  m.FinishedAt,
+		CreatedAt:   m.CreatedAt.String(),
+	}
+}
+
+// 导出会话记录
+func (m *Message) exportAdd(c *wkhttp.Context) {
+	loginUID := c.GetLoginUID()
+	var req exportReq
+	if err := c.BindJSON(&req); err != nil {
+		m.Error(common.ErrData.Error(), zap.Error(err))
+		c.ResponseError(common.ErrData)
+		return
+	}
+	if strings.TrimSpace(req.ChannelID) == "" {
+		c.ResponseError(errors.New("频道ID不能为空！"))
+		return
+	}
+	channelID := req.ChannelID
+	switch req.ChannelType {
+	case common.ChannelTypePerson.Uint8():
+		channelID = common.GetFakeChannelIDWith(loginUID, req.ChannelID)
+	case common.ChannelTypeGroup.Uint8():
+		isMember, err := m.groupService.ExistMember(req.ChannelID, loginUID)
+		if err != nil {
+			m.Error("查询群成员失败！", zap.Error(err))
+			c.ResponseError(errors.New("查询群成员失败！"))
+			return
+		}
+		if !isMember {
+			c.ResponseError(errors.New("不是群成员，不能导出！"))
+			return
+		}
+	case chservice.ChannelTypeThread:
+		thread, err := m.channelService.GetThread(req.ChannelID)
+		if err != nil {
+			m.Error("查询话题失败！", zap.Error(err))
+			c.ResponseError(errors.New("查询话题失败！"))
+			return
+		}
+		if thread == nil {
+			c.ResponseError(errors.New("话题不存在！"))
+			return
+		}
+		isMember, err := m.groupService.ExistMember(thread.ParentChannelID, loginUID)
+		if err != nil {
+			m.Error("查询群成员失败！", zap.Error(err))
+			c.ResponseError(errors.New("查询群成员失败！"))
+			return
+		}
+		if !isMember {
+			c.ResponseError(errors.New("不是群成员，不能导出！"))
+			return
+		}
+	default:
+		c.ResponseError(errors.New("不支持导出此类型的频道！"))
+		return
+	}
+	unfinished, err := m.exporter.exportDB.queryUnfinishedCount(loginUID)
+	if err != nil {
+		m.Error("查询导出任务失败！", zap.Error(err))
+		c.ResponseError(errors.New("查询导出任务失败！"))
+		return
+	}
+	if unfinished >= exportMaxUnfinished {
+		c.ResponseError(errors.New("导出任务过多，请等待之前的任务完成！"))
+		return
+	}
+	model, err := m.exporter.create(loginUID, false, channelID, req.ChannelType)
+	if err != nil {
+		m.Error("创建导出任务失败！", zap.Error(err))
+		c.ResponseError(errors.New("创建导出任务失败！"))
+		return
+	}
+	c.Response(newExportResp(model, loginUID))
+}
+
+// 自己的导出记录
+func (m *Message) exportList(c *wkhttp.Context) {
+	loginUID := c.GetLoginUID()
+	models, err := m.exporter.exportDB.queryWithUID(loginUID, 0, exportListLimit)
+	if err != nil {
+		m.Error("查询导出记录失败！", zap.Error(err))
+		c.ResponseError(errors.New("查询导出记录失败！"))
+		return
+	}
+	resps := make([]*exportResp, 0, len(models))
+	for _, model := range models {
+		resps = append(resps, newExportResp(model, loginUID))
+	}
+	c.Response(resps)
+}
+
+// 导出详情（进度）
+func (m *Message) exportGet(c *wkhttp.Context) {
+	loginUID := c.GetLoginUID()
+	model, err := m.exporter.exportDB.queryWithExportNo(c.Param("export_no"))
+	if err != nil {
+		m.Error("查询导出记录失败！", zap.Error(err))
+		c.ResponseError(errors.New("查询导出记录失败！"))
+		return
+	}
+	if model == nil || model.IsManager == 1 || model.UID != loginUID {
+		c.ResponseError(errors.New("导出记录不存在！"))
+		return
+	}
+	c.Response(newExportResp(model, loginUID))
+}
+
+// 下载导出的归档文件
+func (m *Message) exportFile(c *wkhttp.Context) {
+	loginUID := c.GetLoginUID()
+	model, err := m.exporter.exportDB.queryWithExportNo(c.Param("export_no"))
+	if err != nil {
+		m.Error("查询导出记录失败！", zap.Error(err))
+		c.ResponseError(errors.New("查询导出记录失败！"))
+		return
+	}
+	if model == nil || model.IsManager == 1 || model.UID != loginUID {
+		c.ResponseError(errors.New("导出记录不存在！"))
+		return
+	}
+	m.exporter.download(c, model)
+}
+
+// 导出任意频道的会话记录
+func (m *Manager) exportAdd(c *wkhttp.Context) {
+	err := c.CheckLoginRole()
+	if err != nil {
+		c.ResponseError(err)
+		return
+	}
+	var req struct {
+		ChannelID   string `json:"channel_id"`
+		ChannelType uint8  `json:"channel_type"`
+		UID         string `json:"uid"` // 个人频道时为会话中的另一方uid
+	}
+	if err := c.BindJSON(&req); err != nil {
+		m.Error(common.ErrData.Error(), zap.Error(err))
+		c.ResponseError(common.ErrData)
+		return
+	}
+	if strings.TrimSpace(req.ChannelID) == "" {
+		c.ResponseError(errors.New("频道ID不能为空！"))
+		return
+	}
+	channelID := req.ChannelID
+	if req.ChannelType == common.ChannelTypePerson.Uint8() {
+		if strings.TrimSpace(req.UID) == "" {
+			c.ResponseError(errors.New("uid不能为空！"))
+			return
+		}
+		channelID = common.GetFakeChannelIDWith(req.UID, req.ChannelID)
+	}
+	model, err := m.exporter.create(c.GetLoginUID(), true, channelID, req.ChannelType)
+	if err != nil {
+		m.Error("创建导出任务失败！", zap.Error(err))
+		c.ResponseError(errors.New("创建导出任务失败！"))
+		return
+	}
+	c.Response(newExportResp(model, ""))
+}
+
+// 导出记录
+func (m *Manager) exportList(c *wkhttp.Context) {
+	err := c.CheckLoginRole()
+	if err != nil {
+		c.ResponseError(err)
+		return
+	}
+	pageIndex, pageSize := c.GetPage()
+	models, err := m.exporter.exportDB.queryWithPage(uint64(pageSize), uint64(pageIndex))
+	if err != nil {
+		m.Error("查询导出记录失败！", zap.Error(err))
+		c.ResponseError(errors.New("查询导出记录失败！"))
+		return
+	}
+	count, err := m.exporter.exportDB.queryCount()
+	if err != nil {
+		m.Error("查询导出记录数量失败！", zap.Error(err))
+		c.ResponseError(errors.New("查询导出记录数量失败！"))
+		return
+	}
+	list := make([]*exportResp, 0, len(models))
+	for _, model := range models {
+		list = append(list, newExportResp(model, ""))
+	}
+	c.Response(map[string]interface{}{
+		"list":  list,
+		"count": count,
+	})
+}
+
+// 导出详情（进度）
+func (m *Manager) exportGet(c *wkhttp.Context) {
+	err := c.CheckLoginRole()
+	if err != nil {
+		c.ResponseError(err)
+		return
+	}
+	model, err := m.exporter.exportDB.queryWithExportNo(c.Param("export_no"))
+	if err != nil {
+		m.Error("查询导出记录失败！", zap.Error(err))
+		c.ResponseError(errors.New("查询导出记录失败！"))
+		return
+	}
+	if model == nil {
+		c.ResponseError(errors.New("导出记录不存在！"))
+		return
+	}
+	c.Response(newExportResp(model, ""))
+}
+
+// 下载导出的归档文件
+func (m *Manager) exportFile(c *wkhttp.Context) {
+	err := c.CheckLoginRole()
+	if err != nil {
+		c.ResponseError(err)
+		return
+	}
+	model, err := m.exporter.exportDB.queryWithExportNo(c.Param("export_no"))
+	if err != nil {
+		m.Error("查询导出记录失败！", zap.Error(err))
+		c.ResponseError(errors.New("查询导出记录失败！"))
+		return
+	}
+	if model == nil {
+		c.ResponseError(errors.New("导出记录不存在！"))
+		return
+	}
+	m.exporter.download(c, model)
+}
+
+// exporter 会话记录导出
+// 归档为zip文件，包含messages.json（消息及撤回、删除、编辑信息）、index.html（渲染后的会话记录）和attachments.json（附件地址）
+type exporter struct {
+	ctx *config.Context
+	log.Log
+	exportDB           *exportDB
+	messageExtraDB     *messageExtraDB
+	messageUserExtraDB *messageUserExtraDB
+	channelOffsetDB    *channelOffsetDB
+	channelService     chservice.IService
+	userService        user.IService
+	fileService        file.IService
+	worker             *exporttask.Worker
+}
+
+func newExporter(ctx *config.Context) *exporter {
+	e := &exporter{
+		ctx:                ctx,
+		Log:                log.NewTLog("MessageExporter"),
+		exportDB:           newExportDB(ctx),
+		messageExtraDB:     newMessageExtraDB(ctx),
+		messageUserExtraDB: newMessageUserExtraDB(ctx),
+		channelOffsetDB:    newChannelOffsetDB(ctx),
+		channelService:     channel.NewService(ctx),
+		userService:        user.NewService(ctx),
+		fileService:        file.NewService(ctx),
+	}
+	e.worker = &exporttask.Worker{
+		Log:               e.Log,
+		ScanInterval:      exportScanInterval,
+		ScanLimit:         exportScanLimit,
+		StaleAfter:        exportRunningStaleAfter,
+		QueryStaleRunning: e.exportDB.queryStaleRunning,
+		QueryWaiting:      e.exportDB.queryWaiting,
+		UpdateStatus:      e.exportDB.updateStatus,
+		Run:               e.runWithExportNo,
+	}
+	return e
+}
+
+// 创建导出任务，消息数量较少时直接导出，否则交给后台任务处理
+func (e *exporter) create(uid string, isManager bool, channelID string, channelType uint8) (*exportModel, error) {
+	model := &exportModel{
+		ExportNo:    util.GenerUUID(),
+		UID:         uid,
+		ChannelID:   channelID,
+		ChannelType: channelType,
+		Status:      exportStatusWait,
+	}
+	if isManager {
+		model.IsManager = 1
+	}
+	startSeq, err := e.startMessageSeq(model)
+	if err != nil {
+		return nil, err
+	}
+	model.Total, err = e.exportDB.queryMessageCountAfterSeq(channelID, channelType, startSeq)
+	if err != nil {
+		return nil, err
+	}
+	err = e.exportDB.insert(model)
+	if err != nil {
+		return nil, err
+	}
+	if model.Total <= exportSyncMaxCount {
+		ok, err := e.worker.Claim(model.ExportNo)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			e.run(model)
+		}
+	}
+	return e.exportDB.queryWithExportNo(model.ExportNo)
+}
+
+func (e *exporter) download(c *wkhttp.Context, model *exportModel) {
+	if model.Status != exportStatusDone {
+		c.ResponseError(errors.New("导出尚未完成！"))
+		return
+	}
+	downloadURL, err := e.fileService.DownloadURL(model.FilePath, fmt.Sprintf("%s.zip", model.ExportNo))
+	if err != nil {
+		e.Error("获取下载地址失败！", zap.Error(err))
+		c.ResponseError(errors.New("获取下载地址失败！"))
+		return
+	}
+	c.Redirect(http.StatusFound, downloadURL)
+}
+
+// 开启后台导出任务（多次调用只会启动一个）
+func (e *exporter) start() {
+	exportWorkerOnce.Do(e.worker.Start)
+}
+
+func (e *exporter) runWithExportNo(exportNo string) {
+	model, err := e.exportDB.queryWithExportNo(exportNo)
+	if err != nil {
+		e.Error("查询导出任务失败！", zap.Error(err), zap.String("exportNo", exportNo))
+		return
+	}
+	if model == nil {
+		return
+	}
+	e.run(model)
+}
+
+func (e *exporter) run(model *exportModel) {
+	stopHeartbeat := e.worker.Heartbeat(model.ExportNo, func() error {
+		return e.exportDB.touchRunning(model.ExportNo)
+	})
+	err := e.export(model)
+	stopHeartbeat()
+	if errors.Is(err, exporttask.ErrLost) {
+		e.Warn("导出任务已失效，放弃本次导出结果！", zap.String("exportNo", model.ExportNo))
+		return
+	}
+	if err != nil {
+		e.Error("导出会话记录失败！", zap.Error(err), zap.String("exportNo", model.ExportNo), zap.String("channelID", model.ChannelID))
+		if _, err = e.exportDB.updateFail(model.ExportNo, "导出失败"); err != nil {
+			e.Error("修改导出任务为失败状态失败！", zap.Error(err))
+		}
+	}
+}
+
+// 用户导出时从自己清空记录的位置开始，管理员导出全部消息
+func (e *exporter) startMessageSeq(model *exportModel) (uint32, error) {
+	if model.IsManager == 1 {
+		return 0, nil
+	}
+	return queryVisibleStartSeq(e.channelService, e.channelOffsetDB, model.UID, model.ChannelID, model.ChannelType)
+}
+
+// 用户在频道内可见消息的起始序号（小于等于此序号的消息不可见）
+// 取频道清空记录的位置和用户自己清空记录（或入群时不允许查看历史消息）的位置中较大的
+func queryVisibleStartSeq(channelService chservice.IService, channelOffsetDB *channelOffsetDB, uid string, fakeChannelID string, channelType uint8) (uint32, error) {
+	var startSeq uint32
+	channelSettings, err := channelService.GetChannelSettings([]string{fakeChannelID})
+	if err != nil {
+		return 0, err
+	}
+	if len(channelSettings) > 0 {
+		startSeq = channelSettings[0].OffsetMessageSeq
+	}
+	channelID := fakeChannelID
+	if channelType == common.ChannelTypePerson.Uint8() {
+		channelID = fakeChannelPeerUID(fakeChannelID, uid)
+	}
+	channelOffset, err := channelOffsetDB.queryWithUIDAndChannel(uid, channelID, channelType)
+	if err != nil {
+		return 0, err
+	}
+	if channelOffset != nil && channelOffset.MessageSeq > startSeq {
+		startSeq = channelOffset.MessageSeq
+	}
+	return startSeq, nil
+}
+
+func (e *exporter) export(model *exportModel) error {
+	startSeq, err := e.startMessageSeq(model)
+	if err != nil {
+		return err
+	}
+	total, err := e.exportDB.queryMessageCountAfterSeq(model.ChannelID, model.ChannelType, startSeq)
+	if err != nil {
+		return err
+	}
+	if err = e.exportDB.updateProgress(model.ExportNo, total, 0); err != nil {
+		return err
+	}
+
+	zipFile, err := os.CreateTemp("", "message_export_*.zip")
+	if err != nil {
+		return err
+	}
+	defer func() {
+		zipFile.Close()
+		os.Remove(zipFile.Name())
+	}()
+	// html正文先写入临时文件，消息读取完后再写入归档
+	htmlBodyFile, err := os.CreateTemp("", "message_export_*.html")
+	if err != nil {
+		return err
+	}
+	defer func() {
+		htmlBodyFile.Close()
+		os.Remove(htmlBodyFile.Name())
+	}()
+
+	zw := zip.NewWriter(zipFile)
+	jsonWriter, err := zw.Create("messages.json")
+	if err != nil {
+		return err
+	}
+	exportedAt := time.Now()
+	_, err = fmt.Fprintf(jsonWriter, `{"export_no":%s,"channel_id":%s,"channel_type":%d,"exported_at":%d,"messages":[`, util.ToJson(model.ExportNo), util.ToJson(model.ChannelID), model.ChannelType, exportedAt.Unix())
+	if err != nil {
+		return err
+	}
+
+	userNames := map[string]string{}
+	attachments := make([]*exportAttachment, 0)
+	var processed int64
+	var written int
+	lastSeq := startSeq
+	for {
+		messages, err := e.exportDB.queryMessagesAfterSeq(model.ChannelID, model.ChannelType, lastSeq, exportBatchSize)
+		if err != nil {
+			return err
+		}
+		if len(messages) == 0 {
+			break
+		}
+		exportMessages, err := e.buildMessages(model, messages, userNames)
+		if err != nil {
+			return err
+		}
+		for _, exportMsg := range exportMessages {
+			data, err := json.Marshal(exportMsg)
+			if err != nil {
+				return err
+			}
+			if written > 0 {
+				if _, err = jsonWriter.Write([]byte(",")); err != nil {
+					return err
+				}
+			}
+			if _, err = jsonWriter.Write(data); err != nil {
+				return err
+			}
+			written++
+			if err = exportMessageTemplate.Execute(htmlBodyFile, e.newMessageView(exportMsg)); err != nil {
+				return err
+			}
+			attachments = append(attachments, e.attachmentsOf(exportMsg)...)
+		}
+		processed += int64(len(messages))
+		lastSeq = messages[len(messages)-1].MessageSeq
+		if processed > total {
+			total = processed
+		}
+		if err = e.exportDB.updateProgress(model.ExportNo, total, processed); err != nil {
+			return err
+		}
+		if len(messages) < exportBatchSize {
+			break
+		}
+	}
+	if _, err = jsonWriter.Write([]byte("]}")); err != nil {
+		return err
+	}
+
+	htmlWriter, err := zw.Create("index.html")
+	if err != nil {
+		return err
+	}
+	err = exportHeaderTemplate.Execute(htmlWriter, map[string]interface{}{
+		"ChannelID":   model.ChannelID,
+		"ChannelType": model.ChannelType,
+		"Count":       written,
+		"ExportedAt":  exportedAt.Format("2006-01-02 15:04:05"),
+	})
+	if err != nil {
+		return err
+	}
+	if _, err = htmlBodyFile.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	if _, err = io.Copy(htmlWriter, htmlBodyFile); err != nil {
+		return err
+	}
+	if _, err = htmlWriter.Write([]byte(exportHTMLFooter)); err != nil {
+		return err
+	}
+
+	attachmentWriter, err := zw.Create("attachments.json")
+	if err != nil {
+		return err
+	}
+	if err = json.NewEncoder(attachmentWriter).Encode(attachments); err != nil {
+		return err
+	}
+	if err = zw.Close(); err != nil {
+		return err
+	}
+
+	fileInfo, err := zipFile.Stat()
+	if err != nil {
+		return err
+	}
+	if _, err = zipFile.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	filePath := fmt.Sprintf("%s/message_export/%s.zip", file.TypeDownload, model.ExportNo)
+	_, err = e.fileService.UploadFile(filePath, "application/zip", func(w io.Writer) error {
+		_, err := io.Copy(w, zipFile)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	rows, err := e.exportDB.updateDone(model.ExportNo, filePath, fileInfo.Size())
+	if err != nil {
+		return err
+	}
+	if rows == 0 {
+		return exporttask.ErrLost
+	}
+	return nil
+}
+
+// 组装一批消息的导出数据
+// 用户导出时过滤掉自己删除和被删除的消息，撤回的消息不保留内容；管理员导出保留全部消息及其状态
+func (e *exporter) buildMessages(model *exportModel, messages []*messageModel, userNames map[string]string) ([]*exportMessage, error) {
+	messageIDs := make([]string, 0, len(messages))
+	uids := make([]string, 0)
+	for _, message := range messages {
+		messageIDs = append(messageIDs, strconv.FormatInt(message.MessageID, 10))
+		if _, ok := userNames[message.FromUID]; !ok {
+			userNames[message.FromUID] = ""
+			uids = append(uids, message.FromUID)
+		}
+	}
+	if len(uids) > 0 {
+		users, err := e.userService.GetUsers(uids)
+		if err != nil {
+			return nil, err
+		}
+		for _, u := range users {
+			userNames[u.UID] = u.Name
+		}
+	}
+	messageExtras, err := e.messageExtraDB.queryWithMessageIDs(messageIDs)
+	if err != nil {
+		return nil, err
+	}
+	messageExtraMap := map[string]*messageExtraModel{}
+	for _, messageExtra := range messageExtras {
+		messageExtraMap[messageExtra.MessageID] = messageExtra
+	}
+	userDeletedMap := map[string]bool{}
+	if model.IsManager == 0 {
+		userExtras, err := e.messageUserExtraDB.queryDeletedWithMessageIDsAndUID(messageIDs, model.UID)
+		if err != nil {
+			return nil, err
+		}
+		for _, userExtra := range userExtras {
+			userDeletedMap[userExtra.MessageID] = true
+		}
+	}
+
+	exportMessages := make([]*exportMessage, 0, len(messages))
+	for _, message := range messages {
+		messageID := strconv.FormatInt(message.MessageID, 10)
+		exportMsg := &exportMessage{
+			MessageID:   messageID,
+			MessageSeq:  message.MessageSeq,
+			ClientMsgNo: message.ClientMsgNo,
+			FromUID:     message.FromUID,
+			FromName:    userNames[message.FromUID],
+			Timestamp:   message.Timestamp,
+			IsDeleted:   message.IsDeleted,
+		}
+		if message.Signal == 1 {
+			exportMsg.Encrypted = 1
+		} else {
+			_ = util.ReadJsonByByte(message.Payload, &exportMsg.Payload)
+			if contentTypeNumber, ok := exportMsg.Payload["type"].(json.Number); ok {
+				contentType, _ := contentTypeNumber.Int64()
+				exportMsg.ContentType = int(contentType)
+			}
+		}
+		messageExtra := messageExtraMap[messageID]
+		if messageExtra != nil {
+			exportMsg.Revoke = messageExtra.Revoke
+			exportMsg.Revoker = messageExtra.Revoker
+			if messageExtra.IsDeleted == 1 {
+				exportMsg.IsDeleted = 1
+			}
+			if messageExtra.ContentEdit.String != "" {
+				_ = util.ReadJsonByByte([]byte(messageExtra.ContentEdit.String), &exportMsg.ContentEdit)
+				exportMsg.EditedAt = messageExtra.EditedAt
+			}
+		}
+		if model.IsManager == 0 {
+			if exportMsg.IsDeleted == 1 || userDeletedMap[messageID] {
+				continue
+			}
+			if exportMsg.Revoke == 1 {
+				exportMsg.Payload = nil
+				exportMsg.ContentEdit = nil
+			}
+		}
+		exportMessages = append(exportMessages, exportMsg)
+	}
+	return exportMessages, nil
+}
+
+// 消息中的附件地址
+func (e *exporter) attachmentsOf(msg *exportMessage) []*exportAttachment {
+	if msg.Payload == nil {
+		return nil
+	}
+	attachments := make([]*exportAttachment, 0)
+	add := func(key string) {
+		url, _ := msg.Payload[key].(string)
+		if url == "" {
+			return
+		}
+		name, _ := msg.Payload["name"].(string)
+		attachments = append(attachments, &exportAttachment{
+			MessageID:   msg.MessageID,
+			ContentType: msg.ContentType,
+			Name:        name,
+			URL:         e.fullURL(url),
+		})
+	}
+	switch common.ContentType(msg.ContentType) {
+	case common.Image, common.GIF, common.Voice, common.File:
+		add("url")
+	case common.Video:
+		add("url")
+		add("cover")
+	}
+	return attachments
+}
+
+// 相对路径的附件地址补全为完整地址
+func (e *exporter) fullURL(url string) string {
+	if strings.HasPrefix(url, "http://") || strings.HasPrefix(url, "https://") {
+		return url
+	}
+	return fmt.Sprintf("%s/%s", strings.TrimSuffix(e.ctx.GetConfig().External.APIBaseURL, "/"), strings.TrimPrefix(url, "/"))
+}
+
+func (e *exporter) newMessageView(msg *exportMessage) *exportMessageView {
+	view := &exportMessageView{
+		MessageID: msg.MessageID,
+		FromUID:   msg.FromUID,
+		FromName:  msg.FromName,
+		Time:      time.Unix(msg.Timestamp, 0).Format("2006-01-02 15:04:05"),
+		Revoked:   msg.Revoke == 1,
+		Deleted:   msg.IsDeleted == 1,
+		Edited:    msg.ContentEdit != nil,
+	}
+	if msg.Encrypted == 1 {
+		view.Content = template.HTML("[加密消息]")
+		return view
+	}
+	payload := msg.Payload
+	if msg.ContentEdit != nil {
+		payload = msg.ContentEdit
+	}
+	if payload == nil {
+		if view.Revoked {
+			view.Content = template.HTML("[消息已撤回]")
+		}
+		return view
+	}
+	label := exportContentLabels[common.ContentType(msg.ContentType)]
+	if label == "" {
+		label = fmt.Sprintf("[消息类型:%d]", msg.ContentType)
+	}
+	switch common.ContentType(msg.ContentType) {
+	case common.Text:
+		content, _ := payload["content"].(string)
+		// 先转义再渲染，避免消息内容中的html被执行
+		view.Content = template.HTML(markdown.ToHtml(html.EscapeString(content)))
+	case common.Image, common.GIF, common.Voice, common.Video, common.File:
+		var b strings.Builder
+		for _, attachment := range e.attachmentsOf(msg) {
+			text := label
+			if attachment.Name != "" {
+				text = fmt.Sprintf("%s %s", label, attachment.Name)
+			}
+			fmt.Fprintf(&b, `<p><a href="%s" target="_blank">%s</a></p>`, html.EscapeString(attachment.URL), html.EscapeString(text))
+		}
+		view.Content = template.HTML(b.String())
+	default:
+		view.Content = template.HTML(html.EscapeString(label))
+	}
+	return view
+}
+
+// 个人频道ID中的对方uid
+func fakeChannelPeerUID(fakeChannelID string, uid string) string {
+	uids := strings.Split(fakeChannelID, "@")
+	if len(uids) != 2 {
+		return fakeChannelID
+	}
+	if uids[0] == uid {
+		return uids[1]
+	}
+	return uids[0]
+}
+
+type exportMessage struct {
+	MessageID   string                 `json:"message_id"`
+	MessageSeq  uint32                 `json:"message_seq"`
+	ClientMsgNo string                 `json:"client_msg_no"`
+	FromUID     string                 `json:"from_uid"`
+	FromName    string                 `json:"from_name"`
+	Timestamp   int64                  `json:"timestamp"`
+	ContentType int                    `json:"content_type"`
+	Payload     map[string]interface{} `json:"payload,omitempty"`
+	Encrypted   int                    `json:"encrypted,omitempty"`    // 是否是端对端加密消息（不导出内容）
+	Revoke      int                    `json:"revoke"`                 // 是否撤回
+	Revoker     string                 `json:"revoker,omitempty"`      // 撤回者uid
+	IsDeleted   int                    `json:"is_deleted"`             // 是否被删除
+	ContentEdit map[string]interface{} `json:"content_edit,omitempty"` // 编辑后的正文
+	EditedAt    int                    `json:"edited_at,omitempty"`    // 编辑时间
+}
+
+type exportAttachment struct {
+	MessageID   string `json:"message_id"`
+	ContentType int    `json:"content_type"`
+	Name        string `json:"name,omitempty"`
+	URL         string `json:"url"`
+}
+
+type exportMessageView struct {
+	MessageID string
+	FromUID   string
+	FromName  string
+	Time      string
+	Revoked   bool
+	Deleted   bool
+	Edited    bool
+	Content   template.HTML
+}
+
+var exportContentLabels = map[common.ContentType]string{
+	common.Image:           "[图片]",
+	common.GIF:             "[GIF]",
+	common.Voice:           "[语音]",
+	common.Video:           "[视频]",
+	common.File:            "[文件]",
+	common.Location:        "[位置]",
+	common.Card:            "[名片]",
+	common.MultipleForward: "[聊天记录]",
+	common.VectorSticker:   "[贴纸]",
+	common.EmojiSticker:    "[表情]",
+}
+
+var exportHeaderTemplate = template.Must(template.New("header").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>会话记录 {{.ChannelID}}</title>
+<style>
+body{font-family:-apple-system,"PingFang SC","Microsoft YaHei",sans-serif;max-width:860px;margin:0 auto;padding:20px;color:#222;}
+.meta{color:#888;font-size:13px;border-bottom:1px solid #eee;padding-bottom:12px;}
+.msg{padding:10px 0;border-bottom:1px solid #f3f3f3;}
+.msg .head{font-size:13px;color:#888;}
+.msg .name{color:#333;font-weight:bold;margin-right:6px;}
+.msg .tag{margin-left:6px;padding:0 4px;border-radius:3px;background:#f0f0f0;}
+.msg .content{margin-top:4px;word-break:break-all;}
+.msg.revoked .content,.msg.deleted .content{color:#aaa;}
+pre{background:#f6f8fa;padding:8px;overflow:auto;}
+</style>
+</head>
+<body>
+<h2>会话记录</h2>
+<p class="meta">频道：{{.ChannelID}}（类型 {{.ChannelType}}） · 消息数：{{.Count}} · 导出时间：{{.ExportedAt}}</p>
+`))
+
+var exportMessageTemplate = template.Must(template.New("message").Parse(`<div class="msg{{if .Revoked}} revoked{{end}}{{if .Deleted}} deleted{{end}}" id="msg-{{.MessageID}}">
+<div class="head"><span class="name">{{if .FromName}}{{.FromName}}{{else}}{{.FromUID}}{{end}}</span><span>{{.Time}}</span>{{if .Edited}}<span class="tag">已编辑</span>{{end}}{{if .Revoked}}<span class="tag">已撤回</span>{{end}}{{if .Deleted}}<span class="tag">已删除</span>{{end}}</div>
+<div class="content">{{.Content}}</div>
+</div>
+`))
+
+const exportHTMLFooter = `</body>
+</html>
+`
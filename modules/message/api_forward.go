@@ -0,0 +1,474 @@
+package message
+
+import (
+	"encoding/json"
+	"errors"
+	"strconv"
+	"strings"
+
+	chservice "github.com/TangSengDaoDao/TangSengDaoDaoServer/modules/channel/service"
+	"github.com/TangSengDaoDao/TangSengDaoDaoServerLib/common"
+	"github.com/TangSengDaoDao/TangSengDaoDaoServerLib/config"
+	"github.com/TangSengDaoDao/TangSengDaoDaoServerLib/pkg/util"
+	"github.com/TangSengDaoDao/TangSengDaoDaoServerLib/pkg/wkhttp"
+	"go.uber.org/zap"
+)
+
+const (
+	forwardMaxMessages    = 100  // 一次合并转发的最大消息数量
+	forwardPreviewCount   = 4    // 合并转发消息中预览的消息数量
+	forwardMaxViaDepth    = 5    // 通过嵌套的合并转发查看时的最大层级
+	forwardSystemTypeFrom = 1000 // 大于等于此值的正文类型为系统消息，不允许转发
+)
+
+type forwardMessageResp struct {
+	MessageID  string                 `json:"message_id"`
+	MessageSeq uint32                 `json:"message_seq"`
+	FromUID    string                 `json:"from_uid"`
+	FromName   string                 `json:"from_name"`
+	Timestamp  int64                  `json:"timestamp"`
+	Payload    map[string]interface{} `json:"payload"`
+	Edited     int                    `json:"edited"` // 是否编辑过
+}
+
+type forwardBundleResp struct {
+	BundleNo          string                `json:"bundle_no"`
+	UID               string                `json:"uid"`                 // 转发者uid
+	SourceChannelType uint8                 `json:"source_channel_type"` // 原消息频道类型
+	MessageID         string                `json:"message_id"`          // 合并转发消息ID
+	MessageCount      int                   `json:"message_count"`
+	Messages          []*forwardMessageResp `json:"messages,omitempty"`
+	CreatedAt         string                `json:"created_at"`
+}
+
+// 合并转发
+// 服务端校验转发者可以看到所有原消息（频道成员、未清空、未删除、未撤回）后生成合并转发并发送到目标频道
+func (m *Message) forwardCreate(c *wkhttp.Context) {
+	loginUID := c.GetLoginUID()
+	var req struct {
+		ChannelID     string   `json:"channel_id"`   // 原消息频道ID
+		ChannelType   uint8    `json:"channel_type"` // 原消息频道类型
+		MessageIDs    []string `json:"message_ids"`  // 原消息ID
+		ToChannelID   string   `json:"to_channel_id"`
+		ToChannelType uint8    `json:"to_channel_type"`
+	}
+	if err := c.BindJSON(&req); err != nil {
+		m.Error(common.ErrData.Error(), zap.Error(err))
+		c.ResponseError(common.ErrData)
+		return
+	}
+	if strings.TrimSpace(req.ChannelID) == "" {
+		c.ResponseError(errors.New("频道ID不能为空！"))
+		return
+	}
+	if strings.TrimSpace(req.ToChannelID) == "" {
+		c.ResponseError(errors.New("转发到的频道ID不能为空！"))
+		return
+	}
+	if req.ToChannelType != common.ChannelTypePerson.Uint8() && req.ToChannelType != common.ChannelTypeGroup.Uint8() {
+		c.ResponseError(errors.New("不支持转发到此类型的频道！"))
+		return
+	}
+	messageIDs := make([]string, 0, len(req.MessageIDs))
+	messageIDMap := map[string]bool{}
+	for _, messageID := range req.MessageIDs {
+		if messageID == "" || messageIDMap[messageID] {
+			continue
+		}
+		messageIDMap[messageID] = true
+		messageIDs = append(messageIDs, messageID)
+	}
+	if len(messageIDs) == 0 {
+		c.ResponseError(errors.New("转发的消息不能为空！"))
+		return
+	}
+	if len(messageIDs) > forwardMaxMessages {
+		c.ResponseError(errors.New("一次最多合并转发100条消息！"))
+		return
+	}
+	fakeChannelID, err := m.checkChannelReadable(loginUID, req.ChannelID, req.ChannelType)
+	if err != nil {
+		c.ResponseError(err)
+		return
+	}
+	bundleMessages, err := m.visibleForwardMessages(loginUID, fakeChannelID, req.ChannelType, messageIDs)
+	if err != nil {
+		c.ResponseError(err)
+		return
+	}
+	if err := checkSendPermission(m.userService, m.groupService, loginUID, false, req.ToChannelID, req.ToChannelType); err != nil {
+		c.ResponseError(err)
+		return
+	}
+
+	toFakeChannelID := req.ToChannelID
+	if req.ToChannelType == common.ChannelTypePerson.Uint8() {
+		toFakeChannelID = common.GetFakeChannelIDWith(loginUID, req.ToChannelID)
+	}
+	bundle := &forwardBundleModel{
+		BundleNo:          util.GenerUUID(),
+		UID:               loginUID,
+		SourceChannelID:   fakeChannelID,
+		SourceChannelType: req.ChannelType,
+		ToChannelID:       toFakeChannelID,
+		ToChannelType:     req.ToChannelType,
+		MessageCount:      len(bundleMessages),
+	}
+	for _, bundleMessage := range bundleMessages {
+		bundleMessage.BundleNo = bundle.BundleNo
+	}
+	tx, err := m.db.session.Begin()
+	if err != nil {
+		m.Error("开启事务失败！", zap.Error(err))
+		c.ResponseError(errors.New("开启事务失败！"))
+		return
+	}
+	defer func() {
+		if err := recover(); err != nil {
+			tx.Rollback()
+			panic(err)
+		}
+	}()
+	err = m.forwardDB.insertTx(bundle, bundleMessages, tx)
+	if err != nil {
+		tx.Rollback()
+		m.Error("添加合并转发失败！", zap.Error(err))
+		c.ResponseError(errors.New("添加合并转发失败！"))
+		return
+	}
+	if err = tx.Commit(); err != nil {
+		tx.Rollback()
+		m.Error("提交事务失败！", zap.Error(err))
+		c.ResponseError(errors.New("提交事务失败！"))
+		return
+	}
+
+	payload, err := m.forwardPayload(bundle, bundleMessages)
+	if err != nil {
+		m.Error("生成合并转发消息失败！", zap.Error(err))
+		c.ResponseError(errors.New("生成合并转发消息失败！"))
+		return
+	}
+	result, err := m.ctx.SendMessageWithResult(&config.MsgSendReq{
+		Header: config.MsgHeader{
+			RedDot: 1,
+		},
+		ChannelID:   req.ToChannelID,
+		ChannelType: req.ToChannelType,
+		FromUID:     loginUID,
+		Payload:     []byte(util.ToJson(payload)),
+	})
+	if err != nil {
+		m.Error("发送合并转发消息失败！", zap.Error(err))
+		if err := m.forwardDB.delete(bundle.BundleNo); err != nil {
+			m.Error("删除合并转发失败！", zap.Error(err))
+		}
+		c.ResponseError(errors.New("发送合并转发消息失败！"))
+		return
+	}
+	if result != nil {
+		bundle.MessageID = strconv.FormatInt(result.MessageID, 10)
+		bundle.MessageSeq = result.MessageSeq
+		err = m.forwardDB.updateMessage(bundle.BundleNo, bundle.MessageID, bundle.MessageSeq)
+		if err != nil {
+			m.Error("修改合并转发消息失败！", zap.Error(err))
+			c.ResponseError(errors.New("修改合并转发消息失败！"))
+			return
+		}
+	}
+	c.Response(&forwardBundleResp{
+		BundleNo:          bundle.BundleNo,
+		UID:               bundle.UID,
+		SourceChannelType: bundle.SourceChannelType,
+		MessageID:         bundle.MessageID,
+		MessageCount:      bundle.MessageCount,
+	})
+}
+
+// 合并转发详情
+// 转发者、目标频道成员可以查看；嵌套的合并转发通过via指定外层合并转发编号查看
+func (m *Message) forwardGet(c *wkhttp.Context) {
+	loginUID := c.GetLoginUID()
+	bundleNo := c.Param("bundle_no")
+	var vias []string
+	if c.Query("via") != "" {
+		vias = strings.Split(c.Query("via"), ",")
+	}
+	if len(vias) > forwardMaxViaDepth {
+		c.ResponseError(errors.New("合并转发嵌套层级过多！"))
+		return
+	}
+	bundle, err := m.forwardDB.queryWithBundleNo(bundleNo)
+	if err != nil {
+		m.Error("查询合并转发失败！", zap.Error(err))
+		c.ResponseError(errors.New("查询合并转发失败！"))
+		return
+	}
+	if bundle == nil {
+		c.ResponseError(errors.New("聊天记录不存在！"))
+		return
+	}
+	ok, err := m.canViewForward(loginUID, bundle, vias)
+	if err != nil {
+		m.Error("查询合并转发权限失败！", zap.Error(err))
+		c.ResponseError(errors.New("查询合并转发权限失败！"))
+		return
+	}
+	if !ok {
+		c.ResponseError(errors.New("无权查看此聊天记录！"))
+		return
+	}
+	bundleMessages, err := m.forwardDB.queryMessages(bundle.BundleNo)
+	if err != nil {
+		m.Error("查询合并转发消息失败！", zap.Error(err))
+		c.ResponseError(errors.New("查询合并转发消息失败！"))
+		return
+	}
+	uids := make([]string, 0)
+	userNames := map[string]string{}
+	for _, bundleMessage := range bundleMessages {
+		if _, ok := userNames[bundleMessage.FromUID]; !ok {
+			userNames[bundleMessage.FromUID] = ""
+			uids = append(uids, bundleMessage.FromUID)
+		}
+	}
+	if len(uids) > 0 {
+		users, err := m.userService.GetUsers(uids)
+		if err != nil {
+			m.Error("查询用户信息失败！", zap.Error(err))
+			c.ResponseError(errors.New("查询用户信息失败！"))
+			return
+		}
+		for _, user := range users {
+			userNames[user.UID] = user.Name
+		}
+	}
+	messageResps := make([]*forwardMessageResp, 0, len(bundleMessages))
+	for _, bundleMessage := range bundleMessages {
+		messageResp := &forwardMessageResp{
+			MessageID:  bundleMessage.MessageID,
+			MessageSeq: bundleMessage.MessageSeq,
+			FromUID:    bundleMessage.FromUID,
+			FromName:   userNames[bundleMessage.FromUID],
+			Timestamp:  bundleMessage.Timestamp,
+			Edited:     bundleMessage.Edited,
+		}
+		_ = util.ReadJsonByByte(bundleMessage.Payload, &messageResp.Payload)
+		messageResps = append(messageResps, messageResp)
+	}
+	c.Response(&forwardBundleResp{
+		BundleNo:          bundle.BundleNo,
+		UID:               bundle.UID,
+		SourceChannelType: bundle.SourceChannelType,
+		MessageID:         bundle.MessageID,
+		MessageCount:      bundle.MessageCount,
+		Messages:          messageResps,
+		CreatedAt:         bundle.CreatedAt.String(),
+	})
+}
+
+// 校验用户可以查看频道内的消息 返回频道在数据库中的ID（个人频道为用户uid组合后的ID）
+func (m *Message) checkChannelReadable(loginUID string, channelID string, channelType uint8) (string, error) {
+	groupNo := channelID
+	switch channelType {
+	case common.ChannelTypePerson.Uint8():
+		return common.GetFakeChannelIDWith(loginUID, channelID), nil
+	case common.ChannelTypeGroup.Uint8():
+	case chservice.ChannelTypeThread:
+		thread, err := m.channelService.GetThread(channelID)
+		if err != nil {
+			m.Error("查询话题失败！", zap.Error(err))
+			return "", errors.New("查询话题失败！")
+		}
+		if thread == nil {
+			return "", errors.New("话题不存在！")
+		}
+		groupNo = thread.ParentChannelID
+	default:
+		return "", errors.New("不支持此类型的频道！")
+	}
+	isMember, err := m.groupService.ExistMember(groupNo, loginUID)
+	if err != nil {
+		m.Error("查询群成员失败！", zap.Error(err))
+		return "", errors.New("查询群成员失败！")
+	}
+	if !isMember {
+		return "", errors.New("不是群成员！")
+	}
+	return channelID, nil
+}
+
+// 查询并校验用户可见的原消息，任意一条不可见时整体失败（不透露具体哪条消息不可见）
+func (m *Message) visibleForwardMessages(loginUID string, fakeChannelID string, channelType uint8, messageIDs []string) ([]*forwardBundleMessageModel, error) {
+	errNotVisible := errors.New("消息不存在或无权转发！")
+	messages, err := m.forwardDB.querySourceMessages(fakeChannelID, channelType, messageIDs)
+	if err != nil {
+		m.Error("查询消息失败！", zap.Error(err))
+		return nil, errors.New("查询消息失败！")
+	}
+	if len(messages) != len(messageIDs) {
+		return nil, errNotVisible
+	}
+	startSeq, err := queryVisibleStartSeq(m.channelService, m.channelOffsetDB, loginUID, fakeChannelID, channelType)
+	if err != nil {
+		m.Error("查询频道偏移量失败！", zap.Error(err))
+		return nil, errors.New("查询频道偏移量失败！")
+	}
+	messageExtras, err := m.messageExtraDB.queryWithMessageIDs(messageIDs)
+	if err != nil {
+		m.Error("查询消息扩展失败！", zap.Error(err))
+		return nil, errors.New("查询消息扩展失败！")
+	}
+	messageExtraMap := map[string]*messageExtraModel{}
+	for _, messageExtra := range messageExtras {
+		messageExtraMap[messageExtra.MessageID] = messageExtra
+	}
+	userExtras, err := m.messageUserExtraDB.queryDeletedWithMessageIDsAndUID(messageIDs, loginUID)
+	if err != nil {
+		m.Error("查询用户删除的消息失败！", zap.Error(err))
+		return nil, errors.New("查询用户删除的消息失败！")
+	}
+	if len(userExtras) > 0 {
+		return nil, errNotVisible
+	}
+
+	bundleMessages := make([]*forwardBundleMessageModel, 0, len(messages))
+	for _, message := range messages {
+		messageID := strconv.FormatInt(message.MessageID, 10)
+		if message.MessageSeq <= startSeq || message.IsDeleted == 1 || message.Signal == 1 {
+			return nil, errNotVisible
+		}
+		payload := message.Payload
+		edited := 0
+		messageExtra := messageExtraMap[messageID]
+		if messageExtra != nil {
+			if messageExtra.Revoke == 1 || messageExtra.IsDeleted == 1 {
+				return nil, errNotVisible
+			}
+			if messageExtra.ContentEdit.String != "" {
+				payload = []byte(messageExtra.ContentEdit.String)
+				edited = 1
+			}
+		}
+		var payloadMap map[string]interface{}
+		if err := util.ReadJsonByByte(payload, &payloadMap); err != nil {
+			return nil, errNotVisible
+		}
+		contentTypeNumber, ok := payloadMap["type"].(json.Number)
+		if !ok {
+			return nil, errNotVisible
+		}
+		contentType, _ := contentTypeNumber.Int64()
+		if contentType <= 0 || contentType >= forwardSystemTypeFrom {
+			return nil, errNotVisible
+		}
+		bundleMessage := &forwardBundleMessageModel{
+			MessageID:  messageID,
+			MessageSeq: message.MessageSeq,
+			FromUID:    message.FromUID,
+			Timestamp:  message.Timestamp,
+			Payload:    payload,
+			Edited:     edited,
+		}
+		if contentType == int64(common.MultipleForward) {
+			// 只引用由这条消息发出的合并转发记录，正文中伪造的bundle_no不作为引用（否则可以通过引用链查看无权查看的记录）
+			refBundleNo, _ := payloadMap["bundle_no"].(string)
+			if refBundleNo != "" {
+				refBundle, err := m.forwardDB.queryWithBundleNo(refBundleNo)
+				if err != nil {
+					m.Error("查询合并转发记录失败！", zap.Error(err))
+					return nil, errors.New("查询合并转发记录失败！")
+				}
+				if refBundle != nil && refBundle.MessageID == messageID {
+					bundleMessage.RefBundleNo = refBundleNo
+				}
+			}
+		}
+		bundleMessages = append(bundleMessages, bundleMessage)
+	}
+	return bundleMessages, nil
+}
+
+// 合并转发消息的正文，包含前几条消息的预览，完整的消息通过合并转发详情获取
+func (m *Message) forwardPayload(bundle *forwardBundleModel, bundleMessages []*forwardBundleMessageModel) (map[string]interface{}, error) {
+	uids := make([]string, 0)
+	uidMap := map[string]bool{}
+	for _, bundleMessage := range bundleMessages {
+		if !uidMap[bundleMessage.FromUID] {
+			uidMap[bundleMessage.FromUID] = true
+			uids = append(uids, bundleMessage.FromUID)
+		}
+	}
+	users, err := m.userService.GetUsers(uids)
+	if err != nil {
+		return nil, err
+	}
+	userResps := make([]map[string]interface{}, 0, len(users))
+	for _, user := range users {
+		userResps = append(userResps, map[string]interface{}{
+			"uid":  user.UID,
+			"name": user.Name,
+		})
+	}
+	previews := make([]map[string]interface{}, 0, forwardPreviewCount)
+	for i, bundleMessage := range bundleMessages {
+		if i >= forwardPreviewCount {
+			break
+		}
+		var payload map[string]interface{}
+		_ = util.ReadJsonByByte(bundleMessage.Payload, &payload)
+		previews = append(previews, map[string]interface{}{
+			"message_id": bundleMessage.MessageID,
+			"from_uid":   bundleMessage.FromUID,
+			"timestamp":  bundleMessage.Timestamp,
+			"payload":    payload,
+		})
+	}
+	return map[string]interface{}{
+		"type":          common.MultipleForward,
+		"bundle_no":     bundle.BundleNo,
+		"channel_type":  bundle.SourceChannelType,
+		"message_count": bundle.MessageCount,
+		"users":         userResps,
+		"msgs":          previews,
+	}, nil
+}
+
+// 是否可以查看合并转发
+// vias为从外到内的外层合并转发编号，用户可以查看最外层且每一层都包含下一层时可以查看
+func (m *Message) canViewForward(loginUID string, bundle *forwardBundleModel, vias []string) (bool, error) {
+	if len(vias) > 0 {
+		outer, err := m.forwardDB.queryWithBundleNo(vias[0])
+		if err != nil {
+			return false, err
+		}
+		if outer == nil {
+			return false, nil
+		}
+		ok, err := m.canViewForward(loginUID, outer, nil)
+		if err != nil || !ok {
+			return false, err
+		}
+		chain := make([]string, 0, len(vias)+1)
+		chain = append(chain, vias...)
+		chain = append(chain, bundle.BundleNo)
+		for i := 0; i < len(chain)-1; i++ {
+			exist, err := m.forwardDB.existRef(chain[i], chain[i+1])
+			if err != nil || !exist {
+				return false, err
+			}
+		}
+		return true, nil
+	}
+	if bundle.UID == loginUID {
+		return true, nil
+	}
+	if bundle.MessageID == "" {
+		return false, nil
+	}
+	if bundle.ToChannelType == common.ChannelTypePerson.Uint8() {
+		uids := strings.Split(bundle.ToChannelID, "@")
+		return len(uids) == 2 && (uids[0] == loginUID || uids[1] == loginUID), nil
+	}
+	return m.groupService.ExistMember(bundle.ToChannelID, loginUID)
+}
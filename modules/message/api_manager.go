@@ -8,6 +8,7 @@ import (
 
 	"github.com/TangSengDaoDao/TangSengDaoDaoServer/modules/base/event"
 	"github.com/TangSengDaoDao/TangSengDaoDaoServer/modules/group"
+	"github.com/TangSengDaoDao/TangSengDaoDaoServer/modules/message/prohibit"
 	"github.com/TangSengDaoDao/TangSengDaoDaoServer/modules/user"
 	"github.com/TangSengDaoDao/TangSengDaoDaoServerLib/common"
 	"github.com/TangSengDaoDao/TangSengDaoDaoServerLib/config"
@@ -25,21 +26,27 @@ import (
 type Manager struct {
 	ctx *config.Context
 	log.Log
-	userService  user.IService
-	groupService group.IService
-	managerDB    *managerDB
-	pinnedDB     *pinnedDB
+	userService     user.IService
+	groupService    group.IService
+	managerDB       *managerDB
+	pinnedDB        *pinnedDB
+	prohibitService prohibit.IService
+	exporter        *exporter
+	purger          *purger
 }
 
 // NewManager NewManager
 func NewManager(ctx *config.Context) *Manager {
 	return &Manager{
-		ctx:          ctx,
-		Log:          log.NewTLog("MessageManager"),
-		userService:  user.NewService(ctx),
-		groupService: group.NewService(ctx),
-		managerDB:    newManagerDB(ctx),
-		pinnedDB:     newPinnedDB(ctx),
+		ctx:             ctx,
+		Log:             log.NewTLog("MessageManager"),
+		userService:     user.NewService(ctx),
+		groupService:    group.NewService(ctx),
+		managerDB:       newManagerDB(ctx),
+		pinnedDB:        newPinnedDB(ctx),
+		prohibitService: prohibit.NewService(ctx),
+		exporter:        newExporter(ctx),
+		purger:          newPurger(ctx),
 	}
 }
 
@@ -47,17 +54,34 @@ func NewManager(ctx *config.Context) *Manager {
 func (m *Manager) Route(r *wkhttp.WKHttp) {
 	auth := r.Group("/v1/manager", m.ctx.AuthMiddleware(r))
 	{
-		auth.POST("/message/send", m.sendMsg)                         // 发送消息
-		auth.POST("message/sendfriends", m.sendMsgToFriends)          // 给某个用户代发消息
-		auth.GET("/message", m.list)                                  // 代发消息记录
-		auth.POST("/message/sendall", m.sendMsgToAllUsers)            // 给所有用户发送一条消息
-		auth.GET("/message/record", m.record)                         // 消息记录
-		auth.GET("/message/recordpersonal", m.recordpersonal)         // 单聊聊天记录
-		auth.POST("/message/prohibit_words", m.addProhibitWords)      // 添加违禁词
-		auth.GET("/message/prohibit_words", m.prohibitWords)          // 查询违禁词
-		auth.DELETE("/message/prohibit_words", m.deleteProhibitWords) // 删除违禁词
-		auth.DELETE("/message", m.delete)                             // 删除消息
-	}
+		auth.POST("/message/send", m.sendMsg)                                 // 发送消息
+		auth.POST("message/sendfriends", m.sendMsgToFriends)                  // 给某个用户代发消息
+		auth.GET("/message", m.list)                                          // 代发消息记录
+		auth.POST("/message/sendall", m.sendMsgToAllUsers)                    // 给所有用户发送一条消息
+		auth.GET("/message/record", m.record)                                 // 消息记录
+		auth.GET("/message/recordpersonal", m.recordpersonal)                 // 单聊聊天记录
+		auth.POST("/message/prohibit_words", m.addProhibitWords)              // 添加违禁词
+		auth.GET("/message/prohibit_words", m.prohibitWords)                  // 查询违禁词
+		auth.DELETE("/message/prohibit_words", m.deleteProhibitWords)         // 删除违禁词
+		auth.GET("/message/prohibit_words/hits", m.prohibitWordHits)          // 违禁词命中记录
+		auth.PUT("/message/prohibit_words/hits/:id", m.handleProhibitWordHit) // 审核违禁词命中记录
+		auth.DELETE("/message", m.delete)                                     // 删除消息
+		auth.POST("/message/export", m.exportAdd)                             // 导出会话记录
+		auth.GET("/message/exports", m.exportList)                            // 导出记录
+		auth.GET("/message/exports/:export_no", m.exportGet)                  // 导出详情（进度）
+		auth.GET("/message/exports/:export_no/download", m.exportFile)        // 下载导出的归档文件
+		auth.GET("/message/retention/policies", m.retentionList)              // 消息保留策略
+		auth.POST("/message/retention/policies", m.retentionAdd)              // 添加消息保留策略
+		auth.PUT("/message/retention/policies/:id", m.retentionUpdate)        // 修改消息保留策略
+		auth.DELETE("/message/retention/policies/:id", m.retentionDelete)     // 删除消息保留策略
+		auth.GET("/message/legal_holds", m.legalHolds)                        // 法律保全列表
+		auth.POST("/message/legal_holds", m.legalHoldAdd)                     // 添加法律保全
+		auth.DELETE("/message/legal_holds/:id", m.legalHoldRelease)           // 解除法律保全
+		auth.POST("/message/purge", m.purgeAdd)                               // 手动清理过期消息
+		auth.GET("/message/purge/reports", m.purgeReports)                    // 清理报告
+		auth.GET("/message/purge/reports/:report_no", m.purgeReport)          // 清理报告详情
+	}
+	m.purger.start() // 按保留策略定时清理过期消息
 }
 func (m *Manager) sendMsgToFriends(c *wkhttp.Context) {
 	err := c.CheckLoginRoleIsSuperAdmin()
@@ -355,6 +379,7 @@ func (m *Manager) prohibitWords(c *wkhttp.Context) {
 				CreatedAt: word.CreatedAt.String(),
 				IsDeleted: word.IsDeleted,
 				Version:   word.Version,
+				Action:    word.Action,
 				Id:        word.Id,
 			})
 		}
@@ -375,6 +400,14 @@ func (m *Manager) addProhibitWords(c *wkhttp.Context) {
 		c.ResponseError(errors.New("违禁词不能为空"))
 		return
 	}
+	action := int(prohibit.ActionMask)
+	if c.Query("action") != "" {
+		action, _ = strconv.Atoi(c.Query("action"))
+	}
+	if action != int(prohibit.ActionMask) && action != int(prohibit.ActionBlock) && action != int(prohibit.ActionReview) {
+		c.ResponseError(errors.New("违禁词处理方式不正确"))
+		return
+	}
 	model, err := m.managerDB.queryProhibitWordsWithContent(content)
 	if err != nil {
 		m.Error(common.ErrData.Error(), zap.Error(err))
@@ -385,6 +418,7 @@ func (m *Manager) addProhibitWords(c *wkhttp.Context) {
 	if model != nil {
 		model.IsDeleted = 0
 		model.Version = version
+		model.Action = action
 		err = m.managerDB.updateProhibitWord(model)
 		if err != nil {
 			m.Error(common.ErrData.Error(), zap.Error(err))
@@ -396,6 +430,7 @@ func (m *Manager) addProhibitWords(c *wkhttp.Context) {
 			IsDeleted: 0,
 			Content:   content,
 			Version:   version,
+			Action:    action,
 		})
 		if err != nil {
 			m.Error(common.ErrData.Error(), zap.Error(err))
@@ -405,6 +440,53 @@ func (m *Manager) addProhibitWords(c *wkhttp.Context) {
 	}
 	c.ResponseOK()
 }
+
+// 违禁词命中记录
+func (m *Manager) prohibitWordHits(c *wkhttp.Context) {
+	err := c.CheckLoginRole()
+	if err != nil {
+		c.ResponseError(err)
+		return
+	}
+	pageIndex, pageSize := c.GetPage()
+	status := -1
+	if c.Query("status") != "" {
+		status, _ = strconv.Atoi(c.Query("status"))
+	}
+	list, count, err := m.prohibitService.GetHits(status, uint64(pageIndex), uint64(pageSize))
+	if err != nil {
+		m.Error("查询违禁词命中记录错误", zap.Error(err))
+		c.ResponseError(errors.New("查询违禁词命中记录错误"))
+		return
+	}
+	c.Response(map[string]interface{}{
+		"list":  list,
+		"count": count,
+	})
+}
+
+// 审核违禁词命中记录
+func (m *Manager) handleProhibitWordHit(c *wkhttp.Context) {
+	err := c.CheckLoginRole()
+	if err != nil {
+		c.ResponseError(err)
+		return
+	}
+	id, _ := strconv.ParseInt(c.Param("id"), 10, 64)
+	status, _ := strconv.Atoi(c.Query("status"))
+	if id <= 0 || (status != prohibit.HitStatusHandled && status != prohibit.HitStatusIgnored) {
+		c.ResponseError(errors.New("参数错误"))
+		return
+	}
+	err = m.prohibitService.UpdateHitStatus(id, status, c.GetLoginUID())
+	if err != nil {
+		m.Error("修改违禁词命中记录错误", zap.Error(err))
+		c.ResponseError(errors.New("修改违禁词命中记录错误"))
+		return
+	}
+	c.ResponseOK()
+}
+
 func (m *Manager) recordpersonal(c *wkhttp.Context) {
 	err := c.CheckLoginRole()
 	if err != nil {
@@ -929,5 +1011,6 @@ type prohibitWordsVO struct {
 	Content   string `json:"content"`    // 违禁词
 	IsDeleted int    `json:"is_deleted"` // 是否删除
 	Version   int64  `json:"version"`    // 版本
+	Action    int    `json:"action"`     // 命中处理方式 1.替换 2.拦截 3.审核
 	CreatedAt string `json:"created_at"` // 时间
 }
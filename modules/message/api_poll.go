@@ -0,0 +1,582 @@
+package message
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"github.com/TangSengDaoDao/TangSengDaoDaoServer/modules/group"
+	"github.com/TangSengDaoDao/TangSengDaoDaoServerLib/common"
+	"github.com/TangSengDaoDao/TangSengDaoDaoServerLib/config"
+	"github.com/TangSengDaoDao/TangSengDaoDaoServerLib/pkg/util"
+	"github.com/TangSengDaoDao/TangSengDaoDaoServerLib/pkg/wkhttp"
+	"github.com/gocraft/dbr/v2"
+	"go.uber.org/zap"
+)
+
+const (
+	pollStatusOpen   = 0 // 进行中
+	pollStatusClosed = 1 // 已结束
+)
+
+const (
+	pollMinOptions      = 2   // 最少选项数量
+	pollMaxOptions      = 20  // 最多选项数量
+	pollQuestionMaxLen  = 500 // 问题最大长度
+	pollOptionMaxLen    = 100 // 选项最大长度
+	pollVotersMaxReturn = 100 // 公开投票时每个选项最多返回的投票者数量
+)
+
+const (
+	pollScanInterval = time.Second * 30 // 扫描到期投票的间隔
+	pollScanLimit    = 100              // 每次最多结束的到期投票数量
+)
+
+var pollWorkerOnce sync.Once
+
+type pollOption struct {
+	ID   int    `json:"id"`
+	Text string `json:"text"`
+}
+
+type pollOptionResult struct {
+	ID     int      `json:"id"`
+	Count  int      `json:"count"`            // 票数
+	Voters []string `json:"voters,omitempty"` // 投票者uid（匿名投票不返回）
+}
+
+// 投票结果，通过消息扩展同步给客户端
+type pollResult struct {
+	PollNo      string              `json:"poll_no"`
+	Status      int                 `json:"status"` // 状态 0.进行中 1.已结束
+	Deadline    int64               `json:"deadline"`
+	ClosedBy    string              `json:"closed_by,omitempty"`
+	ClosedAt    int64               `json:"closed_at,omitempty"`
+	TotalVoters int                 `json:"total_voters"` // 投票人数
+	Options     []*pollOptionResult `json:"options"`
+}
+
+type pollResp struct {
+	PollNo      string        `json:"poll_no"`
+	MessageID   string        `json:"message_id"`
+	MessageSeq  uint32        `json:"message_seq"`
+	ChannelID   string        `json:"channel_id"`
+	ChannelType uint8         `json:"channel_type"`
+	Creator     string        `json:"creator"`
+	Question    string        `json:"question"`
+	Options     []*pollOption `json:"options"`
+	Multiple    int           `json:"multiple"`
+	Anonymous   int           `json:"anonymous"`
+	Deadline    int64         `json:"deadline"`
+	Result      *pollResult   `json:"result"`
+	MyOptionIDs []int         `json:"my_option_ids"` // 自己投的选项
+}
+
+func (p *pollModel) options() []*pollOption {
+	var options []*pollOption
+	_ = util.ReadJsonByByte([]byte(p.Options), &options)
+	return options
+}
+
+// 是否已结束（手动结束或已过截止时间）
+func (p *pollModel) closed() bool {
+	return p.Status == pollStatusClosed || (p.Deadline > 0 && time.Now().Unix() >= p.Deadline)
+}
+
+func newPollResult(poll *pollModel, votes []*pollVoteModel) *pollResult {
+	result := &pollResult{
+		PollNo:   poll.PollNo,
+		Status:   pollStatusOpen,
+		Deadline: poll.Deadline,
+		ClosedBy: poll.ClosedBy,
+		ClosedAt: poll.ClosedAt,
+		Options:  make([]*pollOptionResult, 0),
+	}
+	if poll.closed() {
+		result.Status = pollStatusClosed
+	}
+	optionMap := map[int]*pollOptionResult{}
+	for _, option := range poll.options() {
+		optionResult := &pollOptionResult{ID: option.ID}
+		optionMap[option.ID] = optionResult
+		result.Options = append(result.Options, optionResult)
+	}
+	voters := map[string]struct{}{}
+	for _, vote := range votes {
+		optionResult := optionMap[vote.OptionID]
+		if optionResult == nil {
+			continue
+		}
+		voters[vote.UID] = struct{}{}
+		optionResult.Count++
+		if poll.Anonymous == 0 && len(optionResult.Voters) < pollVotersMaxReturn {
+			optionResult.Voters = append(optionResult.Voters, vote.UID)
+		}
+	}
+	result.TotalVoters = len(voters)
+	return result
+}
+
+func newPollResp(poll *pollModel, votes []*pollVoteModel, loginUID string) *pollResp {
+	channelID := poll.ChannelID
+	if poll.ChannelType == common.ChannelTypePerson.Uint8() {
+		channelID = fakeChannelPeerUID(poll.ChannelID, loginUID)
+	}
+	myOptionIDs := make([]int, 0)
+	for _, vote := range votes {
+		if vote.UID == loginUID {
+			myOptionIDs = append(myOptionIDs, vote.OptionID)
+		}
+	}
+	return &pollResp{
+		PollNo:      poll.PollNo,
+		MessageID:   poll.MessageID,
+		MessageSeq:  poll.MessageSeq,
+		ChannelID:   channelID,
+		ChannelType: poll.ChannelType,
+		Creator:     poll.Creator,
+		Question:    poll.Question,
+		Options:     poll.options(),
+		Multiple:    poll.Multiple,
+		Anonymous:   poll.Anonymous,
+		Deadline:    poll.Deadline,
+		Result:      newPollResult(poll, votes),
+		MyOptionIDs: myOptionIDs,
+	}
+}
+
+// 发起投票
+func (m *Message) pollCreate(c *wkhttp.Context) {
+	loginUID := c.GetLoginUID()
+	var req struct {
+		ChannelID   string   `json:"channel_id"`
+		ChannelType uint8    `json:"channel_type"`
+		Question    string   `json:"question"`
+		Options     []string `json:"options"`
+		Multiple    int      `json:"multiple"`  // 是否多选 0.单选 1.多选
+		Anonymous   int      `json:"anonymous"` // 是否匿名 0.公开 1.匿名
+		Deadline    int64    `json:"deadline"`  // 截止时间 10位时间戳 0.不限制
+	}
+	if err := c.BindJSON(&req); err != nil {
+		m.Error(common.ErrData.Error(), zap.Error(err))
+		c.ResponseError(common.ErrData)
+		return
+	}
+	if strings.TrimSpace(req.ChannelID) == "" {
+		c.ResponseError(errors.New("频道ID不能为空！"))
+		return
+	}
+	if req.ChannelType != common.ChannelTypePerson.Uint8() && req.ChannelType != common.ChannelTypeGroup.Uint8() {
+		c.ResponseError(errors.New("不支持在此类型的频道发起投票！"))
+		return
+	}
+	req.Question = strings.TrimSpace(req.Question)
+	if req.Question == "" {
+		c.ResponseError(errors.New("投票问题不能为空！"))
+		return
+	}
+	if utf8.RuneCountInString(req.Question) > pollQuestionMaxLen {
+		c.ResponseError(errors.New("投票问题过长！"))
+		return
+	}
+	if len(req.Options) < pollMinOptions || len(req.Options) > pollMaxOptions {
+		c.ResponseError(errors.New("投票选项数量需要在2到20个之间！"))
+		return
+	}
+	options := make([]*pollOption, 0, len(req.Options))
+	for i, text := range req.Options {
+		text = strings.TrimSpace(text)
+		if text == "" {
+			c.ResponseError(errors.New("投票选项不能为空！"))
+			return
+		}
+		if utf8.RuneCountInString(text) > pollOptionMaxLen {
+			c.ResponseError(errors.New("投票选项过长！"))
+			return
+		}
+		options = append(options, &pollOption{ID: i + 1, Text: text})
+	}
+	if req.Deadline != 0 && req.Deadline <= time.Now().Unix() {
+		c.ResponseError(errors.New("截止时间必须晚于当前时间！"))
+		return
+	}
+	if err := checkSendPermission(m.userService, m.groupService, loginUID, false, req.ChannelID, req.ChannelType); err != nil {
+		c.ResponseError(err)
+		return
+	}
+	// 问题和选项命中拦截类违禁词时不能发起
+	if _, err := m.prohibitService.FilterPayload(map[string]interface{}{
+		"type":    common.Text,
+		"content": strings.Join(append([]string{req.Question}, req.Options...), "\n"),
+	}); err != nil {
+		c.ResponseError(err)
+		return
+	}
+
+	fakeChannelID := req.ChannelID
+	if req.ChannelType == common.ChannelTypePerson.Uint8() {
+		fakeChannelID = common.GetFakeChannelIDWith(loginUID, req.ChannelID)
+	}
+	poll := &pollModel{
+		PollNo:      util.GenerUUID(),
+		ChannelID:   fakeChannelID,
+		ChannelType: req.ChannelType,
+		Creator:     loginUID,
+		Question:    req.Question,
+		Options:     util.ToJson(options),
+		Multiple:    req.Multiple,
+		Anonymous:   req.Anonymous,
+		Deadline:    req.Deadline,
+		Status:      pollStatusOpen,
+	}
+	err := m.pollDB.insert(poll)
+	if err != nil {
+		m.Error("添加投票失败！", zap.Error(err))
+		c.ResponseError(errors.New("添加投票失败！"))
+		return
+	}
+	result, err := m.ctx.SendMessageWithResult(&config.MsgSendReq{
+		Header: config.MsgHeader{
+			RedDot: 1,
+		},
+		ChannelID:   req.ChannelID,
+		ChannelType: req.ChannelType,
+		FromUID:     loginUID,
+		Payload: []byte(util.ToJson(map[string]interface{}{
+			"type":      ContentTypePoll,
+			"content":   req.Question,
+			"poll_no":   poll.PollNo,
+			"question":  req.Question,
+			"options":   options,
+			"multiple":  req.Multiple,
+			"anonymous": req.Anonymous,
+			"deadline":  req.Deadline,
+		})),
+	})
+	if err != nil {
+		m.Error("发送投票消息失败！", zap.Error(err))
+		if err := m.pollDB.delete(poll.PollNo); err != nil {
+			m.Error("删除投票失败！", zap.Error(err))
+		}
+		c.ResponseError(errors.New("发送投票消息失败！"))
+		return
+	}
+	if result == nil {
+		// 拿不到消息ID的投票无法参与，按发送失败处理
+		m.Error("发送投票消息没有返回结果！", zap.String("pollNo", poll.PollNo))
+		if err := m.pollDB.delete(poll.PollNo); err != nil {
+			m.Error("删除投票失败！", zap.Error(err))
+		}
+		c.ResponseError(errors.New("发送投票消息失败！"))
+		return
+	}
+	poll.MessageID = strconv.FormatInt(result.MessageID, 10)
+	poll.MessageSeq = result.MessageSeq
+	err = m.pollDB.updateMessage(poll.PollNo, poll.MessageID, poll.MessageSeq)
+	if err != nil {
+		m.Error("修改投票消息失败！", zap.Error(err))
+		c.ResponseError(errors.New("修改投票消息失败！"))
+		return
+	}
+	c.Response(newPollResp(poll, nil, loginUID))
+}
+
+// 投票详情
+func (m *Message) pollGet(c *wkhttp.Context) {
+	loginUID := c.GetLoginUID()
+	poll, ok := m.getPollForMember(c, c.Param("poll_no"), loginUID)
+	if !ok {
+		return
+	}
+	votes, err := m.pollDB.queryVotes(poll.PollNo)
+	if err != nil {
+		m.Error("查询投票记录失败！", zap.Error(err))
+		c.ResponseError(errors.New("查询投票记录失败！"))
+		return
+	}
+	c.Response(newPollResp(poll, votes, loginUID))
+}
+
+// 投票（重复投票会覆盖之前的选择，选项为空表示撤销投票）
+func (m *Message) pollVote(c *wkhttp.Context) {
+	loginUID := c.GetLoginUID()
+	var req struct {
+		OptionIDs []int `json:"option_ids"`
+	}
+	if err := c.BindJSON(&req); err != nil {
+		m.Error(common.ErrData.Error(), zap.Error(err))
+		c.ResponseError(common.ErrData)
+		return
+	}
+	poll, ok := m.getPollForMember(c, c.Param("poll_no"), loginUID)
+	if !ok {
+		return
+	}
+	if poll.closed() {
+		c.ResponseError(errors.New("投票已结束！"))
+		return
+	}
+	if poll.Multiple == 0 && len(req.OptionIDs) > 1 {
+		c.ResponseError(errors.New("单选投票只能选择一个选项！"))
+		return
+	}
+	optionIDs := map[int]struct{}{}
+	for _, option := range poll.options() {
+		optionIDs[option.ID] = struct{}{}
+	}
+	selected := map[int]struct{}{}
+	for _, optionID := range req.OptionIDs {
+		if _, ok := optionIDs[optionID]; !ok {
+			c.ResponseError(errors.New("投票选项不存在！"))
+			return
+		}
+		if _, ok := selected[optionID]; ok {
+			c.ResponseError(errors.New("投票选项重复！"))
+			return
+		}
+		selected[optionID] = struct{}{}
+	}
+
+	tx, err := m.db.session.Begin()
+	if err != nil {
+		m.Error("开启事务失败！", zap.Error(err))
+		c.ResponseError(errors.New("开启事务失败！"))
+		return
+	}
+	defer func() {
+		if err := recover(); err != nil {
+			tx.Rollback()
+			panic(err)
+		}
+	}()
+	poll, err = m.pollDB.queryWithPollNoForUpdateTx(poll.PollNo, tx)
+	if err != nil {
+		tx.Rollback()
+		m.Error("查询投票失败！", zap.Error(err))
+		c.ResponseError(errors.New("查询投票失败！"))
+		return
+	}
+	if poll.closed() {
+		tx.Rollback()
+		c.ResponseError(errors.New("投票已结束！"))
+		return
+	}
+	err = m.pollDB.deleteVotesWithUIDTx(poll.PollNo, loginUID, tx)
+	if err != nil {
+		tx.Rollback()
+		m.Error("删除投票记录失败！", zap.Error(err))
+		c.ResponseError(errors.New("删除投票记录失败！"))
+		return
+	}
+	for _, optionID := range req.OptionIDs {
+		err = m.pollDB.insertVoteTx(&pollVoteModel{
+			PollNo:   poll.PollNo,
+			UID:      loginUID,
+			OptionID: optionID,
+		}, tx)
+		if err != nil {
+			tx.Rollback()
+			m.Error("添加投票记录失败！", zap.Error(err))
+			c.ResponseError(errors.New("添加投票记录失败！"))
+			return
+		}
+	}
+	votes, err := m.updatePollResultTx(poll, tx)
+	if err != nil {
+		tx.Rollback()
+		m.Error("更新投票结果失败！", zap.Error(err))
+		c.ResponseError(errors.New("更新投票结果失败！"))
+		return
+	}
+	if err := tx.Commit(); err != nil {
+		tx.Rollback()
+		c.ResponseErrorf("事务提交失败！", err)
+		return
+	}
+	m.sendPollSyncCMD(poll, loginUID)
+	c.Response(newPollResp(poll, votes, loginUID))
+}
+
+// 结束投票（发起者或拥有结束投票权限的群成员可以结束）
+func (m *Message) pollClose(c *wkhttp.Context) {
+	loginUID := c.GetLoginUID()
+	poll, ok := m.getPollForMember(c, c.Param("poll_no"), loginUID)
+	if !ok {
+		return
+	}
+	if poll.Creator != loginUID {
+		if poll.ChannelType != common.ChannelTypeGroup.Uint8() {
+			c.ResponseError(errors.New("只有发起者可以结束投票！"))
+			return
+		}
+		canClose, err := m.groupService.HasPermission(poll.ChannelID, loginUID, group.PermissionClosePoll)
+		if err != nil {
+			m.Error("查询群内权限失败！", zap.Error(err))
+			c.ResponseError(errors.New("查询群内权限失败！"))
+			return
+		}
+		if !canClose {
+			c.ResponseError(errors.New("没有结束投票的权限！"))
+			return
+		}
+	}
+	if poll.Status == pollStatusClosed {
+		c.ResponseOK()
+		return
+	}
+	closedPoll, err := m.closePoll(poll.PollNo, loginUID, time.Now().Unix())
+	if err != nil {
+		m.Error("结束投票失败！", zap.Error(err))
+		c.ResponseError(errors.New("结束投票失败！"))
+		return
+	}
+	if closedPoll != nil {
+		m.sendPollSyncCMD(closedPoll, loginUID)
+	}
+	c.ResponseOK()
+}
+
+// 结束投票并写入最终结果，投票已结束（并发结束或其他实例已处理）时返回nil
+func (m *Message) closePoll(pollNo string, closedBy string, closedAt int64) (*pollModel, error) {
+	tx, err := m.db.session.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if err := recover(); err != nil {
+			tx.Rollback()
+			panic(err)
+		}
+	}()
+	poll, err := m.pollDB.queryWithPollNoForUpdateTx(pollNo, tx)
+	if err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+	if poll == nil || poll.Status == pollStatusClosed {
+		tx.Rollback()
+		return nil, nil
+	}
+	poll.Status = pollStatusClosed
+	poll.ClosedBy = closedBy
+	poll.ClosedAt = closedAt
+	err = m.pollDB.updateClosedTx(poll.PollNo, poll.ClosedBy, poll.ClosedAt, tx)
+	if err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+	_, err = m.updatePollResultTx(poll, tx)
+	if err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+	if err = tx.Commit(); err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+	return poll, nil
+}
+
+// 开启到期投票的后台结束任务（多次调用只会启动一个）
+// 到期的投票在接口中已按结束处理，这里把最终结果写入消息扩展并通知客户端同步
+func (m *Message) startPollDeadlineWorker() {
+	pollWorkerOnce.Do(func() {
+		go func() {
+			ticker := time.NewTicker(pollScanInterval)
+			defer ticker.Stop()
+			for range ticker.C {
+				m.closeExpiredPolls()
+			}
+		}()
+	})
+}
+
+func (m *Message) closeExpiredPolls() {
+	polls, err := m.pollDB.queryExpired(time.Now().Unix(), pollScanLimit)
+	if err != nil {
+		m.Error("查询到期的投票失败！", zap.Error(err))
+		return
+	}
+	for _, poll := range polls {
+		closedPoll, err := m.closePoll(poll.PollNo, "", poll.Deadline)
+		if err != nil {
+			m.Error("结束到期的投票失败！", zap.Error(err), zap.String("pollNo", poll.PollNo))
+			continue
+		}
+		if closedPoll != nil {
+			m.sendPollSyncCMD(closedPoll, closedPoll.Creator)
+		}
+	}
+}
+
+// 查询投票并校验登录用户是否是频道成员，校验失败时已经返回了错误
+func (m *Message) getPollForMember(c *wkhttp.Context, pollNo string, loginUID string) (*pollModel, bool) {
+	poll, err := m.pollDB.queryWithPollNo(pollNo)
+	if err != nil {
+		m.Error("查询投票失败！", zap.Error(err))
+		c.ResponseError(errors.New("查询投票失败！"))
+		return nil, false
+	}
+	if poll == nil || poll.MessageID == "" {
+		c.ResponseError(errors.New("投票不存在！"))
+		return nil, false
+	}
+	if poll.ChannelType == common.ChannelTypePerson.Uint8() {
+		uids := strings.Split(poll.ChannelID, "@")
+		if len(uids) != 2 || (uids[0] != loginUID && uids[1] != loginUID) {
+			c.ResponseError(errors.New("投票不存在！"))
+			return nil, false
+		}
+		return poll, true
+	}
+	isMember, err := m.groupService.ExistMember(poll.ChannelID, loginUID)
+	if err != nil {
+		m.Error("查询群成员失败！", zap.Error(err))
+		c.ResponseError(errors.New("查询群成员失败！"))
+		return nil, false
+	}
+	if !isMember {
+		c.ResponseError(errors.New("不是群成员，不能参与投票！"))
+		return nil, false
+	}
+	return poll, true
+}
+
+// 重新计票并写入消息扩展
+func (m *Message) updatePollResultTx(poll *pollModel, tx *dbr.Tx) ([]*pollVoteModel, error) {
+	votes, err := m.pollDB.queryVotesTx(poll.PollNo, tx)
+	if err != nil {
+		return nil, err
+	}
+	err = m.messageExtraDB.insertOrUpdatePollResultTx(&messageExtraModel{
+		MessageID:   poll.MessageID,
+		MessageSeq:  poll.MessageSeq,
+		FromUID:     poll.Creator,
+		ChannelID:   poll.ChannelID,
+		ChannelType: poll.ChannelType,
+		PollResult:  dbr.NewNullString(util.ToJson(newPollResult(poll, votes))),
+		Version:     m.genMessageExtraSeq(poll.ChannelID),
+	}, tx)
+	return votes, err
+}
+
+// 通知频道内成员同步消息扩展
+func (m *Message) sendPollSyncCMD(poll *pollModel, loginUID string) {
+	channelID := poll.ChannelID
+	if poll.ChannelType == common.ChannelTypePerson.Uint8() {
+		channelID = fakeChannelPeerUID(poll.ChannelID, loginUID)
+	}
+	err := m.ctx.SendCMD(config.MsgCMDReq{
+		NoPersist:   true,
+		ChannelID:   channelID,
+		ChannelType: poll.ChannelType,
+		FromUID:     loginUID,
+		CMD:         common.CMDSyncMessageExtra,
+	})
+	if err != nil {
+		m.Error("发送同步消息扩展cmd失败！", zap.Error(err))
+	}
+}
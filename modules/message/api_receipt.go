@@ -0,0 +1,268 @@
+package message
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/TangSengDaoDao/TangSengDaoDaoServer/modules/group"
+	"github.com/TangSengDaoDao/TangSengDaoDaoServerLib/common"
+	"github.com/TangSengDaoDao/TangSengDaoDaoServerLib/config"
+	"github.com/TangSengDaoDao/TangSengDaoDaoServerLib/pkg/util"
+	"github.com/TangSengDaoDao/TangSengDaoDaoServerLib/pkg/wkhttp"
+	"go.uber.org/zap"
+)
+
+const (
+	nudgeMessageCooldownPrefix = "nudgeMessage:"    // 同一条消息催读冷却
+	nudgeDailyCountPrefix      = "nudgeDailyCount:" // 每人每天催读次数
+	nudgeMessageCooldown       = time.Minute * 10   // 同一条消息两次催读的最小间隔
+	nudgeDailyLimit            = 20                 // 每人每天最多催读次数
+	nudgePushMaxMember         = 200                // 催读推送的最大成员数，超过只发提醒项
+)
+
+type receiptMemberResp struct {
+	UID  string `json:"uid"`
+	Name string `json:"name"`
+}
+
+type receiptSummaryResp struct {
+	MessageID   string               `json:"message_id"`
+	ReadedCount int                  `json:"readed_count"` // 已读人数
+	UnreadCount int                  `json:"unread_count"` // 未读人数
+	Readed      []*receiptMemberResp `json:"readed"`       // 已读成员
+	Unread      []*receiptMemberResp `json:"unread"`       // 未读成员
+}
+
+// 群消息回执汇总（已读和未读成员）
+// 仍在群内的消息发送者和拥有查看消息详情权限的群成员可以查看
+func (m *Message) receiptSummary(c *wkhttp.Context) {
+	messageID := c.Param("message_id")
+	channelID := c.Query("channel_id")
+	if strings.TrimSpace(channelID) == "" {
+		c.ResponseError(errors.New("频道ID不能为空！"))
+		return
+	}
+	message, err := m.receiptMessage(c.GetLoginUID(), channelID, messageID, false)
+	if err != nil {
+		c.ResponseError(err)
+		return
+	}
+	readed, unread, err := m.receiptMembers(message)
+	if err != nil {
+		m.Error("查询消息回执失败！", zap.Error(err))
+		c.ResponseError(errors.New("查询消息回执失败！"))
+		return
+	}
+	c.Response(&receiptSummaryResp{
+		MessageID:   messageID,
+		ReadedCount: len(readed),
+		UnreadCount: len(unread),
+		Readed:      readed,
+		Unread:      unread,
+	})
+}
+
+// 催读未读成员（只有拥有查看消息详情权限的群成员可以催读）
+func (m *Message) receiptNudge(c *wkhttp.Context) {
+	var req struct {
+		ChannelID string `json:"channel_id"`
+		Push      int    `json:"push"` // 是否同时发送推送 0.否 1.是
+	}
+	if err := c.BindJSON(&req); err != nil {
+		c.ResponseError(errors.New("请求数据格式有误！"))
+		return
+	}
+	if strings.TrimSpace(req.ChannelID) == "" {
+		c.ResponseError(errors.New("频道ID不能为空！"))
+		return
+	}
+	loginUID := c.GetLoginUID()
+	messageID := c.Param("message_id")
+	message, err := m.receiptMessage(loginUID, req.ChannelID, messageID, true)
+	if err != nil {
+		c.ResponseError(err)
+		return
+	}
+
+	cooldownKey := fmt.Sprintf("%s%s", nudgeMessageCooldownPrefix, messageID)
+	cooldown, err := m.ctx.GetRedisConn().GetString(cooldownKey)
+	if err != nil {
+		m.Error("查询催读冷却失败！", zap.Error(err))
+		c.ResponseError(errors.New("查询催读冷却失败！"))
+		return
+	}
+	if cooldown != "" {
+		c.ResponseError(fmt.Errorf("%d分钟内只能催读一次！", int(nudgeMessageCooldown.Minutes())))
+		return
+	}
+	_, unread, err := m.receiptMembers(message)
+	if err != nil {
+		m.Error("查询消息回执失败！", zap.Error(err))
+		c.ResponseError(errors.New("查询消息回执失败！"))
+		return
+	}
+	if len(unread) == 0 {
+		c.ResponseError(errors.New("所有成员都已读！"))
+		return
+	}
+	dailyKey := fmt.Sprintf("%s%s", nudgeDailyCountPrefix, util.Toyyyy_MM_dd(time.Now()))
+	count, err := m.ctx.GetRedisConn().Hincrby(dailyKey, loginUID, 1)
+	if err != nil {
+		m.Error("增加催读次数失败！", zap.Error(err))
+		c.ResponseError(errors.New("增加催读次数失败！"))
+		return
+	}
+	if err = m.ctx.GetRedisConn().Expire(dailyKey, time.Hour*48); err != nil {
+		m.Warn("设置催读次数过期时间失败！", zap.Error(err))
+	}
+	if count > nudgeDailyLimit {
+		c.ResponseError(fmt.Errorf("每天最多催读%d次！", nudgeDailyLimit))
+		return
+	}
+	if err = m.ctx.GetRedisConn().SetAndExpire(cooldownKey, loginUID, nudgeMessageCooldown); err != nil {
+		m.Error("设置催读冷却失败！", zap.Error(err))
+		c.ResponseError(errors.New("设置催读冷却失败！"))
+		return
+	}
+
+	reminders := make([]*remindersModel, 0, len(unread))
+	for _, member := range unread {
+		reminders = append(reminders, &remindersModel{
+			ChannelID:    message.ChannelID,
+			ChannelType:  message.ChannelType,
+			ClientMsgNo:  message.ClientMsgNo,
+			Publisher:    loginUID,
+			MessageID:    messageID,
+			MessageSeq:   message.MessageSeq,
+			ReminderType: ReminderTypeReadNudge,
+			UID:          member.UID,
+			IsLocate:     1,
+			Version:      m.ctx.GenSeq(common.RemindersKey),
+			Text:         "[有消息待你查看]",
+		})
+	}
+	m.handleReminders(reminders)
+
+	pushed := 0
+	if req.Push == 1 && len(unread) <= nudgePushMaxMember {
+		// 由系统账号通过个人频道发一条提示消息，离线成员会走离线推送（催读者与成员不一定是好友，不能以催读者身份私发）
+		payload := []byte(util.ToJson(map[string]interface{}{
+			"content": "{0}提醒你查看群消息",
+			"type":    common.Tip,
+			"data": map[string]interface{}{
+				"data_type":    "readNudge",
+				"channel_id":   message.ChannelID,
+				"channel_type": message.ChannelType,
+				"message_id":   messageID,
+				"message_seq":  message.MessageSeq,
+			},
+			"extra": []config.UserBaseVo{
+				{
+					UID:  loginUID,
+					Name: c.GetLoginName(),
+				},
+			},
+		}))
+		for _, member := range unread {
+			err = m.ctx.SendMessage(&config.MsgSendReq{
+				FromUID:     m.ctx.GetConfig().Account.SystemUID,
+				ChannelID:   member.UID,
+				ChannelType: common.ChannelTypePerson.Uint8(),
+				Payload:     payload,
+				Header: config.MsgHeader{
+					RedDot: 1,
+				},
+			})
+			if err != nil {
+				m.Warn("发送催读消息失败！", zap.Error(err), zap.String("uid", member.UID))
+				continue
+			}
+			pushed++
+		}
+	}
+	c.Response(map[string]interface{}{
+		"nudged": len(unread),
+		"pushed": pushed,
+	})
+}
+
+// 查询回执对应的群消息并校验查看权限
+// onlyPermission为true时发送者也需要拥有查看消息详情权限
+func (m *Message) receiptMessage(loginUID, channelID, messageID string, onlyPermission bool) (*messageModel, error) {
+	if strings.TrimSpace(messageID) == "" {
+		return nil, errors.New("消息ID不能为空！")
+	}
+	message, err := m.db.queryMessageWithMessageID(channelID, common.ChannelTypeGroup.Uint8(), messageID)
+	if err != nil {
+		m.Error("查询消息失败！", zap.Error(err))
+		return nil, errors.New("查询消息失败！")
+	}
+	if message == nil || message.IsDeleted == 1 {
+		return nil, errors.New("消息不存在！")
+	}
+	isMember, err := m.groupService.ExistMember(channelID, loginUID)
+	if err != nil {
+		m.Error("查询是否是群成员失败！", zap.Error(err))
+		return nil, errors.New("查询是否是群成员失败！")
+	}
+	if !isMember {
+		return nil, errors.New("不是群成员！")
+	}
+	if message.FromUID == loginUID && !onlyPermission {
+		return message, nil
+	}
+	canView, err := m.groupService.HasPermission(channelID, loginUID, group.PermissionViewMessageDetail)
+	if err != nil {
+		m.Error("查询群内权限失败！", zap.Error(err))
+		return nil, errors.New("查询群内权限失败！")
+	}
+	if !canView {
+		return nil, errors.New("无权查看此消息的回执！")
+	}
+	return message, nil
+}
+
+// 查询群消息的已读和未读成员
+func (m *Message) receiptMembers(message *messageModel) ([]*receiptMemberResp, []*receiptMemberResp, error) {
+	readedUIDs, err := m.memberReadedDB.queryUIDsWithMessageID(strconv.FormatInt(message.MessageID, 10))
+	if err != nil {
+		return nil, nil, err
+	}
+	members, err := m.groupService.GetMembers(message.ChannelID)
+	if err != nil {
+		return nil, nil, err
+	}
+	readed, unread := splitReceiptMembers(message, members, readedUIDs)
+	return readed, unread, nil
+}
+
+// 按已读记录把群成员分为已读和未读，不包含发送者和消息发送后才入群的成员
+func splitReceiptMembers(message *messageModel, members []*group.MemberResp, readedUIDs []string) ([]*receiptMemberResp, []*receiptMemberResp) {
+	readedMap := make(map[string]bool, len(readedUIDs))
+	for _, uid := range readedUIDs {
+		readedMap[uid] = true
+	}
+	readed := make([]*receiptMemberResp, 0, len(readedUIDs))
+	unread := make([]*receiptMemberResp, 0)
+	for _, member := range members {
+		if member.IsDeleted == 1 || member.UID == message.FromUID {
+			continue
+		}
+		resp := &receiptMemberResp{
+			UID:  member.UID,
+			Name: member.Name,
+		}
+		if readedMap[member.UID] {
+			readed = append(readed, resp)
+			continue
+		}
+		if member.CreatedAt > message.Timestamp {
+			continue
+		}
+		unread = append(unread, resp)
+	}
+	return readed, unread
+}
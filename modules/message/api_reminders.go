@@ -7,6 +7,7 @@ import (
 	"net/http"
 	"time"
 
+	chservice "github.com/TangSengDaoDao/TangSengDaoDaoServer/modules/channel/service"
 	"github.com/TangSengDaoDao/TangSengDaoDaoServer/modules/group"
 	"github.com/TangSengDaoDao/TangSengDaoDaoServerLib/common"
 	"github.com/TangSengDaoDao/TangSengDaoDaoServerLib/config"
@@ -203,6 +204,58 @@ func (m *Message) getReminders(messages []*config.MessageResp) []*remindersModel
 				}
 			}
 		}
+		// 话题回复
+		if message.ChannelType == chservice.ChannelTypeThread {
+			reminders = append(reminders, m.getThreadReplyReminders(message)...)
+		}
+	}
+	return reminders
+}
+
+// 话题有新回复时提醒话题的参与者（提醒项挂在父频道的根消息上）
+func (m *Message) getThreadReplyReminders(message *config.MessageResp) []*remindersModel {
+	if message.FromUID == m.ctx.GetConfig().Account.SystemUID {
+		return nil
+	}
+	thread, err := m.channelService.GetThread(message.ChannelID)
+	if err != nil {
+		m.Error("查询话题失败！", zap.Error(err), zap.String("channelID", message.ChannelID))
+		return nil
+	}
+	if thread == nil {
+		return nil
+	}
+	participantUIDs, err := m.channelService.GetThreadParticipantUIDs(message.ChannelID)
+	if err != nil {
+		m.Error("查询话题参与者失败！", zap.Error(err), zap.String("channelID", message.ChannelID))
+		return nil
+	}
+	data := util.ToJson(map[string]interface{}{
+		"thread_channel_id":   thread.ChannelID,
+		"thread_channel_type": chservice.ChannelTypeThread,
+		"message_id":          fmt.Sprintf("%d", message.MessageID),
+		"message_seq":         message.MessageSeq,
+	})
+	reminders := make([]*remindersModel, 0, len(participantUIDs))
+	for _, uid := range participantUIDs {
+		if uid == message.FromUID {
+			continue
+		}
+		version := m.ctx.GenSeq(common.RemindersKey)
+		reminders = append(reminders, &remindersModel{
+			ChannelID:    thread.ParentChannelID,
+			ChannelType:  thread.ParentChannelType,
+			ClientMsgNo:  message.ClientMsgNo,
+			Publisher:    message.FromUID,
+			MessageID:    thread.RootMessageID,
+			MessageSeq:   thread.RootMessageSeq,
+			ReminderType: ReminderTypeThreadReply,
+			UID:          uid,
+			IsLocate:     1,
+			Version:      version,
+			Text:         "[话题有新回复]",
+			Data:         data,
+		})
 	}
 	return reminders
 }
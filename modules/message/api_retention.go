@@ -0,0 +1,1011 @@
+package message
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/TangSengDaoDao/TangSengDaoDaoServer/modules/group"
+	"github.com/TangSengDaoDao/TangSengDaoDaoServerLib/common"
+	"github.com/TangSengDaoDao/TangSengDaoDaoServerLib/config"
+	"github.com/TangSengDaoDao/TangSengDaoDaoServerLib/pkg/log"
+	"github.com/TangSengDaoDao/TangSengDaoDaoServerLib/pkg/util"
+	"github.com/TangSengDaoDao/TangSengDaoDaoServerLib/pkg/wkhttp"
+	"go.uber.org/zap"
+)
+
+const (
+	retentionScopeChannelType = 1 // 按频道类型
+	retentionScopeCategory    = 2 // 按群分类
+	retentionScopeChannel     = 3 // 指定频道
+)
+
+const (
+	legalHoldTargetChannel = 1 // 保全频道
+	legalHoldTargetUser    = 2 // 保全用户（该用户发送的消息、单聊和当前所在的群）
+)
+
+const (
+	legalHoldStatusReleased = 0 // 已解除
+	legalHoldStatusActive   = 1 // 保全中
+)
+
+const (
+	purgeStatusWait    = 0 // 等待执行
+	purgeStatusRunning = 1 // 执行中
+	purgeStatusDone    = 2 // 已完成
+	purgeStatusFail    = 3 // 失败
+)
+
+const (
+	purgeTriggerAuto   = 1 // 定时清理
+	purgeTriggerManual = 2 // 手动清理
+)
+
+const (
+	retentionMinSecond     = 24 * 60 * 60     // 保留时长最少一天，防止误配置清空消息
+	purgeBatchSize         = 500              // 每批删除的消息数量
+	purgeGroupBatchSize    = 500              // 每批查询群分类的群数量
+	purgeScanInterval      = time.Second * 10 // 扫描待执行清理的间隔
+	purgeScanLimit         = 5                // 每次扫描的最大清理数量
+	purgeRunningStaleAfter = time.Minute * 10 // 执行中状态超过此时间未更新进度视为中断
+)
+
+var purgeWorkerOnce sync.Once
+
+type retentionPolicyResp struct {
+	ID              int64  `json:"id"`
+	Scope           int    `json:"scope"` // 策略范围 1.频道类型 2.群分类 3.指定频道
+	ChannelType     uint8  `json:"channel_type"`
+	Category        string `json:"category"`
+	ChannelID       string `json:"channel_id"`
+	RetentionSecond int64  `json:"retention_second"` // 消息保留时长 单位秒
+	Status          int    `json:"status"`           // 状态 0.停用 1.启用
+	Remark          string `json:"remark"`
+	CreatedBy       string `json:"created_by"`
+	CreatedAt       string `json:"created_at"`
+	UpdatedAt       string `json:"updated_at"`
+}
+
+func newRetentionPolicyResp(m *retentionPolicyModel) *retentionPolicyResp {
+	return &retentionPolicyResp{
+		ID:              m.Id,
+		Scope:           m.Scope,
+		ChannelType:     m.ChannelType,
+		Category:        m.Category,
+		ChannelID:       m.ChannelID,
+		RetentionSecond: m.RetentionSecond,
+		Status:          m.Status,
+		Remark:          m.Remark,
+		CreatedBy:       m.CreatedBy,
+		CreatedAt:       m.CreatedAt.String(),
+		UpdatedAt:       m.UpdatedAt.String(),
+	}
+}
+
+type legalHoldResp struct {
+	ID          int64  `json:"id"`
+	TargetType  int    `json:"target_type"` // 保全对象 1.频道 2.用户
+	ChannelID   string `json:"channel_id"`
+	ChannelType uint8  `json:"channel_type"`
+	UID         string `json:"uid"`
+	Reason      string `json:"reason"`
+	Status      int    `json:"status"` // 状态 0.已解除 1.保全中
+	CreatedBy   string `json:"created_by"`
+	ReleasedBy  string `json:"released_by"`
+	ReleasedAt  int64  `json:"released_at"`
+	CreatedAt   string `json:"created_at"`
+}
+
+func newLegalHoldResp(m *legalHoldModel) *legalHoldResp {
+	return &legalHoldResp{
+		ID:          m.Id,
+		TargetType:  m.TargetType,
+		ChannelID:   m.ChannelID,
+		ChannelType: m.ChannelType,
+		UID:         m.UID,
+		Reason:      m.Reason,
+		Status:      m.Status,
+		CreatedBy:   m.CreatedBy,
+		ReleasedBy:  m.ReleasedBy,
+		ReleasedAt:  m.ReleasedAt,
+		CreatedAt:   m.CreatedAt.String(),
+	}
+}
+
+type purgeReportResp struct {
+	ReportNo     string `json:"report_no"`
+	TriggerType  int    `json:"trigger_type"` // 触发方式 1.定时 2.手动
+	Operator     string `json:"operator"`
+	DryRun       int    `json:"dry_run"` // 是否只统计不删除
+	Status       int    `json:"status"`  // 状态 0.等待执行 1.执行中 2.已完成 3.失败
+	PolicyCount  int    `json:"policy_count"`
+	HoldCount    int    `json:"hold_count"`
+	ChannelCount int    `json:"channel_count"`
+	MessageCount int64  `json:"message_count"`
+	HeldCount    int64  `json:"held_count"`
+	ExtraCount   int64  `json:"extra_count"`
+	FailReason   string `json:"fail_reason,omitempty"`
+	StartedAt    int64  `json:"started_at"`
+	FinishedAt   int64  `json:"finished_at"`
+	CreatedAt    string `json:"created_at"`
+}
+
+func newPurgeReportResp(m *purgeReportModel) *purgeReportResp {
+	return &purgeReportResp{
+		ReportNo:     m.ReportNo,
+		TriggerType:  m.TriggerType,
+		Operator:     m.Operator,
+		DryRun:       m.DryRun,
+		Status:       m.Status,
+		PolicyCount:  m.PolicyCount,
+		HoldCount:    m.HoldCount,
+		ChannelCount: m.ChannelCount,
+		MessageCount: m.MessageCount,
+		HeldCount:    m.HeldCount,
+		ExtraCount:   m.ExtraCount,
+		FailReason:   m.FailReason,
+		StartedAt:    m.StartedAt,
+		FinishedAt:   m.FinishedAt,
+		CreatedAt:    m.CreatedAt.String(),
+	}
+}
+
+type purgeReportItemResp struct {
+	PolicyID      int64    `json:"policy_id"`
+	ChannelID     string   `json:"channel_id"`
+	ChannelType   uint8    `json:"channel_type"`
+	Cutoff        int64    `json:"cutoff"` // 清理此时间之前的消息
+	Held          int      `json:"held"`   // 频道是否被保全
+	HeldUIDs      []string `json:"held_uids,omitempty"`
+	MessageCount  int64    `json:"message_count"`
+	HeldCount     int64    `json:"held_count"`
+	ExtraCount    int64    `json:"extra_count"`
+	MinMessageSeq uint32   `json:"min_message_seq"`
+	MaxMessageSeq uint32   `json:"max_message_seq"`
+	MinTimestamp  int64    `json:"min_timestamp"`
+	MaxTimestamp  int64    `json:"max_timestamp"`
+}
+
+func newPurgeReportItemResp(m *purgeReportItemModel) *purgeReportItemResp {
+	var heldUIDs []string
+	if m.HeldUids != "" {
+		_ = util.ReadJsonByByte([]byte(m.HeldUids), &heldUIDs)
+	}
+	return &purgeReportItemResp{
+		PolicyID:      m.PolicyID,
+		ChannelID:     m.ChannelID,
+		ChannelType:   m.ChannelType,
+		Cutoff:        m.Cutoff,
+		Held:          m.Held,
+		HeldUIDs:      heldUIDs,
+		MessageCount:  m.MessageCount,
+		HeldCount:     m.HeldCount,
+		ExtraCount:    m.ExtraCount,
+		MinMessageSeq: m.MinMessageSeq,
+		MaxMessageSeq: m.MaxMessageSeq,
+		MinTimestamp:  m.MinTimestamp,
+		MaxTimestamp:  m.MaxTimestamp,
+	}
+}
+
+// 保留策略列表
+func (m *Manager) retentionList(c *wkhttp.Context) {
+	err := c.CheckLoginRole()
+	if err != nil {
+		c.ResponseError(err)
+		return
+	}
+	models, err := m.purger.retentionDB.queryPolicies()
+	if err != nil {
+		m.Error("查询保留策略失败！", zap.Error(err))
+		c.ResponseError(errors.New("查询保留策略失败！"))
+		return
+	}
+	list := make([]*retentionPolicyResp, 0, len(models))
+	for _, model := range models {
+		list = append(list, newRetentionPolicyResp(model))
+	}
+	c.Response(list)
+}
+
+// 添加保留策略
+func (m *Manager) retentionAdd(c *wkhttp.Context) {
+	err := c.CheckLoginRoleIsSuperAdmin()
+	if err != nil {
+		c.ResponseError(err)
+		return
+	}
+	var req struct {
+		Scope           int    `json:"scope"`
+		ChannelType     uint8  `json:"channel_type"`
+		Category        string `json:"category"`
+		ChannelID       string `json:"channel_id"`
+		UID             string `json:"uid"` // 指定个人频道时为会话中的另一方uid
+		RetentionSecond int64  `json:"retention_second"`
+		Remark          string `json:"remark"`
+	}
+	if err := c.BindJSON(&req); err != nil {
+		m.Error(common.ErrData.Error(), zap.Error(err))
+		c.ResponseError(common.ErrData)
+		return
+	}
+	if req.RetentionSecond < retentionMinSecond {
+		c.ResponseError(errors.New("消息保留时长不能少于1天！"))
+		return
+	}
+	model := &retentionPolicyModel{
+		Scope:           req.Scope,
+		RetentionSecond: req.RetentionSecond,
+		Status:          1,
+		Remark:          req.Remark,
+		CreatedBy:       c.GetLoginUID(),
+	}
+	switch req.Scope {
+	case retentionScopeChannelType:
+		if req.ChannelType == 0 {
+			c.ResponseError(errors.New("频道类型不能为空！"))
+			return
+		}
+		model.ChannelType = req.ChannelType
+	case retentionScopeCategory:
+		if strings.TrimSpace(req.Category) == "" {
+			c.ResponseError(errors.New("群分类不能为空！"))
+			return
+		}
+		model.ChannelType = common.ChannelTypeGroup.Uint8()
+		model.Category = req.Category
+	case retentionScopeChannel:
+		channelID, err := managerChannelID(req.ChannelID, req.ChannelType, req.UID)
+		if err != nil {
+			c.ResponseError(err)
+			return
+		}
+		model.ChannelType = req.ChannelType
+		model.ChannelID = channelID
+	default:
+		c.ResponseError(errors.New("策略范围不正确！"))
+		return
+	}
+	existModel, err := m.purger.retentionDB.queryPolicyWithTarget(model.Scope, model.ChannelType, model.Category, model.ChannelID)
+	if err != nil {
+		m.Error("查询保留策略失败！", zap.Error(err))
+		c.ResponseError(errors.New("查询保留策略失败！"))
+		return
+	}
+	if existModel != nil {
+		c.ResponseError(errors.New("该范围已存在保留策略！"))
+		return
+	}
+	err = m.purger.retentionDB.insertPolicy(model)
+	if err != nil {
+		m.Error("添加保留策略失败！", zap.Error(err))
+		c.ResponseError(errors.New("添加保留策略失败！"))
+		return
+	}
+	c.ResponseOK()
+}
+
+// 修改保留策略（保留时长、启用状态、备注）
+func (m *Manager) retentionUpdate(c *wkhttp.Context) {
+	err := c.CheckLoginRoleIsSuperAdmin()
+	if err != nil {
+		c.ResponseError(err)
+		return
+	}
+	id, _ := strconv.ParseInt(c.Param("id"), 10, 64)
+	var req struct {
+		RetentionSecond int64  `json:"retention_second"`
+		Status          int    `json:"status"`
+		Remark          string `json:"remark"`
+	}
+	if err := c.BindJSON(&req); err != nil {
+		m.Error(common.ErrData.Error(), zap.Error(err))
+		c.ResponseError(common.ErrData)
+		return
+	}
+	if req.RetentionSecond < retentionMinSecond {
+		c.ResponseError(errors.New("消息保留时长不能少于1天！"))
+		return
+	}
+	if req.Status != 0 && req.Status != 1 {
+		c.ResponseError(errors.New("状态不正确！"))
+		return
+	}
+	model, err := m.purger.retentionDB.queryPolicyWithID(id)
+	if err != nil {
+		m.Error("查询保留策略失败！", zap.Error(err))
+		c.ResponseError(errors.New("查询保留策略失败！"))
+		return
+	}
+	if model == nil {
+		c.ResponseError(errors.New("保留策略不存在！"))
+		return
+	}
+	model.RetentionSecond = req.RetentionSecond
+	model.Status = req.Status
+	model.Remark = req.Remark
+	err = m.purger.retentionDB.updatePolicy(model)
+	if err != nil {
+		m.Error("修改保留策略失败！", zap.Error(err))
+		c.ResponseError(errors.New("修改保留策略失败！"))
+		return
+	}
+	c.ResponseOK()
+}
+
+// 删除保留策略
+func (m *Manager) retentionDelete(c *wkhttp.Context) {
+	err := c.CheckLoginRoleIsSuperAdmin()
+	if err != nil {
+		c.ResponseError(err)
+		return
+	}
+	id, _ := strconv.ParseInt(c.Param("id"), 10, 64)
+	err = m.purger.retentionDB.deletePolicy(id)
+	if err != nil {
+		m.Error("删除保留策略失败！", zap.Error(err))
+		c.ResponseError(errors.New("删除保留策略失败！"))
+		return
+	}
+	c.ResponseOK()
+}
+
+// 法律保全列表
+func (m *Manager) legalHolds(c *wkhttp.Context) {
+	err := c.CheckLoginRole()
+	if err != nil {
+		c.ResponseError(err)
+		return
+	}
+	pageIndex, pageSize := c.GetPage()
+	status := -1
+	if c.Query("status") != "" {
+		status, _ = strconv.Atoi(c.Query("status"))
+	}
+	models, err := m.purger.retentionDB.queryHoldsWithPage(status, uint64(pageSize), uint64(pageIndex))
+	if err != nil {
+		m.Error("查询法律保全失败！", zap.Error(err))
+		c.ResponseError(errors.New("查询法律保全失败！"))
+		return
+	}
+	count, err := m.purger.retentionDB.queryHoldCount(status)
+	if err != nil {
+		m.Error("查询法律保全数量失败！", zap.Error(err))
+		c.ResponseError(errors.New("查询法律保全数量失败！"))
+		return
+	}
+	list := make([]*legalHoldResp, 0, len(models))
+	for _, model := range models {
+		list = append(list, newLegalHoldResp(model))
+	}
+	c.Response(map[string]interface{}{
+		"list":  list,
+		"count": count,
+	})
+}
+
+// 添加法律保全
+func (m *Manager) legalHoldAdd(c *wkhttp.Context) {
+	err := c.CheckLoginRoleIsSuperAdmin()
+	if err != nil {
+		c.ResponseError(err)
+		return
+	}
+	var req struct {
+		TargetType  int    `json:"target_type"`
+		ChannelID   string `json:"channel_id"`
+		ChannelType uint8  `json:"channel_type"`
+		UID         string `json:"uid"` // 保全用户时为用户uid，保全个人频道时为会话中的另一方uid
+		Reason      string `json:"reason"`
+	}
+	if err := c.BindJSON(&req); err != nil {
+		m.Error(common.ErrData.Error(), zap.Error(err))
+		c.ResponseError(common.ErrData)
+		return
+	}
+	if strings.TrimSpace(req.Reason) == "" {
+		c.ResponseError(errors.New("保全原因不能为空！"))
+		return
+	}
+	model := &legalHoldModel{
+		TargetType: req.TargetType,
+		Reason:     req.Reason,
+		Status:     legalHoldStatusActive,
+		CreatedBy:  c.GetLoginUID(),
+	}
+	switch req.TargetType {
+	case legalHoldTargetChannel:
+		channelID, err := managerChannelID(req.ChannelID, req.ChannelType, req.UID)
+		if err != nil {
+			c.ResponseError(err)
+			return
+		}
+		model.ChannelID = channelID
+		model.ChannelType = req.ChannelType
+	case legalHoldTargetUser:
+		if strings.TrimSpace(req.UID) == "" {
+			c.ResponseError(errors.New("uid不能为空！"))
+			return
+		}
+		user, err := m.userService.GetUser(req.UID)
+		if err != nil {
+			m.Error("查询用户信息失败！", zap.Error(err))
+			c.ResponseError(errors.New("查询用户信息失败！"))
+			return
+		}
+		if user == nil {
+			c.ResponseError(errors.New("用户不存在！"))
+			return
+		}
+		model.UID = req.UID
+	default:
+		c.ResponseError(errors.New("保全对象不正确！"))
+		return
+	}
+	err = m.purger.retentionDB.insertHold(model)
+	if err != nil {
+		m.Error("添加法律保全失败！", zap.Error(err))
+		c.ResponseError(errors.New("添加法律保全失败！"))
+		return
+	}
+	c.ResponseOK()
+}
+
+// 解除法律保全（保留记录用于审计）
+func (m *Manager) legalHoldRelease(c *wkhttp.Context) {
+	err := c.CheckLoginRoleIsSuperAdmin()
+	if err != nil {
+		c.ResponseError(err)
+		return
+	}
+	id, _ := strconv.ParseInt(c.Param("id"), 10, 64)
+	rows, err := m.purger.retentionDB.updateHoldReleased(id, c.GetLoginUID())
+	if err != nil {
+		m.Error("解除法律保全失败！", zap.Error(err))
+		c.ResponseError(errors.New("解除法律保全失败！"))
+		return
+	}
+	if rows == 0 {
+		c.ResponseError(errors.New("法律保全不存在或已解除！"))
+		return
+	}
+	c.ResponseOK()
+}
+
+// 手动执行消息清理
+func (m *Manager) purgeAdd(c *wkhttp.Context) {
+	err := c.CheckLoginRoleIsSuperAdmin()
+	if err != nil {
+		c.ResponseError(err)
+		return
+	}
+	var req struct {
+		DryRun int `json:"dry_run"` // 1.只统计不删除
+	}
+	if err := c.BindJSON(&req); err != nil {
+		m.Error(common.ErrData.Error(), zap.Error(err))
+		c.ResponseError(common.ErrData)
+		return
+	}
+	model := &purgeReportModel{
+		ReportNo:    util.GenerUUID(),
+		TriggerType: purgeTriggerManual,
+		Operator:    c.GetLoginUID(),
+		Status:      purgeStatusWait,
+	}
+	if req.DryRun == 1 {
+		model.DryRun = 1
+	}
+	err = m.purger.retentionDB.insertReport(model)
+	if err != nil {
+		m.Error("添加清理任务失败！", zap.Error(err))
+		c.ResponseError(errors.New("添加清理任务失败！"))
+		return
+	}
+	model, err = m.purger.retentionDB.queryReportWithReportNo(model.ReportNo)
+	if err != nil || model == nil {
+		m.Error("查询清理报告失败！", zap.Error(err))
+		c.ResponseError(errors.New("查询清理报告失败！"))
+		return
+	}
+	c.Response(newPurgeReportResp(model))
+}
+
+// 清理报告列表
+func (m *Manager) purgeReports(c *wkhttp.Context) {
+	err := c.CheckLoginRole()
+	if err != nil {
+		c.ResponseError(err)
+		return
+	}
+	pageIndex, pageSize := c.GetPage()
+	models, err := m.purger.retentionDB.queryReportsWithPage(uint64(pageSize), uint64(pageIndex))
+	if err != nil {
+		m.Error("查询清理报告失败！", zap.Error(err))
+		c.ResponseError(errors.New("查询清理报告失败！"))
+		return
+	}
+	count, err := m.purger.retentionDB.queryReportCount()
+	if err != nil {
+		m.Error("查询清理报告数量失败！", zap.Error(err))
+		c.ResponseError(errors.New("查询清理报告数量失败！"))
+		return
+	}
+	list := make([]*purgeReportResp, 0, len(models))
+	for _, model := range models {
+		list = append(list, newPurgeReportResp(model))
+	}
+	c.Response(map[string]interface{}{
+		"list":  list,
+		"count": count,
+	})
+}
+
+// 清理报告详情（明细分页）
+func (m *Manager) purgeReport(c *wkhttp.Context) {
+	err := c.CheckLoginRole()
+	if err != nil {
+		c.ResponseError(err)
+		return
+	}
+	reportNo := c.Param("report_no")
+	model, err := m.purger.retentionDB.queryReportWithReportNo(reportNo)
+	if err != nil {
+		m.Error("查询清理报告失败！", zap.Error(err))
+		c.ResponseError(errors.New("查询清理报告失败！"))
+		return
+	}
+	if model == nil {
+		c.ResponseError(errors.New("清理报告不存在！"))
+		return
+	}
+	pageIndex, pageSize := c.GetPage()
+	items, err := m.purger.retentionDB.queryReportItemsWithPage(reportNo, uint64(pageSize), uint64(pageIndex))
+	if err != nil {
+		m.Error("查询清理报告明细失败！", zap.Error(err))
+		c.ResponseError(errors.New("查询清理报告明细失败！"))
+		return
+	}
+	itemCount, err := m.purger.retentionDB.queryReportItemCount(reportNo)
+	if err != nil {
+		m.Error("查询清理报告明细数量失败！", zap.Error(err))
+		c.ResponseError(errors.New("查询清理报告明细数量失败！"))
+		return
+	}
+	itemResps := make([]*purgeReportItemResp, 0, len(items))
+	for _, item := range items {
+		itemResps = append(itemResps, newPurgeReportItemResp(item))
+	}
+	c.Response(map[string]interface{}{
+		"report":     newPurgeReportResp(model),
+		"items":      itemResps,
+		"item_count": itemCount,
+	})
+}
+
+// 后台管理指定的频道ID（个人频道转换为用户uid组合后的ID）
+func managerChannelID(channelID string, channelType uint8, uid string) (string, error) {
+	if strings.TrimSpace(channelID) == "" {
+		return "", errors.New("频道ID不能为空！")
+	}
+	if channelType == 0 {
+		return "", errors.New("频道类型不能为空！")
+	}
+	if channelType == common.ChannelTypePerson.Uint8() {
+		if strings.TrimSpace(uid) == "" {
+			return "", errors.New("uid不能为空！")
+		}
+		return common.GetFakeChannelIDWith(uid, channelID), nil
+	}
+	return channelID, nil
+}
+
+// retentionResolver 计算频道生效的保留策略和保全状态
+type retentionResolver struct {
+	typePolicies     map[uint8]*retentionPolicyModel
+	categoryPolicies map[string]*retentionPolicyModel
+	channelPolicies  map[string]*retentionPolicyModel
+	minRetention     int64
+	heldChannels     map[string]bool
+	heldUIDMap       map[string]bool
+	heldUIDs         []string
+}
+
+func newRetentionResolver(policies []*retentionPolicyModel, holds []*legalHoldModel) *retentionResolver {
+	r := &retentionResolver{
+		typePolicies:     map[uint8]*retentionPolicyModel{},
+		categoryPolicies: map[string]*retentionPolicyModel{},
+		channelPolicies:  map[string]*retentionPolicyModel{},
+		heldChannels:     map[string]bool{},
+		heldUIDMap:       map[string]bool{},
+	}
+	for _, policy := range policies {
+		switch policy.Scope {
+		case retentionScopeChannelType:
+			r.typePolicies[policy.ChannelType] = policy
+		case retentionScopeCategory:
+			r.categoryPolicies[policy.Category] = policy
+		case retentionScopeChannel:
+			r.channelPolicies[retentionChannelKey(policy.ChannelID, policy.ChannelType)] = policy
+		default:
+			continue
+		}
+		if r.minRetention == 0 || policy.RetentionSecond < r.minRetention {
+			r.minRetention = policy.RetentionSecond
+		}
+	}
+	for _, hold := range holds {
+		switch hold.TargetType {
+		case legalHoldTargetChannel:
+			r.heldChannels[retentionChannelKey(hold.ChannelID, hold.ChannelType)] = true
+		case legalHoldTargetUser:
+			if !r.heldUIDMap[hold.UID] {
+				r.heldUIDMap[hold.UID] = true
+				r.heldUIDs = append(r.heldUIDs, hold.UID)
+			}
+		}
+	}
+	return r
+}
+
+// 优先级：指定频道 > 群分类 > 频道类型
+func (r *retentionResolver) policy(channelID string, channelType uint8, category string) *retentionPolicyModel {
+	if policy := r.channelPolicies[retentionChannelKey(channelID, channelType)]; policy != nil {
+		return policy
+	}
+	if channelType == common.ChannelTypeGroup.Uint8() && category != "" {
+		if policy := r.categoryPolicies[category]; policy != nil {
+			return policy
+		}
+	}
+	return r.typePolicies[channelType]
+}
+
+func (r *retentionResolver) hasCategoryPolicy() bool {
+	return len(r.categoryPolicies) > 0
+}
+
+// 频道被保全，个人频道中任意一方被保全也视为频道被保全
+func (r *retentionResolver) channelHeld(channelID string, channelType uint8) bool {
+	if r.heldChannels[retentionChannelKey(channelID, channelType)] {
+		return true
+	}
+	if channelType == common.ChannelTypePerson.Uint8() {
+		for _, uid := range strings.Split(channelID, "@") {
+			if r.heldUIDMap[uid] {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// 保全一批群频道
+func (r *retentionResolver) holdGroups(groupNos []string) {
+	for _, groupNo := range groupNos {
+		r.heldChannels[retentionChannelKey(groupNo, common.ChannelTypeGroup.Uint8())] = true
+	}
+}
+
+func retentionChannelKey(channelID string, channelType uint8) string {
+	return fmt.Sprintf("%d-%s", channelType, channelID)
+}
+
+// purger 按保留策略清理过期消息
+// 定时清理每小时生成一份清理报告（多实例通过报告编号去重），手动清理由管理员发起，均由后台任务执行
+type purger struct {
+	ctx *config.Context
+	log.Log
+	retentionDB  *retentionDB
+	groupService group.IService
+}
+
+func newPurger(ctx *config.Context) *purger {
+	return &purger{
+		ctx:          ctx,
+		Log:          log.NewTLog("MessagePurger"),
+		retentionDB:  newRetentionDB(ctx),
+		groupService: group.NewService(ctx),
+	}
+}
+
+// 开启后台清理任务（多次调用只会启动一个）
+func (p *purger) start() {
+	purgeWorkerOnce.Do(func() {
+		go func() {
+			ticker := time.NewTicker(purgeScanInterval)
+			defer ticker.Stop()
+			for range ticker.C {
+				p.failStaleReports()
+				p.addAutoReport()
+				p.runWaitingReports()
+			}
+		}()
+	})
+}
+
+// 中断在执行中状态的清理标记为失败，已删除的数据已记录在明细中，剩余的由下一次清理处理
+func (p *purger) failStaleReports() {
+	models, err := p.retentionDB.queryStaleRunningReports(time.Now().Add(-purgeRunningStaleAfter))
+	if err != nil {
+		p.Error("查询执行中的清理失败！", zap.Error(err))
+		return
+	}
+	for _, model := range models {
+		if err = p.retentionDB.updateReportFail(model.ReportNo, "清理中断"); err != nil {
+			p.Error("修改清理为失败状态失败！", zap.Error(err), zap.String("reportNo", model.ReportNo))
+		}
+	}
+}
+
+// 每小时生成一次定时清理
+func (p *purger) addAutoReport() {
+	reportNo := fmt.Sprintf("auto-%s", time.Now().Format("2006010215"))
+	model, err := p.retentionDB.queryReportWithReportNo(reportNo)
+	if err != nil {
+		p.Error("查询清理报告失败！", zap.Error(err))
+		return
+	}
+	if model != nil {
+		return
+	}
+	policies, err := p.retentionDB.queryEnabledPolicies()
+	if err != nil {
+		p.Error("查询保留策略失败！", zap.Error(err))
+		return
+	}
+	if len(policies) == 0 {
+		return
+	}
+	err = p.retentionDB.insertReport(&purgeReportModel{
+		ReportNo:    reportNo,
+		TriggerType: purgeTriggerAuto,
+		Status:      purgeStatusWait,
+	})
+	if err != nil {
+		// 多实例同时生成时报告编号唯一索引冲突
+		p.Warn("添加定时清理失败！", zap.Error(err), zap.String("reportNo", reportNo))
+	}
+}
+
+func (p *purger) runWaitingReports() {
+	models, err := p.retentionDB.queryWaitingReports(purgeScanLimit)
+	if err != nil {
+		p.Error("查询待执行的清理失败！", zap.Error(err))
+		return
+	}
+	for _, model := range models {
+		// 抢占任务，防止多实例重复清理
+		rows, err := p.retentionDB.updateReportStatus(model.ReportNo, purgeStatusWait, purgeStatusRunning)
+		if err != nil {
+			p.Error("修改清理状态失败！", zap.Error(err), zap.String("reportNo", model.ReportNo))
+			continue
+		}
+		if rows == 0 {
+			continue
+		}
+		p.run(model)
+	}
+}
+
+func (p *purger) run(report *purgeReportModel) {
+	err := p.purge(report)
+	if err != nil {
+		p.Error("清理过期消息失败！", zap.Error(err), zap.String("reportNo", report.ReportNo))
+		if err = p.retentionDB.updateReportFail(report.ReportNo, "清理失败"); err != nil {
+			p.Error("修改清理为失败状态失败！", zap.Error(err))
+		}
+		return
+	}
+	if err = p.retentionDB.updateReportDone(report.ReportNo); err != nil {
+		p.Error("修改清理为完成状态失败！", zap.Error(err))
+	}
+}
+
+func (p *purger) purge(report *purgeReportModel) error {
+	policies, err := p.retentionDB.queryEnabledPolicies()
+	if err != nil {
+		return err
+	}
+	holds, err := p.retentionDB.queryActiveHolds()
+	if err != nil {
+		return err
+	}
+	report.PolicyCount = len(policies)
+	report.HoldCount = len(holds)
+	if err = p.retentionDB.updateReportProgress(report); err != nil {
+		return err
+	}
+	resolver := newRetentionResolver(policies, holds)
+	if resolver.minRetention <= 0 {
+		return nil
+	}
+	if err = p.holdMemberGroups(resolver); err != nil {
+		return err
+	}
+	now := time.Now().Unix()
+	for _, table := range p.retentionDB.getMessageTables() {
+		channels, err := p.retentionDB.queryExpiredChannels(table, now-resolver.minRetention)
+		if err != nil {
+			return err
+		}
+		if len(channels) == 0 {
+			continue
+		}
+		categories, err := p.groupCategories(resolver, channels)
+		if err != nil {
+			return err
+		}
+		for _, channel := range channels {
+			policy := resolver.policy(channel.ChannelID, channel.ChannelType, categories[channel.ChannelID])
+			if policy == nil {
+				continue
+			}
+			err = p.purgeChannel(report, resolver, table, channel, policy, now-policy.RetentionSecond)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// 被保全用户收到的群消息：保全用户当前所在的群整体保全（已退出的群只保留该用户发送的消息）
+func (p *purger) holdMemberGroups(resolver *retentionResolver) error {
+	for _, uid := range resolver.heldUIDs {
+		groups, err := p.groupService.GetGroupsWithMemberUID(uid)
+		if err != nil {
+			return err
+		}
+		groupNos := make([]string, 0, len(groups))
+		for _, groupInfo := range groups {
+			groupNos = append(groupNos, groupInfo.GroupNo)
+		}
+		resolver.holdGroups(groupNos)
+	}
+	return nil
+}
+
+// 查询群频道的群分类
+func (p *purger) groupCategories(resolver *retentionResolver, channels []*purgeChannelModel) (map[string]string, error) {
+	categories := map[string]string{}
+	if !resolver.hasCategoryPolicy() {
+		return categories, nil
+	}
+	groupNos := make([]string, 0)
+	for _, channel := range channels {
+		if channel.ChannelType == common.ChannelTypeGroup.Uint8() {
+			groupNos = append(groupNos, channel.ChannelID)
+		}
+	}
+	for start := 0; start < len(groupNos); start += purgeGroupBatchSize {
+		end := start + purgeGroupBatchSize
+		if end > len(groupNos) {
+			end = len(groupNos)
+		}
+		groups, err := p.groupService.GetGroups(groupNos[start:end])
+		if err != nil {
+			return nil, err
+		}
+		for _, groupInfo := range groups {
+			categories[groupInfo.GroupNo] = groupInfo.Category
+		}
+	}
+	return categories, nil
+}
+
+// 清理单个频道的过期消息并记录明细
+func (p *purger) purgeChannel(report *purgeReportModel, resolver *retentionResolver, table string, channel *purgeChannelModel, policy *retentionPolicyModel, cutoff int64) error {
+	item := &purgeReportItemModel{
+		ReportNo:    report.ReportNo,
+		PolicyID:    policy.Id,
+		ChannelID:   channel.ChannelID,
+		ChannelType: channel.ChannelType,
+		Cutoff:      cutoff,
+	}
+	if resolver.channelHeld(channel.ChannelID, channel.ChannelType) {
+		heldCount, err := p.retentionDB.queryExpiredCountWithUIDs(table, channel.ChannelID, channel.ChannelType, cutoff, nil)
+		if err != nil {
+			return err
+		}
+		if heldCount == 0 {
+			return nil
+		}
+		item.Held = 1
+		item.HeldCount = heldCount
+		report.HeldCount += heldCount
+		if err = p.retentionDB.insertReportItem(item); err != nil {
+			return err
+		}
+		return p.retentionDB.updateReportProgress(report)
+	}
+
+	// 频道未被保全时（包括被保全用户不在的群），只保留被保全用户发送的消息
+	heldUIDs := resolver.heldUIDs
+	if len(heldUIDs) > 0 {
+		heldCount, err := p.retentionDB.queryExpiredCountWithUIDs(table, channel.ChannelID, channel.ChannelType, cutoff, heldUIDs)
+		if err != nil {
+			return err
+		}
+		if heldCount > 0 {
+			item.HeldCount = heldCount
+			item.HeldUids = util.ToJson(heldUIDs)
+		}
+	}
+	stat, err := p.retentionDB.queryExpiredStat(table, channel.ChannelID, channel.ChannelType, cutoff, heldUIDs)
+	if err != nil {
+		return err
+	}
+	if stat == nil || (stat.MessageCount == 0 && item.HeldCount == 0) {
+		return nil
+	}
+	item.MinMessageSeq = stat.MinMessageSeq
+	item.MaxMessageSeq = stat.MaxMessageSeq
+	item.MinTimestamp = stat.MinTimestamp
+	item.MaxTimestamp = stat.MaxTimestamp
+	if report.DryRun == 1 {
+		item.MessageCount = stat.MessageCount
+	} else {
+		for {
+			messages, err := p.retentionDB.queryExpiredMessages(table, channel.ChannelID, channel.ChannelType, cutoff, heldUIDs, purgeBatchSize)
+			if err != nil {
+				return err
+			}
+			if len(messages) == 0 {
+				break
+			}
+			messageCount, extraCount, err := p.deleteMessages(table, messages)
+			if err != nil {
+				return err
+			}
+			item.MessageCount += messageCount
+			item.ExtraCount += extraCount
+			if len(messages) < purgeBatchSize {
+				break
+			}
+		}
+	}
+	if item.MessageCount > 0 {
+		report.ChannelCount++
+	}
+	report.MessageCount += item.MessageCount
+	report.HeldCount += item.HeldCount
+	report.ExtraCount += item.ExtraCount
+	if err = p.retentionDB.insertReportItem(item); err != nil {
+		return err
+	}
+	return p.retentionDB.updateReportProgress(report)
+}
+
+func (p *purger) deleteMessages(table string, messages []*purgeMessageModel) (int64, int64, error) {
+	ids := make([]int64, 0, len(messages))
+	messageIDs := make([]string, 0, len(messages))
+	for _, message := range messages {
+		ids = append(ids, message.Id)
+		messageIDs = append(messageIDs, message.MessageID)
+	}
+	tx, err := p.retentionDB.session.Begin()
+	if err != nil {
+		return 0, 0, err
+	}
+	defer func() {
+		if err := recover(); err != nil {
+			tx.Rollback()
+			panic(err)
+		}
+	}()
+	messageCount, extraCount, err := p.retentionDB.deleteMessagesTx(table, ids, messageIDs, tx)
+	if err != nil {
+		tx.Rollback()
+		return 0, 0, err
+	}
+	if err = tx.Commit(); err != nil {
+		tx.Rollback()
+		return 0, 0, err
+	}
+	return messageCount, extraCount, nil
+}
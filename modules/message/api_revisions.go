@@ -0,0 +1,111 @@
+package message
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+
+	"github.com/TangSengDaoDao/TangSengDaoDaoServer/modules/group"
+	"github.com/TangSengDaoDao/TangSengDaoDaoServerLib/common"
+	"github.com/TangSengDaoDao/TangSengDaoDaoServerLib/pkg/util"
+	"github.com/TangSengDaoDao/TangSengDaoDaoServerLib/pkg/wkhttp"
+	"go.uber.org/zap"
+)
+
+type messageRevisionResp struct {
+	Revision  int                    `json:"revision"`   // 修订号 0.原始消息
+	Content   map[string]interface{} `json:"content"`    // 正文
+	EditorUID string                 `json:"editor_uid"` // 编辑者uid
+	EditedAt  int64                  `json:"edited_at"`  // 编辑时间 时间戳（秒）
+}
+
+// 消息编辑历史
+// 消息发送者可以查看，群内消息拥有查看消息详情权限的成员也可以查看
+func (m *Message) messageRevisions(c *wkhttp.Context) {
+	loginUID := c.GetLoginUID()
+	messageID := c.Param("message_id")
+	channelID := c.Query("channel_id")
+	channelType, _ := strconv.ParseInt(c.Query("channel_type"), 10, 64)
+	if strings.TrimSpace(messageID) == "" {
+		c.ResponseError(errors.New("消息ID不能为空！"))
+		return
+	}
+	if strings.TrimSpace(channelID) == "" {
+		c.ResponseError(errors.New("频道ID不能为空！"))
+		return
+	}
+	fakeChannelID := channelID
+	if uint8(channelType) == common.ChannelTypePerson.Uint8() {
+		fakeChannelID = common.GetFakeChannelIDWith(loginUID, channelID)
+	}
+	message, err := m.db.queryMessageWithMessageID(fakeChannelID, uint8(channelType), messageID)
+	if err != nil {
+		m.Error("查询消息失败！", zap.Error(err))
+		c.ResponseError(errors.New("查询消息失败！"))
+		return
+	}
+	if message == nil {
+		c.ResponseError(errors.New("消息不存在！"))
+		return
+	}
+	if message.FromUID != loginUID {
+		if uint8(channelType) != common.ChannelTypeGroup.Uint8() {
+			c.ResponseError(errors.New("无权查看此消息的编辑历史！"))
+			return
+		}
+		canView, err := m.groupService.HasPermission(channelID, loginUID, group.PermissionViewMessageDetail)
+		if err != nil {
+			m.Error("查询群内权限失败！", zap.Error(err))
+			c.ResponseError(errors.New("查询群内权限失败！"))
+			return
+		}
+		if !canView {
+			c.ResponseError(errors.New("无权查看此消息的编辑历史！"))
+			return
+		}
+	}
+
+	revisions, err := m.editRevisionDB.queryWithMessageID(messageID)
+	if err != nil {
+		m.Error("查询消息编辑历史失败！", zap.Error(err))
+		c.ResponseError(errors.New("查询消息编辑历史失败！"))
+		return
+	}
+	resps := make([]*messageRevisionResp, 0, len(revisions)+1)
+	var payload map[string]interface{}
+	_ = util.ReadJsonByByte(message.Payload, &payload)
+	resps = append(resps, &messageRevisionResp{
+		Revision:  0,
+		Content:   payload,
+		EditorUID: message.FromUID,
+		EditedAt:  message.Timestamp,
+	})
+	if len(revisions) == 0 {
+		// 记录编辑历史之前编辑过的消息只保留了最后一次编辑
+		messageExtra, err := m.messageExtraDB.queryWithMessageID(messageID)
+		if err != nil {
+			m.Error("查询消息扩展失败！", zap.Error(err))
+			c.ResponseError(errors.New("查询消息扩展失败！"))
+			return
+		}
+		if messageExtra != nil && messageExtra.ContentEdit.String != "" {
+			revisions = append(revisions, &messageEditRevisionModel{
+				Revision:    1,
+				ContentEdit: messageExtra.ContentEdit,
+				EditorUID:   message.FromUID,
+				EditedAt:    messageExtra.EditedAt,
+			})
+		}
+	}
+	for _, revision := range revisions {
+		var content map[string]interface{}
+		_ = util.ReadJsonByByte([]byte(revision.ContentEdit.String), &content)
+		resps = append(resps, &messageRevisionResp{
+			Revision:  revision.Revision,
+			Content:   content,
+			EditorUID: revision.EditorUID,
+			EditedAt:  int64(revision.EditedAt),
+		})
+	}
+	c.Response(resps)
+}
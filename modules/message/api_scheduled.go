@@ -0,0 +1,399 @@
+package message
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/TangSengDaoDao/TangSengDaoDaoServer/modules/group"
+	"github.com/TangSengDaoDao/TangSengDaoDaoServerLib/common"
+	"github.com/TangSengDaoDao/TangSengDaoDaoServerLib/config"
+	"github.com/TangSengDaoDao/TangSengDaoDaoServerLib/pkg/util"
+	"github.com/TangSengDaoDao/TangSengDaoDaoServerLib/pkg/wkhttp"
+	"go.uber.org/zap"
+)
+
+const (
+	scheduledStatusWait    = 0 // 待发送
+	scheduledStatusSending = 1 // 发送中
+	scheduledStatusSent    = 2 // 已发送
+	scheduledStatusCancel  = 3 // 已取消
+	scheduledStatusFail    = 4 // 发送失败
+)
+
+const (
+	scheduledMaxAhead          = time.Hour * 24 * 365 // 最多可提前多久设置定时消息
+	scheduledScanInterval      = time.Second * 5      // 扫描待发送消息的间隔
+	scheduledScanLimit         = 200                  // 每次扫描的最大数量
+	scheduledSendingStaleAfter = time.Minute * 5      // 发送中状态超过此时间视为中断，标记为发送失败（不重新投递，避免重复发送）
+)
+
+const scheduledStaleFailReason = "投递中断，发送结果未知"
+
+var scheduledWorkerOnce sync.Once
+
+type scheduledMessageReq struct {
+	ChannelID   string                 `json:"channel_id"`
+	ChannelType uint8                  `json:"channel_type"`
+	Payload     map[string]interface{} `json:"payload"`
+	SendAt      int64                  `json:"send_at"` // 计划发送时间 10位时间戳
+}
+
+func (s *scheduledMessageReq) check() error {
+	if strings.TrimSpace(s.ChannelID) == "" {
+		return errors.New("频道ID不能为空！")
+	}
+	if s.ChannelType == 0 {
+		return errors.New("频道类型不能为空！")
+	}
+	return checkScheduledPayloadAndSendAt(s.Payload, s.SendAt)
+}
+
+func checkScheduledPayloadAndSendAt(payload map[string]interface{}, sendAt int64) error {
+	if len(payload) == 0 {
+		return errors.New("消息内容不能为空！")
+	}
+	now := time.Now()
+	if sendAt <= now.Unix() {
+		return errors.New("发送时间必须大于当前时间！")
+	}
+	if sendAt > now.Add(scheduledMaxAhead).Unix() {
+		return errors.New("发送时间不能超过一年！")
+	}
+	return nil
+}
+
+// ScheduledMessageResp 定时消息
+type ScheduledMessageResp struct {
+	ID          int64                  `json:"id"`
+	FromUID     string                 `json:"from_uid"`
+	ChannelID   string                 `json:"channel_id"`
+	ChannelType uint8                  `json:"channel_type"`
+	Payload     map[string]interface{} `json:"payload"`
+	SendAt      int64                  `json:"send_at"`
+	Status      int                    `json:"status"`
+	FailReason  string                 `json:"fail_reason,omitempty"`
+	MessageID   string                 `json:"message_id,omitempty"`
+	SentAt      int64                  `json:"sent_at,omitempty"`
+	CreatedAt   string                 `json:"created_at"`
+}
+
+func newScheduledMessageResp(m *scheduledMessageModel) *ScheduledMessageResp {
+	var payload map[string]interface{}
+	if m.Payload != "" {
+		_ = util.ReadJsonByByte([]byte(m.Payload), &payload)
+	}
+	return &ScheduledMessageResp{
+		ID:          m.Id,
+		FromUID:     m.FromUID,
+		ChannelID:   m.ChannelID,
+		ChannelType: m.ChannelType,
+		Payload:     payload,
+		SendAt:      m.SendAt,
+		Status:      m.Status,
+		FailReason:  m.FailReason,
+		MessageID:   m.MessageID,
+		SentAt:      m.SentAt,
+		CreatedAt:   m.CreatedAt.String(),
+	}
+}
+
+// 添加定时消息
+func (m *Message) scheduledAdd(c *wkhttp.Context) {
+	loginUID := c.GetLoginUID()
+	var req scheduledMessageReq
+	if err := c.BindJSON(&req); err != nil {
+		m.Error(common.ErrData.Error(), zap.Error(err))
+		c.ResponseError(common.ErrData)
+		return
+	}
+	if err := req.check(); err != nil {
+		c.ResponseError(err)
+		return
+	}
+	// 创建时先校验一次权限和违禁词，发送时还会再校验
+	if err := checkSendPermission(m.userService, m.groupService, loginUID, false, req.ChannelID, req.ChannelType); err != nil {
+		c.ResponseError(err)
+		return
+	}
+	if _, err := m.prohibitService.FilterPayload(req.Payload); err != nil {
+		c.ResponseError(err)
+		return
+	}
+	id, err := m.scheduledMessageDB.insert(&scheduledMessageModel{
+		FromUID:     loginUID,
+		ChannelID:   req.ChannelID,
+		ChannelType: req.ChannelType,
+		Payload:     util.ToJson(req.Payload),
+		SendAt:      req.SendAt,
+		Status:      scheduledStatusWait,
+	})
+	if err != nil {
+		m.Error("添加定时消息失败！", zap.Error(err))
+		c.ResponseError(errors.New("添加定时消息失败！"))
+		return
+	}
+	c.Response(map[string]interface{}{
+		"id": id,
+	})
+}
+
+// 获取频道内自己待发送的定时消息
+func (m *Message) scheduledList(c *wkhttp.Context) {
+	loginUID := c.GetLoginUID()
+	channelID := c.Query("channel_id")
+	channelTypeI64, _ := strconv.ParseInt(c.Query("channel_type"), 10, 64)
+	if strings.TrimSpace(channelID) == "" {
+		c.ResponseError(errors.New("频道ID不能为空！"))
+		return
+	}
+	models, err := m.scheduledMessageDB.queryPendingWithChannel(loginUID, channelID, uint8(channelTypeI64))
+	if err != nil {
+		m.Error("查询定时消息失败！", zap.Error(err))
+		c.ResponseError(errors.New("查询定时消息失败！"))
+		return
+	}
+	resps := make([]*ScheduledMessageResp, 0, len(models))
+	for _, model := range models {
+		resps = append(resps, newScheduledMessageResp(model))
+	}
+	c.Response(resps)
+}
+
+// 编辑定时消息
+func (m *Message) scheduledUpdate(c *wkhttp.Context) {
+	loginUID := c.GetLoginUID()
+	id, _ := strconv.ParseInt(c.Param("id"), 10, 64)
+	var req struct {
+		Payload map[string]interface{} `json:"payload"`
+		SendAt  int64                  `json:"send_at"`
+	}
+	if err := c.BindJSON(&req); err != nil {
+		m.Error(common.ErrData.Error(), zap.Error(err))
+		c.ResponseError(common.ErrData)
+		return
+	}
+	if err := checkScheduledPayloadAndSendAt(req.Payload, req.SendAt); err != nil {
+		c.ResponseError(err)
+		return
+	}
+	model, err := m.scheduledMessageDB.queryWithID(id)
+	if err != nil {
+		m.Error("查询定时消息失败！", zap.Error(err))
+		c.ResponseError(errors.New("查询定时消息失败！"))
+		return
+	}
+	if model == nil || model.FromUID != loginUID {
+		c.ResponseError(errors.New("定时消息不存在！"))
+		return
+	}
+	rows, err := m.scheduledMessageDB.updatePayloadAndSendAt(id, util.ToJson(req.Payload), req.SendAt)
+	if err != nil {
+		m.Error("修改定时消息失败！", zap.Error(err))
+		c.ResponseError(errors.New("修改定时消息失败！"))
+		return
+	}
+	if rows == 0 {
+		c.ResponseError(errors.New("定时消息已发送或已取消，不能修改！"))
+		return
+	}
+	c.ResponseOK()
+}
+
+// 取消定时消息
+func (m *Message) scheduledCancel(c *wkhttp.Context) {
+	loginUID := c.GetLoginUID()
+	id, _ := strconv.ParseInt(c.Param("id"), 10, 64)
+	model, err := m.scheduledMessageDB.queryWithID(id)
+	if err != nil {
+		m.Error("查询定时消息失败！", zap.Error(err))
+		c.ResponseError(errors.New("查询定时消息失败！"))
+		return
+	}
+	if model == nil || model.FromUID != loginUID {
+		c.ResponseError(errors.New("定时消息不存在！"))
+		return
+	}
+	rows, err := m.scheduledMessageDB.updateStatus(id, scheduledStatusWait, scheduledStatusCancel)
+	if err != nil {
+		m.Error("取消定时消息失败！", zap.Error(err))
+		c.ResponseError(errors.New("取消定时消息失败！"))
+		return
+	}
+	if rows == 0 {
+		c.ResponseError(errors.New("定时消息已发送或已取消！"))
+		return
+	}
+	c.ResponseOK()
+}
+
+// 开启定时消息投递（多次调用只会启动一个）
+func (m *Message) startScheduledMessageWorker() {
+	scheduledWorkerOnce.Do(func() {
+		go func() {
+			ticker := time.NewTicker(scheduledScanInterval)
+			defer ticker.Stop()
+			for range ticker.C {
+				m.recoverStaleScheduledMessages()
+				m.deliverDueScheduledMessages()
+			}
+		}()
+	})
+}
+
+// 中断在发送中状态的消息（如投递过程中服务重启）可能已经发出，不能确定结果，标记为发送失败而不是重新投递
+func (m *Message) recoverStaleScheduledMessages() {
+	models, err := m.scheduledMessageDB.queryStaleSending(time.Now().Add(-scheduledSendingStaleAfter))
+	if err != nil {
+		m.Error("查询发送中的定时消息失败！", zap.Error(err))
+		return
+	}
+	for _, model := range models {
+		if _, err = m.scheduledMessageDB.updateSendingToFail(model.Id, scheduledStaleFailReason); err != nil {
+			m.Error("修改中断的定时消息为失败状态失败！", zap.Error(err), zap.Int64("id", model.Id))
+		}
+	}
+}
+
+func (m *Message) deliverDueScheduledMessages() {
+	models, err := m.scheduledMessageDB.queryDue(time.Now().Unix(), scheduledScanLimit)
+	if err != nil {
+		m.Error("查询到期的定时消息失败！", zap.Error(err))
+		return
+	}
+	for _, model := range models {
+		// 抢占任务，防止多实例重复发送
+		rows, err := m.scheduledMessageDB.updateStatus(model.Id, scheduledStatusWait, scheduledStatusSending)
+		if err != nil {
+			m.Error("修改定时消息状态失败！", zap.Error(err), zap.Int64("id", model.Id))
+			continue
+		}
+		if rows == 0 {
+			continue
+		}
+		m.deliverScheduledMessage(model)
+	}
+}
+
+func (m *Message) deliverScheduledMessage(model *scheduledMessageModel) {
+	err := checkSendPermission(m.userService, m.groupService, model.FromUID, model.FromRobot == 1, model.ChannelID, model.ChannelType)
+	if err != nil {
+		m.Warn("定时消息无发送权限！", zap.Error(err), zap.Int64("id", model.Id), zap.String("fromUID", model.FromUID), zap.String("channelID", model.ChannelID))
+		if err = m.scheduledMessageDB.updateFail(model.Id, err.Error()); err != nil {
+			m.Error("修改定时消息为失败状态失败！", zap.Error(err))
+		}
+		return
+	}
+	var payloadMap map[string]interface{}
+	_ = util.ReadJsonByByte([]byte(model.Payload), &payloadMap)
+	payloadMap, err = m.prohibitService.FilterPayload(payloadMap)
+	if err != nil {
+		if err = m.scheduledMessageDB.updateFail(model.Id, err.Error()); err != nil {
+			m.Error("修改定时消息为失败状态失败！", zap.Error(err))
+		}
+		return
+	}
+	result, err := m.ctx.SendMessageWithResult(&config.MsgSendReq{
+		Header: config.MsgHeader{
+			RedDot: 1,
+		},
+		ChannelID:   model.ChannelID,
+		ChannelType: model.ChannelType,
+		FromUID:     model.FromUID,
+		ClientMsgNo: scheduledClientMsgNo(model.Id), // 每条定时消息固定的客户端消息编号，IM可据此去重
+		Payload:     []byte(util.ToJson(payloadMap)),
+	})
+	if err != nil {
+		m.Error("发送定时消息失败！", zap.Error(err), zap.Int64("id", model.Id))
+		if err = m.scheduledMessageDB.updateFail(model.Id, "发送消息失败"); err != nil {
+			m.Error("修改定时消息为失败状态失败！", zap.Error(err))
+		}
+		return
+	}
+	var messageID string
+	if result != nil {
+		messageID = strconv.FormatInt(result.MessageID, 10)
+	}
+	if err = m.scheduledMessageDB.updateSent(model.Id, messageID, time.Now().Unix()); err != nil {
+		m.Error("修改定时消息为已发送失败！", zap.Error(err), zap.Int64("id", model.Id))
+	}
+}
+
+// 定时消息的客户端消息编号
+func scheduledClientMsgNo(id int64) string {
+	return fmt.Sprintf("scheduled_%d", id)
+}
+
+// 校验发送者当前是否可以向频道发送消息
+func checkSendPermission(userService userSendChecker, groupService group.IService, fromUID string, robot bool, channelID string, channelType uint8) error {
+	if channelType == common.ChannelTypePerson.Uint8() {
+		inBlacklist, err := userService.ExistBlacklist(channelID, fromUID)
+		if err != nil {
+			return fmt.Errorf("查询黑名单失败！%w", err)
+		}
+		if inBlacklist {
+			return errors.New("已被对方拉入黑名单")
+		}
+		if robot {
+			return nil
+		}
+		sendUserIsFriend, err := userService.IsFriend(fromUID, channelID)
+		if err != nil {
+			return fmt.Errorf("查询好友关系错误！%w", err)
+		}
+		if !sendUserIsFriend {
+			return errors.New("发送者与接受者不是好友")
+		}
+		recvUserIsFriend, err := userService.IsFriend(channelID, fromUID)
+		if err != nil {
+			return fmt.Errorf("查询好友关系错误！%w", err)
+		}
+		if !recvUserIsFriend {
+			return errors.New("接受者与发送者不是好友")
+		}
+		return nil
+	}
+	if channelType == common.ChannelTypeGroup.Uint8() {
+		groupInfo, err := groupService.GetGroupWithGroupNo(channelID)
+		if err != nil {
+			return fmt.Errorf("查询群信息错误！%w", err)
+		}
+		if groupInfo == nil || groupInfo.Status != group.GroupStatusNormal {
+			return errors.New("群不存在或已禁用")
+		}
+		member, err := groupService.GetMember(channelID, fromUID)
+		if err != nil {
+			return fmt.Errorf("查询群成员错误！%w", err)
+		}
+		if member == nil || member.IsDeleted == 1 {
+			return errors.New("未在群内")
+		}
+		if member.Status == int(common.GroupMemberStatusBlacklist) {
+			return errors.New("已被拉入群黑名单")
+		}
+		// 与IM的全员禁言白名单保持一致，群主和管理员不受禁言限制
+		isManager, err := groupService.IsCreatorOrManager(channelID, fromUID)
+		if err != nil {
+			return fmt.Errorf("查询群管理员错误！%w", err)
+		}
+		if isManager {
+			return nil
+		}
+		if groupInfo.Forbidden == 1 {
+			return errors.New("群已开启全员禁言")
+		}
+		if member.ForbiddenExpirTime > time.Now().Unix() {
+			return errors.New("已被禁言")
+		}
+	}
+	return nil
+}
+
+// 发送权限校验所需的用户服务
+type userSendChecker interface {
+	IsFriend(uid string, toUID string) (bool, error)
+	ExistBlacklist(uid string, toUID string) (bool, error)
+}
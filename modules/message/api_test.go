@@ -3,6 +3,7 @@ package message
 import (
 	"bytes"
 	"encoding/json"
+	"fmt"
 	"net/http"
 	"net/http/httptest"
 	"os"
@@ -10,6 +11,7 @@ import (
 	"time"
 
 	"github.com/TangSengDaoDao/TangSengDaoDaoServer/modules/base/event"
+	"github.com/TangSengDaoDao/TangSengDaoDaoServer/modules/group"
 	_ "github.com/TangSengDaoDao/TangSengDaoDaoServer/modules/webhook"
 	"github.com/TangSengDaoDao/TangSengDaoDaoServerLib/common"
 	"github.com/TangSengDaoDao/TangSengDaoDaoServerLib/config"
@@ -187,6 +189,44 @@ func TestSyncPindMessage(t *testing.T) {
 	// assert.Equal(t, http.StatusOK, w.Code)
 }
 
+func TestScheduledMessage(t *testing.T) {
+	s, ctx := testutil.NewTestServer()
+	msg := New(ctx)
+	msg.Route(s.GetRoute())
+	err := testutil.CleanAllTables(ctx)
+	assert.NoError(t, err)
+
+	id, err := msg.scheduledMessageDB.insert(&scheduledMessageModel{
+		FromUID:     testutil.UID,
+		ChannelID:   "c1",
+		ChannelType: common.ChannelTypePerson.Uint8(),
+		Payload:     util.ToJson(map[string]interface{}{"type": 1, "content": "hello"}),
+		SendAt:      time.Now().Add(time.Hour).Unix(),
+		Status:      scheduledStatusWait,
+	})
+	assert.NoError(t, err)
+
+	// 发送时间已过期
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("PUT", fmt.Sprintf("/v1/message/scheduled/%d", id), bytes.NewReader([]byte(util.ToJson(map[string]interface{}{
+		"payload": map[string]interface{}{"type": 1, "content": "hello2"},
+		"send_at": time.Now().Add(-time.Minute).Unix(),
+	}))))
+	req.Header.Set("token", testutil.Token)
+	s.GetRoute().ServeHTTP(w, req)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+
+	w = httptest.NewRecorder()
+	req, _ = http.NewRequest("DELETE", fmt.Sprintf("/v1/message/scheduled/%d", id), nil)
+	req.Header.Set("token", testutil.Token)
+	s.GetRoute().ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	model, err := msg.scheduledMessageDB.queryWithID(id)
+	assert.NoError(t, err)
+	assert.Equal(t, scheduledStatusCancel, model.Status)
+}
+
 // UID 测试用户ID
 var UID = "beb714efd08a4530a5881ebd7f2fde38"
 
@@ -233,3 +273,475 @@ func NewTestServer1(args ...string) (*server.Server, *config.Context) {
 	return s, ctx
 
 }
+
+func TestMessageEditRevisions(t *testing.T) {
+	s, ctx := testutil.NewTestServer()
+	msg := New(ctx)
+	msg.Route(s.GetRoute())
+	err := testutil.CleanAllTables(ctx)
+	assert.NoError(t, err)
+
+	channelID := "g1"
+	channelType := common.ChannelTypeGroup.Uint8()
+	err = msg.db.insertMessage(&messageModel{
+		MessageID:   100,
+		MessageSeq:  1,
+		FromUID:     testutil.UID,
+		ChannelID:   channelID,
+		ChannelType: channelType,
+		Timestamp:   time.Now().Unix(),
+		Payload:     []byte(util.ToJson(map[string]interface{}{"type": 1, "content": "hello"})),
+	})
+	assert.NoError(t, err)
+
+	for _, content := range []string{"hello1", "hello2"} {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("POST", "/v1/message/edit", bytes.NewReader([]byte(util.ToJson(map[string]interface{}{
+			"message_id":   "100",
+			"message_seq":  1,
+			"channel_id":   channelID,
+			"channel_type": channelType,
+			"content_edit": util.ToJson(map[string]interface{}{"type": 1, "content": content}),
+		}))))
+		req.Header.Set("token", testutil.Token)
+		s.GetRoute().ServeHTTP(w, req)
+	}
+
+	revisions, err := msg.editRevisionDB.queryWithMessageID("100")
+	assert.NoError(t, err)
+	assert.Equal(t, 2, len(revisions))
+	assert.Equal(t, 1, revisions[0].Revision)
+	assert.Equal(t, 2, revisions[1].Revision)
+	assert.Equal(t, testutil.UID, revisions[1].EditorUID)
+}
+
+func TestRetentionResolver(t *testing.T) {
+	typePolicy := &retentionPolicyModel{Scope: retentionScopeChannelType, ChannelType: common.ChannelTypeGroup.Uint8(), RetentionSecond: 30 * 86400}
+	categoryPolicy := &retentionPolicyModel{Scope: retentionScopeCategory, ChannelType: common.ChannelTypeGroup.Uint8(), Category: "finance", RetentionSecond: 365 * 86400}
+	channelPolicy := &retentionPolicyModel{Scope: retentionScopeChannel, ChannelType: common.ChannelTypeGroup.Uint8(), ChannelID: "g1", RetentionSecond: 7 * 86400}
+	resolver := newRetentionResolver([]*retentionPolicyModel{typePolicy, categoryPolicy, channelPolicy}, []*legalHoldModel{
+		{TargetType: legalHoldTargetChannel, ChannelID: "g2", ChannelType: common.ChannelTypeGroup.Uint8()},
+		{TargetType: legalHoldTargetUser, UID: "u1"},
+	})
+	assert.Equal(t, int64(7*86400), resolver.minRetention)
+	assert.Equal(t, channelPolicy, resolver.policy("g1", common.ChannelTypeGroup.Uint8(), "finance"))
+	assert.Equal(t, categoryPolicy, resolver.policy("g3", common.ChannelTypeGroup.Uint8(), "finance"))
+	assert.Equal(t, typePolicy, resolver.policy("g4", common.ChannelTypeGroup.Uint8(), ""))
+	assert.Nil(t, resolver.policy("u2@u3", common.ChannelTypePerson.Uint8(), ""))
+
+	assert.True(t, resolver.channelHeld("g2", common.ChannelTypeGroup.Uint8()))
+	assert.False(t, resolver.channelHeld("g1", common.ChannelTypeGroup.Uint8()))
+	assert.True(t, resolver.channelHeld("u1@u2", common.ChannelTypePerson.Uint8()))
+	assert.Equal(t, []string{"u1"}, resolver.heldUIDs)
+
+	// 被保全用户所在的群整体保全
+	resolver.holdGroups([]string{"g5"})
+	assert.True(t, resolver.channelHeld("g5", common.ChannelTypeGroup.Uint8()))
+	assert.False(t, resolver.channelHeld("g5", common.ChannelTypePerson.Uint8()))
+}
+
+func TestForwardVisibleMessages(t *testing.T) {
+	_, ctx := testutil.NewTestServer()
+	msg := New(ctx)
+	err := testutil.CleanAllTables(ctx)
+	assert.NoError(t, err)
+
+	channelType := common.ChannelTypePerson.Uint8()
+	fakeChannelID := common.GetFakeChannelIDWith(testutil.UID, "10001")
+	for i := 1; i <= 3; i++ {
+		err = msg.db.insertMessage(&messageModel{
+			MessageID:   int64(200 + i),
+			MessageSeq:  uint32(i),
+			FromUID:     testutil.UID,
+			ChannelID:   fakeChannelID,
+			ChannelType: channelType,
+			Timestamp:   time.Now().Unix(),
+			Payload:     []byte(util.ToJson(map[string]interface{}{"type": 1, "content": fmt.Sprintf("hello%d", i)})),
+		})
+		assert.NoError(t, err)
+	}
+	err = msg.messageExtraDB.insertOrUpdateDeleted(&messageExtraModel{
+		MessageID:   "203",
+		MessageSeq:  3,
+		ChannelID:   fakeChannelID,
+		ChannelType: channelType,
+		IsDeleted:   1,
+	})
+	assert.NoError(t, err)
+
+	bundleMessages, err := msg.visibleForwardMessages(testutil.UID, fakeChannelID, channelType, []string{"202", "201"})
+	assert.NoError(t, err)
+	assert.Equal(t, 2, len(bundleMessages))
+	assert.Equal(t, "201", bundleMessages[0].MessageID)
+
+	_, err = msg.visibleForwardMessages(testutil.UID, fakeChannelID, channelType, []string{"201", "203"})
+	assert.Error(t, err)
+
+	_, err = msg.visibleForwardMessages(testutil.UID, fakeChannelID, channelType, []string{"201", "999"})
+	assert.Error(t, err)
+
+	// 合并转发消息只引用由它发出的合并转发记录，伪造的bundle_no不作为引用
+	tx, _ := ctx.DB().Begin()
+	err = msg.forwardDB.insertTx(&forwardBundleModel{
+		BundleNo:  "bundle1",
+		UID:       "10002",
+		MessageID: "204",
+	}, nil, tx)
+	assert.NoError(t, err)
+	assert.NoError(t, tx.Commit())
+	for i, messageID := range []int64{204, 205} {
+		err = msg.db.insertMessage(&messageModel{
+			MessageID:   messageID,
+			MessageSeq:  uint32(4 + i),
+			FromUID:     testutil.UID,
+			ChannelID:   fakeChannelID,
+			ChannelType: channelType,
+			Timestamp:   time.Now().Unix(),
+			Payload:     []byte(util.ToJson(map[string]interface{}{"type": common.MultipleForward, "bundle_no": "bundle1"})),
+		})
+		assert.NoError(t, err)
+	}
+	bundleMessages, err = msg.visibleForwardMessages(testutil.UID, fakeChannelID, channelType, []string{"204", "205"})
+	assert.NoError(t, err)
+	assert.Equal(t, 2, len(bundleMessages))
+	assert.Equal(t, "bundle1", bundleMessages[0].RefBundleNo)
+	assert.Equal(t, "", bundleMessages[1].RefBundleNo)
+}
+
+func TestSplitReceiptMembers(t *testing.T) {
+	message := &messageModel{FromUID: "u1", Timestamp: 1000}
+	members := []*group.MemberResp{
+		{UID: "u1", Name: "sender", CreatedAt: 100},
+		{UID: "u2", Name: "readed", CreatedAt: 100},
+		{UID: "u3", Name: "unread", CreatedAt: 100},
+		{UID: "u4", Name: "deleted", CreatedAt: 100, IsDeleted: 1},
+		{UID: "u5", Name: "joinedLater", CreatedAt: 2000},
+	}
+	readed, unread := splitReceiptMembers(message, members, []string{"u2"})
+	assert.Equal(t, 1, len(readed))
+	assert.Equal(t, "u2", readed[0].UID)
+	assert.Equal(t, 1, len(unread))
+	assert.Equal(t, "u3", unread[0].UID)
+}
+
+func TestReceiptPermission(t *testing.T) {
+	s, ctx := testutil.NewTestServer()
+	msg := New(ctx)
+	msg.Route(s.GetRoute())
+	err := testutil.CleanAllTables(ctx)
+	assert.NoError(t, err)
+
+	channelID := "g1"
+	err = msg.db.insertMessage(&messageModel{
+		MessageID:   300,
+		MessageSeq:  1,
+		FromUID:     testutil.UID,
+		ChannelID:   channelID,
+		ChannelType: common.ChannelTypeGroup.Uint8(),
+		Timestamp:   time.Now().Unix(),
+		Payload:     []byte(util.ToJson(map[string]interface{}{"type": 1, "content": "hello"})),
+	})
+	assert.NoError(t, err)
+
+	// 发送者已不在群内，不能查看回执
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/v1/messages/300/read_status?channel_id="+channelID, nil)
+	req.Header.Set("token", testutil.Token)
+	s.GetRoute().ServeHTTP(w, req)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+
+	groupDB := group.NewDB(ctx)
+	err = groupDB.InsertMember(&group.MemberModel{GroupNo: channelID, UID: testutil.UID, Role: group.MemberRoleCommon, Status: int(common.GroupMemberStatusNormal)})
+	assert.NoError(t, err)
+	err = groupDB.InsertMember(&group.MemberModel{GroupNo: channelID, UID: "10001", Role: group.MemberRoleCommon, Status: int(common.GroupMemberStatusNormal)})
+	assert.NoError(t, err)
+
+	// 群内的发送者可以查看回执
+	w = httptest.NewRecorder()
+	req, _ = http.NewRequest("GET", "/v1/messages/300/read_status?channel_id="+channelID, nil)
+	req.Header.Set("token", testutil.Token)
+	s.GetRoute().ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	// 没有查看消息详情权限的发送者不能催读
+	w = httptest.NewRecorder()
+	req, _ = http.NewRequest("POST", "/v1/messages/300/nudge", bytes.NewReader([]byte(util.ToJson(map[string]interface{}{
+		"channel_id": channelID,
+	}))))
+	req.Header.Set("token", testutil.Token)
+	s.GetRoute().ServeHTTP(w, req)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+}
+
+func TestPollResult(t *testing.T) {
+	poll := &pollModel{
+		PollNo:   "p1",
+		Options:  util.ToJson([]*pollOption{{ID: 1, Text: "a"}, {ID: 2, Text: "b"}}),
+		Multiple: 1,
+	}
+	votes := []*pollVoteModel{
+		{UID: "u1", OptionID: 1},
+		{UID: "u1", OptionID: 2},
+		{UID: "u2", OptionID: 1},
+		{UID: "u3", OptionID: 3}, // 不存在的选项不计票
+	}
+	result := newPollResult(poll, votes)
+	assert.Equal(t, pollStatusOpen, result.Status)
+	assert.Equal(t, 2, result.TotalVoters)
+	assert.Equal(t, 2, result.Options[0].Count)
+	assert.Equal(t, []string{"u1", "u2"}, result.Options[0].Voters)
+	assert.Equal(t, 1, result.Options[1].Count)
+
+	// 匿名投票不返回投票者，已过截止时间视为结束
+	poll.Anonymous = 1
+	poll.Deadline = time.Now().Add(-time.Minute).Unix()
+	result = newPollResult(poll, votes)
+	assert.Equal(t, pollStatusClosed, result.Status)
+	assert.Equal(t, 0, len(result.Options[0].Voters))
+}
+
+func TestPollVote(t *testing.T) {
+	s, ctx := testutil.NewTestServer()
+	msg := New(ctx)
+	msg.Route(s.GetRoute())
+	err := testutil.CleanAllTables(ctx)
+	assert.NoError(t, err)
+
+	err = group.NewDB(ctx).InsertMember(&group.MemberModel{GroupNo: "g1", UID: testutil.UID, Role: group.MemberRoleCommon, Status: int(common.GroupMemberStatusNormal)})
+	assert.NoError(t, err)
+	err = msg.pollDB.insert(&pollModel{
+		PollNo:      "p1",
+		MessageID:   "400",
+		MessageSeq:  1,
+		ChannelID:   "g1",
+		ChannelType: common.ChannelTypeGroup.Uint8(),
+		Creator:     "10001",
+		Question:    "q",
+		Options:     util.ToJson([]*pollOption{{ID: 1, Text: "a"}, {ID: 2, Text: "b"}}),
+	})
+	assert.NoError(t, err)
+
+	vote := func(optionIDs []int) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("POST", "/v1/polls/p1/vote", bytes.NewReader([]byte(util.ToJson(map[string]interface{}{
+			"option_ids": optionIDs,
+		}))))
+		req.Header.Set("token", testutil.Token)
+		s.GetRoute().ServeHTTP(w, req)
+		return w
+	}
+
+	// 单选投票不能选多个
+	w := vote([]int{1, 2})
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+
+	w = vote([]int{1})
+	assert.Equal(t, http.StatusOK, w.Code)
+	// 重新投票覆盖之前的选择
+	w = vote([]int{2})
+	assert.Equal(t, http.StatusOK, w.Code)
+	votes, err := msg.pollDB.queryVotes("p1")
+	assert.NoError(t, err)
+	assert.Equal(t, 1, len(votes))
+	assert.Equal(t, 2, votes[0].OptionID)
+
+	extra, err := msg.messageExtraDB.queryWithMessageID("400")
+	assert.NoError(t, err)
+	var result pollResult
+	err = util.ReadJsonByByte([]byte(extra.PollResult.String), &result)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, result.TotalVoters)
+	assert.Equal(t, 0, result.Options[0].Count)
+	assert.Equal(t, 1, result.Options[1].Count)
+}
+
+func TestCloseExpiredPolls(t *testing.T) {
+	_, ctx := testutil.NewTestServer()
+	msg := New(ctx)
+	err := testutil.CleanAllTables(ctx)
+	assert.NoError(t, err)
+
+	deadline := time.Now().Add(-time.Minute).Unix()
+	err = msg.pollDB.insert(&pollModel{
+		PollNo:      "p1",
+		MessageID:   "400",
+		MessageSeq:  1,
+		ChannelID:   "g1",
+		ChannelType: common.ChannelTypeGroup.Uint8(),
+		Creator:     "10001",
+		Question:    "q",
+		Options:     util.ToJson([]*pollOption{{ID: 1, Text: "a"}, {ID: 2, Text: "b"}}),
+		Deadline:    deadline,
+	})
+	assert.NoError(t, err)
+
+	msg.closeExpiredPolls()
+
+	poll, err := msg.pollDB.queryWithPollNo("p1")
+	assert.NoError(t, err)
+	assert.Equal(t, pollStatusClosed, poll.Status)
+	assert.Equal(t, deadline, poll.ClosedAt)
+	extra, err := msg.messageExtraDB.queryWithMessageID("400")
+	assert.NoError(t, err)
+	assert.Contains(t, extra.PollResult.String, `"status":1`)
+
+	// 已结束的投票不会重复处理
+	closedPoll, err := msg.closePoll("p1", "", deadline)
+	assert.NoError(t, err)
+	assert.Nil(t, closedPoll)
+}
+
+// 准备导出用的消息：正常、被删除、被撤回、自己删除
+func prepareExportMessages(t *testing.T, ctx *config.Context, msg *Message, channelID string, channelType uint8) {
+	for i := 1; i <= 4; i++ {
+		err := msg.db.insertMessage(&messageModel{
+			MessageID:   int64(500 + i),
+			MessageSeq:  uint32(i),
+			FromUID:     testutil.UID,
+			ChannelID:   channelID,
+			ChannelType: channelType,
+			Timestamp:   time.Now().Unix(),
+			Payload:     []byte(util.ToJson(map[string]interface{}{"type": 1, "content": fmt.Sprintf("hello%d", i)})),
+		})
+		assert.NoError(t, err)
+	}
+	err := msg.messageExtraDB.insertOrUpdateDeleted(&messageExtraModel{
+		MessageID:   "502",
+		MessageSeq:  2,
+		ChannelID:   channelID,
+		ChannelType: channelType,
+		IsDeleted:   1,
+	})
+	assert.NoError(t, err)
+	tx, _ := ctx.DB().Begin()
+	err = msg.messageExtraDB.insertTx(&messageExtraModel{
+		MessageID:   "503",
+		MessageSeq:  3,
+		ChannelID:   channelID,
+		ChannelType: channelType,
+		Revoke:      1,
+		Revoker:     testutil.UID,
+	}, tx)
+	assert.NoError(t, err)
+	err = msg.exporter.messageUserExtraDB.insertOrUpdateDeletedTx(&messageUserExtraModel{
+		UID:              testutil.UID,
+		MessageID:        "504",
+		MessageSeq:       4,
+		ChannelID:        channelID,
+		ChannelType:      channelType,
+		MessageIsDeleted: 1,
+	}, tx)
+	assert.NoError(t, err)
+	assert.NoError(t, tx.Commit())
+}
+
+func TestExportAccess(t *testing.T) {
+	s, ctx := testutil.NewTestServer()
+	msg := New(ctx)
+	msg.Route(s.GetRoute())
+	err := testutil.CleanAllTables(ctx)
+	assert.NoError(t, err)
+
+	export := func(channelID string, channelType uint8) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("POST", "/v1/message/export", bytes.NewReader([]byte(util.ToJson(map[string]interface{}{
+			"channel_id":   channelID,
+			"channel_type": channelType,
+		}))))
+		req.Header.Set("token", testutil.Token)
+		s.GetRoute().ServeHTTP(w, req)
+		return w
+	}
+	// 不是群成员不能导出
+	w := export("g1", common.ChannelTypeGroup.Uint8())
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Contains(t, w.Body.String(), "不是群成员，不能导出！")
+	// 不支持的频道类型
+	w = export("c1", 99)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+
+	// 不能查看和下载别人的导出
+	err = msg.exporter.exportDB.insert(&exportModel{
+		ExportNo:    "e1",
+		UID:         "10001",
+		ChannelID:   "g1",
+		ChannelType: common.ChannelTypeGroup.Uint8(),
+		Status:      exportStatusDone,
+	})
+	assert.NoError(t, err)
+	for _, path := range []string{"/v1/message/exports/e1", "/v1/message/exports/e1/download"} {
+		w = httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", path, nil)
+		req.Header.Set("token", testutil.Token)
+		s.GetRoute().ServeHTTP(w, req)
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+		assert.Contains(t, w.Body.String(), "导出记录不存在！")
+	}
+}
+
+func TestExportBuildMessages(t *testing.T) {
+	_, ctx := testutil.NewTestServer()
+	msg := New(ctx)
+	err := testutil.CleanAllTables(ctx)
+	assert.NoError(t, err)
+
+	channelID := "g1"
+	channelType := common.ChannelTypeGroup.Uint8()
+	prepareExportMessages(t, ctx, msg, channelID, channelType)
+	messages, err := msg.exporter.exportDB.queryMessagesAfterSeq(channelID, channelType, 0, exportBatchSize)
+	assert.NoError(t, err)
+	assert.Equal(t, 4, len(messages))
+
+	// 用户导出不包含被删除和自己删除的消息，撤回的消息不保留内容
+	exportMessages, err := msg.exporter.buildMessages(&exportModel{UID: testutil.UID}, messages, map[string]string{})
+	assert.NoError(t, err)
+	assert.Equal(t, 2, len(exportMessages))
+	assert.Equal(t, "501", exportMessages[0].MessageID)
+	assert.Equal(t, "hello1", exportMessages[0].Payload["content"])
+	assert.Equal(t, "503", exportMessages[1].MessageID)
+	assert.Equal(t, 1, exportMessages[1].Revoke)
+	assert.Nil(t, exportMessages[1].Payload)
+
+	// 管理员导出保留全部消息及其状态
+	exportMessages, err = msg.exporter.buildMessages(&exportModel{UID: "admin", IsManager: 1}, messages, map[string]string{})
+	assert.NoError(t, err)
+	assert.Equal(t, 4, len(exportMessages))
+	assert.Equal(t, 1, exportMessages[1].IsDeleted)
+	assert.NotNil(t, exportMessages[2].Payload)
+}
+
+func TestExportUserMessages(t *testing.T) {
+	_, ctx := testutil.NewTestServer()
+	msg := New(ctx)
+	err := testutil.CleanAllTables(ctx)
+	assert.NoError(t, err)
+
+	channelType := common.ChannelTypePerson.Uint8()
+	fakeChannelID := common.GetFakeChannelIDWith(testutil.UID, "10001")
+	prepareExportMessages(t, ctx, msg, fakeChannelID, channelType)
+	// 别人发送的消息不导出
+	err = msg.db.insertMessage(&messageModel{
+		MessageID:   510,
+		MessageSeq:  10,
+		FromUID:     "10001",
+		ChannelID:   fakeChannelID,
+		ChannelType: channelType,
+		Timestamp:   time.Now().Unix(),
+		Payload:     []byte(util.ToJson(map[string]interface{}{"type": 1, "content": "other"})),
+	})
+	assert.NoError(t, err)
+
+	buff := bytes.NewBuffer(nil)
+	count, err := msg.exporter.exportUserMessages(testutil.UID, buff)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(2), count)
+	var dataMessages []map[string]interface{}
+	err = json.Unmarshal(buff.Bytes(), &dataMessages)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, len(dataMessages))
+	// 单聊消息的频道ID为对方uid
+	assert.Equal(t, "10001", dataMessages[0]["channel_id"])
+	assert.Equal(t, "501", dataMessages[0]["message_id"])
+	assert.Equal(t, "503", dataMessages[1]["message_id"])
+	assert.Nil(t, dataMessages[1]["payload"])
+}
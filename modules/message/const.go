@@ -14,8 +14,13 @@ type ReminderType int
 const (
 	ReminderTypeMentionMe      = 1 // 有人@我
 	ReminderTypeApplyJoinGroup = 2 // 申请加群
+	ReminderTypeThreadReply    = 3 // 参与的话题有新回复
+	ReminderTypeReadNudge      = 4 // 群消息未读催读
 )
 
+// ContentTypePoll 投票消息
+const ContentTypePoll = 21
+
 var sensitive_words = []string{
 	"银行卡",
 	"微信",
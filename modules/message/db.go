@@ -36,6 +36,13 @@ func (d *DB) queryProhibitWordsWithVersion(version int64) ([]*ProhibitWordModel,
 	return list, err
 }
 
+// 通过消息ID查询频道内的消息
+func (d *DB) queryMessageWithMessageID(channelID string, channelType uint8, messageID string) (*messageModel, error) {
+	var m *messageModel
+	_, err := d.session.Select("*").From(d.getTable(channelID)).Where("channel_id=? and channel_type=? and message_id=?", channelID, channelType, messageID).Load(&m)
+	return m, err
+}
+
 // 新增消息
 func (d *DB) insertMessage(m *messageModel) error {
 	_, err := d.session.InsertInto(d.getTable(m.ChannelID)).Columns(util.AttrToUnderscore(m)...).Record(m).Exec()
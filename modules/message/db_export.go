@@ -0,0 +1,187 @@
+package message
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/TangSengDaoDao/TangSengDaoDaoServerLib/config"
+	"github.com/TangSengDaoDao/TangSengDaoDaoServerLib/pkg/db"
+	"github.com/TangSengDaoDao/TangSengDaoDaoServerLib/pkg/util"
+	"github.com/gocraft/dbr/v2"
+)
+
+type exportDB struct {
+	ctx     *config.Context
+	session *dbr.Session
+}
+
+func newExportDB(ctx *config.Context) *exportDB {
+	return &exportDB{
+		ctx:     ctx,
+		session: ctx.DB(),
+	}
+}
+
+func (e *exportDB) insert(m *exportModel) error {
+	_, err := e.session.InsertInto("message_export").Columns(util.AttrToUnderscore(m)...).Record(m).Exec()
+	return err
+}
+
+func (e *exportDB) queryWithExportNo(exportNo string) (*exportModel, error) {
+	var model *exportModel
+	_, err := e.session.Select("*").From("message_export").Where("export_no=?", exportNo).Load(&model)
+	return model, err
+}
+
+// 查询某个用户发起的导出任务
+func (e *exportDB) queryWithUID(uid string, isManager int, limit uint64) ([]*exportModel, error) {
+	var list []*exportModel
+	_, err := e.session.Select("*").From("message_export").Where("uid=? and is_manager=?", uid, isManager).OrderDesc("id").Limit(limit).Load(&list)
+	return list, err
+}
+
+// 分页查询所有导出任务
+func (e *exportDB) queryWithPage(pageSize, page uint64) ([]*exportModel, error) {
+	var list []*exportModel
+	_, err := e.session.Select("*").From("message_export").OrderDesc("id").Offset((page - 1) * pageSize).Limit(pageSize).Load(&list)
+	return list, err
+}
+
+func (e *exportDB) queryCount() (int64, error) {
+	var count int64
+	_, err := e.session.Select("count(*)").From("message_export").Load(&count)
+	return count, err
+}
+
+// 查询某个用户正在进行中的导出任务数量
+func (e *exportDB) queryUnfinishedCount(uid string) (int, error) {
+	var count int
+	_, err := e.session.Select("count(*)").From("message_export").Where("uid=? and status in ?", uid, []int{exportStatusWait, exportStatusRunning}).Load(&count)
+	return count, err
+}
+
+// 查询等待导出的任务编号
+func (e *exportDB) queryWaiting(limit uint64) ([]string, error) {
+	var exportNos []string
+	_, err := e.session.Select("export_no").From("message_export").Where("status=?", exportStatusWait).OrderAsc("id").Limit(limit).Load(&exportNos)
+	return exportNos, err
+}
+
+// 查询导出中超时的任务编号（服务中途重启导致）
+func (e *exportDB) queryStaleRunning(updatedBefore time.Time) ([]string, error) {
+	var exportNos []string
+	_, err := e.session.Select("export_no").From("message_export").Where("status=? and updated_at<?", exportStatusRunning, updatedBefore).Load(&exportNos)
+	return exportNos, err
+}
+
+// 状态从fromStatus改为toStatus 返回影响行数，用于多实例下抢占任务
+func (e *exportDB) updateStatus(exportNo string, fromStatus, toStatus int) (int64, error) {
+	result, err := e.session.Update("message_export").SetMap(map[string]interface{}{
+		"status":     toStatus,
+		"updated_at": dbr.Now,
+	}).Where("export_no=? and status=?", exportNo, fromStatus).Exec()
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+// 更新导出进度（同时刷新更新时间，用于判断任务是否中断）
+func (e *exportDB) updateProgress(exportNo string, total int64, processed int64) error {
+	_, err := e.session.Update("message_export").SetMap(map[string]interface{}{
+		"total":      total,
+		"processed":  processed,
+		"updated_at": dbr.Now,
+	}).Where("export_no=? and status=?", exportNo, exportStatusRunning).Exec()
+	return err
+}
+
+// 刷新导出中任务的更新时间
+func (e *exportDB) touchRunning(exportNo string) error {
+	_, err := e.session.Update("message_export").Set("updated_at", dbr.Now).Where("export_no=? and status=?", exportNo, exportStatusRunning).Exec()
+	return err
+}
+
+// 导出完成（任务仍在导出中时才更新）返回影响行数
+func (e *exportDB) updateDone(exportNo string, filePath string, fileSize int64) (int64, error) {
+	result, err := e.session.Update("message_export").SetMap(map[string]interface{}{
+		"status":      exportStatusDone,
+		"file_path":   filePath,
+		"file_size":   fileSize,
+		"finished_at": time.Now().Unix(),
+		"updated_at":  dbr.Now,
+	}).Where("export_no=? and status=?", exportNo, exportStatusRunning).Exec()
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+// 导出失败（任务仍在导出中时才更新）返回影响行数
+func (e *exportDB) updateFail(exportNo string, reason string) (int64, error) {
+	result, err := e.session.Update("message_export").SetMap(map[string]interface{}{
+		"status":      exportStatusFail,
+		"fail_reason": reason,
+		"finished_at": time.Now().Unix(),
+		"updated_at":  dbr.Now,
+	}).Where("export_no=? and status=?", exportNo, exportStatusRunning).Exec()
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+// 查询频道内大于指定序号的消息数量
+func (e *exportDB) queryMessageCountAfterSeq(channelID string, channelType uint8, messageSeq uint32) (int64, error) {
+	var count int64
+	_, err := e.session.Select("count(*)").From(e.getMessageTable(channelID)).Where("channel_id=? and channel_type=? and message_seq>?", channelID, channelType, messageSeq).Load(&count)
+	return count, err
+}
+
+// 按消息序号升序查询频道内的消息
+func (e *exportDB) queryMessagesAfterSeq(channelID string, channelType uint8, messageSeq uint32, limit uint64) ([]*messageModel, error) {
+	var list []*messageModel
+	_, err := e.session.Select("*").From(e.getMessageTable(channelID)).Where("channel_id=? and channel_type=? and message_seq>?", channelID, channelType, messageSeq).OrderAsc("message_seq").Limit(limit).Load(&list)
+	return list, err
+}
+
+// 按id升序查询某个分表中用户发送的消息
+func (e *exportDB) queryMessagesWithFromUID(table string, fromUID string, lastID int64, limit uint64) ([]*messageModel, error) {
+	var list []*messageModel
+	_, err := e.session.Select("*").From(table).Where("from_uid=? and id>?", fromUID, lastID).OrderAsc("id").Limit(limit).Load(&list)
+	return list, err
+}
+
+// 消息分表
+func (e *exportDB) getMessageTables() []string {
+	count := int(e.ctx.GetConfig().TablePartitionConfig.MessageTableCount)
+	tables := make([]string, 0, count)
+	for i := 0; i < count; i++ {
+		if i == 0 {
+			tables = append(tables, "message")
+			continue
+		}
+		tables = append(tables, fmt.Sprintf("message%d", i))
+	}
+	return tables
+}
+
+func (e *exportDB) getMessageTable(channelID string) string {
+	return NewDB(e.ctx).getTable(channelID)
+}
+
+type exportModel struct {
+	ExportNo    string
+	UID         string
+	IsManager   int
+	ChannelID   string
+	ChannelType uint8
+	Status      int
+	Total       int64
+	Processed   int64
+	FilePath    string
+	FileSize    int64
+	FailReason  string
+	FinishedAt  int64
+	db.BaseModel
+}
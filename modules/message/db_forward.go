@@ -0,0 +1,105 @@
+package message
+
+import (
+	"github.com/TangSengDaoDao/TangSengDaoDaoServerLib/config"
+	"github.com/TangSengDaoDao/TangSengDaoDaoServerLib/pkg/db"
+	"github.com/TangSengDaoDao/TangSengDaoDaoServerLib/pkg/util"
+	"github.com/gocraft/dbr/v2"
+)
+
+type forwardDB struct {
+	ctx     *config.Context
+	session *dbr.Session
+	db      *DB
+}
+
+func newForwardDB(ctx *config.Context) *forwardDB {
+	return &forwardDB{
+		ctx:     ctx,
+		session: ctx.DB(),
+		db:      NewDB(ctx),
+	}
+}
+
+func (f *forwardDB) insertTx(m *forwardBundleModel, messages []*forwardBundleMessageModel, tx *dbr.Tx) error {
+	_, err := tx.InsertInto("forward_bundle").Columns(util.AttrToUnderscore(m)...).Record(m).Exec()
+	if err != nil {
+		return err
+	}
+	for _, message := range messages {
+		_, err = tx.InsertInto("forward_bundle_message").Columns(util.AttrToUnderscore(message)...).Record(message).Exec()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (f *forwardDB) queryWithBundleNo(bundleNo string) (*forwardBundleModel, error) {
+	var m *forwardBundleModel
+	_, err := f.session.Select("*").From("forward_bundle").Where("bundle_no=?", bundleNo).Load(&m)
+	return m, err
+}
+
+func (f *forwardDB) queryMessages(bundleNo string) ([]*forwardBundleMessageModel, error) {
+	var list []*forwardBundleMessageModel
+	_, err := f.session.Select("*").From("forward_bundle_message").Where("bundle_no=?", bundleNo).OrderAsc("id").Load(&list)
+	return list, err
+}
+
+// 合并转发中是否包含另一个合并转发
+func (f *forwardDB) existRef(bundleNo string, refBundleNo string) (bool, error) {
+	var count int
+	_, err := f.session.Select("count(*)").From("forward_bundle_message").Where("bundle_no=? and ref_bundle_no=?", bundleNo, refBundleNo).Load(&count)
+	return count > 0, err
+}
+
+func (f *forwardDB) updateMessage(bundleNo string, messageID string, messageSeq uint32) error {
+	_, err := f.session.Update("forward_bundle").SetMap(map[string]interface{}{
+		"message_id":  messageID,
+		"message_seq": messageSeq,
+		"updated_at":  dbr.Now,
+	}).Where("bundle_no=?", bundleNo).Exec()
+	return err
+}
+
+func (f *forwardDB) delete(bundleNo string) error {
+	_, err := f.session.DeleteFrom("forward_bundle_message").Where("bundle_no=?", bundleNo).Exec()
+	if err != nil {
+		return err
+	}
+	_, err = f.session.DeleteFrom("forward_bundle").Where("bundle_no=?", bundleNo).Exec()
+	return err
+}
+
+// 查询频道内指定的消息
+func (f *forwardDB) querySourceMessages(channelID string, channelType uint8, messageIDs []string) ([]*messageModel, error) {
+	var list []*messageModel
+	_, err := f.session.Select("*").From(f.db.getTable(channelID)).Where("channel_id=? and channel_type=? and message_id in ?", channelID, channelType, messageIDs).OrderAsc("message_seq").Load(&list)
+	return list, err
+}
+
+type forwardBundleModel struct {
+	BundleNo          string
+	UID               string
+	SourceChannelID   string
+	SourceChannelType uint8
+	ToChannelID       string
+	ToChannelType     uint8
+	MessageID         string
+	MessageSeq        uint32
+	MessageCount      int
+	db.BaseModel
+}
+
+type forwardBundleMessageModel struct {
+	BundleNo    string
+	MessageID   string
+	MessageSeq  uint32
+	FromUID     string
+	Timestamp   int64
+	Payload     []byte
+	Edited      int
+	RefBundleNo string
+	db.BaseModel
+}
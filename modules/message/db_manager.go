@@ -76,6 +76,7 @@ func (m *managerDB) updateProhibitWord(word *prohibitWordsModel) error {
 	_, err := m.session.Update("prohibit_words").SetMap(map[string]interface{}{
 		"version":    word.Version,
 		"is_deleted": word.IsDeleted,
+		"action":     word.Action,
 	}).Where("content=?", word.Content).Exec()
 	return err
 }
@@ -116,6 +117,7 @@ type prohibitWordsModel struct {
 	Content   string
 	IsDeleted int
 	Version   int64
+	Action    int // 命中处理方式 1.替换 2.拦截 3.审核
 	db.BaseModel
 }
 
@@ -82,6 +82,13 @@ func (m *memberReadedDB) queryWithMessageIDAndPage(messageID string, pIndex, pSi
 	return models, err
 }
 
+// 查询消息的所有已读成员uid
+func (m *memberReadedDB) queryUIDsWithMessageID(messageID string) ([]string, error) {
+	var uids []string
+	_, err := m.session.Select("uid").From("member_readed").Where("message_id=?", messageID).Load(&uids)
+	return uids, err
+}
+
 type memberReadedModel struct {
 	CloneNo     string // TODO: 此字段作废
 	MessageID   int64
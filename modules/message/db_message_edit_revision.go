@@ -0,0 +1,51 @@
+package message
+
+import (
+	"github.com/TangSengDaoDao/TangSengDaoDaoServerLib/config"
+	"github.com/TangSengDaoDao/TangSengDaoDaoServerLib/pkg/db"
+	"github.com/TangSengDaoDao/TangSengDaoDaoServerLib/pkg/util"
+	"github.com/gocraft/dbr/v2"
+)
+
+type messageEditRevisionDB struct {
+	ctx     *config.Context
+	session *dbr.Session
+}
+
+func newMessageEditRevisionDB(ctx *config.Context) *messageEditRevisionDB {
+	return &messageEditRevisionDB{
+		ctx:     ctx,
+		session: ctx.DB(),
+	}
+}
+
+// 查询消息当前最大的修订号（锁定，防止并发编辑产生相同修订号）
+func (m *messageEditRevisionDB) queryMaxRevisionForUpdateTx(messageID string, tx *dbr.Tx) (int, error) {
+	var revision int
+	err := tx.SelectBySql("select IFNULL(max(revision),0) from message_edit_revision where message_id=? for update", messageID).LoadOne(&revision)
+	return revision, err
+}
+
+func (m *messageEditRevisionDB) insertTx(md *messageEditRevisionModel, tx *dbr.Tx) error {
+	_, err := tx.InsertInto("message_edit_revision").Columns(util.AttrToUnderscore(md)...).Record(md).Exec()
+	return err
+}
+
+func (m *messageEditRevisionDB) queryWithMessageID(messageID string) ([]*messageEditRevisionModel, error) {
+	var list []*messageEditRevisionModel
+	_, err := m.session.Select("*").From("message_edit_revision").Where("message_id=?", messageID).OrderAsc("revision").Load(&list)
+	return list, err
+}
+
+type messageEditRevisionModel struct {
+	MessageID       string
+	MessageSeq      uint32
+	ChannelID       string
+	ChannelType     uint8
+	Revision        int
+	ContentEdit     dbr.NullString // 编辑后的正文
+	ContentEditHash string
+	EditorUID       string // 编辑者uid
+	EditedAt        int    // 编辑时间 时间戳（秒）
+	db.BaseModel
+}
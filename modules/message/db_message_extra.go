@@ -46,6 +46,11 @@ func (m *messageExtraDB) insertOrUpdatePinnedTx(md *messageExtraModel, tx *dbr.T
 	return err
 }
 
+func (m *messageExtraDB) insertOrUpdatePollResultTx(md *messageExtraModel, tx *dbr.Tx) error {
+	_, err := tx.InsertBySql("INSERT INTO message_extra (message_id,message_seq,from_uid,channel_id,channel_type,poll_result,version) VALUES (?,?,?,?,?,?,?) ON DUPLICATE KEY UPDATE poll_result=VALUES(poll_result),version=VALUES(version)", md.MessageID, md.MessageSeq, md.FromUID, md.ChannelID, md.ChannelType, md.PollResult, md.Version).Exec()
+	return err
+}
+
 func (m *messageExtraDB) insertOrUpdateDeleted(md *messageExtraModel) error {
 	_, err := m.session.InsertBySql("INSERT INTO message_extra (message_id,message_seq,channel_id,channel_type,is_deleted,version) VALUES (?,?,?,?,?,?) ON DUPLICATE KEY UPDATE is_deleted=VALUES(is_deleted),version=VALUES(version)", md.MessageID, md.MessageSeq, md.ChannelID, md.ChannelType, md.IsDeleted, md.Version).Exec()
 	return err
@@ -145,7 +150,8 @@ type messageExtraModel struct {
 	ContentEditHash string
 	EditedAt        int // 编辑时间 时间戳（秒）
 	IsDeleted       int
-	Version         int64 // 数据版本
-	IsPinned        int   // 是否置顶
+	Version         int64          // 数据版本
+	IsPinned        int            // 是否置顶
+	PollResult      dbr.NullString // 投票结果
 	db.BaseModel
 }
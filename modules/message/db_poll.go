@@ -0,0 +1,116 @@
+package message
+
+import (
+	"github.com/TangSengDaoDao/TangSengDaoDaoServerLib/config"
+	"github.com/TangSengDaoDao/TangSengDaoDaoServerLib/pkg/db"
+	"github.com/TangSengDaoDao/TangSengDaoDaoServerLib/pkg/util"
+	"github.com/gocraft/dbr/v2"
+)
+
+type pollDB struct {
+	ctx     *config.Context
+	session *dbr.Session
+}
+
+func newPollDB(ctx *config.Context) *pollDB {
+	return &pollDB{
+		ctx:     ctx,
+		session: ctx.DB(),
+	}
+}
+
+func (p *pollDB) insert(m *pollModel) error {
+	_, err := p.session.InsertInto("poll").Columns(util.AttrToUnderscore(m)...).Record(m).Exec()
+	return err
+}
+
+func (p *pollDB) queryWithPollNo(pollNo string) (*pollModel, error) {
+	var m *pollModel
+	_, err := p.session.Select("*").From("poll").Where("poll_no=?", pollNo).Load(&m)
+	return m, err
+}
+
+// 锁定投票，同一个投票的计票串行执行
+func (p *pollDB) queryWithPollNoForUpdateTx(pollNo string, tx *dbr.Tx) (*pollModel, error) {
+	var m *pollModel
+	_, err := tx.SelectBySql("select * from poll where poll_no=? for update", pollNo).Load(&m)
+	return m, err
+}
+
+// 查询已到截止时间但还未结束的投票
+func (p *pollDB) queryExpired(now int64, limit uint64) ([]*pollModel, error) {
+	var list []*pollModel
+	_, err := p.session.Select("*").From("poll").Where("status=? and deadline>0 and deadline<=? and message_id<>''", pollStatusOpen, now).OrderAsc("deadline").Limit(limit).Load(&list)
+	return list, err
+}
+
+func (p *pollDB) updateMessage(pollNo string, messageID string, messageSeq uint32) error {
+	_, err := p.session.Update("poll").SetMap(map[string]interface{}{
+		"message_id":  messageID,
+		"message_seq": messageSeq,
+		"updated_at":  dbr.Now,
+	}).Where("poll_no=?", pollNo).Exec()
+	return err
+}
+
+func (p *pollDB) updateClosedTx(pollNo string, closedBy string, closedAt int64, tx *dbr.Tx) error {
+	_, err := tx.Update("poll").SetMap(map[string]interface{}{
+		"status":     pollStatusClosed,
+		"closed_by":  closedBy,
+		"closed_at":  closedAt,
+		"updated_at": dbr.Now,
+	}).Where("poll_no=?", pollNo).Exec()
+	return err
+}
+
+func (p *pollDB) delete(pollNo string) error {
+	_, err := p.session.DeleteFrom("poll").Where("poll_no=?", pollNo).Exec()
+	return err
+}
+
+func (p *pollDB) deleteVotesWithUIDTx(pollNo string, uid string, tx *dbr.Tx) error {
+	_, err := tx.DeleteFrom("poll_vote").Where("poll_no=? and uid=?", pollNo, uid).Exec()
+	return err
+}
+
+func (p *pollDB) insertVoteTx(m *pollVoteModel, tx *dbr.Tx) error {
+	_, err := tx.InsertInto("poll_vote").Columns(util.AttrToUnderscore(m)...).Record(m).Exec()
+	return err
+}
+
+func (p *pollDB) queryVotesTx(pollNo string, tx *dbr.Tx) ([]*pollVoteModel, error) {
+	var list []*pollVoteModel
+	_, err := tx.Select("*").From("poll_vote").Where("poll_no=?", pollNo).OrderAsc("id").Load(&list)
+	return list, err
+}
+
+func (p *pollDB) queryVotes(pollNo string) ([]*pollVoteModel, error) {
+	var list []*pollVoteModel
+	_, err := p.session.Select("*").From("poll_vote").Where("poll_no=?", pollNo).OrderAsc("id").Load(&list)
+	return list, err
+}
+
+type pollModel struct {
+	PollNo      string
+	MessageID   string
+	MessageSeq  uint32
+	ChannelID   string
+	ChannelType uint8
+	Creator     string
+	Question    string
+	Options     string // 投票选项 json数组
+	Multiple    int    // 是否多选
+	Anonymous   int    // 是否匿名
+	Deadline    int64  // 截止时间
+	Status      int
+	ClosedBy    string
+	ClosedAt    int64
+	db.BaseModel
+}
+
+type pollVoteModel struct {
+	PollNo   string
+	UID      string
+	OptionID int
+	db.BaseModel
+}
@@ -0,0 +1,416 @@
+package message
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/TangSengDaoDao/TangSengDaoDaoServerLib/config"
+	"github.com/TangSengDaoDao/TangSengDaoDaoServerLib/pkg/db"
+	"github.com/TangSengDaoDao/TangSengDaoDaoServerLib/pkg/util"
+	"github.com/gocraft/dbr/v2"
+)
+
+type retentionDB struct {
+	ctx     *config.Context
+	session *dbr.Session
+}
+
+func newRetentionDB(ctx *config.Context) *retentionDB {
+	return &retentionDB{
+		ctx:     ctx,
+		session: ctx.DB(),
+	}
+}
+
+// -------------------- 保留策略 --------------------
+
+func (r *retentionDB) insertPolicy(m *retentionPolicyModel) error {
+	_, err := r.session.InsertInto("message_retention_policy").Columns(util.AttrToUnderscore(m)...).Record(m).Exec()
+	return err
+}
+
+func (r *retentionDB) queryPolicyWithID(id int64) (*retentionPolicyModel, error) {
+	var m *retentionPolicyModel
+	_, err := r.session.Select("*").From("message_retention_policy").Where("id=?", id).Load(&m)
+	return m, err
+}
+
+func (r *retentionDB) queryPolicyWithTarget(scope int, channelType uint8, category string, channelID string) (*retentionPolicyModel, error) {
+	var m *retentionPolicyModel
+	_, err := r.session.Select("*").From("message_retention_policy").Where("scope=? and channel_type=? and category=? and channel_id=?", scope, channelType, category, channelID).Load(&m)
+	return m, err
+}
+
+func (r *retentionDB) queryPolicies() ([]*retentionPolicyModel, error) {
+	var list []*retentionPolicyModel
+	_, err := r.session.Select("*").From("message_retention_policy").OrderAsc("scope", "id").Load(&list)
+	return list, err
+}
+
+func (r *retentionDB) queryEnabledPolicies() ([]*retentionPolicyModel, error) {
+	var list []*retentionPolicyModel
+	_, err := r.session.Select("*").From("message_retention_policy").Where("status=1 and retention_second>0").Load(&list)
+	return list, err
+}
+
+func (r *retentionDB) updatePolicy(m *retentionPolicyModel) error {
+	_, err := r.session.Update("message_retention_policy").SetMap(map[string]interface{}{
+		"retention_second": m.RetentionSecond,
+		"status":           m.Status,
+		"remark":           m.Remark,
+		"updated_at":       dbr.Now,
+	}).Where("id=?", m.Id).Exec()
+	return err
+}
+
+func (r *retentionDB) deletePolicy(id int64) error {
+	_, err := r.session.DeleteFrom("message_retention_policy").Where("id=?", id).Exec()
+	return err
+}
+
+// -------------------- 法律保全 --------------------
+
+func (r *retentionDB) insertHold(m *legalHoldModel) error {
+	_, err := r.session.InsertInto("message_legal_hold").Columns(util.AttrToUnderscore(m)...).Record(m).Exec()
+	return err
+}
+
+func (r *retentionDB) queryHoldWithID(id int64) (*legalHoldModel, error) {
+	var m *legalHoldModel
+	_, err := r.session.Select("*").From("message_legal_hold").Where("id=?", id).Load(&m)
+	return m, err
+}
+
+func (r *retentionDB) queryActiveHolds() ([]*legalHoldModel, error) {
+	var list []*legalHoldModel
+	_, err := r.session.Select("*").From("message_legal_hold").Where("status=?", legalHoldStatusActive).Load(&list)
+	return list, err
+}
+
+// status小于0时查询全部
+func (r *retentionDB) queryHoldsWithPage(status int, pageSize, page uint64) ([]*legalHoldModel, error) {
+	var list []*legalHoldModel
+	builder := r.session.Select("*").From("message_legal_hold")
+	if status >= 0 {
+		builder = builder.Where("status=?", status)
+	}
+	_, err := builder.OrderDesc("id").Offset((page - 1) * pageSize).Limit(pageSize).Load(&list)
+	return list, err
+}
+
+func (r *retentionDB) queryHoldCount(status int) (int64, error) {
+	var count int64
+	builder := r.session.Select("count(*)").From("message_legal_hold")
+	if status >= 0 {
+		builder = builder.Where("status=?", status)
+	}
+	_, err := builder.Load(&count)
+	return count, err
+}
+
+func (r *retentionDB) updateHoldReleased(id int64, releasedBy string) (int64, error) {
+	result, err := r.session.Update("message_legal_hold").SetMap(map[string]interface{}{
+		"status":      legalHoldStatusReleased,
+		"released_by": releasedBy,
+		"released_at": time.Now().Unix(),
+		"updated_at":  dbr.Now,
+	}).Where("id=? and status=?", id, legalHoldStatusActive).Exec()
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+// -------------------- 清理报告 --------------------
+
+func (r *retentionDB) insertReport(m *purgeReportModel) error {
+	_, err := r.session.InsertInto("message_purge_report").Columns(util.AttrToUnderscore(m)...).Record(m).Exec()
+	return err
+}
+
+func (r *retentionDB) queryReportWithReportNo(reportNo string) (*purgeReportModel, error) {
+	var m *purgeReportModel
+	_, err := r.session.Select("*").From("message_purge_report").Where("report_no=?", reportNo).Load(&m)
+	return m, err
+}
+
+func (r *retentionDB) queryReportsWithPage(pageSize, page uint64) ([]*purgeReportModel, error) {
+	var list []*purgeReportModel
+	_, err := r.session.Select("*").From("message_purge_report").OrderDesc("id").Offset((page - 1) * pageSize).Limit(pageSize).Load(&list)
+	return list, err
+}
+
+func (r *retentionDB) queryReportCount() (int64, error) {
+	var count int64
+	_, err := r.session.Select("count(*)").From("message_purge_report").Load(&count)
+	return count, err
+}
+
+func (r *retentionDB) queryWaitingReports(limit uint64) ([]*purgeReportModel, error) {
+	var list []*purgeReportModel
+	_, err := r.session.Select("*").From("message_purge_report").Where("status=?", purgeStatusWait).OrderAsc("id").Limit(limit).Load(&list)
+	return list, err
+}
+
+// 查询执行中超时的清理（服务中途重启导致）
+func (r *retentionDB) queryStaleRunningReports(updatedBefore time.Time) ([]*purgeReportModel, error) {
+	var list []*purgeReportModel
+	_, err := r.session.Select("*").From("message_purge_report").Where("status=? and updated_at<?", purgeStatusRunning, updatedBefore).Load(&list)
+	return list, err
+}
+
+// 状态从fromStatus改为toStatus 返回影响行数，用于多实例下抢占任务
+func (r *retentionDB) updateReportStatus(reportNo string, fromStatus, toStatus int) (int64, error) {
+	setMap := map[string]interface{}{
+		"status":     toStatus,
+		"updated_at": dbr.Now,
+	}
+	if toStatus == purgeStatusRunning {
+		setMap["started_at"] = time.Now().Unix()
+	}
+	result, err := r.session.Update("message_purge_report").SetMap(setMap).Where("report_no=? and status=?", reportNo, fromStatus).Exec()
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+// 更新清理进度（同时刷新更新时间，用于判断任务是否中断）
+func (r *retentionDB) updateReportProgress(m *purgeReportModel) error {
+	_, err := r.session.Update("message_purge_report").SetMap(map[string]interface{}{
+		"policy_count":  m.PolicyCount,
+		"hold_count":    m.HoldCount,
+		"channel_count": m.ChannelCount,
+		"message_count": m.MessageCount,
+		"held_count":    m.HeldCount,
+		"extra_count":   m.ExtraCount,
+		"updated_at":    dbr.Now,
+	}).Where("report_no=?", m.ReportNo).Exec()
+	return err
+}
+
+func (r *retentionDB) updateReportDone(reportNo string) error {
+	_, err := r.session.Update("message_purge_report").SetMap(map[string]interface{}{
+		"status":      purgeStatusDone,
+		"finished_at": time.Now().Unix(),
+		"updated_at":  dbr.Now,
+	}).Where("report_no=?", reportNo).Exec()
+	return err
+}
+
+func (r *retentionDB) updateReportFail(reportNo string, reason string) error {
+	_, err := r.session.Update("message_purge_report").SetMap(map[string]interface{}{
+		"status":      purgeStatusFail,
+		"fail_reason": reason,
+		"finished_at": time.Now().Unix(),
+		"updated_at":  dbr.Now,
+	}).Where("report_no=?", reportNo).Exec()
+	return err
+}
+
+func (r *retentionDB) insertReportItem(m *purgeReportItemModel) error {
+	_, err := r.session.InsertInto("message_purge_report_item").Columns(util.AttrToUnderscore(m)...).Record(m).Exec()
+	return err
+}
+
+func (r *retentionDB) queryReportItemsWithPage(reportNo string, pageSize, page uint64) ([]*purgeReportItemModel, error) {
+	var list []*purgeReportItemModel
+	_, err := r.session.Select("*").From("message_purge_report_item").Where("report_no=?", reportNo).OrderAsc("id").Offset((page - 1) * pageSize).Limit(pageSize).Load(&list)
+	return list, err
+}
+
+func (r *retentionDB) queryReportItemCount(reportNo string) (int64, error) {
+	var count int64
+	_, err := r.session.Select("count(*)").From("message_purge_report_item").Where("report_no=?", reportNo).Load(&count)
+	return count, err
+}
+
+// -------------------- 过期消息 --------------------
+
+// 查询消息表内存在早于cutoff的消息的频道
+func (r *retentionDB) queryExpiredChannels(table string, cutoff int64) ([]*purgeChannelModel, error) {
+	var list []*purgeChannelModel
+	_, err := r.session.Select("distinct channel_id,channel_type").From(table).Where("timestamp<?", cutoff).Load(&list)
+	return list, err
+}
+
+// 统计频道内早于cutoff的消息，excludeUIDs的消息不统计
+func (r *retentionDB) queryExpiredStat(table string, channelID string, channelType uint8, cutoff int64, excludeUIDs []string) (*purgeStatModel, error) {
+	var m *purgeStatModel
+	builder := r.session.Select("count(*) message_count,IFNULL(min(message_seq),0) min_message_seq,IFNULL(max(message_seq),0) max_message_seq,IFNULL(min(timestamp),0) min_timestamp,IFNULL(max(timestamp),0) max_timestamp").From(table).Where("channel_id=? and channel_type=? and timestamp<?", channelID, channelType, cutoff)
+	if len(excludeUIDs) > 0 {
+		builder = builder.Where("from_uid not in ?", excludeUIDs)
+	}
+	_, err := builder.Load(&m)
+	return m, err
+}
+
+// 统计频道内早于cutoff且发送者为uids的消息数量
+func (r *retentionDB) queryExpiredCountWithUIDs(table string, channelID string, channelType uint8, cutoff int64, uids []string) (int64, error) {
+	var count int64
+	builder := r.session.Select("count(*)").From(table).Where("channel_id=? and channel_type=? and timestamp<?", channelID, channelType, cutoff)
+	if len(uids) > 0 {
+		builder = builder.Where("from_uid in ?", uids)
+	}
+	_, err := builder.Load(&count)
+	return count, err
+}
+
+func (r *retentionDB) queryExpiredMessages(table string, channelID string, channelType uint8, cutoff int64, excludeUIDs []string, limit uint64) ([]*purgeMessageModel, error) {
+	var list []*purgeMessageModel
+	builder := r.session.Select("id,message_id").From(table).Where("channel_id=? and channel_type=? and timestamp<?", channelID, channelType, cutoff)
+	if len(excludeUIDs) > 0 {
+		builder = builder.Where("from_uid not in ?", excludeUIDs)
+	}
+	_, err := builder.OrderAsc("id").Limit(limit).Load(&list)
+	return list, err
+}
+
+// 删除消息及其关联数据 返回删除的消息数量和关联数据数量
+func (r *retentionDB) deleteMessagesTx(table string, ids []int64, messageIDs []string, tx *dbr.Tx) (int64, int64, error) {
+	var extraCount int64
+	deleteFrom := func(table string, where string, args ...interface{}) error {
+		result, err := tx.DeleteFrom(table).Where(where, args...).Exec()
+		if err != nil {
+			return err
+		}
+		rows, err := result.RowsAffected()
+		if err != nil {
+			return err
+		}
+		extraCount += rows
+		return nil
+	}
+	// 投票记录需要在投票之前删除
+	err := deleteFrom("poll_vote", "poll_no in (select poll_no from poll where message_id in ?)", messageIDs)
+	if err != nil {
+		return 0, 0, err
+	}
+	// 随消息删除合并转发（转发时的消息快照）
+	err = deleteFrom("forward_bundle_message", "bundle_no in (select bundle_no from forward_bundle where message_id in ?)", messageIDs)
+	if err != nil {
+		return 0, 0, err
+	}
+	extraTables := []string{"message_extra", "reaction_users", "member_readed", "pinned_message", "reminders", "message_edit_revision", "poll", "message_search_doc", "message_search_token", "forward_bundle"}
+	extraTables = append(extraTables, r.getMessageUserExtraTables()...)
+	for _, extraTable := range extraTables {
+		if err = deleteFrom(extraTable, "message_id in ?", messageIDs); err != nil {
+			return 0, 0, err
+		}
+	}
+	result, err := tx.DeleteFrom(table).Where("id in ?", ids).Exec()
+	if err != nil {
+		return 0, 0, err
+	}
+	messageCount, err := result.RowsAffected()
+	if err != nil {
+		return 0, 0, err
+	}
+	return messageCount, extraCount, nil
+}
+
+// 消息分表
+func (r *retentionDB) getMessageTables() []string {
+	count := int(r.ctx.GetConfig().TablePartitionConfig.MessageTableCount)
+	tables := make([]string, 0, count)
+	for i := 0; i < count; i++ {
+		if i == 0 {
+			tables = append(tables, "message")
+			continue
+		}
+		tables = append(tables, fmt.Sprintf("message%d", i))
+	}
+	return tables
+}
+
+func (r *retentionDB) getMessageUserExtraTables() []string {
+	count := int(r.ctx.GetConfig().TablePartitionConfig.MessageUserEditTableCount)
+	tables := make([]string, 0, count)
+	for i := 0; i < count; i++ {
+		if i == 0 {
+			tables = append(tables, "message_user_extra")
+			continue
+		}
+		tables = append(tables, fmt.Sprintf("message_user_extra%d", i))
+	}
+	return tables
+}
+
+type retentionPolicyModel struct {
+	Scope           int
+	ChannelType     uint8
+	Category        string
+	ChannelID       string
+	RetentionSecond int64
+	Status          int
+	Remark          string
+	CreatedBy       string
+	db.BaseModel
+}
+
+type legalHoldModel struct {
+	TargetType  int
+	ChannelID   string
+	ChannelType uint8
+	UID         string
+	Reason      string
+	Status      int
+	CreatedBy   string
+	ReleasedBy  string
+	ReleasedAt  int64
+	db.BaseModel
+}
+
+type purgeReportModel struct {
+	ReportNo     string
+	TriggerType  int
+	Operator     string
+	DryRun       int
+	Status       int
+	PolicyCount  int
+	HoldCount    int
+	ChannelCount int
+	MessageCount int64
+	HeldCount    int64
+	ExtraCount   int64
+	FailReason   string
+	StartedAt    int64
+	FinishedAt   int64
+	db.BaseModel
+}
+
+type purgeReportItemModel struct {
+	ReportNo      string
+	PolicyID      int64
+	ChannelID     string
+	ChannelType   uint8
+	Cutoff        int64
+	Held          int
+	HeldUids      string // 被保全的发送者uid json数组
+	MessageCount  int64
+	HeldCount     int64
+	ExtraCount    int64
+	MinMessageSeq uint32
+	MaxMessageSeq uint32
+	MinTimestamp  int64
+	MaxTimestamp  int64
+	db.BaseModel
+}
+
+type purgeChannelModel struct {
+	ChannelID   string
+	ChannelType uint8
+}
+
+type purgeStatModel struct {
+	MessageCount  int64
+	MinMessageSeq uint32
+	MaxMessageSeq uint32
+	MinTimestamp  int64
+	MaxTimestamp  int64
+}
+
+type purgeMessageModel struct {
+	Id        int64
+	MessageID string
+}
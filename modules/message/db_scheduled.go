@@ -0,0 +1,123 @@
+package message
+
+import (
+	"time"
+
+	"github.com/TangSengDaoDao/TangSengDaoDaoServerLib/config"
+	"github.com/TangSengDaoDao/TangSengDaoDaoServerLib/pkg/db"
+	"github.com/TangSengDaoDao/TangSengDaoDaoServerLib/pkg/util"
+	"github.com/gocraft/dbr/v2"
+)
+
+type scheduledMessageDB struct {
+	ctx     *config.Context
+	session *dbr.Session
+}
+
+func newScheduledMessageDB(ctx *config.Context) *scheduledMessageDB {
+	return &scheduledMessageDB{
+		ctx:     ctx,
+		session: ctx.DB(),
+	}
+}
+
+func (s *scheduledMessageDB) insert(m *scheduledMessageModel) (int64, error) {
+	result, err := s.session.InsertInto("scheduled_message").Columns(util.AttrToUnderscore(m)...).Record(m).Exec()
+	if err != nil {
+		return 0, err
+	}
+	return result.LastInsertId()
+}
+
+func (s *scheduledMessageDB) queryWithID(id int64) (*scheduledMessageModel, error) {
+	var model *scheduledMessageModel
+	_, err := s.session.Select("*").From("scheduled_message").Where("id=?", id).Load(&model)
+	return model, err
+}
+
+// 查询某个发送者在某频道内待发送的定时消息
+func (s *scheduledMessageDB) queryPendingWithChannel(fromUID string, channelID string, channelType uint8) ([]*scheduledMessageModel, error) {
+	var list []*scheduledMessageModel
+	_, err := s.session.Select("*").From("scheduled_message").Where("from_uid=? and channel_id=? and channel_type=? and status=?", fromUID, channelID, channelType, scheduledStatusWait).OrderAsc("send_at").Load(&list)
+	return list, err
+}
+
+// 查询已到发送时间的定时消息
+func (s *scheduledMessageDB) queryDue(now int64, limit uint64) ([]*scheduledMessageModel, error) {
+	var list []*scheduledMessageModel
+	_, err := s.session.Select("*").From("scheduled_message").Where("status=? and send_at<=?", scheduledStatusWait, now).OrderAsc("send_at").Limit(limit).Load(&list)
+	return list, err
+}
+
+// 查询发送中超时的定时消息（服务中途重启导致）
+func (s *scheduledMessageDB) queryStaleSending(updatedBefore time.Time) ([]*scheduledMessageModel, error) {
+	var list []*scheduledMessageModel
+	_, err := s.session.Select("*").From("scheduled_message").Where("status=? and updated_at<?", scheduledStatusSending, updatedBefore).Load(&list)
+	return list, err
+}
+
+func (s *scheduledMessageDB) updatePayloadAndSendAt(id int64, payload string, sendAt int64) (int64, error) {
+	result, err := s.session.Update("scheduled_message").SetMap(map[string]interface{}{
+		"payload": payload,
+		"send_at": sendAt,
+	}).Where("id=? and status=?", id, scheduledStatusWait).Exec()
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+// 状态从fromStatus改为toStatus 返回影响行数，用于多实例下抢占任务
+func (s *scheduledMessageDB) updateStatus(id int64, fromStatus, toStatus int) (int64, error) {
+	result, err := s.session.Update("scheduled_message").SetMap(map[string]interface{}{
+		"status":     toStatus,
+		"updated_at": dbr.Now,
+	}).Where("id=? and status=?", id, fromStatus).Exec()
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+func (s *scheduledMessageDB) updateSent(id int64, messageID string, sentAt int64) error {
+	_, err := s.session.Update("scheduled_message").SetMap(map[string]interface{}{
+		"status":     scheduledStatusSent,
+		"message_id": messageID,
+		"sent_at":    sentAt,
+	}).Where("id=?", id).Exec()
+	return err
+}
+
+// 发送中的消息改为发送失败 返回影响行数
+func (s *scheduledMessageDB) updateSendingToFail(id int64, reason string) (int64, error) {
+	result, err := s.session.Update("scheduled_message").SetMap(map[string]interface{}{
+		"status":      scheduledStatusFail,
+		"fail_reason": reason,
+	}).Where("id=? and status=?", id, scheduledStatusSending).Exec()
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+func (s *scheduledMessageDB) updateFail(id int64, reason string) error {
+	_, err := s.session.Update("scheduled_message").SetMap(map[string]interface{}{
+		"status":      scheduledStatusFail,
+		"fail_reason": reason,
+	}).Where("id=?", id).Exec()
+	return err
+}
+
+type scheduledMessageModel struct {
+	FromUID     string
+	FromRobot   int
+	ChannelID   string
+	ChannelType uint8
+	Payload     string
+	SendAt      int64
+	Status      int
+	FailReason  string
+	MessageID   string
+	SentAt      int64
+	db.BaseModel
+}
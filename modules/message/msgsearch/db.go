@@ -0,0 +1,228 @@
+package msgsearch
+
+import (
+	"fmt"
+	"hash/crc32"
+	"strings"
+
+	"github.com/TangSengDaoDao/TangSengDaoDaoServerLib/common"
+	"github.com/TangSengDaoDao/TangSengDaoDaoServerLib/config"
+	"github.com/TangSengDaoDao/TangSengDaoDaoServerLib/pkg/db"
+	"github.com/gocraft/dbr/v2"
+)
+
+type searchDB struct {
+	ctx     *config.Context
+	session *dbr.Session
+}
+
+func newSearchDB(ctx *config.Context) *searchDB {
+	return &searchDB{
+		ctx:     ctx,
+		session: ctx.DB(),
+	}
+}
+
+// 锁定并查询消息表的索引进度（不存在则创建）
+func (s *searchDB) queryOffsetForUpdateTx(tableName string, tx *dbr.Tx) (int64, error) {
+	_, err := tx.InsertBySql("insert ignore into message_search_offset (table_name,last_id) values (?,0)", tableName).Exec()
+	if err != nil {
+		return 0, err
+	}
+	var lastID int64
+	err = tx.SelectBySql("select last_id from message_search_offset where table_name=? for update", tableName).LoadOne(&lastID)
+	return lastID, err
+}
+
+func (s *searchDB) updateOffsetTx(tableName string, lastID int64, tx *dbr.Tx) error {
+	_, err := tx.Update("message_search_offset").SetMap(map[string]interface{}{
+		"last_id":    lastID,
+		"updated_at": dbr.Now,
+	}).Where("table_name=?", tableName).Exec()
+	return err
+}
+
+// 查询消息表中未索引的消息
+func (s *searchDB) queryMessagesAfterIDTx(tableName string, lastID int64, limit uint64, tx *dbr.Tx) ([]*messageModel, error) {
+	var models []*messageModel
+	_, err := tx.Select("id,message_id,message_seq,client_msg_no,setting,`signal`,from_uid,channel_id,channel_type,timestamp,payload,is_deleted").From(tableName).Where("id>?", lastID).OrderAsc("id").Limit(limit).Load(&models)
+	return models, err
+}
+
+func (s *searchDB) insertDocTx(m *docModel, tx *dbr.Tx) error {
+	_, err := tx.InsertBySql("insert ignore into message_search_doc (message_id,message_seq,from_uid,channel_id,channel_type,content_type,timestamp) values (?,?,?,?,?,?,?)", m.MessageID, m.MessageSeq, m.FromUID, m.ChannelID, m.ChannelType, m.ContentType, m.Timestamp).Exec()
+	return err
+}
+
+// 是否已索引消息
+func (s *searchDB) existDoc(messageID string) (bool, error) {
+	var count int
+	_, err := s.session.Select("count(*)").From("message_search_doc").Where("message_id=?", messageID).Load(&count)
+	return count > 0, err
+}
+
+func (s *searchDB) deleteTokensTx(messageID string, tx *dbr.Tx) error {
+	_, err := tx.DeleteFrom("message_search_token").Where("message_id=?", messageID).Exec()
+	return err
+}
+
+// 查询消息编辑后的正文 key为消息ID
+func (s *searchDB) queryContentEdits(messageIDs []string) (map[string]string, error) {
+	if len(messageIDs) == 0 {
+		return map[string]string{}, nil
+	}
+	var results []*struct {
+		MessageID   string
+		ContentEdit string
+	}
+	_, err := s.session.Select("message_id,content_edit").From("message_extra").Where("message_id in ? and content_edit is not null and content_edit<>''", messageIDs).Load(&results)
+	if err != nil {
+		return nil, err
+	}
+	contentEditMap := make(map[string]string, len(results))
+	for _, result := range results {
+		contentEditMap[result.MessageID] = result.ContentEdit
+	}
+	return contentEditMap, nil
+}
+
+func (s *searchDB) insertTokensTx(messageID string, tokens []string, tx *dbr.Tx) error {
+	if len(tokens) == 0 {
+		return nil
+	}
+	values := make([]string, 0, len(tokens))
+	args := make([]interface{}, 0, len(tokens)*2)
+	for _, token := range tokens {
+		values = append(values, "(?,?)")
+		args = append(args, token, messageID)
+	}
+	_, err := tx.InsertBySql(fmt.Sprintf("insert ignore into message_search_token (token,message_id) values %s", strings.Join(values, ",")), args...).Exec()
+	return err
+}
+
+// 搜索文档
+// 返回登录用户可见的、包含所有词的消息（没有词时只按条件筛选），已撤回、已删除和已清空（频道偏移之前）的消息会被过滤
+func (s *searchDB) search(req *SearchReq, tokens []string, groupNos []string) ([]*docModel, error) {
+	builder := s.session.Select("d.*").From(dbr.I("message_search_doc").As("d"))
+	if len(tokens) > 0 {
+		builder = builder.Where("d.message_id in (select message_id from message_search_token where token in ? group by message_id having count(*)=?)", tokens, len(tokens))
+	}
+
+	// 可见范围：自己参与的单聊和加入的群
+	personCond := dbr.And(
+		dbr.Eq("d.channel_type", common.ChannelTypePerson.Uint8()),
+		dbr.Or(
+			dbr.Expr("SUBSTRING_INDEX(d.channel_id,'@',1)=?", req.UID),
+			dbr.Expr("SUBSTRING_INDEX(d.channel_id,'@',-1)=?", req.UID),
+		),
+	)
+	if len(groupNos) > 0 {
+		builder = builder.Where(dbr.Or(personCond, dbr.And(dbr.Eq("d.channel_type", common.ChannelTypeGroup.Uint8()), dbr.Eq("d.channel_id", groupNos))))
+	} else {
+		builder = builder.Where(personCond)
+	}
+
+	if req.ChannelID != "" {
+		channelID := req.ChannelID
+		if req.ChannelType == common.ChannelTypePerson.Uint8() {
+			channelID = common.GetFakeChannelIDWith(req.UID, req.ChannelID)
+		}
+		builder = builder.Where("d.channel_id=? and d.channel_type=?", channelID, req.ChannelType)
+	}
+	if req.FromUID != "" {
+		builder = builder.Where("d.from_uid=?", req.FromUID)
+	}
+	if len(req.ContentTypes) > 0 {
+		builder = builder.Where("d.content_type in ?", req.ContentTypes)
+	}
+	if req.StartTime > 0 {
+		builder = builder.Where("d.timestamp>=?", req.StartTime)
+	}
+	if req.EndTime > 0 {
+		builder = builder.Where("d.timestamp<?", req.EndTime)
+	}
+
+	// 撤回或被管理员删除
+	builder = builder.Where("not exists (select 1 from message_extra e where e.message_id=d.message_id and (e.`revoke`=1 or e.is_deleted=1))")
+	// 自己删除
+	builder = builder.Where(fmt.Sprintf("not exists (select 1 from %s u where u.uid=? and u.message_id=d.message_id and u.message_is_deleted=1)", s.getMessageUserExtraTable(req.UID)), req.UID)
+	// 自己清空的频道消息（个人频道的偏移记录在对方uid上）
+	builder = builder.Where(fmt.Sprintf("not exists (select 1 from %s o where (o.uid=? or o.uid='') and o.channel_type=d.channel_type and o.channel_id=IF(d.channel_type=?,IF(SUBSTRING_INDEX(d.channel_id,'@',1)=?,SUBSTRING_INDEX(d.channel_id,'@',-1),SUBSTRING_INDEX(d.channel_id,'@',1)),d.channel_id) and o.message_seq>=d.message_seq)", s.getChannelOffsetTable(req.UID)), req.UID, common.ChannelTypePerson.Uint8(), req.UID)
+	// 频道被清空的消息
+	builder = builder.Where("not exists (select 1 from channel_setting cs where cs.channel_id=d.channel_id and cs.channel_type=d.channel_type and cs.offset_message_seq>=d.message_seq)")
+
+	var models []*docModel
+	_, err := builder.OrderDesc("d.timestamp").OrderDesc("d.message_seq").Offset(uint64((req.Page - 1) * req.Limit)).Limit(uint64(req.Limit)).Load(&models)
+	return models, err
+}
+
+// 通过消息ID查询消息
+func (s *searchDB) queryMessagesWithIDs(channelID string, messageIDs []string) ([]*messageModel, error) {
+	var models []*messageModel
+	_, err := s.session.Select("id,message_id,message_seq,client_msg_no,setting,`signal`,from_uid,channel_id,channel_type,timestamp,payload,is_deleted").From(s.getMessageTable(channelID)).Where("message_id in ?", messageIDs).Load(&models)
+	return models, err
+}
+
+// 消息分表
+func (s *searchDB) getMessageTables() []string {
+	count := int(s.ctx.GetConfig().TablePartitionConfig.MessageTableCount)
+	tables := make([]string, 0, count)
+	for i := 0; i < count; i++ {
+		if i == 0 {
+			tables = append(tables, "message")
+			continue
+		}
+		tables = append(tables, fmt.Sprintf("message%d", i))
+	}
+	return tables
+}
+
+func (s *searchDB) getMessageTable(channelID string) string {
+	tableIndex := crc32.ChecksumIEEE([]byte(channelID)) % uint32(s.ctx.GetConfig().TablePartitionConfig.MessageTableCount)
+	if tableIndex == 0 {
+		return "message"
+	}
+	return fmt.Sprintf("message%d", tableIndex)
+}
+
+func (s *searchDB) getMessageUserExtraTable(uid string) string {
+	tableIndex := crc32.ChecksumIEEE([]byte(uid)) % uint32(s.ctx.GetConfig().TablePartitionConfig.MessageUserEditTableCount)
+	if tableIndex == 0 {
+		return "message_user_extra"
+	}
+	return fmt.Sprintf("message_user_extra%d", tableIndex)
+}
+
+func (s *searchDB) getChannelOffsetTable(uid string) string {
+	tableIndex := crc32.ChecksumIEEE([]byte(uid)) % uint32(s.ctx.GetConfig().TablePartitionConfig.ChannelOffsetTableCount)
+	if tableIndex == 0 {
+		return "channel_offset"
+	}
+	return fmt.Sprintf("channel_offset%d", tableIndex)
+}
+
+type messageModel struct {
+	Id          int64
+	MessageID   string
+	MessageSeq  uint32
+	ClientMsgNo string
+	Setting     uint8
+	Signal      int
+	FromUID     string
+	ChannelID   string
+	ChannelType uint8
+	Timestamp   int64
+	Payload     []byte
+	IsDeleted   int
+}
+
+type docModel struct {
+	MessageID   string
+	MessageSeq  uint32
+	FromUID     string
+	ChannelID   string
+	ChannelType uint8
+	ContentType int
+	Timestamp   int64
+	db.BaseModel
+}
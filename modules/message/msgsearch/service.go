@@ -0,0 +1,478 @@
+package msgsearch
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	commonapi "github.com/TangSengDaoDao/TangSengDaoDaoServer/modules/common"
+	"github.com/TangSengDaoDao/TangSengDaoDaoServer/modules/group"
+	"github.com/TangSengDaoDao/TangSengDaoDaoServer/pkg/tokenizer"
+	"github.com/TangSengDaoDao/TangSengDaoDaoServerLib/common"
+	"github.com/TangSengDaoDao/TangSengDaoDaoServerLib/config"
+	"github.com/TangSengDaoDao/TangSengDaoDaoServerLib/pkg/log"
+	"github.com/TangSengDaoDao/TangSengDaoDaoServerLib/pkg/util"
+	"go.uber.org/zap"
+)
+
+const (
+	indexInterval        = time.Second * 5 // 索引新消息的间隔
+	indexBatchSize       = 500             // 每批索引的消息数量
+	indexMaxBatchPerTick = 20              // 每次每张表最多索引的批数
+	maxTokensPerMessage  = 1000            // 每条消息最多索引的词数
+	defaultLimit         = 20
+	maxLimit             = 100
+)
+
+// Searcher 消息搜索
+type Searcher interface {
+	// 搜索用户可见的消息
+	Search(req *SearchReq) (*SearchResp, error)
+}
+
+// SearchReq 消息搜索请求
+type SearchReq struct {
+	UID          string // 搜索者uid
+	Keyword      string // 关键字
+	ContentTypes []int  // 正文类型
+	FromUID      string // 发送者uid
+	ChannelID    string // 频道ID（个人频道为对方uid）
+	ChannelType  uint8  // 频道类型
+	Topic        string // topic（仅悟空IM搜索支持）
+	Limit        int    // 每页数量
+	Page         int    // 页码 从1开始
+	StartTime    int64  // 消息时间（开始）
+	EndTime      int64  // 消息时间（结束，不包含）
+}
+
+func (r *SearchReq) fill() {
+	if r.Page <= 0 {
+		r.Page = 1
+	}
+	if r.Limit <= 0 {
+		r.Limit = defaultLimit
+	}
+	if r.Limit > maxLimit {
+		r.Limit = maxLimit
+	}
+}
+
+// SearchResp 消息搜索结果
+type SearchResp struct {
+	Messages []*config.MessageResp
+}
+
+// ---------- 悟空IM搜索 ----------
+
+type imSearcher struct {
+	ctx *config.Context
+}
+
+// NewIMSearcher 通过悟空IM搜索插件搜索消息
+func NewIMSearcher(ctx *config.Context) Searcher {
+	return &imSearcher{ctx: ctx}
+}
+
+func (i *imSearcher) Search(req *SearchReq) (*SearchResp, error) {
+	msgResp, err := i.ctx.IMSearchUserMessages(&config.SearchUserMessageReq{
+		UID: req.UID,
+		Payload: map[string]interface{}{
+			"content": req.Keyword,
+			"name":    req.Keyword,
+		},
+		PayloadTypes: req.ContentTypes,
+		Limit:        req.Limit,
+		Page:         req.Page,
+		FromUID:      req.FromUID,
+		ChannelID:    req.ChannelID,
+		ChannelType:  req.ChannelType,
+		Topic:        req.Topic,
+		StartTime:    req.StartTime,
+		EndTime:      req.EndTime,
+		Highlights:   []string{"payload.content", "payload.name"},
+	})
+	if err != nil {
+		return nil, err
+	}
+	resp := &SearchResp{}
+	if msgResp != nil {
+		resp.Messages = msgResp.Messages
+	}
+	return resp, nil
+}
+
+// ---------- 内置索引搜索 ----------
+
+// LocalSearcher 基于消息表建立的内置倒排索引搜索
+type LocalSearcher struct {
+	ctx *config.Context
+	log.Log
+	db           *searchDB
+	groupService group.IService
+}
+
+// NewLocalSearcher 内置索引搜索
+func NewLocalSearcher(ctx *config.Context) *LocalSearcher {
+	return &LocalSearcher{
+		ctx:          ctx,
+		Log:          log.NewTLog("msgsearch.LocalSearcher"),
+		db:           newSearchDB(ctx),
+		groupService: group.NewService(ctx),
+	}
+}
+
+// Search 搜索消息
+func (l *LocalSearcher) Search(req *SearchReq) (*SearchResp, error) {
+	req.fill()
+	if req.UID == "" {
+		return nil, errors.New("搜索者不能为空！")
+	}
+	tokens := tokenizer.QueryTokens(req.Keyword)
+	if len(tokens) == 0 && len(req.ContentTypes) == 0 && req.ChannelID == "" && req.FromUID == "" {
+		return &SearchResp{Messages: make([]*config.MessageResp, 0)}, nil
+	}
+	groups, err := l.groupService.GetGroupsWithMemberUID(req.UID)
+	if err != nil {
+		return nil, err
+	}
+	groupNos := make([]string, 0, len(groups))
+	for _, g := range groups {
+		groupNos = append(groupNos, g.GroupNo)
+	}
+	docs, err := l.db.search(req, tokens, groupNos)
+	if err != nil {
+		return nil, err
+	}
+	if len(docs) == 0 {
+		return &SearchResp{Messages: make([]*config.MessageResp, 0)}, nil
+	}
+
+	// 按频道回查消息正文（同一频道的消息在同一张分表）
+	channelMessageIDs := map[string][]string{}
+	for _, doc := range docs {
+		channelMessageIDs[doc.ChannelID] = append(channelMessageIDs[doc.ChannelID], doc.MessageID)
+	}
+	messageMap := make(map[string]*messageModel, len(docs))
+	for channelID, messageIDs := range channelMessageIDs {
+		messages, err := l.db.queryMessagesWithIDs(channelID, messageIDs)
+		if err != nil {
+			return nil, err
+		}
+		for _, message := range messages {
+			messageMap[message.MessageID] = message
+		}
+	}
+	// 编辑过的消息返回编辑后的正文
+	messageIDs := make([]string, 0, len(docs))
+	for _, doc := range docs {
+		messageIDs = append(messageIDs, doc.MessageID)
+	}
+	contentEditMap, err := l.db.queryContentEdits(messageIDs)
+	if err != nil {
+		return nil, err
+	}
+	resps := make([]*config.MessageResp, 0, len(docs))
+	for _, doc := range docs {
+		message := messageMap[doc.MessageID]
+		if message == nil || message.IsDeleted == 1 {
+			continue
+		}
+		if contentEdit := contentEditMap[message.MessageID]; contentEdit != "" {
+			message.Payload = []byte(contentEdit)
+		}
+		resps = append(resps, l.toMessageResp(message, req))
+	}
+	return &SearchResp{Messages: resps}, nil
+}
+
+func (l *LocalSearcher) toMessageResp(m *messageModel, req *SearchReq) *config.MessageResp {
+	messageID, _ := strconv.ParseInt(m.MessageID, 10, 64)
+	channelID := m.ChannelID
+	if m.ChannelType == common.ChannelTypePerson.Uint8() {
+		channelID = getPeerUID(m.ChannelID, req.UID)
+	}
+	payload := m.Payload
+	if req.Keyword != "" {
+		payload = highlight(payload, req.Keyword)
+	}
+	return &config.MessageResp{
+		MessageID:    messageID,
+		MessageIDStr: m.MessageID,
+		MessageSeq:   m.MessageSeq,
+		ClientMsgNo:  m.ClientMsgNo,
+		Setting:      m.Setting,
+		FromUID:      m.FromUID,
+		ChannelID:    channelID,
+		ChannelType:  m.ChannelType,
+		Timestamp:    int32(m.Timestamp),
+		Payload:      payload,
+	}
+}
+
+// 索引消息表中的新消息
+func (l *LocalSearcher) index() {
+	for _, tableName := range l.db.getMessageTables() {
+		for i := 0; i < indexMaxBatchPerTick; i++ {
+			count, err := l.indexBatch(tableName)
+			if err != nil {
+				l.Error("索引消息失败！", zap.Error(err), zap.String("table", tableName))
+				break
+			}
+			if count < indexBatchSize {
+				break
+			}
+		}
+	}
+}
+
+// 索引一批消息 进度行加锁，多实例部署时同一张表同时只有一个实例在索引
+func (l *LocalSearcher) indexBatch(tableName string) (int, error) {
+	tx, err := l.ctx.DB().Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer func() {
+		if err := recover(); err != nil {
+			tx.RollbackUnlessCommitted()
+			panic(err)
+		}
+	}()
+	lastID, err := l.db.queryOffsetForUpdateTx(tableName, tx)
+	if err != nil {
+		tx.Rollback()
+		return 0, err
+	}
+	messages, err := l.db.queryMessagesAfterIDTx(tableName, lastID, indexBatchSize, tx)
+	if err != nil {
+		tx.Rollback()
+		return 0, err
+	}
+	if len(messages) == 0 {
+		tx.Rollback()
+		return 0, nil
+	}
+	// 索引前已被编辑的消息按编辑后的正文索引
+	messageIDs := make([]string, 0, len(messages))
+	for _, message := range messages {
+		messageIDs = append(messageIDs, message.MessageID)
+	}
+	contentEditMap, err := l.db.queryContentEdits(messageIDs)
+	if err != nil {
+		tx.Rollback()
+		return 0, err
+	}
+	for _, message := range messages {
+		if contentEdit := contentEditMap[message.MessageID]; contentEdit != "" {
+			message.Payload = []byte(contentEdit)
+		}
+		doc, tokens := newDoc(message)
+		if doc == nil {
+			continue
+		}
+		if err = l.db.insertDocTx(doc, tx); err != nil {
+			tx.Rollback()
+			return 0, err
+		}
+		if err = l.db.insertTokensTx(doc.MessageID, tokens, tx); err != nil {
+			tx.Rollback()
+			return 0, err
+		}
+	}
+	if err = l.db.updateOffsetTx(tableName, messages[len(messages)-1].Id, tx); err != nil {
+		tx.Rollback()
+		return 0, err
+	}
+	if err = tx.Commit(); err != nil {
+		tx.Rollback()
+		return 0, err
+	}
+	return len(messages), nil
+}
+
+// ReindexEdited 消息被编辑后按编辑后的正文重新索引（还未索引的消息在索引时会使用编辑后的正文）
+func (l *LocalSearcher) ReindexEdited(messageID string, contentEdit string) error {
+	exist, err := l.db.existDoc(messageID)
+	if err != nil {
+		return err
+	}
+	if !exist {
+		return nil
+	}
+	tokens := editTokens(contentEdit)
+	tx, err := l.ctx.DB().Begin()
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err := recover(); err != nil {
+			tx.RollbackUnlessCommitted()
+			panic(err)
+		}
+	}()
+	if err = l.db.deleteTokensTx(messageID, tx); err != nil {
+		tx.Rollback()
+		return err
+	}
+	if err = l.db.insertTokensTx(messageID, tokens, tx); err != nil {
+		tx.Rollback()
+		return err
+	}
+	if err = tx.Commit(); err != nil {
+		tx.Rollback()
+		return err
+	}
+	return nil
+}
+
+// 编辑后正文的词
+func editTokens(contentEdit string) []string {
+	var payloadMap map[string]interface{}
+	if err := util.ReadJsonByByte([]byte(contentEdit), &payloadMap); err != nil || len(payloadMap) == 0 {
+		return nil
+	}
+	return payloadTokens(payloadMap)
+}
+
+// 生成消息的搜索文档和词，不需要索引的消息返回nil
+func newDoc(m *messageModel) (*docModel, []string) {
+	if m.IsDeleted == 1 || m.Signal == 1 || config.SettingFromUint8(m.Setting).Signal {
+		return nil, nil
+	}
+	if m.ChannelType != common.ChannelTypePerson.Uint8() && m.ChannelType != common.ChannelTypeGroup.Uint8() {
+		return nil, nil
+	}
+	var payloadMap map[string]interface{}
+	if err := util.ReadJsonByByte(m.Payload, &payloadMap); err != nil || len(payloadMap) == 0 {
+		return nil, nil
+	}
+	if payloadMap["visibles"] != nil { // 部分可见的消息不参与搜索
+		return nil, nil
+	}
+	var contentType int
+	if typeObj, ok := payloadMap["type"]; ok {
+		contentType, _ = strconv.Atoi(fmt.Sprintf("%v", typeObj))
+	}
+	tokens := payloadTokens(payloadMap)
+	return &docModel{
+		MessageID:   m.MessageID,
+		MessageSeq:  m.MessageSeq,
+		FromUID:     m.FromUID,
+		ChannelID:   m.ChannelID,
+		ChannelType: m.ChannelType,
+		ContentType: contentType,
+		Timestamp:   m.Timestamp,
+	}, tokens
+}
+
+// 正文和名称中的词
+func payloadTokens(payloadMap map[string]interface{}) []string {
+	texts := make([]string, 0, 2)
+	for _, key := range []string{"content", "name"} {
+		if text, ok := payloadMap[key].(string); ok && text != "" {
+			texts = append(texts, text)
+		}
+	}
+	tokens := tokenizer.Tokenize(strings.Join(texts, " "))
+	if len(tokens) > maxTokensPerMessage {
+		tokens = tokens[:maxTokensPerMessage]
+	}
+	return tokens
+}
+
+// 高亮正文和名称中的关键字
+func highlight(payload []byte, keyword string) []byte {
+	var payloadMap map[string]interface{}
+	if err := util.ReadJsonByByte(payload, &payloadMap); err != nil || payloadMap == nil {
+		return payload
+	}
+	for _, key := range []string{"content", "name"} {
+		if text, ok := payloadMap[key].(string); ok && text != "" {
+			payloadMap[key] = strings.ReplaceAll(text, keyword, fmt.Sprintf("<mark>%s</mark>", keyword))
+		}
+	}
+	return []byte(util.ToJson(payloadMap))
+}
+
+func getPeerUID(fakeChannelID string, uid string) string {
+	uids := strings.Split(fakeChannelID, "@")
+	if len(uids) != 2 {
+		return fakeChannelID
+	}
+	if uids[0] == uid {
+		return uids[1]
+	}
+	return uids[0]
+}
+
+// ---------- 按配置切换 ----------
+
+var (
+	serviceOnce sync.Once
+	instance    *Service
+)
+
+// Service 消息搜索服务 根据应用配置在悟空IM搜索和内置索引搜索之间切换
+type Service struct {
+	ctx *config.Context
+	log.Log
+	imSearcher    Searcher
+	localSearcher *LocalSearcher
+	commonService commonapi.IService
+	startOnce     sync.Once
+}
+
+// NewService 消息搜索服务（全局单例）
+func NewService(ctx *config.Context) *Service {
+	serviceOnce.Do(func() {
+		instance = &Service{
+			ctx:           ctx,
+			Log:           log.NewTLog("msgsearch.Service"),
+			imSearcher:    NewIMSearcher(ctx),
+			localSearcher: NewLocalSearcher(ctx),
+			commonService: commonapi.NewService(ctx),
+		}
+	})
+	return instance
+}
+
+// LocalOn 是否使用内置索引搜索
+func (s *Service) LocalOn() bool {
+	appConfig, err := s.commonService.GetAppConfig()
+	if err != nil {
+		s.Error("查询应用配置失败！", zap.Error(err))
+		return false
+	}
+	return appConfig != nil && appConfig.MessageSearchLocalOn == 1
+}
+
+// Search 搜索消息
+func (s *Service) Search(req *SearchReq) (*SearchResp, error) {
+	if s.LocalOn() {
+		return s.localSearcher.Search(req)
+	}
+	return s.imSearcher.Search(req)
+}
+
+// ReindexEdited 消息被编辑后重新索引（内置索引关闭时也更新已有的索引，开启后搜索结果保持最新）
+func (s *Service) ReindexEdited(messageID string, contentEdit string) error {
+	return s.localSearcher.ReindexEdited(messageID, contentEdit)
+}
+
+// Start 开启索引任务（开启内置索引搜索后才会索引，关闭期间的消息会在开启后补齐）
+func (s *Service) Start() {
+	s.startOnce.Do(func() {
+		go func() {
+			ticker := time.NewTicker(indexInterval)
+			defer ticker.Stop()
+			for range ticker.C {
+				if !s.LocalOn() {
+					continue
+				}
+				s.localSearcher.index()
+			}
+		}()
+	})
+}
@@ -0,0 +1,92 @@
+package prohibit
+
+import (
+	"github.com/TangSengDaoDao/TangSengDaoDaoServerLib/pkg/db"
+	"github.com/TangSengDaoDao/TangSengDaoDaoServerLib/pkg/util"
+	"github.com/gocraft/dbr/v2"
+)
+
+type prohibitDB struct {
+	session *dbr.Session
+}
+
+func newProhibitDB(session *dbr.Session) *prohibitDB {
+	return &prohibitDB{
+		session: session,
+	}
+}
+
+// 查询违禁词的最大版本
+func (p *prohibitDB) queryMaxVersion() (int64, error) {
+	var version int64
+	_, err := p.session.Select("IFNULL(max(version),0)").From("prohibit_words").Load(&version)
+	return version, err
+}
+
+// 查询所有未删除的违禁词
+func (p *prohibitDB) queryValidWords() ([]*wordModel, error) {
+	var list []*wordModel
+	_, err := p.session.Select("content,action").From("prohibit_words").Where("is_deleted=0").Load(&list)
+	return list, err
+}
+
+// 添加命中记录，同一条消息已有记录时忽略（webhook可能重复投递）
+func (p *prohibitDB) insertHit(m *hitModel) error {
+	_, err := p.session.InsertBySql("insert ignore into prohibit_word_hit (message_id,message_seq,client_msg_no,from_uid,channel_id,channel_type,words,action,content,status,handler_uid) values (?,?,?,?,?,?,?,?,?,?,?)", m.MessageID, m.MessageSeq, m.ClientMsgNo, m.FromUID, m.ChannelID, m.ChannelType, m.Words, m.Action, m.Content, m.Status, m.HandlerUID).Exec()
+	return err
+}
+
+func (p *prohibitDB) queryHits(status int, pageIndex, pageSize uint64) ([]*hitModel, error) {
+	var list []*hitModel
+	builder := p.session.Select("*").From("prohibit_word_hit")
+	if status >= 0 {
+		builder = builder.Where("status=?", status)
+	}
+	_, err := builder.OrderDir("id", false).Offset((pageIndex - 1) * pageSize).Limit(pageSize).Load(&list)
+	return list, err
+}
+
+func (p *prohibitDB) queryHitCount(status int) (int64, error) {
+	var count int64
+	builder := p.session.Select("count(*)").From("prohibit_word_hit")
+	if status >= 0 {
+		builder = builder.Where("status=?", status)
+	}
+	_, err := builder.Load(&count)
+	return count, err
+}
+
+func (p *prohibitDB) updateHitStatus(id int64, status int, handlerUID string) error {
+	_, err := p.session.Update("prohibit_word_hit").SetMap(map[string]interface{}{
+		"status":      status,
+		"handler_uid": handlerUID,
+		"updated_at":  dbr.Now,
+	}).Where("id=?", id).Exec()
+	return err
+}
+
+// 用替换后的正文覆盖已投递消息的正文
+func (p *prohibitDB) insertOrUpdateContentEdit(messageID string, messageSeq uint32, channelID string, channelType uint8, contentEdit string, version int64) error {
+	_, err := p.session.InsertBySql("INSERT INTO message_extra (message_id,message_seq,channel_id,channel_type,content_edit,content_edit_hash,version) VALUES (?,?,?,?,?,?,?) ON DUPLICATE KEY UPDATE content_edit=VALUES(content_edit),content_edit_hash=VALUES(content_edit_hash),version=VALUES(version)", messageID, messageSeq, channelID, channelType, contentEdit, util.MD5(contentEdit), version).Exec()
+	return err
+}
+
+type wordModel struct {
+	Content string
+	Action  int
+}
+
+type hitModel struct {
+	MessageID   string
+	MessageSeq  uint32
+	ClientMsgNo string
+	FromUID     string
+	ChannelID   string
+	ChannelType uint8
+	Words       string
+	Action      int
+	Content     string
+	Status      int
+	HandlerUID  string
+	db.BaseModel
+}
@@ -0,0 +1,357 @@
+package prohibit
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/TangSengDaoDao/TangSengDaoDaoServer/modules/message/revoke"
+	"github.com/TangSengDaoDao/TangSengDaoDaoServer/pkg/wordfilter"
+	"github.com/TangSengDaoDao/TangSengDaoDaoServerLib/common"
+	"github.com/TangSengDaoDao/TangSengDaoDaoServerLib/config"
+	"github.com/TangSengDaoDao/TangSengDaoDaoServerLib/pkg/log"
+	"github.com/TangSengDaoDao/TangSengDaoDaoServerLib/pkg/util"
+	"go.uber.org/zap"
+)
+
+// Action 违禁词命中后的处理方式
+type Action int
+
+const (
+	// ActionMask 替换为*
+	ActionMask Action = 1
+	// ActionBlock 拦截（已投递的消息将被撤回）
+	ActionBlock Action = 2
+	// ActionReview 标记待审核
+	ActionReview Action = 3
+)
+
+const (
+	// HitStatusWait 待审核
+	HitStatusWait = 0
+	// HitStatusHandled 已处理
+	HitStatusHandled = 1
+	// HitStatusIgnored 已忽略
+	HitStatusIgnored = 2
+)
+
+const (
+	maskRune            = '*'
+	reloadCheckInterval = time.Second * 10 // 检查违禁词是否变化的间隔
+)
+
+// ErrBlocked 消息包含被拦截的违禁词
+var ErrBlocked = errors.New("消息包含违禁词，发送失败！")
+
+// IService 违禁词服务
+type IService interface {
+	// 检查消息正文，返回命中结果（未命中返回nil）
+	CheckPayload(payload map[string]interface{}) *Result
+	// 服务端发送前过滤正文 命中拦截类违禁词返回ErrBlocked，命中替换类违禁词返回替换后的正文
+	FilterPayload(payload map[string]interface{}) (map[string]interface{}, error)
+	// 处理已投递的消息（替换、撤回并记录命中）
+	HandleDeliveredMessage(msg *DeliveredMessage, result *Result) error
+	// 分页查询命中记录 status小于0表示查询全部
+	GetHits(status int, pageIndex, pageSize uint64) ([]*HitResp, int64, error)
+	// 修改命中记录的审核状态
+	UpdateHitStatus(id int64, status int, handlerUID string) error
+}
+
+var (
+	serviceOnce sync.Once
+	instance    *Service
+)
+
+// Service 违禁词服务
+type Service struct {
+	ctx *config.Context
+	log.Log
+	db            *prohibitDB
+	revokeService revoke.IService
+
+	matchersLock    sync.RWMutex
+	matchers        map[Action]*wordfilter.Matcher
+	loadedVersion   int64
+	lastCheckedAt   time.Time
+	reloadCheckLock sync.Mutex
+}
+
+// NewService 违禁词服务（全局单例，共享违禁词缓存）
+func NewService(ctx *config.Context) IService {
+	serviceOnce.Do(func() {
+		instance = &Service{
+			ctx:           ctx,
+			Log:           log.NewTLog("prohibit.Service"),
+			db:            newProhibitDB(ctx.DB()),
+			revokeService: revoke.NewService(ctx),
+			matchers:      map[Action]*wordfilter.Matcher{},
+		}
+	})
+	return instance
+}
+
+// Result 违禁词命中结果
+type Result struct {
+	Hits          map[Action][]string    // 按处理方式分组的命中词
+	MaskedPayload map[string]interface{} // 替换后的正文（有替换类命中时才有值）
+}
+
+// Blocked 是否需要拦截
+func (r *Result) Blocked() bool {
+	return len(r.Hits[ActionBlock]) > 0
+}
+
+// NeedReview 是否需要审核
+func (r *Result) NeedReview() bool {
+	return len(r.Hits[ActionReview]) > 0
+}
+
+// Masked 是否有替换
+func (r *Result) Masked() bool {
+	return r.MaskedPayload != nil
+}
+
+// Action 最严重的处理方式
+func (r *Result) Action() Action {
+	if r.Blocked() {
+		return ActionBlock
+	}
+	if r.NeedReview() {
+		return ActionReview
+	}
+	return ActionMask
+}
+
+// Words 所有命中的词
+func (r *Result) Words() []string {
+	words := make([]string, 0)
+	for _, action := range []Action{ActionBlock, ActionReview, ActionMask} {
+		words = append(words, r.Hits[action]...)
+	}
+	return util.RemoveRepeatedElement(words)
+}
+
+// CheckPayload 检查消息正文
+func (s *Service) CheckPayload(payload map[string]interface{}) *Result {
+	if payload == nil {
+		return nil
+	}
+	content, ok := payload["content"].(string)
+	if !ok || strings.TrimSpace(content) == "" {
+		return nil
+	}
+	s.reloadIfNeed()
+
+	s.matchersLock.RLock()
+	defer s.matchersLock.RUnlock()
+
+	var result *Result
+	for action, matcher := range s.matchers {
+		matches := matcher.FindAll(content)
+		if len(matches) == 0 {
+			continue
+		}
+		if result == nil {
+			result = &Result{Hits: map[Action][]string{}}
+		}
+		for _, match := range matches {
+			result.Hits[action] = append(result.Hits[action], match.Word)
+		}
+	}
+	if result == nil {
+		return nil
+	}
+	if maskMatcher := s.matchers[ActionMask]; maskMatcher != nil && len(result.Hits[ActionMask]) > 0 {
+		maskedPayload := make(map[string]interface{}, len(payload))
+		for key, value := range payload {
+			maskedPayload[key] = value
+		}
+		maskedPayload["content"] = maskMatcher.Replace(content, maskRune)
+		result.MaskedPayload = maskedPayload
+	}
+	return result
+}
+
+// FilterPayload 服务端发送前过滤正文
+// 待审核类的命中不在这里处理，消息投递后由webhook统一记录
+func (s *Service) FilterPayload(payload map[string]interface{}) (map[string]interface{}, error) {
+	result := s.CheckPayload(payload)
+	if result == nil {
+		return payload, nil
+	}
+	if result.Blocked() {
+		return nil, ErrBlocked
+	}
+	if result.Masked() {
+		return result.MaskedPayload, nil
+	}
+	return payload, nil
+}
+
+// HandleDeliveredMessage 处理已投递的消息
+func (s *Service) HandleDeliveredMessage(msg *DeliveredMessage, result *Result) error {
+	if result == nil {
+		return nil
+	}
+	messageIDStr := strconv.FormatInt(msg.MessageID, 10)
+	fakeChannelID := msg.ChannelID
+	if msg.ChannelType == common.ChannelTypePerson.Uint8() {
+		fakeChannelID = common.GetFakeChannelIDWith(msg.FromUID, msg.ChannelID)
+	}
+	status := HitStatusHandled
+	if result.NeedReview() {
+		status = HitStatusWait
+	}
+	content, _ := msg.Payload["content"].(string)
+	err := s.db.insertHit(&hitModel{
+		MessageID:   messageIDStr,
+		MessageSeq:  msg.MessageSeq,
+		ClientMsgNo: msg.ClientMsgNo,
+		FromUID:     msg.FromUID,
+		ChannelID:   fakeChannelID,
+		ChannelType: msg.ChannelType,
+		Words:       truncate(strings.Join(result.Words(), ","), 255),
+		Action:      int(result.Action()),
+		Content:     content,
+		Status:      status,
+	})
+	if err != nil {
+		return fmt.Errorf("添加违禁词命中记录失败！%w", err)
+	}
+	if result.Blocked() {
+		return s.revokeService.RevokeWithSystem(&revoke.Message{
+			MessageID:   msg.MessageID,
+			MessageSeq:  msg.MessageSeq,
+			FromUID:     msg.FromUID,
+			ChannelID:   msg.ChannelID,
+			ChannelType: msg.ChannelType,
+		})
+	}
+	version := time.Now().UnixNano() / 1e3
+	if result.Masked() {
+		err = s.db.insertOrUpdateContentEdit(messageIDStr, msg.MessageSeq, fakeChannelID, msg.ChannelType, util.ToJson(result.MaskedPayload), version)
+		if err != nil {
+			return fmt.Errorf("替换违禁词失败！%w", err)
+		}
+		return s.ctx.SendCMD(config.MsgCMDReq{
+			NoPersist:   true,
+			ChannelID:   msg.ChannelID,
+			ChannelType: msg.ChannelType,
+			FromUID:     msg.FromUID,
+			CMD:         common.CMDSyncMessageExtra,
+		})
+	}
+	return nil
+}
+
+// GetHits 分页查询命中记录
+func (s *Service) GetHits(status int, pageIndex, pageSize uint64) ([]*HitResp, int64, error) {
+	models, err := s.db.queryHits(status, pageIndex, pageSize)
+	if err != nil {
+		return nil, 0, err
+	}
+	count, err := s.db.queryHitCount(status)
+	if err != nil {
+		return nil, 0, err
+	}
+	resps := make([]*HitResp, 0, len(models))
+	for _, model := range models {
+		resps = append(resps, &HitResp{
+			ID:          model.Id,
+			MessageID:   model.MessageID,
+			MessageSeq:  model.MessageSeq,
+			FromUID:     model.FromUID,
+			ChannelID:   model.ChannelID,
+			ChannelType: model.ChannelType,
+			Words:       model.Words,
+			Action:      model.Action,
+			Content:     model.Content,
+			Status:      model.Status,
+			HandlerUID:  model.HandlerUID,
+			CreatedAt:   model.CreatedAt.String(),
+		})
+	}
+	return resps, count, nil
+}
+
+// UpdateHitStatus 修改命中记录的审核状态
+func (s *Service) UpdateHitStatus(id int64, status int, handlerUID string) error {
+	return s.db.updateHitStatus(id, status, handlerUID)
+}
+
+// 违禁词有变化时重新构建匹配器
+func (s *Service) reloadIfNeed() {
+	s.reloadCheckLock.Lock()
+	defer s.reloadCheckLock.Unlock()
+	if time.Since(s.lastCheckedAt) < reloadCheckInterval {
+		return
+	}
+	s.lastCheckedAt = time.Now()
+	version, err := s.db.queryMaxVersion()
+	if err != nil {
+		s.Error("查询违禁词版本失败！", zap.Error(err))
+		return
+	}
+	if version == s.loadedVersion {
+		return
+	}
+	words, err := s.db.queryValidWords()
+	if err != nil {
+		s.Error("查询违禁词失败！", zap.Error(err))
+		return
+	}
+	wordsMap := map[Action][]string{}
+	for _, word := range words {
+		action := Action(word.Action)
+		if action != ActionBlock && action != ActionReview {
+			action = ActionMask
+		}
+		wordsMap[action] = append(wordsMap[action], word.Content)
+	}
+	matchers := make(map[Action]*wordfilter.Matcher, len(wordsMap))
+	for action, actionWords := range wordsMap {
+		matchers[action] = wordfilter.New(actionWords)
+	}
+	s.matchersLock.Lock()
+	s.matchers = matchers
+	s.loadedVersion = version
+	s.matchersLock.Unlock()
+}
+
+func truncate(str string, max int) string {
+	runes := []rune(str)
+	if len(runes) <= max {
+		return str
+	}
+	return string(runes[:max])
+}
+
+// DeliveredMessage 已投递的消息
+type DeliveredMessage struct {
+	MessageID   int64
+	MessageSeq  uint32
+	ClientMsgNo string
+	FromUID     string
+	ChannelID   string
+	ChannelType uint8
+	Payload     map[string]interface{}
+}
+
+// HitResp 违禁词命中记录
+type HitResp struct {
+	ID          int64  `json:"id"`
+	MessageID   string `json:"message_id"`
+	MessageSeq  uint32 `json:"message_seq"`
+	FromUID     string `json:"from_uid"`
+	ChannelID   string `json:"channel_id"`
+	ChannelType uint8  `json:"channel_type"`
+	Words       string `json:"words"`       // 命中的违禁词
+	Action      int    `json:"action"`      // 执行的处理方式
+	Content     string `json:"content"`     // 原始内容
+	Status      int    `json:"status"`      // 审核状态 0.待审核 1.已处理 2.已忽略
+	HandlerUID  string `json:"handler_uid"` // 审核人
+	CreatedAt   string `json:"created_at"`
+}
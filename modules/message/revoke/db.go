@@ -0,0 +1,21 @@
+package revoke
+
+import (
+	"github.com/gocraft/dbr/v2"
+)
+
+type revokeDB struct {
+	session *dbr.Session
+}
+
+func newRevokeDB(session *dbr.Session) *revokeDB {
+	return &revokeDB{
+		session: session,
+	}
+}
+
+// 将已投递的消息标记为撤回
+func (r *revokeDB) insertOrUpdateRevoke(messageID string, messageSeq uint32, fromUID, channelID string, channelType uint8, revoker string, version int64) error {
+	_, err := r.session.InsertBySql("INSERT INTO message_extra (message_id,message_seq,from_uid,channel_id,channel_type,`revoke`,revoker,version) VALUES (?,?,?,?,?,1,?,?) ON DUPLICATE KEY UPDATE `revoke`=VALUES(`revoke`),revoker=VALUES(revoker),version=VALUES(version)", messageID, messageSeq, fromUID, channelID, channelType, revoker, version).Exec()
+	return err
+}
@@ -0,0 +1,60 @@
+package revoke
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/TangSengDaoDao/TangSengDaoDaoServerLib/common"
+	"github.com/TangSengDaoDao/TangSengDaoDaoServerLib/config"
+)
+
+// IService 系统撤回服务（违禁词拦截、违反群慢速模式等由服务端撤回已投递的消息）
+type IService interface {
+	// 以系统账号撤回已投递的消息
+	RevokeWithSystem(msg *Message) error
+}
+
+// Service 系统撤回服务
+type Service struct {
+	ctx *config.Context
+	db  *revokeDB
+}
+
+// NewService NewService
+func NewService(ctx *config.Context) IService {
+	return &Service{
+		ctx: ctx,
+		db:  newRevokeDB(ctx.DB()),
+	}
+}
+
+// Message 需要撤回的已投递消息
+type Message struct {
+	MessageID   int64
+	MessageSeq  uint32
+	FromUID     string
+	ChannelID   string
+	ChannelType uint8
+}
+
+// RevokeWithSystem 以系统账号撤回已投递的消息
+func (s *Service) RevokeWithSystem(msg *Message) error {
+	messageIDStr := strconv.FormatInt(msg.MessageID, 10)
+	fakeChannelID := msg.ChannelID
+	if msg.ChannelType == common.ChannelTypePerson.Uint8() {
+		fakeChannelID = common.GetFakeChannelIDWith(msg.FromUID, msg.ChannelID)
+	}
+	systemUID := s.ctx.GetConfig().Account.SystemUID
+	err := s.db.insertOrUpdateRevoke(messageIDStr, msg.MessageSeq, msg.FromUID, fakeChannelID, msg.ChannelType, systemUID, time.Now().UnixNano()/1e3)
+	if err != nil {
+		return fmt.Errorf("撤回消息失败！%w", err)
+	}
+	return s.ctx.SendRevoke(&config.MsgRevokeReq{
+		Operator:    systemUID,
+		FromUID:     msg.FromUID,
+		ChannelID:   msg.ChannelID,
+		ChannelType: msg.ChannelType,
+		MessageID:   msg.MessageID,
+	})
+}
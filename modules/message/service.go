@@ -1,11 +1,15 @@
 package message
 
 import (
+	"errors"
 	"strconv"
 
+	"github.com/TangSengDaoDao/TangSengDaoDaoServer/modules/group"
+	"github.com/TangSengDaoDao/TangSengDaoDaoServer/modules/user"
 	"github.com/TangSengDaoDao/TangSengDaoDaoServerLib/common"
 	"github.com/TangSengDaoDao/TangSengDaoDaoServerLib/config"
 	"github.com/TangSengDaoDao/TangSengDaoDaoServerLib/pkg/log"
+	"github.com/TangSengDaoDao/TangSengDaoDaoServerLib/pkg/util"
 )
 
 type IService interface {
@@ -19,6 +23,12 @@ type IService interface {
 	GetChannelOffsetWithUID(uid string, channelIDs []string) ([]*channelOffsetResp, error)
 	// 删除会话
 	DeleteConversation(uid string, channelID string, channelType uint8) error
+	// 添加定时消息 返回定时消息ID
+	AddScheduledMessage(req *ScheduledMessageAddReq) (int64, error)
+	// 查询发送者在某频道内待发送的定时消息
+	GetPendingScheduledMessages(fromUID string, channelID string, channelType uint8) ([]*ScheduledMessageResp, error)
+	// 取消定时消息
+	CancelScheduledMessage(fromUID string, id int64) error
 }
 
 type Service struct {
@@ -27,6 +37,9 @@ type Service struct {
 	messageExtraDB     *messageExtraDB
 	messageUserExtraDB *messageUserExtraDB
 	channelOffsetDB    *channelOffsetDB
+	scheduledMessageDB *scheduledMessageDB
+	userService        user.IService
+	groupService       group.IService
 }
 
 func NewService(ctx *config.Context) *Service {
@@ -37,6 +50,9 @@ func NewService(ctx *config.Context) *Service {
 		messageExtraDB:     newMessageExtraDB(ctx),
 		messageUserExtraDB: newMessageUserExtraDB(ctx),
 		channelOffsetDB:    newChannelOffsetDB(ctx),
+		scheduledMessageDB: newScheduledMessageDB(ctx),
+		userService:        user.NewService(ctx),
+		groupService:       group.NewService(ctx),
 	}
 }
 
@@ -148,6 +164,80 @@ func (s *Service) DeleteConversation(uid string, channelID string, channelType u
 	return nil
 }
 
+// AddScheduledMessage 添加定时消息
+func (s *Service) AddScheduledMessage(req *ScheduledMessageAddReq) (int64, error) {
+	if req.FromUID == "" {
+		return 0, errors.New("发送者不能为空！")
+	}
+	scheduledReq := &scheduledMessageReq{
+		ChannelID:   req.ChannelID,
+		ChannelType: req.ChannelType,
+		Payload:     req.Payload,
+		SendAt:      req.SendAt,
+	}
+	if err := scheduledReq.check(); err != nil {
+		return 0, err
+	}
+	if err := checkSendPermission(s.userService, s.groupService, req.FromUID, req.FromRobot, req.ChannelID, req.ChannelType); err != nil {
+		return 0, err
+	}
+	fromRobot := 0
+	if req.FromRobot {
+		fromRobot = 1
+	}
+	return s.scheduledMessageDB.insert(&scheduledMessageModel{
+		FromUID:     req.FromUID,
+		FromRobot:   fromRobot,
+		ChannelID:   req.ChannelID,
+		ChannelType: req.ChannelType,
+		Payload:     util.ToJson(req.Payload),
+		SendAt:      req.SendAt,
+		Status:      scheduledStatusWait,
+	})
+}
+
+// GetPendingScheduledMessages 查询发送者在某频道内待发送的定时消息
+func (s *Service) GetPendingScheduledMessages(fromUID string, channelID string, channelType uint8) ([]*ScheduledMessageResp, error) {
+	models, err := s.scheduledMessageDB.queryPendingWithChannel(fromUID, channelID, channelType)
+	if err != nil {
+		return nil, err
+	}
+	resps := make([]*ScheduledMessageResp, 0, len(models))
+	for _, model := range models {
+		resps = append(resps, newScheduledMessageResp(model))
+	}
+	return resps, nil
+}
+
+// CancelScheduledMessage 取消定时消息
+func (s *Service) CancelScheduledMessage(fromUID string, id int64) error {
+	model, err := s.scheduledMessageDB.queryWithID(id)
+	if err != nil {
+		return err
+	}
+	if model == nil || model.FromUID != fromUID {
+		return errors.New("定时消息不存在！")
+	}
+	rows, err := s.scheduledMessageDB.updateStatus(id, scheduledStatusWait, scheduledStatusCancel)
+	if err != nil {
+		return err
+	}
+	if rows == 0 {
+		return errors.New("定时消息已发送或已取消！")
+	}
+	return nil
+}
+
+// ScheduledMessageAddReq 添加定时消息请求
+type ScheduledMessageAddReq struct {
+	FromUID     string                 // 发送者
+	FromRobot   bool                   // 是否是机器人发送（机器人不校验好友关系）
+	ChannelID   string                 // 接收频道
+	ChannelType uint8                  // 接收频道类型
+	Payload     map[string]interface{} // 消息内容
+	SendAt      int64                  // 计划发送时间 10位时间戳
+}
+
 type messageUserExtraResp struct {
 	MessageID        int64  `json:"message_id"`
 	MessageIDStr     string `json:"message_id_str"`
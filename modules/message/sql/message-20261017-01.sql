@@ -0,0 +1,20 @@
+-- +migrate Up
+
+-- 定时消息
+create table `scheduled_message`(
+  id            bigint        not null primary key AUTO_INCREMENT,
+  from_uid      VARCHAR(40)   not null default '',  -- 发送者uid
+  from_robot    smallint      not null default 0,   -- 是否是机器人发送 0.否 1.是
+  channel_id    VARCHAR(100)  not null default '',  -- 频道ID
+  channel_type  smallint      not null default 0,   -- 频道类型
+  payload       mediumtext,                         -- 消息内容
+  send_at       bigint        not null default 0,   -- 计划发送时间 10位时间戳
+  status        smallint      not null default 0,   -- 状态 0.待发送 1.发送中 2.已发送 3.已取消 4.发送失败
+  fail_reason   VARCHAR(255)  not null default '',  -- 失败原因
+  message_id    VARCHAR(20)   not null default '',  -- 发送成功后的消息ID
+  sent_at       bigint        not null default 0,   -- 实际发送时间 10位时间戳
+  created_at    timeStamp     not null DEFAULT CURRENT_TIMESTAMP, -- 创建时间
+  updated_at    timeStamp     not null DEFAULT CURRENT_TIMESTAMP  -- 更新时间
+);
+CREATE INDEX scheduled_message_status_send_at_idx on `scheduled_message` (status, send_at);
+CREATE INDEX scheduled_message_from_uid_channel_idx on `scheduled_message` (from_uid, channel_id, channel_type);
@@ -0,0 +1,24 @@
+-- +migrate Up
+
+-- 违禁词命中后的处理方式 1.替换为* 2.拦截 3.标记待审核
+ALTER TABLE `prohibit_words` ADD COLUMN action smallint not null default 1 COMMENT '命中处理方式 1.替换 2.拦截 3.审核';
+
+-- 违禁词命中记录
+create table `prohibit_word_hit`(
+  id            bigint        not null primary key AUTO_INCREMENT,
+  message_id    VARCHAR(20)   not null default '',  -- 消息唯一ID
+  message_seq   bigint        not null default 0,   -- 消息序列号
+  client_msg_no VARCHAR(40)   not null default '',  -- 客户端消息编号
+  from_uid      VARCHAR(40)   not null default '',  -- 发送者uid
+  channel_id    VARCHAR(100)  not null default '',  -- 频道ID
+  channel_type  smallint      not null default 0,   -- 频道类型
+  words         VARCHAR(255)  not null default '',  -- 命中的违禁词，多个以逗号分隔
+  action        smallint      not null default 0,   -- 执行的处理方式 1.替换 2.拦截 3.审核
+  content       TEXT,                               -- 原始内容
+  status        smallint      not null default 0,   -- 审核状态 0.待审核 1.已处理 2.已忽略
+  handler_uid   VARCHAR(40)   not null default '',  -- 审核人
+  created_at    timeStamp     not null DEFAULT CURRENT_TIMESTAMP, -- 创建时间
+  updated_at    timeStamp     not null DEFAULT CURRENT_TIMESTAMP  -- 更新时间
+);
+CREATE INDEX prohibit_word_hit_status_idx on `prohibit_word_hit` (status);
+CREATE INDEX prohibit_word_hit_message_idx on `prohibit_word_hit` (message_id);
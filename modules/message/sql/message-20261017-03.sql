@@ -0,0 +1,37 @@
+-- +migrate Up
+
+-- 内置消息搜索 文档（每条可搜索的消息一行）
+create table `message_search_doc`(
+  id            bigint          not null primary key AUTO_INCREMENT,
+  message_id    VARCHAR(20)     not null default '',  -- 消息唯一ID
+  message_seq   bigint          not null default 0,   -- 消息序列号
+  from_uid      VARCHAR(40)     not null default '',  -- 发送者uid
+  channel_id    VARCHAR(100)    not null default '',  -- 频道ID（个人频道为fake channel id）
+  channel_type  smallint        not null default 0,   -- 频道类型
+  content_type  integer         not null default 0,   -- 正文类型
+  timestamp     BIGINT          not null default 0,   -- 消息时间
+  created_at    timeStamp       not null DEFAULT CURRENT_TIMESTAMP, -- 创建时间
+  updated_at    timeStamp       not null DEFAULT CURRENT_TIMESTAMP  -- 更新时间
+);
+CREATE UNIQUE INDEX message_search_doc_message_uidx on `message_search_doc` (message_id);
+CREATE INDEX message_search_doc_channel_idx on `message_search_doc` (channel_id, channel_type);
+
+-- 内置消息搜索 倒排索引
+create table `message_search_token`(
+  id            bigint          not null primary key AUTO_INCREMENT,
+  token         VARCHAR(32)     not null default '',  -- 词
+  message_id    VARCHAR(20)     not null default '',  -- 消息唯一ID
+  created_at    timeStamp       not null DEFAULT CURRENT_TIMESTAMP  -- 创建时间
+);
+CREATE UNIQUE INDEX message_search_token_uidx on `message_search_token` (token, message_id);
+CREATE INDEX message_search_token_message_idx on `message_search_token` (message_id);
+
+-- 内置消息搜索 各消息分表的索引进度
+create table `message_search_offset`(
+  id            bigint          not null primary key AUTO_INCREMENT,
+  table_name    VARCHAR(40)     not null default '',  -- 消息表名
+  last_id       bigint          not null default 0,   -- 已索引到的消息表自增id
+  created_at    timeStamp       not null DEFAULT CURRENT_TIMESTAMP, -- 创建时间
+  updated_at    timeStamp       not null DEFAULT CURRENT_TIMESTAMP  -- 更新时间
+);
+CREATE UNIQUE INDEX message_search_offset_table_uidx on `message_search_offset` (table_name);
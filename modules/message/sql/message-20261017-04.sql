@@ -0,0 +1,23 @@
+-- +migrate Up
+
+-- 会话记录导出
+create table `message_export`(
+  id            bigint        not null primary key AUTO_INCREMENT,
+  export_no     VARCHAR(40)   not null default '',  -- 导出编号
+  uid           VARCHAR(40)   not null default '',  -- 发起导出的用户uid
+  is_manager    smallint      not null default 0,   -- 是否是后台管理员导出 0.否 1.是
+  channel_id    VARCHAR(100)  not null default '',  -- 频道ID（个人频道为用户uid组合后的ID）
+  channel_type  smallint      not null default 0,   -- 频道类型
+  status        smallint      not null default 0,   -- 状态 0.等待导出 1.导出中 2.已完成 3.导出失败
+  total         bigint        not null default 0,   -- 需要导出的消息数量
+  processed     bigint        not null default 0,   -- 已处理的消息数量
+  file_path     VARCHAR(255)  not null default '',  -- 归档文件路径
+  file_size     bigint        not null default 0,   -- 归档文件大小
+  fail_reason   VARCHAR(255)  not null default '',  -- 失败原因
+  finished_at   bigint        not null default 0,   -- 完成时间 10位时间戳
+  created_at    timeStamp     not null DEFAULT CURRENT_TIMESTAMP, -- 创建时间
+  updated_at    timeStamp     not null DEFAULT CURRENT_TIMESTAMP  -- 更新时间
+);
+CREATE UNIQUE INDEX message_export_export_no_idx on `message_export` (export_no);
+CREATE INDEX message_export_uid_idx on `message_export` (uid);
+CREATE INDEX message_export_status_idx on `message_export` (status);
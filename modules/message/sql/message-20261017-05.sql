@@ -0,0 +1,18 @@
+-- +migrate Up
+
+-- 消息编辑历史
+create table `message_edit_revision`(
+  id                 bigint        not null primary key AUTO_INCREMENT,
+  message_id         VARCHAR(20)   not null default '',  -- 消息ID
+  message_seq        bigint        not null default 0,   -- 消息序号
+  channel_id         VARCHAR(100)  not null default '',  -- 频道ID
+  channel_type       smallint      not null default 0,   -- 频道类型
+  revision           integer       not null default 0,   -- 修订号 从1开始
+  content_edit       mediumtext,                         -- 编辑后的正文
+  content_edit_hash  VARCHAR(255)  not null default '',  -- 编辑正文的hash
+  editor_uid         VARCHAR(40)   not null default '',  -- 编辑者uid
+  edited_at          integer       not null default 0,   -- 编辑时间 时间戳（秒）
+  created_at         timeStamp     not null DEFAULT CURRENT_TIMESTAMP, -- 创建时间
+  updated_at         timeStamp     not null DEFAULT CURRENT_TIMESTAMP  -- 更新时间
+);
+CREATE UNIQUE INDEX message_edit_revision_message_id_revision_idx on `message_edit_revision` (message_id, revision);
@@ -0,0 +1,37 @@
+-- +migrate Up
+
+-- 投票
+create table `poll`(
+  id            bigint        not null primary key AUTO_INCREMENT,
+  poll_no       VARCHAR(40)   not null default '',  -- 投票编号
+  message_id    VARCHAR(20)   not null default '',  -- 投票消息ID
+  message_seq   bigint        not null default 0,   -- 投票消息序号
+  channel_id    VARCHAR(100)  not null default '',  -- 频道ID（个人频道为用户uid组合后的ID）
+  channel_type  smallint      not null default 0,   -- 频道类型
+  creator       VARCHAR(40)   not null default '',  -- 创建者uid
+  question      VARCHAR(1000) not null default '',  -- 投票问题
+  options       text,                               -- 投票选项 json数组 [{"id":1,"text":"选项"}]
+  multiple      smallint      not null default 0,   -- 是否多选 0.单选 1.多选
+  anonymous     smallint      not null default 0,   -- 是否匿名 0.公开 1.匿名
+  deadline      bigint        not null default 0,   -- 截止时间 10位时间戳 0.不限制
+  status        smallint      not null default 0,   -- 状态 0.进行中 1.已结束
+  closed_by     VARCHAR(40)   not null default '',  -- 结束投票的uid
+  closed_at     bigint        not null default 0,   -- 结束时间 10位时间戳
+  created_at    timeStamp     not null DEFAULT CURRENT_TIMESTAMP, -- 创建时间
+  updated_at    timeStamp     not null DEFAULT CURRENT_TIMESTAMP  -- 更新时间
+);
+CREATE UNIQUE INDEX poll_poll_no_idx on `poll` (poll_no);
+CREATE INDEX poll_message_id_idx on `poll` (message_id);
+
+-- 投票记录
+create table `poll_vote`(
+  id            bigint        not null primary key AUTO_INCREMENT,
+  poll_no       VARCHAR(40)   not null default '',  -- 投票编号
+  uid           VARCHAR(40)   not null default '',  -- 投票者uid
+  option_id     integer       not null default 0,   -- 选项ID
+  created_at    timeStamp     not null DEFAULT CURRENT_TIMESTAMP, -- 创建时间
+  updated_at    timeStamp     not null DEFAULT CURRENT_TIMESTAMP  -- 更新时间
+);
+CREATE UNIQUE INDEX poll_vote_poll_no_uid_option_idx on `poll_vote` (poll_no, uid, option_id);
+
+ALTER TABLE `message_extra` ADD COLUMN poll_result TEXT COMMENT '投票结果';
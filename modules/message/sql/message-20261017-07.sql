@@ -0,0 +1,86 @@
+-- +migrate Up
+
+-- 消息保留策略（优先级：指定频道 > 群分类 > 频道类型）
+create table `message_retention_policy`(
+  id                bigint        not null primary key AUTO_INCREMENT,
+  scope             smallint      not null default 0,   -- 策略范围 1.频道类型 2.群分类 3.指定频道
+  channel_type      smallint      not null default 0,   -- 频道类型
+  category          VARCHAR(40)   not null default '',  -- 群分类（scope=2）
+  channel_id        VARCHAR(100)  not null default '',  -- 频道ID（scope=3，个人频道为用户uid组合后的ID）
+  retention_second  bigint        not null default 0,   -- 消息保留时长 单位秒
+  status            smallint      not null default 1,   -- 状态 0.停用 1.启用
+  remark            VARCHAR(255)  not null default '',  -- 备注
+  created_by        VARCHAR(40)   not null default '',  -- 创建者uid
+  created_at        timeStamp     not null DEFAULT CURRENT_TIMESTAMP, -- 创建时间
+  updated_at        timeStamp     not null DEFAULT CURRENT_TIMESTAMP  -- 更新时间
+);
+CREATE UNIQUE INDEX message_retention_policy_target_uidx on `message_retention_policy` (scope, channel_type, category, channel_id);
+
+-- 法律保全（保全中的频道或用户的消息不会被清理）
+create table `message_legal_hold`(
+  id            bigint        not null primary key AUTO_INCREMENT,
+  target_type   smallint      not null default 0,   -- 保全对象 1.频道 2.用户
+  channel_id    VARCHAR(100)  not null default '',  -- 频道ID（target_type=1，个人频道为用户uid组合后的ID）
+  channel_type  smallint      not null default 0,   -- 频道类型
+  uid           VARCHAR(40)   not null default '',  -- 用户uid（target_type=2）
+  reason        VARCHAR(255)  not null default '',  -- 保全原因
+  status        smallint      not null default 1,   -- 状态 0.已解除 1.保全中
+  created_by    VARCHAR(40)   not null default '',  -- 创建者uid
+  released_by   VARCHAR(40)   not null default '',  -- 解除者uid
+  released_at   bigint        not null default 0,   -- 解除时间 10位时间戳
+  created_at    timeStamp     not null DEFAULT CURRENT_TIMESTAMP, -- 创建时间
+  updated_at    timeStamp     not null DEFAULT CURRENT_TIMESTAMP  -- 更新时间
+);
+CREATE INDEX message_legal_hold_status_idx on `message_legal_hold` (status);
+
+-- 消息清理报告
+create table `message_purge_report`(
+  id              bigint        not null primary key AUTO_INCREMENT,
+  report_no       VARCHAR(40)   not null default '',  -- 报告编号（定时清理为auto-时间）
+  trigger_type    smallint      not null default 0,   -- 触发方式 1.定时 2.手动
+  operator        VARCHAR(40)   not null default '',  -- 手动触发的管理员uid
+  dry_run         smallint      not null default 0,   -- 是否只统计不删除 0.否 1.是
+  status          smallint      not null default 0,   -- 状态 0.等待执行 1.执行中 2.已完成 3.失败
+  policy_count    integer       not null default 0,   -- 生效的策略数量
+  hold_count      integer       not null default 0,   -- 生效的保全数量
+  channel_count   integer       not null default 0,   -- 清理的频道数量
+  message_count   bigint        not null default 0,   -- 清理的消息数量
+  held_count      bigint        not null default 0,   -- 因保全跳过的过期消息数量
+  extra_count     bigint        not null default 0,   -- 清理的关联数据数量（扩展、回应、已读、置顶等）
+  fail_reason     VARCHAR(255)  not null default '',  -- 失败原因
+  started_at      bigint        not null default 0,   -- 开始时间 10位时间戳
+  finished_at     bigint        not null default 0,   -- 完成时间 10位时间戳
+  created_at      timeStamp     not null DEFAULT CURRENT_TIMESTAMP, -- 创建时间
+  updated_at      timeStamp     not null DEFAULT CURRENT_TIMESTAMP  -- 更新时间
+);
+CREATE UNIQUE INDEX message_purge_report_report_no_uidx on `message_purge_report` (report_no);
+CREATE INDEX message_purge_report_status_idx on `message_purge_report` (status);
+
+-- 消息清理报告明细（每个频道一条）
+create table `message_purge_report_item`(
+  id               bigint        not null primary key AUTO_INCREMENT,
+  report_no        VARCHAR(40)   not null default '',  -- 报告编号
+  policy_id        bigint        not null default 0,   -- 命中的保留策略ID
+  channel_id       VARCHAR(100)  not null default '',  -- 频道ID
+  channel_type     smallint      not null default 0,   -- 频道类型
+  cutoff           bigint        not null default 0,   -- 清理此时间之前的消息 10位时间戳
+  held             smallint      not null default 0,   -- 频道是否被保全 0.否 1.是
+  held_uids        text,                               -- 被保全的发送者uid（这些用户的消息未清理） json数组
+  message_count    bigint        not null default 0,   -- 清理的消息数量
+  held_count       bigint        not null default 0,   -- 因保全跳过的过期消息数量
+  extra_count      bigint        not null default 0,   -- 清理的关联数据数量
+  min_message_seq  bigint        not null default 0,   -- 清理的最小消息序号
+  max_message_seq  bigint        not null default 0,   -- 清理的最大消息序号
+  min_timestamp    bigint        not null default 0,   -- 清理的最早消息时间
+  max_timestamp    bigint        not null default 0,   -- 清理的最晚消息时间
+  created_at       timeStamp     not null DEFAULT CURRENT_TIMESTAMP, -- 创建时间
+  updated_at       timeStamp     not null DEFAULT CURRENT_TIMESTAMP  -- 更新时间
+);
+CREATE INDEX message_purge_report_item_report_no_idx on `message_purge_report_item` (report_no);
+
+-- 按时间查找过期消息
+CREATE INDEX message_timestamp_idx on `message` (timestamp);
+CREATE INDEX message_timestamp_idx on `message1` (timestamp);
+CREATE INDEX message_timestamp_idx on `message2` (timestamp);
+CREATE INDEX message_timestamp_idx on `message3` (timestamp);
+CREATE INDEX message_timestamp_idx on `message4` (timestamp);
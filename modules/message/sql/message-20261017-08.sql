@@ -0,0 +1,35 @@
+-- +migrate Up
+
+-- 合并转发（聊天记录），由服务端校验转发者对原消息的访问权限后生成
+create table `forward_bundle`(
+  id                   bigint        not null primary key AUTO_INCREMENT,
+  bundle_no            VARCHAR(40)   not null default '',  -- 合并转发编号
+  uid                  VARCHAR(40)   not null default '',  -- 转发者uid
+  source_channel_id    VARCHAR(100)  not null default '',  -- 原消息频道ID（个人频道为用户uid组合后的ID）
+  source_channel_type  smallint      not null default 0,   -- 原消息频道类型
+  to_channel_id        VARCHAR(100)  not null default '',  -- 转发到的频道ID（个人频道为用户uid组合后的ID）
+  to_channel_type      smallint      not null default 0,   -- 转发到的频道类型
+  message_id           VARCHAR(20)   not null default '',  -- 合并转发消息ID
+  message_seq          bigint        not null default 0,   -- 合并转发消息序号
+  message_count        integer       not null default 0,   -- 转发的消息数量
+  created_at           timeStamp     not null DEFAULT CURRENT_TIMESTAMP, -- 创建时间
+  updated_at           timeStamp     not null DEFAULT CURRENT_TIMESTAMP  -- 更新时间
+);
+CREATE UNIQUE INDEX forward_bundle_bundle_no_uidx on `forward_bundle` (bundle_no);
+
+-- 合并转发中的消息（转发时的快照）
+create table `forward_bundle_message`(
+  id            bigint        not null primary key AUTO_INCREMENT,
+  bundle_no     VARCHAR(40)   not null default '',  -- 合并转发编号
+  message_id    VARCHAR(20)   not null default '',  -- 原消息ID
+  message_seq   bigint        not null default 0,   -- 原消息序号
+  from_uid      VARCHAR(40)   not null default '',  -- 原消息发送者uid
+  timestamp     bigint        not null default 0,   -- 原消息时间
+  payload       mediumblob    not null,             -- 消息内容（已编辑的消息为编辑后的内容）
+  edited        smallint      not null default 0,   -- 是否编辑过 0.否 1.是
+  ref_bundle_no VARCHAR(40)   not null default '',  -- 原消息为合并转发时，其合并转发编号
+  created_at    timeStamp     not null DEFAULT CURRENT_TIMESTAMP, -- 创建时间
+  updated_at    timeStamp     not null DEFAULT CURRENT_TIMESTAMP  -- 更新时间
+);
+CREATE INDEX forward_bundle_message_bundle_no_idx on `forward_bundle_message` (bundle_no);
+CREATE INDEX forward_bundle_message_ref_bundle_no_idx on `forward_bundle_message` (ref_bundle_no);
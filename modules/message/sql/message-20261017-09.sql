@@ -0,0 +1,8 @@
+-- +migrate Up
+
+-- 个人数据导出按发送者查询消息
+CREATE INDEX message_from_uid_idx on `message` (from_uid);
+CREATE INDEX message_from_uid_idx on `message1` (from_uid);
+CREATE INDEX message_from_uid_idx on `message2` (from_uid);
+CREATE INDEX message_from_uid_idx on `message3` (from_uid);
+CREATE INDEX message_from_uid_idx on `message4` (from_uid);
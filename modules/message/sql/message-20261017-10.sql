@@ -0,0 +1,6 @@
+-- +migrate Up
+
+-- 违禁词命中记录每条消息只记录一次（webhook重复投递时不再重复记录）
+DELETE h1 FROM `prohibit_word_hit` h1 INNER JOIN `prohibit_word_hit` h2 ON h1.message_id=h2.message_id AND h1.id>h2.id;
+DROP INDEX prohibit_word_hit_message_idx ON `prohibit_word_hit`;
+CREATE UNIQUE INDEX prohibit_word_hit_message_uidx on `prohibit_word_hit` (message_id);
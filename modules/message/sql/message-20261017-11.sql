@@ -0,0 +1,4 @@
+-- +migrate Up
+
+-- 扫描到期未结束的投票
+CREATE INDEX poll_status_deadline_idx on `poll` (status, deadline);
@@ -106,6 +106,38 @@ func (q *QRCode) handleQRCodeInfo(c *wkhttp.Context) {
 		return
 	}
 
+	if strings.HasPrefix(code, group.InviteLinkCodePrefix) { // 群邀请链接 格式： group_invite_xxxx
+		link, err := q.groupDB.QueryInviteLinkWithLinkNo(code[len(group.InviteLinkCodePrefix):])
+		if err != nil {
+			q.Error("查询邀请链接失败！", zap.Error(err))
+			c.ResponseError(errors.New("查询邀请链接失败！"))
+			return
+		}
+		if link == nil {
+			c.ResponseError(errors.New("邀请链接不存在！"))
+			return
+		}
+		if err := link.Check(); err != nil {
+			c.ResponseError(err)
+			return
+		}
+		result, err := q.handleJoinGroup(loginUID, common.QRCodeModel{
+			Type: common.QRCodeTypeGroup,
+			Data: map[string]interface{}{
+				"group_no":  link.GroupNo,
+				"generator": link.Creator, // 链接创建者作为邀请人
+				"link_no":   link.LinkNo,
+			},
+		})
+		if err != nil {
+			q.Error("处理请求失败！", zap.Error(err))
+			c.ResponseError(errors.New("处理请求失败！"))
+			return
+		}
+		c.JSON(http.StatusOK, result)
+		return
+	}
+
 	qrcodeContent, err := q.ctx.GetRedisConn().GetString(fmt.Sprintf("%s%s", common.QRCodeCachePrefix, code))
 	if err != nil {
 		q.Error("获取二维码信息失败！", zap.Error(err))
@@ -178,6 +210,7 @@ func (q *QRCode) handleScanLogin(loginUID string, uuid string, qrCodeModel commo
 func (q *QRCode) handleJoinGroup(loginUID string, qrCodeModel common.QRCodeModel) (interface{}, error) {
 	groupNo := qrCodeModel.Data["group_no"].(string)
 	generator := qrCodeModel.Data["generator"].(string)
+	linkNo, _ := qrCodeModel.Data["link_no"].(string) // 通过邀请链接入群时的链接编号
 
 	exist, err := q.groupDB.ExistMember(loginUID, groupNo) // 已在群内
 	if err != nil {
@@ -193,6 +226,7 @@ func (q *QRCode) handleJoinGroup(loginUID string, qrCodeModel common.QRCodeModel
 		"group_no":  groupNo,   // 群编号
 		"generator": generator, // 二维码生成者
 		"scaner":    loginUID,  // 二维码扫码者
+		"link_no":   linkNo,    // 邀请链接编号
 		"type":      common.AuthCodeTypeJoinGroup,
 	}), time.Minute*30)
 	if err != nil {
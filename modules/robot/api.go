@@ -12,6 +12,8 @@ import (
 	"time"
 
 	"github.com/TangSengDaoDao/TangSengDaoDaoServer/modules/base/app"
+	"github.com/TangSengDaoDao/TangSengDaoDaoServer/modules/message"
+	"github.com/TangSengDaoDao/TangSengDaoDaoServer/modules/message/prohibit"
 	"github.com/TangSengDaoDao/TangSengDaoDaoServer/modules/user"
 	"github.com/TangSengDaoDao/TangSengDaoDaoServerLib/common"
 	"github.com/TangSengDaoDao/TangSengDaoDaoServerLib/config"
@@ -31,6 +33,8 @@ type Robot struct {
 	robotEventPrefix                  string
 	userService                       user.IService
 	appService                        app.IService
+	messageService                    message.IService
+	prohibitService                   prohibit.IService
 	inlineQueryEventsMap              map[string][]*robotEvent // inlineQuery事件
 	inlineQueryEventsMapLock          sync.RWMutex
 	inlineQueryEventResultChanMap     map[string]chan *InlineQueryResult
@@ -46,6 +50,8 @@ func New(ctx *config.Context) *Robot {
 		robotEventPrefix:              "robotEvent:",
 		userService:                   user.NewService(ctx),
 		appService:                    app.NewService(ctx),
+		messageService:                message.NewService(ctx),
+		prohibitService:               prohibit.NewService(ctx),
 		inlineQueryEventsMap:          map[string][]*robotEvent{},
 		inlineQueryEventResultChanMap: map[string]chan *InlineQueryResult{},
 		mentionRegexp:                 regexp.MustCompile(`@\S+`),
@@ -76,6 +82,8 @@ func (rb *Robot) Route(r *wkhttp.WKHttp) {
 		robotAuth.POST("/typing", rb.typing)                       // 输入中
 		robotAuth.POST("/stream/start", rb.streamStart)            // 流式消息开启
 		robotAuth.POST("/stream/end", rb.streamEnd)                // 流式消息结束
+		robotAuth.GET("/scheduled", rb.scheduledList)              // 待发送的定时消息
+		robotAuth.DELETE("/scheduled/:id", rb.scheduledCancel)     // 取消定时消息
 
 	}
 
@@ -235,6 +243,11 @@ func (rb *Robot) sendMessage(c *wkhttp.Context) {
 		c.ResponseError(fmt.Errorf("无效的payload[%s]", util.ToJson(messageReq.Payload)))
 		return
 	}
+	payload, err := rb.prohibitService.FilterPayload(messageReq.Payload)
+	if err != nil {
+		c.ResponseError(err)
+		return
+	}
 	robotID := c.Param("robot_id")
 	userResp, err := rb.userService.GetUserWithUsername(robotID)
 	if err != nil {
@@ -246,12 +259,31 @@ func (rb *Robot) sendMessage(c *wkhttp.Context) {
 		c.ResponseError(fmt.Errorf("机器人[%s]不存在！", robotID))
 		return
 	}
+	if messageReq.SendAt > 0 {
+		scheduledID, err := rb.messageService.AddScheduledMessage(&message.ScheduledMessageAddReq{
+			FromUID:     robotID,
+			FromRobot:   true,
+			ChannelID:   messageReq.ChannelID,
+			ChannelType: messageReq.ChannelType,
+			Payload:     payload,
+			SendAt:      messageReq.SendAt,
+		})
+		if err != nil {
+			rb.Error("添加robot定时消息失败！", zap.Error(err))
+			c.ResponseError(err)
+			return
+		}
+		c.Response(gin.H{
+			"scheduled_id": scheduledID,
+		})
+		return
+	}
 	result, err := rb.ctx.SendMessageWithResult(&config.MsgSendReq{
 		StreamNo:    messageReq.StreamNo,
 		ChannelID:   messageReq.ChannelID,
 		ChannelType: messageReq.ChannelType,
 		FromUID:     robotID,
-		Payload:     []byte(util.ToJson(messageReq.Payload)),
+		Payload:     []byte(util.ToJson(payload)),
 	})
 	if err != nil {
 		rb.Error("发送robot消息失败！", zap.Error(err))
@@ -261,6 +293,33 @@ func (rb *Robot) sendMessage(c *wkhttp.Context) {
 	c.Response(result)
 }
 
+// 机器人在某频道内待发送的定时消息
+func (rb *Robot) scheduledList(c *wkhttp.Context) {
+	channelID := c.Query("channel_id")
+	channelType, _ := strconv.ParseInt(c.Query("channel_type"), 10, 64)
+	if strings.TrimSpace(channelID) == "" {
+		c.ResponseError(errors.New("channel_id不能为空！"))
+		return
+	}
+	resps, err := rb.messageService.GetPendingScheduledMessages(c.Param("robot_id"), channelID, uint8(channelType))
+	if err != nil {
+		rb.Error("查询robot定时消息失败！", zap.Error(err))
+		c.ResponseError(errors.New("查询定时消息失败！"))
+		return
+	}
+	c.Response(resps)
+}
+
+// 取消机器人的定时消息
+func (rb *Robot) scheduledCancel(c *wkhttp.Context) {
+	id, _ := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err := rb.messageService.CancelScheduledMessage(c.Param("robot_id"), id); err != nil {
+		c.ResponseError(err)
+		return
+	}
+	c.ResponseOK()
+}
+
 func (rb *Robot) supportContentType(contentType common.ContentType) bool {
 	return contentType == common.Text
 }
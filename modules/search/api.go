@@ -7,6 +7,7 @@ import (
 
 	"github.com/TangSengDaoDao/TangSengDaoDaoServer/modules/group"
 	"github.com/TangSengDaoDao/TangSengDaoDaoServer/modules/message"
+	"github.com/TangSengDaoDao/TangSengDaoDaoServer/modules/message/msgsearch"
 	"github.com/TangSengDaoDao/TangSengDaoDaoServer/modules/user"
 	"github.com/TangSengDaoDao/TangSengDaoDaoServer/pkg/log"
 	"github.com/TangSengDaoDao/TangSengDaoDaoServer/pkg/util"
@@ -19,18 +20,20 @@ import (
 type Search struct {
 	ctx *config.Context
 	log.Log
-	userService    user.IService
-	groupService   group.IService
-	messageService message.IService
+	userService     user.IService
+	groupService    group.IService
+	messageService  message.IService
+	messageSearcher msgsearch.Searcher
 }
 
 func New(ctx *config.Context) *Search {
 	s := &Search{
-		ctx:            ctx,
-		Log:            log.NewTLog("search"),
-		userService:    user.NewService(ctx),
-		groupService:   group.NewService(ctx),
-		messageService: message.NewService(ctx),
+		ctx:             ctx,
+		Log:             log.NewTLog("search"),
+		userService:     user.NewService(ctx),
+		groupService:    group.NewService(ctx),
+		messageService:  message.NewService(ctx),
+		messageSearcher: msgsearch.NewService(ctx),
 	}
 	return s
 }
@@ -65,17 +68,11 @@ func (s *Search) global(c *wkhttp.Context) {
 	if req.Limit > 100 {
 		req.Limit = 100
 	}
-	payload := map[string]interface{}{
-		"content": req.Keyword,
-		"name":    req.Keyword,
-	}
-	highlights := []string{"payload.content", "payload.name"}
-
 	// 查询消息
-	msgResp, err := s.ctx.IMSearchUserMessages(&config.SearchUserMessageReq{
+	msgResp, err := s.messageSearcher.Search(&msgsearch.SearchReq{
 		UID:          loginUID,
-		Payload:      payload,
-		PayloadTypes: req.ContentType,
+		Keyword:      req.Keyword,
+		ContentTypes: req.ContentType,
 		Limit:        req.Limit,
 		Page:         req.Page,
 		FromUID:      req.FromUID,
@@ -84,11 +81,10 @@ func (s *Search) global(c *wkhttp.Context) {
 		Topic:        req.Topic,
 		StartTime:    req.StartTime,
 		EndTime:      req.EndTime,
-		Highlights:   highlights,
 	})
 	if err != nil {
-		s.Error("查询悟空IM消息错误", zap.Error(err))
-		c.ResponseError(errors.New("查询悟空IM消息错误"))
+		s.Error("查询消息错误", zap.Error(err))
+		c.ResponseError(errors.New("查询消息错误"))
 		return
 	}
 	channelIds := make([]string, 0)
@@ -54,6 +54,7 @@ type User struct {
 	friendDB      *friendDB
 	deviceDB      *deviceDB
 	smsServie     commonapi.ISMSService
+	emailService  commonapi.IEmailService
 	fileService   file.IService
 	settingDB     *SettingDB
 	onlineDB      *onlineDB
@@ -61,6 +62,13 @@ type User struct {
 	onlineService *OnlineService
 	giteeDB       *giteeDB
 	githubDB      *githubDB
+	totpDB        *totpDB
+	sessionDB     *sessionDB
+	oidcDB        *oidcDB
+	ldap          *ldapDirectory
+	loginGuard    *loginGuard
+	webauthnDB    *webauthnDB
+	dataExporter  *dataExporter
 
 	setting *Setting
 	log.Log
@@ -87,6 +95,7 @@ func New(ctx *config.Context) *User {
 		deviceDB:                 newDeviceDB(ctx),
 		friendDB:                 newFriendDB(ctx),
 		smsServie:                commonapi.NewSMSService(ctx),
+		emailService:             commonapi.NewEmailService(ctx, common2.NewService(ctx)),
 		settingDB:                NewSettingDB(ctx.DB()),
 		setting:                  NewSetting(ctx),
 		userDeviceTokenPrefix:    common.UserDeviceTokenPrefix,
@@ -105,11 +114,19 @@ func New(ctx *config.Context) *User {
 		deviceFlagDB:             newDeviceFlagDB(ctx),
 		giteeDB:                  newGiteeDB(ctx),
 		githubDB:                 newGithubDB(ctx),
+		totpDB:                   newTOTPDB(ctx),
+		sessionDB:                newSessionDB(ctx),
+		oidcDB:                   newOIDCDB(ctx),
+		ldap:                     newLDAPDirectory(ctx),
+		loginGuard:               newLoginGuard(ctx),
+		webauthnDB:               newWebauthnDB(ctx),
+		dataExporter:             newDataExporter(ctx),
 		commonService:            common2.NewService(ctx),
 		appService:               app.NewService(ctx),
 	}
 	u.updateSystemUserToken()
 	u.AddSystemUids()
+	u.dataExporter.start() // 个人数据后台导出
 	source.SetUserProvider(u)
 	return u
 }
@@ -125,6 +142,7 @@ func (u *User) Route(r *wkhttp.WKHttp) {
 
 		auth.POST("/users/:uid/avatar", u.uploadAvatar)              //上传用户头像
 		auth.PUT("/users/:uid/setting", u.setting.userSettingUpdate) // 更新用户设置
+		auth.GET("/users/:uid/signal/bundle", u.signalPrekeyBundle)  // 获取用户的公钥包（消耗一个一次性公钥）
 	}
 
 	user := r.Group("/v1/user", u.ctx.AuthMiddleware(r))
@@ -146,9 +164,35 @@ func (u *User) Route(r *wkhttp.WKHttp) {
 		user.GET("/customerservices", u.customerservices)          //客服列表
 		user.DELETE("/destroy/:code", u.destroyAccount)            // 注销用户
 		user.POST("/sms/destroy", u.sendDestroyCode)               //获取注销账号短信验证码
+		user.POST("/email/destroy", u.sendDestroyEmailCode)        // 获取注销账号邮箱验证码
+		user.POST("/email/bindcode", u.sendBindEmailCode)          // 获取绑定邮箱验证码
+		user.POST("/email/bind", u.bindEmail)                      // 绑定邮箱
 		user.PUT("/updatepassword", u.updatePwd)                   // 修改登录密码
 		user.POST("/web3publickey", u.uploadWeb3PublicKey)         // 上传web3公钥
 		user.POST("/quit", u.quit)                                 // 退出登录
+		user.GET("/totp", u.totpStatus)                            // 两步验证状态
+		user.POST("/totp/enroll", u.totpEnroll)                    // 开始绑定两步验证
+		user.POST("/totp/enroll/verify", u.totpEnrollVerify)       // 验证并开启两步验证
+		user.POST("/totp/recovery_codes", u.totpRecoveryCodes)     // 重新生成恢复码
+		user.POST("/totp/disable", u.totpDisable)                  // 关闭两步验证
+		user.GET("/sessions", u.sessionList)                       // 我的登录会话
+		user.DELETE("/sessions/:session_id", u.sessionRevoke)      // 撤销登录会话
+		// #################### 个人数据导出 ####################
+		user.POST("/data_export", u.dataExportAdd)                  // 申请导出个人数据
+		user.GET("/data_exports", u.dataExportList)                 // 个人数据导出记录
+		user.GET("/data_exports/:export_no", u.dataExportGet)       // 个人数据导出详情
+		user.GET("/data_exports/:export_no/file", u.dataExportFile) // 下载个人数据归档
+		// #################### 通行密钥 ####################
+		user.POST("/webauthn/register/begin", u.webauthnRegisterBegin)       // 开始注册通行密钥
+		user.POST("/webauthn/register/finish", u.webauthnRegisterFinish)     // 完成注册通行密钥
+		user.GET("/webauthn/credentials", u.webauthnCredentials)             // 我的通行密钥
+		user.PUT("/webauthn/credentials/:id", u.webauthnCredentialUpdate)    // 修改通行密钥名称
+		user.DELETE("/webauthn/credentials/:id", u.webauthnCredentialDelete) // 删除通行密钥
+		// #################### 端对端加密 ####################
+		user.POST("/signal/keys", u.signalKeysUpload)                         // 上传身份公钥和签名key
+		user.PUT("/signal/signed_prekey", u.signalSignedPrekeyUpdate)         // 更换签名key
+		user.POST("/signal/onetime_prekeys", u.signalOnetimePrekeysRefill)    // 补充一次性公钥
+		user.GET("/signal/onetime_prekeys/count", u.signalOnetimePrekeyCount) // 剩余一次性公钥数量
 		// #################### 登录设备管理 ####################
 		user.GET("/devices", u.deviceList)                 // 用户登录设备
 		user.DELETE("/devices/:device_id", u.deviceDelete) // 删除登录设备
@@ -172,6 +216,7 @@ func (u *User) Route(r *wkhttp.WKHttp) {
 		v.POST("/user/login", u.login)                       // 用户登录
 		v.POST("/user/usernamelogin", u.usernameLogin)       // 用户名登录
 		v.POST("/user/usernameregister", u.usernameRegister) // 用户名注册
+		v.GET("/user/login/captcha", u.loginCaptcha)         // 登录图片验证码
 
 		v.POST("/user/pwdforget_web3", u.resetPwdWithWeb3PublicKey) // 通过web3公钥重置密码
 		v.GET("/user/web3verifytext", u.getVerifyText)              // 获取验证字符串
@@ -188,7 +233,18 @@ func (u *User) Route(r *wkhttp.WKHttp) {
 		v.POST("/user/login_authcode/:auth_code", u.loginWithAuthCode)   // 通过认证码登录
 		v.POST("/user/sms/login_check_phone", u.sendLoginCheckPhoneCode) //发送登录设备验证验证码
 		v.POST("/user/login/check_phone", u.loginCheckPhone)             //登录验证设备手机号
-
+		v.POST("/user/login/totp", u.loginTOTP)                          // 登录两步验证
+		v.POST("/user/token/refresh", u.refreshToken)                    // 刷新token
+		v.POST("/user/webauthn/login/begin", u.webauthnLoginBegin)       // 开始通行密钥登录
+		v.POST("/user/webauthn/login/finish", u.webauthnLoginFinish)     // 完成通行密钥登录
+
+		// #################### 邮箱验证码 ####################
+		v.POST("/user/email/registercode", u.sendEmailRegisterCode)        // 获取邮箱注册验证码
+		v.POST("/user/email/register", u.emailRegister)                    // 邮箱注册
+		v.POST("/user/email/forgetpwd", u.sendEmailForgetPwdCode)          // 获取邮箱找回密码验证码
+		v.POST("/user/email/pwdforget", u.emailPwdForget)                  // 通过邮箱重置登录密码
+		v.POST("/user/email/login_check_email", u.sendLoginCheckEmailCode) // 发送登录设备验证邮箱验证码
+		v.POST("/user/login/check_email", u.loginCheckEmail)               // 登录验证设备邮箱
 		// #################### 第三方授权 ####################
 		v.GET("/user/thirdlogin/authcode", u.thirdAuthcode)     // 第三方授权码获取
 		v.GET("/user/thirdlogin/authstatus", u.thirdAuthStatus) // github认证页面
@@ -198,12 +254,17 @@ func (u *User) Route(r *wkhttp.WKHttp) {
 		// gitee
 		v.GET("/user/gitee", u.gitee)            // gitee认证页面
 		v.GET("/user/oauth/gitee", u.giteeOAuth) // gitee登录
+		// OpenID Connect
+		v.GET("/user/thirdlogin/oidc", u.oidcProviders)     // 可用的身份提供方
+		v.GET("/user/oidc/:provider", u.oidcAuthorize)      // 身份提供方认证页面
+		v.GET("/user/oauth/oidc/:provider", u.oidcCallback) // 身份提供方登录
 
 	}
 
 	u.ctx.AddOnlineStatusListener(u.onlineService.listenOnlineStatus) // 监听在线状态
 	u.ctx.AddOnlineStatusListener(u.handleOnlineStatus)               // 需要放在listenOnlineStatus之后
 	u.ctx.Schedule(time.Minute*5, u.onlineStatusCheck)                // 在线状态定时检查
+	u.ctx.Schedule(time.Minute*10, u.ldapSyncCheck)                   // 目录定时同步
 
 }
 
@@ -230,6 +291,20 @@ func (u *User) quit(c *wkhttp.Context) {
 		c.ResponseError(errors.New("删除设备token失败！"))
 		return
 	}
+	// 撤销当前登录会话
+	session, err := u.sessionDB.queryWithAccessToken(c.GetHeader("token"))
+	if err != nil {
+		u.Error("查询登录会话失败！", zap.Error(err))
+		c.ResponseError(errors.New("查询登录会话失败！"))
+		return
+	}
+	if session != nil && session.UID == loginUID {
+		err = revokeSessionToken(u.ctx, u.sessionDB, session)
+		if err != nil {
+			c.ResponseError(err)
+			return
+		}
+	}
 	c.ResponseOK()
 }
 
@@ -267,7 +342,7 @@ func (u *User) getRedDot(c *wkhttp.Context) {
 		c.ResponseError(errors.New("分类不能为空"))
 		return
 	}
-	userRedDot, err := u.db.queryUserRedDot(loginUID, UserRedDotCategoryFriendApply)
+	userRedDot, err := u.db.queryUserRedDot(loginUID, category)
 	if err != nil {
 		u.Error("查询用户红点错误", zap.Error(err))
 		c.ResponseError(errors.New("查询用户红点错误"))
@@ -968,6 +1043,33 @@ func (u *User) login(c *wkhttp.Context) {
 	loginSpan.SetTag("username", req.Username)
 	defer loginSpan.Finish()
 
+	// 连续登录失败的账号或IP需要等待、输入验证码或已被锁定
+	publicIP := util.GetClientPublicIP(c.Request)
+	if err := u.loginGuard.check(req.Username, publicIP, req.CaptchaID, req.CaptchaCode); err != nil {
+		responseLoginGuardError(err, c)
+		return
+	}
+
+	// 开启目录认证时优先使用目录校验密码
+	ldapUser, ldapResult, err := u.ldapLogin(req.Username, req.Password, config.DeviceFlag(req.Flag), req.Device, loginSpanCtx, c)
+	if err != nil {
+		if errors.Is(err, ErrPasswordIncorrect) {
+			u.loginGuard.fail(req.Username, "", publicIP)
+		}
+		c.ResponseError(err)
+		return
+	}
+	if ldapResult != nil {
+		u.loginGuard.success(req.Username)
+		c.Response(ldapResult)
+		return
+	}
+	if ldapUser != nil {
+		u.loginGuard.success(req.Username)
+		u.execLoginAndRespose(ldapUser, config.DeviceFlag(req.Flag), req.Device, loginSpanCtx, c)
+		return
+	}
+
 	userInfo, err := u.db.QueryByUsernameCxt(loginSpanCtx, req.Username)
 	if err != nil {
 		u.Error("查询用户信息失败！", zap.String("username", req.Username))
@@ -975,6 +1077,7 @@ func (u *User) login(c *wkhttp.Context) {
 		return
 	}
 	if userInfo == nil || userInfo.IsDestroy == 1 {
+		u.loginGuard.fail(req.Username, "", publicIP)
 		c.ResponseError(errors.New("用户不存在"))
 		return
 	}
@@ -982,8 +1085,20 @@ func (u *User) login(c *wkhttp.Context) {
 		c.ResponseError(errors.New("此账号不允许登录"))
 		return
 	}
-	if util.MD5(util.MD5(req.Password)) != userInfo.Password {
-		c.ResponseError(errors.New("密码不正确！"))
+	ok, err := verifyPassword(u.db, userInfo.UID, req.Password, userInfo.Password, userInfo.PasswordAlgo, userInfo.PasswordReset)
+	if err != nil {
+		u.Error("校验密码失败！", zap.Error(err), zap.String("uid", userInfo.UID))
+		c.ResponseError(errors.New("校验密码失败！"))
+		return
+	}
+	if !ok {
+		u.loginGuard.fail(req.Username, userInfo.UID, publicIP)
+		c.ResponseError(ErrPasswordIncorrect)
+		return
+	}
+	u.loginGuard.success(req.Username)
+	if userInfo.PasswordReset == 1 {
+		c.ResponseError(ErrPasswordNeedReset)
 		return
 	}
 	u.execLoginAndRespose(userInfo, config.DeviceFlag(req.Flag), req.Device, loginSpanCtx, c)
@@ -992,19 +1107,14 @@ func (u *User) login(c *wkhttp.Context) {
 // 验证登录用户信息
 func (u *User) execLoginAndRespose(userInfo *Model, flag config.DeviceFlag, device *deviceReq, loginSpanCtx context.Context, c *wkhttp.Context) {
 
-	result, err := u.execLogin(userInfo, flag, device, loginSpanCtx)
+	result, err := u.execLogin(userInfo, flag, device, newLoginClient(c), loginSpanCtx)
 	if err != nil {
+		if errors.Is(err, ErrUserNeedTOTP) {
+			u.responseNeedTOTP(userInfo, flag, device, c)
+			return
+		}
 		if errors.Is(err, ErrUserNeedVerification) {
-			phone := ""
-			if len(userInfo.Phone) > 5 {
-				phone = fmt.Sprintf("%s******%s", userInfo.Phone[0:3], userInfo.Phone[len(userInfo.Phone)-2:])
-			}
-			c.ResponseWithStatus(http.StatusBadRequest, map[string]interface{}{
-				"status": 110,
-				"msg":    "需要验证手机号码！",
-				"uid":    userInfo.UID,
-				"phone":  phone,
-			})
+			u.responseNeedVerification(userInfo, c)
 			return
 		}
 		c.ResponseError(err)
@@ -1017,7 +1127,55 @@ func (u *User) execLoginAndRespose(userInfo *Model, flag config.DeviceFlag, devi
 	go u.sentWelcomeMsg(publicIP, userInfo.UID)
 }
 
-func (u *User) execLogin(userInfo *Model, flag config.DeviceFlag, device *deviceReq, loginSpanCtx context.Context) (*loginUserDetailResp, error) {
+// 返回需要验证登录设备的手机号或邮箱
+func (u *User) responseNeedVerification(userInfo *Model, c *wkhttp.Context) {
+	c.ResponseWithStatus(http.StatusBadRequest, needVerificationResp(userInfo))
+}
+
+func needVerificationResp(userInfo *Model) map[string]interface{} {
+	phone := ""
+	if len(userInfo.Phone) > 5 {
+		phone = fmt.Sprintf("%s******%s", userInfo.Phone[0:3], userInfo.Phone[len(userInfo.Phone)-2:])
+	}
+	email := ""
+	if userInfo.EmailVerified == 1 {
+		email = maskEmail(userInfo.Email)
+	}
+	return map[string]interface{}{
+		"status": 110,
+		"msg":    "需要验证手机号码！",
+		"uid":    userInfo.UID,
+		"phone":  phone,
+		"email":  email, // 有已验证的邮箱时也可以通过邮箱验证
+	}
+}
+
+// 开启了两步验证的用户返回ErrUserNeedTOTP，验证通过后再调用execLoginAfterTOTP
+// 后台要求开启两步验证时，未开启的管理账号返回ErrUserNeedTOTPEnroll
+func (u *User) execLogin(userInfo *Model, flag config.DeviceFlag, device *deviceReq, client *loginClient, loginSpanCtx context.Context) (*loginUserDetailResp, error) {
+	enabled, err := totpEnabled(u.totpDB, userInfo.UID)
+	if err != nil {
+		u.Error("查询两步验证失败！", zap.Error(err))
+		return nil, errors.New("查询两步验证失败！")
+	}
+	if enabled {
+		return nil, ErrUserNeedTOTP
+	}
+	// 管理账号的登录token也可以访问后台接口，后台要求开启两步验证时未开启的管理账号需要先通过后台登录绑定
+	if userInfo.Role == string(wkhttp.Admin) || userInfo.Role == string(wkhttp.SuperAdmin) {
+		appConfig, err := u.commonService.GetAppConfig()
+		if err != nil {
+			u.Error("查询应用配置失败！", zap.Error(err))
+			return nil, errors.New("查询应用配置失败！")
+		}
+		if appConfig != nil && appConfig.ManagerTOTPOn == 1 {
+			return nil, ErrUserNeedTOTPEnroll
+		}
+	}
+	return u.execLoginAfterTOTP(userInfo, flag, device, client, loginSpanCtx)
+}
+
+func (u *User) execLoginAfterTOTP(userInfo *Model, flag config.DeviceFlag, device *deviceReq, client *loginClient, loginSpanCtx context.Context) (*loginUserDetailResp, error) {
 	if userInfo.Status == int(common.UserDisable) {
 		return nil, errors.New("该用户已被禁用")
 	}
@@ -1069,43 +1227,12 @@ func (u *User) execLogin(userInfo *Model, flag config.DeviceFlag, device *device
 		}
 
 	}
-	token := util.GenerUUID()
-	// 将token设置到缓存
-	tokenSpan, _ := u.ctx.Tracer().StartSpanFromContext(loginSpanCtx, "SetAndExpire")
-	tokenSpan.SetTag("key", "token")
-	// 获取老的token并清除老token数据
-	oldToken, err := u.ctx.Cache().Get(fmt.Sprintf("%s%d%s", u.ctx.GetConfig().Cache.UIDTokenCachePrefix, flag, userInfo.UID))
-	if err != nil {
-		u.Error("获取旧token错误", zap.Error(err))
-		tokenSpan.Finish()
-		return nil, errors.New("获取旧token错误")
-	}
-	if flag == config.APP {
-		if oldToken != "" {
-			err = u.ctx.Cache().Delete(u.ctx.GetConfig().Cache.TokenCachePrefix + oldToken)
-			if err != nil {
-				u.Error("清除旧token数据错误", zap.Error(err))
-				tokenSpan.Finish()
-				return nil, errors.New("清除旧token数据错误")
-			}
-		}
-	} else { // PC暂时不执行删除操作，因为PC可以同时登陆
-		if strings.TrimSpace(oldToken) != "" { // 如果是web或pc类设备 因为支持多登所以这里依然使用老token
-			token = oldToken
-		}
-	}
-
-	err = u.ctx.Cache().SetAndExpire(u.ctx.GetConfig().Cache.TokenCachePrefix+token, wkhttp.EncodeTokenCacheInfo(userInfo.UID, userInfo.Name, userInfo.Role), u.ctx.GetConfig().Cache.TokenExpire)
-	if err != nil {
-		u.Error("设置token缓存失败！", zap.Error(err))
-		tokenSpan.Finish()
-		return nil, errors.New("设置token缓存失败！")
-	}
-	err = u.ctx.Cache().SetAndExpire(fmt.Sprintf("%s%d%s", u.ctx.GetConfig().Cache.UIDTokenCachePrefix, flag, userInfo.UID), token, u.ctx.GetConfig().Cache.TokenExpire)
+	// 创建登录会话
+	tokenSpan, _ := u.ctx.Tracer().StartSpanFromContext(loginSpanCtx, "createSession")
+	session, err := createSession(u.ctx, u.sessionDB, userInfo, flag, device, client)
 	if err != nil {
-		u.Error("设置uidtoken缓存失败！", zap.Error(err))
 		tokenSpan.Finish()
-		return nil, errors.New("设置uidtoken缓存失败！")
+		return nil, err
 	}
 	tokenSpan.Finish()
 
@@ -1113,7 +1240,7 @@ func (u *User) execLogin(userInfo *Model, flag config.DeviceFlag, device *device
 
 	imTokenReq := config.UpdateIMTokenReq{
 		UID:         userInfo.UID,
-		Token:       token,
+		Token:       session.IMToken,
 		DeviceFlag:  config.DeviceFlag(flag),
 		DeviceLevel: deviceLevel,
 	}
@@ -1130,7 +1257,7 @@ func (u *User) execLogin(userInfo *Model, flag config.DeviceFlag, device *device
 		return nil, errors.New("此账号已经被封禁！")
 	}
 
-	return newLoginUserDetailResp(userInfo, token, u.ctx), nil
+	return newLoginUserDetailResp(userInfo, session.AccessToken, u.ctx).withSession(session), nil
 }
 
 // sendWelcomeMsg 发送欢迎语
@@ -1193,38 +1320,11 @@ func (u *User) register(c *wkhttp.Context) {
 		c.ResponseError(errors.New("注册通道暂不开放，请长按标题使用官网上演示账号登录"))
 		return
 	}
-	appConfig, err := u.commonService.GetAppConfig()
+	invite, err := u.checkRegisterInvite(req.InviteCode)
 	if err != nil {
-		u.Error("查询应用设置错误", zap.Error(err))
 		c.ResponseError(err)
 		return
 	}
-	var registerInviteOn = 0
-	if appConfig != nil {
-		registerInviteOn = appConfig.RegisterInviteOn
-	}
-	var invite *model.Invite
-	if registerInviteOn == 1 {
-		if req.InviteCode == "" {
-			c.ResponseError(errors.New("邀请码不能为空"))
-			return
-		}
-		var inviteCodeIsExist = false
-		modules := register.GetModules(u.ctx)
-		for _, m := range modules {
-			if m.BussDataSource.GetInviteCode != nil {
-				invite, _ = m.BussDataSource.GetInviteCode(req.InviteCode)
-				if invite != nil && invite.Uid != "" {
-					inviteCodeIsExist = true
-					break
-				}
-			}
-		}
-		if !inviteCodeIsExist {
-			c.ResponseError(errors.New("邀请码不存在"))
-			return
-		}
-	}
 	registerSpan := u.ctx.Tracer().StartSpan(
 		"user.register",
 		opentracing.ChildOf(c.GetSpanContext()),
@@ -1272,6 +1372,35 @@ func (u *User) register(c *wkhttp.Context) {
 	u.createUser(registerSpanCtx, model, c, invite)
 }
 
+// 开启注册邀请机制时校验邀请码
+func (u *User) checkRegisterInvite(inviteCode string) (*model.Invite, error) {
+	appConfig, err := u.commonService.GetAppConfig()
+	if err != nil {
+		u.Error("查询应用设置错误", zap.Error(err))
+		return nil, err
+	}
+	var registerInviteOn = 0
+	if appConfig != nil {
+		registerInviteOn = appConfig.RegisterInviteOn
+	}
+	if registerInviteOn != 1 {
+		return nil, nil
+	}
+	if inviteCode == "" {
+		return nil, errors.New("邀请码不能为空")
+	}
+	modules := register.GetModules(u.ctx)
+	for _, m := range modules {
+		if m.BussDataSource.GetInviteCode != nil {
+			invite, _ := m.BussDataSource.GetInviteCode(inviteCode)
+			if invite != nil && invite.Uid != "" {
+				return invite, nil
+			}
+		}
+	}
+	return nil, errors.New("邀请码不存在")
+}
+
 // 搜索用户
 func (u *User) search(c *wkhttp.Context) {
 	keyword := c.Query("keyword")
@@ -1503,16 +1632,6 @@ func (u *User) loginWithAuthCode(c *wkhttp.Context) {
 		return
 	}
 	scaner := authInfoMap["scaner"].(string)
-	// 获取老的token
-	token, err := u.ctx.Cache().Get(fmt.Sprintf("%s%d%s", u.ctx.GetConfig().Cache.UIDTokenCachePrefix, flag, scaner))
-	if err != nil {
-		u.Error("获取旧token错误", zap.Error(err))
-		c.ResponseError(errors.New("获取旧token错误"))
-		return
-	}
-	if strings.TrimSpace(token) == "" {
-		token = util.GenerUUID()
-	}
 
 	userModel, err := u.db.QueryByUID(scaner)
 	if err != nil {
@@ -1521,6 +1640,7 @@ func (u *User) loginWithAuthCode(c *wkhttp.Context) {
 		return
 	}
 	// 获取缓存设备
+	var loginDevice *deviceReq
 	uuid := authInfoMap["uuid"].(string)
 	if uuid != "" {
 		deviceCache, err := u.ctx.GetRedisConn().GetString(fmt.Sprintf("%s%s", common.DeviceCacheUUIDPrefix, uuid))
@@ -1560,12 +1680,22 @@ func (u *User) loginWithAuthCode(c *wkhttp.Context) {
 					c.ResponseError(errors.New("更新用户登录设备失败"))
 					return
 				}
+				loginDevice = &deviceReq{
+					DeviceID:    deviceId,
+					DeviceName:  deviceName,
+					DeviceModel: dmodel,
+				}
 			}
 		}
 	}
+	session, err := createSession(u.ctx, u.sessionDB, userModel, flag, loginDevice, newLoginClient(c))
+	if err != nil {
+		c.ResponseError(err)
+		return
+	}
 	imResp, err := u.ctx.UpdateIMToken(config.UpdateIMTokenReq{
 		UID:         scaner,
-		Token:       token,
+		Token:       session.IMToken,
 		DeviceFlag:  flag,
 		DeviceLevel: config.DeviceLevelSlave,
 	})
@@ -1579,13 +1709,6 @@ func (u *User) loginWithAuthCode(c *wkhttp.Context) {
 		return
 	}
 
-	// 将token设置到缓存
-	err = u.ctx.Cache().SetAndExpire(u.ctx.GetConfig().Cache.TokenCachePrefix+token, wkhttp.EncodeTokenCacheInfo(userModel.UID, userModel.Name, ""), u.ctx.GetConfig().Cache.TokenExpire)
-	if err != nil {
-		u.Error("设置token缓存失败！", zap.Error(err))
-		c.ResponseError(errors.New("设置token缓存失败！"))
-		return
-	}
 	err = u.ctx.GetRedisConn().Del(authCodeKey)
 	if err != nil {
 		u.Error("删除授权码失败！", zap.Error(err))
@@ -1593,22 +1716,19 @@ func (u *User) loginWithAuthCode(c *wkhttp.Context) {
 		return
 	}
 
-	err = u.ctx.Cache().SetAndExpire(fmt.Sprintf("%s%d%s", u.ctx.GetConfig().Cache.UIDTokenCachePrefix, flag, userModel.UID), token, u.ctx.GetConfig().Cache.TokenExpire)
-	if err != nil {
-		u.Error("设置uidtoken缓存失败！", zap.Error(err))
-		c.ResponseError(errors.New("设置uidtoken缓存失败！"))
-		return
-	}
-
 	c.Response(map[string]interface{}{
-		"app_id":     userModel.AppID,
-		"name":       userModel.Name,
-		"username":   userModel.Username,
-		"uid":        userModel.UID,
-		"token":      token,
-		"short_no":   userModel.ShortNo,
-		"avatar":     u.ctx.GetConfig().GetAvatarPath(userModel.UID),
-		"im_pub_key": "",
+		"app_id":        userModel.AppID,
+		"name":          userModel.Name,
+		"username":      userModel.Username,
+		"uid":           userModel.UID,
+		"token":         session.AccessToken,
+		"short_no":      userModel.ShortNo,
+		"avatar":        u.ctx.GetConfig().GetAvatarPath(userModel.UID),
+		"im_pub_key":    "",
+		"session_id":    session.SessionID,
+		"refresh_token": session.RefreshToken,
+		"im_token":      session.IMToken,
+		"expires_in":    session.ExpiresIn,
 	})
 }
 
@@ -1981,7 +2101,13 @@ func (u *User) setChatPwd(c *wkhttp.Context) {
 		c.ResponseError(errors.New("查询用户信息失败"))
 		return
 	}
-	if user.Password != util.MD5(util.MD5(req.LoginPwd)) {
+	ok, err := verifyPassword(u.db, user.UID, req.LoginPwd, user.Password, user.PasswordAlgo, user.PasswordReset)
+	if err != nil {
+		u.Error("校验密码失败！", zap.Error(err), zap.String("uid", user.UID))
+		c.ResponseError(errors.New("校验密码失败！"))
+		return
+	}
+	if !ok {
 		c.ResponseError(errors.New("登录密码错误"))
 		return
 	}
@@ -2149,8 +2275,12 @@ func (u *User) loginCheckPhone(c *wkhttp.Context) {
 		c.ResponseError(err)
 		return
 	}
+	u.loginCheckDeviceSuccess(userInfo, spanCtx, c)
+}
 
-	loginDeviceJsonStr, err := u.ctx.GetRedisConn().GetString(fmt.Sprintf("%s%s", u.ctx.GetConfig().Cache.LoginDeviceCachePrefix, req.UID))
+// 登录设备验证通过后保存设备并完成登录
+func (u *User) loginCheckDeviceSuccess(userInfo *Model, spanCtx context.Context, c *wkhttp.Context) {
+	loginDeviceJsonStr, err := u.ctx.GetRedisConn().GetString(fmt.Sprintf("%s%s", u.ctx.GetConfig().Cache.LoginDeviceCachePrefix, userInfo.UID))
 	if err != nil {
 		u.Error("获取登录设备缓存失败！", zap.Error(err))
 		c.ResponseError(errors.New("获取登录设备缓存失败！"))
@@ -2163,7 +2293,7 @@ func (u *User) loginCheckPhone(c *wkhttp.Context) {
 	var loginDeivce *deviceReq
 	err = util.ReadJsonByByte([]byte(loginDeviceJsonStr), &loginDeivce)
 	if err != nil {
-		u.Error("解码登录设备信息失败！", zap.Error(err), zap.String("uid", req.UID))
+		u.Error("解码登录设备信息失败！", zap.Error(err), zap.String("uid", userInfo.UID))
 		c.ResponseError(errors.New("解码登录设备信息失败！"))
 		return
 	}
@@ -2179,18 +2309,15 @@ func (u *User) loginCheckPhone(c *wkhttp.Context) {
 		c.ResponseError(errors.New("添加或更新登录设备信息失败！"))
 		return
 	}
-	token := util.GenerUUID()
-	// 将token设置到缓存
-	err = u.ctx.Cache().SetAndExpire(u.ctx.GetConfig().Cache.TokenCachePrefix+token, wkhttp.EncodeTokenCacheInfo(userInfo.UID, userInfo.Name, ""), u.ctx.GetConfig().Cache.TokenExpire)
+	session, err := createSession(u.ctx, u.sessionDB, userInfo, config.APP, loginDeivce, newLoginClient(c))
 	if err != nil {
-		u.Error("设置token缓存失败！", zap.Error(err))
-		c.ResponseError(errors.New("设置token缓存失败！"))
+		c.ResponseError(err)
 		return
 	}
 	// err = u.ctx.UpdateIMToken(userInfo.UID, token, config.DeviceFlag(0), config.DeviceLevelMaster)
 	imResp, err := u.ctx.UpdateIMToken(config.UpdateIMTokenReq{
 		UID:         userInfo.UID,
-		Token:       token,
+		Token:       session.IMToken,
 		DeviceFlag:  config.APP,
 		DeviceLevel: config.DeviceLevelMaster,
 	})
@@ -2203,7 +2330,7 @@ func (u *User) loginCheckPhone(c *wkhttp.Context) {
 		c.ResponseError(errors.New("此账号已经被封禁！"))
 		return
 	}
-	c.Response(newLoginUserDetailResp(userInfo, token, u.ctx))
+	c.Response(newLoginUserDetailResp(userInfo, session.AccessToken, u.ctx).withSession(session))
 }
 
 // customerservices 客服列表
@@ -2265,12 +2392,24 @@ func (u *User) destroyAccount(c *wkhttp.Context) {
 		c.ResponseError(errors.New("登录用户不存在"))
 		return
 	}
+	// 没有绑定手机号的账号通过已验证的邮箱注销
+	byEmail := c.Query("channel") == "email" || userInfo.Phone == ""
+	if byEmail && (userInfo.EmailVerified != 1 || userInfo.Email == "") {
+		c.ResponseError(errors.New("未绑定邮箱"))
+		return
+	}
 	//测试模式
 	if strings.TrimSpace(u.ctx.GetConfig().SMSCode) != "" {
 		if strings.TrimSpace(u.ctx.GetConfig().SMSCode) != code {
 			c.ResponseError(errors.New("验证码错误"))
 			return
 		}
+	} else if byEmail {
+		err = u.emailService.Verify(c.Context, userInfo.Email, code, commonapi.CodeTypeDestroyAccount)
+		if err != nil {
+			c.ResponseError(err)
+			return
+		}
 	} else {
 		//线上验证短信验证码
 		// 校验验证码
@@ -2285,12 +2424,21 @@ func (u *User) destroyAccount(c *wkhttp.Context) {
 	time := fmt.Sprintf("%d%d%d%d%d", t.Year(), t.Month(), t.Day(), t.Minute(), t.Second())
 	phone := fmt.Sprintf("%s@%s@delete", userInfo.Phone, time)
 	username := fmt.Sprintf("%s%s", userInfo.Zone, phone)
+	if userInfo.Phone == "" {
+		phone = ""
+		username = fmt.Sprintf("%s@%s@delete", userInfo.Username, time)
+	}
 	err = u.db.destroyAccount(loginUID, username, phone)
 	if err != nil {
 		u.Error("注销账号错误", zap.Error(err))
 		c.ResponseError(errors.New("注销账号错误"))
 		return
 	}
+	_, err = revokeUserSessions(u.ctx, u.sessionDB, c.GetLoginUID())
+	if err != nil {
+		c.ResponseError(err)
+		return
+	}
 	err = u.ctx.QuitUserDevice(c.GetLoginUID(), -1) // 退出全部登陆设备
 	if err != nil {
 		u.Error("退出登陆设备失败", zap.Error(err))
@@ -2439,7 +2587,7 @@ func (u *User) pwdforget(c *wkhttp.Context) {
 		}
 	}
 
-	err = u.db.UpdateUsersWithField("password", util.MD5(util.MD5(req.Pwd)), userInfo.UID)
+	err = updatePassword(u.db, userInfo.UID, req.Pwd)
 	if err != nil {
 		u.Error("修改登录密码错误", zap.Error(err))
 		c.ResponseError(errors.New("修改登录密码错误"))
@@ -2584,11 +2732,19 @@ func (u *User) createUserWithRespAndTx(registerSpanCtx context.Context, createUs
 		userModel.Username = fmt.Sprintf("%s%s", createUser.Zone, createUser.Phone)
 	}
 	if createUser.Password != "" {
-		userModel.Password = util.MD5(util.MD5(createUser.Password))
+		err = setModelPassword(userModel, createUser.Password)
+		if err != nil {
+			u.Error("生成密码哈希失败！", zap.Error(err))
+			return nil, err
+		}
 	}
 	if createUser.Username != "" {
 		userModel.Username = createUser.Username
 	}
+	if createUser.Email != "" {
+		userModel.Email = createUser.Email
+		userModel.EmailVerified = 1
+	}
 
 	userModel.ShortNo = shortNo
 	userModel.OfflineProtection = 0
@@ -2660,16 +2816,13 @@ func (u *User) createUserWithRespAndTx(registerSpanCtx context.Context, createUs
 		commitCallback()
 	}
 	u.ctx.EventCommit(eventID)
-	token := util.GenerUUID()
-	// 将token设置到缓存
-	err = u.ctx.Cache().SetAndExpire(u.ctx.GetConfig().Cache.TokenCachePrefix+token, wkhttp.EncodeTokenCacheInfo(userModel.UID, userModel.Name, userModel.Role), u.ctx.GetConfig().Cache.TokenExpire)
+	session, err := createSession(u.ctx, u.sessionDB, userModel, config.DeviceFlag(createUser.Flag), createUser.Device, &loginClient{IP: publicIP})
 	if err != nil {
-		u.Error("设置token缓存失败！", zap.Error(err))
 		return nil, err
 	}
 	_, err = u.ctx.UpdateIMToken(config.UpdateIMTokenReq{
 		UID:         createUser.UID,
-		Token:       token,
+		Token:       session.IMToken,
 		DeviceFlag:  config.DeviceFlag(createUser.Flag),
 		DeviceLevel: config.DeviceLevelSlave,
 	})
@@ -2686,7 +2839,7 @@ func (u *User) createUserWithRespAndTx(registerSpanCtx context.Context, createUs
 		}
 	}
 
-	return newLoginUserDetailResp(userModel, token, u.ctx), nil
+	return newLoginUserDetailResp(userModel, session.AccessToken, u.ctx).withSession(session), nil
 }
 
 // ---------- vo ----------
@@ -2702,6 +2855,7 @@ type createUserModel struct {
 	GiteeUID       string
 	GithubUID      string
 	Username       string
+	Email          string // 已验证的邮箱
 	Flag           int
 	IsUploadAvatar int
 	Device         *deviceReq
@@ -2764,6 +2918,9 @@ type loginReq struct {
 	Password string     `json:"password"`
 	Flag     int        `json:"flag"`   // 设备标示 0.APP 1.PC
 	Device   *deviceReq `json:"device"` //登录设备信息
+	// 连续登录失败后需要的图片验证码
+	CaptchaID   string `json:"captcha_id"`
+	CaptchaCode string `json:"captcha_code"`
 }
 
 func (r loginReq) Check() error {
@@ -2813,7 +2970,20 @@ type loginUserDetailResp struct {
 	Setting         setting `json:"setting"`
 	RSAPublicKey    string  `json:"rsa_public_key"` // 应用公钥做一些消息验证 base64编码
 	ShortStatus     int     `json:"short_status"`
-	MsgExpireSecond int64   `json:"msg_expire_second"` // 消息过期时长
+	MsgExpireSecond int64   `json:"msg_expire_second"`       // 消息过期时长
+	SessionID       string  `json:"session_id,omitempty"`    // 登录会话ID
+	RefreshToken    string  `json:"refresh_token,omitempty"` // 用于刷新token
+	IMToken         string  `json:"im_token,omitempty"`      // 连接IM使用的token
+	ExpiresIn       int64   `json:"expires_in,omitempty"`    // token有效期（秒）
+}
+
+// 设置登录会话的凭证
+func (l *loginUserDetailResp) withSession(session *sessionToken) *loginUserDetailResp {
+	l.SessionID = session.SessionID
+	l.RefreshToken = session.RefreshToken
+	l.IMToken = session.IMToken
+	l.ExpiresIn = session.ExpiresIn
+	return l
 }
 
 type setting struct {
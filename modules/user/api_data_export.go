@@ -0,0 +1,538 @@
+package user
+
+import (
+	"archive/zip"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/TangSengDaoDao/TangSengDaoDaoServer/modules/file"
+	"github.com/TangSengDaoDao/TangSengDaoDaoServer/pkg/exporttask"
+	"github.com/TangSengDaoDao/TangSengDaoDaoServerLib/common"
+	"github.com/TangSengDaoDao/TangSengDaoDaoServerLib/config"
+	"github.com/TangSengDaoDao/TangSengDaoDaoServerLib/pkg/log"
+	"github.com/TangSengDaoDao/TangSengDaoDaoServerLib/pkg/util"
+	"github.com/TangSengDaoDao/TangSengDaoDaoServerLib/pkg/wkhttp"
+	"go.uber.org/zap"
+)
+
+const (
+	dataExportStatusWait    = exporttask.StatusWait    // 等待导出
+	dataExportStatusRunning = exporttask.StatusRunning // 导出中
+	dataExportStatusDone    = exporttask.StatusDone    // 已完成
+	dataExportStatusFail    = exporttask.StatusFail    // 导出失败
+)
+
+const (
+	dataExportListLimit         = 20               // 导出记录列表的最大数量
+	dataExportScanInterval      = time.Second * 5  // 扫描待导出任务的间隔
+	dataExportScanLimit         = 5                // 每次扫描的最大任务数量
+	dataExportRunningStaleAfter = time.Minute * 10 // 导出中状态超过此时间未更新进度视为中断，重新导出
+)
+
+var dataExportWorkerOnce sync.Once
+
+// DataExportSection 个人数据导出中的一项数据
+// 用户模块不能依赖群组、消息等模块，这些模块的数据通过AddDataExportSection注册
+type DataExportSection struct {
+	Name  string // 归档中的文件名（不含扩展名）
+	Title string // 数据说明
+	// Export 将用户的数据以json格式写入w，返回导出的记录数量
+	Export func(uid string, w io.Writer) (int64, error)
+}
+
+var (
+	dataExportSectionsLock sync.RWMutex
+	dataExportSections     []*DataExportSection
+)
+
+// AddDataExportSection 注册个人数据导出项（同名的导出项会被替换）
+func AddDataExportSection(section *DataExportSection) {
+	dataExportSectionsLock.Lock()
+	defer dataExportSectionsLock.Unlock()
+	for i, s := range dataExportSections {
+		if s.Name == section.Name {
+			dataExportSections[i] = section
+			return
+		}
+	}
+	dataExportSections = append(dataExportSections, section)
+}
+
+func getDataExportSections() []*DataExportSection {
+	dataExportSectionsLock.RLock()
+	defer dataExportSectionsLock.RUnlock()
+	return append([]*DataExportSection(nil), dataExportSections...)
+}
+
+type dataExportResp struct {
+	ExportNo   string `json:"export_no"`
+	Status     int    `json:"status"`   // 状态 0.等待导出 1.导出中 2.已完成 3.导出失败
+	Progress   int    `json:"progress"` // 导出进度 0-100
+	FileSize   int64  `json:"file_size,omitempty"`
+	FailReason string `json:"fail_reason,omitempty"`
+	FinishedAt int64  `json:"finished_at,omitempty"`
+	CreatedAt  string `json:"created_at"`
+}
+
+func newDataExportResp(m *dataExportModel) *dataExportResp {
+	progress := 0
+	if m.Status == dataExportStatusDone {
+		progress = 100
+	} else if m.Total > 0 {
+		progress = m.Processed * 100 / m.Total
+		if progress > 99 {
+			progress = 99
+		}
+	}
+	return &dataExportResp{
+		ExportNo:   m.ExportNo,
+		Status:     m.Status,
+		Progress:   progress,
+		FileSize:   m.FileSize,
+		FailReason: m.FailReason,
+		FinishedAt: m.FinishedAt,
+		CreatedAt:  m.CreatedAt.String(),
+	}
+}
+
+// 申请导出个人数据
+func (u *User) dataExportAdd(c *wkhttp.Context) {
+	loginUID := c.GetLoginUID()
+	unfinished, err := u.dataExporter.dataExportDB.queryUnfinishedCount(loginUID)
+	if err != nil {
+		u.Error("查询导出任务失败！", zap.Error(err))
+		c.ResponseError(errors.New("查询导出任务失败！"))
+		return
+	}
+	if unfinished > 0 {
+		c.ResponseError(errors.New("已有正在进行的导出任务，请等待完成！"))
+		return
+	}
+	model := &dataExportModel{
+		ExportNo: util.GenerUUID(),
+		UID:      loginUID,
+		Status:   dataExportStatusWait,
+	}
+	err = u.dataExporter.dataExportDB.insert(model)
+	if err != nil {
+		u.Error("创建导出任务失败！", zap.Error(err))
+		c.ResponseError(errors.New("创建导出任务失败！"))
+		return
+	}
+	model, err = u.dataExporter.dataExportDB.queryWithExportNo(model.ExportNo)
+	if err != nil {
+		u.Error("查询导出记录失败！", zap.Error(err))
+		c.ResponseError(errors.New("查询导出记录失败！"))
+		return
+	}
+	c.Response(newDataExportResp(model))
+}
+
+// 我的个人数据导出记录
+func (u *User) dataExportList(c *wkhttp.Context) {
+	models, err := u.dataExporter.dataExportDB.queryWithUID(c.GetLoginUID(), dataExportListLimit)
+	if err != nil {
+		u.Error("查询导出记录失败！", zap.Error(err))
+		c.ResponseError(errors.New("查询导出记录失败！"))
+		return
+	}
+	resps := make([]*dataExportResp, 0, len(models))
+	for _, model := range models {
+		resps = append(resps, newDataExportResp(model))
+	}
+	c.Response(resps)
+}
+
+// 个人数据导出详情（进度）
+func (u *User) dataExportGet(c *wkhttp.Context) {
+	model, err := u.dataExporter.dataExportDB.queryWithExportNo(c.Param("export_no"))
+	if err != nil {
+		u.Error("查询导出记录失败！", zap.Error(err))
+		c.ResponseError(errors.New("查询导出记录失败！"))
+		return
+	}
+	if model == nil || model.UID != c.GetLoginUID() {
+		c.ResponseError(errors.New("导出记录不存在！"))
+		return
+	}
+	c.Response(newDataExportResp(model))
+}
+
+// 下载个人数据归档文件
+func (u *User) dataExportFile(c *wkhttp.Context) {
+	model, err := u.dataExporter.dataExportDB.queryWithExportNo(c.Param("export_no"))
+	if err != nil {
+		u.Error("查询导出记录失败！", zap.Error(err))
+		c.ResponseError(errors.New("查询导出记录失败！"))
+		return
+	}
+	if model == nil || model.UID != c.GetLoginUID() {
+		c.ResponseError(errors.New("导出记录不存在！"))
+		return
+	}
+	if model.Status != dataExportStatusDone {
+		c.ResponseError(errors.New("导出尚未完成！"))
+		return
+	}
+	downloadURL, err := u.fileService.DownloadURL(model.FilePath, fmt.Sprintf("%s.zip", model.ExportNo))
+	if err != nil {
+		u.Error("获取下载地址失败！", zap.Error(err))
+		c.ResponseError(errors.New("获取下载地址失败！"))
+		return
+	}
+	c.Redirect(http.StatusFound, downloadURL)
+}
+
+// dataExporter 个人数据导出
+// 归档为zip文件，包含manifest.json（导出项说明）和每个导出项的<name>.json
+type dataExporter struct {
+	ctx *config.Context
+	log.Log
+	dataExportDB *dataExportDB
+	db           *DB
+	friendDB     *friendDB
+	deviceDB     *deviceDB
+	maillistDB   *maillistDB
+	fileService  file.IService
+	worker       *exporttask.Worker
+}
+
+func newDataExporter(ctx *config.Context) *dataExporter {
+	e := &dataExporter{
+		ctx:          ctx,
+		Log:          log.NewTLog("DataExporter"),
+		dataExportDB: newDataExportDB(ctx),
+		db:           NewDB(ctx),
+		friendDB:     newFriendDB(ctx),
+		deviceDB:     newDeviceDB(ctx),
+		maillistDB:   newMaillistDB(ctx),
+		fileService:  file.NewService(ctx),
+	}
+	e.worker = &exporttask.Worker{
+		Log:               e.Log,
+		ScanInterval:      dataExportScanInterval,
+		ScanLimit:         dataExportScanLimit,
+		StaleAfter:        dataExportRunningStaleAfter,
+		QueryStaleRunning: e.dataExportDB.queryStaleRunning,
+		QueryWaiting:      e.dataExportDB.queryWaiting,
+		UpdateStatus:      e.dataExportDB.updateStatus,
+		Run:               e.runWithExportNo,
+	}
+	return e
+}
+
+// 用户模块自己的导出项
+func (e *dataExporter) sections() []*DataExportSection {
+	sections := []*DataExportSection{
+		{Name: "profile", Title: "个人资料", Export: e.exportProfile},
+		{Name: "settings", Title: "会话及用户设置", Export: e.exportSettings},
+		{Name: "friends", Title: "好友", Export: e.exportFriends},
+		{Name: "friend_applies", Title: "好友申请", Export: e.exportFriendApplies},
+		{Name: "devices", Title: "登录设备", Export: e.exportDevices},
+		{Name: "login_logs", Title: "登录日志", Export: e.exportLoginLogs},
+		{Name: "maillist", Title: "上传的通讯录", Export: e.exportMaillist},
+	}
+	return append(sections, getDataExportSections()...)
+}
+
+// 开启后台导出任务（多次调用只会启动一个）
+func (e *dataExporter) start() {
+	dataExportWorkerOnce.Do(e.worker.Start)
+}
+
+func (e *dataExporter) runWithExportNo(exportNo string) {
+	model, err := e.dataExportDB.queryWithExportNo(exportNo)
+	if err != nil {
+		e.Error("查询导出任务失败！", zap.Error(err), zap.String("exportNo", exportNo))
+		return
+	}
+	if model == nil {
+		return
+	}
+	e.run(model)
+}
+
+func (e *dataExporter) run(model *dataExportModel) {
+	stopHeartbeat := e.worker.Heartbeat(model.ExportNo, func() error {
+		return e.dataExportDB.touchRunning(model.ExportNo)
+	})
+	err := e.export(model)
+	stopHeartbeat()
+	if errors.Is(err, exporttask.ErrLost) {
+		e.Warn("导出任务已失效，放弃本次导出结果！", zap.String("exportNo", model.ExportNo))
+		return
+	}
+	if err != nil {
+		e.Error("导出个人数据失败！", zap.Error(err), zap.String("exportNo", model.ExportNo), zap.String("uid", model.UID))
+		rows, err := e.dataExportDB.updateFail(model.ExportNo, "导出失败")
+		if err != nil {
+			e.Error("修改导出任务为失败状态失败！", zap.Error(err))
+			return
+		}
+		if rows > 0 {
+			e.notify(model.UID, "你申请的个人数据导出失败，请稍后重新申请。")
+		}
+		return
+	}
+	e.notify(model.UID, "你申请导出的个人数据已准备好，请在个人数据导出记录中下载。")
+}
+
+// 通过系统账号通知用户导出结果
+func (e *dataExporter) notify(uid string, content string) {
+	err := e.ctx.SendMessage(&config.MsgSendReq{
+		FromUID:     e.ctx.GetConfig().Account.SystemUID,
+		ChannelID:   uid,
+		ChannelType: common.ChannelTypePerson.Uint8(),
+		Payload: []byte(util.ToJson(map[string]interface{}{
+			"content": content,
+			"type":    common.Text,
+		})),
+		Header: config.MsgHeader{
+			RedDot: 1,
+		},
+	})
+	if err != nil {
+		e.Error("发送个人数据导出通知失败！", zap.Error(err), zap.String("uid", uid))
+	}
+}
+
+type dataExportManifestSection struct {
+	Name  string `json:"name"`
+	Title string `json:"title"`
+	File  string `json:"file"`
+	Count int64  `json:"count"`
+}
+
+func (e *dataExporter) export(model *dataExportModel) error {
+	sections := e.sections()
+	if err := e.dataExportDB.updateProgress(model.ExportNo, len(sections), 0); err != nil {
+		return err
+	}
+	zipFile, err := os.CreateTemp("", "user_data_export_*.zip")
+	if err != nil {
+		return err
+	}
+	defer func() {
+		zipFile.Close()
+		os.Remove(zipFile.Name())
+	}()
+
+	zw := zip.NewWriter(zipFile)
+	manifestSections := make([]*dataExportManifestSection, 0, len(sections))
+	for i, section := range sections {
+		fileName := fmt.Sprintf("%s.json", section.Name)
+		w, err := zw.Create(fileName)
+		if err != nil {
+			return err
+		}
+		count, err := section.Export(model.UID, w)
+		if err != nil {
+			return fmt.Errorf("导出%s失败：%w", section.Name, err)
+		}
+		manifestSections = append(manifestSections, &dataExportManifestSection{
+			Name:  section.Name,
+			Title: section.Title,
+			File:  fileName,
+			Count: count,
+		})
+		if err = e.dataExportDB.updateProgress(model.ExportNo, len(sections), i+1); err != nil {
+			return err
+		}
+	}
+	manifestWriter, err := zw.Create("manifest.json")
+	if err != nil {
+		return err
+	}
+	err = json.NewEncoder(manifestWriter).Encode(map[string]interface{}{
+		"export_no":   model.ExportNo,
+		"uid":         model.UID,
+		"exported_at": time.Now().Unix(),
+		"sections":    manifestSections,
+	})
+	if err != nil {
+		return err
+	}
+	if err = zw.Close(); err != nil {
+		return err
+	}
+
+	fileInfo, err := zipFile.Stat()
+	if err != nil {
+		return err
+	}
+	if _, err = zipFile.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	filePath := fmt.Sprintf("%s/user_data_export/%s.zip", file.TypeDownload, model.ExportNo)
+	_, err = e.fileService.UploadFile(filePath, "application/zip", func(w io.Writer) error {
+		_, err := io.Copy(w, zipFile)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	rows, err := e.dataExportDB.updateDone(model.ExportNo, filePath, fileInfo.Size())
+	if err != nil {
+		return err
+	}
+	if rows == 0 {
+		return exporttask.ErrLost
+	}
+	return nil
+}
+
+// 个人资料（不包含密码等凭证信息）
+func (e *dataExporter) exportProfile(uid string, w io.Writer) (int64, error) {
+	m, err := e.db.QueryByUID(uid)
+	if err != nil {
+		return 0, err
+	}
+	if m == nil {
+		return 0, writeDataExportJSON(w, nil)
+	}
+	return 1, writeDataExportJSON(w, map[string]interface{}{
+		"uid":                m.UID,
+		"name":               m.Name,
+		"username":           m.Username,
+		"email":              m.Email,
+		"zone":               m.Zone,
+		"phone":              m.Phone,
+		"sex":                m.Sex,
+		"short_no":           m.ShortNo,
+		"category":           m.Category,
+		"device_lock":        m.DeviceLock,
+		"search_by_phone":    m.SearchByPhone,
+		"search_by_short":    m.SearchByShort,
+		"new_msg_notice":     m.NewMsgNotice,
+		"msg_show_detail":    m.MsgShowDetail,
+		"voice_on":           m.VoiceOn,
+		"shock_on":           m.ShockOn,
+		"offline_protection": m.OfflineProtection,
+		"msg_expire_second":  m.MsgExpireSecond,
+		"web3_public_key":    m.Web3PublicKey,
+		"created_at":         m.CreatedAt.String(),
+	})
+}
+
+func (e *dataExporter) exportSettings(uid string, w io.Writer) (int64, error) {
+	models, err := e.dataExportDB.queryUserSettings(uid)
+	if err != nil {
+		return 0, err
+	}
+	list := make([]map[string]interface{}, 0, len(models))
+	for _, m := range models {
+		list = append(list, map[string]interface{}{
+			"to_uid":        m.ToUID,
+			"mute":          m.Mute,
+			"top":           m.Top,
+			"chat_pwd_on":   m.ChatPwdOn,
+			"screenshot":    m.Screenshot,
+			"revoke_remind": m.RevokeRemind,
+			"blacklist":     m.Blacklist,
+			"receipt":       m.Receipt,
+			"flame":         m.Flame,
+			"flame_second":  m.FlameSecond,
+			"remark":        m.Remark,
+		})
+	}
+	return int64(len(list)), writeDataExportJSON(w, list)
+}
+
+func (e *dataExporter) exportFriends(uid string, w io.Writer) (int64, error) {
+	models, err := e.friendDB.QueryFriends(uid)
+	if err != nil {
+		return 0, err
+	}
+	list := make([]map[string]interface{}, 0, len(models))
+	for _, m := range models {
+		list = append(list, map[string]interface{}{
+			"uid":        m.ToUID,
+			"name":       m.ToName,
+			"remark":     m.Remark,
+			"is_alone":   m.IsAlone,
+			"created_at": m.CreatedAt.String(),
+		})
+	}
+	return int64(len(list)), writeDataExportJSON(w, list)
+}
+
+func (e *dataExporter) exportFriendApplies(uid string, w io.Writer) (int64, error) {
+	models, err := e.dataExportDB.queryFriendApplies(uid)
+	if err != nil {
+		return 0, err
+	}
+	list := make([]map[string]interface{}, 0, len(models))
+	for _, m := range models {
+		// 好友申请记录的uid为接收人，to_uid为申请人
+		list = append(list, map[string]interface{}{
+			"from_uid":   m.ToUID,
+			"to_uid":     m.UID,
+			"remark":     m.Remark,
+			"status":     m.Status,
+			"created_at": m.CreatedAt.String(),
+		})
+	}
+	return int64(len(list)), writeDataExportJSON(w, list)
+}
+
+func (e *dataExporter) exportDevices(uid string, w io.Writer) (int64, error) {
+	models, err := e.deviceDB.queryDeviceWithUID(uid)
+	if err != nil {
+		return 0, err
+	}
+	list := make([]map[string]interface{}, 0, len(models))
+	for _, m := range models {
+		list = append(list, map[string]interface{}{
+			"device_id":    m.DeviceID,
+			"device_name":  m.DeviceName,
+			"device_model": m.DeviceModel,
+			"last_login":   m.LastLogin,
+		})
+	}
+	return int64(len(list)), writeDataExportJSON(w, list)
+}
+
+func (e *dataExporter) exportLoginLogs(uid string, w io.Writer) (int64, error) {
+	models, err := e.dataExportDB.queryLoginLogs(uid)
+	if err != nil {
+		return 0, err
+	}
+	list := make([]map[string]interface{}, 0, len(models))
+	for _, m := range models {
+		list = append(list, map[string]interface{}{
+			"login_ip":   m.LoginIP,
+			"type":       m.Type,
+			"remark":     m.Remark,
+			"created_at": m.CreatedAt.String(),
+		})
+	}
+	return int64(len(list)), writeDataExportJSON(w, list)
+}
+
+func (e *dataExporter) exportMaillist(uid string, w io.Writer) (int64, error) {
+	models, err := e.maillistDB.query(uid)
+	if err != nil {
+		return 0, err
+	}
+	list := make([]map[string]interface{}, 0, len(models))
+	for _, m := range models {
+		list = append(list, map[string]interface{}{
+			"zone":  m.Zone,
+			"phone": m.Phone,
+			"name":  m.Name,
+		})
+	}
+	return int64(len(list)), writeDataExportJSON(w, list)
+}
+
+func writeDataExportJSON(w io.Writer, v interface{}) error {
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(v)
+}
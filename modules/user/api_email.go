@@ -0,0 +1,500 @@
+package user
+
+import (
+	"context"
+	"errors"
+	"net/mail"
+	"strings"
+
+	commonapi "github.com/TangSengDaoDao/TangSengDaoDaoServer/modules/base/common"
+	"github.com/TangSengDaoDao/TangSengDaoDaoServerLib/pkg/util"
+	"github.com/TangSengDaoDao/TangSengDaoDaoServerLib/pkg/wkhttp"
+	"github.com/opentracing/opentracing-go"
+	"go.uber.org/zap"
+)
+
+// 邮箱统一转为小写，避免同一个邮箱因大小写不同注册多个账号
+func normalizeEmail(email string) (string, error) {
+	email = strings.ToLower(strings.TrimSpace(email))
+	if email == "" {
+		return "", errors.New("邮箱不能为空！")
+	}
+	if len(email) > 100 {
+		return "", errors.New("邮箱长度不能超过100位！")
+	}
+	addr, err := mail.ParseAddress(email)
+	if err != nil || addr.Address != email {
+		return "", errors.New("邮箱格式有误！")
+	}
+	return email, nil
+}
+
+// 隐藏邮箱的部分字符 例如 ab******@example.com
+func maskEmail(email string) string {
+	at := strings.LastIndex(email, "@")
+	if at <= 0 {
+		return ""
+	}
+	name := email[:at]
+	if len(name) > 2 {
+		name = name[:2]
+	}
+	return name + "******" + email[at:]
+}
+
+// 发送邮箱验证码
+func (u *User) sendEmailVerifyCode(ctx context.Context, email string, codeType commonapi.CodeType) error {
+	err := u.emailService.SendVerifyCode(ctx, email, codeType)
+	if err != nil {
+		u.Error("发送邮箱验证码失败", zap.Error(err), zap.String("email", email))
+		if errors.Is(err, commonapi.ErrEmailServiceOff) {
+			return err
+		}
+		return errors.New("发送邮箱验证码失败！")
+	}
+	return nil
+}
+
+// 校验邮箱验证码（测试模式下使用配置的固定验证码）
+func (u *User) verifyEmailCode(ctx context.Context, email, code string, codeType commonapi.CodeType) error {
+	if strings.TrimSpace(u.ctx.GetConfig().SMSCode) != "" {
+		if strings.TrimSpace(u.ctx.GetConfig().SMSCode) != code {
+			return errors.New("验证码错误")
+		}
+		return nil
+	}
+	return u.emailService.Verify(ctx, email, code, codeType)
+}
+
+// 邮箱是否已被其他账号使用（excludeUID为空时不排除任何账号）
+func (u *User) emailIsUsed(email string, excludeUID string) (bool, error) {
+	userInfo, err := u.db.QueryByEmail(email)
+	if err != nil {
+		return false, err
+	}
+	if userInfo != nil && userInfo.UID != excludeUID {
+		return true, nil
+	}
+	// 邮箱注册的账号以邮箱作为用户名
+	userInfo, err = u.db.QueryByUsername(email)
+	if err != nil {
+		return false, err
+	}
+	return userInfo != nil && userInfo.UID != excludeUID, nil
+}
+
+// 获取邮箱注册验证码
+func (u *User) sendEmailRegisterCode(c *wkhttp.Context) {
+	if u.ctx.GetConfig().Register.Off {
+		c.ResponseError(errors.New("注册通道暂不开放，请长按标题使用官网上演示账号登录"))
+		return
+	}
+	var req emailCodeReq
+	if err := c.BindJSON(&req); err != nil {
+		c.ResponseError(errors.New("请求数据格式有误！"))
+		return
+	}
+	email, err := normalizeEmail(req.Email)
+	if err != nil {
+		c.ResponseError(err)
+		return
+	}
+
+	span := u.ctx.Tracer().StartSpan(
+		"user.sendEmailRegisterCode",
+		opentracing.ChildOf(c.GetSpanContext()),
+	)
+	defer span.Finish()
+	spanCtx := u.ctx.Tracer().ContextWithSpan(context.Background(), span)
+
+	exist, err := u.emailIsUsed(email, "")
+	if err != nil {
+		u.Error("查询用户信息失败！", zap.Error(err))
+		c.ResponseError(errors.New("查询用户信息失败！"))
+		return
+	}
+	if exist {
+		c.Response(map[string]interface{}{
+			"exist": 1,
+		})
+		return
+	}
+	err = u.sendEmailVerifyCode(spanCtx, email, commonapi.CodeTypeRegister)
+	if err != nil {
+		c.ResponseError(err)
+		return
+	}
+	c.Response(map[string]interface{}{
+		"exist": 0,
+	})
+}
+
+// 邮箱注册
+func (u *User) emailRegister(c *wkhttp.Context) {
+	var req emailRegisterReq
+	if err := c.BindJSON(&req); err != nil {
+		c.ResponseError(errors.New("请求数据格式有误！"))
+		return
+	}
+	if err := req.check(); err != nil {
+		c.ResponseError(err)
+		return
+	}
+	email, err := normalizeEmail(req.Email)
+	if err != nil {
+		c.ResponseError(err)
+		return
+	}
+	if u.ctx.GetConfig().Register.Off {
+		c.ResponseError(errors.New("注册通道暂不开放，请长按标题使用官网上演示账号登录"))
+		return
+	}
+	invite, err := u.checkRegisterInvite(req.InviteCode)
+	if err != nil {
+		c.ResponseError(err)
+		return
+	}
+	registerSpan := u.ctx.Tracer().StartSpan(
+		"user.emailRegister",
+		opentracing.ChildOf(c.GetSpanContext()),
+	)
+	defer registerSpan.Finish()
+	registerSpanCtx := u.ctx.Tracer().ContextWithSpan(context.Background(), registerSpan)
+	registerSpan.SetTag("email", email)
+
+	exist, err := u.emailIsUsed(email, "")
+	if err != nil {
+		u.Error("查询用户信息失败！", zap.Error(err), zap.String("email", email))
+		c.ResponseError(err)
+		return
+	}
+	if exist {
+		c.ResponseError(errors.New("该邮箱已被注册"))
+		return
+	}
+	err = u.verifyEmailCode(registerSpanCtx, email, req.Code, commonapi.CodeTypeRegister)
+	if err != nil {
+		c.ResponseError(err)
+		return
+	}
+	var model = &createUserModel{
+		UID:      util.GenerUUID(),
+		Sex:      1,
+		Name:     req.Name,
+		Username: email,
+		Email:    email,
+		Password: req.Password,
+		Flag:     int(req.Flag),
+		Device:   req.Device,
+	}
+	u.createUser(registerSpanCtx, model, c, invite)
+}
+
+// 获取邮箱找回密码验证码
+func (u *User) sendEmailForgetPwdCode(c *wkhttp.Context) {
+	var req emailCodeReq
+	if err := c.BindJSON(&req); err != nil {
+		c.ResponseError(errors.New("请求数据格式有误！"))
+		return
+	}
+	email, err := normalizeEmail(req.Email)
+	if err != nil {
+		c.ResponseError(err)
+		return
+	}
+
+	span := u.ctx.Tracer().StartSpan(
+		"user.sendEmailForgetPwdCode",
+		opentracing.ChildOf(c.GetSpanContext()),
+	)
+	defer span.Finish()
+	spanCtx := u.ctx.Tracer().ContextWithSpan(context.Background(), span)
+
+	userInfo, err := u.db.QueryByEmail(email)
+	if err != nil {
+		u.Error("查询用户信息失败！", zap.Error(err))
+		c.ResponseError(errors.New("查询用户信息失败！"))
+		return
+	}
+	if userInfo == nil || userInfo.IsDestroy == 1 {
+		c.ResponseError(errors.New("该邮箱未注册"))
+		return
+	}
+	err = u.sendEmailVerifyCode(spanCtx, email, commonapi.CodeTypeForgetLoginPWD)
+	if err != nil {
+		c.ResponseError(err)
+		return
+	}
+	c.ResponseOK()
+}
+
+// 通过邮箱重置登录密码
+func (u *User) emailPwdForget(c *wkhttp.Context) {
+	var req emailResetPwdReq
+	if err := c.BindJSON(&req); err != nil {
+		c.ResponseError(errors.New("请求数据格式有误！"))
+		return
+	}
+	email, err := normalizeEmail(req.Email)
+	if err != nil {
+		c.ResponseError(err)
+		return
+	}
+	if strings.TrimSpace(req.Code) == "" {
+		c.ResponseError(errors.New("验证码不能为空！"))
+		return
+	}
+	if strings.TrimSpace(req.Pwd) == "" {
+		c.ResponseError(errors.New("密码不能为空！"))
+		return
+	}
+	userInfo, err := u.db.QueryByEmail(email)
+	if err != nil {
+		u.Error("查询用户信息错误", zap.Error(err))
+		c.ResponseError(errors.New("查询用户信息错误"))
+		return
+	}
+	if userInfo == nil || userInfo.IsDestroy == 1 {
+		c.ResponseError(errors.New("该账号不存在"))
+		return
+	}
+	err = u.verifyEmailCode(context.Background(), email, req.Code, commonapi.CodeTypeForgetLoginPWD)
+	if err != nil {
+		c.ResponseError(err)
+		return
+	}
+	err = updatePassword(u.db, userInfo.UID, req.Pwd)
+	if err != nil {
+		u.Error("修改登录密码错误", zap.Error(err))
+		c.ResponseError(errors.New("修改登录密码错误"))
+		return
+	}
+	c.ResponseOK()
+}
+
+// 发送登录设备验证的邮箱验证码
+func (u *User) sendLoginCheckEmailCode(c *wkhttp.Context) {
+	var req struct {
+		UID string `json:"uid"`
+	}
+	if err := c.BindJSON(&req); err != nil {
+		c.ResponseError(errors.New("数据格式有误！"))
+		return
+	}
+	if req.UID == "" {
+		c.ResponseError(errors.New("uid不能为空！"))
+		return
+	}
+
+	span := u.ctx.Tracer().StartSpan(
+		"user.sendLoginCheckEmailCode",
+		opentracing.ChildOf(c.GetSpanContext()),
+	)
+	defer span.Finish()
+	spanCtx := u.ctx.Tracer().ContextWithSpan(context.Background(), span)
+
+	userInfo, err := u.db.QueryByUID(req.UID)
+	if err != nil {
+		u.Error("查询用户信息失败！", zap.Error(err))
+		c.ResponseError(errors.New("查询用户信息失败！"))
+		return
+	}
+	if userInfo == nil {
+		c.ResponseError(errors.New("该用户不存在"))
+		return
+	}
+	if userInfo.EmailVerified != 1 || userInfo.Email == "" {
+		c.ResponseError(errors.New("该用户未绑定邮箱"))
+		return
+	}
+	err = u.sendEmailVerifyCode(spanCtx, userInfo.Email, commonapi.CodeTypeCheckMobile)
+	if err != nil {
+		c.ResponseError(err)
+		return
+	}
+	c.ResponseOK()
+}
+
+// 登录验证设备邮箱
+func (u *User) loginCheckEmail(c *wkhttp.Context) {
+	var req struct {
+		UID  string `json:"uid"`
+		Code string `json:"code"`
+	}
+	if err := c.BindJSON(&req); err != nil {
+		c.ResponseError(errors.New("数据格式有误！"))
+		return
+	}
+	if req.UID == "" {
+		c.ResponseError(errors.New("uid不能为空！"))
+		return
+	}
+	if req.Code == "" {
+		c.ResponseError(errors.New("验证码不能为空！"))
+		return
+	}
+	span := u.ctx.Tracer().StartSpan(
+		"user.loginCheckEmail",
+		opentracing.ChildOf(c.GetSpanContext()),
+	)
+	defer span.Finish()
+	spanCtx := u.ctx.Tracer().ContextWithSpan(context.Background(), span)
+
+	userInfo, err := u.db.QueryByUID(req.UID)
+	if err != nil {
+		u.Error("查询用户信息失败！", zap.Error(err))
+		c.ResponseError(errors.New("查询用户信息失败！"))
+		return
+	}
+	if userInfo == nil {
+		c.ResponseError(errors.New("该用户不存在"))
+		return
+	}
+	if userInfo.EmailVerified != 1 || userInfo.Email == "" {
+		c.ResponseError(errors.New("该用户未绑定邮箱"))
+		return
+	}
+	err = u.emailService.Verify(spanCtx, userInfo.Email, req.Code, commonapi.CodeTypeCheckMobile)
+	if err != nil {
+		u.Error("验证邮箱验证码失败", zap.Error(err))
+		c.ResponseError(err)
+		return
+	}
+	u.loginCheckDeviceSuccess(userInfo, spanCtx, c)
+}
+
+// 获取绑定邮箱验证码
+func (u *User) sendBindEmailCode(c *wkhttp.Context) {
+	var req emailCodeReq
+	if err := c.BindJSON(&req); err != nil {
+		c.ResponseError(errors.New("请求数据格式有误！"))
+		return
+	}
+	email, err := normalizeEmail(req.Email)
+	if err != nil {
+		c.ResponseError(err)
+		return
+	}
+	exist, err := u.emailIsUsed(email, c.GetLoginUID())
+	if err != nil {
+		u.Error("查询用户信息失败！", zap.Error(err))
+		c.ResponseError(errors.New("查询用户信息失败！"))
+		return
+	}
+	if exist {
+		c.ResponseError(errors.New("该邮箱已被其他账号使用"))
+		return
+	}
+	err = u.sendEmailVerifyCode(c.Context, email, commonapi.CodeTypeBindEmail)
+	if err != nil {
+		c.ResponseError(err)
+		return
+	}
+	c.ResponseOK()
+}
+
+// 绑定邮箱
+func (u *User) bindEmail(c *wkhttp.Context) {
+	var req emailBindReq
+	if err := c.BindJSON(&req); err != nil {
+		c.ResponseError(errors.New("请求数据格式有误！"))
+		return
+	}
+	email, err := normalizeEmail(req.Email)
+	if err != nil {
+		c.ResponseError(err)
+		return
+	}
+	if strings.TrimSpace(req.Code) == "" {
+		c.ResponseError(errors.New("验证码不能为空！"))
+		return
+	}
+	exist, err := u.emailIsUsed(email, c.GetLoginUID())
+	if err != nil {
+		u.Error("查询用户信息失败！", zap.Error(err))
+		c.ResponseError(errors.New("查询用户信息失败！"))
+		return
+	}
+	if exist {
+		c.ResponseError(errors.New("该邮箱已被其他账号使用"))
+		return
+	}
+	err = u.verifyEmailCode(c.Context, email, req.Code, commonapi.CodeTypeBindEmail)
+	if err != nil {
+		c.ResponseError(err)
+		return
+	}
+	err = u.db.updateVerifiedEmail(c.GetLoginUID(), email)
+	if err != nil {
+		u.Error("绑定邮箱失败", zap.Error(err))
+		c.ResponseError(errors.New("绑定邮箱失败"))
+		return
+	}
+	c.ResponseOK()
+}
+
+// 发送注销账号邮箱验证码
+func (u *User) sendDestroyEmailCode(c *wkhttp.Context) {
+	userInfo, err := u.db.QueryByUID(c.GetLoginUID())
+	if err != nil {
+		u.Error("查询登录用户信息错误", zap.Error(err))
+		c.ResponseError(errors.New("查询登录用户信息错误"))
+		return
+	}
+	if userInfo == nil || userInfo.IsDestroy == 1 {
+		c.ResponseError(errors.New("登录用户不存在"))
+		return
+	}
+	if userInfo.EmailVerified != 1 || userInfo.Email == "" {
+		c.ResponseError(errors.New("未绑定邮箱"))
+		return
+	}
+	err = u.sendEmailVerifyCode(c.Context, userInfo.Email, commonapi.CodeTypeDestroyAccount)
+	if err != nil {
+		c.ResponseError(err)
+		return
+	}
+	c.ResponseOK()
+}
+
+// 邮箱验证码请求
+type emailCodeReq struct {
+	Email string `json:"email"`
+}
+
+// 绑定邮箱请求
+type emailBindReq struct {
+	Email string `json:"email"`
+	Code  string `json:"code"`
+}
+
+// 邮箱重置登录密码
+type emailResetPwdReq struct {
+	Email string `json:"email"` // 邮箱
+	Code  string `json:"code"`  // 验证码
+	Pwd   string `json:"pwd"`   // 密码
+}
+
+// 邮箱注册请求
+type emailRegisterReq struct {
+	Name       string     `json:"name"`
+	Email      string     `json:"email"`
+	Code       string     `json:"code"`
+	Password   string     `json:"password"`
+	Flag       uint8      `json:"flag"`        // 注册设备的标记 0.APP 1.PC
+	Device     *deviceReq `json:"device"`      //注册用户设备信息
+	InviteCode string     `json:"invite_code"` // 邀请码
+}
+
+func (r emailRegisterReq) check() error {
+	if strings.TrimSpace(r.Code) == "" {
+		return errors.New("验证码不能为空！")
+	}
+	if strings.TrimSpace(r.Password) == "" {
+		return errors.New("密码不能为空！")
+	}
+	if len(r.Password) < 6 {
+		return errors.New("密码长度必须大于6位！")
+	}
+	return nil
+}
@@ -22,6 +22,8 @@ import (
 
 const (
 	ThirdAuthcodePrefix = "thirdlogin:authcode:"
+	// 第三方登录需要两步验证或设备验证时，授权码缓存的验证信息前缀
+	thirdAuthVerifyPrefix = "verify:"
 )
 
 func (u *User) thirdAuthcode(c *wkhttp.Context) {
@@ -77,6 +79,17 @@ func (u *User) thirdAuthStatus(c *wkhttp.Context) {
 		u.Error("redis del error", zap.Error(err))
 	}
 
+	if strings.HasPrefix(result, thirdAuthVerifyPrefix) { // 需要验证，返回与账号登录一致的验证信息
+		var verifyResp map[string]interface{}
+		err = util.ReadJsonByByte([]byte(strings.TrimPrefix(result, thirdAuthVerifyPrefix)), &verifyResp)
+		if err != nil {
+			c.ResponseError(err)
+			return
+		}
+		c.ResponseWithStatus(http.StatusBadRequest, verifyResp)
+		return
+	}
+
 	var loginResp *loginUserDetailResp
 	err = util.ReadJsonByByte([]byte(result), &loginResp)
 	if err != nil {
@@ -89,6 +102,23 @@ func (u *User) thirdAuthStatus(c *wkhttp.Context) {
 	})
 }
 
+// 第三方登录的用户需要两步验证或验证登录设备时，返回缓存到授权码的验证信息（其他错误原样返回）
+func (u *User) thirdLoginVerifyResult(userInfo *Model, flag config.DeviceFlag, loginErr error) (string, error) {
+	var verifyResp map[string]interface{}
+	if errors.Is(loginErr, ErrUserNeedTOTP) {
+		resp, err := u.needTOTPResp(userInfo, flag, nil)
+		if err != nil {
+			return "", err
+		}
+		verifyResp = resp
+	} else if errors.Is(loginErr, ErrUserNeedVerification) {
+		verifyResp = needVerificationResp(userInfo)
+	} else {
+		return "", loginErr
+	}
+	return thirdAuthVerifyPrefix + util.ToJson(verifyResp), nil
+}
+
 // 获取gitee授权地址
 func (u *User) gitee(c *wkhttp.Context) {
 	cfg := u.ctx.GetConfig()
@@ -137,19 +167,24 @@ func (u *User) giteeOAuth(c *wkhttp.Context) {
 	defer loginSpan.Finish()
 
 	var loginResp *loginUserDetailResp
+	var loginRespStr string
 	if userInfoM != nil { // 存在就登录
 		if userInfoM.IsDestroy == 1 {
 			c.ResponseError(errors.New("用户不存在"))
 			return
 		}
-		loginResp, err = u.execLogin(userInfoM, deviceFlag, nil, loginSpanCtx)
+		loginResp, err = u.execLogin(userInfoM, deviceFlag, nil, newLoginClient(c), loginSpanCtx)
 		if err != nil {
-			c.ResponseError(err)
-			return
+			loginRespStr, err = u.thirdLoginVerifyResult(userInfoM, deviceFlag, err)
+			if err != nil {
+				c.ResponseError(err)
+				return
+			}
+		} else {
+			// 发送登录消息
+			publicIP := util.GetClientPublicIP(c.Request)
+			go u.sentWelcomeMsg(publicIP, userInfoM.UID)
 		}
-		// 发送登录消息
-		publicIP := util.GetClientPublicIP(c.Request)
-		go u.sentWelcomeMsg(publicIP, userInfoM.UID)
 	} else {
 		// 创建用户
 		uid := util.GenerUUID()
@@ -220,10 +255,9 @@ func (u *User) giteeOAuth(c *wkhttp.Context) {
 			return
 		}
 	}
-	var loginRespStr string
 	if loginResp != nil {
 		loginRespStr = util.ToJson(loginResp)
-	} else {
+	} else if loginRespStr == "" {
 		loginRespStr = "0"
 	}
 	err = u.ctx.GetRedisConn().SetAndExpire(fmt.Sprintf("%s%s", ThirdAuthcodePrefix, authcode), loginRespStr, time.Minute*1)
@@ -66,19 +66,24 @@ func (u *User) githubOAuth(c *wkhttp.Context) {
 	defer loginSpan.Finish()
 
 	var loginResp *loginUserDetailResp
+	var loginRespStr string
 	if userInfoM != nil { // 存在就登录
 		if userInfoM.IsDestroy == 1 {
 			c.ResponseError(errors.New("用户不存在"))
 			return
 		}
-		loginResp, err = u.execLogin(userInfoM, deviceFlag, nil, loginSpanCtx)
+		loginResp, err = u.execLogin(userInfoM, deviceFlag, nil, newLoginClient(c), loginSpanCtx)
 		if err != nil {
-			c.ResponseError(err)
-			return
+			loginRespStr, err = u.thirdLoginVerifyResult(userInfoM, deviceFlag, err)
+			if err != nil {
+				c.ResponseError(err)
+				return
+			}
+		} else {
+			// 发送登录消息
+			publicIP := util.GetClientPublicIP(c.Request)
+			go u.sentWelcomeMsg(publicIP, userInfoM.UID)
 		}
-		// 发送登录消息
-		publicIP := util.GetClientPublicIP(c.Request)
-		go u.sentWelcomeMsg(publicIP, userInfoM.UID)
 	} else {
 		// 创建用户
 		uid := util.GenerUUID()
@@ -149,10 +154,9 @@ func (u *User) githubOAuth(c *wkhttp.Context) {
 			return
 		}
 	}
-	var loginRespStr string
 	if loginResp != nil {
 		loginRespStr = util.ToJson(loginResp)
-	} else {
+	} else if loginRespStr == "" {
 		loginRespStr = "0"
 	}
 	err = u.ctx.GetRedisConn().SetAndExpire(fmt.Sprintf("%s%s", ThirdAuthcodePrefix, authcode), loginRespStr, time.Minute*1)
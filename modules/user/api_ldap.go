@@ -0,0 +1,520 @@
+package user
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/TangSengDaoDao/TangSengDaoDaoServer/modules/base/event"
+	"github.com/TangSengDaoDao/TangSengDaoDaoServer/pkg/ldap"
+	"github.com/TangSengDaoDao/TangSengDaoDaoServerLib/common"
+	"github.com/TangSengDaoDao/TangSengDaoDaoServerLib/config"
+	"github.com/TangSengDaoDao/TangSengDaoDaoServerLib/pkg/log"
+	"github.com/TangSengDaoDao/TangSengDaoDaoServerLib/pkg/util"
+	"github.com/TangSengDaoDao/TangSengDaoDaoServerLib/pkg/wkevent"
+	"github.com/TangSengDaoDao/TangSengDaoDaoServerLib/pkg/wkhttp"
+	"go.uber.org/zap"
+)
+
+const (
+	ldapProvider       = "ldap"     // 目录用户在user_identity中的provider
+	ldapSyncKey        = "ldapSync" // 定时同步的间隔控制（多实例时只同步一次）
+	ldapSearchPageSize = 500        // 同步时分页查询的大小
+	ldapTimeout        = time.Second * 10
+)
+
+var (
+	errLDAPInvalidCredentials = errors.New("ldap: invalid credentials")
+	ldapSyncLock              sync.Mutex
+)
+
+// ldapDirectory 目录认证和同步（User和Manager共用）
+type ldapDirectory struct {
+	ctx *config.Context
+	log.Log
+	db        *ldapDB
+	oidcDB    *oidcDB
+	userDB    *DB
+	sessionDB *sessionDB
+}
+
+func newLDAPDirectory(ctx *config.Context) *ldapDirectory {
+	return &ldapDirectory{
+		ctx:       ctx,
+		Log:       log.NewTLog("ldap"),
+		db:        newLDAPDB(ctx),
+		oidcDB:    newOIDCDB(ctx),
+		userDB:    NewDB(ctx),
+		sessionDB: newSessionDB(ctx),
+	}
+}
+
+// 已开启的目录配置，未开启返回nil
+func (d *ldapDirectory) enabledConfig() (*ldapConfigModel, error) {
+	cfg, err := d.db.queryConfig()
+	if err != nil {
+		return nil, err
+	}
+	if cfg == nil || cfg.Status != 1 || cfg.URL == "" {
+		return nil, nil
+	}
+	return cfg, nil
+}
+
+// 连接目录并使用服务账号绑定
+func (d *ldapDirectory) dial(cfg *ldapConfigModel) (*ldap.Conn, error) {
+	tlsConfig := &tls.Config{InsecureSkipVerify: cfg.InsecureSkipVerify == 1}
+	conn, err := ldap.Dial(cfg.URL, tlsConfig, ldapTimeout)
+	if err != nil {
+		return nil, err
+	}
+	if cfg.StartTLS == 1 && strings.HasPrefix(cfg.URL, "ldap://") {
+		if err = conn.StartTLS(tlsConfig); err != nil {
+			conn.Close()
+			return nil, err
+		}
+	}
+	if cfg.BindDN != "" {
+		if err = conn.Bind(cfg.BindDN, cfg.BindPassword); err != nil {
+			conn.Close()
+			return nil, err
+		}
+	}
+	return conn, nil
+}
+
+// 在目录中查找用户并校验密码，目录中不存在该用户时返回nil
+func (d *ldapDirectory) authenticate(cfg *ldapConfigModel, username string, password string) (*ldap.Entry, error) {
+	conn, err := d.dial(cfg)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	entries, err := conn.Search(&ldap.SearchRequest{
+		BaseDN:     cfg.BaseDN,
+		Scope:      ldap.ScopeWholeSubtree,
+		Filter:     strings.ReplaceAll(cfg.UserFilter, "{username}", ldap.EscapeFilter(username)),
+		Attributes: []string{cfg.UsernameAttr, cfg.NameAttr, cfg.EmailAttr},
+		SizeLimit:  2,
+	})
+	if err != nil && !ldap.IsErrorWithCode(err, ldap.ResultSizeLimitExceeded) {
+		return nil, err
+	}
+	if len(entries) == 0 {
+		return nil, nil
+	}
+	if len(entries) > 1 {
+		return nil, fmt.Errorf("ldap: multiple entries match username %q", username)
+	}
+	err = conn.Bind(entries[0].DN, password)
+	if err != nil {
+		if errors.Is(err, ldap.ErrEmptyPassword) || ldap.IsErrorWithCode(err, ldap.ResultInvalidCredentials) {
+			return nil, errLDAPInvalidCredentials
+		}
+		return nil, err
+	}
+	return entries[0], nil
+}
+
+// 目录用户的唯一标识（登录名属性，不区分大小写）
+func ldapSubject(cfg *ldapConfigModel, entry *ldap.Entry) string {
+	return strings.ToLower(entry.Get(cfg.UsernameAttr))
+}
+
+// 目录认证登录，返回的用户为nil表示未开启目录认证或目录中不存在该用户（继续使用本地密码登录）
+// 首次登录时创建本地用户并直接返回登录结果
+func (u *User) ldapLogin(username string, password string, flag config.DeviceFlag, device *deviceReq, loginSpanCtx context.Context, c *wkhttp.Context) (*Model, *loginUserDetailResp, error) {
+	cfg, err := u.ldap.enabledConfig()
+	if err != nil {
+		u.Error("查询目录配置失败！", zap.Error(err))
+		return nil, nil, errors.New("查询目录配置失败！")
+	}
+	if cfg == nil {
+		return nil, nil, nil
+	}
+	entry, err := u.ldap.authenticate(cfg, username, password)
+	if err != nil {
+		if errors.Is(err, errLDAPInvalidCredentials) {
+			return nil, nil, ErrPasswordIncorrect
+		}
+		u.Error("目录认证失败！", zap.Error(err), zap.String("username", username))
+		return nil, nil, errors.New("目录服务不可用，请稍后再试！")
+	}
+	if entry == nil {
+		return nil, nil, nil
+	}
+	subject := ldapSubject(cfg, entry)
+	if subject == "" {
+		u.Error("目录用户缺少登录名属性！", zap.String("dn", entry.DN), zap.String("attr", cfg.UsernameAttr))
+		return nil, nil, errors.New("目录用户信息不完整！")
+	}
+	identity, err := u.oidcDB.queryIdentity(ldapProvider, subject)
+	if err != nil {
+		u.Error("查询身份绑定失败！", zap.Error(err))
+		return nil, nil, errors.New("查询身份绑定失败！")
+	}
+	if identity != nil {
+		userInfo, err := u.db.QueryByUID(identity.UID)
+		if err != nil {
+			u.Error("查询用户信息失败！", zap.Error(err))
+			return nil, nil, errors.New("查询用户信息失败！")
+		}
+		if userInfo == nil || userInfo.IsDestroy == 1 {
+			return nil, nil, errors.New("用户不存在")
+		}
+		return userInfo, nil, nil
+	}
+
+	// 首次登录，创建本地用户
+	uid := util.GenerUUID()
+	name := strings.TrimSpace(entry.Get(cfg.NameAttr))
+	if name == "" {
+		name = username
+	}
+	tx, err := u.ctx.DB().Begin()
+	if err != nil {
+		u.Error("开启事务失败！", zap.Error(err))
+		return nil, nil, errors.New("开启事务失败！")
+	}
+	defer func() {
+		if err := recover(); err != nil {
+			tx.Rollback()
+			panic(err)
+		}
+	}()
+	err = u.oidcDB.insertIdentityTx(&userIdentityModel{
+		Provider: ldapProvider,
+		Subject:  subject,
+		UID:      uid,
+		Email:    entry.Get(cfg.EmailAttr),
+	}, tx)
+	if err != nil {
+		tx.Rollback()
+		u.Error("添加身份绑定失败！", zap.Error(err))
+		return nil, nil, errors.New("添加身份绑定失败！")
+	}
+	var commitErr error
+	result, err := u.createUserWithRespAndTx(loginSpanCtx, &createUserModel{
+		UID:    uid,
+		Name:   name,
+		Flag:   int(flag),
+		Device: device,
+	}, util.GetClientPublicIP(c.Request), nil, tx, func() error {
+		commitErr = tx.Commit()
+		if commitErr != nil {
+			tx.Rollback()
+			u.Error("数据库事物提交失败", zap.Error(commitErr))
+		}
+		return commitErr
+	})
+	if err != nil {
+		tx.Rollback()
+		return nil, nil, err
+	}
+	if commitErr != nil {
+		return nil, nil, errors.New("数据库事物提交失败")
+	}
+	userInfo, err := u.db.QueryByUID(uid)
+	if err != nil {
+		u.Error("查询用户信息失败！", zap.Error(err))
+		return nil, nil, errors.New("查询用户信息失败！")
+	}
+	return userInfo, result, nil
+}
+
+// 定时同步目录（间隔由配置决定）
+func (u *User) ldapSyncCheck() {
+	cfg, err := u.ldap.enabledConfig()
+	if err != nil {
+		u.Error("查询目录配置失败！", zap.Error(err))
+		return
+	}
+	if cfg == nil || cfg.SyncOn != 1 {
+		return
+	}
+	synced, err := u.ctx.GetRedisConn().GetString(ldapSyncKey)
+	if err != nil {
+		u.Error("获取目录同步状态失败！", zap.Error(err))
+		return
+	}
+	if synced != "" {
+		return
+	}
+	interval := cfg.SyncInterval
+	if interval <= 0 {
+		interval = 60
+	}
+	err = u.ctx.GetRedisConn().SetAndExpire(ldapSyncKey, "1", time.Duration(interval)*time.Minute)
+	if err != nil {
+		u.Error("设置目录同步状态失败！", zap.Error(err))
+		return
+	}
+	result, err := u.ldap.sync()
+	if err != nil {
+		u.Warn("目录同步失败！", zap.Error(err))
+		return
+	}
+	u.Info("目录同步完成", zap.Any("result", result))
+}
+
+type ldapSyncResult struct {
+	Users        int `json:"users"`         // 目录中的有效用户数
+	Disabled     int `json:"disabled"`      // 本次禁用的用户数
+	Enabled      int `json:"enabled"`       // 本次恢复的用户数
+	GroupAdded   int `json:"group_added"`   // 加入群的成员数
+	GroupRemoved int `json:"group_removed"` // 移出群的成员数
+}
+
+// 同步目录：禁用目录中已删除的用户，恢复重新加入的用户，按映射同步群成员
+func (d *ldapDirectory) sync() (*ldapSyncResult, error) {
+	if !ldapSyncLock.TryLock() {
+		return nil, errors.New("目录同步正在进行中！")
+	}
+	defer ldapSyncLock.Unlock()
+
+	cfg, err := d.enabledConfig()
+	if err != nil {
+		return nil, err
+	}
+	if cfg == nil {
+		return nil, errors.New("未开启目录认证！")
+	}
+	conn, err := d.dial(cfg)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	entries, err := conn.Search(&ldap.SearchRequest{
+		BaseDN:     cfg.BaseDN,
+		Scope:      ldap.ScopeWholeSubtree,
+		Filter:     cfg.SyncFilter,
+		Attributes: []string{cfg.UsernameAttr},
+		PageSize:   ldapSearchPageSize,
+	})
+	if err != nil {
+		return nil, err
+	}
+	// 目录配置错误时可能查不到任何用户，为避免误禁用全部用户直接跳过
+	if len(entries) == 0 {
+		return nil, errors.New("目录中没有查询到用户，已跳过同步！")
+	}
+	result := &ldapSyncResult{Users: len(entries)}
+	subjects := make(map[string]bool, len(entries))
+	dnSubjects := make(map[string]string, len(entries))
+	for _, entry := range entries {
+		subject := ldapSubject(cfg, entry)
+		if subject == "" {
+			continue
+		}
+		subjects[subject] = true
+		dnSubjects[strings.ToLower(entry.DN)] = subject
+	}
+
+	identities, err := d.db.queryIdentities()
+	if err != nil {
+		return nil, err
+	}
+	subjectUIDs := make(map[string]string, len(identities))
+	ldapUIDs := make(map[string]bool, len(identities))
+	for _, identity := range identities {
+		subjectUIDs[identity.Subject] = identity.UID
+		ldapUIDs[identity.UID] = true
+		exist := subjects[identity.Subject]
+		if !exist && identity.SyncDisabled == 0 {
+			if err = d.setUserDisabled(identity.UID, true); err != nil {
+				d.Error("禁用目录用户失败！", zap.Error(err), zap.String("uid", identity.UID))
+				continue
+			}
+			if err = d.db.updateIdentitySyncDisabled(identity.Id, 1); err != nil {
+				return nil, err
+			}
+			result.Disabled++
+		} else if exist && identity.SyncDisabled == 1 {
+			if err = d.setUserDisabled(identity.UID, false); err != nil {
+				d.Error("恢复目录用户失败！", zap.Error(err), zap.String("uid", identity.UID))
+				continue
+			}
+			if err = d.db.updateIdentitySyncDisabled(identity.Id, 0); err != nil {
+				return nil, err
+			}
+			result.Enabled++
+		}
+	}
+
+	added, removed, err := d.syncGroups(cfg, conn, dnSubjects, subjects, subjectUIDs, ldapUIDs)
+	if err != nil {
+		return nil, err
+	}
+	result.GroupAdded = added
+	result.GroupRemoved = removed
+	return result, nil
+}
+
+// 按映射同步群成员，只移出通过目录登录过的普通成员
+func (d *ldapDirectory) syncGroups(cfg *ldapConfigModel, conn *ldap.Conn, dnSubjects map[string]string, subjects map[string]bool, subjectUIDs map[string]string, ldapUIDs map[string]bool) (int, int, error) {
+	mappings, err := d.db.queryGroups()
+	if err != nil {
+		return 0, 0, err
+	}
+	if len(mappings) == 0 {
+		return 0, 0, nil
+	}
+	// 同一个群可能映射多个目录组，取并集
+	wants := map[string]map[string]bool{}
+	failed := map[string]bool{}
+	for _, mapping := range mappings {
+		if wants[mapping.GroupNo] == nil {
+			wants[mapping.GroupNo] = map[string]bool{}
+		}
+		entries, err := conn.Search(&ldap.SearchRequest{
+			BaseDN:     mapping.GroupDN,
+			Scope:      ldap.ScopeBaseObject,
+			Filter:     "(objectClass=*)",
+			Attributes: []string{cfg.GroupMemberAttr},
+		})
+		if err != nil || len(entries) == 0 {
+			d.Warn("查询目录组失败！", zap.Error(err), zap.String("groupDN", mapping.GroupDN))
+			failed[mapping.GroupNo] = true // 查询失败的群不做移除，避免误删
+			continue
+		}
+		for _, member := range entries[0].GetAll(cfg.GroupMemberAttr) {
+			subject := dnSubjects[strings.ToLower(member)]
+			if subject == "" { // posixGroup的memberUid是登录名
+				subject = strings.ToLower(member)
+			}
+			if uid := subjectUIDs[subject]; uid != "" && subjects[subject] {
+				wants[mapping.GroupNo][uid] = true
+			}
+		}
+	}
+
+	members := make([]*config.OrgOrDeptEmployeeVO, 0)
+	added, removed := 0, 0
+	for groupNo, want := range wants {
+		exist, err := d.db.existGroupNo(groupNo)
+		if err != nil {
+			return 0, 0, err
+		}
+		if !exist {
+			continue
+		}
+		currentMembers, err := d.db.queryGroupMembers(groupNo)
+		if err != nil {
+			return 0, 0, err
+		}
+		current := make(map[string]bool, len(currentMembers))
+		for _, member := range currentMembers {
+			current[member.UID] = true
+			if failed[groupNo] || want[member.UID] || !ldapUIDs[member.UID] || member.Role != 0 {
+				continue
+			}
+			members = append(members, &config.OrgOrDeptEmployeeVO{
+				Operator:    d.ctx.GetConfig().Account.SystemUID,
+				EmployeeUid: member.UID,
+				GroupNo:     groupNo,
+				Action:      "delete",
+			})
+			removed++
+		}
+		for uid := range want {
+			if current[uid] {
+				continue
+			}
+			members = append(members, &config.OrgOrDeptEmployeeVO{
+				Operator:    d.ctx.GetConfig().Account.SystemUID,
+				EmployeeUid: uid,
+				GroupNo:     groupNo,
+				Action:      "add",
+			})
+			added++
+		}
+	}
+	if len(members) == 0 {
+		return 0, 0, nil
+	}
+	uids := make([]string, 0, len(members))
+	for _, member := range members {
+		uids = append(uids, member.EmployeeUid)
+	}
+	users, err := d.userDB.QueryByUIDs(uids)
+	if err != nil {
+		return 0, 0, err
+	}
+	for _, member := range members {
+		for _, user := range users {
+			if user.UID == member.EmployeeUid {
+				member.EmployeeName = user.Name
+				break
+			}
+		}
+	}
+
+	// 群成员由群模块通过事件修改
+	tx, err := d.ctx.DB().Begin()
+	if err != nil {
+		return 0, 0, err
+	}
+	defer func() {
+		if err := recover(); err != nil {
+			tx.Rollback()
+			panic(err)
+		}
+	}()
+	eventID, err := d.ctx.EventBegin(&wkevent.Data{
+		Event: event.OrgOrDeptEmployeeUpdate,
+		Type:  wkevent.Message,
+		Data: &config.MsgOrgOrDeptEmployeeUpdateReq{
+			Members: members,
+		},
+	}, tx)
+	if err != nil {
+		tx.Rollback()
+		return 0, 0, err
+	}
+	if err = tx.Commit(); err != nil {
+		tx.Rollback()
+		return 0, 0, err
+	}
+	d.ctx.EventCommit(eventID)
+	return added, removed, nil
+}
+
+// 禁用或恢复用户（禁用时同时撤销全部登录会话）
+func (d *ldapDirectory) setUserDisabled(uid string, disabled bool) error {
+	status := common.UserAvailable
+	ban := 0
+	if disabled {
+		status = common.UserDisable
+		ban = 1
+	}
+	err := d.userDB.UpdateUsersWithField("status", fmt.Sprintf("%d", status), uid)
+	if err != nil {
+		return err
+	}
+	err = d.ctx.IMCreateOrUpdateChannelInfo(&config.ChannelInfoCreateReq{
+		ChannelID:   uid,
+		ChannelType: common.ChannelTypePerson.Uint8(),
+		Ban:         ban,
+	})
+	if err != nil {
+		return err
+	}
+	if disabled {
+		_, err = revokeUserSessions(d.ctx, d.sessionDB, uid)
+	}
+	return err
+}
+
+// 校验目录地址
+func checkLDAPURL(rawURL string) error {
+	u, err := url.Parse(rawURL)
+	if err != nil || (u.Scheme != "ldap" && u.Scheme != "ldaps") || u.Host == "" {
+		return errors.New("目录地址格式有误，例如 ldap://host:389 或 ldaps://host:636")
+	}
+	return nil
+}
@@ -0,0 +1,287 @@
+package user
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/TangSengDaoDao/TangSengDaoDaoServer/pkg/captcha"
+	"github.com/TangSengDaoDao/TangSengDaoDaoServerLib/config"
+	"github.com/TangSengDaoDao/TangSengDaoDaoServerLib/pkg/log"
+	"github.com/TangSengDaoDao/TangSengDaoDaoServerLib/pkg/util"
+	"github.com/TangSengDaoDao/TangSengDaoDaoServerLib/pkg/wkhttp"
+	"go.uber.org/zap"
+)
+
+const (
+	loginFailPrefix    = "loginFail:"    // 登录失败次数
+	loginDelayPrefix   = "loginDelay:"   // 登录失败后需要等待到的时间
+	loginLockPrefix    = "loginLock:"    // 登录锁定到的时间
+	loginCaptchaPrefix = "loginCaptcha:" // 登录验证码
+
+	loginFailWindow             = time.Hour        // 失败次数统计窗口（最后一次失败后开始计算）
+	loginAccountCaptchaLimit    = 3                // 账号失败多少次后需要验证码
+	loginIPCaptchaLimit         = 10               // IP失败多少次后需要验证码（同一出口IP可能有很多用户）
+	loginDelayLimit             = 5                // 账号失败多少次后开始递增等待时间
+	loginMaxDelay               = time.Minute      // 最长等待时间
+	loginAccountLockLimit       = 10               // 账号失败多少次后锁定
+	loginIPLockLimit            = 50               // IP失败多少次后锁定
+	loginLockDuration           = time.Minute * 15 // 锁定时长
+	loginCaptchaExpire          = time.Minute * 5  // 验证码有效期
+	loginCaptchaLength          = 4                // 验证码位数
+	loginGuardStatusNeedCaptcha = 113              // 需要验证码时返回的状态
+)
+
+var (
+	// ErrLoginNeedCaptcha 登录失败次数过多，需要验证码
+	ErrLoginNeedCaptcha = errors.New("需要输入验证码！")
+	// ErrLoginCaptchaIncorrect 验证码错误或已过期
+	ErrLoginCaptchaIncorrect = errors.New("验证码不正确或已过期！")
+	// ErrPasswordIncorrect 密码不正确
+	ErrPasswordIncorrect = errors.New("密码不正确！")
+)
+
+// loginGuard 登录防暴力破解（按账号和IP统计失败次数，递增等待、要求验证码和临时锁定）
+type loginGuard struct {
+	ctx *config.Context
+	log.Log
+	loginLog *LoginLog
+}
+
+func newLoginGuard(ctx *config.Context) *loginGuard {
+	return &loginGuard{
+		ctx:      ctx,
+		Log:      log.NewTLog("loginGuard"),
+		loginLog: NewLoginLog(ctx),
+	}
+}
+
+func loginAccountKey(account string) string {
+	return fmt.Sprintf("account:%s", strings.ToLower(strings.TrimSpace(account)))
+}
+
+func loginIPKey(ip string) string {
+	return fmt.Sprintf("ip:%s", ip)
+}
+
+// check 校验密码前调用，被锁定、需要等待或需要验证码时返回错误
+func (g *loginGuard) check(account string, ip string, captchaID string, captchaCode string) error {
+	accountKey, ipKey := loginAccountKey(account), loginIPKey(ip)
+	for _, key := range []string{accountKey, ipKey} {
+		remain, err := g.remain(loginLockPrefix + key)
+		if err != nil {
+			return err
+		}
+		if remain > 0 {
+			return fmt.Errorf("登录失败次数过多，请%d分钟后再试！", int((remain+time.Minute-1)/time.Minute))
+		}
+	}
+	remain, err := g.remain(loginDelayPrefix + accountKey)
+	if err != nil {
+		return err
+	}
+	if remain > 0 {
+		return fmt.Errorf("登录失败次数过多，请%d秒后再试！", int((remain+time.Second-1)/time.Second))
+	}
+	accountFails, err := g.failures(accountKey)
+	if err != nil {
+		return err
+	}
+	ipFails, err := g.failures(ipKey)
+	if err != nil {
+		return err
+	}
+	if accountFails < loginAccountCaptchaLimit && ipFails < loginIPCaptchaLimit {
+		return nil
+	}
+	if captchaID == "" || captchaCode == "" {
+		return ErrLoginNeedCaptcha
+	}
+	return g.verifyCaptcha(captchaID, captchaCode)
+}
+
+// fail 登录失败（账号不存在或密码错误）后调用
+func (g *loginGuard) fail(account string, uid string, ip string) {
+	accountKey, ipKey := loginAccountKey(account), loginIPKey(ip)
+	accountFails, err := g.incrFailures(accountKey)
+	if err != nil {
+		g.Error("增加登录失败次数失败！", zap.Error(err))
+		return
+	}
+	if accountFails >= loginAccountLockLimit {
+		g.lock(accountKey, accountFails)
+		g.loginLog.addEvent(loginLogTypeLock, uid, account, ip, fmt.Sprintf("账号连续登录失败%d次，锁定%d分钟", accountFails, int(loginLockDuration/time.Minute)))
+	} else if accountFails >= loginDelayLimit {
+		delay := time.Second << uint(accountFails-loginDelayLimit)
+		if delay > loginMaxDelay {
+			delay = loginMaxDelay
+		}
+		err = g.ctx.GetRedisConn().SetAndExpire(loginDelayPrefix+accountKey, strconv.FormatInt(time.Now().Add(delay).UnixMilli(), 10), delay)
+		if err != nil {
+			g.Error("设置登录等待时间失败！", zap.Error(err))
+		}
+	}
+
+	ipFails, err := g.incrFailures(ipKey)
+	if err != nil {
+		g.Error("增加登录失败次数失败！", zap.Error(err))
+		return
+	}
+	if ipFails >= loginIPLockLimit {
+		g.lock(ipKey, ipFails)
+		g.loginLog.addEvent(loginLogTypeLock, "", "", ip, fmt.Sprintf("IP连续登录失败%d次，锁定%d分钟", ipFails, int(loginLockDuration/time.Minute)))
+	}
+}
+
+// success 登录成功后清除账号的失败次数（IP的失败次数不清除，避免用一个正确账号重置计数）
+func (g *loginGuard) success(account string) {
+	accountKey := loginAccountKey(account)
+	if err := g.ctx.GetRedisConn().Del(loginFailPrefix + accountKey); err != nil {
+		g.Warn("清除登录失败次数失败！", zap.Error(err))
+	}
+	if err := g.ctx.GetRedisConn().Del(loginDelayPrefix + accountKey); err != nil {
+		g.Warn("清除登录等待时间失败！", zap.Error(err))
+	}
+}
+
+// unlock 解除账号或IP的锁定，返回是否处于锁定状态
+func (g *loginGuard) unlock(account string, uid string, ip string, operator string) (bool, error) {
+	keys := make([]string, 0, 2)
+	if account != "" {
+		keys = append(keys, loginAccountKey(account))
+	}
+	if ip != "" {
+		keys = append(keys, loginIPKey(ip))
+	}
+	locked := false
+	for _, key := range keys {
+		remain, err := g.remain(loginLockPrefix + key)
+		if err != nil {
+			return false, err
+		}
+		if remain > 0 {
+			locked = true
+		}
+		for _, prefix := range []string{loginLockPrefix, loginFailPrefix, loginDelayPrefix} {
+			if err = g.ctx.GetRedisConn().Del(prefix + key); err != nil {
+				return false, err
+			}
+		}
+	}
+	if locked {
+		g.loginLog.addEvent(loginLogTypeUnlock, uid, account, ip, fmt.Sprintf("管理员[%s]解除锁定", operator))
+	}
+	return locked, nil
+}
+
+// 锁定并保留需要验证码的失败次数（解锁后再次失败仍需要验证码）
+func (g *loginGuard) lock(key string, fails int) {
+	err := g.ctx.GetRedisConn().SetAndExpire(loginLockPrefix+key, strconv.FormatInt(time.Now().Add(loginLockDuration).UnixMilli(), 10), loginLockDuration)
+	if err != nil {
+		g.Error("设置登录锁定失败！", zap.Error(err), zap.String("key", key))
+		return
+	}
+	if err = g.ctx.GetRedisConn().Hset(loginFailPrefix+key, "count", strconv.Itoa(loginAccountCaptchaLimit)); err != nil {
+		g.Warn("重置登录失败次数失败！", zap.Error(err))
+	}
+	g.Warn("连续登录失败，已锁定", zap.String("key", key), zap.Int("fails", fails))
+}
+
+func (g *loginGuard) failures(key string) (int, error) {
+	count, err := g.ctx.GetRedisConn().Hget(loginFailPrefix+key, "count")
+	if err != nil {
+		return 0, err
+	}
+	if count == "" {
+		return 0, nil
+	}
+	return strconv.Atoi(count)
+}
+
+func (g *loginGuard) incrFailures(key string) (int, error) {
+	count, err := g.ctx.GetRedisConn().Hincrby(loginFailPrefix+key, "count", 1)
+	if err != nil {
+		return 0, err
+	}
+	if err = g.ctx.GetRedisConn().Expire(loginFailPrefix+key, loginFailWindow); err != nil {
+		g.Warn("设置登录失败次数过期时间失败！", zap.Error(err))
+	}
+	return int(count), nil
+}
+
+// 剩余时间（值为截止时间的毫秒时间戳）
+func (g *loginGuard) remain(key string) (time.Duration, error) {
+	value, err := g.ctx.GetRedisConn().GetString(key)
+	if err != nil {
+		return 0, err
+	}
+	if value == "" {
+		return 0, nil
+	}
+	until, _ := strconv.ParseInt(value, 10, 64)
+	return time.Until(time.UnixMilli(until)), nil
+}
+
+// 校验验证码（只能使用一次）
+func (g *loginGuard) verifyCaptcha(captchaID string, captchaCode string) error {
+	key := loginCaptchaPrefix + captchaID
+	code, err := g.ctx.GetRedisConn().GetString(key)
+	if err != nil {
+		return err
+	}
+	if code == "" {
+		return ErrLoginCaptchaIncorrect
+	}
+	if err = g.ctx.GetRedisConn().Del(key); err != nil {
+		return err
+	}
+	if code != strings.TrimSpace(captchaCode) {
+		return ErrLoginCaptchaIncorrect
+	}
+	return nil
+}
+
+// 生成登录验证码
+func (g *loginGuard) newCaptcha() (string, []byte, error) {
+	code, img, err := captcha.Generate(loginCaptchaLength)
+	if err != nil {
+		return "", nil, err
+	}
+	captchaID := util.GenerUUID()
+	err = g.ctx.GetRedisConn().SetAndExpire(loginCaptchaPrefix+captchaID, code, loginCaptchaExpire)
+	if err != nil {
+		return "", nil, err
+	}
+	return captchaID, img, nil
+}
+
+// 返回防暴力破解的错误，需要验证码时返回113状态
+func responseLoginGuardError(err error, c *wkhttp.Context) {
+	if errors.Is(err, ErrLoginNeedCaptcha) || errors.Is(err, ErrLoginCaptchaIncorrect) {
+		c.ResponseWithStatus(http.StatusBadRequest, map[string]interface{}{
+			"status": loginGuardStatusNeedCaptcha,
+			"msg":    err.Error(),
+		})
+		return
+	}
+	c.ResponseError(err)
+}
+
+// 获取登录验证码
+func (u *User) loginCaptcha(c *wkhttp.Context) {
+	captchaID, img, err := u.loginGuard.newCaptcha()
+	if err != nil {
+		u.Error("生成验证码失败！", zap.Error(err))
+		c.ResponseError(errors.New("生成验证码失败！"))
+		return
+	}
+	c.Response(map[string]interface{}{
+		"captcha_id": captchaID,
+		"image":      "data:image/png;base64," + base64.StdEncoding.EncodeToString(img),
+		"expire":     int(loginCaptchaExpire / time.Second),
+	})
+}
@@ -6,6 +6,12 @@ import (
 	"go.uber.org/zap"
 )
 
+const (
+	loginLogTypeLogin  = 0 // 登录
+	loginLogTypeLock   = 1 // 连续登录失败被锁定
+	loginLogTypeUnlock = 2 // 解锁
+)
+
 // LoginLog 用户设置
 type LoginLog struct {
 	ctx *config.Context
@@ -29,6 +35,20 @@ func (l *LoginLog) add(uid string, publicIP string) {
 	}
 }
 
+// addEvent 添加锁定或解锁记录
+func (l *LoginLog) addEvent(typ int, uid string, account string, ip string, remark string) {
+	err := l.loginLogDB.insert(&LoginLogModel{
+		UID:     uid,
+		LoginIP: ip,
+		Type:    typ,
+		Account: account,
+		Remark:  remark,
+	})
+	if err != nil {
+		l.Error("添加登录锁定日志错误", zap.Error(err))
+	}
+}
+
 // getLastLoginIp 获取最后一次登录ip
 func (l *LoginLog) getLastLoginIP(uid string) *loginLogResp {
 	model, err := l.loginLogDB.queryLastLoginIP(uid)
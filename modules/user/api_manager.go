@@ -1,7 +1,9 @@
 package user
 
 import (
+	"context"
 	"fmt"
+	"net/http"
 	"sort"
 	"strconv"
 	"strings"
@@ -31,8 +33,17 @@ type Manager struct {
 	friendDB      *friendDB
 	onlineService IOnlineService
 	commonService common2.IService
+	totpDB        *totpDB
+	sessionDB     *sessionDB
+	oidcDB        *oidcDB
+	ldap          *ldapDirectory
+	loginGuard    *loginGuard
+	loginLogDB    *LoginLogDB
 }
 
+// 返回给后台的密钥脱敏后的值，修改时原样提交表示不修改
+const maskedSecret = "******"
+
 // NewManager NewManager
 func NewManager(ctx *config.Context) *Manager {
 	m := &Manager{
@@ -45,6 +56,12 @@ func NewManager(ctx *config.Context) *Manager {
 		userSettingDB: NewSettingDB(ctx.DB()),
 		onlineService: NewOnlineService(ctx),
 		commonService: common2.NewService(ctx),
+		totpDB:        newTOTPDB(ctx),
+		sessionDB:     newSessionDB(ctx),
+		oidcDB:        newOIDCDB(ctx),
+		ldap:          newLDAPDirectory(ctx),
+		loginGuard:    newLoginGuard(ctx),
+		loginLogDB:    NewLoginLogDB(ctx.DB()),
 	}
 	m.createManagerAccount()
 	return m
@@ -54,7 +71,10 @@ func NewManager(ctx *config.Context) *Manager {
 func (m *Manager) Route(r *wkhttp.WKHttp) {
 	user := r.Group("/v1/manager")
 	{
-		user.POST("/login", m.login) // 账号登录
+		user.POST("/login", m.login)                                    // 账号登录
+		user.POST("/login/totp", m.loginTOTP)                           // 登录两步验证
+		user.POST("/login/totp/enroll", m.loginTOTPEnroll)              // 登录时绑定两步验证
+		user.POST("/login/totp/enroll/verify", m.loginTOTPEnrollVerify) // 登录时验证并开启两步验证
 	}
 	auth := r.Group("/v1/manager", m.ctx.AuthMiddleware(r))
 	{
@@ -71,7 +91,506 @@ func (m *Manager) Route(r *wkhttp.WKHttp) {
 		auth.PUT("/user/liftban/:uid/:status", m.liftBanUser) // 解禁或封禁用户
 		auth.POST("/user/updatepassword", m.updatePwd)        // 修改用户密码
 		auth.GET("/user/devices", m.devices)                  // 查看某用户设备列表
+		auth.GET("/user/legacypwd", m.legacyPwdUsers)         // 使用旧密码哈希的用户
+		auth.POST("/user/legacypwd/reset", m.resetLegacyPwd)  // 强制旧密码用户重置密码
+		auth.POST("/user/totp/reset", m.resetUserTOTP)        // 重置用户两步验证
+		auth.GET("/user/sessions", m.sessions)                // 查看某用户登录会话
+		auth.POST("/user/sessions/revoke", m.sessionsRevoke)  // 撤销某用户全部登录会话
+		auth.GET("/user/loginlocks", m.loginLocks)            // 登录锁定和解锁记录
+		auth.POST("/user/login/unlock", m.loginUnlock)        // 解除登录锁定
+		// #################### 身份提供方 ####################
+		auth.GET("/oidc/providers", m.oidcProviderList)                // 身份提供方列表
+		auth.POST("/oidc/providers", m.oidcProviderAdd)                // 添加身份提供方
+		auth.PUT("/oidc/providers/:provider", m.oidcProviderUpdate)    // 修改身份提供方
+		auth.DELETE("/oidc/providers/:provider", m.oidcProviderDelete) // 删除身份提供方
+		// #################### 目录认证 ####################
+		auth.GET("/ldap/config", m.ldapConfig)             // 目录认证配置
+		auth.PUT("/ldap/config", m.ldapConfigUpdate)       // 修改目录认证配置
+		auth.POST("/ldap/sync", m.ldapSync)                // 立即同步目录
+		auth.GET("/ldap/groups", m.ldapGroups)             // 目录组与群的映射
+		auth.POST("/ldap/groups", m.ldapGroupAdd)          // 添加目录组映射
+		auth.DELETE("/ldap/groups/:id", m.ldapGroupDelete) // 删除目录组映射
+	}
+}
+
+// 查看某用户的登录会话
+func (m *Manager) sessions(c *wkhttp.Context) {
+	err := c.CheckLoginRole()
+	if err != nil {
+		c.ResponseError(err)
+		return
+	}
+	uid := c.Query("uid")
+	if uid == "" {
+		c.ResponseError(errors.New("请求用户uid不能为空"))
+		return
+	}
+	sessions, err := m.sessionDB.queryActiveWithUID(uid)
+	if err != nil {
+		m.Error("查询用户登录会话错误", zap.Error(err))
+		c.ResponseError(errors.New("查询用户登录会话错误"))
+		return
+	}
+	list := make([]*sessionResp, 0, len(sessions))
+	for _, session := range sessions {
+		list = append(list, newSessionResp(session, ""))
+	}
+	c.Response(list)
+}
+
+// 撤销某用户的全部登录会话
+func (m *Manager) sessionsRevoke(c *wkhttp.Context) {
+	err := c.CheckLoginRoleIsSuperAdmin()
+	if err != nil {
+		c.ResponseError(err)
+		return
+	}
+	var req struct {
+		UID string `json:"uid"`
+	}
+	if err := c.BindJSON(&req); err != nil {
+		c.ResponseError(errors.New("请求数据格式有误！"))
+		return
+	}
+	if req.UID == "" {
+		c.ResponseError(errors.New("用户uid不能为空"))
+		return
+	}
+	count, err := revokeUserSessions(m.ctx, m.sessionDB, req.UID)
+	if err != nil {
+		c.ResponseError(err)
+		return
+	}
+	c.Response(map[string]interface{}{
+		"count": count,
+	})
+}
+
+// 登录锁定和解锁记录
+func (m *Manager) loginLocks(c *wkhttp.Context) {
+	err := c.CheckLoginRole()
+	if err != nil {
+		c.ResponseError(err)
+		return
+	}
+	pageIndex, pageSize := c.GetPage()
+	models, err := m.loginLogDB.queryEventsWithPage(uint64(pageSize), uint64(pageIndex))
+	if err != nil {
+		m.Error("查询登录锁定记录错误", zap.Error(err))
+		c.ResponseError(errors.New("查询登录锁定记录错误"))
+		return
+	}
+	count, err := m.loginLogDB.queryEventCount()
+	if err != nil {
+		m.Error("查询登录锁定记录数量错误", zap.Error(err))
+		c.ResponseError(errors.New("查询登录锁定记录数量错误"))
+		return
+	}
+	list := make([]*loginLockResp, 0, len(models))
+	for _, model := range models {
+		list = append(list, &loginLockResp{
+			Type:      model.Type,
+			UID:       model.UID,
+			Account:   model.Account,
+			IP:        model.LoginIP,
+			Remark:    model.Remark,
+			CreatedAt: model.CreatedAt.String(),
+		})
+	}
+	c.Response(map[string]interface{}{
+		"list":  list,
+		"count": count,
+	})
+}
+
+// 解除用户或IP的登录锁定
+func (m *Manager) loginUnlock(c *wkhttp.Context) {
+	err := c.CheckLoginRoleIsSuperAdmin()
+	if err != nil {
+		c.ResponseError(err)
+		return
+	}
+	var req struct {
+		UID string `json:"uid"`
+		IP  string `json:"ip"`
+	}
+	if err := c.BindJSON(&req); err != nil {
+		c.ResponseError(errors.New("请求数据格式有误！"))
+		return
+	}
+	req.IP = strings.TrimSpace(req.IP)
+	if req.UID == "" && req.IP == "" {
+		c.ResponseError(errors.New("用户uid和IP不能都为空"))
+		return
+	}
+	accounts := make([]string, 0, 2)
+	if req.UID != "" {
+		userInfo, err := m.userDB.QueryByUID(req.UID)
+		if err != nil {
+			m.Error("查询用户信息错误", zap.Error(err))
+			c.ResponseError(errors.New("查询用户信息错误"))
+			return
+		}
+		if userInfo == nil {
+			c.ResponseError(errors.New("用户不存在"))
+			return
+		}
+		if userInfo.Username != "" {
+			accounts = append(accounts, userInfo.Username)
+		}
+		// 目录用户使用目录登录名登录
+		identity, err := m.oidcDB.queryIdentityWithUID(ldapProvider, req.UID)
+		if err != nil {
+			m.Error("查询身份绑定错误", zap.Error(err))
+			c.ResponseError(errors.New("查询身份绑定错误"))
+			return
+		}
+		if identity != nil && !strings.EqualFold(identity.Subject, userInfo.Username) {
+			accounts = append(accounts, identity.Subject)
+		}
+		if len(accounts) == 0 {
+			c.ResponseError(errors.New("该用户没有登录账号"))
+			return
+		}
+	}
+	operator := c.GetLoginName()
+	locked := false
+	if len(accounts) == 0 {
+		accounts = append(accounts, "")
+	}
+	for i, account := range accounts {
+		ip := ""
+		if i == 0 {
+			ip = req.IP
+		}
+		ok, err := m.loginGuard.unlock(account, req.UID, ip, operator)
+		if err != nil {
+			m.Error("解除登录锁定错误", zap.Error(err))
+			c.ResponseError(errors.New("解除登录锁定错误"))
+			return
+		}
+		locked = locked || ok
+	}
+	c.Response(map[string]interface{}{
+		"locked": locked,
+	})
+}
+
+// 身份提供方列表
+func (m *Manager) oidcProviderList(c *wkhttp.Context) {
+	err := c.CheckLoginRole()
+	if err != nil {
+		c.ResponseError(err)
+		return
+	}
+	models, err := m.oidcDB.queryProviders()
+	if err != nil {
+		m.Error("查询身份提供方失败！", zap.Error(err))
+		c.ResponseError(errors.New("查询身份提供方失败！"))
+		return
+	}
+	list := make([]*oidcProviderResp, 0, len(models))
+	for _, model := range models {
+		list = append(list, newOIDCProviderResp(model))
+	}
+	c.Response(list)
+}
+
+// 添加身份提供方
+func (m *Manager) oidcProviderAdd(c *wkhttp.Context) {
+	err := c.CheckLoginRoleIsSuperAdmin()
+	if err != nil {
+		c.ResponseError(err)
+		return
+	}
+	var req oidcProviderReq
+	if err := c.BindJSON(&req); err != nil {
+		c.ResponseError(errors.New("请求数据格式有误！"))
+		return
+	}
+	if err := req.check(); err != nil {
+		c.ResponseError(err)
+		return
+	}
+	exist, err := m.oidcDB.queryProvider(req.Provider)
+	if err != nil {
+		m.Error("查询身份提供方失败！", zap.Error(err))
+		c.ResponseError(errors.New("查询身份提供方失败！"))
+		return
+	}
+	if exist != nil {
+		c.ResponseError(errors.New("身份提供方标识已存在！"))
+		return
+	}
+	model := req.toModel()
+	if err := m.checkOIDCProvider(model); err != nil {
+		c.ResponseError(err)
+		return
+	}
+	err = m.oidcDB.insertProvider(model)
+	if err != nil {
+		m.Error("添加身份提供方失败！", zap.Error(err))
+		c.ResponseError(errors.New("添加身份提供方失败！"))
+		return
+	}
+	c.ResponseOK()
+}
+
+// 修改身份提供方（client_secret为空时保留原密钥）
+func (m *Manager) oidcProviderUpdate(c *wkhttp.Context) {
+	err := c.CheckLoginRoleIsSuperAdmin()
+	if err != nil {
+		c.ResponseError(err)
+		return
+	}
+	var req oidcProviderReq
+	if err := c.BindJSON(&req); err != nil {
+		c.ResponseError(errors.New("请求数据格式有误！"))
+		return
+	}
+	req.Provider = c.Param("provider")
+	if err := req.check(); err != nil {
+		c.ResponseError(err)
+		return
+	}
+	exist, err := m.oidcDB.queryProvider(req.Provider)
+	if err != nil {
+		m.Error("查询身份提供方失败！", zap.Error(err))
+		c.ResponseError(errors.New("查询身份提供方失败！"))
+		return
+	}
+	if exist == nil {
+		c.ResponseError(errors.New("身份提供方不存在！"))
+		return
+	}
+	model := req.toModel()
+	if model.ClientSecret == "" || model.ClientSecret == maskedSecret {
+		model.ClientSecret = exist.ClientSecret
+	}
+	if model.Status == 1 {
+		if err := m.checkOIDCProvider(model); err != nil {
+			c.ResponseError(err)
+			return
+		}
+	}
+	err = m.oidcDB.updateProvider(model)
+	if err != nil {
+		m.Error("修改身份提供方失败！", zap.Error(err))
+		c.ResponseError(errors.New("修改身份提供方失败！"))
+		return
+	}
+	removeOIDCProviderCache(model.Provider)
+	c.ResponseOK()
+}
+
+// 删除身份提供方（已绑定的用户无法再通过该提供方登录）
+func (m *Manager) oidcProviderDelete(c *wkhttp.Context) {
+	err := c.CheckLoginRoleIsSuperAdmin()
+	if err != nil {
+		c.ResponseError(err)
+		return
+	}
+	provider := c.Param("provider")
+	err = m.oidcDB.deleteProvider(provider)
+	if err != nil {
+		m.Error("删除身份提供方失败！", zap.Error(err))
+		c.ResponseError(errors.New("删除身份提供方失败！"))
+		return
+	}
+	removeOIDCProviderCache(provider)
+	c.ResponseOK()
+}
+
+// 通过发现文档校验issuer配置是否正确
+func (m *Manager) checkOIDCProvider(model *oidcProviderModel) error {
+	timeoutCtx, cancel := context.WithTimeout(context.Background(), oidcRequestTimeout)
+	defer cancel()
+	_, err := newOIDCProvider(timeoutCtx, m.ctx, model)
+	if err != nil {
+		m.Warn("获取身份提供方配置失败！", zap.Error(err), zap.String("issuer", model.Issuer))
+		return errors.New("获取身份提供方配置失败，请检查issuer！")
+	}
+	return nil
+}
+
+// 目录认证配置
+func (m *Manager) ldapConfig(c *wkhttp.Context) {
+	err := c.CheckLoginRole()
+	if err != nil {
+		c.ResponseError(err)
+		return
+	}
+	model, err := m.ldap.db.queryConfig()
+	if err != nil {
+		m.Error("查询目录配置失败！", zap.Error(err))
+		c.ResponseError(errors.New("查询目录配置失败！"))
+		return
+	}
+	if model == nil {
+		model = defaultLDAPConfig()
+	}
+	c.Response(newLDAPConfigResp(model))
+}
+
+// 修改目录认证配置（bind_password为空时保留原密码），开启时会先测试连接
+func (m *Manager) ldapConfigUpdate(c *wkhttp.Context) {
+	err := c.CheckLoginRoleIsSuperAdmin()
+	if err != nil {
+		c.ResponseError(err)
+		return
+	}
+	var req ldapConfigReq
+	if err := c.BindJSON(&req); err != nil {
+		c.ResponseError(errors.New("请求数据格式有误！"))
+		return
+	}
+	if err := req.check(); err != nil {
+		c.ResponseError(err)
+		return
+	}
+	exist, err := m.ldap.db.queryConfig()
+	if err != nil {
+		m.Error("查询目录配置失败！", zap.Error(err))
+		c.ResponseError(errors.New("查询目录配置失败！"))
+		return
+	}
+	model := req.toModel()
+	if exist != nil {
+		model.Id = exist.Id
+		if model.BindPassword == "" || model.BindPassword == maskedSecret {
+			model.BindPassword = exist.BindPassword
+		}
+	}
+	if model.Status == 1 {
+		conn, err := m.ldap.dial(model)
+		if err != nil {
+			m.Warn("连接目录失败！", zap.Error(err), zap.String("url", model.URL))
+			c.ResponseError(fmt.Errorf("连接目录失败：%s", err.Error()))
+			return
+		}
+		conn.Close()
+	}
+	if exist == nil {
+		err = m.ldap.db.insertConfig(model)
+	} else {
+		err = m.ldap.db.updateConfig(model)
+	}
+	if err != nil {
+		m.Error("保存目录配置失败！", zap.Error(err))
+		c.ResponseError(errors.New("保存目录配置失败！"))
+		return
+	}
+	c.ResponseOK()
+}
+
+// 立即同步目录
+func (m *Manager) ldapSync(c *wkhttp.Context) {
+	err := c.CheckLoginRoleIsSuperAdmin()
+	if err != nil {
+		c.ResponseError(err)
+		return
+	}
+	result, err := m.ldap.sync()
+	if err != nil {
+		m.Warn("目录同步失败！", zap.Error(err))
+		c.ResponseError(fmt.Errorf("目录同步失败：%s", err.Error()))
+		return
+	}
+	c.Response(result)
+}
+
+// 目录组与群的映射列表
+func (m *Manager) ldapGroups(c *wkhttp.Context) {
+	err := c.CheckLoginRole()
+	if err != nil {
+		c.ResponseError(err)
+		return
+	}
+	models, err := m.ldap.db.queryGroups()
+	if err != nil {
+		m.Error("查询目录组映射失败！", zap.Error(err))
+		c.ResponseError(errors.New("查询目录组映射失败！"))
+		return
+	}
+	list := make([]map[string]interface{}, 0, len(models))
+	for _, model := range models {
+		list = append(list, map[string]interface{}{
+			"id":         model.Id,
+			"group_dn":   model.GroupDN,
+			"group_no":   model.GroupNo,
+			"created_at": model.CreatedAt.String(),
+		})
+	}
+	c.Response(list)
+}
+
+// 添加目录组与群的映射
+func (m *Manager) ldapGroupAdd(c *wkhttp.Context) {
+	err := c.CheckLoginRoleIsSuperAdmin()
+	if err != nil {
+		c.ResponseError(err)
+		return
+	}
+	var req struct {
+		GroupDN string `json:"group_dn"`
+		GroupNo string `json:"group_no"`
+	}
+	if err := c.BindJSON(&req); err != nil {
+		c.ResponseError(errors.New("请求数据格式有误！"))
+		return
+	}
+	req.GroupDN = strings.TrimSpace(req.GroupDN)
+	if req.GroupDN == "" || req.GroupNo == "" {
+		c.ResponseError(errors.New("目录组DN和群编号不能为空！"))
+		return
+	}
+	exist, err := m.ldap.db.existGroupNo(req.GroupNo)
+	if err != nil {
+		m.Error("查询群信息失败！", zap.Error(err))
+		c.ResponseError(errors.New("查询群信息失败！"))
+		return
+	}
+	if !exist {
+		c.ResponseError(errors.New("群不存在！"))
+		return
+	}
+	exist, err = m.ldap.db.existGroup(req.GroupDN, req.GroupNo)
+	if err != nil {
+		m.Error("查询目录组映射失败！", zap.Error(err))
+		c.ResponseError(errors.New("查询目录组映射失败！"))
+		return
+	}
+	if exist {
+		c.ResponseError(errors.New("映射已存在！"))
+		return
+	}
+	err = m.ldap.db.insertGroup(&ldapGroupModel{
+		GroupDN: req.GroupDN,
+		GroupNo: req.GroupNo,
+	})
+	if err != nil {
+		m.Error("添加目录组映射失败！", zap.Error(err))
+		c.ResponseError(errors.New("添加目录组映射失败！"))
+		return
+	}
+	c.ResponseOK()
+}
+
+// 删除目录组与群的映射（不会移除已有的群成员）
+func (m *Manager) ldapGroupDelete(c *wkhttp.Context) {
+	err := c.CheckLoginRoleIsSuperAdmin()
+	if err != nil {
+		c.ResponseError(err)
+		return
+	}
+	id, _ := strconv.ParseInt(c.Param("id"), 10, 64)
+	err = m.ldap.db.deleteGroup(id)
+	if err != nil {
+		m.Error("删除目录组映射失败！", zap.Error(err))
+		c.ResponseError(errors.New("删除目录组映射失败！"))
+		return
 	}
+	c.ResponseOK()
 }
 
 func (m *Manager) devices(c *wkhttp.Context) {
@@ -151,6 +670,11 @@ func (m *Manager) login(c *wkhttp.Context) {
 		c.ResponseError(err)
 		return
 	}
+	publicIP := util.GetClientPublicIP(c.Request)
+	if err := m.loginGuard.check(req.Username, publicIP, req.CaptchaID, req.CaptchaCode); err != nil {
+		responseLoginGuardError(err, c)
+		return
+	}
 	userInfo, err := m.db.queryUserInfoWithNameAndPwd(req.Username)
 	if err != nil {
 		m.Error("登录错误", zap.Error(err))
@@ -158,27 +682,182 @@ func (m *Manager) login(c *wkhttp.Context) {
 		return
 	}
 	if userInfo == nil || userInfo.UID == "" {
+		m.loginGuard.fail(req.Username, "", publicIP)
 		c.ResponseError(errors.New("登录用户不存在"))
 		return
 	}
-	if userInfo.Password != util.MD5(util.MD5(req.Password)) {
+	ok, err := verifyPassword(m.userDB, userInfo.UID, req.Password, userInfo.Password, userInfo.PasswordAlgo, userInfo.PasswordReset)
+	if err != nil {
+		m.Error("校验密码失败！", zap.Error(err))
+		c.ResponseError(errors.New("校验密码失败！"))
+		return
+	}
+	if !ok {
+		m.loginGuard.fail(req.Username, userInfo.UID, publicIP)
 		c.ResponseError(errors.New("用户名或密码错误"))
 		return
 	}
+	m.loginGuard.success(req.Username)
+	if userInfo.PasswordReset == 1 {
+		c.ResponseError(ErrPasswordNeedReset)
+		return
+	}
 	if userInfo.Role != string(wkhttp.Admin) && userInfo.Role != string(wkhttp.SuperAdmin) {
 		c.ResponseError(errors.New("登录账号未开通管理权限"))
 		return
 	}
+	// 两步验证
+	enabled, err := totpEnabled(m.totpDB, userInfo.UID)
+	if err != nil {
+		m.Error("查询两步验证失败！", zap.Error(err))
+		c.ResponseError(errors.New("查询两步验证失败！"))
+		return
+	}
+	needEnroll := false
+	if !enabled {
+		appConfig, err := m.commonService.GetAppConfig()
+		if err != nil {
+			m.Error("查询应用配置失败！", zap.Error(err))
+			c.ResponseError(errors.New("查询应用配置失败！"))
+			return
+		}
+		needEnroll = appConfig != nil && appConfig.ManagerTOTPOn == 1
+	}
+	if enabled || needEnroll {
+		ticket, err := createTOTPLoginTicket(m.ctx, &totpLoginTicket{
+			UID:     userInfo.UID,
+			Flag:    config.Web.Uint8(),
+			Manager: 1,
+		})
+		if err != nil {
+			m.Error("创建两步验证登录凭证失败！", zap.Error(err))
+			c.ResponseError(errors.New("创建两步验证登录凭证失败！"))
+			return
+		}
+		status, msg := 111, ErrUserNeedTOTP.Error()
+		if needEnroll {
+			status, msg = 112, ErrUserNeedTOTPEnroll.Error()
+		}
+		c.ResponseWithStatus(http.StatusBadRequest, map[string]interface{}{
+			"status": status,
+			"msg":    msg,
+			"uid":    userInfo.UID,
+			"ticket": ticket,
+		})
+		return
+	}
+	m.responseLoginToken(userInfo.UID, userInfo.Name, userInfo.Role, nil, c)
+}
+
+// 后台登录第二步：验证两步验证码
+func (m *Manager) loginTOTP(c *wkhttp.Context) {
+	var req struct {
+		Ticket string `json:"ticket"`
+		Code   string `json:"code"` // 验证码或恢复码
+	}
+	if err := c.BindJSON(&req); err != nil {
+		c.ResponseError(errors.New("请求数据格式有误！"))
+		return
+	}
+	ticket, err := checkTOTPLoginTicket(m.ctx, m.totpDB, req.Ticket, req.Code)
+	if err != nil {
+		c.ResponseError(err)
+		return
+	}
+	userInfo, err := m.managerTOTPUser(ticket)
+	if err != nil {
+		c.ResponseError(err)
+		return
+	}
+	m.responseLoginToken(userInfo.UID, userInfo.Name, userInfo.Role, nil, c)
+}
+
+// 后台要求开启两步验证时，登录过程中绑定两步验证
+func (m *Manager) loginTOTPEnroll(c *wkhttp.Context) {
+	var req struct {
+		Ticket string `json:"ticket"`
+	}
+	if err := c.BindJSON(&req); err != nil {
+		c.ResponseError(errors.New("请求数据格式有误！"))
+		return
+	}
+	ticket, err := loadTOTPLoginTicket(m.ctx, req.Ticket)
+	if err != nil {
+		c.ResponseError(err)
+		return
+	}
+	userInfo, err := m.managerTOTPUser(ticket)
+	if err != nil {
+		c.ResponseError(err)
+		return
+	}
+	resp, err := startTOTPEnroll(m.ctx, m.totpDB, userInfo.UID, userInfo.Username)
+	if err != nil {
+		c.ResponseError(err)
+		return
+	}
+	c.Response(resp)
+}
+
+// 登录过程中验证并开启两步验证，成功后直接登录并返回恢复码
+func (m *Manager) loginTOTPEnrollVerify(c *wkhttp.Context) {
+	var req struct {
+		Ticket string `json:"ticket"`
+		Code   string `json:"code"`
+	}
+	if err := c.BindJSON(&req); err != nil {
+		c.ResponseError(errors.New("请求数据格式有误！"))
+		return
+	}
+	ticket, err := loadTOTPLoginTicket(m.ctx, req.Ticket)
+	if err != nil {
+		c.ResponseError(err)
+		return
+	}
+	userInfo, err := m.managerTOTPUser(ticket)
+	if err != nil {
+		c.ResponseError(err)
+		return
+	}
+	codes, err := finishTOTPEnroll(m.ctx, m.totpDB, userInfo.UID, req.Code)
+	if err != nil {
+		c.ResponseError(err)
+		return
+	}
+	_ = m.ctx.GetRedisConn().Del(fmt.Sprintf("%s%s", totpLoginTicketPrefix, req.Ticket))
+	m.responseLoginToken(userInfo.UID, userInfo.Name, userInfo.Role, codes, c)
+}
+
+// 查询两步验证登录凭证对应的管理员
+func (m *Manager) managerTOTPUser(ticket *totpLoginTicket) (*Model, error) {
+	if ticket.Manager != 1 {
+		return nil, errors.New("登录凭证无效，请重新登录！")
+	}
+	userInfo, err := m.userDB.QueryByUID(ticket.UID)
+	if err != nil {
+		m.Error("查询用户信息失败！", zap.Error(err))
+		return nil, errors.New("查询用户信息失败！")
+	}
+	if userInfo == nil {
+		return nil, errors.New("登录用户不存在")
+	}
+	if userInfo.Role != string(wkhttp.Admin) && userInfo.Role != string(wkhttp.SuperAdmin) {
+		return nil, errors.New("登录账号未开通管理权限")
+	}
+	return userInfo, nil
+}
+
+func (m *Manager) responseLoginToken(uid, name, role string, recoveryCodes []string, c *wkhttp.Context) {
 	token := util.GenerUUID()
 	// 将token设置到缓存
-	err = m.ctx.Cache().SetAndExpire(m.ctx.GetConfig().Cache.TokenCachePrefix+token, wkhttp.EncodeTokenCacheInfo(userInfo.UID, userInfo.Name, userInfo.Role), m.ctx.GetConfig().Cache.TokenExpire)
+	err := m.ctx.Cache().SetAndExpire(m.ctx.GetConfig().Cache.TokenCachePrefix+token, wkhttp.EncodeTokenCacheInfo(uid, name, role), m.ctx.GetConfig().Cache.TokenExpire)
 	if err != nil {
 		m.Error("设置token缓存失败！", zap.Error(err))
 		c.ResponseError(errors.New("设置token缓存失败！"))
 		return
 	}
 
-	err = m.ctx.Cache().SetAndExpire(fmt.Sprintf("%s%d%s", m.ctx.GetConfig().Cache.UIDTokenCachePrefix, config.Web, userInfo.UID), token, m.ctx.GetConfig().Cache.TokenExpire)
+	err = m.ctx.Cache().SetAndExpire(fmt.Sprintf("%s%d%s", m.ctx.GetConfig().Cache.UIDTokenCachePrefix, config.Web, uid), token, m.ctx.GetConfig().Cache.TokenExpire)
 	if err != nil {
 		m.Error("设置uidtoken缓存失败！", zap.Error(err))
 		c.ResponseError(errors.New("设置token缓存失败！"))
@@ -186,13 +865,40 @@ func (m *Manager) login(c *wkhttp.Context) {
 	}
 
 	c.Response(&managerLoginResp{
-		UID:   userInfo.UID,
-		Token: token,
-		Name:  userInfo.Name,
-		Role:  userInfo.Role,
+		UID:           uid,
+		Token:         token,
+		Name:          name,
+		Role:          role,
+		RecoveryCodes: recoveryCodes,
 	})
 }
 
+// 重置用户的两步验证（用户丢失验证器和恢复码时使用）
+func (m *Manager) resetUserTOTP(c *wkhttp.Context) {
+	err := c.CheckLoginRoleIsSuperAdmin()
+	if err != nil {
+		c.ResponseError(err)
+		return
+	}
+	var req struct {
+		UID string `json:"uid"`
+	}
+	if err := c.BindJSON(&req); err != nil {
+		c.ResponseError(errors.New("请求数据格式有误！"))
+		return
+	}
+	if strings.TrimSpace(req.UID) == "" {
+		c.ResponseError(errors.New("用户uid不能为空"))
+		return
+	}
+	if err = deleteTOTP(m.ctx, m.totpDB, req.UID); err != nil {
+		m.Error("重置两步验证错误", zap.Error(err))
+		c.ResponseError(errors.New("重置两步验证错误"))
+		return
+	}
+	c.ResponseOK()
+}
+
 // 重置用户密码
 func (m *Manager) resetUserPassword(c *wkhttp.Context) {
 	err := c.CheckLoginRoleIsSuperAdmin()
@@ -234,7 +940,7 @@ func (m *Manager) resetUserPassword(c *wkhttp.Context) {
 		return
 	}
 
-	err = m.userDB.UpdateUsersWithField("password", util.MD5(util.MD5(req.NewPassword)), req.Uid)
+	err = updatePassword(m.userDB, req.Uid, req.NewPassword)
 	if err != nil {
 		m.Error("重置用户密码错误", zap.Error(err))
 		c.Response("重置用户密码错误")
@@ -371,7 +1077,12 @@ func (m *Manager) addAdminUser(c *wkhttp.Context) {
 	userModel.Username = req.LoginName
 	userModel.Zone = ""
 	userModel.Role = string(wkhttp.Admin)
-	userModel.Password = util.MD5(util.MD5(req.Password))
+	err = setModelPassword(userModel, req.Password)
+	if err != nil {
+		m.Error("生成密码哈希失败！", zap.Error(err))
+		c.ResponseError(errors.New("生成密码哈希失败！"))
+		return
+	}
 	userModel.ShortNo = util.Ten2Hex(time.Now().UnixNano())
 	userModel.IsUploadAvatar = 0
 	userModel.NewMsgNotice = 0
@@ -453,7 +1164,13 @@ func (m *Manager) addUser(c *wkhttp.Context) {
 	userModel.Phone = req.Phone
 	userModel.Username = fmt.Sprintf("%s%s", req.Zone, req.Phone)
 	userModel.Zone = req.Zone
-	userModel.Password = util.MD5(util.MD5(req.Password))
+	err = setModelPassword(userModel, req.Password)
+	if err != nil {
+		tx.Rollback()
+		m.Error("生成密码哈希失败！", zap.Error(err))
+		c.ResponseError(errors.New("生成密码哈希失败！"))
+		return
+	}
 	userModel.ShortNo = shortNo
 	userModel.IsUploadAvatar = 0
 	userModel.NewMsgNotice = 1
@@ -510,6 +1227,69 @@ func (m *Manager) addUser(c *wkhttp.Context) {
 	c.ResponseOK()
 }
 
+// 还在使用旧密码哈希的用户列表
+func (m *Manager) legacyPwdUsers(c *wkhttp.Context) {
+	err := c.CheckLoginRole()
+	if err != nil {
+		c.ResponseError(err)
+		return
+	}
+	pageIndex, pageSize := c.GetPage()
+	users, err := m.userDB.queryLegacyPasswordWithPage(uint64(pageSize), uint64(pageIndex))
+	if err != nil {
+		m.Error("查询旧密码用户列表错误", zap.Error(err))
+		c.ResponseError(errors.New("查询旧密码用户列表错误"))
+		return
+	}
+	count, err := m.userDB.queryLegacyPasswordCount()
+	if err != nil {
+		m.Error("查询旧密码用户数量错误", zap.Error(err))
+		c.ResponseError(errors.New("查询旧密码用户数量错误"))
+		return
+	}
+	list := make([]*managerLegacyPwdUserResp, 0, len(users))
+	for _, user := range users {
+		list = append(list, &managerLegacyPwdUserResp{
+			UID:           user.UID,
+			Name:          user.Name,
+			Username:      user.Username,
+			Phone:         getShowPhoneNum(user.Phone),
+			Role:          user.Role,
+			PasswordReset: user.PasswordReset,
+			RegisterTime:  user.CreatedAt.String(),
+		})
+	}
+	c.Response(map[string]interface{}{
+		"list":  list,
+		"count": count,
+	})
+}
+
+// 强制使用旧密码哈希的用户重置密码（重置后才能用密码登录）
+func (m *Manager) resetLegacyPwd(c *wkhttp.Context) {
+	err := c.CheckLoginRoleIsSuperAdmin()
+	if err != nil {
+		c.ResponseError(err)
+		return
+	}
+	var req struct {
+		UIDs []string `json:"uids"` // 为空时重置所有普通用户
+	}
+	if err := c.BindJSON(&req); err != nil {
+		c.ResponseError(errors.New("请求数据格式有误！"))
+		return
+	}
+	count, err := m.userDB.updateLegacyPasswordReset(req.UIDs, []string{string(wkhttp.Admin), string(wkhttp.SuperAdmin)})
+	if err != nil {
+		m.Error("强制重置密码错误", zap.Error(err))
+		c.ResponseError(errors.New("强制重置密码错误"))
+		return
+	}
+	c.Response(map[string]interface{}{
+		"count": count,
+	})
+}
+
 // 用户列表
 func (m *Manager) list(c *wkhttp.Context) {
 	err := c.CheckLoginRole()
@@ -871,6 +1651,13 @@ func (m *Manager) liftBanUser(c *wkhttp.Context) {
 		c.ResponseError(errors.New("更新IM的token失败！"))
 		return
 	}
+	if ban == 1 {
+		_, err = revokeUserSessions(m.ctx, m.sessionDB, userInfo.UID)
+		if err != nil {
+			c.ResponseError(err)
+			return
+		}
+	}
 	err = m.ctx.QuitUserDevice(userInfo.UID, -1)
 	if err != nil {
 		m.Error("下线用户所有登录设备错误", zap.Error(err), zap.String("uid", uid))
@@ -911,7 +1698,13 @@ func (m *Manager) updatePwd(c *wkhttp.Context) {
 		c.ResponseError(errors.New("操作用户不存在"))
 		return
 	}
-	if util.MD5(util.MD5(req.Password)) != user.Password {
+	ok, err := verifyPassword(m.userDB, user.UID, req.Password, user.Password, user.PasswordAlgo, user.PasswordReset)
+	if err != nil {
+		m.Error("校验密码失败！", zap.Error(err))
+		c.ResponseError(errors.New("校验密码失败！"))
+		return
+	}
+	if !ok {
 		c.ResponseError(errors.New("原密码错误"))
 		return
 	}
@@ -923,7 +1716,7 @@ func (m *Manager) updatePwd(c *wkhttp.Context) {
 		c.ResponseError(errors.New("新密码不能和旧密码一样"))
 		return
 	}
-	err = m.userDB.UpdateUsersWithField("password", util.MD5(util.MD5(req.NewPassword)), loginUID)
+	err = updatePassword(m.userDB, loginUID, req.NewPassword)
 	if err != nil {
 		m.Error("修改用户密码错误", zap.Error(err))
 		c.Response("修改用户密码错误")
@@ -1073,7 +1866,7 @@ func (m *Manager) createManagerAccount() {
 	username := string(wkhttp.SuperAdmin)
 	role := string(wkhttp.SuperAdmin)
 	var pwd = m.ctx.GetConfig().AdminPwd
-	err = m.userDB.Insert(&Model{
+	userModel := &Model{
 		UID:      m.ctx.GetConfig().Account.AdminUID,
 		Name:     "超级管理员",
 		ShortNo:  "30000",
@@ -1083,8 +1876,13 @@ func (m *Manager) createManagerAccount() {
 		Zone:     "0086",
 		Phone:    "13000000002",
 		Status:   1,
-		Password: util.MD5(util.MD5(pwd)),
-	})
+	}
+	err = setModelPassword(userModel, pwd)
+	if err != nil {
+		m.Error("生成密码哈希失败！", zap.Error(err))
+		return
+	}
+	err = m.userDB.Insert(userModel)
 	if err != nil {
 		m.Error("新增系统管理员错误", zap.Error(err))
 		return
@@ -1110,15 +1908,27 @@ func getShowPhoneNum(mobile string) string {
 }
 
 type managerLoginReq struct {
-	Username string `json:"username"`
-	Password string `json:"password"`
+	Username    string `json:"username"`
+	Password    string `json:"password"`
+	CaptchaID   string `json:"captcha_id"`   // 连续登录失败后需要的图片验证码
+	CaptchaCode string `json:"captcha_code"` // 图片验证码内容
+}
+
+type loginLockResp struct {
+	Type      int    `json:"type"` // 1.锁定 2.解锁
+	UID       string `json:"uid"`
+	Account   string `json:"account"`
+	IP        string `json:"ip"`
+	Remark    string `json:"remark"`
+	CreatedAt string `json:"created_at"`
 }
 
 type managerLoginResp struct {
-	UID   string `json:"uid"`
-	Token string `json:"token"`
-	Name  string `json:"name"`
-	Role  string `json:"role"`
+	UID           string   `json:"uid"`
+	Token         string   `json:"token"`
+	Name          string   `json:"name"`
+	Role          string   `json:"role"`
+	RecoveryCodes []string `json:"recovery_codes,omitempty"` // 登录时开启两步验证返回的恢复码
 }
 type managerAddUserReq struct {
 	Name     string `json:"name"`
@@ -1158,6 +1968,16 @@ type managerUserResp struct {
 	GithubUID      string `json:"github_uid"` // github uid
 }
 
+type managerLegacyPwdUserResp struct {
+	UID           string `json:"uid"`
+	Name          string `json:"name"`
+	Username      string `json:"username"`
+	Phone         string `json:"phone"`
+	Role          string `json:"role"`
+	PasswordReset int    `json:"password_reset"` // 是否已要求重置密码 0.否 1.是
+	RegisterTime  string `json:"register_time"`
+}
+
 type managerFriendResp struct {
 	Name             string `json:"name"`
 	UID              string `json:"uid"`
@@ -1202,3 +2022,214 @@ func newUserOnlineResp(m *onlineStatusWeightModel) *userOnlineResp {
 		Online:      m.Online,
 	}
 }
+
+type oidcProviderReq struct {
+	Provider     string `json:"provider"`      // 提供方标识
+	Name         string `json:"name"`          // 显示名称
+	Icon         string `json:"icon"`          // 图标地址
+	Issuer       string `json:"issuer"`        // issuer地址
+	ClientID     string `json:"client_id"`     // 客户端ID
+	ClientSecret string `json:"client_secret"` // 客户端密钥
+	Scopes       string `json:"scopes"`        // 申请的scope，空格分隔
+	NameClaim    string `json:"name_claim"`    // 用户名称对应的声明
+	AvatarClaim  string `json:"avatar_claim"`  // 头像对应的声明
+	PhoneClaim   string `json:"phone_claim"`   // 手机号对应的声明
+	PhoneZone    string `json:"phone_zone"`    // 默认区号
+	Status       int    `json:"status"`        // 状态 0.禁用 1.启用
+}
+
+func (r oidcProviderReq) check() error {
+	if strings.TrimSpace(r.Provider) == "" {
+		return errors.New("提供方标识不能为空！")
+	}
+	if len(r.Provider) > 40 || strings.ContainsAny(r.Provider, "/?#& ") {
+		return errors.New("提供方标识格式有误！")
+	}
+	if strings.TrimSpace(r.Name) == "" {
+		return errors.New("名称不能为空！")
+	}
+	if !strings.HasPrefix(r.Issuer, "https://") && !strings.HasPrefix(r.Issuer, "http://") {
+		return errors.New("issuer格式有误！")
+	}
+	if strings.TrimSpace(r.ClientID) == "" {
+		return errors.New("客户端ID不能为空！")
+	}
+	if r.Scopes != "" && !strings.Contains(" "+r.Scopes+" ", " openid ") {
+		return errors.New("scopes必须包含openid！")
+	}
+	return nil
+}
+
+func (r oidcProviderReq) toModel() *oidcProviderModel {
+	model := &oidcProviderModel{
+		Provider:     r.Provider,
+		Name:         r.Name,
+		Icon:         r.Icon,
+		Issuer:       strings.TrimSuffix(r.Issuer, "/"),
+		ClientID:     r.ClientID,
+		ClientSecret: r.ClientSecret,
+		Scopes:       r.Scopes,
+		NameClaim:    r.NameClaim,
+		AvatarClaim:  r.AvatarClaim,
+		PhoneClaim:   r.PhoneClaim,
+		PhoneZone:    r.PhoneZone,
+		Status:       r.Status,
+	}
+	if model.Scopes == "" {
+		model.Scopes = "openid profile"
+	}
+	if model.NameClaim == "" {
+		model.NameClaim = "name"
+	}
+	if model.PhoneZone == "" {
+		model.PhoneZone = "0086"
+	}
+	return model
+}
+
+type oidcProviderResp struct {
+	Provider     string `json:"provider"`
+	Name         string `json:"name"`
+	Icon         string `json:"icon"`
+	Issuer       string `json:"issuer"`
+	ClientID     string `json:"client_id"`
+	ClientSecret string `json:"client_secret"` // 脱敏后的密钥
+	Scopes       string `json:"scopes"`
+	NameClaim    string `json:"name_claim"`
+	AvatarClaim  string `json:"avatar_claim"`
+	PhoneClaim   string `json:"phone_claim"`
+	PhoneZone    string `json:"phone_zone"`
+	Status       int    `json:"status"`
+	RedirectPath string `json:"redirect_path"` // 需要在身份提供方登记的回调路径
+	CreatedAt    string `json:"created_at"`
+}
+
+func newOIDCProviderResp(m *oidcProviderModel) *oidcProviderResp {
+	secret := ""
+	if m.ClientSecret != "" {
+		secret = maskedSecret
+	}
+	return &oidcProviderResp{
+		Provider:     m.Provider,
+		Name:         m.Name,
+		Icon:         m.Icon,
+		Issuer:       m.Issuer,
+		ClientID:     m.ClientID,
+		ClientSecret: secret,
+		Scopes:       m.Scopes,
+		NameClaim:    m.NameClaim,
+		AvatarClaim:  m.AvatarClaim,
+		PhoneClaim:   m.PhoneClaim,
+		PhoneZone:    m.PhoneZone,
+		Status:       m.Status,
+		RedirectPath: fmt.Sprintf("/v1/user/oauth/oidc/%s", m.Provider),
+		CreatedAt:    m.CreatedAt.String(),
+	}
+}
+
+type ldapConfigReq struct {
+	Status             int    `json:"status"`               // 是否开启目录认证
+	URL                string `json:"url"`                  // 目录地址
+	StartTLS           int    `json:"start_tls"`            // 是否使用StartTLS
+	InsecureSkipVerify int    `json:"insecure_skip_verify"` // 是否跳过证书校验
+	BindDN             string `json:"bind_dn"`              // 服务账号
+	BindPassword       string `json:"bind_password"`        // 服务账号密码
+	BaseDN             string `json:"base_dn"`              // 用户搜索的根节点
+	UserFilter         string `json:"user_filter"`          // 登录时查找用户的过滤器
+	UsernameAttr       string `json:"username_attr"`        // 登录名属性
+	NameAttr           string `json:"name_attr"`            // 用户名称属性
+	EmailAttr          string `json:"email_attr"`           // 邮箱属性
+	SyncOn             int    `json:"sync_on"`              // 是否定时同步
+	SyncFilter         string `json:"sync_filter"`          // 同步时查找有效用户的过滤器
+	SyncInterval       int    `json:"sync_interval"`        // 同步间隔（分钟）
+	GroupMemberAttr    string `json:"group_member_attr"`    // 目录组的成员属性
+}
+
+func (r ldapConfigReq) check() error {
+	if r.Status != 1 {
+		return nil
+	}
+	if err := checkLDAPURL(r.URL); err != nil {
+		return err
+	}
+	if strings.TrimSpace(r.BaseDN) == "" {
+		return errors.New("base_dn不能为空！")
+	}
+	if !strings.Contains(r.UserFilter, "{username}") {
+		return errors.New("user_filter必须包含{username}！")
+	}
+	if r.SyncInterval != 0 && r.SyncInterval < 10 {
+		return errors.New("同步间隔不能小于10分钟！")
+	}
+	return nil
+}
+
+func (r ldapConfigReq) toModel() *ldapConfigModel {
+	model := defaultLDAPConfig()
+	model.Status = r.Status
+	model.URL = strings.TrimSpace(r.URL)
+	model.StartTLS = r.StartTLS
+	model.InsecureSkipVerify = r.InsecureSkipVerify
+	model.BindDN = r.BindDN
+	model.BindPassword = r.BindPassword
+	model.BaseDN = r.BaseDN
+	model.SyncOn = r.SyncOn
+	if r.UserFilter != "" {
+		model.UserFilter = r.UserFilter
+	}
+	if r.UsernameAttr != "" {
+		model.UsernameAttr = r.UsernameAttr
+	}
+	if r.NameAttr != "" {
+		model.NameAttr = r.NameAttr
+	}
+	if r.EmailAttr != "" {
+		model.EmailAttr = r.EmailAttr
+	}
+	if r.SyncFilter != "" {
+		model.SyncFilter = r.SyncFilter
+	}
+	if r.SyncInterval > 0 {
+		model.SyncInterval = r.SyncInterval
+	}
+	if r.GroupMemberAttr != "" {
+		model.GroupMemberAttr = r.GroupMemberAttr
+	}
+	return model
+}
+
+func defaultLDAPConfig() *ldapConfigModel {
+	return &ldapConfigModel{
+		UserFilter:      "(&(objectClass=person)(uid={username}))",
+		UsernameAttr:    "uid",
+		NameAttr:        "cn",
+		EmailAttr:       "mail",
+		SyncFilter:      "(objectClass=person)",
+		SyncInterval:    60,
+		GroupMemberAttr: "member",
+	}
+}
+
+func newLDAPConfigResp(m *ldapConfigModel) *ldapConfigReq {
+	bindPassword := ""
+	if m.BindPassword != "" {
+		bindPassword = maskedSecret
+	}
+	return &ldapConfigReq{
+		Status:             m.Status,
+		URL:                m.URL,
+		StartTLS:           m.StartTLS,
+		InsecureSkipVerify: m.InsecureSkipVerify,
+		BindDN:             m.BindDN,
+		BindPassword:       bindPassword,
+		BaseDN:             m.BaseDN,
+		UserFilter:         m.UserFilter,
+		UsernameAttr:       m.UsernameAttr,
+		NameAttr:           m.NameAttr,
+		EmailAttr:          m.EmailAttr,
+		SyncOn:             m.SyncOn,
+		SyncFilter:         m.SyncFilter,
+		SyncInterval:       m.SyncInterval,
+		GroupMemberAttr:    m.GroupMemberAttr,
+	}
+}
@@ -0,0 +1,397 @@
+package user
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/TangSengDaoDao/TangSengDaoDaoServer/pkg/oidc"
+	"github.com/TangSengDaoDao/TangSengDaoDaoServerLib/config"
+	"github.com/TangSengDaoDao/TangSengDaoDaoServerLib/pkg/util"
+	"github.com/TangSengDaoDao/TangSengDaoDaoServerLib/pkg/wkhttp"
+	"github.com/opentracing/opentracing-go"
+	"go.uber.org/zap"
+)
+
+const (
+	oidcStatePrefix       = "oidcState:"     // 授权请求的state
+	oidcStateExpire       = time.Minute * 10 // state有效期
+	oidcProviderCacheTime = time.Hour        // 发现文档缓存时间
+	oidcRequestTimeout    = time.Second * 10 // 请求身份提供方的超时时间
+)
+
+// 授权请求的上下文（回调时通过state取回）
+type oidcState struct {
+	Provider     string `json:"provider"`
+	Authcode     string `json:"authcode"`
+	Nonce        string `json:"nonce"`
+	CodeVerifier string `json:"code_verifier"`
+}
+
+type oidcCachedProvider struct {
+	provider  *oidc.Provider
+	model     *oidcProviderModel
+	expiredAt time.Time
+}
+
+var (
+	oidcProviderCacheLock sync.Mutex
+	oidcProviderCache     = map[string]*oidcCachedProvider{}
+)
+
+// 已启用的身份提供方列表
+func (u *User) oidcProviders(c *wkhttp.Context) {
+	models, err := u.oidcDB.queryEnabledProviders()
+	if err != nil {
+		u.Error("查询身份提供方失败！", zap.Error(err))
+		c.ResponseError(errors.New("查询身份提供方失败！"))
+		return
+	}
+	list := make([]map[string]interface{}, 0, len(models))
+	for _, model := range models {
+		list = append(list, map[string]interface{}{
+			"provider": model.Provider,
+			"name":     model.Name,
+			"icon":     model.Icon,
+		})
+	}
+	c.Response(list)
+}
+
+// 跳转到身份提供方的授权页面
+func (u *User) oidcAuthorize(c *wkhttp.Context) {
+	authcode := c.Query("authcode")
+	exist, err := u.ctx.GetRedisConn().GetString(fmt.Sprintf("%s%s", ThirdAuthcodePrefix, authcode))
+	if err != nil {
+		u.Error("获取授权码失败！", zap.Error(err))
+		c.ResponseError(errors.New("获取授权码失败！"))
+		return
+	}
+	if exist != "1" {
+		c.ResponseError(errors.New("授权码无效或已过期！"))
+		return
+	}
+	provider, _, err := u.getOIDCProvider(c.Param("provider"))
+	if err != nil {
+		c.ResponseError(err)
+		return
+	}
+	state, err := oidc.NewRandom()
+	if err != nil {
+		u.Error("生成state失败！", zap.Error(err))
+		c.ResponseError(errors.New("生成state失败！"))
+		return
+	}
+	nonce, err := oidc.NewRandom()
+	if err != nil {
+		u.Error("生成nonce失败！", zap.Error(err))
+		c.ResponseError(errors.New("生成nonce失败！"))
+		return
+	}
+	codeVerifier, err := oidc.NewRandom()
+	if err != nil {
+		u.Error("生成code_verifier失败！", zap.Error(err))
+		c.ResponseError(errors.New("生成code_verifier失败！"))
+		return
+	}
+	err = u.ctx.GetRedisConn().SetAndExpire(fmt.Sprintf("%s%s", oidcStatePrefix, state), util.ToJson(&oidcState{
+		Provider:     c.Param("provider"),
+		Authcode:     authcode,
+		Nonce:        nonce,
+		CodeVerifier: codeVerifier,
+	}), oidcStateExpire)
+	if err != nil {
+		u.Error("缓存授权状态失败！", zap.Error(err))
+		c.ResponseError(errors.New("缓存授权状态失败！"))
+		return
+	}
+	c.Redirect(http.StatusFound, provider.AuthCodeURL(state, nonce, oidc.CodeChallenge(codeVerifier)))
+}
+
+// 身份提供方授权回调
+func (u *User) oidcCallback(c *wkhttp.Context) {
+	stateKey := fmt.Sprintf("%s%s", oidcStatePrefix, c.Query("state"))
+	stateStr, err := u.ctx.GetRedisConn().GetString(stateKey)
+	if err != nil {
+		u.Error("获取授权状态失败！", zap.Error(err))
+		c.String(http.StatusInternalServerError, "获取授权状态失败！")
+		return
+	}
+	if stateStr == "" {
+		c.String(http.StatusBadRequest, "登录已过期，请重新登录！")
+		return
+	}
+	_ = u.ctx.GetRedisConn().Del(stateKey) // state只能使用一次
+	var state oidcState
+	if err = util.ReadJsonByByte([]byte(stateStr), &state); err != nil || state.Provider != c.Param("provider") {
+		c.String(http.StatusBadRequest, "登录状态无效！")
+		return
+	}
+	loginRespStr, err := u.oidcLogin(&state, c)
+	if err != nil {
+		u.Warn("oidc登录失败！", zap.Error(err), zap.String("provider", state.Provider))
+		_ = u.ctx.GetRedisConn().SetAndExpire(fmt.Sprintf("%s%s", ThirdAuthcodePrefix, state.Authcode), "0", time.Minute*1)
+		c.String(http.StatusOK, fmt.Sprintf("登录失败：%s", err.Error()))
+		return
+	}
+	err = u.ctx.GetRedisConn().SetAndExpire(fmt.Sprintf("%s%s", ThirdAuthcodePrefix, state.Authcode), loginRespStr, time.Minute*1)
+	if err != nil {
+		u.Error("redis set error", zap.Error(err))
+		c.String(http.StatusInternalServerError, "登录失败！")
+		return
+	}
+	if strings.HasPrefix(loginRespStr, thirdAuthVerifyPrefix) {
+		c.String(http.StatusOK, "请返回应用完成验证！")
+		return
+	}
+	c.String(http.StatusOK, "登录成功，请返回应用！")
+}
+
+// 校验授权码并登录（不存在绑定关系时创建用户），返回缓存到授权码的登录结果
+func (u *User) oidcLogin(state *oidcState, c *wkhttp.Context) (string, error) {
+	if errMsg := c.Query("error"); errMsg != "" {
+		return "", fmt.Errorf("身份提供方返回错误：%s", errMsg)
+	}
+	code := c.Query("code")
+	if code == "" {
+		return "", errors.New("code不能为空")
+	}
+	provider, providerM, err := u.getOIDCProvider(state.Provider)
+	if err != nil {
+		return "", err
+	}
+	timeoutCtx, cancel := context.WithTimeout(context.Background(), oidcRequestTimeout)
+	defer cancel()
+	token, err := provider.Exchange(timeoutCtx, code, state.CodeVerifier)
+	if err != nil {
+		u.Warn("授权码换取token失败！", zap.Error(err))
+		return "", errors.New("授权码换取token失败！")
+	}
+	claims, err := provider.VerifyIDToken(timeoutCtx, token.IDToken, state.Nonce, time.Now())
+	if err != nil {
+		u.Warn("校验id_token失败！", zap.Error(err))
+		return "", errors.New("校验身份失败！")
+	}
+	if token.AccessToken != "" {
+		// id_token里可能只有sub，其他声明从userinfo补充
+		userInfoClaims, err := provider.UserInfo(timeoutCtx, token.AccessToken)
+		if err != nil {
+			u.Warn("获取userinfo失败！", zap.Error(err))
+		} else if userInfoClaims.String("sub") == claims.String("sub") {
+			claims.Merge(userInfoClaims)
+		}
+	}
+	subject := claims.String("sub")
+
+	loginSpan := u.ctx.Tracer().StartSpan(
+		"oidclogin",
+		opentracing.ChildOf(c.GetSpanContext()),
+	)
+	loginSpan.SetTag("provider", state.Provider)
+	defer loginSpan.Finish()
+	loginSpanCtx := u.ctx.Tracer().ContextWithSpan(context.Background(), loginSpan)
+	deviceFlag := config.APP
+
+	identity, err := u.oidcDB.queryIdentity(state.Provider, subject)
+	if err != nil {
+		u.Error("查询身份绑定失败！", zap.Error(err))
+		return "", errors.New("查询身份绑定失败！")
+	}
+	publicIP := util.GetClientPublicIP(c.Request)
+	if identity != nil { // 已绑定就登录
+		userInfo, err := u.db.QueryByUID(identity.UID)
+		if err != nil {
+			u.Error("查询用户信息失败！", zap.Error(err))
+			return "", errors.New("查询用户信息失败！")
+		}
+		if userInfo == nil || userInfo.IsDestroy == 1 {
+			return "", errors.New("用户不存在")
+		}
+		loginResp, err := u.execLogin(userInfo, deviceFlag, nil, newLoginClient(c), loginSpanCtx)
+		if err != nil {
+			return u.thirdLoginVerifyResult(userInfo, deviceFlag, err)
+		}
+		go u.sentWelcomeMsg(publicIP, userInfo.UID)
+		return util.ToJson(loginResp), nil
+	}
+
+	// 创建用户
+	uid := util.GenerUUID()
+	name := strings.TrimSpace(claims.String(providerM.NameClaim))
+	if name == "" {
+		name = strings.TrimSpace(claims.String("preferred_username"))
+	}
+	if name == "" {
+		name = Names[crc32.ChecksumIEEE([]byte(uid))%uint32(len(Names))]
+	}
+	model := &createUserModel{
+		UID:  uid,
+		Name: name,
+		Flag: int(deviceFlag.Uint8()),
+	}
+	zone, phone, err := u.oidcPhone(providerM, claims)
+	if err != nil {
+		return "", err
+	}
+	model.Zone = zone
+	model.Phone = phone
+	if avatarURL := claims.String(providerM.AvatarClaim); avatarURL != "" && u.uploadRemoteAvatar(uid, avatarURL) {
+		model.IsUploadAvatar = 1
+	}
+
+	tx, err := u.ctx.DB().Begin()
+	if err != nil {
+		u.Error("开启事务失败！", zap.Error(err))
+		return "", errors.New("开启事务失败！")
+	}
+	defer func() {
+		if err := recover(); err != nil {
+			tx.Rollback()
+			panic(err)
+		}
+	}()
+	err = u.oidcDB.insertIdentityTx(&userIdentityModel{
+		Provider: state.Provider,
+		Subject:  subject,
+		UID:      uid,
+		Email:    claims.String("email"),
+	}, tx)
+	if err != nil {
+		tx.Rollback()
+		u.Error("添加身份绑定失败！", zap.Error(err))
+		return "", errors.New("添加身份绑定失败！")
+	}
+	var commitErr error
+	loginResp, err := u.createUserWithRespAndTx(loginSpanCtx, model, publicIP, nil, tx, func() error {
+		commitErr = tx.Commit()
+		if commitErr != nil {
+			tx.Rollback()
+			u.Error("数据库事物提交失败", zap.Error(commitErr))
+		}
+		return commitErr
+	})
+	if err != nil {
+		tx.Rollback()
+		return "", err
+	}
+	if commitErr != nil {
+		return "", errors.New("数据库事物提交失败")
+	}
+	return util.ToJson(loginResp), nil
+}
+
+// 从声明中取手机号，手机号已被其他账号使用或未验证时不同步
+func (u *User) oidcPhone(providerM *oidcProviderModel, claims oidc.Claims) (string, string, error) {
+	if providerM.PhoneClaim == "" {
+		return "", "", nil
+	}
+	if verified, ok := claims["phone_number_verified"].(bool); ok && !verified {
+		return "", "", nil
+	}
+	zone, phone := splitOIDCPhone(claims.String(providerM.PhoneClaim), providerM.PhoneZone)
+	if phone == "" {
+		return "", "", nil
+	}
+	exist, err := u.db.QueryByPhone(zone, phone)
+	if err != nil {
+		u.Error("查询手机号是否存在失败！", zap.Error(err))
+		return "", "", errors.New("查询手机号是否存在失败！")
+	}
+	if exist != nil {
+		return "", "", nil
+	}
+	return zone, phone, nil
+}
+
+// 下载第三方头像作为用户头像
+func (u *User) uploadRemoteAvatar(uid string, avatarURL string) bool {
+	timeoutCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	imgReader, _ := u.fileService.DownloadImage(avatarURL, timeoutCtx)
+	cancel()
+	if imgReader == nil {
+		return false
+	}
+	defer imgReader.Close()
+	avatarID := crc32.ChecksumIEEE([]byte(uid)) % uint32(u.ctx.GetConfig().Avatar.Partition)
+	_, err := u.fileService.UploadFile(fmt.Sprintf("avatar/%d/%s.png", avatarID, uid), "image/png", func(w io.Writer) error {
+		_, err := io.Copy(w, imgReader)
+		return err
+	})
+	if err != nil {
+		u.Warn("上传第三方头像失败！", zap.Error(err))
+		return false
+	}
+	return true
+}
+
+// 获取身份提供方（发现文档缓存一小时）
+func (u *User) getOIDCProvider(name string) (*oidc.Provider, *oidcProviderModel, error) {
+	oidcProviderCacheLock.Lock()
+	cached := oidcProviderCache[name]
+	oidcProviderCacheLock.Unlock()
+	if cached != nil && time.Now().Before(cached.expiredAt) {
+		return cached.provider, cached.model, nil
+	}
+	model, err := u.oidcDB.queryProvider(name)
+	if err != nil {
+		u.Error("查询身份提供方失败！", zap.Error(err))
+		return nil, nil, errors.New("查询身份提供方失败！")
+	}
+	if model == nil || model.Status != 1 {
+		return nil, nil, errors.New("身份提供方不存在或已禁用！")
+	}
+	timeoutCtx, cancel := context.WithTimeout(context.Background(), oidcRequestTimeout)
+	defer cancel()
+	provider, err := newOIDCProvider(timeoutCtx, u.ctx, model)
+	if err != nil {
+		u.Error("获取身份提供方配置失败！", zap.Error(err), zap.String("issuer", model.Issuer))
+		return nil, nil, errors.New("获取身份提供方配置失败！")
+	}
+	oidcProviderCacheLock.Lock()
+	oidcProviderCache[name] = &oidcCachedProvider{
+		provider:  provider,
+		model:     model,
+		expiredAt: time.Now().Add(oidcProviderCacheTime),
+	}
+	oidcProviderCacheLock.Unlock()
+	return provider, model, nil
+}
+
+func newOIDCProvider(ctx context.Context, appCtx *config.Context, model *oidcProviderModel) (*oidc.Provider, error) {
+	return oidc.NewProvider(ctx, oidc.Config{
+		Issuer:       model.Issuer,
+		ClientID:     model.ClientID,
+		ClientSecret: model.ClientSecret,
+		RedirectURL:  fmt.Sprintf("%s/user/oauth/oidc/%s", appCtx.GetConfig().External.APIBaseURL, model.Provider),
+		Scopes:       strings.Fields(model.Scopes),
+	}, nil)
+}
+
+// 清除身份提供方缓存（后台修改配置后调用）
+func removeOIDCProviderCache(name string) {
+	oidcProviderCacheLock.Lock()
+	delete(oidcProviderCache, name)
+	oidcProviderCacheLock.Unlock()
+}
+
+// 拆分手机号的区号，例如 +8613600000000 => 0086 13600000000
+func splitOIDCPhone(value string, defaultZone string) (string, string) {
+	value = strings.NewReplacer(" ", "", "-", "").Replace(strings.TrimSpace(value))
+	if value == "" {
+		return "", ""
+	}
+	if strings.HasPrefix(value, "+") {
+		zone := strings.TrimPrefix(defaultZone, "00")
+		if !strings.HasPrefix(value[1:], zone) {
+			return "", "" // 无法确定区号
+		}
+		return defaultZone, value[1+len(zone):]
+	}
+	return defaultZone, value
+}
@@ -0,0 +1,131 @@
+package user
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/TangSengDaoDao/TangSengDaoDaoServerLib/pkg/util"
+	"github.com/TangSengDaoDao/TangSengDaoDaoServerLib/testutil"
+	"github.com/stretchr/testify/assert"
+)
+
+// 模拟身份提供方，换取token时返回签发给subject的id_token
+func newTestOIDCServer(t *testing.T, subject string, nonce string) *httptest.Server {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	assert.NoError(t, err)
+	var server *httptest.Server
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{
+			"issuer":                 server.URL,
+			"authorization_endpoint": server.URL + "/auth",
+			"token_endpoint":         server.URL + "/token",
+			"jwks_uri":               server.URL + "/jwks",
+		})
+	})
+	mux.HandleFunc("/jwks", func(w http.ResponseWriter, r *http.Request) {
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{
+			"keys": []map[string]interface{}{{
+				"kty": "RSA",
+				"kid": "k1",
+				"use": "sig",
+				"n":   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+				"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+			}},
+		})
+	})
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		header, _ := json.Marshal(map[string]string{"alg": "RS256", "kid": "k1"})
+		payload, _ := json.Marshal(map[string]interface{}{
+			"iss":   server.URL,
+			"aud":   "client",
+			"sub":   subject,
+			"exp":   time.Now().Add(time.Hour).Unix(),
+			"iat":   time.Now().Unix(),
+			"nonce": nonce,
+		})
+		signed := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+		digest := sha256.Sum256([]byte(signed))
+		signature, _ := rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, digest[:])
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{
+			"id_token": signed + "." + base64.RawURLEncoding.EncodeToString(signature),
+		})
+	})
+	server = httptest.NewServer(mux)
+	t.Cleanup(server.Close)
+	return server
+}
+
+func TestOIDCLoginNeedTOTP(t *testing.T) {
+	s, ctx := testutil.NewTestServer()
+	u := New(ctx)
+	err := testutil.CleanAllTables(ctx)
+	assert.NoError(t, err)
+
+	server := newTestOIDCServer(t, "sub123", "nonce123")
+	err = u.oidcDB.insertProvider(&oidcProviderModel{
+		Provider: "totptest",
+		Name:     "totptest",
+		Issuer:   server.URL,
+		ClientID: "client",
+		Scopes:   "openid",
+		Status:   1,
+	})
+	assert.NoError(t, err)
+	err = u.db.Insert(&Model{
+		UID:     "123",
+		Name:    "oidcuser",
+		ShortNo: "123",
+		Status:  1,
+	})
+	assert.NoError(t, err)
+	tx, _ := ctx.DB().Begin()
+	err = u.oidcDB.insertIdentityTx(&userIdentityModel{
+		Provider: "totptest",
+		Subject:  "sub123",
+		UID:      "123",
+	}, tx)
+	assert.NoError(t, err)
+	assert.NoError(t, tx.Commit())
+	// 开启两步验证
+	err = u.totpDB.insertOrUpdatePending("123", "JBSWY3DPEHPK3PXP")
+	assert.NoError(t, err)
+	_, err = ctx.DB().Update("user_totp").Set("status", totpStatusEnabled).Where("uid=?", "123").Exec()
+	assert.NoError(t, err)
+
+	authcode := "authcode123"
+	err = ctx.GetRedisConn().SetAndExpire(fmt.Sprintf("%s%s", ThirdAuthcodePrefix, authcode), "1", time.Minute)
+	assert.NoError(t, err)
+	err = ctx.GetRedisConn().SetAndExpire(fmt.Sprintf("%s%s", oidcStatePrefix, "state123"), util.ToJson(&oidcState{
+		Provider:     "totptest",
+		Authcode:     authcode,
+		Nonce:        "nonce123",
+		CodeVerifier: "verifier123",
+	}), time.Minute)
+	assert.NoError(t, err)
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/v1/user/oauth/oidc/totptest?state=state123&code=code123", nil)
+	s.GetRoute().ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	// 客户端轮询登录状态时拿到两步验证的凭证
+	w = httptest.NewRecorder()
+	req, _ = http.NewRequest("GET", "/v1/user/thirdlogin/authstatus?authcode="+authcode, nil)
+	s.GetRoute().ServeHTTP(w, req)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Equal(t, true, strings.Contains(w.Body.String(), `"status":111`))
+	assert.Equal(t, true, strings.Contains(w.Body.String(), `"uid":"123"`))
+	assert.Equal(t, true, strings.Contains(w.Body.String(), `"ticket":"`))
+}
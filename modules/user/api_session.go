@@ -0,0 +1,460 @@
+package user
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/TangSengDaoDao/TangSengDaoDaoServerLib/common"
+	"github.com/TangSengDaoDao/TangSengDaoDaoServerLib/config"
+	"github.com/TangSengDaoDao/TangSengDaoDaoServerLib/pkg/log"
+	"github.com/TangSengDaoDao/TangSengDaoDaoServerLib/pkg/util"
+	"github.com/TangSengDaoDao/TangSengDaoDaoServerLib/pkg/wkhttp"
+	"go.uber.org/zap"
+)
+
+const (
+	sessionAccessTokenExpire = time.Hour * 2 // access token有效期（refresh token有效期为配置的TokenExpire）
+	sessionUserAgentMaxLen   = 255
+	sessionTouchPrefix       = "sessionTouch:" // 会话最近更新过活跃时间的标记 sessionTouch:{access_token}
+	sessionTouchInterval     = time.Minute * 5 // 会话活跃时间的最小更新间隔
+)
+
+// ErrSessionInvalid 会话已失效，需要重新登录
+var ErrSessionInvalid = errors.New("登录已失效，请重新登录！")
+
+// 登录客户端信息
+type loginClient struct {
+	IP        string
+	UserAgent string
+}
+
+func newLoginClient(c *wkhttp.Context) *loginClient {
+	userAgent := c.Request.UserAgent()
+	if len(userAgent) > sessionUserAgentMaxLen {
+		userAgent = userAgent[:sessionUserAgentMaxLen]
+	}
+	return &loginClient{
+		IP:        util.GetClientPublicIP(c.Request),
+		UserAgent: userAgent,
+	}
+}
+
+// 会话的登录凭证
+type sessionToken struct {
+	SessionID    string
+	AccessToken  string
+	RefreshToken string
+	IMToken      string // 连接IM使用的token（同一个用户同一类设备共用）
+	ExpiresIn    int64  // access token有效期（秒）
+}
+
+// 刷新token
+func (u *User) refreshToken(c *wkhttp.Context) {
+	var req struct {
+		RefreshToken string `json:"refresh_token"`
+	}
+	if err := c.BindJSON(&req); err != nil {
+		c.ResponseError(errors.New("请求数据格式有误！"))
+		return
+	}
+	if req.RefreshToken == "" {
+		c.ResponseError(errors.New("refresh_token不能为空！"))
+		return
+	}
+	token, err := refreshSession(u.ctx, u.sessionDB, u.db, req.RefreshToken, newLoginClient(c))
+	if err != nil {
+		c.ResponseError(err)
+		return
+	}
+	c.Response(newSessionTokenResp(token))
+}
+
+// 我的登录会话
+func (u *User) sessionList(c *wkhttp.Context) {
+	sessions, err := u.sessionDB.queryActiveWithUID(c.GetLoginUID())
+	if err != nil {
+		u.Error("查询登录会话失败！", zap.Error(err))
+		c.ResponseError(errors.New("查询登录会话失败！"))
+		return
+	}
+	currentToken := c.GetHeader("token")
+	resps := make([]*sessionResp, 0, len(sessions))
+	for _, session := range sessions {
+		resps = append(resps, newSessionResp(session, currentToken))
+	}
+	c.Response(resps)
+}
+
+// 撤销某个登录会话
+func (u *User) sessionRevoke(c *wkhttp.Context) {
+	sessionID := c.Param("session_id")
+	session, err := u.sessionDB.queryWithSessionID(sessionID)
+	if err != nil {
+		u.Error("查询登录会话失败！", zap.Error(err))
+		c.ResponseError(errors.New("查询登录会话失败！"))
+		return
+	}
+	if session == nil || session.UID != c.GetLoginUID() {
+		c.ResponseError(errors.New("登录会话不存在！"))
+		return
+	}
+	err = revokeSessions(u.ctx, u.sessionDB, []*sessionModel{session})
+	if err != nil {
+		c.ResponseError(err)
+		return
+	}
+	c.ResponseOK()
+}
+
+// SessionTouchMiddleware 登录用户请求接口时更新会话的最后活跃时间（同一个会话每sessionTouchInterval最多更新一次）
+// 需要放在各模块注册路由的前面
+func SessionTouchMiddleware(ctx *config.Context) wkhttp.HandlerFunc {
+	d := newSessionDB(ctx)
+	return func(c *wkhttp.Context) {
+		c.Next()
+		token := c.GetHeader("token")
+		if token == "" || c.Writer.Status() == http.StatusUnauthorized {
+			return
+		}
+		touchSession(ctx, d, token, time.Now())
+	}
+}
+
+// 更新会话的最后活跃时间
+func touchSession(ctx *config.Context, d *sessionDB, accessToken string, now time.Time) {
+	touchKey := sessionTouchPrefix + accessToken
+	touched, err := ctx.GetRedisConn().GetString(touchKey)
+	if err != nil {
+		log.Warn("查询会话活跃标记失败！", zap.Error(err))
+		return
+	}
+	if touched != "" {
+		return
+	}
+	if err = ctx.GetRedisConn().SetAndExpire(touchKey, "1", sessionTouchInterval); err != nil {
+		log.Warn("设置会话活跃标记失败！", zap.Error(err))
+		return
+	}
+	if err = d.updateLastSeenAt(accessToken, now.Unix()); err != nil {
+		log.Warn("更新会话活跃时间失败！", zap.Error(err))
+	}
+}
+
+// 创建登录会话，app同时只保留一个会话（新登录会撤销旧的app会话）
+func createSession(ctx *config.Context, d *sessionDB, userInfo *Model, flag config.DeviceFlag, device *deviceReq, client *loginClient) (*sessionToken, error) {
+	if flag == config.APP {
+		oldSessions, err := d.queryActiveWithUIDAndFlag(userInfo.UID, flag.Uint8())
+		if err != nil {
+			log.Error("查询旧的登录会话失败！", zap.Error(err))
+			return nil, errors.New("查询旧的登录会话失败！")
+		}
+		for _, oldSession := range oldSessions {
+			if err = revokeSessionToken(ctx, d, oldSession); err != nil {
+				return nil, err
+			}
+		}
+	}
+	accessToken := util.GenerUUID()
+	refreshToken := util.GenerUUID()
+	uidTokenKey := fmt.Sprintf("%s%d%s", ctx.GetConfig().Cache.UIDTokenCachePrefix, flag, userInfo.UID)
+	// 兼容旧客户端，首次登录的IM token和access token相同；web和pc可以同时登录，沿用已有的IM token
+	imToken := accessToken
+	if flag != config.APP {
+		oldIMToken, err := ctx.Cache().Get(uidTokenKey)
+		if err != nil {
+			log.Error("获取旧token错误", zap.Error(err))
+			return nil, errors.New("获取旧token错误")
+		}
+		if oldIMToken != "" {
+			imToken = oldIMToken
+		}
+	}
+	err := ctx.Cache().SetAndExpire(ctx.GetConfig().Cache.TokenCachePrefix+accessToken, wkhttp.EncodeTokenCacheInfo(userInfo.UID, userInfo.Name, userInfo.Role), sessionAccessTokenExpire)
+	if err != nil {
+		log.Error("设置token缓存失败！", zap.Error(err))
+		return nil, errors.New("设置token缓存失败！")
+	}
+	err = ctx.Cache().SetAndExpire(uidTokenKey, imToken, ctx.GetConfig().Cache.TokenExpire)
+	if err != nil {
+		log.Error("设置uidtoken缓存失败！", zap.Error(err))
+		return nil, errors.New("设置uidtoken缓存失败！")
+	}
+	now := time.Now()
+	session := &sessionModel{
+		SessionID:        util.GenerUUID(),
+		UID:              userInfo.UID,
+		DeviceFlag:       flag.Uint8(),
+		AccessToken:      accessToken,
+		RefreshTokenHash: hashRefreshToken(refreshToken),
+		RefreshExpireAt:  now.Add(ctx.GetConfig().Cache.TokenExpire).Unix(),
+		LastSeenAt:       now.Unix(),
+	}
+	if device != nil {
+		session.DeviceID = device.DeviceID
+		session.DeviceName = device.DeviceName
+		session.DeviceModel = device.DeviceModel
+	}
+	if client != nil {
+		session.IP = client.IP
+		session.UserAgent = client.UserAgent
+	}
+	err = d.insert(session)
+	if err != nil {
+		log.Error("添加登录会话失败！", zap.Error(err))
+		return nil, errors.New("添加登录会话失败！")
+	}
+	return &sessionToken{
+		SessionID:    session.SessionID,
+		AccessToken:  accessToken,
+		RefreshToken: refreshToken,
+		IMToken:      imToken,
+		ExpiresIn:    int64(sessionAccessTokenExpire.Seconds()),
+	}, nil
+}
+
+// 通过refresh token换取新的access token，refresh token每次使用后都会更换
+func refreshSession(ctx *config.Context, d *sessionDB, userDB *DB, refreshToken string, client *loginClient) (*sessionToken, error) {
+	refreshTokenHash := hashRefreshToken(refreshToken)
+	session, err := d.queryWithRefreshTokenHash(refreshTokenHash)
+	if err != nil {
+		log.Error("查询登录会话失败！", zap.Error(err))
+		return nil, errors.New("查询登录会话失败！")
+	}
+	now := time.Now()
+	if session == nil || session.Revoked == 1 || session.RefreshExpireAt <= now.Unix() {
+		return nil, ErrSessionInvalid
+	}
+	userInfo, err := userDB.QueryByUID(session.UID)
+	if err != nil {
+		log.Error("查询用户信息失败！", zap.Error(err))
+		return nil, errors.New("查询用户信息失败！")
+	}
+	if userInfo == nil {
+		return nil, ErrSessionInvalid
+	}
+	if userInfo.Status == int(common.UserDisable) {
+		_ = revokeSessions(ctx, d, []*sessionModel{session})
+		return nil, errors.New("该用户已被禁用")
+	}
+	newRefreshToken := util.GenerUUID()
+	newSession := &sessionModel{
+		AccessToken:      util.GenerUUID(),
+		RefreshTokenHash: hashRefreshToken(newRefreshToken),
+		RefreshExpireAt:  now.Add(ctx.GetConfig().Cache.TokenExpire).Unix(),
+		IP:               session.IP,
+		UserAgent:        session.UserAgent,
+		LastSeenAt:       now.Unix(),
+	}
+	if client != nil {
+		newSession.IP = client.IP
+		newSession.UserAgent = client.UserAgent
+	}
+	ok, err := d.rotateToken(session.SessionID, refreshTokenHash, newSession)
+	if err != nil {
+		log.Error("更新登录会话失败！", zap.Error(err))
+		return nil, errors.New("更新登录会话失败！")
+	}
+	if !ok {
+		return nil, ErrSessionInvalid
+	}
+	tokenCachePrefix := ctx.GetConfig().Cache.TokenCachePrefix
+	if session.AccessToken != "" {
+		if err = ctx.Cache().Delete(tokenCachePrefix + session.AccessToken); err != nil {
+			log.Warn("清除旧token数据错误", zap.Error(err))
+		}
+	}
+	err = ctx.Cache().SetAndExpire(tokenCachePrefix+newSession.AccessToken, wkhttp.EncodeTokenCacheInfo(userInfo.UID, userInfo.Name, userInfo.Role), sessionAccessTokenExpire)
+	if err != nil {
+		log.Error("设置token缓存失败！", zap.Error(err))
+		return nil, errors.New("设置token缓存失败！")
+	}
+	imToken, err := sessionIMToken(ctx, session.UID, config.DeviceFlag(session.DeviceFlag))
+	if err != nil {
+		return nil, err
+	}
+	return &sessionToken{
+		SessionID:    session.SessionID,
+		AccessToken:  newSession.AccessToken,
+		RefreshToken: newRefreshToken,
+		IMToken:      imToken,
+		ExpiresIn:    int64(sessionAccessTokenExpire.Seconds()),
+	}, nil
+}
+
+// 获取用户某类设备当前的IM token，不存在（被撤销的会话轮换过）时重新生成
+func sessionIMToken(ctx *config.Context, uid string, flag config.DeviceFlag) (string, error) {
+	uidTokenKey := fmt.Sprintf("%s%d%s", ctx.GetConfig().Cache.UIDTokenCachePrefix, flag, uid)
+	imToken, err := ctx.Cache().Get(uidTokenKey)
+	if err != nil {
+		log.Error("获取IM token错误", zap.Error(err))
+		return "", errors.New("获取IM token错误")
+	}
+	if imToken == "" {
+		imToken = util.GenerUUID()
+		resp, err := ctx.UpdateIMToken(config.UpdateIMTokenReq{
+			UID:         uid,
+			Token:       imToken,
+			DeviceFlag:  flag,
+			DeviceLevel: sessionDeviceLevel(flag),
+		})
+		if err != nil {
+			log.Error("更新IM的token失败！", zap.Error(err))
+			return "", errors.New("更新IM的token失败！")
+		}
+		if resp.Status == config.UpdateTokenStatusBan {
+			return "", errors.New("此账号已经被封禁！")
+		}
+	}
+	err = ctx.Cache().SetAndExpire(uidTokenKey, imToken, ctx.GetConfig().Cache.TokenExpire)
+	if err != nil {
+		log.Error("设置uidtoken缓存失败！", zap.Error(err))
+		return "", errors.New("设置uidtoken缓存失败！")
+	}
+	return imToken, nil
+}
+
+// 撤销会话并让对应设备的IM连接失效
+// IM的token是按设备类型区分的，撤销web或pc会话时同类设备的其他会话也会被踢下线，这些会话刷新token后可以拿到新的IM token重新连接
+func revokeSessions(ctx *config.Context, d *sessionDB, sessions []*sessionModel) error {
+	type uidFlag struct {
+		uid  string
+		flag uint8
+	}
+	revokedFlags := make([]uidFlag, 0, len(sessions))
+	for _, session := range sessions {
+		if err := revokeSessionToken(ctx, d, session); err != nil {
+			return err
+		}
+		exist := false
+		for _, revokedFlag := range revokedFlags {
+			if revokedFlag.uid == session.UID && revokedFlag.flag == session.DeviceFlag {
+				exist = true
+				break
+			}
+		}
+		if !exist {
+			revokedFlags = append(revokedFlags, uidFlag{uid: session.UID, flag: session.DeviceFlag})
+		}
+	}
+	for _, revokedFlag := range revokedFlags {
+		flag := config.DeviceFlag(revokedFlag.flag)
+		// 更换IM token，被撤销的会话无法再用旧token连接IM
+		_, err := ctx.UpdateIMToken(config.UpdateIMTokenReq{
+			UID:         revokedFlag.uid,
+			Token:       util.GenerUUID(),
+			DeviceFlag:  flag,
+			DeviceLevel: sessionDeviceLevel(flag),
+		})
+		if err != nil {
+			log.Error("更新IM的token失败！", zap.Error(err))
+			return errors.New("更新IM的token失败！")
+		}
+		err = ctx.Cache().Delete(fmt.Sprintf("%s%d%s", ctx.GetConfig().Cache.UIDTokenCachePrefix, flag, revokedFlag.uid))
+		if err != nil {
+			log.Error("清除uidtoken缓存失败！", zap.Error(err))
+			return errors.New("清除uidtoken缓存失败！")
+		}
+		err = ctx.QuitUserDevice(revokedFlag.uid, int(flag))
+		if err != nil {
+			log.Error("退出登录设备失败！", zap.Error(err))
+			return errors.New("退出登录设备失败！")
+		}
+	}
+	return nil
+}
+
+// 撤销会话并清除access token（不处理IM的token）
+func revokeSessionToken(ctx *config.Context, d *sessionDB, session *sessionModel) error {
+	_, err := d.revoke(session.SessionID)
+	if err != nil {
+		log.Error("撤销登录会话失败！", zap.Error(err))
+		return errors.New("撤销登录会话失败！")
+	}
+	if session.AccessToken != "" {
+		err = ctx.Cache().Delete(ctx.GetConfig().Cache.TokenCachePrefix + session.AccessToken)
+		if err != nil {
+			log.Error("清除token数据错误", zap.Error(err))
+			return errors.New("清除token数据错误")
+		}
+	}
+	return nil
+}
+
+// 撤销用户的全部会话
+func revokeUserSessions(ctx *config.Context, d *sessionDB, uid string) (int, error) {
+	sessions, err := d.queryActiveWithUID(uid)
+	if err != nil {
+		log.Error("查询登录会话失败！", zap.Error(err))
+		return 0, errors.New("查询登录会话失败！")
+	}
+	if len(sessions) == 0 {
+		return 0, nil
+	}
+	return len(sessions), revokeSessions(ctx, d, sessions)
+}
+
+func sessionDeviceLevel(flag config.DeviceFlag) config.DeviceLevel {
+	if flag == config.APP {
+		return config.DeviceLevelMaster
+	}
+	return config.DeviceLevelSlave
+}
+
+func hashRefreshToken(refreshToken string) string {
+	sum := sha256.Sum256([]byte(refreshToken))
+	return hex.EncodeToString(sum[:])
+}
+
+type sessionTokenResp struct {
+	SessionID    string `json:"session_id"`
+	Token        string `json:"token"`
+	RefreshToken string `json:"refresh_token"`
+	IMToken      string `json:"im_token"`
+	ExpiresIn    int64  `json:"expires_in"`
+}
+
+func newSessionTokenResp(t *sessionToken) *sessionTokenResp {
+	return &sessionTokenResp{
+		SessionID:    t.SessionID,
+		Token:        t.AccessToken,
+		RefreshToken: t.RefreshToken,
+		IMToken:      t.IMToken,
+		ExpiresIn:    t.ExpiresIn,
+	}
+}
+
+type sessionResp struct {
+	SessionID   string `json:"session_id"`
+	DeviceFlag  uint8  `json:"device_flag"`
+	DeviceID    string `json:"device_id"`
+	DeviceName  string `json:"device_name"`
+	DeviceModel string `json:"device_model"`
+	IP          string `json:"ip"`
+	UserAgent   string `json:"user_agent"`
+	LastSeenAt  int64  `json:"last_seen_at"`
+	CreatedAt   string `json:"created_at"`
+	Current     int    `json:"current"` // 是否是当前会话 1.是
+}
+
+func newSessionResp(m *sessionModel, currentToken string) *sessionResp {
+	current := 0
+	if currentToken != "" && m.AccessToken == currentToken {
+		current = 1
+	}
+	return &sessionResp{
+		SessionID:   m.SessionID,
+		DeviceFlag:  m.DeviceFlag,
+		DeviceID:    m.DeviceID,
+		DeviceName:  m.DeviceName,
+		DeviceModel: m.DeviceModel,
+		IP:          m.IP,
+		UserAgent:   m.UserAgent,
+		LastSeenAt:  m.LastSeenAt,
+		CreatedAt:   m.CreatedAt.String(),
+		Current:     current,
+	}
+}
@@ -0,0 +1,406 @@
+package user
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/TangSengDaoDao/TangSengDaoDaoServerLib/config"
+	"github.com/TangSengDaoDao/TangSengDaoDaoServerLib/pkg/wkhttp"
+	"go.uber.org/zap"
+)
+
+const (
+	// CMDSignalPrekeyLow 一次性公钥不足，客户端需要补充（发给公钥所属用户）
+	CMDSignalPrekeyLow = "signalPrekeyLow"
+	// CMDSignalIdentityChanged 用户的身份公钥发生变化（发给该用户的好友）
+	CMDSignalIdentityChanged = "signalIdentityChanged"
+)
+
+const (
+	signalOnetimePrekeyMaxUpload = 100 // 单次最多上传的一次性公钥数量
+	signalOnetimePrekeyLowCount  = 10  // 一次性公钥少于这个数量时通知用户补充
+
+	signalPrekeyLowNotifyPrefix = "signalPrekeyLow:" // 公钥不足通知的频率限制
+	signalPrekeyLowNotifyExpire = time.Hour
+
+	signalPrekeyBundlePrefix = "signalPrekeyBundle:" // 获取公钥包的频率限制 signalPrekeyBundle:{login_uid}@{uid}
+	signalPrekeyBundleExpire = time.Second * 10      // 同一用户获取同一个人的公钥包的最小间隔
+)
+
+// 上传身份公钥和签名key，身份公钥变化时清空旧的一次性公钥并通知好友
+func (u *User) signalKeysUpload(c *wkhttp.Context) {
+	var req signalKeysReq
+	if err := c.BindJSON(&req); err != nil {
+		c.ResponseError(errors.New("请求数据格式有误！"))
+		return
+	}
+	if err := req.check(); err != nil {
+		c.ResponseError(err)
+		return
+	}
+	loginUID := c.GetLoginUID()
+	oldIdentity, err := u.identitieDB.queryWithUID(loginUID)
+	if err != nil {
+		u.Error("查询用户身份公钥失败！", zap.Error(err))
+		c.ResponseError(errors.New("查询用户身份公钥失败！"))
+		return
+	}
+	identityChanged := oldIdentity != nil && oldIdentity.IdentityKey != req.IdentityKey
+
+	tx, err := u.ctx.DB().Begin()
+	if err != nil {
+		u.Error("开启事务失败！", zap.Error(err))
+		c.ResponseError(errors.New("开启事务失败！"))
+		return
+	}
+	defer func() {
+		if err := recover(); err != nil {
+			tx.Rollback()
+			panic(err)
+		}
+	}()
+	err = u.identitieDB.insertOrUpdateTx(&identitiesModel{
+		UID:             loginUID,
+		RegistrationID:  req.RegistrationID,
+		IdentityKey:     req.IdentityKey,
+		SignedPrekeyID:  req.SignedPrekeyID,
+		SignedPubkey:    req.SignedPubkey,
+		SignedSignature: req.SignedSignature,
+	}, tx)
+	if err != nil {
+		tx.Rollback()
+		u.Error("保存用户身份公钥失败！", zap.Error(err))
+		c.ResponseError(errors.New("保存用户身份公钥失败！"))
+		return
+	}
+	if identityChanged {
+		// 旧身份下的一次性公钥已无法使用
+		err = u.onetimePrekeysDB.deleteWithUIDTx(loginUID, tx)
+		if err != nil {
+			tx.Rollback()
+			u.Error("删除旧的一次性公钥失败！", zap.Error(err))
+			c.ResponseError(errors.New("删除旧的一次性公钥失败！"))
+			return
+		}
+	}
+	for _, prekey := range req.OnetimePrekeys {
+		err = u.onetimePrekeysDB.insertIgnoreTx(loginUID, prekey.KeyID, prekey.Pubkey, tx)
+		if err != nil {
+			tx.Rollback()
+			u.Error("保存一次性公钥失败！", zap.Error(err))
+			c.ResponseError(errors.New("保存一次性公钥失败！"))
+			return
+		}
+	}
+	if err = tx.Commit(); err != nil {
+		tx.Rollback()
+		u.Error("提交事务失败！", zap.Error(err))
+		c.ResponseError(errors.New("提交事务失败！"))
+		return
+	}
+	if identityChanged {
+		u.sendSignalIdentityChangedCMD(loginUID)
+	}
+	c.ResponseOK()
+}
+
+// 更换签名key
+func (u *User) signalSignedPrekeyUpdate(c *wkhttp.Context) {
+	var req struct {
+		SignedPrekeyID  int    `json:"signed_prekey_id"`
+		SignedPubkey    string `json:"signed_pubkey"`
+		SignedSignature string `json:"signed_signature"`
+	}
+	if err := c.BindJSON(&req); err != nil {
+		c.ResponseError(errors.New("请求数据格式有误！"))
+		return
+	}
+	if !isBase64Key(req.SignedPubkey) || !isBase64Key(req.SignedSignature) {
+		c.ResponseError(errors.New("签名key格式有误！"))
+		return
+	}
+	ok, err := u.identitieDB.updateSignedPrekey(c.GetLoginUID(), req.SignedPrekeyID, req.SignedPubkey, req.SignedSignature)
+	if err != nil {
+		u.Error("更新签名key失败！", zap.Error(err))
+		c.ResponseError(errors.New("更新签名key失败！"))
+		return
+	}
+	if !ok {
+		c.ResponseError(errors.New("请先上传身份公钥！"))
+		return
+	}
+	c.ResponseOK()
+}
+
+// 补充一次性公钥
+func (u *User) signalOnetimePrekeysRefill(c *wkhttp.Context) {
+	var req struct {
+		OnetimePrekeys []*signalOnetimePrekeyReq `json:"onetime_prekeys"`
+	}
+	if err := c.BindJSON(&req); err != nil {
+		c.ResponseError(errors.New("请求数据格式有误！"))
+		return
+	}
+	if len(req.OnetimePrekeys) == 0 {
+		c.ResponseError(errors.New("一次性公钥不能为空！"))
+		return
+	}
+	if err := checkSignalOnetimePrekeys(req.OnetimePrekeys); err != nil {
+		c.ResponseError(err)
+		return
+	}
+	loginUID := c.GetLoginUID()
+	identity, err := u.identitieDB.queryWithUID(loginUID)
+	if err != nil {
+		u.Error("查询用户身份公钥失败！", zap.Error(err))
+		c.ResponseError(errors.New("查询用户身份公钥失败！"))
+		return
+	}
+	if identity == nil {
+		c.ResponseError(errors.New("请先上传身份公钥！"))
+		return
+	}
+	tx, err := u.ctx.DB().Begin()
+	if err != nil {
+		u.Error("开启事务失败！", zap.Error(err))
+		c.ResponseError(errors.New("开启事务失败！"))
+		return
+	}
+	defer func() {
+		if err := recover(); err != nil {
+			tx.Rollback()
+			panic(err)
+		}
+	}()
+	for _, prekey := range req.OnetimePrekeys {
+		err = u.onetimePrekeysDB.insertIgnoreTx(loginUID, prekey.KeyID, prekey.Pubkey, tx)
+		if err != nil {
+			tx.Rollback()
+			u.Error("保存一次性公钥失败！", zap.Error(err))
+			c.ResponseError(errors.New("保存一次性公钥失败！"))
+			return
+		}
+	}
+	if err = tx.Commit(); err != nil {
+		tx.Rollback()
+		u.Error("提交事务失败！", zap.Error(err))
+		c.ResponseError(errors.New("提交事务失败！"))
+		return
+	}
+	count, err := u.onetimePrekeysDB.queryCount(loginUID)
+	if err != nil {
+		u.Error("查询一次性公钥数量失败！", zap.Error(err))
+		c.ResponseError(errors.New("查询一次性公钥数量失败！"))
+		return
+	}
+	c.Response(map[string]interface{}{
+		"count": count,
+	})
+}
+
+// 我剩余的一次性公钥数量
+func (u *User) signalOnetimePrekeyCount(c *wkhttp.Context) {
+	count, err := u.onetimePrekeysDB.queryCount(c.GetLoginUID())
+	if err != nil {
+		u.Error("查询一次性公钥数量失败！", zap.Error(err))
+		c.ResponseError(errors.New("查询一次性公钥数量失败！"))
+		return
+	}
+	c.Response(map[string]interface{}{
+		"count": count,
+	})
+}
+
+// 获取某个用户的公钥包（每次获取会消耗对方一个一次性公钥）
+func (u *User) signalPrekeyBundle(c *wkhttp.Context) {
+	uid := c.Param("uid")
+	loginUID := c.GetLoginUID()
+	if uid != loginUID {
+		blacklist, err := u.friendDB.existBlacklist(loginUID, uid)
+		if err != nil {
+			u.Error("查询黑名单失败！", zap.Error(err))
+			c.ResponseError(errors.New("查询黑名单失败！"))
+			return
+		}
+		if blacklist {
+			c.ResponseError(errors.New("对方不允许获取公钥！"))
+			return
+		}
+	}
+	// 每次获取都会消耗对方的一次性公钥，限制获取频率防止被恶意耗尽
+	rateLimitKey := fmt.Sprintf("%s%s@%s", signalPrekeyBundlePrefix, loginUID, uid)
+	limited, err := u.ctx.GetRedisConn().GetString(rateLimitKey)
+	if err != nil {
+		u.Error("获取公钥包频率限制失败！", zap.Error(err))
+		c.ResponseError(errors.New("获取公钥包频率限制失败！"))
+		return
+	}
+	if limited != "" {
+		c.ResponseError(errors.New("获取公钥过于频繁，请稍后再试！"))
+		return
+	}
+	err = u.ctx.GetRedisConn().SetAndExpire(rateLimitKey, "1", signalPrekeyBundleExpire)
+	if err != nil {
+		u.Error("设置公钥包频率限制失败！", zap.Error(err))
+		c.ResponseError(errors.New("设置公钥包频率限制失败！"))
+		return
+	}
+	identity, err := u.identitieDB.queryWithUID(uid)
+	if err != nil {
+		u.Error("查询用户身份公钥失败！", zap.Error(err))
+		c.ResponseError(errors.New("查询用户身份公钥失败！"))
+		return
+	}
+	if identity == nil {
+		c.ResponseError(errors.New("对方未开启加密通讯！"))
+		return
+	}
+	prekey, err := u.onetimePrekeysDB.consumeMinWithUID(uid)
+	if err != nil {
+		u.Error("获取一次性公钥失败！", zap.Error(err))
+		c.ResponseError(errors.New("获取一次性公钥失败！"))
+		return
+	}
+	resp := &signalPrekeyBundleResp{
+		UID:             uid,
+		RegistrationID:  identity.RegistrationID,
+		IdentityKey:     identity.IdentityKey,
+		SignedPrekeyID:  identity.SignedPrekeyID,
+		SignedPubkey:    identity.SignedPubkey,
+		SignedSignature: identity.SignedSignature,
+	}
+	if prekey != nil {
+		resp.OnetimePrekey = &signalOnetimePrekeyResp{
+			KeyID:  prekey.KeyID,
+			Pubkey: prekey.Pubkey,
+		}
+	}
+	c.Response(resp)
+
+	u.checkSignalPrekeyLow(uid)
+}
+
+// 一次性公钥不足时通知用户补充（一小时内只通知一次）
+func (u *User) checkSignalPrekeyLow(uid string) {
+	count, err := u.onetimePrekeysDB.queryCount(uid)
+	if err != nil {
+		u.Warn("查询一次性公钥数量失败！", zap.Error(err))
+		return
+	}
+	if count >= signalOnetimePrekeyLowCount {
+		return
+	}
+	key := fmt.Sprintf("%s%s", signalPrekeyLowNotifyPrefix, uid)
+	notified, err := u.ctx.GetRedisConn().GetString(key)
+	if err != nil {
+		u.Warn("获取公钥不足通知记录失败！", zap.Error(err))
+		return
+	}
+	if notified != "" {
+		return
+	}
+	err = u.ctx.GetRedisConn().SetAndExpire(key, "1", signalPrekeyLowNotifyExpire)
+	if err != nil {
+		u.Warn("设置公钥不足通知记录失败！", zap.Error(err))
+		return
+	}
+	err = u.ctx.SendCMD(config.MsgCMDReq{
+		NoPersist:   true,
+		CMD:         CMDSignalPrekeyLow,
+		Subscribers: []string{uid},
+		Param: map[string]interface{}{
+			"count": count,
+		},
+	})
+	if err != nil {
+		u.Warn("发送公钥不足命令失败！", zap.Error(err))
+	}
+}
+
+// 通知好友身份公钥已变化（好友需要重新建立会话并提示安全码变化）
+func (u *User) sendSignalIdentityChangedCMD(uid string) {
+	friends, err := u.friendDB.QueryFriends(uid)
+	if err != nil {
+		u.Error("查询用户好友失败！", zap.Error(err))
+		return
+	}
+	if len(friends) == 0 {
+		return
+	}
+	uids := make([]string, 0, len(friends))
+	for _, friend := range friends {
+		uids = append(uids, friend.ToUID)
+	}
+	err = u.ctx.SendCMD(config.MsgCMDReq{
+		CMD:         CMDSignalIdentityChanged,
+		Subscribers: uids,
+		Param: map[string]interface{}{
+			"uid": uid,
+		},
+	})
+	if err != nil {
+		u.Error("发送身份公钥变化命令失败！", zap.Error(err))
+	}
+}
+
+func isBase64Key(key string) bool {
+	if strings.TrimSpace(key) == "" {
+		return false
+	}
+	_, err := base64.StdEncoding.DecodeString(key)
+	return err == nil
+}
+
+func checkSignalOnetimePrekeys(prekeys []*signalOnetimePrekeyReq) error {
+	if len(prekeys) > signalOnetimePrekeyMaxUpload {
+		return fmt.Errorf("一次最多上传%d个一次性公钥！", signalOnetimePrekeyMaxUpload)
+	}
+	for _, prekey := range prekeys {
+		if prekey == nil || !isBase64Key(prekey.Pubkey) {
+			return errors.New("一次性公钥格式有误！")
+		}
+	}
+	return nil
+}
+
+type signalOnetimePrekeyReq struct {
+	KeyID  int    `json:"key_id"`
+	Pubkey string `json:"pubkey"`
+}
+
+type signalKeysReq struct {
+	RegistrationID  uint32                    `json:"registration_id"`
+	IdentityKey     string                    `json:"identity_key"`
+	SignedPrekeyID  int                       `json:"signed_prekey_id"`
+	SignedPubkey    string                    `json:"signed_pubkey"`
+	SignedSignature string                    `json:"signed_signature"`
+	OnetimePrekeys  []*signalOnetimePrekeyReq `json:"onetime_prekeys"`
+}
+
+func (r signalKeysReq) check() error {
+	if !isBase64Key(r.IdentityKey) {
+		return errors.New("身份公钥格式有误！")
+	}
+	if !isBase64Key(r.SignedPubkey) || !isBase64Key(r.SignedSignature) {
+		return errors.New("签名key格式有误！")
+	}
+	return checkSignalOnetimePrekeys(r.OnetimePrekeys)
+}
+
+type signalOnetimePrekeyResp struct {
+	KeyID  int    `json:"key_id"`
+	Pubkey string `json:"pubkey"`
+}
+
+type signalPrekeyBundleResp struct {
+	UID             string                   `json:"uid"`
+	RegistrationID  uint32                   `json:"registration_id"`
+	IdentityKey     string                   `json:"identity_key"`
+	SignedPrekeyID  int                      `json:"signed_prekey_id"`
+	SignedPubkey    string                   `json:"signed_pubkey"`
+	SignedSignature string                   `json:"signed_signature"`
+	OnetimePrekey   *signalOnetimePrekeyResp `json:"onetime_prekey,omitempty"` // 一次性公钥已用完时为空
+}
@@ -13,6 +13,7 @@ import (
 	"time"
 
 	"github.com/TangSengDaoDao/TangSengDaoDaoServerLib/pkg/util"
+	"github.com/TangSengDaoDao/TangSengDaoDaoServerLib/pkg/wkhttp"
 	"github.com/TangSengDaoDao/TangSengDaoDaoServerLib/testutil"
 	"github.com/stretchr/testify/assert"
 )
@@ -109,6 +110,228 @@ func TestUsernameLogin(t *testing.T) {
 	s.GetRoute().ServeHTTP(w, req)
 	assert.Equal(t, true, strings.Contains(w.Body.String(), `"username":userone123123`))
 }
+func TestUsernameLoginRehashPassword(t *testing.T) {
+	s, ctx := testutil.NewTestServer()
+	u := New(ctx)
+	err := testutil.CleanAllTables(ctx)
+	assert.NoError(t, err)
+	username := "userone123123"
+	password := "123123"
+	err = u.db.Insert(&Model{
+		UID:          "123",
+		Username:     username,
+		Password:     util.MD5(util.MD5(password)),
+		PasswordAlgo: "md5",
+		Name:         username,
+		ShortNo:      "123",
+		Status:       1,
+	})
+	assert.NoError(t, err)
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("POST", "/v1/user/usernamelogin", bytes.NewReader([]byte(util.ToJson(map[string]interface{}{
+		"username": username,
+		"password": password,
+		"device": map[string]interface{}{
+			"device_id":    "device_id3",
+			"device_name":  "device_name1",
+			"device_model": "device_model1",
+		},
+	}))))
+	s.GetRoute().ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	userInfo, err := u.db.QueryByUID("123")
+	assert.NoError(t, err)
+	assert.Equal(t, "argon2id", userInfo.PasswordAlgo)
+	assert.True(t, strings.HasPrefix(userInfo.Password, "$argon2id$"))
+
+	// 强制重置后不能再用密码登录
+	err = u.db.UpdateUsersWithField("password_reset", "1", "123")
+	assert.NoError(t, err)
+	w = httptest.NewRecorder()
+	req, _ = http.NewRequest("POST", "/v1/user/usernamelogin", bytes.NewReader([]byte(util.ToJson(map[string]interface{}{
+		"username": username,
+		"password": password,
+		"device": map[string]interface{}{
+			"device_id":    "device_id3",
+			"device_name":  "device_name1",
+			"device_model": "device_model1",
+		},
+	}))))
+	s.GetRoute().ServeHTTP(w, req)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+
+	// 已被要求重置密码的旧密码用户登录时不升级哈希
+	err = u.db.Insert(&Model{
+		UID:           "124",
+		Username:      "usertwo123123",
+		Password:      util.MD5(util.MD5(password)),
+		PasswordAlgo:  "md5",
+		PasswordReset: 1,
+		Name:          "usertwo123123",
+		ShortNo:       "124",
+		Status:        1,
+	})
+	assert.NoError(t, err)
+	w = httptest.NewRecorder()
+	req, _ = http.NewRequest("POST", "/v1/user/usernamelogin", bytes.NewReader([]byte(util.ToJson(map[string]interface{}{
+		"username": "usertwo123123",
+		"password": password,
+		"device": map[string]interface{}{
+			"device_id":    "device_id3",
+			"device_name":  "device_name1",
+			"device_model": "device_model1",
+		},
+	}))))
+	s.GetRoute().ServeHTTP(w, req)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	userInfo, err = u.db.QueryByUID("124")
+	assert.NoError(t, err)
+	assert.Equal(t, "md5", userInfo.PasswordAlgo)
+}
+
+func TestRefreshToken(t *testing.T) {
+	s, ctx := testutil.NewTestServer()
+	u := New(ctx)
+	err := testutil.CleanAllTables(ctx)
+	assert.NoError(t, err)
+	userModel := &Model{
+		UID:      "123",
+		Username: "usersession",
+		Name:     "usersession",
+		ShortNo:  "123",
+		Status:   1,
+	}
+	err = setModelPassword(userModel, "123456")
+	assert.NoError(t, err)
+	err = u.db.Insert(userModel)
+	assert.NoError(t, err)
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("POST", "/v1/user/usernamelogin", bytes.NewReader([]byte(util.ToJson(map[string]interface{}{
+		"username": "usersession",
+		"password": "123456",
+		"flag":     1,
+	}))))
+	s.GetRoute().ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+	var loginResult struct {
+		Data *loginUserDetailResp `json:"data"`
+	}
+	err = util.ReadJsonByByte(w.Body.Bytes(), &loginResult)
+	assert.NoError(t, err)
+	assert.NotEmpty(t, loginResult.Data.RefreshToken)
+	assert.NotEmpty(t, loginResult.Data.SessionID)
+
+	// 刷新后返回新的token
+	w = httptest.NewRecorder()
+	req, _ = http.NewRequest("POST", "/v1/user/token/refresh", bytes.NewReader([]byte(util.ToJson(map[string]interface{}{
+		"refresh_token": loginResult.Data.RefreshToken,
+	}))))
+	s.GetRoute().ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+	var refreshResult sessionTokenResp
+	err = util.ReadJsonByByte(w.Body.Bytes(), &refreshResult)
+	assert.NoError(t, err)
+	assert.NotEqual(t, loginResult.Data.Token, refreshResult.Token)
+	assert.NotEqual(t, loginResult.Data.RefreshToken, refreshResult.RefreshToken)
+
+	// 旧的refresh token不能再次使用
+	w = httptest.NewRecorder()
+	req, _ = http.NewRequest("POST", "/v1/user/token/refresh", bytes.NewReader([]byte(util.ToJson(map[string]interface{}{
+		"refresh_token": loginResult.Data.RefreshToken,
+	}))))
+	s.GetRoute().ServeHTTP(w, req)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+
+	// 撤销会话后不能再刷新
+	w = httptest.NewRecorder()
+	req, _ = http.NewRequest("DELETE", fmt.Sprintf("/v1/user/sessions/%s", refreshResult.SessionID), nil)
+	req.Header.Set("token", refreshResult.Token)
+	s.GetRoute().ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	w = httptest.NewRecorder()
+	req, _ = http.NewRequest("POST", "/v1/user/token/refresh", bytes.NewReader([]byte(util.ToJson(map[string]interface{}{
+		"refresh_token": refreshResult.RefreshToken,
+	}))))
+	s.GetRoute().ServeHTTP(w, req)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+}
+
+func TestTouchSession(t *testing.T) {
+	_, ctx := testutil.NewTestServer()
+	u := New(ctx)
+	err := testutil.CleanAllTables(ctx)
+	assert.NoError(t, err)
+	err = u.sessionDB.insert(&sessionModel{
+		SessionID:        "s1",
+		UID:              "123",
+		AccessToken:      "touchtoken",
+		RefreshTokenHash: hashRefreshToken("touchrefresh"),
+		RefreshExpireAt:  time.Now().Add(time.Hour).Unix(),
+		LastSeenAt:       100,
+	})
+	assert.NoError(t, err)
+	_ = ctx.GetRedisConn().Del(sessionTouchPrefix + "touchtoken")
+
+	touchSession(ctx, u.sessionDB, "touchtoken", time.Unix(200, 0))
+	session, err := u.sessionDB.queryWithSessionID("s1")
+	assert.NoError(t, err)
+	assert.Equal(t, int64(200), session.LastSeenAt)
+
+	// 更新间隔内不重复更新
+	touchSession(ctx, u.sessionDB, "touchtoken", time.Unix(300, 0))
+	session, err = u.sessionDB.queryWithSessionID("s1")
+	assert.NoError(t, err)
+	assert.Equal(t, int64(200), session.LastSeenAt)
+}
+
+func TestSignalPrekeyBundle(t *testing.T) {
+	s, ctx := testutil.NewTestServer()
+	u := New(ctx)
+	err := testutil.CleanAllTables(ctx)
+	assert.NoError(t, err)
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("POST", "/v1/user/signal/keys", bytes.NewReader([]byte(util.ToJson(map[string]interface{}{
+		"registration_id":  1234,
+		"identity_key":     "aWRlbnRpdHk=",
+		"signed_prekey_id": 1,
+		"signed_pubkey":    "c2lnbmVk",
+		"signed_signature": "c2lnbmF0dXJl",
+		"onetime_prekeys": []map[string]interface{}{
+			{"key_id": 1, "pubkey": "a2V5MQ=="},
+			{"key_id": 2, "pubkey": "a2V5Mg=="},
+		},
+	}))))
+	req.Header.Set("token", testutil.Token)
+	s.GetRoute().ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	// 每次获取消耗一个一次性公钥
+	for _, expect := range []string{`"key_id":1`, `"key_id":2`} {
+		w = httptest.NewRecorder()
+		req, _ = http.NewRequest("GET", fmt.Sprintf("/v1/users/%s/signal/bundle", testutil.UID), nil)
+		req.Header.Set("token", testutil.Token)
+		s.GetRoute().ServeHTTP(w, req)
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Contains(t, w.Body.String(), expect)
+	}
+	count, err := u.onetimePrekeysDB.queryCount(testutil.UID)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, count)
+
+	// 一次性公钥用完后只返回签名key
+	w = httptest.NewRecorder()
+	req, _ = http.NewRequest("GET", fmt.Sprintf("/v1/users/%s/signal/bundle", testutil.UID), nil)
+	req.Header.Set("token", testutil.Token)
+	s.GetRoute().ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.NotContains(t, w.Body.String(), `"onetime_prekey"`)
+	assert.Contains(t, w.Body.String(), `"signed_pubkey":"c2lnbmVk"`)
+}
+
 func TestUploadWeb3PublicKey(t *testing.T) {
 	s, ctx := testutil.NewTestServer()
 	u := New(ctx)
@@ -254,6 +477,39 @@ func TestUser_Login(t *testing.T) {
 	time.Sleep(2 * time.Second)
 }
 
+func TestUser_LoginManagerNeedTOTPEnroll(t *testing.T) {
+	s, ctx := testutil.NewTestServer()
+	u := New(ctx)
+	err := testutil.CleanAllTables(ctx)
+	assert.NoError(t, err)
+
+	err = u.db.Insert(&Model{
+		UID:      testutil.UID,
+		Name:     "admin",
+		Username: "admin",
+		Password: util.MD5(util.MD5("123456")),
+		ShortNo:  "uid_xxx1",
+		Role:     string(wkhttp.Admin),
+		Status:   1,
+	})
+	assert.NoError(t, err)
+	// 后台要求管理账号开启两步验证
+	_, err = ctx.DB().InsertInto("app_config").Pair("manager_totp_on", 1).Exec()
+	assert.NoError(t, err)
+
+	// 未开启两步验证的管理账号不能通过app登录拿到token
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("POST", "/v1/user/login", bytes.NewReader([]byte(util.ToJson(map[string]interface{}{
+		"username": "admin",
+		"password": "123456",
+		"flag":     1,
+	}))))
+	s.GetRoute().ServeHTTP(w, req)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Equal(t, false, strings.Contains(w.Body.String(), `"token":`))
+	assert.Equal(t, true, strings.Contains(w.Body.String(), ErrUserNeedTOTPEnroll.Error()))
+}
+
 func TestUser_Search(t *testing.T) {
 	s, ctx := testutil.NewTestServer()
 
@@ -676,3 +932,121 @@ func TestDeleteUserRedDot(t *testing.T) {
 	s.GetRoute().ServeHTTP(w, req)
 	assert.Equal(t, http.StatusOK, w.Code)
 }
+
+func TestEmailRegister(t *testing.T) {
+	s, ctx := testutil.NewTestServer()
+	u := New(ctx)
+	err := testutil.CleanAllTables(ctx)
+	assert.NoError(t, err)
+	ctx.GetConfig().SMSCode = "123456"
+
+	register := func(email string, code string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("POST", "/v1/user/email/register", bytes.NewReader([]byte(util.ToJson(map[string]interface{}{
+			"name":     "emailuser",
+			"email":    email,
+			"code":     code,
+			"password": "1234567",
+		}))))
+		s.GetRoute().ServeHTTP(w, req)
+		return w
+	}
+	// 验证码错误
+	w := register("Email@Example.com", "111111")
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+
+	// 邮箱统一转为小写，注册后邮箱为已验证状态
+	w = register("Email@Example.com", "123456")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), `"token":`)
+	userInfo, err := u.db.QueryByEmail("email@example.com")
+	assert.NoError(t, err)
+	assert.NotNil(t, userInfo)
+	assert.Equal(t, "email@example.com", userInfo.Username)
+
+	// 同一个邮箱不能重复注册
+	w = register("email@example.com", "123456")
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Contains(t, w.Body.String(), "该邮箱已被注册")
+}
+
+func TestEmailPwdForget(t *testing.T) {
+	s, ctx := testutil.NewTestServer()
+	u := New(ctx)
+	err := testutil.CleanAllTables(ctx)
+	assert.NoError(t, err)
+	ctx.GetConfig().SMSCode = "123456"
+	err = u.db.Insert(&Model{
+		UID:           "123",
+		Username:      "emailuser",
+		Name:          "emailuser",
+		ShortNo:       "123",
+		Password:      util.MD5(util.MD5("123456")),
+		Email:         "email@example.com",
+		EmailVerified: 1,
+	})
+	assert.NoError(t, err)
+
+	pwdForget := func(email string, code string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("POST", "/v1/user/email/pwdforget", bytes.NewReader([]byte(util.ToJson(map[string]interface{}{
+			"email": email,
+			"code":  code,
+			"pwd":   "new_pwd_123",
+		}))))
+		s.GetRoute().ServeHTTP(w, req)
+		return w
+	}
+	// 未注册的邮箱
+	w := pwdForget("other@example.com", "123456")
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	// 验证码错误
+	w = pwdForget("email@example.com", "111111")
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+
+	w = pwdForget("Email@example.com", "123456")
+	assert.Equal(t, http.StatusOK, w.Code)
+	userInfo, err := u.db.QueryByUID("123")
+	assert.NoError(t, err)
+	assert.NotEqual(t, util.MD5(util.MD5("123456")), userInfo.Password)
+}
+
+func TestEmailDestroyAccount(t *testing.T) {
+	s, ctx := testutil.NewTestServer()
+	u := New(ctx)
+	err := testutil.CleanAllTables(ctx)
+	assert.NoError(t, err)
+	ctx.GetConfig().SMSCode = "123456"
+	err = u.db.Insert(&Model{
+		UID:      testutil.UID,
+		Username: "emailuser",
+		Name:     "emailuser",
+		ShortNo:  "123",
+		Email:    "email@example.com",
+	})
+	assert.NoError(t, err)
+
+	// 没有手机号且邮箱未验证时不能注销
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("DELETE", "/v1/user/destroy/123456", nil)
+	req.Header.Set("token", testutil.Token)
+	s.GetRoute().ServeHTTP(w, req)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Contains(t, w.Body.String(), "未绑定邮箱")
+
+	err = u.db.updateVerifiedEmail(testutil.UID, "email@example.com")
+	assert.NoError(t, err)
+	w = httptest.NewRecorder()
+	req, _ = http.NewRequest("DELETE", "/v1/user/destroy/123456", nil)
+	req.Header.Set("token", testutil.Token)
+	s.GetRoute().ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	// 注销后邮箱可以重新注册
+	userInfo, err := u.db.QueryByUID(testutil.UID)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, userInfo.IsDestroy)
+	used, err := u.emailIsUsed("email@example.com", "")
+	assert.NoError(t, err)
+	assert.False(t, used)
+}
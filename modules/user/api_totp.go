@@ -0,0 +1,491 @@
+package user
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/TangSengDaoDao/TangSengDaoDaoServer/pkg/totp"
+	"github.com/TangSengDaoDao/TangSengDaoDaoServerLib/config"
+	"github.com/TangSengDaoDao/TangSengDaoDaoServerLib/pkg/log"
+	"github.com/TangSengDaoDao/TangSengDaoDaoServerLib/pkg/util"
+	"github.com/TangSengDaoDao/TangSengDaoDaoServerLib/pkg/wkhttp"
+	"github.com/opentracing/opentracing-go"
+	"go.uber.org/zap"
+)
+
+const (
+	totpStatusPending = 0 // 绑定中
+	totpStatusEnabled = 1 // 已开启
+
+	totpRecoveryCodeCount = 10 // 恢复码数量
+
+	totpLoginTicketPrefix = "totpLogin:"    // 两步验证登录凭证
+	totpLoginTicketExpire = time.Minute * 5 // 登录凭证有效期
+	totpLoginMaxFail      = 5               // 同一个登录凭证最多验证失败次数
+)
+
+var (
+	// ErrUserNeedTOTP 用户开启了两步验证，需要输入验证码
+	ErrUserNeedTOTP = errors.New("需要两步验证！")
+	// ErrUserNeedTOTPEnroll 后台要求开启两步验证，需要先绑定
+	ErrUserNeedTOTPEnroll = errors.New("需要先开启两步验证！")
+)
+
+// 密码校验通过后等待两步验证的登录
+type totpLoginTicket struct {
+	UID     string     `json:"uid"`
+	Flag    uint8      `json:"flag"`
+	Device  *deviceReq `json:"device,omitempty"`
+	Manager int        `json:"manager,omitempty"` // 是否是后台登录 1.是
+	Fail    int        `json:"fail"`              // 验证失败次数
+}
+
+// 两步验证状态
+func (u *User) totpStatus(c *wkhttp.Context) {
+	loginUID := c.GetLoginUID()
+	model, err := u.totpDB.queryWithUID(loginUID)
+	if err != nil {
+		u.Error("查询两步验证失败！", zap.Error(err))
+		c.ResponseError(errors.New("查询两步验证失败！"))
+		return
+	}
+	enabled := 0
+	recoveryCount := 0
+	if model != nil && model.Status == totpStatusEnabled {
+		enabled = 1
+		recoveryCount, err = u.totpDB.queryUnusedRecoveryCount(loginUID)
+		if err != nil {
+			u.Error("查询恢复码数量失败！", zap.Error(err))
+			c.ResponseError(errors.New("查询恢复码数量失败！"))
+			return
+		}
+	}
+	c.Response(map[string]interface{}{
+		"enabled":        enabled,
+		"recovery_count": recoveryCount,
+	})
+}
+
+// 开始绑定两步验证，返回密钥和扫码地址
+func (u *User) totpEnroll(c *wkhttp.Context) {
+	userInfo, err := u.db.QueryByUID(c.GetLoginUID())
+	if err != nil {
+		u.Error("查询用户信息失败！", zap.Error(err))
+		c.ResponseError(errors.New("查询用户信息失败！"))
+		return
+	}
+	if userInfo == nil {
+		c.ResponseError(errors.New("登录用户不存在！"))
+		return
+	}
+	resp, err := startTOTPEnroll(u.ctx, u.totpDB, userInfo.UID, userInfo.Username)
+	if err != nil {
+		c.ResponseError(err)
+		return
+	}
+	c.Response(resp)
+}
+
+// 验证绑定的验证码并开启两步验证，返回恢复码（只返回这一次）
+func (u *User) totpEnrollVerify(c *wkhttp.Context) {
+	var req struct {
+		Code string `json:"code"`
+	}
+	if err := c.BindJSON(&req); err != nil {
+		c.ResponseError(errors.New("请求数据格式有误！"))
+		return
+	}
+	codes, err := finishTOTPEnroll(u.ctx, u.totpDB, c.GetLoginUID(), req.Code)
+	if err != nil {
+		c.ResponseError(err)
+		return
+	}
+	c.Response(map[string]interface{}{
+		"recovery_codes": codes,
+	})
+}
+
+// 重新生成恢复码
+func (u *User) totpRecoveryCodes(c *wkhttp.Context) {
+	var req struct {
+		Code string `json:"code"` // 验证码或恢复码
+	}
+	if err := c.BindJSON(&req); err != nil {
+		c.ResponseError(errors.New("请求数据格式有误！"))
+		return
+	}
+	loginUID := c.GetLoginUID()
+	ok, err := verifyTOTPCode(u.totpDB, loginUID, req.Code)
+	if err != nil {
+		u.Error("校验两步验证码失败！", zap.Error(err))
+		c.ResponseError(errors.New("校验两步验证码失败！"))
+		return
+	}
+	if !ok {
+		c.ResponseError(errors.New("验证码不正确！"))
+		return
+	}
+	codes, hashes, err := newTOTPRecoveryCodes()
+	if err != nil {
+		u.Error("生成恢复码失败！", zap.Error(err))
+		c.ResponseError(errors.New("生成恢复码失败！"))
+		return
+	}
+	tx, err := u.ctx.DB().Begin()
+	if err != nil {
+		u.Error("开启事务失败！", zap.Error(err))
+		c.ResponseError(errors.New("开启事务失败！"))
+		return
+	}
+	defer func() {
+		if err := recover(); err != nil {
+			tx.Rollback()
+			panic(err)
+		}
+	}()
+	err = u.totpDB.insertRecoveryCodesTx(loginUID, hashes, tx)
+	if err != nil {
+		tx.Rollback()
+		u.Error("保存恢复码失败！", zap.Error(err))
+		c.ResponseError(errors.New("保存恢复码失败！"))
+		return
+	}
+	if err = tx.Commit(); err != nil {
+		tx.Rollback()
+		u.Error("提交事务失败！", zap.Error(err))
+		c.ResponseError(errors.New("提交事务失败！"))
+		return
+	}
+	c.Response(map[string]interface{}{
+		"recovery_codes": codes,
+	})
+}
+
+// 关闭两步验证
+func (u *User) totpDisable(c *wkhttp.Context) {
+	var req struct {
+		Code string `json:"code"` // 验证码或恢复码
+	}
+	if err := c.BindJSON(&req); err != nil {
+		c.ResponseError(errors.New("请求数据格式有误！"))
+		return
+	}
+	loginUID := c.GetLoginUID()
+	ok, err := verifyTOTPCode(u.totpDB, loginUID, req.Code)
+	if err != nil {
+		u.Error("校验两步验证码失败！", zap.Error(err))
+		c.ResponseError(errors.New("校验两步验证码失败！"))
+		return
+	}
+	if !ok {
+		c.ResponseError(errors.New("验证码不正确！"))
+		return
+	}
+	if err = deleteTOTP(u.ctx, u.totpDB, loginUID); err != nil {
+		u.Error("关闭两步验证失败！", zap.Error(err))
+		c.ResponseError(errors.New("关闭两步验证失败！"))
+		return
+	}
+	c.ResponseOK()
+}
+
+// 登录第二步：验证两步验证码
+func (u *User) loginTOTP(c *wkhttp.Context) {
+	var req struct {
+		Ticket string `json:"ticket"`
+		Code   string `json:"code"` // 验证码或恢复码
+	}
+	if err := c.BindJSON(&req); err != nil {
+		c.ResponseError(errors.New("请求数据格式有误！"))
+		return
+	}
+	ticket, err := checkTOTPLoginTicket(u.ctx, u.totpDB, req.Ticket, req.Code)
+	if err != nil {
+		c.ResponseError(err)
+		return
+	}
+	if ticket.Manager == 1 {
+		c.ResponseError(errors.New("登录凭证无效，请重新登录！"))
+		return
+	}
+	userInfo, err := u.db.QueryByUID(ticket.UID)
+	if err != nil {
+		u.Error("查询用户信息失败！", zap.Error(err))
+		c.ResponseError(errors.New("查询用户信息失败！"))
+		return
+	}
+	if userInfo == nil || userInfo.IsDestroy == 1 {
+		c.ResponseError(errors.New("用户不存在"))
+		return
+	}
+	loginSpan := u.ctx.Tracer().StartSpan(
+		"user.loginTOTP",
+		opentracing.ChildOf(c.GetSpanContext()),
+	)
+	defer loginSpan.Finish()
+	loginSpanCtx := u.ctx.Tracer().ContextWithSpan(context.Background(), loginSpan)
+
+	result, err := u.execLoginAfterTOTP(userInfo, config.DeviceFlag(ticket.Flag), ticket.Device, newLoginClient(c), loginSpanCtx)
+	if err != nil {
+		if errors.Is(err, ErrUserNeedVerification) {
+			u.responseNeedVerification(userInfo, c)
+			return
+		}
+		c.ResponseError(err)
+		return
+	}
+	c.Response(result)
+
+	publicIP := util.GetClientPublicIP(c.Request)
+	go u.sentWelcomeMsg(publicIP, userInfo.UID)
+}
+
+// 返回需要两步验证，客户端带上凭证调用登录第二步
+func (u *User) responseNeedTOTP(userInfo *Model, flag config.DeviceFlag, device *deviceReq, c *wkhttp.Context) {
+	resp, err := u.needTOTPResp(userInfo, flag, device)
+	if err != nil {
+		c.ResponseError(err)
+		return
+	}
+	c.ResponseWithStatus(http.StatusBadRequest, resp)
+}
+
+func (u *User) needTOTPResp(userInfo *Model, flag config.DeviceFlag, device *deviceReq) (map[string]interface{}, error) {
+	ticket, err := createTOTPLoginTicket(u.ctx, &totpLoginTicket{
+		UID:    userInfo.UID,
+		Flag:   flag.Uint8(),
+		Device: device,
+	})
+	if err != nil {
+		u.Error("创建两步验证登录凭证失败！", zap.Error(err))
+		return nil, errors.New("创建两步验证登录凭证失败！")
+	}
+	return map[string]interface{}{
+		"status": 111,
+		"msg":    ErrUserNeedTOTP.Error(),
+		"uid":    userInfo.UID,
+		"ticket": ticket,
+	}, nil
+}
+
+// 是否开启了两步验证
+func totpEnabled(d *totpDB, uid string) (bool, error) {
+	model, err := d.queryWithUID(uid)
+	if err != nil {
+		return false, err
+	}
+	return model != nil && model.Status == totpStatusEnabled, nil
+}
+
+// 校验验证码或恢复码（验证码只能使用一次，恢复码使用后失效）
+func verifyTOTPCode(d *totpDB, uid string, code string) (bool, error) {
+	code = strings.TrimSpace(code)
+	if code == "" {
+		return false, nil
+	}
+	model, err := d.queryWithUID(uid)
+	if err != nil {
+		return false, err
+	}
+	if model == nil || model.Status != totpStatusEnabled {
+		return false, nil
+	}
+	if step, ok := totp.Validate(model.Secret, code, time.Now(), model.LastStep); ok {
+		return d.updateLastStep(uid, step)
+	}
+	return d.useRecoveryCode(uid, hashTOTPRecoveryCode(code))
+}
+
+// 开始绑定，已开启的需要先关闭
+func startTOTPEnroll(ctx *config.Context, d *totpDB, uid string, account string) (map[string]interface{}, error) {
+	enabled, err := totpEnabled(d, uid)
+	if err != nil {
+		log.Error("查询两步验证失败！", zap.Error(err))
+		return nil, errors.New("查询两步验证失败！")
+	}
+	if enabled {
+		return nil, errors.New("已开启两步验证！")
+	}
+	secret, err := totp.GenerateSecret()
+	if err != nil {
+		log.Error("生成两步验证密钥失败！", zap.Error(err))
+		return nil, errors.New("生成两步验证密钥失败！")
+	}
+	if err = d.insertOrUpdatePending(uid, secret); err != nil {
+		log.Error("保存两步验证密钥失败！", zap.Error(err))
+		return nil, errors.New("保存两步验证密钥失败！")
+	}
+	if account == "" {
+		account = uid
+	}
+	return map[string]interface{}{
+		"secret": secret,
+		"url":    totp.URL(ctx.GetConfig().AppName, account, secret), // 客户端用此地址生成二维码
+	}, nil
+}
+
+// 验证绑定的验证码，开启两步验证并返回恢复码
+func finishTOTPEnroll(ctx *config.Context, d *totpDB, uid string, code string) ([]string, error) {
+	model, err := d.queryWithUID(uid)
+	if err != nil {
+		log.Error("查询两步验证失败！", zap.Error(err))
+		return nil, errors.New("查询两步验证失败！")
+	}
+	if model == nil {
+		return nil, errors.New("请先绑定两步验证！")
+	}
+	if model.Status == totpStatusEnabled {
+		return nil, errors.New("已开启两步验证！")
+	}
+	step, ok := totp.Validate(model.Secret, code, time.Now(), 0)
+	if !ok {
+		return nil, errors.New("验证码不正确！")
+	}
+	codes, hashes, err := newTOTPRecoveryCodes()
+	if err != nil {
+		log.Error("生成恢复码失败！", zap.Error(err))
+		return nil, errors.New("生成恢复码失败！")
+	}
+	tx, err := ctx.DB().Begin()
+	if err != nil {
+		log.Error("开启事务失败！", zap.Error(err))
+		return nil, errors.New("开启事务失败！")
+	}
+	defer func() {
+		if err := recover(); err != nil {
+			tx.Rollback()
+			panic(err)
+		}
+	}()
+	enabled, err := d.enableTx(uid, step, hashes, tx)
+	if err != nil {
+		tx.Rollback()
+		log.Error("开启两步验证失败！", zap.Error(err))
+		return nil, errors.New("开启两步验证失败！")
+	}
+	if !enabled {
+		tx.Rollback()
+		return nil, errors.New("已开启两步验证！")
+	}
+	if err = tx.Commit(); err != nil {
+		tx.Rollback()
+		log.Error("提交事务失败！", zap.Error(err))
+		return nil, errors.New("提交事务失败！")
+	}
+	return codes, nil
+}
+
+// 删除两步验证和恢复码
+func deleteTOTP(ctx *config.Context, d *totpDB, uid string) error {
+	tx, err := ctx.DB().Begin()
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err := recover(); err != nil {
+			tx.Rollback()
+			panic(err)
+		}
+	}()
+	if err = d.deleteTx(uid, tx); err != nil {
+		tx.Rollback()
+		return err
+	}
+	if err = tx.Commit(); err != nil {
+		tx.Rollback()
+		return err
+	}
+	return nil
+}
+
+// 生成恢复码，返回明文和sha256
+func newTOTPRecoveryCodes() ([]string, []string, error) {
+	const chars = "abcdefghjkmnpqrstuvwxyz23456789"
+	codes := make([]string, 0, totpRecoveryCodeCount)
+	hashes := make([]string, 0, totpRecoveryCodeCount)
+	buf := make([]byte, 10)
+	for i := 0; i < totpRecoveryCodeCount; i++ {
+		if _, err := rand.Read(buf); err != nil {
+			return nil, nil, err
+		}
+		code := make([]byte, 0, len(buf)+1)
+		for j, b := range buf {
+			if j == len(buf)/2 {
+				code = append(code, '-')
+			}
+			code = append(code, chars[int(b)%len(chars)])
+		}
+		codes = append(codes, string(code))
+		hashes = append(hashes, hashTOTPRecoveryCode(string(code)))
+	}
+	return codes, hashes, nil
+}
+
+func hashTOTPRecoveryCode(code string) string {
+	sum := sha256.Sum256([]byte(strings.ToLower(strings.TrimSpace(code))))
+	return hex.EncodeToString(sum[:])
+}
+
+func createTOTPLoginTicket(ctx *config.Context, ticket *totpLoginTicket) (string, error) {
+	ticketID := util.GenerUUID()
+	err := ctx.GetRedisConn().SetAndExpire(fmt.Sprintf("%s%s", totpLoginTicketPrefix, ticketID), util.ToJson(ticket), totpLoginTicketExpire)
+	if err != nil {
+		return "", err
+	}
+	return ticketID, nil
+}
+
+func loadTOTPLoginTicket(ctx *config.Context, ticketID string) (*totpLoginTicket, error) {
+	if strings.TrimSpace(ticketID) == "" {
+		return nil, errors.New("登录凭证不能为空！")
+	}
+	ticketJSON, err := ctx.GetRedisConn().GetString(fmt.Sprintf("%s%s", totpLoginTicketPrefix, ticketID))
+	if err != nil {
+		log.Error("获取两步验证登录凭证失败！", zap.Error(err))
+		return nil, errors.New("获取两步验证登录凭证失败！")
+	}
+	if ticketJSON == "" {
+		return nil, errors.New("登录凭证已过期，请重新登录！")
+	}
+	var ticket *totpLoginTicket
+	if err = util.ReadJsonByByte([]byte(ticketJSON), &ticket); err != nil {
+		log.Error("解码两步验证登录凭证失败！", zap.Error(err))
+		return nil, errors.New("解码两步验证登录凭证失败！")
+	}
+	return ticket, nil
+}
+
+// 校验登录凭证和验证码，成功后凭证失效
+func checkTOTPLoginTicket(ctx *config.Context, d *totpDB, ticketID string, code string) (*totpLoginTicket, error) {
+	if strings.TrimSpace(code) == "" {
+		return nil, errors.New("验证码不能为空！")
+	}
+	ticket, err := loadTOTPLoginTicket(ctx, ticketID)
+	if err != nil {
+		return nil, err
+	}
+	key := fmt.Sprintf("%s%s", totpLoginTicketPrefix, ticketID)
+	ok, err := verifyTOTPCode(d, ticket.UID, code)
+	if err != nil {
+		log.Error("校验两步验证码失败！", zap.Error(err))
+		return nil, errors.New("校验两步验证码失败！")
+	}
+	if !ok {
+		ticket.Fail++
+		if ticket.Fail >= totpLoginMaxFail {
+			_ = ctx.GetRedisConn().Del(key)
+			return nil, errors.New("验证码错误次数过多，请重新登录！")
+		}
+		_ = ctx.GetRedisConn().SetAndExpire(key, util.ToJson(ticket), totpLoginTicketExpire)
+		return nil, errors.New("验证码不正确！")
+	}
+	_ = ctx.GetRedisConn().Del(key)
+	return ticket, nil
+}
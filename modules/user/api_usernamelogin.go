@@ -65,10 +65,6 @@ func (u *User) usernameLogin(c *wkhttp.Context) {
 		c.ResponseError(err)
 		return
 	}
-	if len(req.Username) < 8 || len(req.Username) > 22 {
-		c.ResponseError(errors.New("用户名必须在8-22位"))
-		return
-	}
 	loginSpan := u.ctx.Tracer().StartSpan(
 		"login",
 		opentracing.ChildOf(c.GetSpanContext()),
@@ -77,26 +73,68 @@ func (u *User) usernameLogin(c *wkhttp.Context) {
 	loginSpan.SetTag("username", req.Username)
 	defer loginSpan.Finish()
 
-	userInfo, err := u.db.QueryByUsernameCxt(loginSpanCtx, req.Username)
+	// 连续登录失败的账号或IP需要等待、输入验证码或已被锁定
+	publicIP := util.GetClientPublicIP(c.Request)
+	if err := u.loginGuard.check(req.Username, publicIP, req.CaptchaID, req.CaptchaCode); err != nil {
+		responseLoginGuardError(err, c)
+		return
+	}
+
+	// 开启目录认证时优先使用目录校验密码（目录登录名不受用户名长度限制）
+	userInfo, result, err := u.ldapLogin(req.Username, req.Password, config.DeviceFlag(req.Flag), req.Device, loginSpanCtx, c)
 	if err != nil {
-		u.Error("查询用户信息失败！", zap.String("username", req.Username))
+		if errors.Is(err, ErrPasswordIncorrect) {
+			u.loginGuard.fail(req.Username, "", publicIP)
+		}
 		c.ResponseError(err)
 		return
 	}
 	if userInfo == nil {
-		c.ResponseError(errors.New("该用户名不存在"))
-		return
-	}
+		if len(req.Username) < 8 || len(req.Username) > 22 {
+			c.ResponseError(errors.New("用户名必须在8-22位"))
+			return
+		}
+		userInfo, err = u.db.QueryByUsernameCxt(loginSpanCtx, req.Username)
+		if err != nil {
+			u.Error("查询用户信息失败！", zap.String("username", req.Username))
+			c.ResponseError(err)
+			return
+		}
+		if userInfo == nil {
+			u.loginGuard.fail(req.Username, "", publicIP)
+			c.ResponseError(errors.New("该用户名不存在"))
+			return
+		}
 
-	if util.MD5(util.MD5(req.Password)) != userInfo.Password {
-		c.ResponseError(errors.New("密码不正确！"))
-		return
+		ok, err := verifyPassword(u.db, userInfo.UID, req.Password, userInfo.Password, userInfo.PasswordAlgo, userInfo.PasswordReset)
+		if err != nil {
+			u.Error("校验密码失败！", zap.Error(err), zap.String("uid", userInfo.UID))
+			c.ResponseError(errors.New("校验密码失败！"))
+			return
+		}
+		if !ok {
+			u.loginGuard.fail(req.Username, userInfo.UID, publicIP)
+			c.ResponseError(ErrPasswordIncorrect)
+			return
+		}
+		if userInfo.PasswordReset == 1 {
+			u.loginGuard.success(req.Username)
+			c.ResponseError(ErrPasswordNeedReset)
+			return
+		}
 	}
+	u.loginGuard.success(req.Username)
 
-	result, err := u.execLogin(userInfo, config.DeviceFlag(req.Flag), req.Device, loginSpanCtx)
-	if err != nil {
-		c.ResponseError(err)
-		return
+	if result == nil { // 目录首次登录时已经返回了登录结果
+		result, err = u.execLogin(userInfo, config.DeviceFlag(req.Flag), req.Device, newLoginClient(c), loginSpanCtx)
+		if err != nil {
+			if errors.Is(err, ErrUserNeedTOTP) {
+				u.responseNeedTOTP(userInfo, config.DeviceFlag(req.Flag), req.Device, c)
+				return
+			}
+			c.ResponseError(err)
+			return
+		}
 	}
 	needUploadWeb3PublicKey := 0
 	if userInfo.Web3PublicKey == "" {
@@ -231,9 +269,7 @@ func (u *User) resetPwdWithWeb3PublicKey(c *wkhttp.Context) {
 		return
 	}
 
-	updateMap := map[string]interface{}{}
-	updateMap["password"] = util.MD5(util.MD5(req.Password))
-	err = u.db.updateUser(updateMap, user.UID)
+	err = updatePassword(u.db, user.UID, req.Password)
 	if err != nil {
 		u.Error("修改用户密码错误", zap.Error(err))
 		c.ResponseError(err)
@@ -459,12 +495,17 @@ func (u *User) updatePwd(c *wkhttp.Context) {
 		c.ResponseError(errors.New("该用户不存在"))
 		return
 	}
-	oldPwd := util.MD5(util.MD5(req.Password))
-	if oldPwd != userInfo.Password {
+	ok, err := verifyPassword(u.db, userInfo.UID, req.Password, userInfo.Password, userInfo.PasswordAlgo, userInfo.PasswordReset)
+	if err != nil {
+		u.Error("校验密码失败！", zap.Error(err), zap.String("uid", userInfo.UID))
+		c.ResponseError(errors.New("校验密码失败！"))
+		return
+	}
+	if !ok {
 		c.ResponseError(errors.New("旧密码错误"))
 		return
 	}
-	err = u.db.UpdateUsersWithField("password", util.MD5(util.MD5(req.NewPassword)), userInfo.UID)
+	err = updatePassword(u.db, userInfo.UID, req.NewPassword)
 	if err != nil {
 		u.Error("修改登录密码错误", zap.Error(err))
 		c.ResponseError(errors.New("修改登录密码错误"))
@@ -0,0 +1,518 @@
+package user
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/TangSengDaoDao/TangSengDaoDaoServer/pkg/webauthn"
+	"github.com/TangSengDaoDao/TangSengDaoDaoServerLib/config"
+	"github.com/TangSengDaoDao/TangSengDaoDaoServerLib/pkg/util"
+	"github.com/TangSengDaoDao/TangSengDaoDaoServerLib/pkg/wkhttp"
+	"github.com/opentracing/opentracing-go"
+	"go.uber.org/zap"
+)
+
+const (
+	webauthnRegisterPrefix  = "webauthnRegister:" // 注册通行密钥的挑战值
+	webauthnLoginPrefix     = "webauthnLogin:"    // 通行密钥登录的会话
+	webauthnChallengeExpire = time.Minute * 5     // 挑战值有效期
+	webauthnMaxCredentials  = 10                  // 每个用户最多注册的通行密钥数量
+	webauthnNameMaxLength   = 50                  // 通行密钥名称最大长度
+)
+
+// 通行密钥登录会话（指定了用户名时只能使用该用户的通行密钥）
+type webauthnLoginSession struct {
+	Challenge string `json:"challenge"`
+	UID       string `json:"uid,omitempty"`
+}
+
+// 依赖方配置，后台没有配置时使用网页登录地址
+func (u *User) webauthnConfig() (*webauthn.Config, error) {
+	appConfig, err := u.commonService.GetAppConfig()
+	if err != nil {
+		u.Error("查询应用配置失败！", zap.Error(err))
+		return nil, errors.New("查询应用配置失败！")
+	}
+	var rpID string
+	origins := make([]string, 0)
+	if appConfig != nil {
+		rpID = strings.TrimSpace(appConfig.WebauthnRPID)
+		for _, origin := range strings.Split(appConfig.WebauthnOrigins, ",") {
+			if origin = strings.TrimSpace(origin); origin != "" {
+				origins = append(origins, origin)
+			}
+		}
+	}
+	if len(origins) == 0 {
+		loginURL, err := url.Parse(u.ctx.GetConfig().External.WebLoginURL)
+		if err == nil && loginURL.Scheme != "" && loginURL.Host != "" {
+			origins = append(origins, fmt.Sprintf("%s://%s", loginURL.Scheme, loginURL.Host))
+		}
+	}
+	if rpID == "" && len(origins) > 0 {
+		if originURL, err := url.Parse(origins[0]); err == nil {
+			rpID = originURL.Hostname()
+		}
+	}
+	if rpID == "" || len(origins) == 0 {
+		return nil, errors.New("未配置通行密钥！")
+	}
+	return &webauthn.Config{
+		RPID:    rpID,
+		RPName:  u.ctx.GetConfig().AppName,
+		Origins: origins,
+		Timeout: webauthnChallengeExpire,
+	}, nil
+}
+
+func webauthnCredentialHash(credentialID []byte) string {
+	sum := sha256.Sum256(credentialID)
+	return hex.EncodeToString(sum[:])
+}
+
+func webauthnDescriptors(models []*webauthnCredentialModel) []webauthn.CredentialDescriptor {
+	descriptors := make([]webauthn.CredentialDescriptor, 0, len(models))
+	for _, model := range models {
+		id, err := webauthn.DecodeBase64(model.CredentialID)
+		if err != nil {
+			continue
+		}
+		var transports []string
+		if model.Transports != "" {
+			transports = strings.Split(model.Transports, ",")
+		}
+		descriptors = append(descriptors, webauthn.NewCredentialDescriptor(id, transports))
+	}
+	return descriptors
+}
+
+// 开始注册通行密钥
+func (u *User) webauthnRegisterBegin(c *wkhttp.Context) {
+	loginUID := c.GetLoginUID()
+	userInfo, err := u.db.QueryByUID(loginUID)
+	if err != nil {
+		u.Error("查询用户信息失败！", zap.Error(err))
+		c.ResponseError(errors.New("查询用户信息失败！"))
+		return
+	}
+	if userInfo == nil {
+		c.ResponseError(errors.New("登录用户不存在！"))
+		return
+	}
+	cfg, err := u.webauthnConfig()
+	if err != nil {
+		c.ResponseError(err)
+		return
+	}
+	models, err := u.webauthnDB.queryWithUID(loginUID)
+	if err != nil {
+		u.Error("查询通行密钥失败！", zap.Error(err))
+		c.ResponseError(errors.New("查询通行密钥失败！"))
+		return
+	}
+	if len(models) >= webauthnMaxCredentials {
+		c.ResponseError(fmt.Errorf("最多只能添加%d个通行密钥！", webauthnMaxCredentials))
+		return
+	}
+	challenge, err := webauthn.NewChallenge()
+	if err != nil {
+		u.Error("生成挑战值失败！", zap.Error(err))
+		c.ResponseError(errors.New("生成挑战值失败！"))
+		return
+	}
+	err = u.ctx.GetRedisConn().SetAndExpire(webauthnRegisterPrefix+loginUID, challenge, webauthnChallengeExpire)
+	if err != nil {
+		u.Error("缓存挑战值失败！", zap.Error(err))
+		c.ResponseError(errors.New("缓存挑战值失败！"))
+		return
+	}
+	name := userInfo.Username
+	if name == "" {
+		name = userInfo.Phone
+	}
+	if name == "" {
+		name = userInfo.UID
+	}
+	c.Response(map[string]interface{}{
+		"public_key": cfg.CreationOptions(challenge, webauthn.User{
+			ID:          []byte(userInfo.UID),
+			Name:        name,
+			DisplayName: userInfo.Name,
+		}, webauthnDescriptors(models)),
+	})
+}
+
+// 完成注册通行密钥
+func (u *User) webauthnRegisterFinish(c *wkhttp.Context) {
+	var req struct {
+		Name       string                         `json:"name"`
+		Credential *webauthn.RegistrationResponse `json:"credential"`
+	}
+	if err := c.BindJSON(&req); err != nil {
+		c.ResponseError(errors.New("请求数据格式有误！"))
+		return
+	}
+	req.Name = strings.TrimSpace(req.Name)
+	if utf8.RuneCountInString(req.Name) > webauthnNameMaxLength {
+		c.ResponseError(fmt.Errorf("名称不能超过%d个字符！", webauthnNameMaxLength))
+		return
+	}
+	if req.Credential == nil {
+		c.ResponseError(errors.New("凭证不能为空！"))
+		return
+	}
+	loginUID := c.GetLoginUID()
+	challenge, err := u.ctx.GetRedisConn().GetString(webauthnRegisterPrefix + loginUID)
+	if err != nil {
+		u.Error("查询挑战值失败！", zap.Error(err))
+		c.ResponseError(errors.New("查询挑战值失败！"))
+		return
+	}
+	if challenge == "" {
+		c.ResponseError(errors.New("注册已过期，请重新开始！"))
+		return
+	}
+	// 挑战值只能使用一次
+	if err = u.ctx.GetRedisConn().Del(webauthnRegisterPrefix + loginUID); err != nil {
+		u.Error("删除挑战值失败！", zap.Error(err))
+		c.ResponseError(errors.New("删除挑战值失败！"))
+		return
+	}
+	cfg, err := u.webauthnConfig()
+	if err != nil {
+		c.ResponseError(err)
+		return
+	}
+	credential, err := cfg.VerifyRegistration(challenge, req.Credential)
+	if err != nil {
+		u.Warn("通行密钥注册校验失败！", zap.Error(err), zap.String("uid", loginUID))
+		c.ResponseError(errors.New("通行密钥校验失败！"))
+		return
+	}
+	credentialHash := webauthnCredentialHash(credential.ID)
+	exist, err := u.webauthnDB.queryWithHash(credentialHash)
+	if err != nil {
+		u.Error("查询通行密钥失败！", zap.Error(err))
+		c.ResponseError(errors.New("查询通行密钥失败！"))
+		return
+	}
+	if exist != nil {
+		c.ResponseError(errors.New("该通行密钥已添加！"))
+		return
+	}
+	models, err := u.webauthnDB.queryWithUID(loginUID)
+	if err != nil {
+		u.Error("查询通行密钥失败！", zap.Error(err))
+		c.ResponseError(errors.New("查询通行密钥失败！"))
+		return
+	}
+	if len(models) >= webauthnMaxCredentials {
+		c.ResponseError(fmt.Errorf("最多只能添加%d个通行密钥！", webauthnMaxCredentials))
+		return
+	}
+	if req.Name == "" {
+		req.Name = fmt.Sprintf("通行密钥%d", len(models)+1)
+	}
+	backupEligible := 0
+	if credential.BackupEligible {
+		backupEligible = 1
+	}
+	model := &webauthnCredentialModel{
+		UID:            loginUID,
+		CredentialID:   webauthn.EncodeBase64(credential.ID),
+		CredentialHash: credentialHash,
+		PublicKey:      webauthn.EncodeBase64(credential.PublicKey),
+		Algorithm:      credential.Algorithm,
+		SignCount:      credential.SignCount,
+		AAGUID:         hex.EncodeToString(credential.AAGUID),
+		Transports:     strings.Join(credential.Transports, ","),
+		BackupEligible: backupEligible,
+		Name:           req.Name,
+	}
+	if err = u.webauthnDB.insert(model); err != nil {
+		u.Error("添加通行密钥失败！", zap.Error(err))
+		c.ResponseError(errors.New("添加通行密钥失败！"))
+		return
+	}
+	model, err = u.webauthnDB.queryWithHash(credentialHash)
+	if err != nil || model == nil {
+		u.Error("查询通行密钥失败！", zap.Error(err))
+		c.ResponseError(errors.New("查询通行密钥失败！"))
+		return
+	}
+	c.Response(newWebauthnCredentialResp(model))
+}
+
+// 通行密钥列表
+func (u *User) webauthnCredentials(c *wkhttp.Context) {
+	models, err := u.webauthnDB.queryWithUID(c.GetLoginUID())
+	if err != nil {
+		u.Error("查询通行密钥失败！", zap.Error(err))
+		c.ResponseError(errors.New("查询通行密钥失败！"))
+		return
+	}
+	list := make([]*webauthnCredentialResp, 0, len(models))
+	for _, model := range models {
+		list = append(list, newWebauthnCredentialResp(model))
+	}
+	c.Response(list)
+}
+
+// 修改通行密钥名称
+func (u *User) webauthnCredentialUpdate(c *wkhttp.Context) {
+	var req struct {
+		Name string `json:"name"`
+	}
+	if err := c.BindJSON(&req); err != nil {
+		c.ResponseError(errors.New("请求数据格式有误！"))
+		return
+	}
+	req.Name = strings.TrimSpace(req.Name)
+	if req.Name == "" {
+		c.ResponseError(errors.New("名称不能为空！"))
+		return
+	}
+	if utf8.RuneCountInString(req.Name) > webauthnNameMaxLength {
+		c.ResponseError(fmt.Errorf("名称不能超过%d个字符！", webauthnNameMaxLength))
+		return
+	}
+	model, ok := u.queryLoginWebauthnCredential(c)
+	if !ok {
+		return
+	}
+	if err := u.webauthnDB.updateName(model.Id, req.Name); err != nil {
+		u.Error("修改通行密钥名称失败！", zap.Error(err))
+		c.ResponseError(errors.New("修改通行密钥名称失败！"))
+		return
+	}
+	c.ResponseOK()
+}
+
+// 删除通行密钥
+func (u *User) webauthnCredentialDelete(c *wkhttp.Context) {
+	model, ok := u.queryLoginWebauthnCredential(c)
+	if !ok {
+		return
+	}
+	if err := u.webauthnDB.delete(model.Id); err != nil {
+		u.Error("删除通行密钥失败！", zap.Error(err))
+		c.ResponseError(errors.New("删除通行密钥失败！"))
+		return
+	}
+	c.ResponseOK()
+}
+
+// 查询登录用户路径参数中指定的通行密钥，失败时已返回错误
+func (u *User) queryLoginWebauthnCredential(c *wkhttp.Context) (*webauthnCredentialModel, bool) {
+	id, _ := strconv.ParseInt(c.Param("id"), 10, 64)
+	if id <= 0 {
+		c.ResponseError(errors.New("通行密钥ID有误！"))
+		return nil, false
+	}
+	model, err := u.webauthnDB.queryWithIDAndUID(id, c.GetLoginUID())
+	if err != nil {
+		u.Error("查询通行密钥失败！", zap.Error(err))
+		c.ResponseError(errors.New("查询通行密钥失败！"))
+		return nil, false
+	}
+	if model == nil {
+		c.ResponseError(errors.New("通行密钥不存在！"))
+		return nil, false
+	}
+	return model, true
+}
+
+// 开始通行密钥登录，不传用户名时由认证器选择账号
+func (u *User) webauthnLoginBegin(c *wkhttp.Context) {
+	var req struct {
+		Username string `json:"username"`
+	}
+	if err := c.BindJSON(&req); err != nil {
+		c.ResponseError(errors.New("请求数据格式有误！"))
+		return
+	}
+	cfg, err := u.webauthnConfig()
+	if err != nil {
+		c.ResponseError(err)
+		return
+	}
+	session := &webauthnLoginSession{}
+	var models []*webauthnCredentialModel
+	if username := strings.TrimSpace(req.Username); username != "" {
+		userInfo, err := u.db.QueryByUsername(username)
+		if err != nil {
+			u.Error("查询用户信息失败！", zap.Error(err))
+			c.ResponseError(errors.New("查询用户信息失败！"))
+			return
+		}
+		// 用户不存在时不提示，避免通过此接口探测账号
+		if userInfo != nil {
+			session.UID = userInfo.UID
+			models, err = u.webauthnDB.queryWithUID(userInfo.UID)
+			if err != nil {
+				u.Error("查询通行密钥失败！", zap.Error(err))
+				c.ResponseError(errors.New("查询通行密钥失败！"))
+				return
+			}
+		} else {
+			session.UID = "-" // 不会匹配任何通行密钥
+		}
+	}
+	session.Challenge, err = webauthn.NewChallenge()
+	if err != nil {
+		u.Error("生成挑战值失败！", zap.Error(err))
+		c.ResponseError(errors.New("生成挑战值失败！"))
+		return
+	}
+	sessionID := util.GenerUUID()
+	err = u.ctx.GetRedisConn().SetAndExpire(webauthnLoginPrefix+sessionID, util.ToJson(session), webauthnChallengeExpire)
+	if err != nil {
+		u.Error("缓存登录会话失败！", zap.Error(err))
+		c.ResponseError(errors.New("缓存登录会话失败！"))
+		return
+	}
+	c.Response(map[string]interface{}{
+		"session_id": sessionID,
+		"public_key": cfg.RequestOptions(session.Challenge, webauthnDescriptors(models)),
+	})
+}
+
+// 完成通行密钥登录，返回和密码登录一样的登录信息
+func (u *User) webauthnLoginFinish(c *wkhttp.Context) {
+	var req struct {
+		SessionID  string                      `json:"session_id"`
+		Credential *webauthn.AssertionResponse `json:"credential"`
+		Flag       int                         `json:"flag"`   // 设备标示 0.APP 1.PC
+		Device     *deviceReq                  `json:"device"` // 登录设备信息
+	}
+	if err := c.BindJSON(&req); err != nil {
+		c.ResponseError(errors.New("请求数据格式有误！"))
+		return
+	}
+	if req.SessionID == "" || req.Credential == nil {
+		c.ResponseError(errors.New("登录会话和凭证不能为空！"))
+		return
+	}
+	loginSpan := u.ctx.Tracer().StartSpan(
+		"webauthnLogin",
+		opentracing.ChildOf(c.GetSpanContext()),
+	)
+	loginSpanCtx := u.ctx.Tracer().ContextWithSpan(context.Background(), loginSpan)
+	defer loginSpan.Finish()
+
+	sessionJSON, err := u.ctx.GetRedisConn().GetString(webauthnLoginPrefix + req.SessionID)
+	if err != nil {
+		u.Error("查询登录会话失败！", zap.Error(err))
+		c.ResponseError(errors.New("查询登录会话失败！"))
+		return
+	}
+	if sessionJSON == "" {
+		c.ResponseError(errors.New("登录已过期，请重新开始！"))
+		return
+	}
+	// 挑战值只能使用一次
+	if err = u.ctx.GetRedisConn().Del(webauthnLoginPrefix + req.SessionID); err != nil {
+		u.Error("删除登录会话失败！", zap.Error(err))
+		c.ResponseError(errors.New("删除登录会话失败！"))
+		return
+	}
+	var session webauthnLoginSession
+	if err = json.Unmarshal([]byte(sessionJSON), &session); err != nil {
+		u.Error("解析登录会话失败！", zap.Error(err))
+		c.ResponseError(errors.New("解析登录会话失败！"))
+		return
+	}
+	credentialID, err := req.Credential.CredentialID()
+	if err != nil || len(credentialID) == 0 {
+		c.ResponseError(errors.New("凭证格式有误！"))
+		return
+	}
+	model, err := u.webauthnDB.queryWithHash(webauthnCredentialHash(credentialID))
+	if err != nil {
+		u.Error("查询通行密钥失败！", zap.Error(err))
+		c.ResponseError(errors.New("查询通行密钥失败！"))
+		return
+	}
+	if model == nil || (session.UID != "" && session.UID != model.UID) {
+		c.ResponseError(errors.New("通行密钥不存在或已删除！"))
+		return
+	}
+	if req.Credential.Response.UserHandle != "" {
+		userHandle, err := webauthn.DecodeBase64(req.Credential.Response.UserHandle)
+		if err != nil || string(userHandle) != model.UID {
+			c.ResponseError(errors.New("通行密钥与账号不匹配！"))
+			return
+		}
+	}
+	publicKey, err := webauthn.DecodeBase64(model.PublicKey)
+	if err != nil {
+		u.Error("通行密钥公钥格式有误！", zap.Error(err), zap.Int64("id", model.Id))
+		c.ResponseError(errors.New("通行密钥校验失败！"))
+		return
+	}
+	cfg, err := u.webauthnConfig()
+	if err != nil {
+		c.ResponseError(err)
+		return
+	}
+	assertion, err := cfg.VerifyAssertion(session.Challenge, req.Credential, publicKey, model.SignCount)
+	if err != nil {
+		u.Warn("通行密钥登录校验失败！", zap.Error(err), zap.String("uid", model.UID), zap.Int64("id", model.Id))
+		c.ResponseError(errors.New("通行密钥校验失败！"))
+		return
+	}
+	ok, err := u.webauthnDB.updateUsed(model.Id, model.SignCount, assertion.SignCount)
+	if err != nil {
+		u.Error("更新通行密钥失败！", zap.Error(err))
+		c.ResponseError(errors.New("更新通行密钥失败！"))
+		return
+	}
+	if !ok && (model.SignCount != 0 || assertion.SignCount != 0) {
+		c.ResponseError(errors.New("通行密钥校验失败！"))
+		return
+	}
+	userInfo, err := u.db.QueryByUID(model.UID)
+	if err != nil {
+		u.Error("查询用户信息失败！", zap.Error(err))
+		c.ResponseError(errors.New("查询用户信息失败！"))
+		return
+	}
+	if userInfo == nil || userInfo.IsDestroy == 1 {
+		c.ResponseError(errors.New("用户不存在"))
+		return
+	}
+	u.execLoginAndRespose(userInfo, config.DeviceFlag(req.Flag), req.Device, loginSpanCtx, c)
+}
+
+type webauthnCredentialResp struct {
+	ID             int64    `json:"id"`
+	Name           string   `json:"name"`
+	Transports     []string `json:"transports"`
+	BackupEligible int      `json:"backup_eligible"` // 是否可同步到其他设备 0.否 1.是
+	LastUsedAt     int64    `json:"last_used_at"`    // 最后使用时间 10位时间戳
+	CreatedAt      string   `json:"created_at"`
+}
+
+func newWebauthnCredentialResp(m *webauthnCredentialModel) *webauthnCredentialResp {
+	transports := make([]string, 0)
+	if m.Transports != "" {
+		transports = strings.Split(m.Transports, ",")
+	}
+	return &webauthnCredentialResp{
+		ID:             m.Id,
+		Name:           m.Name,
+		Transports:     transports,
+		BackupEligible: m.BackupEligible,
+		LastUsedAt:     m.LastUsedAt,
+		CreatedAt:      m.CreatedAt.String(),
+	}
+}
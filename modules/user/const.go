@@ -61,4 +61,8 @@ const (
 
 const (
 	UserRedDotCategoryFriendApply = "friendApply"
+	// UserRedDotCategoryGroupJoinApply 入群申请待审核（群主和管理员）
+	UserRedDotCategoryGroupJoinApply = "groupJoinApply"
+	// UserRedDotCategoryGroupJoinApplyResult 入群申请审核结果（申请者）
+	UserRedDotCategoryGroupJoinApplyResult = "groupJoinApplyResult"
 )
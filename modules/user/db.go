@@ -3,6 +3,7 @@ package user
 import (
 	"context"
 
+	"github.com/TangSengDaoDao/TangSengDaoDaoServer/pkg/password"
 	"github.com/TangSengDaoDao/TangSengDaoDaoServerLib/config"
 	"github.com/TangSengDaoDao/TangSengDaoDaoServerLib/pkg/db"
 	"github.com/TangSengDaoDao/TangSengDaoDaoServerLib/pkg/util"
@@ -208,17 +209,77 @@ func (d *DB) updateUser(userMap map[string]interface{}, uid string) error {
 	return err
 }
 
-func (d *DB) updatePassword(password string, uid string) error {
-	_, err := d.session.Update("user").Set("password", password).Where("uid=?", uid).Exec()
+// 修改密码（同时清除需要重置密码的标记）
+func (d *DB) updatePassword(password string, passwordAlgo string, uid string) error {
+	_, err := d.session.Update("user").SetMap(map[string]interface{}{
+		"password":       password,
+		"password_algo":  passwordAlgo,
+		"password_reset": 0,
+	}).Where("uid=?", uid).Exec()
 	return err
 }
 
+// 升级密码哈希算法（密码未被修改且未被要求重置密码时才更新）
+func (d *DB) rehashPassword(uid string, oldPassword string, newPassword string, passwordAlgo string) error {
+	_, err := d.session.Update("user").SetMap(map[string]interface{}{
+		"password":      newPassword,
+		"password_algo": passwordAlgo,
+	}).Where("uid=? and password=? and password_reset=0", uid, oldPassword).Exec()
+	return err
+}
+
+// 查询还在使用旧密码哈希或已被要求重置密码的用户
+func (d *DB) queryLegacyPasswordWithPage(pageSize, page uint64) ([]*Model, error) {
+	var models []*Model
+	_, err := d.session.Select("*").From("user").Where("(password_algo=? or password_reset=1) and password<>'' and is_destroy=0", password.AlgoMD5).OrderDesc("created_at").Offset((page - 1) * pageSize).Limit(pageSize).Load(&models)
+	return models, err
+}
+
+// 查询还在使用旧密码哈希或已被要求重置密码的用户数量
+func (d *DB) queryLegacyPasswordCount() (int64, error) {
+	var count int64
+	_, err := d.session.Select("count(*)").From("user").Where("(password_algo=? or password_reset=1) and password<>'' and is_destroy=0", password.AlgoMD5).Load(&count)
+	return count, err
+}
+
+// 标记用户需要重置密码 uids为空时标记除excludeRoles角色和机器人外所有还在使用旧密码哈希的用户
+func (d *DB) updateLegacyPasswordReset(uids []string, excludeRoles []string) (int64, error) {
+	builder := d.session.Update("user").Set("password_reset", 1).Where("password<>'' and is_destroy=0 and password_reset=0")
+	if len(uids) > 0 {
+		builder = builder.Where("uid in ?", uids)
+	} else {
+		builder = builder.Where("password_algo=? and role not in ? and robot=0", password.AlgoMD5, excludeRoles)
+	}
+	result, err := builder.Exec()
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
 // 注销账户
 func (d *DB) destroyAccount(uid, username, phone string) error {
 	_, err := d.session.Update("user").SetMap(map[string]interface{}{
-		"phone":      phone,
-		"username":   username,
-		"is_destroy": 1,
+		"phone":          phone,
+		"username":       username,
+		"email_verified": 0,
+		"is_destroy":     1,
+	}).Where("uid=?", uid).Exec()
+	return err
+}
+
+// QueryByEmail 通过已验证的邮箱查询用户
+func (d *DB) QueryByEmail(email string) (*Model, error) {
+	var model *Model
+	_, err := d.session.Select("*").From("user").Where("email=? and email_verified=1", email).Load(&model)
+	return model, err
+}
+
+// 绑定已验证的邮箱
+func (d *DB) updateVerifiedEmail(uid, email string) error {
+	_, err := d.session.Update("user").SetMap(map[string]interface{}{
+		"email":          email,
+		"email_verified": 1,
 	}).Where("uid=?", uid).Exec()
 	return err
 }
@@ -314,7 +375,10 @@ type Model struct {
 	Name              string // 用户名称
 	Username          string // 用户名
 	Email             string // email地址
+	EmailVerified     int    // 邮箱是否已验证0.否1.是
 	Password          string // 用户密码
+	PasswordAlgo      string // 密码哈希算法
+	PasswordReset     int    // 是否需要重置密码后才能登录 0.否 1.是
 	Category          string //用户分类
 	Sex               int    //性别
 	ShortNo           string //唯一短编号
@@ -0,0 +1,149 @@
+package user
+
+import (
+	"time"
+
+	"github.com/TangSengDaoDao/TangSengDaoDaoServerLib/config"
+	"github.com/TangSengDaoDao/TangSengDaoDaoServerLib/pkg/db"
+	"github.com/TangSengDaoDao/TangSengDaoDaoServerLib/pkg/util"
+	"github.com/gocraft/dbr/v2"
+)
+
+type dataExportDB struct {
+	session *dbr.Session
+	ctx     *config.Context
+}
+
+func newDataExportDB(ctx *config.Context) *dataExportDB {
+	return &dataExportDB{
+		session: ctx.DB(),
+		ctx:     ctx,
+	}
+}
+
+func (d *dataExportDB) insert(m *dataExportModel) error {
+	_, err := d.session.InsertInto("user_data_export").Columns(util.AttrToUnderscore(m)...).Record(m).Exec()
+	return err
+}
+
+func (d *dataExportDB) queryWithExportNo(exportNo string) (*dataExportModel, error) {
+	var model *dataExportModel
+	_, err := d.session.Select("*").From("user_data_export").Where("export_no=?", exportNo).Load(&model)
+	return model, err
+}
+
+func (d *dataExportDB) queryWithUID(uid string, limit uint64) ([]*dataExportModel, error) {
+	var models []*dataExportModel
+	_, err := d.session.Select("*").From("user_data_export").Where("uid=?", uid).OrderDesc("id").Limit(limit).Load(&models)
+	return models, err
+}
+
+// 查询用户正在进行中的导出任务数量
+func (d *dataExportDB) queryUnfinishedCount(uid string) (int, error) {
+	var count int
+	_, err := d.session.Select("count(*)").From("user_data_export").Where("uid=? and status in ?", uid, []int{dataExportStatusWait, dataExportStatusRunning}).Load(&count)
+	return count, err
+}
+
+// 查询等待导出的任务编号
+func (d *dataExportDB) queryWaiting(limit uint64) ([]string, error) {
+	var exportNos []string
+	_, err := d.session.Select("export_no").From("user_data_export").Where("status=?", dataExportStatusWait).OrderAsc("id").Limit(limit).Load(&exportNos)
+	return exportNos, err
+}
+
+// 查询导出中超时的任务编号（服务中途重启导致）
+func (d *dataExportDB) queryStaleRunning(updatedBefore time.Time) ([]string, error) {
+	var exportNos []string
+	_, err := d.session.Select("export_no").From("user_data_export").Where("status=? and updated_at<?", dataExportStatusRunning, updatedBefore).Load(&exportNos)
+	return exportNos, err
+}
+
+// 状态从fromStatus改为toStatus 返回影响行数，用于多实例下抢占任务
+func (d *dataExportDB) updateStatus(exportNo string, fromStatus, toStatus int) (int64, error) {
+	result, err := d.session.Update("user_data_export").SetMap(map[string]interface{}{
+		"status":     toStatus,
+		"updated_at": dbr.Now,
+	}).Where("export_no=? and status=?", exportNo, fromStatus).Exec()
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+// 更新导出进度（同时刷新更新时间，用于判断任务是否中断）
+func (d *dataExportDB) updateProgress(exportNo string, total int, processed int) error {
+	_, err := d.session.Update("user_data_export").SetMap(map[string]interface{}{
+		"total":      total,
+		"processed":  processed,
+		"updated_at": dbr.Now,
+	}).Where("export_no=? and status=?", exportNo, dataExportStatusRunning).Exec()
+	return err
+}
+
+// 刷新导出中任务的更新时间
+func (d *dataExportDB) touchRunning(exportNo string) error {
+	_, err := d.session.Update("user_data_export").Set("updated_at", dbr.Now).Where("export_no=? and status=?", exportNo, dataExportStatusRunning).Exec()
+	return err
+}
+
+// 导出完成（任务仍在导出中时才更新）返回影响行数
+func (d *dataExportDB) updateDone(exportNo string, filePath string, fileSize int64) (int64, error) {
+	result, err := d.session.Update("user_data_export").SetMap(map[string]interface{}{
+		"status":      dataExportStatusDone,
+		"file_path":   filePath,
+		"file_size":   fileSize,
+		"finished_at": time.Now().Unix(),
+		"updated_at":  dbr.Now,
+	}).Where("export_no=? and status=?", exportNo, dataExportStatusRunning).Exec()
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+// 导出失败（任务仍在导出中时才更新）返回影响行数
+func (d *dataExportDB) updateFail(exportNo string, reason string) (int64, error) {
+	result, err := d.session.Update("user_data_export").SetMap(map[string]interface{}{
+		"status":      dataExportStatusFail,
+		"fail_reason": reason,
+		"finished_at": time.Now().Unix(),
+		"updated_at":  dbr.Now,
+	}).Where("export_no=? and status=?", exportNo, dataExportStatusRunning).Exec()
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+// 用户作为申请人或接收人的好友申请
+func (d *dataExportDB) queryFriendApplies(uid string) ([]*FriendApplyModel, error) {
+	var models []*FriendApplyModel
+	_, err := d.session.Select("*").From("friend_apply_record").Where("uid=? or to_uid=?", uid, uid).OrderAsc("id").Load(&models)
+	return models, err
+}
+
+func (d *dataExportDB) queryUserSettings(uid string) ([]*SettingModel, error) {
+	var models []*SettingModel
+	_, err := d.session.Select("*").From("user_setting").Where("uid=?", uid).OrderAsc("id").Load(&models)
+	return models, err
+}
+
+func (d *dataExportDB) queryLoginLogs(uid string) ([]*LoginLogModel, error) {
+	var models []*LoginLogModel
+	_, err := d.session.Select("*").From("login_log").Where("uid=?", uid).OrderAsc("id").Load(&models)
+	return models, err
+}
+
+type dataExportModel struct {
+	ExportNo   string
+	UID        string
+	Status     int
+	Total      int
+	Processed  int
+	FilePath   string
+	FileSize   int64
+	FailReason string
+	FinishedAt int64
+	db.BaseModel
+}
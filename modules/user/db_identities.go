@@ -23,6 +23,27 @@ func (i *identitieDB) saveOrUpdateTx(m *identitiesModel, tx *dbr.Tx) error {
 	return err
 }
 
+// 上传新的身份公钥和签名key（覆盖旧的）
+func (i *identitieDB) insertOrUpdateTx(m *identitiesModel, tx *dbr.Tx) error {
+	_, err := tx.InsertBySql("insert into signal_identities(uid,identity_key,signed_prekey_id,signed_pubkey,signed_signature,registration_id) values(?,?,?,?,?,?) ON DUPLICATE KEY UPDATE identity_key=VALUES(identity_key),signed_prekey_id=VALUES(signed_prekey_id),signed_pubkey=VALUES(signed_pubkey),signed_signature=VALUES(signed_signature),registration_id=VALUES(registration_id),updated_at=NOW()", m.UID, m.IdentityKey, m.SignedPrekeyID, m.SignedPubkey, m.SignedSignature, m.RegistrationID).Exec()
+	return err
+}
+
+// 更换签名key
+func (i *identitieDB) updateSignedPrekey(uid string, signedPrekeyID int, signedPubkey string, signedSignature string) (bool, error) {
+	result, err := i.session.Update("signal_identities").SetMap(map[string]interface{}{
+		"signed_prekey_id": signedPrekeyID,
+		"signed_pubkey":    signedPubkey,
+		"signed_signature": signedSignature,
+		"updated_at":       dbr.Expr("NOW()"),
+	}).Where("uid=?", uid).Exec()
+	if err != nil {
+		return false, err
+	}
+	rows, err := result.RowsAffected()
+	return rows > 0, err
+}
+
 func (i *identitieDB) deleteWithUID(uid string) error {
 	_, err := i.session.DeleteFrom("signal_identities").Where("uid=?", uid).Exec()
 	return err
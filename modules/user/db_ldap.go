@@ -0,0 +1,133 @@
+package user
+
+import (
+	"github.com/TangSengDaoDao/TangSengDaoDaoServerLib/config"
+	"github.com/TangSengDaoDao/TangSengDaoDaoServerLib/pkg/db"
+	"github.com/TangSengDaoDao/TangSengDaoDaoServerLib/pkg/util"
+	"github.com/gocraft/dbr/v2"
+)
+
+type ldapDB struct {
+	session *dbr.Session
+	ctx     *config.Context
+}
+
+func newLDAPDB(ctx *config.Context) *ldapDB {
+	return &ldapDB{
+		session: ctx.DB(),
+		ctx:     ctx,
+	}
+}
+
+func (d *ldapDB) queryConfig() (*ldapConfigModel, error) {
+	var model *ldapConfigModel
+	_, err := d.session.Select("*").From("ldap_config").OrderDir("id", true).Limit(1).Load(&model)
+	return model, err
+}
+
+func (d *ldapDB) insertConfig(m *ldapConfigModel) error {
+	_, err := d.session.InsertInto("ldap_config").Columns(util.AttrToUnderscore(m)...).Record(m).Exec()
+	return err
+}
+
+func (d *ldapDB) updateConfig(m *ldapConfigModel) error {
+	_, err := d.session.Update("ldap_config").SetMap(map[string]interface{}{
+		"status":               m.Status,
+		"url":                  m.URL,
+		"start_tls":            m.StartTLS,
+		"insecure_skip_verify": m.InsecureSkipVerify,
+		"bind_dn":              m.BindDN,
+		"bind_password":        m.BindPassword,
+		"base_dn":              m.BaseDN,
+		"user_filter":          m.UserFilter,
+		"username_attr":        m.UsernameAttr,
+		"name_attr":            m.NameAttr,
+		"email_attr":           m.EmailAttr,
+		"sync_on":              m.SyncOn,
+		"sync_filter":          m.SyncFilter,
+		"sync_interval":        m.SyncInterval,
+		"group_member_attr":    m.GroupMemberAttr,
+		"updated_at":           dbr.Expr("NOW()"),
+	}).Where("id=?", m.Id).Exec()
+	return err
+}
+
+func (d *ldapDB) insertGroup(m *ldapGroupModel) error {
+	_, err := d.session.InsertInto("ldap_group").Columns(util.AttrToUnderscore(m)...).Record(m).Exec()
+	return err
+}
+
+func (d *ldapDB) deleteGroup(id int64) error {
+	_, err := d.session.DeleteFrom("ldap_group").Where("id=?", id).Exec()
+	return err
+}
+
+func (d *ldapDB) queryGroups() ([]*ldapGroupModel, error) {
+	var models []*ldapGroupModel
+	_, err := d.session.Select("*").From("ldap_group").OrderDir("id", true).Load(&models)
+	return models, err
+}
+
+func (d *ldapDB) existGroup(groupDN string, groupNo string) (bool, error) {
+	var count int
+	_, err := d.session.Select("count(*)").From("ldap_group").Where("group_dn=? and group_no=?", groupDN, groupNo).Load(&count)
+	return count > 0, err
+}
+
+// 查询通过目录登录过的用户
+func (d *ldapDB) queryIdentities() ([]*userIdentityModel, error) {
+	var models []*userIdentityModel
+	_, err := d.session.Select("*").From("user_identity").Where("provider=?", ldapProvider).Load(&models)
+	return models, err
+}
+
+func (d *ldapDB) updateIdentitySyncDisabled(id int64, syncDisabled int) error {
+	_, err := d.session.Update("user_identity").SetMap(map[string]interface{}{
+		"sync_disabled": syncDisabled,
+		"updated_at":    dbr.Expr("NOW()"),
+	}).Where("id=?", id).Exec()
+	return err
+}
+
+// 查询群的有效成员
+func (d *ldapDB) queryGroupMembers(groupNo string) ([]*ldapGroupMemberModel, error) {
+	var models []*ldapGroupMemberModel
+	_, err := d.session.Select("uid,role").From("group_member").Where("group_no=? and is_deleted=0", groupNo).Load(&models)
+	return models, err
+}
+
+func (d *ldapDB) existGroupNo(groupNo string) (bool, error) {
+	var count int
+	_, err := d.session.Select("count(*)").From("`group`").Where("group_no=?", groupNo).Load(&count)
+	return count > 0, err
+}
+
+type ldapConfigModel struct {
+	Status             int
+	URL                string
+	StartTLS           int
+	InsecureSkipVerify int
+	BindDN             string
+	BindPassword       string
+	BaseDN             string
+	UserFilter         string
+	UsernameAttr       string
+	NameAttr           string
+	EmailAttr          string
+	SyncOn             int
+	SyncFilter         string
+	SyncInterval       int
+	GroupMemberAttr    string
+	db.BaseModel
+}
+
+type ldapGroupModel struct {
+	GroupDN string
+	GroupNo string
+	db.BaseModel
+}
+
+type ldapGroupMemberModel struct {
+	UID  string
+	Role int // 成员角色 0.普通成员 1.群主 2.管理员
+}
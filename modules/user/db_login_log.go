@@ -27,16 +27,33 @@ func (l *LoginLogDB) insert(m *LoginLogModel) error {
 // queryLastLoginIP 查询最后一次登录日志
 func (l *LoginLogDB) queryLastLoginIP(uid string) (*LoginLogModel, error) {
 	var model *LoginLogModel
-	_, err := l.session.Select("*").From("login_log").Where("uid=?", uid).OrderDir("created_at", false).Limit(1).Load(&model)
+	_, err := l.session.Select("*").From("login_log").Where("uid=? and type=?", uid, loginLogTypeLogin).OrderDir("created_at", false).Limit(1).Load(&model)
 	if err != nil {
 		return nil, err
 	}
 	return model, nil
 }
 
+// queryEventsWithPage 分页查询锁定和解锁记录
+func (l *LoginLogDB) queryEventsWithPage(pageSize, page uint64) ([]*LoginLogModel, error) {
+	var models []*LoginLogModel
+	_, err := l.session.Select("*").From("login_log").Where("type in ?", []int{loginLogTypeLock, loginLogTypeUnlock}).Offset((page-1)*pageSize).Limit(pageSize).OrderDir("id", false).Load(&models)
+	return models, err
+}
+
+// queryEventCount 锁定和解锁记录数量
+func (l *LoginLogDB) queryEventCount() (int64, error) {
+	var count int64
+	_, err := l.session.Select("count(*)").From("login_log").Where("type in ?", []int{loginLogTypeLock, loginLogTypeUnlock}).Load(&count)
+	return count, err
+}
+
 // LoginLogModel 登录日志
 type LoginLogModel struct {
 	LoginIP string //登录IP
 	UID     string
+	Type    int    // 类型 0.登录 1.锁定 2.解锁
+	Account string // 锁定或解锁的登录账号
+	Remark  string // 备注
 	db.BaseModel
 }
@@ -110,11 +110,13 @@ func (m *managerDB) deleteUserWithUIDAndRole(uid, role string) error {
 }
 
 type managerLoginModel struct {
-	Username string
-	UID      string
-	Name     string
-	Password string
-	Role     string
+	Username      string
+	UID           string
+	Name          string
+	Password      string
+	PasswordAlgo  string
+	PasswordReset int
+	Role          string
 }
 
 type managerUserModel struct {
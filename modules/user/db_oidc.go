@@ -0,0 +1,108 @@
+package user
+
+import (
+	"github.com/TangSengDaoDao/TangSengDaoDaoServerLib/config"
+	"github.com/TangSengDaoDao/TangSengDaoDaoServerLib/pkg/db"
+	"github.com/TangSengDaoDao/TangSengDaoDaoServerLib/pkg/util"
+	"github.com/gocraft/dbr/v2"
+)
+
+type oidcDB struct {
+	session *dbr.Session
+	ctx     *config.Context
+}
+
+func newOIDCDB(ctx *config.Context) *oidcDB {
+	return &oidcDB{
+		session: ctx.DB(),
+		ctx:     ctx,
+	}
+}
+
+func (d *oidcDB) insertProvider(m *oidcProviderModel) error {
+	_, err := d.session.InsertInto("oidc_provider").Columns(util.AttrToUnderscore(m)...).Record(m).Exec()
+	return err
+}
+
+func (d *oidcDB) updateProvider(m *oidcProviderModel) error {
+	_, err := d.session.Update("oidc_provider").SetMap(map[string]interface{}{
+		"name":          m.Name,
+		"icon":          m.Icon,
+		"issuer":        m.Issuer,
+		"client_id":     m.ClientID,
+		"client_secret": m.ClientSecret,
+		"scopes":        m.Scopes,
+		"name_claim":    m.NameClaim,
+		"avatar_claim":  m.AvatarClaim,
+		"phone_claim":   m.PhoneClaim,
+		"phone_zone":    m.PhoneZone,
+		"status":        m.Status,
+		"updated_at":    dbr.Expr("NOW()"),
+	}).Where("provider=?", m.Provider).Exec()
+	return err
+}
+
+func (d *oidcDB) deleteProvider(provider string) error {
+	_, err := d.session.DeleteFrom("oidc_provider").Where("provider=?", provider).Exec()
+	return err
+}
+
+func (d *oidcDB) queryProvider(provider string) (*oidcProviderModel, error) {
+	var model *oidcProviderModel
+	_, err := d.session.Select("*").From("oidc_provider").Where("provider=?", provider).Load(&model)
+	return model, err
+}
+
+func (d *oidcDB) queryProviders() ([]*oidcProviderModel, error) {
+	var models []*oidcProviderModel
+	_, err := d.session.Select("*").From("oidc_provider").OrderDir("id", true).Load(&models)
+	return models, err
+}
+
+func (d *oidcDB) queryEnabledProviders() ([]*oidcProviderModel, error) {
+	var models []*oidcProviderModel
+	_, err := d.session.Select("*").From("oidc_provider").Where("status=1").OrderDir("id", true).Load(&models)
+	return models, err
+}
+
+func (d *oidcDB) insertIdentityTx(m *userIdentityModel, tx *dbr.Tx) error {
+	_, err := tx.InsertInto("user_identity").Columns(util.AttrToUnderscore(m)...).Record(m).Exec()
+	return err
+}
+
+func (d *oidcDB) queryIdentity(provider string, subject string) (*userIdentityModel, error) {
+	var model *userIdentityModel
+	_, err := d.session.Select("*").From("user_identity").Where("provider=? and subject=?", provider, subject).Load(&model)
+	return model, err
+}
+
+func (d *oidcDB) queryIdentityWithUID(provider string, uid string) (*userIdentityModel, error) {
+	var model *userIdentityModel
+	_, err := d.session.Select("*").From("user_identity").Where("provider=? and uid=?", provider, uid).Limit(1).Load(&model)
+	return model, err
+}
+
+type oidcProviderModel struct {
+	Provider     string
+	Name         string
+	Icon         string
+	Issuer       string
+	ClientID     string
+	ClientSecret string
+	Scopes       string
+	NameClaim    string
+	AvatarClaim  string
+	PhoneClaim   string
+	PhoneZone    string
+	Status       int
+	db.BaseModel
+}
+
+type userIdentityModel struct {
+	Provider     string
+	Subject      string
+	UID          string
+	Email        string
+	SyncDisabled int // 是否因目录中已删除而被同步禁用
+	db.BaseModel
+}
@@ -24,6 +24,39 @@ func (o *onetimePrekeysDB) insertTx(m *onetimePrekeysModel, tx *dbr.Tx) error {
 	return err
 }
 
+// 补充一次性公钥（key_id已存在的忽略）
+func (o *onetimePrekeysDB) insertIgnoreTx(uid string, keyID int, pubkey string, tx *dbr.Tx) error {
+	_, err := tx.InsertBySql("insert ignore into signal_onetime_prekeys(uid,key_id,pubkey) values(?,?,?)", uid, keyID, pubkey).Exec()
+	return err
+}
+
+func (o *onetimePrekeysDB) deleteWithUIDTx(uid string, tx *dbr.Tx) error {
+	_, err := tx.DeleteFrom("signal_onetime_prekeys").Where("uid=?", uid).Exec()
+	return err
+}
+
+// 取出并删除用户最小的onetimePreKey，并发获取时每个key只会被一个请求拿到，没有可用的key时返回nil
+func (o *onetimePrekeysDB) consumeMinWithUID(uid string) (*onetimePrekeysModel, error) {
+	for {
+		m, err := o.queryMinWithUID(uid)
+		if err != nil || m == nil {
+			return nil, err
+		}
+		result, err := o.session.DeleteFrom("signal_onetime_prekeys").Where("id=?", m.Id).Exec()
+		if err != nil {
+			return nil, err
+		}
+		rows, err := result.RowsAffected()
+		if err != nil {
+			return nil, err
+		}
+		if rows > 0 {
+			return m, nil
+		}
+		// 已被其他请求取走，继续取下一个
+	}
+}
+
 func (o *onetimePrekeysDB) delete(uid string, keyID int) error {
 	_, err := o.session.DeleteFrom("signal_onetime_prekeys").Where("uid=? and key_id=?", uid, keyID).Exec()
 	return err
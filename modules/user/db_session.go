@@ -0,0 +1,115 @@
+package user
+
+import (
+	"time"
+
+	"github.com/TangSengDaoDao/TangSengDaoDaoServerLib/config"
+	"github.com/TangSengDaoDao/TangSengDaoDaoServerLib/pkg/db"
+	"github.com/TangSengDaoDao/TangSengDaoDaoServerLib/pkg/util"
+	"github.com/gocraft/dbr/v2"
+)
+
+type sessionDB struct {
+	session *dbr.Session
+	ctx     *config.Context
+}
+
+func newSessionDB(ctx *config.Context) *sessionDB {
+	return &sessionDB{
+		session: ctx.DB(),
+		ctx:     ctx,
+	}
+}
+
+func (d *sessionDB) insert(m *sessionModel) error {
+	_, err := d.session.InsertInto("user_session").Columns(util.AttrToUnderscore(m)...).Record(m).Exec()
+	return err
+}
+
+func (d *sessionDB) queryWithSessionID(sessionID string) (*sessionModel, error) {
+	var model *sessionModel
+	_, err := d.session.Select("*").From("user_session").Where("session_id=?", sessionID).Load(&model)
+	return model, err
+}
+
+func (d *sessionDB) queryWithRefreshTokenHash(refreshTokenHash string) (*sessionModel, error) {
+	var model *sessionModel
+	_, err := d.session.Select("*").From("user_session").Where("refresh_token_hash=?", refreshTokenHash).Load(&model)
+	return model, err
+}
+
+func (d *sessionDB) queryWithAccessToken(accessToken string) (*sessionModel, error) {
+	var model *sessionModel
+	_, err := d.session.Select("*").From("user_session").Where("access_token=? and revoked=0", accessToken).Load(&model)
+	return model, err
+}
+
+// 查询用户有效的会话
+func (d *sessionDB) queryActiveWithUID(uid string) ([]*sessionModel, error) {
+	var models []*sessionModel
+	_, err := d.session.Select("*").From("user_session").Where("uid=? and revoked=0 and refresh_expire_at>?", uid, time.Now().Unix()).OrderDir("last_seen_at", false).Load(&models)
+	return models, err
+}
+
+// 查询用户某类设备有效的会话
+func (d *sessionDB) queryActiveWithUIDAndFlag(uid string, deviceFlag uint8) ([]*sessionModel, error) {
+	var models []*sessionModel
+	_, err := d.session.Select("*").From("user_session").Where("uid=? and device_flag=? and revoked=0 and refresh_expire_at>?", uid, deviceFlag, time.Now().Unix()).Load(&models)
+	return models, err
+}
+
+// 轮换refresh token，旧的refresh token已被使用（并发刷新）时返回false
+func (d *sessionDB) rotateToken(sessionID string, oldRefreshTokenHash string, m *sessionModel) (bool, error) {
+	result, err := d.session.Update("user_session").SetMap(map[string]interface{}{
+		"access_token":       m.AccessToken,
+		"refresh_token_hash": m.RefreshTokenHash,
+		"refresh_expire_at":  m.RefreshExpireAt,
+		"ip":                 m.IP,
+		"user_agent":         m.UserAgent,
+		"last_seen_at":       m.LastSeenAt,
+		"updated_at":         dbr.Expr("NOW()"),
+	}).Where("session_id=? and refresh_token_hash=? and revoked=0", sessionID, oldRefreshTokenHash).Exec()
+	if err != nil {
+		return false, err
+	}
+	rows, err := result.RowsAffected()
+	return rows > 0, err
+}
+
+// 更新会话的最后活跃时间
+func (d *sessionDB) updateLastSeenAt(accessToken string, lastSeenAt int64) error {
+	_, err := d.session.Update("user_session").Set("last_seen_at", lastSeenAt).Where("access_token=? and revoked=0", accessToken).Exec()
+	return err
+}
+
+// 撤销会话，会话已被撤销时返回false
+func (d *sessionDB) revoke(sessionID string) (bool, error) {
+	result, err := d.session.Update("user_session").SetMap(map[string]interface{}{
+		"revoked":    1,
+		"revoked_at": time.Now().Unix(),
+		"updated_at": dbr.Expr("NOW()"),
+	}).Where("session_id=? and revoked=0", sessionID).Exec()
+	if err != nil {
+		return false, err
+	}
+	rows, err := result.RowsAffected()
+	return rows > 0, err
+}
+
+type sessionModel struct {
+	SessionID        string
+	UID              string
+	DeviceFlag       uint8
+	DeviceID         string
+	DeviceName       string
+	DeviceModel      string
+	IP               string
+	UserAgent        string
+	AccessToken      string
+	RefreshTokenHash string
+	RefreshExpireAt  int64
+	LastSeenAt       int64
+	Revoked          int
+	RevokedAt        int64
+	db.BaseModel
+}
@@ -0,0 +1,115 @@
+package user
+
+import (
+	"time"
+
+	"github.com/TangSengDaoDao/TangSengDaoDaoServerLib/config"
+	"github.com/TangSengDaoDao/TangSengDaoDaoServerLib/pkg/db"
+	"github.com/gocraft/dbr/v2"
+)
+
+type totpDB struct {
+	session *dbr.Session
+	ctx     *config.Context
+}
+
+func newTOTPDB(ctx *config.Context) *totpDB {
+	return &totpDB{
+		session: ctx.DB(),
+		ctx:     ctx,
+	}
+}
+
+func (d *totpDB) queryWithUID(uid string) (*totpModel, error) {
+	var model *totpModel
+	_, err := d.session.Select("*").From("user_totp").Where("uid=?", uid).Load(&model)
+	return model, err
+}
+
+// 开始绑定（已开启的不覆盖）
+func (d *totpDB) insertOrUpdatePending(uid string, secret string) error {
+	_, err := d.session.InsertBySql("INSERT INTO user_totp (uid,secret,status,last_step) VALUES (?,?,0,0) ON DUPLICATE KEY UPDATE secret=IF(status=1,secret,VALUES(secret)),last_step=IF(status=1,last_step,0)", uid, secret).Exec()
+	return err
+}
+
+// 绑定验证通过后开启，同时重新生成恢复码
+func (d *totpDB) enableTx(uid string, lastStep int64, codeHashes []string, tx *dbr.Tx) (bool, error) {
+	result, err := tx.Update("user_totp").SetMap(map[string]interface{}{
+		"status":     1,
+		"last_step":  lastStep,
+		"enabled_at": time.Now().Unix(),
+		"updated_at": dbr.Expr("NOW()"),
+	}).Where("uid=? and status=0", uid).Exec()
+	if err != nil {
+		return false, err
+	}
+	rows, err := result.RowsAffected()
+	if err != nil || rows == 0 {
+		return false, err
+	}
+	return true, d.insertRecoveryCodesTx(uid, codeHashes, tx)
+}
+
+// 记录已使用的验证码周期，周期没有前进（验证码已被使用）时返回false
+func (d *totpDB) updateLastStep(uid string, step int64) (bool, error) {
+	result, err := d.session.Update("user_totp").Set("last_step", step).Set("updated_at", dbr.Expr("NOW()")).Where("uid=? and status=1 and last_step<?", uid, step).Exec()
+	if err != nil {
+		return false, err
+	}
+	rows, err := result.RowsAffected()
+	return rows > 0, err
+}
+
+// 关闭两步验证
+func (d *totpDB) deleteTx(uid string, tx *dbr.Tx) error {
+	_, err := tx.DeleteFrom("user_totp").Where("uid=?", uid).Exec()
+	if err != nil {
+		return err
+	}
+	_, err = tx.DeleteFrom("user_totp_recovery_code").Where("uid=?", uid).Exec()
+	return err
+}
+
+// 重新生成恢复码（旧的恢复码失效）
+func (d *totpDB) insertRecoveryCodesTx(uid string, codeHashes []string, tx *dbr.Tx) error {
+	_, err := tx.DeleteFrom("user_totp_recovery_code").Where("uid=?", uid).Exec()
+	if err != nil {
+		return err
+	}
+	for _, codeHash := range codeHashes {
+		_, err = tx.InsertInto("user_totp_recovery_code").Columns("uid", "code_hash").Values(uid, codeHash).Exec()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// 使用恢复码，恢复码不存在或已使用时返回false
+func (d *totpDB) useRecoveryCode(uid string, codeHash string) (bool, error) {
+	result, err := d.session.Update("user_totp_recovery_code").SetMap(map[string]interface{}{
+		"used":       1,
+		"used_at":    time.Now().Unix(),
+		"updated_at": dbr.Expr("NOW()"),
+	}).Where("uid=? and code_hash=? and used=0", uid, codeHash).Exec()
+	if err != nil {
+		return false, err
+	}
+	rows, err := result.RowsAffected()
+	return rows > 0, err
+}
+
+func (d *totpDB) queryUnusedRecoveryCount(uid string) (int, error) {
+	var count int
+	_, err := d.session.Select("count(*)").From("user_totp_recovery_code").Where("uid=? and used=0", uid).Load(&count)
+	return count, err
+}
+
+type totpModel struct {
+	UID       string
+	Secret    string
+	Status    int
+	LastStep  int64
+	EnabledAt int64
+	db.BaseModel
+}
@@ -0,0 +1,90 @@
+package user
+
+import (
+	"time"
+
+	"github.com/TangSengDaoDao/TangSengDaoDaoServerLib/config"
+	"github.com/TangSengDaoDao/TangSengDaoDaoServerLib/pkg/db"
+	"github.com/TangSengDaoDao/TangSengDaoDaoServerLib/pkg/util"
+	"github.com/gocraft/dbr/v2"
+)
+
+type webauthnDB struct {
+	session *dbr.Session
+	ctx     *config.Context
+}
+
+func newWebauthnDB(ctx *config.Context) *webauthnDB {
+	return &webauthnDB{
+		session: ctx.DB(),
+		ctx:     ctx,
+	}
+}
+
+func (d *webauthnDB) insert(m *webauthnCredentialModel) error {
+	_, err := d.session.InsertInto("user_webauthn_credential").Columns(util.AttrToUnderscore(m)...).Record(m).Exec()
+	return err
+}
+
+func (d *webauthnDB) queryWithHash(credentialHash string) (*webauthnCredentialModel, error) {
+	var model *webauthnCredentialModel
+	_, err := d.session.Select("*").From("user_webauthn_credential").Where("credential_hash=?", credentialHash).Load(&model)
+	return model, err
+}
+
+func (d *webauthnDB) queryWithUID(uid string) ([]*webauthnCredentialModel, error) {
+	var models []*webauthnCredentialModel
+	_, err := d.session.Select("*").From("user_webauthn_credential").Where("uid=?", uid).OrderDir("id", true).Load(&models)
+	return models, err
+}
+
+func (d *webauthnDB) queryWithIDAndUID(id int64, uid string) (*webauthnCredentialModel, error) {
+	var model *webauthnCredentialModel
+	_, err := d.session.Select("*").From("user_webauthn_credential").Where("id=? and uid=?", id, uid).Load(&model)
+	return model, err
+}
+
+func (d *webauthnDB) updateName(id int64, name string) error {
+	_, err := d.session.Update("user_webauthn_credential").SetMap(map[string]interface{}{
+		"name":       name,
+		"updated_at": dbr.Expr("NOW()"),
+	}).Where("id=?", id).Exec()
+	return err
+}
+
+// 登录成功后更新签名计数（计数不是期望的旧值时说明凭证被并发使用，返回false）
+func (d *webauthnDB) updateUsed(id int64, oldSignCount uint32, signCount uint32) (bool, error) {
+	result, err := d.session.Update("user_webauthn_credential").SetMap(map[string]interface{}{
+		"sign_count":   signCount,
+		"last_used_at": time.Now().Unix(),
+		"updated_at":   dbr.Expr("NOW()"),
+	}).Where("id=? and sign_count=?", id, oldSignCount).Exec()
+	if err != nil {
+		return false, err
+	}
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return rows > 0, nil
+}
+
+func (d *webauthnDB) delete(id int64) error {
+	_, err := d.session.DeleteFrom("user_webauthn_credential").Where("id=?", id).Exec()
+	return err
+}
+
+type webauthnCredentialModel struct {
+	UID            string
+	CredentialID   string // base64url
+	CredentialHash string
+	PublicKey      string // COSE格式的公钥 base64url
+	Algorithm      int
+	SignCount      uint32
+	AAGUID         string
+	Transports     string
+	BackupEligible int
+	Name           string
+	LastUsedAt     int64
+	db.BaseModel
+}
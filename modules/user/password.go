@@ -0,0 +1,50 @@
+package user
+
+import (
+	"errors"
+
+	"github.com/TangSengDaoDao/TangSengDaoDaoServer/pkg/password"
+	"github.com/TangSengDaoDao/TangSengDaoDaoServerLib/pkg/log"
+	"go.uber.org/zap"
+)
+
+// ErrPasswordNeedReset 管理员要求重置密码后才能登录
+var ErrPasswordNeedReset = errors.New("密码已失效，请通过忘记密码重新设置！")
+
+// 校验用户密码，旧算法的哈希校验通过后自动升级为默认算法（已被要求重置密码的用户不升级，须通过重置密码更换）
+func verifyPassword(d *DB, uid string, pwd string, hash string, algo string, passwordReset int) (bool, error) {
+	ok, err := password.Verify(pwd, hash, algo)
+	if err != nil || !ok {
+		return false, err
+	}
+	if passwordReset != 1 && password.NeedsRehash(algo) {
+		newHash, newAlgo, err := password.Hash(pwd)
+		if err == nil {
+			err = d.rehashPassword(uid, hash, newHash, newAlgo)
+		}
+		if err != nil {
+			log.Warn("升级密码哈希失败！", zap.Error(err), zap.String("uid", uid))
+		}
+	}
+	return true, nil
+}
+
+// 使用默认算法修改密码
+func updatePassword(d *DB, uid string, pwd string) error {
+	hash, algo, err := password.Hash(pwd)
+	if err != nil {
+		return err
+	}
+	return d.updatePassword(hash, algo, uid)
+}
+
+// 设置新用户的密码
+func setModelPassword(m *Model, pwd string) error {
+	hash, algo, err := password.Hash(pwd)
+	if err != nil {
+		return err
+	}
+	m.Password = hash
+	m.PasswordAlgo = algo
+	return nil
+}
@@ -78,6 +78,8 @@ type IService interface {
 	UpdateUserMsgExpireSecond(uid string, msgExpireSecond int64) error
 	// 搜索好友
 	SearchFriendsWithKeyword(uid string, keyword string) ([]*FriendResp, error)
+	// 增加用户某个分类的红点数量
+	AddRedDot(uids []string, category string) error
 }
 
 // Service Service
@@ -406,7 +408,10 @@ func (s *Service) AddUser(user *AddUserReq) error {
 		Status:   1,
 	}
 	if user.Password != "" {
-		userM.Password = util.MD5(util.MD5(user.Password))
+		if err := setModelPassword(userM, user.Password); err != nil {
+			s.Error("生成密码哈希失败！", zap.Error(err))
+			return err
+		}
 	}
 
 	err := s.db.Insert(userM)
@@ -658,11 +663,16 @@ func (s *Service) UpdateLoginPassword(req UpdateLoginPasswordReq) error {
 	if userM == nil {
 		return errors.New("用户不存在！")
 	}
-	if util.MD5(util.MD5(req.Password)) != userM.Password {
+	ok, err := verifyPassword(s.db, userM.UID, req.Password, userM.Password, userM.PasswordAlgo, userM.PasswordReset)
+	if err != nil {
+		s.Error("校验密码失败！", zap.Error(err))
+		return errors.New("校验密码失败！")
+	}
+	if !ok {
 		return errors.New("原密码不正确！")
 	}
 
-	err = s.db.updatePassword(util.MD5(util.MD5(req.NewPassword)), req.UID)
+	err = updatePassword(s.db, req.UID, req.NewPassword)
 	if err != nil {
 		return errors.New("更新密码失败！")
 	}
@@ -726,6 +736,32 @@ func (s *Service) UpdateUserMsgExpireSecond(uid string, msgExpireSecond int64) e
 	return s.db.updateUserMsgExpireSecond(uid, msgExpireSecond)
 }
 
+// AddRedDot 增加用户某个分类的红点数量
+func (s *Service) AddRedDot(uids []string, category string) error {
+	for _, uid := range uids {
+		userRedDot, err := s.db.queryUserRedDot(uid, category)
+		if err != nil {
+			s.Error("查询用户红点错误", zap.Error(err), zap.String("uid", uid))
+			return err
+		}
+		if userRedDot == nil {
+			err = s.db.insertUserRedDot(&userRedDotModel{
+				UID:      uid,
+				Count:    1,
+				Category: category,
+			})
+		} else {
+			userRedDot.Count++
+			err = s.db.updateUserRedDot(userRedDot)
+		}
+		if err != nil {
+			s.Error("修改用户红点错误", zap.Error(err), zap.String("uid", uid))
+			return err
+		}
+	}
+	return nil
+}
+
 // 搜索好友
 func (s *Service) SearchFriendsWithKeyword(uid string, keyword string) ([]*FriendResp, error) {
 	friends, err := s.friendDB.QueryFriendsWithKeyword(uid, keyword)
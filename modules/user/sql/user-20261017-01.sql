@@ -0,0 +1,7 @@
+-- +migrate Up
+
+-- 密码哈希升级（argon2id/bcrypt哈希比md5长）
+ALTER TABLE `user` MODIFY COLUMN password VARCHAR(255) NOT NULL DEFAULT '' COMMENT '密码哈希';
+ALTER TABLE `user` ADD COLUMN password_algo VARCHAR(20) NOT NULL DEFAULT 'md5' COMMENT '密码哈希算法 md5/bcrypt/argon2id';
+ALTER TABLE `user` ADD COLUMN password_reset smallint NOT NULL DEFAULT 0 COMMENT '是否需要重置密码后才能登录 0.否 1.是';
+CREATE INDEX `user_password_algo_idx` on `user` (`password_algo`);
@@ -0,0 +1,27 @@
+-- +migrate Up
+
+-- 登录会话（每个设备登录一个会话，access token短期有效，通过refresh token续期）
+create table `user_session`
+(
+  id                 bigint         not null primary key AUTO_INCREMENT,
+  session_id         VARCHAR(40)    not null default '',                -- 会话唯一ID
+  uid                VARCHAR(40)    not null default '',                -- 用户uid
+  device_flag        smallint       not null default 0,                 -- 设备标记 0.app 1.web 2.pc
+  device_id          VARCHAR(100)   not null default '',                -- 设备唯一ID
+  device_name        VARCHAR(100)   not null default '',                -- 设备名称
+  device_model       VARCHAR(100)   not null default '',                -- 设备型号
+  ip                 VARCHAR(100)   not null default '',                -- 最后一次访问的IP
+  user_agent         VARCHAR(255)   not null default '',                -- 最后一次访问的UA
+  access_token       VARCHAR(40)    not null default '',                -- 当前的access token
+  refresh_token_hash VARCHAR(64)    not null default '',                -- 当前refresh token的sha256
+  refresh_expire_at  bigint         not null default 0,                 -- refresh token过期时间 10位时间戳
+  last_seen_at       bigint         not null default 0,                 -- 最后活跃时间 10位时间戳
+  revoked            smallint       not null default 0,                 -- 是否已撤销 0.否 1.是
+  revoked_at         bigint         not null default 0,                 -- 撤销时间 10位时间戳
+  created_at         timeStamp      not null DEFAULT CURRENT_TIMESTAMP, -- 创建时间
+  updated_at         timeStamp      not null DEFAULT CURRENT_TIMESTAMP  -- 更新时间
+);
+CREATE UNIQUE INDEX `user_session_session_id_uidx` on `user_session` (`session_id`);
+CREATE UNIQUE INDEX `user_session_refresh_uidx` on `user_session` (`refresh_token_hash`);
+CREATE INDEX `user_session_uid_idx` on `user_session` (`uid`, `revoked`);
+CREATE INDEX `user_session_access_token_idx` on `user_session` (`access_token`);
@@ -0,0 +1,36 @@
+-- +migrate Up
+
+-- OpenID Connect身份提供方（后台配置）
+create table `oidc_provider`
+(
+  id            bigint         not null primary key AUTO_INCREMENT,
+  provider      VARCHAR(40)    not null default '',                -- 提供方标识（用于回调地址）
+  name          VARCHAR(100)   not null default '',                -- 显示名称
+  icon          VARCHAR(255)   not null default '',                -- 图标地址
+  issuer        VARCHAR(255)   not null default '',                -- issuer地址（通过/.well-known/openid-configuration发现）
+  client_id     VARCHAR(255)   not null default '',                -- 客户端ID
+  client_secret VARCHAR(255)   not null default '',                -- 客户端密钥（公开客户端为空，仅使用PKCE）
+  scopes        VARCHAR(255)   not null default 'openid profile',  -- 申请的scope，空格分隔
+  name_claim    VARCHAR(100)   not null default 'name',            -- 用户名称对应的声明
+  avatar_claim  VARCHAR(100)   not null default 'picture',         -- 头像对应的声明
+  phone_claim   VARCHAR(100)   not null default '',                -- 手机号对应的声明（为空不同步手机号）
+  phone_zone    VARCHAR(10)    not null default '0086',            -- 手机号不带区号时使用的默认区号
+  status        smallint       not null default 1,                 -- 状态 0.禁用 1.启用
+  created_at    timeStamp      not null DEFAULT CURRENT_TIMESTAMP, -- 创建时间
+  updated_at    timeStamp      not null DEFAULT CURRENT_TIMESTAMP  -- 更新时间
+);
+CREATE UNIQUE INDEX `oidc_provider_provider_uidx` on `oidc_provider` (`provider`);
+
+-- 第三方身份与用户的绑定
+create table `user_identity`
+(
+  id         bigint         not null primary key AUTO_INCREMENT,
+  provider   VARCHAR(40)    not null default '',                -- 提供方标识
+  subject    VARCHAR(255)   not null default '',                -- 提供方的用户唯一标识（sub）
+  uid        VARCHAR(40)    not null default '',                -- 用户uid
+  email      VARCHAR(255)   not null default '',                -- 绑定时的邮箱
+  created_at timeStamp      not null DEFAULT CURRENT_TIMESTAMP, -- 创建时间
+  updated_at timeStamp      not null DEFAULT CURRENT_TIMESTAMP  -- 更新时间
+);
+CREATE UNIQUE INDEX `user_identity_provider_subject_uidx` on `user_identity` (`provider`, `subject`);
+CREATE INDEX `user_identity_uid_idx` on `user_identity` (`uid`);
@@ -0,0 +1,37 @@
+-- +migrate Up
+
+-- LDAP/AD目录认证配置（只有一条记录）
+create table `ldap_config`
+(
+  id                   bigint         not null primary key AUTO_INCREMENT,
+  status               smallint       not null default 0,                                        -- 是否开启目录认证 0.关闭 1.开启
+  url                  VARCHAR(255)   not null default '',                                       -- 目录地址 ldap://host:389 或 ldaps://host:636
+  start_tls            smallint       not null default 0,                                        -- ldap://连接是否升级为TLS
+  insecure_skip_verify smallint       not null default 0,                                        -- 是否跳过证书校验（仅测试环境使用）
+  bind_dn              VARCHAR(255)   not null default '',                                       -- 搜索用户使用的服务账号
+  bind_password        VARCHAR(255)   not null default '',                                       -- 服务账号密码
+  base_dn              VARCHAR(255)   not null default '',                                       -- 用户搜索的根节点
+  user_filter          VARCHAR(500)   not null default '(&(objectClass=person)(uid={username}))', -- 登录时查找用户的过滤器，{username}为登录名
+  username_attr        VARCHAR(100)   not null default 'uid',                                    -- 登录名属性（AD为sAMAccountName）
+  name_attr            VARCHAR(100)   not null default 'cn',                                     -- 用户名称属性
+  email_attr           VARCHAR(100)   not null default 'mail',                                   -- 邮箱属性
+  sync_on              smallint       not null default 0,                                        -- 是否定时同步
+  sync_filter          VARCHAR(500)   not null default '(objectClass=person)',                   -- 同步时查找有效用户的过滤器
+  sync_interval        integer        not null default 60,                                       -- 同步间隔（分钟）
+  group_member_attr    VARCHAR(100)   not null default 'member',                                 -- 目录组的成员属性（值为用户DN或登录名）
+  created_at           timeStamp      not null DEFAULT CURRENT_TIMESTAMP,                        -- 创建时间
+  updated_at           timeStamp      not null DEFAULT CURRENT_TIMESTAMP                         -- 更新时间
+);
+
+-- 目录组与群的映射
+create table `ldap_group`
+(
+  id         bigint         not null primary key AUTO_INCREMENT,
+  group_dn   VARCHAR(255)   not null default '',                -- 目录组DN
+  group_no   VARCHAR(40)    not null default '',                -- 群编号
+  created_at timeStamp      not null DEFAULT CURRENT_TIMESTAMP, -- 创建时间
+  updated_at timeStamp      not null DEFAULT CURRENT_TIMESTAMP  -- 更新时间
+);
+CREATE UNIQUE INDEX `ldap_group_group_dn_group_no_uidx` on `ldap_group` (`group_dn`, `group_no`);
+
+ALTER TABLE `user_identity` ADD COLUMN sync_disabled smallint not null DEFAULT 0 COMMENT '是否因目录中已删除而被同步禁用';
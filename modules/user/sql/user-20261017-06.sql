@@ -0,0 +1,6 @@
+-- +migrate Up
+
+ALTER TABLE `login_log` ADD COLUMN type smallint not null DEFAULT 0 COMMENT '类型 0.登录 1.锁定 2.解锁';
+ALTER TABLE `login_log` ADD COLUMN account VARCHAR(100) not null DEFAULT '' COMMENT '锁定或解锁的登录账号';
+ALTER TABLE `login_log` ADD COLUMN remark VARCHAR(255) not null DEFAULT '' COMMENT '备注';
+CREATE INDEX `login_log_type_idx` on `login_log` (`type`);
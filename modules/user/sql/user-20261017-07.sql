@@ -0,0 +1,22 @@
+-- +migrate Up
+
+-- 通行密钥（WebAuthn凭证）
+create table `user_webauthn_credential`
+(
+  id               bigint         not null primary key AUTO_INCREMENT,
+  uid              VARCHAR(40)    not null default '',                -- 用户uid
+  credential_id    VARCHAR(1400)  not null default '',                -- 凭证ID base64url
+  credential_hash  VARCHAR(64)    not null default '',                -- 凭证ID的sha256（凭证ID可能很长，用于唯一索引）
+  public_key       TEXT,                                              -- COSE格式的公钥 base64url
+  algorithm        int            not null default 0,                 -- 签名算法 -7.ES256 -8.EdDSA -257.RS256
+  sign_count       bigint         not null default 0,                 -- 签名计数
+  aaguid           VARCHAR(40)    not null default '',                -- 认证器型号
+  transports       VARCHAR(100)   not null default '',                -- 传输方式，多个用逗号分隔
+  backup_eligible  smallint       not null default 0,                 -- 是否可同步到其他设备 0.否 1.是
+  name             VARCHAR(100)   not null default '',                -- 凭证名称
+  last_used_at     bigint         not null default 0,                 -- 最后使用时间 10位时间戳
+  created_at       timeStamp      not null DEFAULT CURRENT_TIMESTAMP, -- 创建时间
+  updated_at       timeStamp      not null DEFAULT CURRENT_TIMESTAMP  -- 更新时间
+);
+CREATE UNIQUE INDEX `user_webauthn_credential_hash_uidx` on `user_webauthn_credential` (`credential_hash`);
+CREATE INDEX `user_webauthn_credential_uid_idx` on `user_webauthn_credential` (`uid`);
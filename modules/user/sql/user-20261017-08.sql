@@ -0,0 +1,21 @@
+-- +migrate Up
+
+-- 个人数据导出
+create table `user_data_export`
+(
+  id           bigint         not null primary key AUTO_INCREMENT,
+  export_no    VARCHAR(40)    not null default '',                -- 导出编号
+  uid          VARCHAR(40)    not null default '',                -- 用户uid
+  status       smallint       not null default 0,                 -- 状态 0.等待导出 1.导出中 2.已完成 3.导出失败
+  total        int            not null default 0,                 -- 需要导出的数据项数量
+  processed    int            not null default 0,                 -- 已导出的数据项数量
+  file_path    VARCHAR(255)   not null default '',                -- 归档文件路径
+  file_size    bigint         not null default 0,                 -- 归档文件大小
+  fail_reason  VARCHAR(255)   not null default '',                -- 失败原因
+  finished_at  bigint         not null default 0,                 -- 完成时间 10位时间戳
+  created_at   timeStamp      not null DEFAULT CURRENT_TIMESTAMP, -- 创建时间
+  updated_at   timeStamp      not null DEFAULT CURRENT_TIMESTAMP  -- 更新时间
+);
+CREATE UNIQUE INDEX `user_data_export_export_no_uidx` on `user_data_export` (`export_no`);
+CREATE INDEX `user_data_export_uid_idx` on `user_data_export` (`uid`);
+CREATE INDEX `user_data_export_status_idx` on `user_data_export` (`status`);
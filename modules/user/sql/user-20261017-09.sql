@@ -0,0 +1,5 @@
+-- +migrate Up
+
+-- 邮箱是否已验证（只有已验证的邮箱可以用于登录、找回密码和注销账号）
+ALTER TABLE `user` ADD COLUMN email_verified smallint not null default 0;
+CREATE INDEX user_email_idx on `user` (email);
@@ -13,6 +13,8 @@ import (
 	"strings"
 
 	"github.com/TangSengDaoDao/TangSengDaoDaoServer/modules/group"
+	"github.com/TangSengDaoDao/TangSengDaoDaoServer/modules/message/prohibit"
+	"github.com/TangSengDaoDao/TangSengDaoDaoServer/modules/message/revoke"
 	"github.com/TangSengDaoDao/TangSengDaoDaoServer/modules/user"
 	"github.com/TangSengDaoDao/TangSengDaoDaoServerLib/common"
 	"github.com/TangSengDaoDao/TangSengDaoDaoServerLib/config"
@@ -28,13 +30,15 @@ import (
 // Webhook Webhook
 type Webhook struct {
 	log.Log
-	ctx          *config.Context
-	supportTypes []common.ContentType
-	db           *DB
-	messageDB    *messageDB
-	pushMap      map[common.DeviceType]map[string]Push
-	groupService group.IService
-	userService  user.IService
+	ctx             *config.Context
+	supportTypes    []common.ContentType
+	db              *DB
+	messageDB       *messageDB
+	pushMap         map[common.DeviceType]map[string]Push
+	groupService    group.IService
+	userService     user.IService
+	prohibitService prohibit.IService
+	revokeService   revoke.IService
 	wkhook.UnimplementedWebhookServiceServer
 	grpcServer *grpc.Server
 }
@@ -84,14 +88,16 @@ func New(ctx *config.Context) *Webhook {
 		}
 	}
 	return &Webhook{
-		db:           NewDB(ctx.DB()),
-		supportTypes: supportTypes,
-		ctx:          ctx,
-		Log:          log.NewTLog("Webhook"),
-		pushMap:      pushMap,
-		messageDB:    newMessageDB(ctx),
-		groupService: group.NewService(ctx),
-		userService:  user.NewService(ctx),
+		db:              NewDB(ctx.DB()),
+		supportTypes:    supportTypes,
+		ctx:             ctx,
+		Log:             log.NewTLog("Webhook"),
+		pushMap:         pushMap,
+		messageDB:       newMessageDB(ctx),
+		groupService:    group.NewService(ctx),
+		userService:     user.NewService(ctx),
+		prohibitService: prohibit.NewService(ctx),
+		revokeService:   revoke.NewService(ctx),
 	}
 }
 func getSupportTypes() []common.ContentType {
@@ -173,6 +179,8 @@ func (w *Webhook) handleMessageNotify(messages []MsgResp) ([]string, error) {
 	}
 
 	confMessages := make([]*config.MessageResp, 0, len(messages))
+	prohibitMessages := make([]*prohibitMessage, 0)
+	slowModeMessages := make([]MsgResp, 0) // 违反群慢速模式的消息
 
 	tx, _ := w.ctx.DB().Begin()
 	defer func() {
@@ -193,14 +201,37 @@ func (w *Webhook) handleMessageNotify(messages []MsgResp) ([]string, error) {
 		}
 		messageM := message.toModel()
 		messageM.ChannelID = fakeChannelID
+		prohibitResult := w.checkProhibitWords(message)
+		if prohibitResult != nil {
+			prohibitMessages = append(prohibitMessages, &prohibitMessage{
+				message: message,
+				result:  prohibitResult,
+			})
+			if prohibitResult.Masked() && !prohibitResult.Blocked() {
+				message.Payload = []byte(util.ToJson(prohibitResult.MaskedPayload))
+				messageM.Payload = string(message.Payload)
+			}
+		}
 		err := w.messageDB.insertOrUpdateTx(messageM, tx)
 		if err != nil {
 			_ = tx.Rollback()
 			w.Error("插入消息失败！", zap.Error(err))
 			return nil, err
 		}
+		if prohibitResult != nil && prohibitResult.Blocked() { // 被拦截的消息不再通知监听者
+			continue
+		}
+		// 群开启了慢速模式的，发言成员开始冷却（按消息顺序逐条判断，冷却期间发送的消息撤回且不再通知监听者）
+		if message.ChannelType == common.ChannelTypeGroup.Uint8() && message.FromUID != "" && message.FromUID != w.ctx.GetConfig().Account.SystemUID {
+			allowed, err := w.groupService.StartSlowModeCooldown(message.ChannelID, message.FromUID, strconv.FormatInt(message.MessageID, 10), int64(message.Timestamp))
+			if err != nil {
+				w.Warn("开始慢速模式冷却失败！", zap.Error(err), zap.String("groupNo", message.ChannelID), zap.String("uid", message.FromUID))
+			} else if !allowed {
+				slowModeMessages = append(slowModeMessages, message)
+				continue
+			}
+		}
 		confMessages = append(confMessages, message.toConfigMessageResp())
-
 	}
 	if err := tx.Commit(); err != nil {
 		_ = tx.Rollback()
@@ -208,6 +239,28 @@ func (w *Webhook) handleMessageNotify(messages []MsgResp) ([]string, error) {
 		return nil, err
 	}
 
+	// 处理命中违禁词的消息
+	for _, prohibitMsg := range prohibitMessages {
+		err := w.prohibitService.HandleDeliveredMessage(prohibitMsg.deliveredMessage(), prohibitMsg.result)
+		if err != nil {
+			w.Error("处理违禁消息失败！", zap.Error(err), zap.Int64("messageID", prohibitMsg.message.MessageID))
+		}
+	}
+
+	// 撤回违反慢速模式的消息
+	for _, message := range slowModeMessages {
+		err := w.revokeService.RevokeWithSystem(&revoke.Message{
+			MessageID:   message.MessageID,
+			MessageSeq:  message.MessageSeq,
+			FromUID:     message.FromUID,
+			ChannelID:   message.ChannelID,
+			ChannelType: message.ChannelType,
+		})
+		if err != nil {
+			w.Error("撤回违反慢速模式的消息失败！", zap.Error(err), zap.Int64("messageID", message.MessageID))
+		}
+	}
+
 	// 通知消息监听者
 	if len(confMessages) > 0 {
 		w.ctx.NotifyMessagesListeners(confMessages)
@@ -215,6 +268,37 @@ func (w *Webhook) handleMessageNotify(messages []MsgResp) ([]string, error) {
 	return messageIDs, nil
 }
 
+// 检查消息是否命中违禁词（系统账号发送的消息不检查）
+func (w *Webhook) checkProhibitWords(message MsgResp) *prohibit.Result {
+	if message.FromUID == "" || message.FromUID == w.ctx.GetConfig().Account.SystemUID {
+		return nil
+	}
+	var payloadMap map[string]interface{}
+	if err := util.ReadJsonByByte(message.Payload, &payloadMap); err != nil {
+		return nil
+	}
+	return w.prohibitService.CheckPayload(payloadMap)
+}
+
+type prohibitMessage struct {
+	message MsgResp
+	result  *prohibit.Result
+}
+
+func (p *prohibitMessage) deliveredMessage() *prohibit.DeliveredMessage {
+	var payloadMap map[string]interface{}
+	_ = util.ReadJsonByByte(p.message.Payload, &payloadMap)
+	return &prohibit.DeliveredMessage{
+		MessageID:   p.message.MessageID,
+		MessageSeq:  p.message.MessageSeq,
+		ClientMsgNo: p.message.ClientMsgNo,
+		FromUID:     p.message.FromUID,
+		ChannelID:   p.message.ChannelID,
+		ChannelType: p.message.ChannelType,
+		Payload:     payloadMap,
+	}
+}
+
 func (w *Webhook) webhook(c *wkhttp.Context) {
 
 	event := c.Query("event")
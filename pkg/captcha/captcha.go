@@ -0,0 +1,135 @@
+// Package captcha 生成数字图片验证码（不依赖字体文件）
+package captcha
+
+import (
+	"bytes"
+	"crypto/rand"
+	"image"
+	"image/color"
+	"image/png"
+	"math/big"
+)
+
+const (
+	// Width 图片宽度
+	Width = 120
+	// Height 图片高度
+	Height = 40
+
+	scale = 4 // 字模放大倍数
+)
+
+// 5x7点阵数字字模
+var digits = [10][7]string{
+	{"01110", "10001", "10011", "10101", "11001", "10001", "01110"},
+	{"00100", "01100", "00100", "00100", "00100", "00100", "01110"},
+	{"01110", "10001", "00001", "00010", "00100", "01000", "11111"},
+	{"11110", "00001", "00001", "01110", "00001", "00001", "11110"},
+	{"00010", "00110", "01010", "10010", "11111", "00010", "00010"},
+	{"11111", "10000", "11110", "00001", "00001", "10001", "01110"},
+	{"00110", "01000", "10000", "11110", "10001", "10001", "01110"},
+	{"11111", "00001", "00010", "00100", "01000", "01000", "01000"},
+	{"01110", "10001", "10001", "01110", "10001", "10001", "01110"},
+	{"01110", "10001", "10001", "01111", "00001", "00010", "01100"},
+}
+
+// Generate 生成length位数字验证码，返回验证码和PNG图片
+func Generate(length int) (string, []byte, error) {
+	code := make([]byte, length)
+	for i := range code {
+		n, err := randInt(10)
+		if err != nil {
+			return "", nil, err
+		}
+		code[i] = byte('0' + n)
+	}
+	img, err := Draw(string(code))
+	if err != nil {
+		return "", nil, err
+	}
+	return string(code), img, nil
+}
+
+// Draw 将数字绘制为PNG图片
+func Draw(code string) ([]byte, error) {
+	img := image.NewRGBA(image.Rect(0, 0, Width, Height))
+	for y := 0; y < Height; y++ {
+		for x := 0; x < Width; x++ {
+			img.Set(x, y, color.RGBA{R: 245, G: 245, B: 245, A: 255})
+		}
+	}
+	// 干扰点
+	for i := 0; i < Width*Height/8; i++ {
+		x, _ := randInt(Width)
+		y, _ := randInt(Height)
+		c, _ := randColor(120, 230)
+		img.Set(x, y, c)
+	}
+	step := Width / (len(code) + 1)
+	for i, ch := range code {
+		if ch < '0' || ch > '9' {
+			continue
+		}
+		glyph := digits[ch-'0']
+		jitterX, _ := randInt(step / 3)
+		offsetY, _ := randInt(Height - 7*scale)
+		originX := step/2 + i*step + jitterX
+		c, err := randColor(0, 110)
+		if err != nil {
+			return nil, err
+		}
+		for row, line := range glyph {
+			// 每行随机水平偏移，模拟扭曲
+			shift, _ := randInt(3)
+			for col, bit := range line {
+				if bit != '1' {
+					continue
+				}
+				for dy := 0; dy < scale; dy++ {
+					for dx := 0; dx < scale; dx++ {
+						img.Set(originX+col*scale+dx+shift-1, offsetY+row*scale+dy, c)
+					}
+				}
+			}
+		}
+	}
+	// 干扰线
+	for i := 0; i < 3; i++ {
+		y0, _ := randInt(Height)
+		y1, _ := randInt(Height)
+		c, _ := randColor(60, 160)
+		for x := 0; x < Width; x++ {
+			y := y0 + (y1-y0)*x/Width
+			img.Set(x, y, c)
+			img.Set(x, y+1, c)
+		}
+	}
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func randInt(max int) (int, error) {
+	if max <= 0 {
+		return 0, nil
+	}
+	n, err := rand.Int(rand.Reader, big.NewInt(int64(max)))
+	if err != nil {
+		return 0, err
+	}
+	return int(n.Int64()), nil
+}
+
+func randColor(min, max int) (color.RGBA, error) {
+	var rgb [3]uint8
+	for i := range rgb {
+		n, err := randInt(max - min)
+		if err != nil {
+			return color.RGBA{}, err
+		}
+		rgb[i] = uint8(min + n)
+	}
+	return color.RGBA{R: rgb[0], G: rgb[1], B: rgb[2], A: 255}, nil
+}
@@ -0,0 +1,22 @@
+package captcha
+
+import (
+	"bytes"
+	"image/png"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGenerate(t *testing.T) {
+	code, data, err := Generate(4)
+	assert.NoError(t, err)
+	assert.Len(t, code, 4)
+	for _, ch := range code {
+		assert.True(t, ch >= '0' && ch <= '9')
+	}
+	img, err := png.Decode(bytes.NewReader(data))
+	assert.NoError(t, err)
+	assert.Equal(t, Width, img.Bounds().Dx())
+	assert.Equal(t, Height, img.Bounds().Dy())
+}
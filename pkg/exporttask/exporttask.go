@@ -0,0 +1,115 @@
+package exporttask
+
+import (
+	"errors"
+	"time"
+
+	"github.com/TangSengDaoDao/TangSengDaoDaoServerLib/pkg/log"
+	"go.uber.org/zap"
+)
+
+// 导出任务状态（使用此包调度的导出任务表都使用这些状态值）
+const (
+	StatusWait    = 0 // 等待导出
+	StatusRunning = 1 // 导出中
+	StatusDone    = 2 // 已完成
+	StatusFail    = 3 // 导出失败
+)
+
+// ErrLost 任务已不在导出中状态（导出超时被恢复为等待导出，可能已被其他实例重新抢占），本次导出结果作废
+var ErrLost = errors.New("导出任务已失效")
+
+// Worker 导出任务的后台调度：定时将中断在导出中状态的任务恢复为等待导出，再逐个抢占等待导出的任务执行。
+// 任务状态都通过带原状态条件的更新修改，多实例部署时同一个任务同时只会被一个实例执行。
+type Worker struct {
+	log.Log
+	ScanInterval time.Duration // 扫描待导出任务的间隔
+	ScanLimit    uint64        // 每次扫描的最大任务数量
+	StaleAfter   time.Duration // 导出中状态超过此时间未更新视为中断，重新导出
+
+	// QueryStaleRunning 查询更新时间早于updatedBefore的导出中任务编号
+	QueryStaleRunning func(updatedBefore time.Time) ([]string, error)
+	// QueryWaiting 查询等待导出的任务编号
+	QueryWaiting func(limit uint64) ([]string, error)
+	// UpdateStatus 状态从fromStatus改为toStatus，返回影响行数
+	UpdateStatus func(exportNo string, fromStatus, toStatus int) (int64, error)
+	// Run 执行已抢占的任务
+	Run func(exportNo string)
+}
+
+// Start 开启后台调度（由调用方保证只启动一次）
+func (w *Worker) Start() {
+	go func() {
+		ticker := time.NewTicker(w.ScanInterval)
+		defer ticker.Stop()
+		for range ticker.C {
+			w.recoverStale()
+			w.runWaiting()
+		}
+	}()
+}
+
+// Claim 抢占等待导出的任务，返回是否抢占成功
+func (w *Worker) Claim(exportNo string) (bool, error) {
+	rows, err := w.UpdateStatus(exportNo, StatusWait, StatusRunning)
+	if err != nil {
+		return false, err
+	}
+	return rows > 0, nil
+}
+
+// Heartbeat 导出期间定时调用touch刷新任务的更新时间，防止耗时较长的导出被当作中断的任务恢复，返回停止函数
+func (w *Worker) Heartbeat(exportNo string, touch func() error) func() {
+	done := make(chan struct{})
+	go func() {
+		ticker := time.NewTicker(w.StaleAfter / 4)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				if err := touch(); err != nil {
+					w.Warn("刷新导出任务更新时间失败！", zap.Error(err), zap.String("exportNo", exportNo))
+				}
+			case <-done:
+				return
+			}
+		}
+	}()
+	return func() {
+		close(done)
+	}
+}
+
+// 将中断在导出中状态的任务（如导出过程中服务重启）恢复为等待导出
+func (w *Worker) recoverStale() {
+	exportNos, err := w.QueryStaleRunning(time.Now().Add(-w.StaleAfter))
+	if err != nil {
+		w.Error("查询导出中的任务失败！", zap.Error(err))
+		return
+	}
+	for _, exportNo := range exportNos {
+		if _, err = w.UpdateStatus(exportNo, StatusRunning, StatusWait); err != nil {
+			w.Error("恢复导出任务状态失败！", zap.Error(err), zap.String("exportNo", exportNo))
+		}
+	}
+}
+
+func (w *Worker) runWaiting() {
+	exportNos, err := w.QueryWaiting(w.ScanLimit)
+	if err != nil {
+		w.Error("查询待导出的任务失败！", zap.Error(err))
+		return
+	}
+	for _, exportNo := range exportNos {
+		// 抢占任务，防止多实例重复导出
+		ok, err := w.Claim(exportNo)
+		if err != nil {
+			w.Error("修改导出任务状态失败！", zap.Error(err), zap.String("exportNo", exportNo))
+			continue
+		}
+		if !ok {
+			continue
+		}
+		w.Run(exportNo)
+	}
+}
@@ -0,0 +1,75 @@
+package exporttask
+
+import (
+	"testing"
+	"time"
+
+	"github.com/TangSengDaoDao/TangSengDaoDaoServerLib/pkg/log"
+	"github.com/stretchr/testify/assert"
+)
+
+func newTestWorker(statuses map[string]int, updatedAt map[string]time.Time, ran *[]string) *Worker {
+	return &Worker{
+		Log:        log.NewTLog("ExportTaskTest"),
+		ScanLimit:  10,
+		StaleAfter: time.Minute * 10,
+		QueryStaleRunning: func(updatedBefore time.Time) ([]string, error) {
+			exportNos := make([]string, 0)
+			for exportNo, status := range statuses {
+				if status == StatusRunning && updatedAt[exportNo].Before(updatedBefore) {
+					exportNos = append(exportNos, exportNo)
+				}
+			}
+			return exportNos, nil
+		},
+		QueryWaiting: func(limit uint64) ([]string, error) {
+			exportNos := make([]string, 0)
+			for exportNo, status := range statuses {
+				if status == StatusWait {
+					exportNos = append(exportNos, exportNo)
+				}
+			}
+			return exportNos, nil
+		},
+		UpdateStatus: func(exportNo string, fromStatus, toStatus int) (int64, error) {
+			if statuses[exportNo] != fromStatus {
+				return 0, nil
+			}
+			statuses[exportNo] = toStatus
+			updatedAt[exportNo] = time.Now()
+			return 1, nil
+		},
+		Run: func(exportNo string) {
+			*ran = append(*ran, exportNo)
+		},
+	}
+}
+
+func TestWorkerRecoverAndRun(t *testing.T) {
+	statuses := map[string]int{
+		"stale":   StatusRunning,
+		"running": StatusRunning,
+		"done":    StatusDone,
+	}
+	updatedAt := map[string]time.Time{
+		"stale":   time.Now().Add(-time.Hour),
+		"running": time.Now(),
+		"done":    time.Now().Add(-time.Hour),
+	}
+	var ran []string
+	w := newTestWorker(statuses, updatedAt, &ran)
+
+	w.recoverStale()
+	assert.Equal(t, StatusWait, statuses["stale"])
+	assert.Equal(t, StatusRunning, statuses["running"])
+	assert.Equal(t, StatusDone, statuses["done"])
+
+	w.runWaiting()
+	assert.Equal(t, []string{"stale"}, ran)
+	assert.Equal(t, StatusRunning, statuses["stale"])
+
+	// 已被抢占的任务不能再次抢占
+	ok, err := w.Claim("stale")
+	assert.NoError(t, err)
+	assert.False(t, ok)
+}
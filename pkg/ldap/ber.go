@@ -0,0 +1,190 @@
+package ldap
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// BER标签（LDAP只用到确定长度编码）
+const (
+	tagBoolean     = 0x01
+	tagInteger     = 0x02
+	tagOctetString = 0x04
+	tagEnumerated  = 0x0a
+	tagSequence    = 0x30
+	tagSet         = 0x31
+
+	classApplication = 0x40
+	classContext     = 0x80
+	constructed      = 0x20
+)
+
+const maxPacketSize = 16 << 20 // 单个消息最大16M
+
+var errMalformed = errors.New("ldap: malformed packet")
+
+// packet 解码后的BER元素
+type packet struct {
+	tag      byte
+	value    []byte    // 原始内容
+	children []*packet // 构造类型的子元素
+}
+
+func (p *packet) isConstructed() bool {
+	return p.tag&constructed != 0
+}
+
+func (p *packet) child(i int) *packet {
+	if i < len(p.children) {
+		return p.children[i]
+	}
+	return nil
+}
+
+func (p *packet) str() string {
+	if p == nil {
+		return ""
+	}
+	return string(p.value)
+}
+
+func (p *packet) int() int64 {
+	if p == nil || len(p.value) == 0 {
+		return 0
+	}
+	v := int64(int8(p.value[0])) // 符号扩展
+	for _, b := range p.value[1:] {
+		v = v<<8 | int64(b)
+	}
+	return v
+}
+
+// ---------- 编码 ----------
+
+func encodeLength(n int) []byte {
+	if n < 0x80 {
+		return []byte{byte(n)}
+	}
+	var buf []byte
+	for ; n > 0; n >>= 8 {
+		buf = append([]byte{byte(n)}, buf...)
+	}
+	return append([]byte{0x80 | byte(len(buf))}, buf...)
+}
+
+func tlv(tag byte, content ...[]byte) []byte {
+	size := 0
+	for _, c := range content {
+		size += len(c)
+	}
+	buf := make([]byte, 0, size+6)
+	buf = append(buf, tag)
+	buf = append(buf, encodeLength(size)...)
+	for _, c := range content {
+		buf = append(buf, c...)
+	}
+	return buf
+}
+
+func berInt(tag byte, v int64) []byte {
+	var buf []byte
+	for {
+		buf = append([]byte{byte(v)}, buf...)
+		v >>= 8
+		if (v == 0 && buf[0]&0x80 == 0) || (v == -1 && buf[0]&0x80 != 0) {
+			break
+		}
+	}
+	return tlv(tag, buf)
+}
+
+func berString(tag byte, s string) []byte {
+	return tlv(tag, []byte(s))
+}
+
+func berBool(v bool) []byte {
+	if v {
+		return tlv(tagBoolean, []byte{0xff})
+	}
+	return tlv(tagBoolean, []byte{0x00})
+}
+
+// ---------- 解码 ----------
+
+// readPacket 从连接读取一个完整的BER元素
+func readPacket(r *bufio.Reader) ([]byte, error) {
+	tag, err := r.ReadByte()
+	if err != nil {
+		return nil, err
+	}
+	first, err := r.ReadByte()
+	if err != nil {
+		return nil, err
+	}
+	header := []byte{tag, first}
+	length := int(first)
+	if first&0x80 != 0 {
+		n := int(first & 0x7f)
+		if n == 0 || n > 4 {
+			return nil, errMalformed // 不支持不定长编码
+		}
+		lenBytes := make([]byte, n)
+		if _, err := io.ReadFull(r, lenBytes); err != nil {
+			return nil, err
+		}
+		header = append(header, lenBytes...)
+		length = 0
+		for _, b := range lenBytes {
+			length = length<<8 | int(b)
+		}
+	}
+	if length > maxPacketSize {
+		return nil, fmt.Errorf("ldap: packet too large (%d bytes)", length)
+	}
+	buf := make([]byte, len(header)+length)
+	copy(buf, header)
+	if _, err := io.ReadFull(r, buf[len(header):]); err != nil {
+		return nil, err
+	}
+	return buf, nil
+}
+
+// decodePacket 解码一个BER元素，返回剩余的字节
+func decodePacket(data []byte) (*packet, []byte, error) {
+	if len(data) < 2 {
+		return nil, nil, errMalformed
+	}
+	p := &packet{tag: data[0]}
+	length := int(data[1])
+	offset := 2
+	if data[1]&0x80 != 0 {
+		n := int(data[1] & 0x7f)
+		if n == 0 || n > 4 || len(data) < 2+n {
+			return nil, nil, errMalformed
+		}
+		length = 0
+		for _, b := range data[2 : 2+n] {
+			length = length<<8 | int(b)
+		}
+		offset += n
+	}
+	if length < 0 || len(data)-offset < length {
+		return nil, nil, errMalformed
+	}
+	p.value = data[offset : offset+length]
+	if p.isConstructed() {
+		rest := p.value
+		for len(rest) > 0 {
+			var child *packet
+			var err error
+			child, rest, err = decodePacket(rest)
+			if err != nil {
+				return nil, nil, err
+			}
+			p.children = append(p.children, child)
+		}
+	}
+	return p, data[offset+length:], nil
+}
@@ -0,0 +1,189 @@
+package ldap
+
+import (
+	"encoding/hex"
+	"fmt"
+	"strings"
+)
+
+// 过滤器标签（RFC 4511 4.5.1）
+const (
+	filterAnd            = classContext | constructed | 0
+	filterOr             = classContext | constructed | 1
+	filterNot            = classContext | constructed | 2
+	filterEqualityMatch  = classContext | constructed | 3
+	filterSubstrings     = classContext | constructed | 4
+	filterGreaterOrEqual = classContext | constructed | 5
+	filterLessOrEqual    = classContext | constructed | 6
+	filterPresent        = classContext | 7
+	filterApproxMatch    = classContext | constructed | 8
+
+	substringInitial = classContext | 0
+	substringAny     = classContext | 1
+	substringFinal   = classContext | 2
+)
+
+// EscapeFilter 转义过滤器中的值（RFC 4515），拼接用户输入时必须调用
+func EscapeFilter(value string) string {
+	var b strings.Builder
+	for i := 0; i < len(value); i++ {
+		c := value[i]
+		switch c {
+		case '\\', '*', '(', ')', 0:
+			fmt.Fprintf(&b, "\\%02x", c)
+		default:
+			b.WriteByte(c)
+		}
+	}
+	return b.String()
+}
+
+// compileFilter 将字符串形式的过滤器编码为BER，例如 (&(objectClass=person)(uid=tom))
+func compileFilter(filter string) ([]byte, error) {
+	filter = strings.TrimSpace(filter)
+	if filter == "" {
+		filter = "(objectClass=*)"
+	}
+	if filter[0] != '(' {
+		filter = "(" + filter + ")"
+	}
+	encoded, pos, err := parseFilter(filter, 0)
+	if err != nil {
+		return nil, err
+	}
+	if pos != len(filter) {
+		return nil, fmt.Errorf("ldap: unexpected trailing characters in filter %q", filter)
+	}
+	return encoded, nil
+}
+
+func parseFilter(filter string, pos int) ([]byte, int, error) {
+	if pos >= len(filter) || filter[pos] != '(' {
+		return nil, pos, fmt.Errorf("ldap: filter %q: expected '(' at %d", filter, pos)
+	}
+	pos++
+	if pos >= len(filter) {
+		return nil, pos, fmt.Errorf("ldap: filter %q: unexpected end", filter)
+	}
+	switch filter[pos] {
+	case '&', '|':
+		tag := byte(filterAnd)
+		if filter[pos] == '|' {
+			tag = filterOr
+		}
+		pos++
+		var children [][]byte
+		for pos < len(filter) && filter[pos] == '(' {
+			child, next, err := parseFilter(filter, pos)
+			if err != nil {
+				return nil, next, err
+			}
+			children = append(children, child)
+			pos = next
+		}
+		if len(children) == 0 {
+			return nil, pos, fmt.Errorf("ldap: filter %q: empty filter list", filter)
+		}
+		if pos >= len(filter) || filter[pos] != ')' {
+			return nil, pos, fmt.Errorf("ldap: filter %q: expected ')' at %d", filter, pos)
+		}
+		return tlv(tag, children...), pos + 1, nil
+	case '!':
+		child, next, err := parseFilter(filter, pos+1)
+		if err != nil {
+			return nil, next, err
+		}
+		if next >= len(filter) || filter[next] != ')' {
+			return nil, next, fmt.Errorf("ldap: filter %q: expected ')' at %d", filter, next)
+		}
+		return tlv(filterNot, child), next + 1, nil
+	}
+	end := strings.IndexByte(filter[pos:], ')')
+	if end < 0 {
+		return nil, pos, fmt.Errorf("ldap: filter %q: missing ')'", filter)
+	}
+	item, err := compileItem(filter[pos : pos+end])
+	if err != nil {
+		return nil, pos, fmt.Errorf("ldap: filter %q: %w", filter, err)
+	}
+	return item, pos + end + 1, nil
+}
+
+func compileItem(item string) ([]byte, error) {
+	eq := strings.IndexByte(item, '=')
+	if eq <= 0 {
+		return nil, fmt.Errorf("invalid item %q", item)
+	}
+	attr := item[:eq]
+	value := item[eq+1:]
+	tag := byte(filterEqualityMatch)
+	switch attr[len(attr)-1] {
+	case '>':
+		tag = filterGreaterOrEqual
+		attr = attr[:len(attr)-1]
+	case '<':
+		tag = filterLessOrEqual
+		attr = attr[:len(attr)-1]
+	case '~':
+		tag = filterApproxMatch
+		attr = attr[:len(attr)-1]
+	case ':':
+		return nil, fmt.Errorf("extensible match is not supported: %q", item)
+	}
+	if attr == "" || strings.ContainsAny(attr, "()*\\ ") {
+		return nil, fmt.Errorf("invalid attribute in %q", item)
+	}
+	if tag != filterEqualityMatch || !strings.Contains(value, "*") {
+		v, err := unescapeFilterValue(value)
+		if err != nil {
+			return nil, err
+		}
+		return tlv(tag, berString(tagOctetString, attr), berString(tagOctetString, v)), nil
+	}
+	if value == "*" {
+		return berString(filterPresent, attr), nil
+	}
+	parts := strings.Split(value, "*")
+	var subs [][]byte
+	for i, part := range parts {
+		if part == "" {
+			continue
+		}
+		v, err := unescapeFilterValue(part)
+		if err != nil {
+			return nil, err
+		}
+		subTag := byte(substringAny)
+		if i == 0 {
+			subTag = substringInitial
+		} else if i == len(parts)-1 {
+			subTag = substringFinal
+		}
+		subs = append(subs, berString(subTag, v))
+	}
+	return tlv(filterSubstrings, berString(tagOctetString, attr), tlv(tagSequence, subs...)), nil
+}
+
+// unescapeFilterValue 解析值中的 \XX 转义
+func unescapeFilterValue(value string) (string, error) {
+	if !strings.Contains(value, "\\") {
+		return value, nil
+	}
+	var b strings.Builder
+	for i := 0; i < len(value); i++ {
+		if value[i] != '\\' {
+			b.WriteByte(value[i])
+			continue
+		}
+		if i+3 > len(value) {
+			return "", fmt.Errorf("invalid escape in %q", value)
+		}
+		decoded, err := hex.DecodeString(value[i+1 : i+3])
+		if err != nil {
+			return "", fmt.Errorf("invalid escape in %q", value)
+		}
+		b.Write(decoded)
+		i += 2
+	}
+	return b.String(), nil
+}
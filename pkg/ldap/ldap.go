@@ -0,0 +1,389 @@
+// Package ldap 精简的LDAPv3客户端，只实现登录认证和目录同步需要的操作（简单绑定、StartTLS、分页搜索）
+package ldap
+
+import (
+	"bufio"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// 搜索范围
+const (
+	ScopeBaseObject   = 0
+	ScopeSingleLevel  = 1
+	ScopeWholeSubtree = 2
+)
+
+// 常用的结果码（RFC 4511 4.1.9）
+const (
+	ResultSuccess            = 0
+	ResultSizeLimitExceeded  = 4
+	ResultNoSuchObject       = 32
+	ResultInvalidCredentials = 49
+	ResultInsufficientAccess = 50
+	ResultUnwillingToPerform = 53
+)
+
+const (
+	resultProtocolError    = 2
+	pagedResultsControlOID = "1.2.840.113556.1.4.319"
+	startTLSOID            = "1.3.6.1.4.1.1466.20037"
+	defaultTimeout         = time.Second * 10
+	protocolVersion        = 3
+	derefAliasesNever      = 0
+
+	opBindRequest     = classApplication | constructed | 0
+	opBindResponse    = classApplication | constructed | 1
+	opUnbindRequest   = classApplication | 2
+	opSearchRequest   = classApplication | constructed | 3
+	opSearchEntry     = classApplication | constructed | 4
+	opSearchDone      = classApplication | constructed | 5
+	opSearchReference = classApplication | constructed | 19
+	opExtendedRequest = classApplication | constructed | 23
+	opExtendedResp    = classApplication | constructed | 24
+
+	authSimple          = classContext | 0
+	extendedRequestName = classContext | 0
+	controlsTag         = classContext | constructed | 0
+)
+
+var (
+	// ErrEmptyPassword 空密码会被服务端当作匿名绑定而返回成功，必须在客户端拒绝
+	ErrEmptyPassword = errors.New("ldap: empty password")
+)
+
+// Error 服务端返回的错误结果
+type Error struct {
+	Code    int
+	Message string
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("ldap: result code %d: %s", e.Code, e.Message)
+}
+
+// IsErrorWithCode 判断是否是指定结果码的错误
+func IsErrorWithCode(err error, code int) bool {
+	var ldapErr *Error
+	return errors.As(err, &ldapErr) && ldapErr.Code == code
+}
+
+// SearchRequest 搜索请求
+type SearchRequest struct {
+	BaseDN     string
+	Scope      int
+	Filter     string
+	Attributes []string
+	SizeLimit  int
+	PageSize   int // 大于0时使用分页控制（AD默认单次最多返回1000条）
+}
+
+// Entry 搜索结果条目
+type Entry struct {
+	DN         string
+	Attributes map[string][]string // key为小写的属性名
+}
+
+// Get 获取属性的第一个值（属性名不区分大小写）
+func (e *Entry) Get(name string) string {
+	values := e.Attributes[strings.ToLower(name)]
+	if len(values) == 0 {
+		return ""
+	}
+	return values[0]
+}
+
+// GetAll 获取属性的全部值
+func (e *Entry) GetAll(name string) []string {
+	return e.Attributes[strings.ToLower(name)]
+}
+
+// Conn 一个LDAP连接（非并发安全）
+type Conn struct {
+	conn    net.Conn
+	reader  *bufio.Reader
+	msgID   int64
+	host    string        // StartTLS时校验证书用的主机名
+	Timeout time.Duration // 单次操作超时时间
+}
+
+// Dial 连接服务器，地址格式 ldap://host:389 或 ldaps://host:636
+func Dial(rawURL string, tlsConfig *tls.Config, timeout time.Duration) (*Conn, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, err
+	}
+	if timeout <= 0 {
+		timeout = defaultTimeout
+	}
+	dialer := &net.Dialer{Timeout: timeout}
+	var conn net.Conn
+	switch u.Scheme {
+	case "ldap":
+		conn, err = dialer.Dial("tcp", hostWithPort(u, "389"))
+	case "ldaps":
+		conn, err = tls.DialWithDialer(dialer, "tcp", hostWithPort(u, "636"), withServerName(tlsConfig, u.Hostname()))
+	default:
+		return nil, fmt.Errorf("ldap: unsupported scheme %q", u.Scheme)
+	}
+	if err != nil {
+		return nil, err
+	}
+	c := NewConn(conn, timeout)
+	c.host = u.Hostname()
+	return c, nil
+}
+
+// NewConn 使用已建立的连接
+func NewConn(conn net.Conn, timeout time.Duration) *Conn {
+	if timeout <= 0 {
+		timeout = defaultTimeout
+	}
+	return &Conn{
+		conn:    conn,
+		reader:  bufio.NewReader(conn),
+		Timeout: timeout,
+	}
+}
+
+// StartTLS 将明文连接升级为TLS
+func (c *Conn) StartTLS(tlsConfig *tls.Config) error {
+	if _, ok := c.conn.(*tls.Conn); ok {
+		return errors.New("ldap: connection is already using TLS")
+	}
+	done, err := c.roundTrip(tlv(opExtendedRequest, berString(extendedRequestName, startTLSOID)), nil)
+	if err != nil {
+		return err
+	}
+	if err := checkResult(done, opExtendedResp); err != nil {
+		return err
+	}
+	tlsConn := tls.Client(c.conn, withServerName(tlsConfig, c.host))
+	_ = tlsConn.SetDeadline(time.Now().Add(c.Timeout))
+	if err := tlsConn.Handshake(); err != nil {
+		return err
+	}
+	_ = tlsConn.SetDeadline(time.Time{})
+	c.conn = tlsConn
+	c.reader = bufio.NewReader(tlsConn)
+	return nil
+}
+
+// Bind 简单绑定（用户名密码认证）
+func (c *Conn) Bind(dn string, password string) error {
+	if password == "" {
+		return ErrEmptyPassword
+	}
+	req := tlv(opBindRequest,
+		berInt(tagInteger, protocolVersion),
+		berString(tagOctetString, dn),
+		berString(authSimple, password),
+	)
+	done, err := c.roundTrip(req, nil)
+	if err != nil {
+		return err
+	}
+	return checkResult(done, opBindResponse)
+}
+
+// Search 搜索条目，PageSize大于0时自动翻页直到取完
+func (c *Conn) Search(req *SearchRequest) ([]*Entry, error) {
+	filter, err := compileFilter(req.Filter)
+	if err != nil {
+		return nil, err
+	}
+	attributes := make([][]byte, 0, len(req.Attributes))
+	for _, attr := range req.Attributes {
+		attributes = append(attributes, berString(tagOctetString, attr))
+	}
+	body := tlv(opSearchRequest,
+		berString(tagOctetString, req.BaseDN),
+		berInt(tagEnumerated, int64(req.Scope)),
+		berInt(tagEnumerated, derefAliasesNever),
+		berInt(tagInteger, int64(req.SizeLimit)),
+		berInt(tagInteger, 0),
+		berBool(false),
+		filter,
+		tlv(tagSequence, attributes...),
+	)
+	var entries []*Entry
+	var cookie []byte
+	for {
+		var controls []byte
+		if req.PageSize > 0 {
+			controls = pagedResultsControl(req.PageSize, cookie)
+		}
+		var pageEntries []*Entry
+		done, err := c.roundTrip(body, controls, func(op *packet) error {
+			entry, err := parseEntry(op)
+			if err != nil {
+				return err
+			}
+			pageEntries = append(pageEntries, entry)
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, pageEntries...)
+		if err := checkResult(done, opSearchDone); err != nil {
+			return entries, err
+		}
+		if req.PageSize <= 0 {
+			return entries, nil
+		}
+		cookie = pagedResultsCookie(done)
+		if len(cookie) == 0 {
+			return entries, nil
+		}
+	}
+}
+
+// Close 发送unbind并关闭连接
+func (c *Conn) Close() error {
+	c.msgID++
+	_ = c.conn.SetWriteDeadline(time.Now().Add(time.Second))
+	_, _ = c.conn.Write(tlv(tagSequence, berInt(tagInteger, c.msgID), []byte{opUnbindRequest, 0x00}))
+	return c.conn.Close()
+}
+
+// roundTrip 发送请求并读取响应，中间结果（搜索条目）交给onEntry处理，返回最终的完整消息
+func (c *Conn) roundTrip(op []byte, controls []byte, onEntry ...func(op *packet) error) (*packet, error) {
+	c.msgID++
+	msgID := c.msgID
+	msg := [][]byte{berInt(tagInteger, msgID), op}
+	if controls != nil {
+		msg = append(msg, controls)
+	}
+	if err := c.conn.SetDeadline(time.Now().Add(c.Timeout)); err != nil {
+		return nil, err
+	}
+	defer c.conn.SetDeadline(time.Time{})
+	if _, err := c.conn.Write(tlv(tagSequence, msg...)); err != nil {
+		return nil, err
+	}
+	for {
+		raw, err := readPacket(c.reader)
+		if err != nil {
+			return nil, err
+		}
+		resp, _, err := decodePacket(raw)
+		if err != nil {
+			return nil, err
+		}
+		if resp.tag != tagSequence || len(resp.children) < 2 {
+			return nil, errMalformed
+		}
+		if resp.child(0).int() != msgID {
+			if resp.child(0).int() == 0 { // 服务端主动通知（例如断开连接）
+				return nil, &Error{Code: resultProtocolError, Message: resultMessage(resp.child(1))}
+			}
+			continue
+		}
+		switch resp.child(1).tag {
+		case opSearchEntry:
+			if len(onEntry) > 0 {
+				if err := onEntry[0](resp.child(1)); err != nil {
+					return nil, err
+				}
+			}
+			// 继续读取
+			if err := c.conn.SetDeadline(time.Now().Add(c.Timeout)); err != nil {
+				return nil, err
+			}
+		case opSearchReference: // 忽略引用
+		default:
+			return resp, nil
+		}
+	}
+}
+
+func checkResult(msg *packet, expectTag byte) error {
+	op := msg.child(1)
+	if op == nil || op.tag != expectTag || len(op.children) < 3 {
+		return errMalformed
+	}
+	code := int(op.child(0).int())
+	if code != ResultSuccess {
+		return &Error{Code: code, Message: op.child(2).str()}
+	}
+	return nil
+}
+
+func resultMessage(op *packet) string {
+	if op == nil || len(op.children) < 3 {
+		return ""
+	}
+	return op.child(2).str()
+}
+
+func parseEntry(op *packet) (*Entry, error) {
+	if len(op.children) < 2 {
+		return nil, errMalformed
+	}
+	entry := &Entry{
+		DN:         op.child(0).str(),
+		Attributes: map[string][]string{},
+	}
+	for _, attr := range op.child(1).children {
+		if len(attr.children) < 2 {
+			return nil, errMalformed
+		}
+		name := strings.ToLower(attr.child(0).str())
+		for _, v := range attr.child(1).children {
+			entry.Attributes[name] = append(entry.Attributes[name], v.str())
+		}
+	}
+	return entry, nil
+}
+
+func pagedResultsControl(size int, cookie []byte) []byte {
+	value := tlv(tagSequence, berInt(tagInteger, int64(size)), tlv(tagOctetString, cookie))
+	return tlv(controlsTag, tlv(tagSequence,
+		berString(tagOctetString, pagedResultsControlOID),
+		tlv(tagOctetString, value),
+	))
+}
+
+func pagedResultsCookie(msg *packet) []byte {
+	controls := msg.child(2)
+	if controls == nil || controls.tag != controlsTag {
+		return nil
+	}
+	for _, control := range controls.children {
+		if control.child(0).str() != pagedResultsControlOID {
+			continue
+		}
+		valuePacket := control.children[len(control.children)-1]
+		value, _, err := decodePacket(valuePacket.value)
+		if err != nil || len(value.children) < 2 {
+			return nil
+		}
+		return value.child(1).value
+	}
+	return nil
+}
+
+func hostWithPort(u *url.URL, defaultPort string) string {
+	if u.Port() != "" {
+		return u.Host
+	}
+	return net.JoinHostPort(u.Hostname(), defaultPort)
+}
+
+func withServerName(tlsConfig *tls.Config, serverName string) *tls.Config {
+	if tlsConfig == nil {
+		tlsConfig = &tls.Config{}
+	} else {
+		tlsConfig = tlsConfig.Clone()
+	}
+	if tlsConfig.ServerName == "" {
+		tlsConfig.ServerName = serverName
+	}
+	return tlsConfig
+}
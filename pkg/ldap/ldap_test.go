@@ -0,0 +1,199 @@
+package ldap
+
+import (
+	"bufio"
+	"net"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// testServer 只实现绑定和分页搜索的模拟服务端
+type testServer struct {
+	listener net.Listener
+	users    map[string]string // dn => password
+	entries  []*Entry
+	requests []*packet // 收到的搜索请求
+}
+
+func newTestServer(t *testing.T) *testServer {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	s := &testServer{listener: listener, users: map[string]string{}}
+	t.Cleanup(func() { listener.Close() })
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go s.serve(conn)
+		}
+	}()
+	return s
+}
+
+func (s *testServer) url() string {
+	return "ldap://" + s.listener.Addr().String()
+}
+
+func (s *testServer) serve(conn net.Conn) {
+	defer conn.Close()
+	reader := bufio.NewReader(conn)
+	for {
+		raw, err := readPacket(reader)
+		if err != nil {
+			return
+		}
+		msg, _, err := decodePacket(raw)
+		if err != nil {
+			return
+		}
+		msgID := msg.child(0).int()
+		op := msg.child(1)
+		switch op.tag {
+		case opBindRequest:
+			code := ResultInvalidCredentials
+			if password, ok := s.users[op.child(1).str()]; ok && password == op.child(2).str() {
+				code = ResultSuccess
+			}
+			conn.Write(response(msgID, opBindResponse, code, nil))
+		case opSearchRequest:
+			s.requests = append(s.requests, op)
+			// 每页固定返回一条，cookie为下一条的下标
+			start := 0
+			var controls []byte
+			if len(msg.children) > 2 {
+				value, _, _ := decodePacket(msg.child(2).child(0).child(1).value)
+				start, _ = strconv.Atoi(value.child(1).str())
+			}
+			for i := start; i < len(s.entries); i++ {
+				entry := s.entries[i]
+				var attrs [][]byte
+				for name, values := range entry.Attributes {
+					var vals [][]byte
+					for _, v := range values {
+						vals = append(vals, berString(tagOctetString, v))
+					}
+					attrs = append(attrs, tlv(tagSequence, berString(tagOctetString, name), tlv(tagSet, vals...)))
+				}
+				conn.Write(tlv(tagSequence, berInt(tagInteger, msgID), tlv(opSearchEntry, berString(tagOctetString, entry.DN), tlv(tagSequence, attrs...))))
+				if len(msg.children) > 2 {
+					cookie := ""
+					if i+1 < len(s.entries) {
+						cookie = strconv.Itoa(i + 1)
+					}
+					controls = pagedResultsControl(1, []byte(cookie))
+					break
+				}
+			}
+			conn.Write(response(msgID, opSearchDone, ResultSuccess, controls))
+		default:
+			return
+		}
+	}
+}
+
+func response(msgID int64, tag byte, code int, controls []byte) []byte {
+	msg := [][]byte{berInt(tagInteger, msgID), tlv(tag, berInt(tagEnumerated, int64(code)), berString(tagOctetString, ""), berString(tagOctetString, "message"))}
+	if controls != nil {
+		msg = append(msg, controls)
+	}
+	return tlv(tagSequence, msg...)
+}
+
+func TestBind(t *testing.T) {
+	s := newTestServer(t)
+	s.users["uid=tom,dc=example,dc=com"] = "secret"
+	conn, err := Dial(s.url(), nil, time.Second)
+	assert.NoError(t, err)
+	defer conn.Close()
+
+	assert.NoError(t, conn.Bind("uid=tom,dc=example,dc=com", "secret"))
+	err = conn.Bind("uid=tom,dc=example,dc=com", "wrong")
+	assert.True(t, IsErrorWithCode(err, ResultInvalidCredentials))
+	// 空密码不能发给服务端（会变成匿名绑定）
+	assert.Equal(t, ErrEmptyPassword, conn.Bind("uid=tom,dc=example,dc=com", ""))
+}
+
+func TestSearch(t *testing.T) {
+	s := newTestServer(t)
+	s.entries = []*Entry{
+		{DN: "uid=tom,dc=example,dc=com", Attributes: map[string][]string{"uid": {"tom"}, "cn": {"Tom"}}},
+		{DN: "uid=jerry,dc=example,dc=com", Attributes: map[string][]string{"uid": {"jerry"}, "memberOf": {"cn=a", "cn=b"}}},
+		{DN: "uid=spike,dc=example,dc=com", Attributes: map[string][]string{"uid": {"spike"}}},
+	}
+	conn, err := Dial(s.url(), nil, time.Second)
+	assert.NoError(t, err)
+	defer conn.Close()
+
+	entries, err := conn.Search(&SearchRequest{
+		BaseDN:     "dc=example,dc=com",
+		Scope:      ScopeWholeSubtree,
+		Filter:     "(uid=*)",
+		Attributes: []string{"uid", "cn"},
+	})
+	assert.NoError(t, err)
+	assert.Len(t, entries, 3)
+	assert.Equal(t, "Tom", entries[0].Get("CN"))
+	assert.Equal(t, []string{"cn=a", "cn=b"}, entries[1].GetAll("memberof"))
+
+	// 分页
+	entries, err = conn.Search(&SearchRequest{
+		BaseDN:   "dc=example,dc=com",
+		Scope:    ScopeWholeSubtree,
+		Filter:   "(objectClass=person)",
+		PageSize: 1,
+	})
+	assert.NoError(t, err)
+	assert.Len(t, entries, 3)
+	assert.Equal(t, "spike", entries[2].Get("uid"))
+	assert.Len(t, s.requests, 4)
+}
+
+func TestCompileFilter(t *testing.T) {
+	encoded, err := compileFilter("(cn=abc)")
+	assert.NoError(t, err)
+	assert.Equal(t, []byte{0xa3, 0x09, 0x04, 0x02, 'c', 'n', 0x04, 0x03, 'a', 'b', 'c'}, encoded)
+
+	encoded, err = compileFilter("(cn=*)")
+	assert.NoError(t, err)
+	assert.Equal(t, []byte{0x87, 0x02, 'c', 'n'}, encoded)
+
+	encoded, err = compileFilter("(cn=a*b*c)")
+	assert.NoError(t, err)
+	assert.Equal(t, []byte{0xa4, 0x0f, 0x04, 0x02, 'c', 'n', 0x30, 0x09, 0x80, 0x01, 'a', 0x81, 0x01, 'b', 0x82, 0x01, 'c'}, encoded)
+
+	encoded, err = compileFilter("(&(objectClass=person)(!(uid=a\\2ab)))")
+	assert.NoError(t, err)
+	p, rest, err := decodePacket(encoded)
+	assert.NoError(t, err)
+	assert.Empty(t, rest)
+	assert.Equal(t, byte(filterAnd), p.tag)
+	assert.Equal(t, byte(filterNot), p.child(1).tag)
+	assert.Equal(t, "a*b", p.child(1).child(0).child(1).str())
+
+	for _, invalid := range []string{"(cn=abc", "(&)", "cn", "(=abc)", "(cn:dn:=abc)", "(cn=a)(cn=b)", "(cn=\\zz)"} {
+		_, err = compileFilter(invalid)
+		assert.Error(t, err, invalid)
+	}
+}
+
+func TestEscapeFilter(t *testing.T) {
+	assert.Equal(t, "a\\2a\\28b\\29\\5c", EscapeFilter("a*(b)\\"))
+	encoded, err := compileFilter("(uid=" + EscapeFilter("*)(uid=*") + ")")
+	assert.NoError(t, err)
+	p, _, _ := decodePacket(encoded)
+	assert.Equal(t, byte(filterEqualityMatch), p.tag)
+	assert.Equal(t, "*)(uid=*", p.child(1).str())
+}
+
+func TestBerInt(t *testing.T) {
+	for _, v := range []int64{0, 1, 127, 128, 255, 256, 1 << 31, -1, -128, -129} {
+		p, _, err := decodePacket(berInt(tagInteger, v))
+		assert.NoError(t, err)
+		assert.Equal(t, v, p.int())
+	}
+}
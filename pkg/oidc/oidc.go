@@ -0,0 +1,472 @@
+package oidc
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	_ "crypto/sha512" // RS384/RS512/ES384需要
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// ClockSkew 校验时间时允许的时钟误差
+	ClockSkew = time.Minute
+	// keysRefreshInterval 遇到未知kid时最短的公钥刷新间隔（防止被伪造的kid刷爆）
+	keysRefreshInterval = time.Minute
+)
+
+var (
+	// ErrInvalidIDToken id_token格式或签名无效
+	ErrInvalidIDToken = errors.New("oidc: invalid id_token")
+	// ErrUnsupportedAlg 不支持的签名算法
+	ErrUnsupportedAlg = errors.New("oidc: unsupported signing algorithm")
+)
+
+// Config 身份提供方配置
+type Config struct {
+	Issuer       string
+	ClientID     string
+	ClientSecret string
+	RedirectURL  string
+	Scopes       []string // 为空时使用 openid profile
+}
+
+// Discovery 发现文档（/.well-known/openid-configuration）
+type Discovery struct {
+	Issuer                string   `json:"issuer"`
+	AuthorizationEndpoint string   `json:"authorization_endpoint"`
+	TokenEndpoint         string   `json:"token_endpoint"`
+	UserinfoEndpoint      string   `json:"userinfo_endpoint"`
+	JwksURI               string   `json:"jwks_uri"`
+	IDTokenSigningAlgs    []string `json:"id_token_signing_alg_values_supported"`
+}
+
+// Token 授权码换取的token
+type Token struct {
+	AccessToken string `json:"access_token"`
+	TokenType   string `json:"token_type"`
+	IDToken     string `json:"id_token"`
+	ExpiresIn   int64  `json:"expires_in"`
+}
+
+// Claims id_token或userinfo中的声明
+type Claims map[string]interface{}
+
+// String 获取字符串声明，支持用.访问嵌套的声明（例如 address.country）
+func (c Claims) String(name string) string {
+	if name == "" {
+		return ""
+	}
+	var value interface{} = map[string]interface{}(c)
+	for _, key := range strings.Split(name, ".") {
+		m, ok := value.(map[string]interface{})
+		if !ok {
+			return ""
+		}
+		value = m[key]
+	}
+	switch v := value.(type) {
+	case string:
+		return v
+	case float64:
+		return fmt.Sprintf("%v", v)
+	case bool:
+		return fmt.Sprintf("%v", v)
+	}
+	return ""
+}
+
+// Merge 合并声明（已有的不覆盖）
+func (c Claims) Merge(other Claims) {
+	for k, v := range other {
+		if _, ok := c[k]; !ok {
+			c[k] = v
+		}
+	}
+}
+
+// Provider 身份提供方
+type Provider struct {
+	cfg       Config
+	discovery *Discovery
+	client    *http.Client
+
+	keysLock     sync.RWMutex
+	keys         map[string]crypto.PublicKey
+	keysUpdateAt time.Time
+}
+
+// NewProvider 通过发现文档创建身份提供方
+func NewProvider(ctx context.Context, cfg Config, client *http.Client) (*Provider, error) {
+	if client == nil {
+		client = &http.Client{Timeout: time.Second * 10}
+	}
+	issuer := strings.TrimSuffix(cfg.Issuer, "/")
+	var discovery Discovery
+	if err := getJSON(ctx, client, issuer+"/.well-known/openid-configuration", "", &discovery); err != nil {
+		return nil, err
+	}
+	if strings.TrimSuffix(discovery.Issuer, "/") != issuer {
+		return nil, fmt.Errorf("oidc: issuer mismatch, expect %s got %s", issuer, discovery.Issuer)
+	}
+	if discovery.AuthorizationEndpoint == "" || discovery.TokenEndpoint == "" || discovery.JwksURI == "" {
+		return nil, errors.New("oidc: incomplete discovery document")
+	}
+	return &Provider{
+		cfg:       cfg,
+		discovery: &discovery,
+		client:    client,
+	}, nil
+}
+
+// Discovery 发现文档
+func (p *Provider) Discovery() *Discovery {
+	return p.discovery
+}
+
+// AuthCodeURL 授权地址（授权码模式 + PKCE）
+func (p *Provider) AuthCodeURL(state, nonce, codeChallenge string) string {
+	scopes := p.cfg.Scopes
+	if len(scopes) == 0 {
+		scopes = []string{"openid", "profile"}
+	}
+	params := url.Values{}
+	params.Set("response_type", "code")
+	params.Set("client_id", p.cfg.ClientID)
+	params.Set("redirect_uri", p.cfg.RedirectURL)
+	params.Set("scope", strings.Join(scopes, " "))
+	params.Set("state", state)
+	params.Set("nonce", nonce)
+	params.Set("code_challenge", codeChallenge)
+	params.Set("code_challenge_method", "S256")
+	sep := "?"
+	if strings.Contains(p.discovery.AuthorizationEndpoint, "?") {
+		sep = "&"
+	}
+	return p.discovery.AuthorizationEndpoint + sep + params.Encode()
+}
+
+// Exchange 用授权码换取token
+func (p *Provider) Exchange(ctx context.Context, code, codeVerifier string) (*Token, error) {
+	form := url.Values{}
+	form.Set("grant_type", "authorization_code")
+	form.Set("code", code)
+	form.Set("redirect_uri", p.cfg.RedirectURL)
+	form.Set("client_id", p.cfg.ClientID)
+	form.Set("code_verifier", codeVerifier)
+	if p.cfg.ClientSecret != "" {
+		form.Set("client_secret", p.cfg.ClientSecret)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, p.discovery.TokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("oidc: token endpoint returned %d: %s", resp.StatusCode, string(body))
+	}
+	var token Token
+	if err = json.Unmarshal(body, &token); err != nil {
+		return nil, err
+	}
+	if token.IDToken == "" {
+		return nil, errors.New("oidc: token response has no id_token")
+	}
+	return &token, nil
+}
+
+// UserInfo 获取用户信息
+func (p *Provider) UserInfo(ctx context.Context, accessToken string) (Claims, error) {
+	if p.discovery.UserinfoEndpoint == "" {
+		return Claims{}, nil
+	}
+	var claims Claims
+	if err := getJSON(ctx, p.client, p.discovery.UserinfoEndpoint, accessToken, &claims); err != nil {
+		return nil, err
+	}
+	return claims, nil
+}
+
+// VerifyIDToken 校验id_token的签名、签发方、接收方、有效期和nonce，返回声明
+func (p *Provider) VerifyIDToken(ctx context.Context, rawIDToken, nonce string, now time.Time) (Claims, error) {
+	parts := strings.Split(rawIDToken, ".")
+	if len(parts) != 3 {
+		return nil, ErrInvalidIDToken
+	}
+	var header struct {
+		Alg string `json:"alg"`
+		Kid string `json:"kid"`
+	}
+	if err := decodeSegment(parts[0], &header); err != nil {
+		return nil, ErrInvalidIDToken
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, ErrInvalidIDToken
+	}
+	key, err := p.publicKey(ctx, header.Kid)
+	if err != nil {
+		return nil, err
+	}
+	if err = verifySignature(header.Alg, key, []byte(parts[0]+"."+parts[1]), signature); err != nil {
+		return nil, err
+	}
+	var claims Claims
+	if err = decodeSegment(parts[1], &claims); err != nil {
+		return nil, ErrInvalidIDToken
+	}
+	if strings.TrimSuffix(claims.String("iss"), "/") != strings.TrimSuffix(p.discovery.Issuer, "/") {
+		return nil, errors.New("oidc: id_token issuer mismatch")
+	}
+	if !claims.hasAudience(p.cfg.ClientID) {
+		return nil, errors.New("oidc: id_token audience mismatch")
+	}
+	exp, ok := claims["exp"].(float64)
+	if !ok || now.After(time.Unix(int64(exp), 0).Add(ClockSkew)) {
+		return nil, errors.New("oidc: id_token expired")
+	}
+	if iat, ok := claims["iat"].(float64); ok && time.Unix(int64(iat), 0).After(now.Add(ClockSkew)) {
+		return nil, errors.New("oidc: id_token issued in the future")
+	}
+	if nonce != "" && claims.String("nonce") != nonce {
+		return nil, errors.New("oidc: id_token nonce mismatch")
+	}
+	if claims.String("sub") == "" {
+		return nil, errors.New("oidc: id_token has no subject")
+	}
+	return claims, nil
+}
+
+func (c Claims) hasAudience(clientID string) bool {
+	switch aud := c["aud"].(type) {
+	case string:
+		return aud == clientID
+	case []interface{}:
+		for _, a := range aud {
+			if s, ok := a.(string); ok && s == clientID {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// 获取签名公钥，kid不存在时重新拉取jwks（身份提供方轮换了公钥）
+func (p *Provider) publicKey(ctx context.Context, kid string) (crypto.PublicKey, error) {
+	p.keysLock.RLock()
+	key, ok := p.lookupKey(kid)
+	canRefresh := time.Since(p.keysUpdateAt) > keysRefreshInterval
+	p.keysLock.RUnlock()
+	if ok {
+		return key, nil
+	}
+	if !canRefresh {
+		return nil, ErrInvalidIDToken
+	}
+	keys, err := p.fetchKeys(ctx)
+	if err != nil {
+		return nil, err
+	}
+	p.keysLock.Lock()
+	p.keys = keys
+	p.keysUpdateAt = time.Now()
+	key, ok = p.lookupKey(kid)
+	p.keysLock.Unlock()
+	if !ok {
+		return nil, ErrInvalidIDToken
+	}
+	return key, nil
+}
+
+// kid为空且只有一个公钥时使用该公钥
+func (p *Provider) lookupKey(kid string) (crypto.PublicKey, bool) {
+	if kid == "" && len(p.keys) == 1 {
+		for _, key := range p.keys {
+			return key, true
+		}
+	}
+	key, ok := p.keys[kid]
+	return key, ok
+}
+
+func (p *Provider) fetchKeys(ctx context.Context) (map[string]crypto.PublicKey, error) {
+	var jwks struct {
+		Keys []jsonWebKey `json:"keys"`
+	}
+	if err := getJSON(ctx, p.client, p.discovery.JwksURI, "", &jwks); err != nil {
+		return nil, err
+	}
+	keys := make(map[string]crypto.PublicKey, len(jwks.Keys))
+	for _, jwk := range jwks.Keys {
+		if jwk.Use != "" && jwk.Use != "sig" {
+			continue
+		}
+		key, err := jwk.publicKey()
+		if err != nil {
+			continue // 忽略不支持的公钥类型
+		}
+		keys[jwk.Kid] = key
+	}
+	return keys, nil
+}
+
+type jsonWebKey struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+func (k jsonWebKey) publicKey() (crypto.PublicKey, error) {
+	switch k.Kty {
+	case "RSA":
+		n, err := base64.RawURLEncoding.DecodeString(k.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := base64.RawURLEncoding.DecodeString(k.E)
+		if err != nil {
+			return nil, err
+		}
+		return &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}, nil
+	case "EC":
+		var curve elliptic.Curve
+		switch k.Crv {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		default:
+			return nil, ErrUnsupportedAlg
+		}
+		x, err := base64.RawURLEncoding.DecodeString(k.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := base64.RawURLEncoding.DecodeString(k.Y)
+		if err != nil {
+			return nil, err
+		}
+		return &ecdsa.PublicKey{Curve: curve, X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}, nil
+	}
+	return nil, ErrUnsupportedAlg
+}
+
+func verifySignature(alg string, key crypto.PublicKey, signed, signature []byte) error {
+	var hash crypto.Hash
+	switch alg {
+	case "RS256", "PS256", "ES256":
+		hash = crypto.SHA256
+	case "RS384", "PS384", "ES384":
+		hash = crypto.SHA384
+	case "RS512", "PS512":
+		hash = crypto.SHA512
+	default:
+		return ErrUnsupportedAlg
+	}
+	h := hash.New()
+	h.Write(signed)
+	digest := h.Sum(nil)
+	switch alg[:2] {
+	case "RS", "PS":
+		rsaKey, ok := key.(*rsa.PublicKey)
+		if !ok {
+			return ErrInvalidIDToken
+		}
+		var err error
+		if alg[0] == 'R' {
+			err = rsa.VerifyPKCS1v15(rsaKey, hash, digest, signature)
+		} else {
+			err = rsa.VerifyPSS(rsaKey, hash, digest, signature, nil)
+		}
+		if err != nil {
+			return ErrInvalidIDToken
+		}
+	case "ES":
+		ecKey, ok := key.(*ecdsa.PublicKey)
+		if !ok {
+			return ErrInvalidIDToken
+		}
+		size := (ecKey.Curve.Params().BitSize + 7) / 8
+		if len(signature) != 2*size {
+			return ErrInvalidIDToken
+		}
+		r := new(big.Int).SetBytes(signature[:size])
+		s := new(big.Int).SetBytes(signature[size:])
+		if !ecdsa.Verify(ecKey, digest, r, s) {
+			return ErrInvalidIDToken
+		}
+	}
+	return nil
+}
+
+// NewRandom 生成随机字符串（state、nonce、code_verifier使用）
+func NewRandom() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// CodeChallenge PKCE的S256 code_challenge
+func CodeChallenge(codeVerifier string) string {
+	sum := sha256.Sum256([]byte(codeVerifier))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+func decodeSegment(seg string, v interface{}) error {
+	data, err := base64.RawURLEncoding.DecodeString(seg)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, v)
+}
+
+func getJSON(ctx context.Context, client *http.Client, u string, bearer string, v interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/json")
+	if bearer != "" {
+		req.Header.Set("Authorization", "Bearer "+bearer)
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("oidc: %s returned %d", u, resp.StatusCode)
+	}
+	return json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(v)
+}
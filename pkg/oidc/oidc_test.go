@@ -0,0 +1,140 @@
+package oidc
+
+import (
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type testIdP struct {
+	server *httptest.Server
+	key    *rsa.PrivateKey
+}
+
+func newTestIdP(t *testing.T) *testIdP {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	assert.NoError(t, err)
+	idp := &testIdP{key: key}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{
+			"issuer":                 idp.server.URL,
+			"authorization_endpoint": idp.server.URL + "/auth",
+			"token_endpoint":         idp.server.URL + "/token",
+			"userinfo_endpoint":      idp.server.URL + "/userinfo",
+			"jwks_uri":               idp.server.URL + "/jwks",
+		})
+	})
+	mux.HandleFunc("/jwks", func(w http.ResponseWriter, r *http.Request) {
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{
+			"keys": []map[string]interface{}{{
+				"kty": "RSA",
+				"kid": "k1",
+				"use": "sig",
+				"n":   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+				"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+			}},
+		})
+	})
+	idp.server = httptest.NewServer(mux)
+	t.Cleanup(idp.server.Close)
+	return idp
+}
+
+func (idp *testIdP) sign(t *testing.T, kid string, claims map[string]interface{}) string {
+	header, _ := json.Marshal(map[string]string{"alg": "RS256", "kid": kid})
+	payload, _ := json.Marshal(claims)
+	signed := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+	digest := sha256.Sum256([]byte(signed))
+	signature, err := rsa.SignPKCS1v15(rand.Reader, idp.key, crypto.SHA256, digest[:])
+	assert.NoError(t, err)
+	return signed + "." + base64.RawURLEncoding.EncodeToString(signature)
+}
+
+func TestVerifyIDToken(t *testing.T) {
+	idp := newTestIdP(t)
+	ctx := context.Background()
+	provider, err := NewProvider(ctx, Config{Issuer: idp.server.URL, ClientID: "client"}, nil)
+	assert.NoError(t, err)
+
+	now := time.Now()
+	claims := map[string]interface{}{
+		"iss":   idp.server.URL,
+		"aud":   "client",
+		"sub":   "user1",
+		"exp":   now.Add(time.Hour).Unix(),
+		"iat":   now.Unix(),
+		"nonce": "n1",
+		"name":  "Tom",
+		"address": map[string]interface{}{
+			"country": "CN",
+		},
+	}
+	result, err := provider.VerifyIDToken(ctx, idp.sign(t, "k1", claims), "n1", now)
+	assert.NoError(t, err)
+	assert.Equal(t, "user1", result.String("sub"))
+	assert.Equal(t, "Tom", result.String("name"))
+	assert.Equal(t, "CN", result.String("address.country"))
+
+	// nonce不匹配
+	_, err = provider.VerifyIDToken(ctx, idp.sign(t, "k1", claims), "n2", now)
+	assert.Error(t, err)
+
+	// 已过期
+	_, err = provider.VerifyIDToken(ctx, idp.sign(t, "k1", claims), "n1", now.Add(2*time.Hour))
+	assert.Error(t, err)
+
+	// 接收方不匹配
+	claims["aud"] = []interface{}{"other"}
+	_, err = provider.VerifyIDToken(ctx, idp.sign(t, "k1", claims), "n1", now)
+	assert.Error(t, err)
+	claims["aud"] = []interface{}{"other", "client"}
+	_, err = provider.VerifyIDToken(ctx, idp.sign(t, "k1", claims), "n1", now)
+	assert.NoError(t, err)
+
+	// 篡改内容后签名无效
+	token := idp.sign(t, "k1", claims)
+	claims["sub"] = "user2"
+	tampered := idp.sign(t, "k1", claims)
+	_, err = provider.VerifyIDToken(ctx, token[:len(token)-10]+tampered[len(tampered)-10:], "n1", now)
+	assert.Error(t, err)
+
+	// 未知的kid
+	_, err = provider.VerifyIDToken(ctx, idp.sign(t, "k2", claims), "n1", now)
+	assert.Error(t, err)
+}
+
+func TestAuthCodeURL(t *testing.T) {
+	idp := newTestIdP(t)
+	provider, err := NewProvider(context.Background(), Config{
+		Issuer:      idp.server.URL,
+		ClientID:    "client",
+		RedirectURL: "https://api.example.com/callback",
+	}, nil)
+	assert.NoError(t, err)
+
+	u, err := url.Parse(provider.AuthCodeURL("s1", "n1", CodeChallenge("verifier")))
+	assert.NoError(t, err)
+	assert.Equal(t, "/auth", u.Path)
+	assert.Equal(t, "openid profile", u.Query().Get("scope"))
+	assert.Equal(t, "S256", u.Query().Get("code_challenge_method"))
+	assert.Equal(t, CodeChallenge("verifier"), u.Query().Get("code_challenge"))
+	assert.Equal(t, "s1", u.Query().Get("state"))
+}
+
+func TestCodeChallenge(t *testing.T) {
+	// RFC 7636 附录B
+	assert.Equal(t, "E9Melhoa2OwvFrEMTJguCHaoeK1t8URWbuGJSstw-cM", CodeChallenge("dBjftJeZ4CVP-mB92K27uhbUJU1p1r_wW1gFWFOEjXk"))
+}
@@ -0,0 +1,193 @@
+package password
+
+import (
+	"crypto/md5"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// 密码哈希算法（与哈希一起存储，用于校验和判断是否需要升级）
+const (
+	AlgoMD5      = "md5"      // 旧格式 md5(md5(密码))，只用于校验
+	AlgoBcrypt   = "bcrypt"   // bcrypt
+	AlgoArgon2id = "argon2id" // argon2id
+)
+
+// DefaultAlgo 新密码使用的算法
+const DefaultAlgo = AlgoArgon2id
+
+// ErrUnsupportedAlgo 不支持的算法
+var ErrUnsupportedAlgo = errors.New("不支持的密码哈希算法")
+
+// Hasher 密码哈希算法
+type Hasher interface {
+	// Algo 算法名称
+	Algo() string
+	// Hash 生成密码哈希
+	Hash(pwd string) (string, error)
+	// Verify 校验密码是否与哈希匹配
+	Verify(pwd string, hash string) (bool, error)
+}
+
+var hashers = map[string]Hasher{
+	AlgoMD5:      md5Hasher{},
+	AlgoBcrypt:   bcryptHasher{cost: bcrypt.DefaultCost},
+	AlgoArgon2id: argon2idHasher{time: 2, memory: 19 * 1024, threads: 1, keyLen: 32, saltLen: 16},
+}
+
+// Get 获取指定算法
+func Get(algo string) (Hasher, error) {
+	hasher := hashers[algo]
+	if hasher == nil {
+		return nil, ErrUnsupportedAlgo
+	}
+	return hasher, nil
+}
+
+// Hash 使用默认算法生成密码哈希，返回哈希和算法
+func Hash(pwd string) (string, string, error) {
+	hash, err := hashers[DefaultAlgo].Hash(pwd)
+	if err != nil {
+		return "", "", err
+	}
+	return hash, DefaultAlgo, nil
+}
+
+// Verify 校验密码 algo为空时按哈希格式识别算法
+func Verify(pwd string, hash string, algo string) (bool, error) {
+	if hash == "" {
+		return false, nil
+	}
+	if algo == "" {
+		algo = Detect(hash)
+	}
+	hasher, err := Get(algo)
+	if err != nil {
+		return false, err
+	}
+	return hasher.Verify(pwd, hash)
+}
+
+// NeedsRehash 是否需要用默认算法重新生成哈希（登录成功后升级）
+func NeedsRehash(algo string) bool {
+	return algo != DefaultAlgo
+}
+
+// Detect 按哈希格式识别算法
+func Detect(hash string) string {
+	if strings.HasPrefix(hash, "$argon2id$") {
+		return AlgoArgon2id
+	}
+	if strings.HasPrefix(hash, "$2a$") || strings.HasPrefix(hash, "$2b$") || strings.HasPrefix(hash, "$2y$") {
+		return AlgoBcrypt
+	}
+	return AlgoMD5
+}
+
+type md5Hasher struct {
+}
+
+func (md5Hasher) Algo() string {
+	return AlgoMD5
+}
+
+func (h md5Hasher) Hash(pwd string) (string, error) {
+	return md5Hex(md5Hex(pwd)), nil
+}
+
+func (h md5Hasher) Verify(pwd string, hash string) (bool, error) {
+	expect, _ := h.Hash(pwd)
+	return subtle.ConstantTimeCompare([]byte(expect), []byte(hash)) == 1, nil
+}
+
+func md5Hex(str string) string {
+	sum := md5.Sum([]byte(str))
+	return hex.EncodeToString(sum[:])
+}
+
+type bcryptHasher struct {
+	cost int
+}
+
+func (bcryptHasher) Algo() string {
+	return AlgoBcrypt
+}
+
+func (h bcryptHasher) Hash(pwd string) (string, error) {
+	hash, err := bcrypt.GenerateFromPassword([]byte(pwd), h.cost)
+	if err != nil {
+		return "", err
+	}
+	return string(hash), nil
+}
+
+func (h bcryptHasher) Verify(pwd string, hash string) (bool, error) {
+	err := bcrypt.CompareHashAndPassword([]byte(hash), []byte(pwd))
+	if err == bcrypt.ErrMismatchedHashAndPassword {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// argon2id哈希格式：$argon2id$v=19$m=内存(KiB),t=迭代次数,p=并行度$盐$哈希（base64无填充）
+type argon2idHasher struct {
+	time    uint32
+	memory  uint32
+	threads uint8
+	keyLen  uint32
+	saltLen int
+}
+
+func (argon2idHasher) Algo() string {
+	return AlgoArgon2id
+}
+
+func (h argon2idHasher) Hash(pwd string) (string, error) {
+	salt := make([]byte, h.saltLen)
+	if _, err := rand.Read(salt); err != nil {
+		return "", err
+	}
+	key := argon2.IDKey([]byte(pwd), salt, h.time, h.memory, h.threads, h.keyLen)
+	return fmt.Sprintf("$argon2id$v=%d$m=%d,t=%d,p=%d$%s$%s", argon2.Version, h.memory, h.time, h.threads,
+		base64.RawStdEncoding.EncodeToString(salt), base64.RawStdEncoding.EncodeToString(key)), nil
+}
+
+func (h argon2idHasher) Verify(pwd string, hash string) (bool, error) {
+	parts := strings.Split(hash, "$")
+	if len(parts) != 6 || parts[1] != AlgoArgon2id {
+		return false, errors.New("argon2id哈希格式有误")
+	}
+	var version int
+	if _, err := fmt.Sscanf(parts[2], "v=%d", &version); err != nil {
+		return false, err
+	}
+	if version != argon2.Version {
+		return false, errors.New("不支持的argon2版本")
+	}
+	var memory, time uint32
+	var threads uint8
+	if _, err := fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &memory, &time, &threads); err != nil {
+		return false, err
+	}
+	salt, err := base64.RawStdEncoding.DecodeString(parts[4])
+	if err != nil {
+		return false, err
+	}
+	key, err := base64.RawStdEncoding.DecodeString(parts[5])
+	if err != nil {
+		return false, err
+	}
+	actual := argon2.IDKey([]byte(pwd), salt, time, memory, threads, uint32(len(key)))
+	return subtle.ConstantTimeCompare(actual, key) == 1, nil
+}
@@ -0,0 +1,48 @@
+package password
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHashAndVerify(t *testing.T) {
+	hash, algo, err := Hash("123456")
+	assert.NoError(t, err)
+	assert.Equal(t, AlgoArgon2id, algo)
+	assert.Equal(t, AlgoArgon2id, Detect(hash))
+
+	ok, err := Verify("123456", hash, algo)
+	assert.NoError(t, err)
+	assert.True(t, ok)
+	ok, err = Verify("654321", hash, algo)
+	assert.NoError(t, err)
+	assert.False(t, ok)
+	assert.False(t, NeedsRehash(algo))
+}
+
+func TestVerifyLegacy(t *testing.T) {
+	// md5(md5("123456"))
+	legacy := "14e1b600b1fd579f47433b88e8d85291"
+	ok, err := Verify("123456", legacy, AlgoMD5)
+	assert.NoError(t, err)
+	assert.True(t, ok)
+	ok, err = Verify("123456", legacy, "")
+	assert.NoError(t, err)
+	assert.True(t, ok)
+	assert.True(t, NeedsRehash(AlgoMD5))
+
+	bcryptHasher, err := Get(AlgoBcrypt)
+	assert.NoError(t, err)
+	hash, err := bcryptHasher.Hash("123456")
+	assert.NoError(t, err)
+	ok, err = Verify("123456", hash, "")
+	assert.NoError(t, err)
+	assert.True(t, ok)
+
+	ok, err = Verify("123456", "", AlgoArgon2id)
+	assert.NoError(t, err)
+	assert.False(t, ok)
+	_, err = Verify("123456", legacy, "sha1")
+	assert.Error(t, err)
+}
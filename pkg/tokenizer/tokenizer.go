@@ -0,0 +1,105 @@
+package tokenizer
+
+import (
+	"strings"
+	"unicode"
+)
+
+const (
+	// MaxTokenLen 单个词的最大长度（rune），超出部分截断
+	MaxTokenLen = 32
+)
+
+// Tokenize 对文本分词用于建立索引
+// 中日韩文字按单字和相邻双字切分，其他字母数字按连续片段切分并转为小写，返回去重后的词
+func Tokenize(text string) []string {
+	tokens := make([]string, 0)
+	seen := map[string]struct{}{}
+	add := func(token string) {
+		if _, ok := seen[token]; ok {
+			return
+		}
+		seen[token] = struct{}{}
+		tokens = append(tokens, token)
+	}
+	for _, seg := range segments(text) {
+		if !seg.cjk {
+			add(truncate(seg.runes))
+			continue
+		}
+		for i := range seg.runes {
+			add(string(seg.runes[i]))
+			if i+1 < len(seg.runes) {
+				add(string(seg.runes[i : i+2]))
+			}
+		}
+	}
+	return tokens
+}
+
+// QueryTokens 对搜索关键字分词，文档需要包含返回的所有词才算命中
+// 中日韩文字只有一个字时使用单字，否则使用相邻双字
+func QueryTokens(keyword string) []string {
+	tokens := make([]string, 0)
+	seen := map[string]struct{}{}
+	add := func(token string) {
+		if _, ok := seen[token]; ok {
+			return
+		}
+		seen[token] = struct{}{}
+		tokens = append(tokens, token)
+	}
+	for _, seg := range segments(keyword) {
+		if !seg.cjk {
+			add(truncate(seg.runes))
+			continue
+		}
+		if len(seg.runes) == 1 {
+			add(string(seg.runes))
+			continue
+		}
+		for i := 0; i+1 < len(seg.runes); i++ {
+			add(string(seg.runes[i : i+2]))
+		}
+	}
+	return tokens
+}
+
+type segment struct {
+	runes []rune
+	cjk   bool
+}
+
+// 将文本切分为连续的中日韩片段和字母数字片段，其他字符作为分隔符
+func segments(text string) []segment {
+	segs := make([]segment, 0)
+	var cur *segment
+	for _, r := range strings.ToLower(text) {
+		var cjk bool
+		if isCJK(r) {
+			cjk = true
+		} else if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			cjk = false
+		} else {
+			cur = nil
+			continue
+		}
+		if cur == nil || cur.cjk != cjk {
+			segs = append(segs, segment{cjk: cjk})
+			cur = &segs[len(segs)-1]
+		}
+		cur.runes = append(cur.runes, r)
+	}
+	return segs
+}
+
+func isCJK(r rune) bool {
+	return unicode.Is(unicode.Han, r) || unicode.Is(unicode.Hiragana, r) || unicode.Is(unicode.Katakana, r) || unicode.Is(unicode.Hangul, r)
+}
+
+func truncate(runes []rune) string {
+	if len(runes) > MaxTokenLen {
+		return string(runes[:MaxTokenLen])
+	}
+	return string(runes)
+}
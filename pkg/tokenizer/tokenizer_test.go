@@ -0,0 +1,28 @@
+package tokenizer
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTokenize(t *testing.T) {
+	assert.Equal(t, []string{"今", "今天", "天", "天开", "开", "开会", "会", "meeting", "10"}, Tokenize("今天开会 Meeting,10"))
+	assert.Equal(t, []string{"a"}, Tokenize("A a"))
+	assert.Empty(t, Tokenize("  ，。!"))
+}
+
+func TestQueryTokens(t *testing.T) {
+	assert.Equal(t, []string{"开会"}, QueryTokens("开会"))
+	assert.Equal(t, []string{"今天", "天开", "开会"}, QueryTokens("今天开会"))
+	assert.Equal(t, []string{"会", "hello"}, QueryTokens("会 Hello"))
+
+	// 查询词都应该出现在索引词中
+	indexed := map[string]bool{}
+	for _, token := range Tokenize("明天下午三点在302会议室开项目周会") {
+		indexed[token] = true
+	}
+	for _, token := range QueryTokens("302会议室") {
+		assert.True(t, indexed[token], token)
+	}
+}
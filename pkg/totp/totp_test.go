@@ -0,0 +1,58 @@
+package totp
+
+import (
+	"encoding/base32"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCode(t *testing.T) {
+	// RFC 6238 附录B的SHA1测试向量（取后6位）
+	secret := base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString([]byte("12345678901234567890"))
+	cases := map[int64]string{
+		59:         "287082",
+		1111111109: "081804",
+		1234567890: "005924",
+		2000000000: "279037",
+	}
+	for unix, expect := range cases {
+		code, err := Code(secret, Step(time.Unix(unix, 0)))
+		assert.NoError(t, err)
+		assert.Equal(t, expect, code)
+	}
+}
+
+func TestValidate(t *testing.T) {
+	secret, err := GenerateSecret()
+	assert.NoError(t, err)
+	now := time.Now()
+	code, err := Code(secret, Step(now))
+	assert.NoError(t, err)
+
+	step, ok := Validate(secret, code, now, 0)
+	assert.True(t, ok)
+	assert.Equal(t, Step(now), step)
+
+	// 已使用过的周期不能再次使用
+	_, ok = Validate(secret, code, now, step)
+	assert.False(t, ok)
+
+	// 允许一个周期的时钟误差
+	_, ok = Validate(secret, code, now.Add(Period*time.Second), 0)
+	assert.True(t, ok)
+	_, ok = Validate(secret, code, now.Add(3*Period*time.Second), 0)
+	assert.False(t, ok)
+
+	_, ok = Validate(secret, "12345", now, 0)
+	assert.False(t, ok)
+}
+
+func TestURL(t *testing.T) {
+	u := URL("唐僧叨叨", "tom", "ABC")
+	assert.True(t, strings.HasPrefix(u, "otpauth://totp/"))
+	assert.Contains(t, u, "secret=ABC")
+	assert.Contains(t, u, "period=30")
+}
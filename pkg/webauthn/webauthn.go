@@ -0,0 +1,528 @@
+// Package webauthn 服务端的WebAuthn（通行密钥）注册和登录校验（W3C Web Authentication Level 2）
+// 只请求 attestation=none，不校验认证器的证明声明（不限制认证器型号）
+package webauthn
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"strings"
+	"time"
+)
+
+// 签名算法（COSE Algorithm Identifiers）
+const (
+	AlgES256 = -7
+	AlgEdDSA = -8
+	AlgRS256 = -257
+)
+
+const (
+	// DefaultTimeout 默认的操作超时时间
+	DefaultTimeout = time.Minute * 5
+
+	challengeLength = 32
+
+	flagUserPresent    = 0x01
+	flagUserVerified   = 0x04
+	flagBackupEligible = 0x08
+	flagBackupState    = 0x10
+	flagAttestedData   = 0x40
+	flagExtensionData  = 0x80
+)
+
+var (
+	// ErrInvalidResponse 客户端返回的数据格式有误
+	ErrInvalidResponse = errors.New("webauthn: invalid response")
+	// ErrChallengeMismatch 挑战值不匹配
+	ErrChallengeMismatch = errors.New("webauthn: challenge mismatch")
+	// ErrOriginMismatch 来源不在允许的列表中
+	ErrOriginMismatch = errors.New("webauthn: origin not allowed")
+	// ErrRPIDMismatch 认证器数据里的RP ID不匹配
+	ErrRPIDMismatch = errors.New("webauthn: rp id mismatch")
+	// ErrUserNotVerified 认证器没有验证用户（指纹、PIN等）
+	ErrUserNotVerified = errors.New("webauthn: user not verified")
+	// ErrUnsupportedKey 不支持的公钥类型或签名算法
+	ErrUnsupportedKey = errors.New("webauthn: unsupported public key")
+	// ErrInvalidSignature 签名校验失败
+	ErrInvalidSignature = errors.New("webauthn: invalid signature")
+	// ErrSignCount 签名计数没有递增，凭证可能被复制
+	ErrSignCount = errors.New("webauthn: sign count did not increase")
+)
+
+// Config 依赖方（RP）配置
+type Config struct {
+	RPID    string   // 依赖方ID，一般为网站域名
+	RPName  string   // 依赖方名称
+	Origins []string // 允许的来源，例如 https://im.example.com
+	Timeout time.Duration
+}
+
+// User 注册凭证的用户
+type User struct {
+	ID          []byte
+	Name        string
+	DisplayName string
+}
+
+// CredentialDescriptor 凭证描述（用于排除已注册的凭证或指定可用的凭证）
+type CredentialDescriptor struct {
+	Type       string   `json:"type"`
+	ID         string   `json:"id"` // base64url
+	Transports []string `json:"transports,omitempty"`
+}
+
+// NewCredentialDescriptor 根据凭证ID创建描述
+func NewCredentialDescriptor(id []byte, transports []string) CredentialDescriptor {
+	return CredentialDescriptor{
+		Type:       "public-key",
+		ID:         EncodeBase64(id),
+		Transports: transports,
+	}
+}
+
+type rpEntity struct {
+	ID   string `json:"id,omitempty"`
+	Name string `json:"name"`
+}
+
+type userEntity struct {
+	ID          string `json:"id"` // base64url
+	Name        string `json:"name"`
+	DisplayName string `json:"displayName"`
+}
+
+type credentialParameter struct {
+	Type string `json:"type"`
+	Alg  int    `json:"alg"`
+}
+
+type authenticatorSelection struct {
+	ResidentKey        string `json:"residentKey"`
+	RequireResidentKey bool   `json:"requireResidentKey"`
+	UserVerification   string `json:"userVerification"`
+}
+
+// CreationOptions 注册选项（navigator.credentials.create 的 publicKey 参数，二进制字段为base64url）
+type CreationOptions struct {
+	Challenge              string                 `json:"challenge"`
+	RP                     rpEntity               `json:"rp"`
+	User                   userEntity             `json:"user"`
+	PubKeyCredParams       []credentialParameter  `json:"pubKeyCredParams"`
+	Timeout                int64                  `json:"timeout"`
+	Attestation            string                 `json:"attestation"`
+	AuthenticatorSelection authenticatorSelection `json:"authenticatorSelection"`
+	ExcludeCredentials     []CredentialDescriptor `json:"excludeCredentials"`
+}
+
+// RequestOptions 登录选项（navigator.credentials.get 的 publicKey 参数，二进制字段为base64url）
+type RequestOptions struct {
+	Challenge        string                 `json:"challenge"`
+	Timeout          int64                  `json:"timeout"`
+	RPID             string                 `json:"rpId"`
+	AllowCredentials []CredentialDescriptor `json:"allowCredentials"`
+	UserVerification string                 `json:"userVerification"`
+}
+
+// RegistrationResponse 注册返回的凭证（PublicKeyCredential 的JSON形式，二进制字段为base64url）
+type RegistrationResponse struct {
+	ID       string `json:"id"`
+	RawID    string `json:"rawId"`
+	Type     string `json:"type"`
+	Response struct {
+		ClientDataJSON    string   `json:"clientDataJSON"`
+		AttestationObject string   `json:"attestationObject"`
+		Transports        []string `json:"transports"`
+	} `json:"response"`
+}
+
+// AssertionResponse 登录返回的断言（PublicKeyCredential 的JSON形式，二进制字段为base64url）
+type AssertionResponse struct {
+	ID       string `json:"id"`
+	RawID    string `json:"rawId"`
+	Type     string `json:"type"`
+	Response struct {
+		ClientDataJSON    string `json:"clientDataJSON"`
+		AuthenticatorData string `json:"authenticatorData"`
+		Signature         string `json:"signature"`
+		UserHandle        string `json:"userHandle"`
+	} `json:"response"`
+}
+
+// CredentialID 断言使用的凭证ID
+func (r *AssertionResponse) CredentialID() ([]byte, error) {
+	id := r.RawID
+	if id == "" {
+		id = r.ID
+	}
+	return DecodeBase64(id)
+}
+
+// Credential 注册成功的凭证
+type Credential struct {
+	ID             []byte
+	PublicKey      []byte // COSE格式的公钥
+	Algorithm      int
+	SignCount      uint32
+	AAGUID         []byte
+	Transports     []string
+	BackupEligible bool // 是否可以同步到其他设备（多设备通行密钥）
+}
+
+// Assertion 登录校验的结果
+type Assertion struct {
+	SignCount   uint32
+	BackupState bool
+}
+
+type clientData struct {
+	Type      string `json:"type"`
+	Challenge string `json:"challenge"`
+	Origin    string `json:"origin"`
+}
+
+type authenticatorData struct {
+	rpIDHash  []byte
+	flags     byte
+	signCount uint32
+	aaguid    []byte
+	credID    []byte
+	publicKey []byte
+}
+
+// NewChallenge 生成随机挑战值（base64url）
+func NewChallenge() (string, error) {
+	buf := make([]byte, challengeLength)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return EncodeBase64(buf), nil
+}
+
+// EncodeBase64 base64url编码（无填充）
+func EncodeBase64(data []byte) string {
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+// DecodeBase64 base64url解码（兼容带填充的写法）
+func DecodeBase64(s string) ([]byte, error) {
+	return base64.RawURLEncoding.DecodeString(strings.TrimRight(s, "="))
+}
+
+func (c *Config) timeout() int64 {
+	if c.Timeout <= 0 {
+		return DefaultTimeout.Milliseconds()
+	}
+	return c.Timeout.Milliseconds()
+}
+
+// CreationOptions 生成注册选项（要求用户验证，优先创建可发现凭证以支持无用户名登录）
+func (c *Config) CreationOptions(challenge string, user User, exclude []CredentialDescriptor) *CreationOptions {
+	if exclude == nil {
+		exclude = make([]CredentialDescriptor, 0)
+	}
+	return &CreationOptions{
+		Challenge: challenge,
+		RP: rpEntity{
+			ID:   c.RPID,
+			Name: c.RPName,
+		},
+		User: userEntity{
+			ID:          EncodeBase64(user.ID),
+			Name:        user.Name,
+			DisplayName: user.DisplayName,
+		},
+		PubKeyCredParams: []credentialParameter{
+			{Type: "public-key", Alg: AlgES256},
+			{Type: "public-key", Alg: AlgEdDSA},
+			{Type: "public-key", Alg: AlgRS256},
+		},
+		Timeout:     c.timeout(),
+		Attestation: "none",
+		AuthenticatorSelection: authenticatorSelection{
+			ResidentKey:      "preferred",
+			UserVerification: "required",
+		},
+		ExcludeCredentials: exclude,
+	}
+}
+
+// RequestOptions 生成登录选项，allow为空时由认证器选择可发现凭证
+func (c *Config) RequestOptions(challenge string, allow []CredentialDescriptor) *RequestOptions {
+	if allow == nil {
+		allow = make([]CredentialDescriptor, 0)
+	}
+	return &RequestOptions{
+		Challenge:        challenge,
+		Timeout:          c.timeout(),
+		RPID:             c.RPID,
+		AllowCredentials: allow,
+		UserVerification: "required",
+	}
+}
+
+// VerifyRegistration 校验注册返回的凭证
+func (c *Config) VerifyRegistration(challenge string, resp *RegistrationResponse) (*Credential, error) {
+	if resp == nil || resp.Type != "public-key" {
+		return nil, ErrInvalidResponse
+	}
+	if _, err := c.verifyClientData(resp.Response.ClientDataJSON, "webauthn.create", challenge); err != nil {
+		return nil, err
+	}
+	rawAttestation, err := DecodeBase64(resp.Response.AttestationObject)
+	if err != nil {
+		return nil, ErrInvalidResponse
+	}
+	item, rest, err := decodeCBOR(rawAttestation)
+	if err != nil || len(rest) != 0 {
+		return nil, ErrInvalidResponse
+	}
+	attestation, ok := item.(map[interface{}]interface{})
+	if !ok {
+		return nil, ErrInvalidResponse
+	}
+	if _, ok = attestation["fmt"].(string); !ok {
+		return nil, ErrInvalidResponse
+	}
+	rawAuthData, ok := attestation["authData"].([]byte)
+	if !ok {
+		return nil, ErrInvalidResponse
+	}
+	authData, err := parseAuthenticatorData(rawAuthData)
+	if err != nil {
+		return nil, err
+	}
+	if err = c.verifyAuthenticatorData(authData); err != nil {
+		return nil, err
+	}
+	if authData.flags&flagAttestedData == 0 {
+		return nil, ErrInvalidResponse
+	}
+	if resp.RawID != "" {
+		rawID, err := DecodeBase64(resp.RawID)
+		if err != nil || !bytes.Equal(rawID, authData.credID) {
+			return nil, ErrInvalidResponse
+		}
+	}
+	_, alg, err := ParsePublicKey(authData.publicKey)
+	if err != nil {
+		return nil, err
+	}
+	return &Credential{
+		ID:             authData.credID,
+		PublicKey:      authData.publicKey,
+		Algorithm:      alg,
+		SignCount:      authData.signCount,
+		AAGUID:         authData.aaguid,
+		Transports:     resp.Response.Transports,
+		BackupEligible: authData.flags&flagBackupEligible != 0,
+	}, nil
+}
+
+// VerifyAssertion 使用注册时保存的公钥和签名计数校验登录断言
+func (c *Config) VerifyAssertion(challenge string, resp *AssertionResponse, publicKey []byte, signCount uint32) (*Assertion, error) {
+	if resp == nil || resp.Type != "public-key" {
+		return nil, ErrInvalidResponse
+	}
+	rawClientData, err := c.verifyClientData(resp.Response.ClientDataJSON, "webauthn.get", challenge)
+	if err != nil {
+		return nil, err
+	}
+	rawAuthData, err := DecodeBase64(resp.Response.AuthenticatorData)
+	if err != nil {
+		return nil, ErrInvalidResponse
+	}
+	authData, err := parseAuthenticatorData(rawAuthData)
+	if err != nil {
+		return nil, err
+	}
+	if err = c.verifyAuthenticatorData(authData); err != nil {
+		return nil, err
+	}
+	signature, err := DecodeBase64(resp.Response.Signature)
+	if err != nil {
+		return nil, ErrInvalidResponse
+	}
+	clientDataHash := sha256.Sum256(rawClientData)
+	signed := append(append([]byte(nil), rawAuthData...), clientDataHash[:]...)
+	if err = verifySignature(publicKey, signed, signature); err != nil {
+		return nil, err
+	}
+	// 计数都为0表示认证器不支持计数（多数同步的通行密钥）
+	if (authData.signCount != 0 || signCount != 0) && authData.signCount <= signCount {
+		return nil, ErrSignCount
+	}
+	return &Assertion{
+		SignCount:   authData.signCount,
+		BackupState: authData.flags&flagBackupState != 0,
+	}, nil
+}
+
+func (c *Config) verifyClientData(encoded string, typ string, challenge string) ([]byte, error) {
+	raw, err := DecodeBase64(encoded)
+	if err != nil {
+		return nil, ErrInvalidResponse
+	}
+	var data clientData
+	if err = json.Unmarshal(raw, &data); err != nil {
+		return nil, ErrInvalidResponse
+	}
+	if data.Type != typ {
+		return nil, ErrInvalidResponse
+	}
+	expected, err := DecodeBase64(challenge)
+	if err != nil || len(expected) == 0 {
+		return nil, ErrChallengeMismatch
+	}
+	actual, err := DecodeBase64(data.Challenge)
+	if err != nil || !bytes.Equal(expected, actual) {
+		return nil, ErrChallengeMismatch
+	}
+	allowed := false
+	for _, origin := range c.Origins {
+		if origin != "" && strings.EqualFold(strings.TrimRight(origin, "/"), data.Origin) {
+			allowed = true
+			break
+		}
+	}
+	if !allowed {
+		return nil, fmt.Errorf("%w: %s", ErrOriginMismatch, data.Origin)
+	}
+	return raw, nil
+}
+
+func (c *Config) verifyAuthenticatorData(authData *authenticatorData) error {
+	rpIDHash := sha256.Sum256([]byte(c.RPID))
+	if !bytes.Equal(rpIDHash[:], authData.rpIDHash) {
+		return ErrRPIDMismatch
+	}
+	if authData.flags&flagUserPresent == 0 || authData.flags&flagUserVerified == 0 {
+		return ErrUserNotVerified
+	}
+	return nil
+}
+
+func parseAuthenticatorData(data []byte) (*authenticatorData, error) {
+	if len(data) < 37 {
+		return nil, ErrInvalidResponse
+	}
+	authData := &authenticatorData{
+		rpIDHash:  data[:32],
+		flags:     data[32],
+		signCount: binary.BigEndian.Uint32(data[33:37]),
+	}
+	rest := data[37:]
+	if authData.flags&flagAttestedData != 0 {
+		if len(rest) < 18 {
+			return nil, ErrInvalidResponse
+		}
+		authData.aaguid = rest[:16]
+		idLength := int(binary.BigEndian.Uint16(rest[16:18]))
+		rest = rest[18:]
+		if idLength == 0 || idLength > 1023 || len(rest) < idLength {
+			return nil, ErrInvalidResponse
+		}
+		authData.credID = rest[:idLength]
+		rest = rest[idLength:]
+		_, after, err := decodeCBOR(rest)
+		if err != nil {
+			return nil, ErrInvalidResponse
+		}
+		authData.publicKey = rest[:len(rest)-len(after)]
+		rest = after
+	}
+	if authData.flags&flagExtensionData != 0 {
+		var err error
+		if _, rest, err = decodeCBOR(rest); err != nil {
+			return nil, ErrInvalidResponse
+		}
+	}
+	if len(rest) != 0 {
+		return nil, ErrInvalidResponse
+	}
+	return authData, nil
+}
+
+// ParsePublicKey 解析COSE格式的公钥，返回公钥和签名算法
+func ParsePublicKey(cose []byte) (crypto.PublicKey, int, error) {
+	item, rest, err := decodeCBOR(cose)
+	if err != nil || len(rest) != 0 {
+		return nil, 0, ErrUnsupportedKey
+	}
+	key, ok := item.(map[interface{}]interface{})
+	if !ok {
+		return nil, 0, ErrUnsupportedKey
+	}
+	kty, _ := key[int64(1)].(int64)
+	alg, _ := key[int64(3)].(int64)
+	switch {
+	case kty == 2 && alg == AlgES256:
+		crv, _ := key[int64(-1)].(int64)
+		x, _ := key[int64(-2)].([]byte)
+		y, _ := key[int64(-3)].([]byte)
+		if crv != 1 || len(x) != 32 || len(y) != 32 {
+			return nil, 0, ErrUnsupportedKey
+		}
+		pub := &ecdsa.PublicKey{Curve: elliptic.P256(), X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}
+		if !pub.Curve.IsOnCurve(pub.X, pub.Y) {
+			return nil, 0, ErrUnsupportedKey
+		}
+		return pub, AlgES256, nil
+	case kty == 1 && alg == AlgEdDSA:
+		crv, _ := key[int64(-1)].(int64)
+		x, _ := key[int64(-2)].([]byte)
+		if crv != 6 || len(x) != ed25519.PublicKeySize {
+			return nil, 0, ErrUnsupportedKey
+		}
+		return ed25519.PublicKey(x), AlgEdDSA, nil
+	case kty == 3 && alg == AlgRS256:
+		n, _ := key[int64(-1)].([]byte)
+		e, _ := key[int64(-2)].([]byte)
+		if len(n) < 256 || len(e) == 0 || len(e) > 4 {
+			return nil, 0, ErrUnsupportedKey
+		}
+		exponent := 0
+		for _, b := range e {
+			exponent = exponent<<8 | int(b)
+		}
+		return &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: exponent}, AlgRS256, nil
+	}
+	return nil, 0, ErrUnsupportedKey
+}
+
+func verifySignature(cose []byte, signed []byte, signature []byte) error {
+	pub, _, err := ParsePublicKey(cose)
+	if err != nil {
+		return err
+	}
+	switch key := pub.(type) {
+	case *ecdsa.PublicKey:
+		digest := sha256.Sum256(signed)
+		if !ecdsa.VerifyASN1(key, digest[:], signature) {
+			return ErrInvalidSignature
+		}
+	case ed25519.PublicKey:
+		if !ed25519.Verify(key, signed, signature) {
+			return ErrInvalidSignature
+		}
+	case *rsa.PublicKey:
+		digest := sha256.Sum256(signed)
+		if rsa.VerifyPKCS1v15(key, crypto.SHA256, digest[:], signature) != nil {
+			return ErrInvalidSignature
+		}
+	default:
+		return ErrUnsupportedKey
+	}
+	return nil
+}
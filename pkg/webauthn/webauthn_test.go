@@ -0,0 +1,257 @@
+package webauthn
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/json"
+	"sort"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+var testConfig = &Config{
+	RPID:    "im.example.com",
+	RPName:  "TangSengDaoDao",
+	Origins: []string{"https://im.example.com"},
+}
+
+// 测试用的CBOR编码（只支持测试需要的类型）
+func encodeCBOR(v interface{}) []byte {
+	head := func(major byte, n uint64) []byte {
+		switch {
+		case n < 24:
+			return []byte{major<<5 | byte(n)}
+		case n < 1<<8:
+			return []byte{major<<5 | 24, byte(n)}
+		case n < 1<<16:
+			buf := []byte{major<<5 | 25, 0, 0}
+			binary.BigEndian.PutUint16(buf[1:], uint16(n))
+			return buf
+		}
+		buf := []byte{major<<5 | 26, 0, 0, 0, 0}
+		binary.BigEndian.PutUint32(buf[1:], uint32(n))
+		return buf
+	}
+	switch value := v.(type) {
+	case int:
+		if value >= 0 {
+			return head(0, uint64(value))
+		}
+		return head(1, uint64(-1-value))
+	case []byte:
+		return append(head(2, uint64(len(value))), value...)
+	case string:
+		return append(head(3, uint64(len(value))), value...)
+	case map[int]interface{}:
+		keys := make([]int, 0, len(value))
+		for key := range value {
+			keys = append(keys, key)
+		}
+		sort.Ints(keys)
+		buf := head(5, uint64(len(value)))
+		for _, key := range keys {
+			buf = append(buf, encodeCBOR(key)...)
+			buf = append(buf, encodeCBOR(value[key])...)
+		}
+		return buf
+	case map[string]interface{}:
+		keys := make([]string, 0, len(value))
+		for key := range value {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		buf := head(5, uint64(len(value)))
+		for _, key := range keys {
+			buf = append(buf, encodeCBOR(key)...)
+			buf = append(buf, encodeCBOR(value[key])...)
+		}
+		return buf
+	}
+	panic("unsupported type")
+}
+
+type testAuthenticator struct {
+	credID    []byte
+	ecKey     *ecdsa.PrivateKey
+	edKey     ed25519.PrivateKey
+	signCount uint32
+}
+
+func newTestAuthenticator(t *testing.T, ed bool) *testAuthenticator {
+	a := &testAuthenticator{credID: []byte("credential-0001")}
+	var err error
+	if ed {
+		_, a.edKey, err = ed25519.GenerateKey(rand.Reader)
+	} else {
+		a.ecKey, err = ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	}
+	assert.NoError(t, err)
+	return a
+}
+
+func (a *testAuthenticator) coseKey() []byte {
+	if a.edKey != nil {
+		return encodeCBOR(map[int]interface{}{1: 1, 3: AlgEdDSA, -1: 6, -2: []byte(a.edKey.Public().(ed25519.PublicKey))})
+	}
+	x := make([]byte, 32)
+	y := make([]byte, 32)
+	a.ecKey.X.FillBytes(x)
+	a.ecKey.Y.FillBytes(y)
+	return encodeCBOR(map[int]interface{}{1: 2, 3: AlgES256, -1: 1, -2: x, -3: y})
+}
+
+func (a *testAuthenticator) authData(rpID string, flags byte, attested bool) []byte {
+	rpIDHash := sha256.Sum256([]byte(rpID))
+	data := append([]byte(nil), rpIDHash[:]...)
+	if attested {
+		flags |= flagAttestedData
+	}
+	data = append(data, flags)
+	data = binary.BigEndian.AppendUint32(data, a.signCount)
+	if attested {
+		data = append(data, make([]byte, 16)...)
+		data = binary.BigEndian.AppendUint16(data, uint16(len(a.credID)))
+		data = append(data, a.credID...)
+		data = append(data, a.coseKey()...)
+	}
+	return data
+}
+
+func clientDataJSON(typ, challenge, origin string) []byte {
+	data, _ := json.Marshal(map[string]string{"type": typ, "challenge": challenge, "origin": origin})
+	return data
+}
+
+func (a *testAuthenticator) register(challenge string, origin string) *RegistrationResponse {
+	resp := &RegistrationResponse{ID: EncodeBase64(a.credID), RawID: EncodeBase64(a.credID), Type: "public-key"}
+	resp.Response.ClientDataJSON = EncodeBase64(clientDataJSON("webauthn.create", challenge, origin))
+	resp.Response.AttestationObject = EncodeBase64(encodeCBOR(map[string]interface{}{
+		"fmt":      "none",
+		"attStmt":  map[string]interface{}{},
+		"authData": a.authData(testConfig.RPID, flagUserPresent|flagUserVerified, true),
+	}))
+	return resp
+}
+
+func (a *testAuthenticator) assert(t *testing.T, challenge string, flags byte) *AssertionResponse {
+	authData := a.authData(testConfig.RPID, flags, false)
+	clientData := clientDataJSON("webauthn.get", challenge, "https://im.example.com")
+	clientDataHash := sha256.Sum256(clientData)
+	signed := append(append([]byte(nil), authData...), clientDataHash[:]...)
+	var signature []byte
+	var err error
+	if a.edKey != nil {
+		signature = ed25519.Sign(a.edKey, signed)
+	} else {
+		digest := sha256.Sum256(signed)
+		signature, err = ecdsa.SignASN1(rand.Reader, a.ecKey, digest[:])
+		assert.NoError(t, err)
+	}
+	resp := &AssertionResponse{ID: EncodeBase64(a.credID), RawID: EncodeBase64(a.credID), Type: "public-key"}
+	resp.Response.ClientDataJSON = EncodeBase64(clientData)
+	resp.Response.AuthenticatorData = EncodeBase64(authData)
+	resp.Response.Signature = EncodeBase64(signature)
+	return resp
+}
+
+func TestRegisterAndAssert(t *testing.T) {
+	for _, ed := range []bool{false, true} {
+		authenticator := newTestAuthenticator(t, ed)
+		challenge, err := NewChallenge()
+		assert.NoError(t, err)
+
+		credential, err := testConfig.VerifyRegistration(challenge, authenticator.register(challenge, "https://im.example.com"))
+		assert.NoError(t, err)
+		assert.Equal(t, authenticator.credID, credential.ID)
+		if ed {
+			assert.Equal(t, AlgEdDSA, credential.Algorithm)
+		} else {
+			assert.Equal(t, AlgES256, credential.Algorithm)
+		}
+
+		challenge, _ = NewChallenge()
+		resp := authenticator.assert(t, challenge, flagUserPresent|flagUserVerified)
+		id, err := resp.CredentialID()
+		assert.NoError(t, err)
+		assert.Equal(t, authenticator.credID, id)
+		result, err := testConfig.VerifyAssertion(challenge, resp, credential.PublicKey, 0)
+		assert.NoError(t, err)
+		assert.Equal(t, uint32(0), result.SignCount)
+
+		// 签名计数
+		authenticator.signCount = 5
+		resp = authenticator.assert(t, challenge, flagUserPresent|flagUserVerified)
+		result, err = testConfig.VerifyAssertion(challenge, resp, credential.PublicKey, 0)
+		assert.NoError(t, err)
+		assert.Equal(t, uint32(5), result.SignCount)
+		_, err = testConfig.VerifyAssertion(challenge, resp, credential.PublicKey, 5)
+		assert.ErrorIs(t, err, ErrSignCount)
+	}
+}
+
+func TestVerifyRegistrationErrors(t *testing.T) {
+	authenticator := newTestAuthenticator(t, false)
+	challenge, _ := NewChallenge()
+	other, _ := NewChallenge()
+
+	_, err := testConfig.VerifyRegistration(other, authenticator.register(challenge, "https://im.example.com"))
+	assert.ErrorIs(t, err, ErrChallengeMismatch)
+
+	_, err = testConfig.VerifyRegistration(challenge, authenticator.register(challenge, "https://evil.example.com"))
+	assert.ErrorIs(t, err, ErrOriginMismatch)
+
+	otherRP := &Config{RPID: "example.org", Origins: testConfig.Origins}
+	_, err = otherRP.VerifyRegistration(challenge, authenticator.register(challenge, "https://im.example.com"))
+	assert.ErrorIs(t, err, ErrRPIDMismatch)
+
+	resp := authenticator.register(challenge, "https://im.example.com")
+	resp.Response.AttestationObject = resp.Response.AttestationObject[:20]
+	_, err = testConfig.VerifyRegistration(challenge, resp)
+	assert.ErrorIs(t, err, ErrInvalidResponse)
+}
+
+func TestVerifyAssertionErrors(t *testing.T) {
+	authenticator := newTestAuthenticator(t, false)
+	challenge, _ := NewChallenge()
+	credential, err := testConfig.VerifyRegistration(challenge, authenticator.register(challenge, "https://im.example.com"))
+	assert.NoError(t, err)
+
+	// 未验证用户
+	resp := authenticator.assert(t, challenge, flagUserPresent)
+	_, err = testConfig.VerifyAssertion(challenge, resp, credential.PublicKey, 0)
+	assert.ErrorIs(t, err, ErrUserNotVerified)
+
+	// 其他凭证的公钥
+	another := newTestAuthenticator(t, false)
+	resp = another.assert(t, challenge, flagUserPresent|flagUserVerified)
+	_, err = testConfig.VerifyAssertion(challenge, resp, credential.PublicKey, 0)
+	assert.ErrorIs(t, err, ErrInvalidSignature)
+
+	// 注册的数据不能用来登录
+	reg := authenticator.register(challenge, "https://im.example.com")
+	resp = authenticator.assert(t, challenge, flagUserPresent|flagUserVerified)
+	resp.Response.ClientDataJSON = reg.Response.ClientDataJSON
+	_, err = testConfig.VerifyAssertion(challenge, resp, credential.PublicKey, 0)
+	assert.ErrorIs(t, err, ErrInvalidResponse)
+}
+
+func TestDecodeCBOR(t *testing.T) {
+	value, rest, err := decodeCBOR([]byte{0xa2, 0x01, 0x02, 0x20, 0x43, 0x01, 0x02, 0x03, 0xff})
+	assert.NoError(t, err)
+	assert.Equal(t, []byte{0xff}, rest)
+	assert.Equal(t, map[interface{}]interface{}{int64(1): int64(2), int64(-1): []byte{1, 2, 3}}, value)
+
+	// 长度超出数据
+	_, _, err = decodeCBOR([]byte{0x5a, 0xff, 0xff, 0xff, 0xff})
+	assert.Error(t, err)
+	_, _, err = decodeCBOR([]byte{0x9b, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff})
+	assert.Error(t, err)
+	// 不定长编码
+	_, _, err = decodeCBOR([]byte{0x9f, 0x01, 0xff})
+	assert.Error(t, err)
+}
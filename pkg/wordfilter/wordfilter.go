@@ -0,0 +1,144 @@
+package wordfilter
+
+import (
+	"strings"
+	"unicode"
+)
+
+// Match 一次命中
+type Match struct {
+	Word  string // 命中的词（原始词）
+	Start int    // 命中在文本中的起始位置（rune下标，包含）
+	End   int    // 命中在文本中的结束位置（rune下标，不包含）
+}
+
+type node struct {
+	children map[rune]*node
+	fail     *node
+	outputs  []int // 以此节点结尾的词下标
+	depth    int
+}
+
+func newNode(depth int) *node {
+	return &node{
+		children: map[rune]*node{},
+		depth:    depth,
+	}
+}
+
+// Matcher 基于AC自动机的多模式匹配器，构建后只读，可并发使用
+type Matcher struct {
+	root  *node
+	words []string
+}
+
+// New 通过词集合构建匹配器（忽略大小写，忽略空词）
+func New(words []string) *Matcher {
+	m := &Matcher{
+		root:  newNode(0),
+		words: make([]string, 0, len(words)),
+	}
+	for _, word := range words {
+		if strings.TrimSpace(word) == "" {
+			continue
+		}
+		m.add(word)
+	}
+	m.build()
+	return m
+}
+
+func (m *Matcher) add(word string) {
+	cur := m.root
+	for _, r := range word {
+		r = unicode.ToLower(r)
+		next := cur.children[r]
+		if next == nil {
+			next = newNode(cur.depth + 1)
+			cur.children[r] = next
+		}
+		cur = next
+	}
+	cur.outputs = append(cur.outputs, len(m.words))
+	m.words = append(m.words, word)
+}
+
+// 构建失败指针
+func (m *Matcher) build() {
+	queue := make([]*node, 0, len(m.root.children))
+	for _, child := range m.root.children {
+		child.fail = m.root
+		queue = append(queue, child)
+	}
+	for len(queue) > 0 {
+		cur := queue[0]
+		queue = queue[1:]
+		for r, child := range cur.children {
+			fail := cur.fail
+			for fail != nil && fail.children[r] == nil {
+				fail = fail.fail
+			}
+			if fail == nil {
+				child.fail = m.root
+			} else {
+				child.fail = fail.children[r]
+				child.outputs = append(child.outputs, child.fail.outputs...)
+			}
+			queue = append(queue, child)
+		}
+	}
+}
+
+// Empty 是否没有任何词
+func (m *Matcher) Empty() bool {
+	return len(m.words) == 0
+}
+
+// FindAll 查找文本中所有命中的词
+func (m *Matcher) FindAll(text string) []Match {
+	if m.Empty() || text == "" {
+		return nil
+	}
+	var matches []Match
+	cur := m.root
+	i := 0
+	for _, r := range text {
+		r = unicode.ToLower(r)
+		for cur != m.root && cur.children[r] == nil {
+			cur = cur.fail
+		}
+		if next := cur.children[r]; next != nil {
+			cur = next
+		}
+		for _, idx := range cur.outputs {
+			wordLen := len([]rune(m.words[idx]))
+			matches = append(matches, Match{
+				Word:  m.words[idx],
+				Start: i + 1 - wordLen,
+				End:   i + 1,
+			})
+		}
+		i++
+	}
+	return matches
+}
+
+// Contains 文本是否包含任意一个词
+func (m *Matcher) Contains(text string) bool {
+	return len(m.FindAll(text)) > 0
+}
+
+// Replace 将文本中命中的词替换为mask字符
+func (m *Matcher) Replace(text string, mask rune) string {
+	matches := m.FindAll(text)
+	if len(matches) == 0 {
+		return text
+	}
+	runes := []rune(text)
+	for _, match := range matches {
+		for i := match.Start; i < match.End; i++ {
+			runes[i] = mask
+		}
+	}
+	return string(runes)
+}
@@ -0,0 +1,29 @@
+package wordfilter
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFindAll(t *testing.T) {
+	m := New([]string{"he", "she", "his", "hers", "代购"})
+
+	matches := m.FindAll("ushers")
+	words := make([]string, 0, len(matches))
+	for _, match := range matches {
+		words = append(words, match.Word)
+	}
+	assert.ElementsMatch(t, []string{"she", "he", "hers"}, words)
+
+	matches = m.FindAll("专业代购，欢迎咨询")
+	assert.Equal(t, 1, len(matches))
+	assert.Equal(t, Match{Word: "代购", Start: 2, End: 4}, matches[0])
+}
+
+func TestReplace(t *testing.T) {
+	m := New([]string{"微信", "QQ"})
+	assert.Equal(t, "加我**或者**", m.Replace("加我微信或者qq", '*'))
+	assert.Equal(t, "没有违禁词", m.Replace("没有违禁词", '*'))
+	assert.False(t, New(nil).Contains("微信"))
+}